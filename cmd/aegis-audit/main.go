@@ -0,0 +1,244 @@
+// Command aegis-audit checks the integrity of chained decision logs and the
+// signatures of decision records, creates the keys they are signed with,
+// and decrypts the payloads captured with them.
+//
+//	aegis-audit verify [-public-key KEY [-checkpoint-every N]] FILE...
+//	aegis-audit verify-signatures -public-key KEY[,KEY...] FILE...
+//	aegis-audit keygen
+//	aegis-audit payload-keygen
+//	aegis-audit open-payloads -private-key-file PATH FILE...
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"aegis-gateway/pkg/telemetry"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "verify":
+		os.Exit(verify(os.Args[2:]))
+	case "verify-signatures":
+		os.Exit(verifySignatures(os.Args[2:]))
+	case "keygen":
+		os.Exit(keygen())
+	case "payload-keygen":
+		os.Exit(payloadKeygen())
+	case "open-payloads":
+		os.Exit(openPayloads(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: aegis-audit verify [-public-key KEY [-checkpoint-every N]] FILE...")
+	fmt.Fprintln(os.Stderr, "       aegis-audit verify-signatures -public-key KEY[,KEY...] FILE...")
+	fmt.Fprintln(os.Stderr, "       aegis-audit keygen")
+	fmt.Fprintln(os.Stderr, "       aegis-audit payload-keygen")
+	fmt.Fprintln(os.Stderr, "       aegis-audit open-payloads -private-key-file PATH FILE...")
+	os.Exit(2)
+}
+
+// verify checks each file and prints what it found. Without a public key
+// checkpoints are checked against the chain but their signatures aren't,
+// and a file without them passes.
+func verify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	publicKey := flags.String("public-key", "", "base64 Ed25519 public key the checkpoints are signed with")
+	every := flags.Int("checkpoint-every", telemetry.DefaultCheckpointEvery, "the sink's checkpoint_every; longer runs of entries without a checkpoint fail")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		usage()
+	}
+
+	var key ed25519.PublicKey
+	if *publicKey != "" {
+		var err error
+		if key, err = telemetry.ParsePublicKey(*publicKey); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		if err := verifyFile(path, key, *every); err != nil {
+			fmt.Printf("%s: FAILED: %v\n", path, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func verifyFile(path string, key ed25519.PublicKey, every int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	report, err := telemetry.VerifyAuditLog(f, key, every)
+	if err != nil {
+		return err
+	}
+	if report.Entries == 0 {
+		return fmt.Errorf("no chained entries")
+	}
+	fmt.Printf("%s: OK: entries %d-%d, %d checkpoints", path, report.FirstSeq, report.LastSeq, report.Checkpoints)
+	if report.Checkpoints > 0 && key == nil {
+		fmt.Print(" (signatures not checked)")
+	}
+	if unsigned := report.LastSeq - report.SignedSeq; report.Checkpoints > 0 && unsigned > 0 {
+		fmt.Printf(", %d entries after the last checkpoint", unsigned)
+	}
+	if report.Unchained > 0 {
+		fmt.Printf(", %d unchained entries before the chain", report.Unchained)
+	}
+	fmt.Println()
+	return nil
+}
+
+// verifySignatures checks that every record in each file is signed by one
+// of the keys. Several keys cover files that span a key rotation.
+func verifySignatures(args []string) int {
+	flags := flag.NewFlagSet("verify-signatures", flag.ExitOnError)
+	publicKeys := flags.String("public-key", "", "comma-separated base64 Ed25519 public keys the records are signed with")
+	flags.Parse(args)
+	if flags.NArg() == 0 || *publicKeys == "" {
+		usage()
+	}
+
+	var keys []ed25519.PublicKey
+	for _, value := range strings.Split(*publicKeys, ",") {
+		key, err := telemetry.ParsePublicKey(strings.TrimSpace(value))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		keys = append(keys, key)
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		report, err := verifyRecordsFile(path, keys)
+		if err != nil {
+			fmt.Printf("%s: FAILED: %v\n", path, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: OK: %d signed records\n", path, report.Signed)
+	}
+	return status
+}
+
+func verifyRecordsFile(path string, keys []ed25519.PublicKey) (telemetry.RecordReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return telemetry.RecordReport{}, err
+	}
+	defer f.Close()
+	return telemetry.VerifyRecords(f, keys)
+}
+
+// keygen prints a new signing key for signing_key or record_signing_key
+// and its public key for verify and verify-signatures
+func keygen() int {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("signing key: %s\n", base64.StdEncoding.EncodeToString(private.Seed()))
+	fmt.Printf("public key:  %s\n", base64.StdEncoding.EncodeToString(public))
+	return 0
+}
+
+// payloadKeygen prints a new X25519 key pair: the public key for
+// payload_key and the private key for open-payloads, which should be kept
+// away from the gateway and from log readers
+func payloadKeygen() int {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("private key: %s\n", base64.StdEncoding.EncodeToString(private.Bytes()))
+	fmt.Printf("public key:  %s\n", base64.StdEncoding.EncodeToString(private.PublicKey().Bytes()))
+	return 0
+}
+
+// openPayloads prints the decrypted payload of every record in each file
+// that has one, as a JSON line with the record's decision ID
+func openPayloads(args []string) int {
+	flags := flag.NewFlagSet("open-payloads", flag.ExitOnError)
+	keyFile := flags.String("private-key-file", "", "file holding the base64 X25519 private key the payloads are encrypted to")
+	flags.Parse(args)
+	if flags.NArg() == 0 || *keyFile == "" {
+		usage()
+	}
+
+	value, err := os.ReadFile(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	key, err := telemetry.ParsePayloadPrivateKey(strings.TrimSpace(string(value)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		if err := openPayloadsFile(path, key); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: FAILED: %v\n", path, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func openPayloadsFile(path string, key *ecdh.PrivateKey) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	out := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		var record struct {
+			DecisionID string `json:"decision.id"`
+			Sealed     string `json:"payload.sealed"`
+		}
+		if len(line) == 0 || json.Unmarshal(line, &record) != nil || record.Sealed == "" {
+			continue
+		}
+		payload, err := telemetry.OpenPayload(record.Sealed, key)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		out.Encode(struct {
+			DecisionID string          `json:"decision.id"`
+			Payload    json.RawMessage `json:"payload"`
+		}{record.DecisionID, payload})
+	}
+	return scanner.Err()
+}
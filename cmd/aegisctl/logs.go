@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"aegis-gateway/pkg/telemetry"
+)
+
+// Waits before reconnecting a decision stream that broke off; the wait
+// doubles while reconnecting fails
+const (
+	tailReconnectBackoff    = time.Second
+	tailMaxReconnectBackoff = 30 * time.Second
+)
+
+// logsOptions are the flags shared by the logs commands
+type logsOptions struct {
+	gateway, token             string
+	agent, tool, session, code string
+	denyOnly, json             bool
+}
+
+// filters returns the query parameters of the decision filters
+func (o *logsOptions) filters() url.Values {
+	query := url.Values{}
+	for name, value := range map[string]string{"agent": o.agent, "tool": o.tool, "session": o.session, "code": o.code} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if o.denyOnly {
+		query.Set("result", "deny")
+	}
+	return query
+}
+
+// get sends an authenticated GET for a gateway path
+func (o *logsOptions) get(ctx context.Context, hc *http.Client, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(o.gateway, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if o.token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, apiError(resp)
+	}
+	return resp, nil
+}
+
+// print writes a decision as the gateway sent it, on one line, or as a
+// line of the form
+//
+//	2026-10-15T09:12:03Z deny finance-agent payments/create MAX_AMOUNT_EXCEEDED: Amount exceeds max_amount=5000
+func (o *logsOptions) print(w io.Writer, decision json.RawMessage) error {
+	if o.json {
+		var line bytes.Buffer
+		if err := json.Compact(&line, decision); err != nil {
+			return fmt.Errorf("invalid decision: %w", err)
+		}
+		_, err := fmt.Fprintln(w, line.String())
+		return err
+	}
+	var entry telemetry.DecisionLog
+	if err := json.Unmarshal(decision, &entry); err != nil {
+		return fmt.Errorf("invalid decision: %w", err)
+	}
+	result := "allow"
+	if entry.Decision != "true" {
+		result = "deny"
+	}
+	line := fmt.Sprintf("%s %s %s %s/%s", entry.Timestamp, result, entry.AgentID, entry.ToolName, entry.ToolAction)
+	if entry.Code != "" {
+		line += " " + entry.Code
+	}
+	if entry.Reason != "" {
+		line += ": " + entry.Reason
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+// gatewayError is an error response of the gateway
+type gatewayError struct {
+	status  int
+	message string
+}
+
+func (e *gatewayError) Error() string {
+	return fmt.Sprintf("gateway answered %d: %s", e.status, e.message)
+}
+
+// apiError reads an error response of the gateway
+func apiError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var problem struct {
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	}
+	json.Unmarshal(body, &problem)
+	e := &gatewayError{status: resp.StatusCode, message: problem.Detail}
+	if e.message == "" {
+		e.message = problem.Title
+	}
+	if e.message == "" {
+		e.message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// logsCommand groups the commands reading decisions from a gateway
+func logsCommand() *cobra.Command {
+	o := &logsOptions{}
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Read the decisions of a running gateway",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			u, err := url.Parse(o.gateway)
+			if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("--gateway must be an http or https URL")
+			}
+			return nil
+		},
+	}
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&o.gateway, "gateway", "http://localhost:8080", "URL of the gateway's admin API")
+	flags.StringVar(&o.token, "token", os.Getenv("AEGIS_ADMIN_TOKEN"), "viewer token (default $AEGIS_ADMIN_TOKEN)")
+	flags.StringVar(&o.agent, "agent", "", "only decisions for this agent")
+	flags.StringVar(&o.tool, "tool", "", "only decisions for this tool")
+	flags.StringVar(&o.session, "session", "", "only decisions in this session")
+	flags.StringVar(&o.code, "code", "", "only denials with this deny code")
+	flags.BoolVar(&o.denyOnly, "deny-only", false, "only denials")
+	flags.BoolVar(&o.json, "json", false, "print each decision as a JSON line")
+	cmd.AddCommand(logsTailCommand(o), logsQueryCommand(o))
+	return cmd
+}
+
+// logsTailCommand prints decisions as the gateway makes them, from
+// GET /v1/decisions/stream, until interrupted. A stream that breaks off is
+// reopened; decisions made in between are missed.
+func logsTailCommand(o *logsOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "tail",
+		Short: "Print decisions as they are made",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			backoff := tailReconnectBackoff
+			for {
+				connected, err := o.tail(ctx, cmd.OutOrStdout(), cmd.ErrOrStderr())
+				if ctx.Err() != nil {
+					return nil
+				}
+				var apiErr *gatewayError
+				if errors.As(err, &apiErr) && apiErr.status < http.StatusInternalServerError {
+					return err
+				}
+				if connected {
+					backoff = tailReconnectBackoff
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "aegisctl: decision stream closed (%v); reconnecting in %s\n", err, backoff)
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(backoff):
+				}
+				backoff = min(2*backoff, tailMaxReconnectBackoff)
+			}
+		},
+	}
+}
+
+// tail reads one decision stream until it ends and reports whether it was
+// opened
+func (o *logsOptions) tail(ctx context.Context, stdout, stderr io.Writer) (bool, error) {
+	resp, err := o.get(ctx, http.DefaultClient, "/v1/decisions/stream", o.filters())
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	// Server-sent events: event and data lines, ended by a blank line;
+	// lines starting with a colon are keepalives
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if err := o.event(stdout, stderr, event, data); err != nil {
+				return true, err
+			}
+			event, data = "", ""
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, io.EOF
+}
+
+// event prints a decision event and reports a dropped event on stderr
+func (o *logsOptions) event(stdout, stderr io.Writer, event, data string) error {
+	switch event {
+	case "decision":
+		return o.print(stdout, json.RawMessage(data))
+	case "dropped":
+		var dropped struct {
+			Dropped int64 `json:"dropped"`
+		}
+		json.Unmarshal([]byte(data), &dropped)
+		fmt.Fprintf(stderr, "aegisctl: %d decisions dropped because the output fell behind\n", dropped.Dropped)
+	}
+	return nil
+}
+
+// logsQueryCommand prints the stored decisions matching its filters,
+// newest first, from GET /v1/decisions, following its pages
+func logsQueryCommand(o *logsOptions) *cobra.Command {
+	var since, until string
+	var limit int
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "query",
+		Short: "Print stored decisions, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if limit < 0 {
+				return fmt.Errorf("--limit must not be negative")
+			}
+			query := o.filters()
+			if since != "" {
+				query.Set("since", since)
+			}
+			if until != "" {
+				query.Set("until", until)
+			}
+
+			hc := &http.Client{Timeout: timeout}
+			printed := 0
+			for {
+				page := telemetry.MaxDecisionPage
+				if limit > 0 {
+					page = min(page, limit-printed)
+				}
+				query.Set("limit", strconv.Itoa(page))
+
+				resp, err := o.get(cmd.Context(), hc, "/v1/decisions", query)
+				if err != nil {
+					return err
+				}
+				// Like telemetry.DecisionPage, keeping decisions as sent
+				var result struct {
+					Decisions []json.RawMessage `json:"decisions"`
+					Next      string            `json:"next"`
+				}
+				err = json.NewDecoder(resp.Body).Decode(&result)
+				resp.Body.Close()
+				if err != nil {
+					return fmt.Errorf("invalid decisions page: %w", err)
+				}
+				for _, decision := range result.Decisions {
+					if err := o.print(cmd.OutOrStdout(), decision); err != nil {
+						return err
+					}
+				}
+				printed += len(result.Decisions)
+				if result.Next == "" || limit > 0 && printed >= limit {
+					return nil
+				}
+				query.Set("cursor", result.Next)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "", "RFC 3339 time, or a duration such as 1h meaning that long ago")
+	cmd.Flags().StringVar(&until, "until", "", "RFC 3339 time, or a duration such as 10m meaning that long ago")
+	cmd.Flags().IntVar(&limit, "limit", 0, "maximum number of decisions (default all)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "how long to wait for each page")
+	return cmd
+}
@@ -0,0 +1,66 @@
+// Command aegisctl checks policy changes before they are deployed: it lints
+// a policies directory, runs test cases against it and explains the
+// decision for a single call, locally or on a running gateway. It also
+// follows and queries a running gateway's decisions.
+//
+//	aegisctl validate DIR [--config FILE] [--strict]
+//	aegisctl test DIR --cases FILE
+//	aegisctl simulate (--policies DIR | --gateway URL [--token TOKEN]) --agent ID --tool TOOL --action ACTION [--params JSON]
+//	aegisctl logs tail [--gateway URL] [--token TOKEN] [--agent ID] [--deny-only] [--json]
+//	aegisctl logs query [--gateway URL] [--token TOKEN] [--agent ID] [--deny-only] [--since 1h] [--limit N] [--json]
+//
+// Each command exits with 1 when the check fails, i.e. a policy file has
+// errors, a case isn't decided as expected or the simulated call is denied,
+// and with 2 when it can't run.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"aegis-gateway/internal/logging"
+)
+
+// exitError ends the command with a status code after it printed why
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// failed is returned by commands whose check failed
+var failed = &exitError{code: 1}
+
+func main() {
+	// The policy engine logs every file it loads
+	logging.Configure(logging.Config{Level: "warn"})
+
+	root := &cobra.Command{
+		Use:           "aegisctl",
+		Short:         "Check Aegis policies before they are deployed",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	root.AddCommand(validateCommand(), testCommand(), simulateCommand(), logsCommand())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := root.ExecuteContext(ctx)
+	stop()
+	var exit *exitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exit):
+		os.Exit(exit.code)
+	default:
+		fmt.Fprintln(os.Stderr, "aegisctl:", err)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/client"
+)
+
+// simulateCommand explains the decision for one call, against a policies
+// directory or a running gateway's POST /v1/simulate. It fails when the
+// call is denied.
+func simulateCommand() *cobra.Command {
+	var (
+		policiesDir, gateway, token string
+		params, claims              string
+		sim                         client.Simulation
+		timeout                     time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "simulate --agent ID --tool TOOL --action ACTION",
+		Short: "Explain the decision for a call without making it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (policiesDir == "") == (gateway == "") {
+				return fmt.Errorf("exactly one of --policies and --gateway is required")
+			}
+			if params != "" {
+				if err := json.Unmarshal([]byte(params), &sim.Params); err != nil {
+					return fmt.Errorf("invalid --params: %w", err)
+				}
+			}
+			if claims != "" {
+				if err := json.Unmarshal([]byte(claims), &sim.Claims); err != nil {
+					return fmt.Errorf("invalid --claims: %w", err)
+				}
+			}
+
+			var result *client.SimulationResult
+			if policiesDir != "" {
+				pe, err := policy.LoadPolicyEngine(policiesDir)
+				if err != nil {
+					return err
+				}
+				defer pe.Close()
+				result = explain(pe.Simulate(newRequest(sim)))
+			} else {
+				c, err := client.New(gateway, client.WithToken(token))
+				if err != nil {
+					return err
+				}
+				ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+				defer cancel()
+				if result, err = c.Simulate(ctx, sim); err != nil {
+					return err
+				}
+			}
+
+			out, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(out))
+			if !result.Allowed {
+				return failed
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&policiesDir, "policies", "", "policies directory to evaluate the call against")
+	cmd.Flags().StringVar(&gateway, "gateway", "", "URL of a running gateway to evaluate the call on")
+	cmd.Flags().StringVar(&token, "token", "", "bearer token for --gateway")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "how long to wait for --gateway")
+	cmd.Flags().StringVar(&sim.AgentID, "agent", "", "agent ID making the call")
+	cmd.Flags().StringVar(&sim.Tool, "tool", "", "tool called")
+	cmd.Flags().StringVar(&sim.Action, "action", "", "action called")
+	cmd.Flags().StringVar(&params, "params", "", "params of the call as a JSON object")
+	cmd.Flags().StringVar(&sim.Method, "method", "", "HTTP method of the call (POST by default)")
+	cmd.Flags().StringVar(&sim.Resource, "resource", "", "resource path of the call")
+	cmd.Flags().StringVar(&claims, "claims", "", "claims of the agent's token as a JSON object")
+	cmd.Flags().StringSliceVar(&sim.Groups, "groups", nil, "groups the agent belongs to")
+	cmd.MarkFlagRequired("agent")
+	cmd.MarkFlagRequired("tool")
+	cmd.MarkFlagRequired("action")
+	return cmd
+}
+
+// newRequest is the request the gateway evaluates for a simulated call
+func newRequest(sim client.Simulation) *policy.Request {
+	if sim.Params == nil {
+		sim.Params = make(map[string]interface{})
+	}
+	encoded, _ := json.Marshal(sim.Params)
+	return &policy.Request{
+		AgentID:  sim.AgentID,
+		Tool:     sim.Tool,
+		Action:   sim.Action,
+		Params:   sim.Params,
+		Method:   sim.Method,
+		Resource: sim.Resource,
+		BodySize: len(encoded),
+		Claims:   sim.Claims,
+		Groups:   sim.Groups,
+	}
+}
+
+// explain renders a local explanation like the gateway's /v1/simulate.
+// on_policy_error isn't applied, since it's part of the gateway config.
+func explain(ex policy.Explanation) *client.SimulationResult {
+	d := ex.Decision
+	result := &client.SimulationResult{
+		Allowed:          d.Allowed,
+		Code:             d.Code,
+		Reason:           d.Reason,
+		Rollout:          d.Rollout,
+		RetryAfter:       d.RetryAfterSeconds(),
+		FailedConditions: []client.ConditionFailure{},
+	}
+	if rule := ex.Rule; rule != nil {
+		result.Rule = &client.Rule{
+			AgentID:       rule.AgentID,
+			Group:         rule.Group,
+			Tool:          rule.Tool,
+			Actions:       rule.Actions,
+			Conditions:    rule.Conditions,
+			Rollout:       rule.Rollout,
+			PolicyVersion: rule.PolicyVersion,
+			Source:        rule.Source,
+		}
+	}
+	for _, f := range ex.FailedConditions {
+		result.FailedConditions = append(result.FailedConditions, client.ConditionFailure(f))
+	}
+	return result
+}
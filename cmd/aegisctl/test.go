@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/client"
+)
+
+// testCase is a call and the decision the policies must reach for it
+type testCase struct {
+	Name     string                 `yaml:"name"`
+	Agent    string                 `yaml:"agent"`
+	Tool     string                 `yaml:"tool"`
+	Action   string                 `yaml:"action"`
+	Params   map[string]interface{} `yaml:"params"`
+	Method   string                 `yaml:"method"`
+	Resource string                 `yaml:"resource"`
+	Claims   map[string]interface{} `yaml:"claims"`
+	Groups   []string               `yaml:"groups"`
+
+	// Expect is allow or deny; Code, if set, is the deny code expected
+	Expect string `yaml:"expect"`
+	Code   string `yaml:"code"`
+}
+
+// testCommand runs the cases of a file against a policies directory
+func testCommand() *cobra.Command {
+	var casesPath string
+	cmd := &cobra.Command{
+		Use:   "test DIR --cases FILE",
+		Short: "Check that the policies of a directory decide test cases as expected",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cases, err := readCases(casesPath)
+			if err != nil {
+				return err
+			}
+			pe, err := policy.LoadPolicyEngine(args[0])
+			if err != nil {
+				return err
+			}
+			defer pe.Close()
+
+			out := cmd.OutOrStdout()
+			passed := 0
+			for i, c := range cases {
+				name := c.Name
+				if name == "" {
+					name = fmt.Sprintf("case %d", i+1)
+				}
+				params, err := asJSON(c.Params)
+				if err != nil {
+					return fmt.Errorf("%s: invalid params: %w", name, err)
+				}
+				claims, err := asJSON(c.Claims)
+				if err != nil {
+					return fmt.Errorf("%s: invalid claims: %w", name, err)
+				}
+				ex := pe.Simulate(newRequest(client.Simulation{
+					AgentID:  c.Agent,
+					Tool:     c.Tool,
+					Action:   c.Action,
+					Params:   params,
+					Method:   c.Method,
+					Resource: c.Resource,
+					Claims:   claims,
+					Groups:   c.Groups,
+				}))
+				if mismatch := c.check(ex.Decision); mismatch != "" {
+					fmt.Fprintf(out, "FAIL %s: %s\n", name, mismatch)
+					continue
+				}
+				fmt.Fprintf(out, "PASS %s\n", name)
+				passed++
+			}
+			fmt.Fprintf(out, "%d passed, %d failed\n", passed, len(cases)-passed)
+			if passed < len(cases) {
+				return failed
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&casesPath, "cases", "", "YAML file of test cases")
+	cmd.MarkFlagRequired("cases")
+	return cmd
+}
+
+// readCases reads the cases: list of a test case file
+func readCases(path string) ([]testCase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cases: %w", err)
+	}
+	var file struct {
+		Cases []testCase `yaml:"cases"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse cases: %w", err)
+	}
+	for i, c := range file.Cases {
+		if c.Expect != "allow" && c.Expect != "deny" {
+			return nil, fmt.Errorf("case %d: expect must be allow or deny", i+1)
+		}
+		if c.Expect == "allow" && c.Code != "" {
+			return nil, fmt.Errorf("case %d: code can only be expected with deny", i+1)
+		}
+	}
+	return file.Cases, nil
+}
+
+// check returns how the decision differs from the expected one, if it does
+func (c testCase) check(d policy.Decision) string {
+	switch {
+	case c.Expect == "allow" && !d.Allowed:
+		return fmt.Sprintf("expected allow, got deny %s: %s", d.Code, d.Reason)
+	case c.Expect == "deny" && d.Allowed:
+		return "expected deny, got allow"
+	case c.Expect == "deny" && c.Code != "" && c.Code != d.Code:
+		return fmt.Sprintf("expected deny %s, got deny %s: %s", c.Code, d.Code, d.Reason)
+	}
+	return ""
+}
+
+// asJSON converts a mapping read from YAML to what the gateway decodes from
+// JSON, e.g. numbers to float64
+func asJSON(m map[string]interface{}) (map[string]interface{}, error) {
+	if m == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
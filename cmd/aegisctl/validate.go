@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+)
+
+// validateCommand lints a policies directory. Warnings are printed but only
+// errors fail it, unless --strict is set.
+func validateCommand() *cobra.Command {
+	var configPath string
+	var strict bool
+	cmd := &cobra.Command{
+		Use:   "validate DIR",
+		Short: "Check the policy files of a directory for errors and likely mistakes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var known func(string) bool
+			if configPath != "" {
+				cfg, err := config.Load(configPath)
+				if err != nil {
+					return err
+				}
+				known = func(tool string) bool {
+					_, ok := cfg.Tools[tool]
+					return ok
+				}
+			}
+
+			findings, err := policy.Lint(args[0], known)
+			if err != nil {
+				return err
+			}
+			errs, warnings := 0, 0
+			for _, f := range findings {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: %s: %s\n", f.File, f.Severity, f.Message)
+				if f.Severity == policy.LintError {
+					errs++
+				} else {
+					warnings++
+				}
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%d errors, %d warnings\n", errs, warnings)
+			if errs > 0 || strict && warnings > 0 {
+				return failed
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&configPath, "config", "", "gateway config whose tools the policies may reference")
+	cmd.Flags().BoolVar(&strict, "strict", false, "fail on warnings too")
+	return cmd
+}
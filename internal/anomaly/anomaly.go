@@ -0,0 +1,233 @@
+// Package anomaly learns each agent's usual behavior from its calls and
+// flags calls that deviate from it: a tool the agent hasn't used, a burst
+// of calls, calls at an hour it isn't active and repeated amounts close to
+// a rule's max_amount. Agents can be held after an anomaly, their calls
+// denied until an operator approves them.
+package anomaly
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/logging"
+)
+
+// logger is the anomaly component's logger
+var logger = logging.For("anomaly")
+
+// CodeApprovalRequired denies the calls of a held agent
+const CodeApprovalRequired = "APPROVAL_REQUIRED"
+
+// Call is a decided call the detector learns from
+type Call struct {
+	Time    time.Time
+	AgentID string
+	Tool    string
+	Allowed bool
+
+	// Amount is the call's amount param and MaxAmount the allowing rule's
+	// max_amount, 0 when either is missing
+	Amount    float64
+	MaxAmount float64
+}
+
+// Anomaly is a deviation from an agent's baseline flagged on a call
+type Anomaly struct {
+	Type   string
+	Reason string
+
+	// Held is set when the anomaly put the agent on hold
+	Held bool
+}
+
+// Detector keeps the baselines of the agents it has seen. A nil Detector
+// flags nothing.
+type Detector struct {
+	cfg    config.AnomalyConfig
+	detect map[string]bool
+	holds  *Holds
+
+	mu     sync.Mutex
+	agents map[string]*profile
+	swept  time.Time
+}
+
+// profile is what the detector has learned of one agent
+type profile struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+
+	// hours counts calls by hour since the Unix epoch
+	hours map[int64]int
+	// tools are when each tool was last called
+	tools map[string]time.Time
+	// nearLimit are the recent calls with amounts close to max_amount
+	nearLimit []time.Time
+	// flagged is when each anomaly type was last flagged
+	flagged map[string]time.Time
+}
+
+// New returns a detector that puts agents on hold in holds, or nil if
+// anomaly detection is disabled
+func New(cfg config.AnomalyConfig, holds *Holds) *Detector {
+	if !cfg.Enabled {
+		return nil
+	}
+	cfg = cfg.WithDefaults()
+	d := &Detector{
+		cfg:    cfg,
+		detect: make(map[string]bool),
+		holds:  holds,
+		agents: make(map[string]*profile),
+	}
+	for _, t := range cfg.Detect {
+		d.detect[t] = true
+	}
+	return d
+}
+
+// Observe learns from a call and returns the anomalies it shows. With
+// action require_approval, the first anomaly of an agent that isn't held
+// puts it on hold.
+func (d *Detector) Observe(c Call) []Anomaly {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(c.Time)
+
+	p := d.agents[c.AgentID]
+	if p == nil {
+		p = &profile{
+			firstSeen: c.Time,
+			hours:     make(map[int64]int),
+			tools:     make(map[string]time.Time),
+			flagged:   make(map[string]time.Time),
+		}
+		d.agents[c.AgentID] = p
+	}
+	p.forget(c.Time.Add(-d.cfg.Baseline))
+	hour := c.Time.Unix() / 3600
+
+	var found []Anomaly
+	learned := c.Time.Sub(p.firstSeen) >= d.cfg.Learning
+	flag := func(typ, reason string) {
+		if !learned || !d.detect[typ] {
+			return
+		}
+		if last, ok := p.flagged[typ]; ok && c.Time.Sub(last) < d.cfg.Cooldown {
+			return
+		}
+		p.flagged[typ] = c.Time
+		found = append(found, Anomaly{Type: typ, Reason: reason})
+	}
+
+	if _, ok := p.tools[c.Tool]; !ok {
+		flag(config.AnomalyNewTool, fmt.Sprintf("%s has not called %s in the last %s", c.AgentID, c.Tool, d.cfg.Baseline))
+	}
+	if !p.activeAt(c.Time.UTC().Hour(), hour) {
+		flag(config.AnomalyOffHours, fmt.Sprintf("%s has not been active at %02d:00 UTC in the last %s", c.AgentID, c.Time.UTC().Hour(), d.cfg.Baseline))
+	}
+	calls := p.hours[hour] + 1
+	average := p.average(hour, c.Time.Add(-d.cfg.Baseline))
+	if calls >= d.cfg.MinVolume && float64(calls) > d.cfg.VolumeFactor*average {
+		flag(config.AnomalyVolumeSpike, fmt.Sprintf("%s made %d calls this hour against an average of %.1f", c.AgentID, calls, average))
+	}
+	if c.Allowed && c.MaxAmount > 0 && c.Amount >= d.cfg.NearLimitRatio*c.MaxAmount {
+		if n := p.countNearLimit(c.Time, d.cfg.NearLimitWindow); n >= d.cfg.NearLimitCount {
+			flag(config.AnomalyNearLimit, fmt.Sprintf("%s made %d calls to %s within %s with amounts of at least %.0f%% of max_amount=%.0f",
+				c.AgentID, n, c.Tool, d.cfg.NearLimitWindow, d.cfg.NearLimitRatio*100, c.MaxAmount))
+		}
+	}
+
+	p.tools[c.Tool] = c.Time
+	p.hours[hour]++
+	p.lastSeen = c.Time
+
+	if len(found) > 0 && d.cfg.Action == config.AnomalyActionRequireApproval {
+		if d.holds.Hold(c.AgentID, found[0].Type, found[0].Reason, c.Time) {
+			for i := range found {
+				found[i].Held = true
+			}
+			logger.Warn("Agent held for approval", "agent_id", c.AgentID, "anomaly", found[0].Type, "reason", found[0].Reason)
+		}
+	}
+	return found
+}
+
+// sweep drops, at most once an hour, the agents not seen for longer than
+// the baseline
+func (d *Detector) sweep(now time.Time) {
+	if now.Sub(d.swept) < time.Hour {
+		return
+	}
+	d.swept = now
+	cutoff := now.Add(-d.cfg.Baseline)
+	for id, p := range d.agents {
+		if p.lastSeen.Before(cutoff) {
+			delete(d.agents, id)
+		}
+	}
+}
+
+// forget drops what the agent did before cutoff
+func (p *profile) forget(cutoff time.Time) {
+	oldest := cutoff.Unix() / 3600
+	for hour := range p.hours {
+		if hour < oldest {
+			delete(p.hours, hour)
+		}
+	}
+	for tool, last := range p.tools {
+		if last.Before(cutoff) {
+			delete(p.tools, tool)
+		}
+	}
+}
+
+// activeAt reports whether the agent made calls at the hour of day (UTC)
+// before the current hour
+func (p *profile) activeAt(hourOfDay int, current int64) bool {
+	for hour, calls := range p.hours {
+		if hour != current && calls > 0 && int(hour%24) == hourOfDay {
+			return true
+		}
+	}
+	return false
+}
+
+// average returns the agent's calls per hour before the current hour,
+// since it was first seen or since cutoff, whichever is later
+func (p *profile) average(current int64, cutoff time.Time) float64 {
+	total := 0
+	for hour, calls := range p.hours {
+		if hour != current {
+			total += calls
+		}
+	}
+	start := p.firstSeen
+	if cutoff.After(start) {
+		start = cutoff
+	}
+	hours := float64(current - start.Unix()/3600)
+	if hours < 1 {
+		hours = 1
+	}
+	return float64(total) / hours
+}
+
+// countNearLimit records a call with an amount close to max_amount and
+// returns how many fell within window
+func (p *profile) countNearLimit(now time.Time, window time.Duration) int {
+	kept := p.nearLimit[:0]
+	for _, t := range p.nearLimit {
+		if now.Sub(t) < window {
+			kept = append(kept, t)
+		}
+	}
+	p.nearLimit = append(kept, now)
+	return len(p.nearLimit)
+}
@@ -0,0 +1,129 @@
+package anomaly
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// start is when the agents of the tests are first seen
+var start = time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)
+
+// learned returns a detector that has seen finance-agent call reports
+// twice at 09:00 UTC on each of two days, so its learning period is over
+func learned(cfg config.AnomalyConfig, holds *Holds) *Detector {
+	cfg.Enabled = true
+	d := New(cfg, holds)
+	for day := 0; day < 2; day++ {
+		for i := 0; i < 2; i++ {
+			d.Observe(Call{Time: start.AddDate(0, 0, day).Add(time.Duration(i) * time.Minute), AgentID: "finance-agent", Tool: "reports", Allowed: true})
+		}
+	}
+	return d
+}
+
+// at returns a time on the day after the baseline was learned
+func at(hour, minute int) time.Time {
+	return time.Date(2026, 10, 14, hour, minute, 0, 0, time.UTC)
+}
+
+func TestObserve(t *testing.T) {
+	near := func(minute int, allowed bool) Call {
+		return Call{Time: at(9, minute), AgentID: "finance-agent", Tool: "payments", Allowed: allowed, Amount: 950, MaxAmount: 1000}
+	}
+	var burst []Call
+	for i := 0; i < 25; i++ {
+		burst = append(burst, Call{Time: at(9, 10).Add(time.Duration(i) * time.Second), AgentID: "finance-agent", Tool: "reports", Allowed: true})
+	}
+
+	tests := []struct {
+		name   string
+		detect []string
+		calls  []Call
+		want   []string
+	}{
+		{"usual call", nil, []Call{{Time: at(9, 5), AgentID: "finance-agent", Tool: "reports"}}, nil},
+		{"new tool", nil, []Call{{Time: at(9, 5), AgentID: "finance-agent", Tool: "payroll"}}, []string{config.AnomalyNewTool}},
+		{"off hours", nil, []Call{{Time: at(3, 0), AgentID: "finance-agent", Tool: "reports"}}, []string{config.AnomalyOffHours}},
+		{"type not detected", []string{config.AnomalyOffHours}, []Call{{Time: at(9, 5), AgentID: "finance-agent", Tool: "payroll"}}, nil},
+		{"new agent is learning", nil, []Call{{Time: at(3, 0), AgentID: "hr-agent", Tool: "payroll"}}, nil},
+		{"cooldown", nil, []Call{
+			{Time: at(9, 5), AgentID: "finance-agent", Tool: "payroll"},
+			{Time: at(9, 6), AgentID: "finance-agent", Tool: "invoices"},
+			{Time: at(10, 6), AgentID: "finance-agent", Tool: "refunds"},
+		}, []string{config.AnomalyNewTool, config.AnomalyNewTool, config.AnomalyOffHours}},
+		{"volume spike", []string{config.AnomalyVolumeSpike}, burst, []string{config.AnomalyVolumeSpike}},
+		{"near limit", []string{config.AnomalyNearLimit}, []Call{near(1, true), near(2, false), near(3, true), near(4, true)}, []string{config.AnomalyNearLimit}},
+		{"near limit under count", []string{config.AnomalyNearLimit}, []Call{near(1, true), near(2, false), near(3, true)}, nil},
+	}
+	for _, tt := range tests {
+		d := learned(config.AnomalyConfig{Detect: tt.detect}, nil)
+		var got []string
+		for _, c := range tt.calls {
+			for _, a := range d.Observe(c) {
+				got = append(got, a.Type)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// With require_approval the first anomaly holds the agent until approved
+func TestRequireApproval(t *testing.T) {
+	holds := NewHolds()
+	d := learned(config.AnomalyConfig{Action: config.AnomalyActionRequireApproval}, holds)
+
+	found := d.Observe(Call{Time: at(3, 0), AgentID: "finance-agent", Tool: "payroll"})
+	if len(found) != 2 || !found[0].Held || !found[1].Held {
+		t.Fatalf("got %+v, want two held anomalies", found)
+	}
+	hold, ok := holds.Held("finance-agent")
+	if !ok || hold.AnomalyType != config.AnomalyNewTool || !hold.Since.Equal(at(3, 0)) {
+		t.Errorf("got hold %+v, %v", hold, ok)
+	}
+	// Already held, so a later anomaly doesn't hold it again
+	if found := d.Observe(Call{Time: at(5, 0), AgentID: "finance-agent", Tool: "invoices"}); len(found) != 2 || found[0].Held {
+		t.Errorf("got %+v", found)
+	}
+
+	if !holds.Approve("finance-agent") || holds.Approve("finance-agent") {
+		t.Error("Approve didn't release the hold exactly once")
+	}
+	// What the agent did while held is part of its baseline
+	if found := d.Observe(Call{Time: at(5, 30), AgentID: "finance-agent", Tool: "payroll"}); len(found) != 0 {
+		t.Errorf("after approval: got %+v", found)
+	}
+}
+
+func TestHolds(t *testing.T) {
+	h := NewHolds()
+	h.Hold("hr-agent", config.AnomalyOffHours, "late", at(4, 0))
+	h.Hold("finance-agent", config.AnomalyNewTool, "payroll", at(3, 0))
+	if h.Hold("hr-agent", config.AnomalyNewTool, "again", at(5, 0)) {
+		t.Error("held agent held again")
+	}
+	var agents []string
+	for _, hold := range h.List() {
+		agents = append(agents, hold.AgentID+":"+hold.AnomalyType)
+	}
+	if want := []string{"finance-agent:new_tool", "hr-agent:off_hours"}; !reflect.DeepEqual(agents, want) {
+		t.Errorf("got %v, want %v", agents, want)
+	}
+	if _, ok := h.Held("payments-agent"); ok {
+		t.Error("agent never held is held")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	d := New(config.AnomalyConfig{}, NewHolds())
+	if d != nil {
+		t.Fatal("New returned a detector while disabled")
+	}
+	if found := d.Observe(Call{Time: at(3, 0), AgentID: "finance-agent", Tool: "payroll"}); found != nil {
+		t.Errorf("got %+v", found)
+	}
+}
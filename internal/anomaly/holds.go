@@ -0,0 +1,67 @@
+package anomaly
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Hold is an agent whose calls are denied until an operator approves it
+type Hold struct {
+	AgentID     string    `json:"agent_id"`
+	Since       time.Time `json:"since"`
+	AnomalyType string    `json:"anomaly_type"`
+	Reason      string    `json:"reason"`
+}
+
+// Holds are the agents on hold, kept in memory
+type Holds struct {
+	mu    sync.Mutex
+	holds map[string]Hold
+}
+
+// NewHolds returns an empty set of holds
+func NewHolds() *Holds {
+	return &Holds{holds: make(map[string]Hold)}
+}
+
+// Hold puts an agent on hold and reports whether it wasn't already
+func (h *Holds) Hold(agentID, anomalyType, reason string, at time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, held := h.holds[agentID]; held {
+		return false
+	}
+	h.holds[agentID] = Hold{AgentID: agentID, Since: at.UTC(), AnomalyType: anomalyType, Reason: reason}
+	return true
+}
+
+// Held returns the hold on an agent, if it is held
+func (h *Holds) Held(agentID string) (Hold, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hold, ok := h.holds[agentID]
+	return hold, ok
+}
+
+// List returns the held agents, longest held first
+func (h *Holds) List() []Hold {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	holds := make([]Hold, 0, len(h.holds))
+	for _, hold := range h.holds {
+		holds = append(holds, hold)
+	}
+	sort.Slice(holds, func(i, j int) bool { return holds[i].Since.Before(holds[j].Since) })
+	return holds
+}
+
+// Approve releases an agent's hold and reports whether it was held. What
+// the agent did to be held becomes part of its baseline.
+func (h *Holds) Approve(agentID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, held := h.holds[agentID]
+	delete(h.holds, agentID)
+	return held
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyPrefix marks gateway-issued keys so they can be told apart from JWTs
+const APIKeyPrefix = "aegis_"
+
+// lastUsedFlushInterval limits how often last-used times are written to disk
+const lastUsedFlushInterval = time.Minute
+
+// ErrInvalidAPIKey is returned for unknown, revoked or expired keys
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKey is the stored record of an issued key. Only a hash of the secret
+// is kept; the key itself is shown once, when it is minted.
+type APIKey struct {
+	ID        string     `json:"id"`
+	AgentID   string     `json:"agent_id"`
+	Name      string     `json:"name,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// Active reports whether the key may still be used at now
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// APIKeyStore issues and verifies per-agent API keys. Keys are persisted
+// to a JSON file when a path is configured, otherwise kept in memory.
+type APIKeyStore struct {
+	path string
+
+	mu        sync.Mutex
+	keys      map[string]*APIKey
+	dirty     bool
+	lastFlush time.Time
+}
+
+// NewAPIKeyStore opens the store at path, creating it on first write
+func NewAPIKeyStore(path string) (*APIKeyStore, error) {
+	s := &APIKeyStore{path: path, keys: make(map[string]*APIKey)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API key store: %w", err)
+	}
+
+	var keys []*APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse API key store: %w", err)
+	}
+	for _, k := range keys {
+		s.keys[k.ID] = k
+	}
+	return s, nil
+}
+
+// Mint issues a new key for agentID and returns the key record and the
+// plaintext key, which can't be recovered later
+func (s *APIKeyStore) Mint(agentID, name string, ttl time.Duration) (*APIKey, string, error) {
+	if agentID == "" {
+		return nil, "", fmt.Errorf("agent_id is required")
+	}
+
+	idBytes := make([]byte, 6)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, "", err
+	}
+	id := hex.EncodeToString(idBytes)
+	plaintext := APIKeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	now := time.Now().UTC()
+	key := &APIKey{
+		ID:        id,
+		AgentID:   agentID,
+		Name:      name,
+		Hash:      hashAPIKey(plaintext),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[id] = key
+	if err := s.saveLocked(); err != nil {
+		delete(s.keys, id)
+		return nil, "", err
+	}
+	copied := *key
+	copied.Hash = ""
+	return &copied, plaintext, nil
+}
+
+// Revoke disables a key. Revoked keys are kept so their history stays visible.
+func (s *APIKeyStore) Revoke(id string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown API key %s", id)
+	}
+	if key.RevokedAt == nil {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+		if err := s.saveLocked(); err != nil {
+			key.RevokedAt = nil
+			return nil, err
+		}
+	}
+	copied := *key
+	copied.Hash = ""
+	return &copied, nil
+}
+
+// List returns all key records, newest first, without their hashes
+func (s *APIKeyStore) List() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		copied := *k
+		copied.Hash = ""
+		keys = append(keys, copied)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys
+}
+
+// Verify returns the agent ID a presented key was issued to and records
+// its use
+func (s *APIKeyStore) Verify(plaintext string) (string, error) {
+	rest, ok := strings.CutPrefix(plaintext, APIKeyPrefix)
+	if !ok {
+		return "", ErrInvalidAPIKey
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	if !ok {
+		return "", ErrInvalidAPIKey
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	key, ok := s.keys[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKey(plaintext))) != 1 || !key.Active(now) {
+		return "", ErrInvalidAPIKey
+	}
+
+	key.LastUsed = &now
+	s.dirty = true
+	if now.Sub(s.lastFlush) >= lastUsedFlushInterval {
+		if err := s.saveLocked(); err != nil {
+			logger.Warn("Failed to record API key usage", "error", err)
+		}
+	}
+	return key.AgentID, nil
+}
+
+// Flush writes pending last-used updates to disk
+func (s *APIKeyStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+// saveLocked atomically rewrites the store file
+func (s *APIKeyStore) saveLocked() error {
+	s.lastFlush = time.Now()
+	if s.path == "" {
+		s.dirty = false
+		return nil
+	}
+
+	keys := make([]*APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode API key store: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to write API key store: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".api-keys-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write API key store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write API key store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write API key store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write API key store: %w", err)
+	}
+	s.dirty = false
+	return nil
+}
+
+// hashAPIKey returns the at-rest hash of a key. Keys carry 256 bits of
+// randomness, so a fast hash is sufficient.
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-keys.json")
+	s, err := NewAPIKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeRecord, active, err := s.Mint("finance-agent", "ci", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, revokedKey, _ := s.Mint("finance-agent", "old", 0)
+	if _, err := s.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, expiredKey, _ := s.Mint("finance-agent", "short", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	// Keys are verified the same way after the store is reopened
+	reopened, err := NewAPIKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"active", active, false},
+		{"revoked", revokedKey, true},
+		{"expired", expiredKey, true},
+		{"tampered secret", active[:len(active)-1] + "x", true},
+		{"other key's ID", strings.Replace(revokedKey, revoked.ID, activeRecord.ID, 1), true},
+		{"no prefix", strings.TrimPrefix(active, APIKeyPrefix), true},
+		{"empty", "", true},
+	}
+	for _, store := range []*APIKeyStore{s, reopened} {
+		for _, tt := range tests {
+			agent, err := store.Verify(tt.key)
+			if (err != nil) != tt.wantErr || (err == nil && agent != "finance-agent") {
+				t.Errorf("%s: got %q, %v; want error %v", tt.name, agent, err, tt.wantErr)
+			}
+		}
+	}
+
+	for _, k := range s.List() {
+		if k.Hash != "" {
+			t.Fatal("List returned a key hash")
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/secrets"
+)
+
+func TestHMACVerify(t *testing.T) {
+	t.Setenv("AEGIS_TEST_HMAC_SECRET", "s3cret")
+	v := NewHMACVerifier(config.HMACConfig{Secrets: map[string]string{"finance-agent": "env:AEGIS_TEST_HMAC_SECRET"}}, secrets.NewStore(config.SecretsConfig{}).Resolve)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	body := []byte(`{"amount":10}`)
+	sign := func(timestamp, nonce string) string {
+		return SignRequest("s3cret", timestamp, nonce, "POST", "/tools/payments/create", body)
+	}
+
+	tests := []struct {
+		name                    string
+		agent, timestamp, nonce string
+		signature, path         string
+		body                    []byte
+		wantErr                 bool
+	}{
+		{"valid", "finance-agent", now, "n1", sign(now, "n1"), "/tools/payments/create", body, false},
+		{"replayed nonce", "finance-agent", now, "n1", sign(now, "n1"), "/tools/payments/create", body, true},
+		{"unknown agent", "other-agent", now, "n2", sign(now, "n2"), "/tools/payments/create", body, true},
+		{"changed body", "finance-agent", now, "n3", sign(now, "n3"), "/tools/payments/create", []byte(`{"amount":10000}`), true},
+		{"changed path", "finance-agent", now, "n4", sign(now, "n4"), "/tools/payments/refund", body, true},
+		{"stale timestamp", "finance-agent", stale, "n5", sign(stale, "n5"), "/tools/payments/create", body, true},
+		{"missing nonce", "finance-agent", now, "", sign(now, ""), "/tools/payments/create", body, true},
+		{"wrong secret", "finance-agent", now, "n6", SignRequest("guess", now, "n6", "POST", "/tools/payments/create", body), "/tools/payments/create", body, true},
+	}
+	for _, tt := range tests {
+		err := v.Verify(tt.agent, tt.timestamp, tt.nonce, tt.signature, "POST", tt.path, tt.body)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// A file: secret is read on each request, so rotating the file takes effect
+// without a reload
+func TestHMACVerifyFileSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "finance-agent.secret")
+	if err := os.WriteFile(path, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v := NewHMACVerifier(config.HMACConfig{Secrets: map[string]string{"finance-agent": "file:" + path}}, secrets.NewStore(config.SecretsConfig{}).Resolve)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	body := []byte(`{"amount":10}`)
+
+	if err := v.Verify("finance-agent", now, "n1", SignRequest("first", now, "n1", "POST", "/tools/payments/create", body), "POST", "/tools/payments/create", body); err != nil {
+		t.Fatalf("signed with file secret: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("second\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify("finance-agent", now, "n2", SignRequest("first", now, "n2", "POST", "/tools/payments/create", body), "POST", "/tools/payments/create", body); err == nil {
+		t.Error("old secret accepted after rotation")
+	}
+	if err := v.Verify("finance-agent", now, "n3", SignRequest("second", now, "n3", "POST", "/tools/payments/create", body), "POST", "/tools/payments/create", body); err != nil {
+		t.Errorf("signed with rotated secret: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify("finance-agent", now, "n4", SignRequest("second", now, "n4", "POST", "/tools/payments/create", body), "POST", "/tools/payments/create", body); err == nil {
+		t.Error("accepted a request with the secret file missing")
+	}
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/logging"
+)
+
+// logger is the auth component's logger
+var logger = logging.For("auth")
+
+// Default JWKS cache lifetimes
+const (
+	DefaultJWKSRefresh = 10 * time.Minute
+
+	// minJWKSRefetch limits how often an unknown key ID triggers a fetch
+	minJWKSRefetch = 30 * time.Second
+)
+
+// jwk is a single JSON Web Key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeySet caches the signing keys published at a JWKS URL. Keys are
+// re-fetched periodically and when a token names a key ID that isn't
+// cached yet, so signing key rotation is picked up without a restart.
+type KeySet struct {
+	url     string
+	issuer  string
+	refresh time.Duration
+	client  *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewKeySet creates a key set for url, fetched lazily on first use
+func NewKeySet(url string, refresh time.Duration) *KeySet {
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	return &KeySet{
+		url:     url,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewDiscoveredKeySet creates a key set whose JWKS URL is read from the
+// issuer's OpenID Connect discovery document on first use
+func NewDiscoveredKeySet(issuer string, refresh time.Duration) *KeySet {
+	ks := NewKeySet("", refresh)
+	ks.issuer = issuer
+	return ks
+}
+
+// Key returns the public key with the given key ID
+func (ks *KeySet) Key(kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	age := time.Since(ks.fetchedAt)
+	key, ok := ks.keys[kid]
+	if ok && age < ks.refresh {
+		return key, nil
+	}
+
+	if ks.keys == nil || age >= ks.refresh || (!ok && age >= minJWKSRefetch) {
+		if err := ks.fetchLocked(); err != nil {
+			// Keep serving cached keys while the JWKS endpoint is unreachable
+			if ok {
+				logger.Warn("Failed to refresh JWKS", "url", ks.url, "error", err)
+				return key, nil
+			}
+			return nil, err
+		}
+		key, ok = ks.keys[kid]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// fetchLocked downloads and parses the key set
+func (ks *KeySet) fetchLocked() error {
+	if ks.url == "" {
+		url, err := ks.discoverLocked()
+		if err != nil {
+			return err
+		}
+		ks.url = url
+	}
+
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			logger.Warn("Skipping JWKS key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+	return nil
+}
+
+// discoverLocked reads jwks_uri from the issuer's discovery document
+func (ks *KeySet) discoverLocked() (string, error) {
+	resp, err := ks.client.Get(strings.TrimSuffix(ks.issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch OIDC discovery document: status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", fmt.Errorf("failed to parse OIDC discovery document: %w", err)
+	}
+	if doc.Issuer != ks.issuer || doc.JWKSURI == "" {
+		return "", fmt.Errorf("OIDC discovery document does not match issuer %s", ks.issuer)
+	}
+	return doc.JWKSURI, nil
+}
+
+// publicKey decodes an RSA or EC key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url-encoded big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// DefaultClockSkew is the leeway allowed on exp and nbf
+const DefaultClockSkew = 30 * time.Second
+
+// ErrNoToken is returned when a request carries no bearer token
+var ErrNoToken = errors.New("no bearer token")
+
+// Claims are the decoded claims of a verified token
+type Claims map[string]interface{}
+
+// String returns a string claim, or "" if it is missing or not a string
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim that may be a single string or a list of strings
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// JWTVerifier validates bearer tokens signed by keys from a JWKS endpoint
+type JWTVerifier struct {
+	issuer   string
+	audience string
+	keys     *KeySet
+	skew     time.Duration
+}
+
+// NewJWTVerifier creates a verifier for the configured issuer and audience
+func NewJWTVerifier(cfg config.JWTConfig) *JWTVerifier {
+	skew := cfg.ClockSkew
+	if skew <= 0 {
+		skew = DefaultClockSkew
+	}
+	return &JWTVerifier{
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		keys:     NewKeySet(cfg.JWKSURL, cfg.JWKSRefresh),
+		skew:     skew,
+	}
+}
+
+// UnverifiedIssuer returns the iss claim of a token without checking its
+// signature, so the right verifier can be picked for it
+func UnverifiedIssuer(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return ""
+	}
+	return claims.String("iss")
+}
+
+// BearerToken extracts the token from an Authorization header value
+func BearerToken(header string) (string, error) {
+	if header == "" {
+		return "", ErrNoToken
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", ErrNoToken
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// Verify checks the token's signature, issuer, audience and validity window
+// and returns its claims
+func (v *JWTVerifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+
+	key, err := v.keys.Key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := v.validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validateClaims checks the registered claims
+func (v *JWTVerifier) validateClaims(claims Claims, now time.Time) error {
+	if v.issuer != "" && claims.String("iss") != v.issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.String("iss"))
+	}
+
+	if v.audience != "" {
+		found := false
+		for _, aud := range claims.Strings("aud") {
+			if aud == v.audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("token is not intended for audience %s", v.audience)
+		}
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.skew)) {
+		return fmt.Errorf("token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.skew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+	return nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature checks an asymmetric JWS signature. Symmetric and "none"
+// algorithms are rejected, so a public key can't be used as an HMAC secret.
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	var h hash.Hash
+	var hashID crypto.Hash
+	switch alg[2:] {
+	case "256":
+		h, hashID = sha256.New(), crypto.SHA256
+	case "384":
+		h, hashID = sha512.New384(), crypto.SHA384
+	case "512":
+		h, hashID = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("signing key does not match algorithm %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hashID, digest, signature); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+	case strings.HasPrefix(alg, "PS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("signing key does not match algorithm %s", alg)
+		}
+		if err := rsa.VerifyPSS(pub, hashID, digest, signature, nil); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("signing key does not match algorithm %s", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	return nil
+}
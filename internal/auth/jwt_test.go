@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+func TestJWTVerify(t *testing.T) {
+	published, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	unpublished, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "EC", "crv": "P-256", "kid": "a", "use": "sig",
+			"x": base64.RawURLEncoding.EncodeToString(published.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(published.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	defer server.Close()
+	v := NewJWTVerifier(config.JWTConfig{JWKSURL: server.URL, Issuer: "https://idp.example.com", Audience: "aegis"})
+
+	// token signs claims with key under kid "a"; a nil key leaves it unsigned
+	encode := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	token := func(key *ecdsa.PrivateKey, alg string, claims map[string]interface{}) string {
+		input := encode(map[string]string{"alg": alg, "kid": "a", "typ": "JWT"}) + "." + encode(claims)
+		if key == nil {
+			return input + "."
+		}
+		digest := sha256.Sum256([]byte(input))
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+	now := float64(time.Now().Unix())
+	claims := map[string]interface{}{"iss": "https://idp.example.com", "aud": "aegis", "sub": "finance-agent", "exp": now + 300}
+	valid := token(published, "ES256", claims)
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", valid, false},
+		{"audience list", token(published, "ES256", map[string]interface{}{"iss": "https://idp.example.com", "aud": []string{"other", "aegis"}, "sub": "finance-agent", "exp": now + 300}), false},
+		{"wrong issuer", token(published, "ES256", map[string]interface{}{"iss": "https://evil.example.com", "aud": "aegis", "sub": "finance-agent", "exp": now + 300}), true},
+		{"wrong audience", token(published, "ES256", map[string]interface{}{"iss": "https://idp.example.com", "aud": "other", "sub": "finance-agent", "exp": now + 300}), true},
+		{"expired", token(published, "ES256", map[string]interface{}{"iss": "https://idp.example.com", "aud": "aegis", "sub": "finance-agent", "exp": now - 300}), true},
+		{"no expiry", token(published, "ES256", map[string]interface{}{"iss": "https://idp.example.com", "aud": "aegis", "sub": "finance-agent"}), true},
+		{"not valid yet", token(published, "ES256", map[string]interface{}{"iss": "https://idp.example.com", "aud": "aegis", "sub": "finance-agent", "exp": now + 300, "nbf": now + 300}), true},
+		{"signed with another key", token(unpublished, "ES256", claims), true},
+		{"alg none", token(nil, "none", claims), true},
+		{"tampered claims", parts[0] + "." + encode(map[string]interface{}{"iss": "https://idp.example.com", "aud": "aegis", "sub": "admin-agent", "exp": now + 300}) + "." + parts[2], true},
+		{"malformed", "not-a-token", true},
+	}
+	for _, tt := range tests {
+		got, err := v.Verify(tt.token)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %v, %v; want error %v", tt.name, got, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.String("sub") != "finance-agent" {
+			t.Errorf("%s: got claims %v", tt.name, got)
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"Bearer abc", "abc", false},
+		{"bearer abc", "abc", false},
+		{"Basic abc", "", true},
+		{"Bearer ", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := BearerToken(tt.header)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%q: got %q, %v", tt.header, got, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"fmt"
+
+	"aegis-gateway/internal/config"
+)
+
+// OIDCIdentity is the agent identity derived from a verified ID token
+type OIDCIdentity struct {
+	Provider string
+	AgentID  string
+	Groups   []string
+	Claims   Claims
+}
+
+// oidcProvider verifies tokens from one issuer and maps their claims
+type oidcProvider struct {
+	cfg      config.OIDCProviderConfig
+	verifier *JWTVerifier
+}
+
+// OIDCVerifier validates ID tokens from any of the configured providers
+type OIDCVerifier struct {
+	providers map[string]*oidcProvider
+}
+
+// NewOIDCVerifier creates a verifier for the configured providers. Each
+// provider's signing keys are located through OIDC discovery and cached.
+func NewOIDCVerifier(providers []config.OIDCProviderConfig) *OIDCVerifier {
+	v := &OIDCVerifier{providers: make(map[string]*oidcProvider, len(providers))}
+	for _, p := range providers {
+		skew := p.ClockSkew
+		if skew <= 0 {
+			skew = DefaultClockSkew
+		}
+		keys := NewDiscoveredKeySet(p.Issuer, p.JWKSRefresh)
+		if p.JWKSURL != "" {
+			keys = NewKeySet(p.JWKSURL, p.JWKSRefresh)
+		}
+		v.providers[p.Issuer] = &oidcProvider{
+			cfg: p,
+			verifier: &JWTVerifier{
+				issuer:   p.Issuer,
+				audience: p.ClientID,
+				keys:     keys,
+				skew:     skew,
+			},
+		}
+	}
+	return v
+}
+
+// Handles reports whether token was issued by one of the providers
+func (v *OIDCVerifier) Handles(token string) bool {
+	_, ok := v.providers[UnverifiedIssuer(token)]
+	return ok
+}
+
+// Verify validates an ID token against its issuer and maps its claims to
+// an agent ID and policy groups
+func (v *OIDCVerifier) Verify(token string) (*OIDCIdentity, error) {
+	provider, ok := v.providers[UnverifiedIssuer(token)]
+	if !ok {
+		return nil, fmt.Errorf("token issuer is not a configured OIDC provider")
+	}
+
+	claims, err := provider.verifier.Verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", provider.cfg.Name, err)
+	}
+
+	agentClaim := provider.cfg.AgentClaim
+	if agentClaim == "" {
+		agentClaim = "sub"
+	}
+	subject := claims.String(agentClaim)
+	if subject == "" {
+		return nil, fmt.Errorf("%s: token has no %s claim", provider.cfg.Name, agentClaim)
+	}
+
+	groupsClaim := provider.cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	var groups []string
+	for _, g := range claims.Strings(groupsClaim) {
+		if mapped, ok := provider.cfg.GroupMap[g]; ok {
+			g = mapped
+		}
+		groups = append(groups, g)
+	}
+
+	return &OIDCIdentity{
+		Provider: provider.cfg.Name,
+		AgentID:  provider.cfg.AgentPrefix + subject,
+		Groups:   groups,
+		Claims:   claims,
+	}, nil
+}
@@ -0,0 +1,283 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ExtAuthzConfig serves the Envoy ext_authz API on the gRPC listener, so
+// Envoy or Istio can ask for decisions while handling the data path itself
+type ExtAuthzConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// PathPrefix is stripped from the request path before it is read as
+	// :tool/:action[/resource] (default "/"). Per-route context extensions
+	// "tool" and "action" take precedence.
+	PathPrefix string `yaml:"path_prefix"`
+}
+
+// WithDefaults returns the settings with unset values defaulted
+func (e ExtAuthzConfig) WithDefaults() ExtAuthzConfig {
+	if e.PathPrefix == "" {
+		e.PathPrefix = "/"
+	}
+	return e
+}
+
+// AuthConfig controls how agent identities are established
+type AuthConfig struct {
+	MTLS    MTLSConfig           `yaml:"mtls"`
+	JWT     JWTConfig            `yaml:"jwt"`
+	APIKeys APIKeysConfig        `yaml:"api_keys"`
+	HMAC    HMACConfig           `yaml:"hmac"`
+	OIDC    []OIDCProviderConfig `yaml:"oidc"`
+
+	// AllowHeaderIdentity keeps accepting the X-Agent-ID header alone as
+	// the agent identity when an authenticator is configured, e.g. while
+	// agents move to it. Without an authenticator the header is the only
+	// identity and always accepted.
+	AllowHeaderIdentity bool `yaml:"allow_header_identity"`
+}
+
+// HeaderIdentityAllowed reports whether a request may be identified by its
+// X-Agent-ID header alone: when no authenticator is configured, or
+// auth.allow_header_identity is set
+func (c *Config) HeaderIdentityAllowed() bool {
+	if c.Auth.AllowHeaderIdentity {
+		return true
+	}
+	auth, tls := c.Auth, c.Server.TLS
+	mtls := tls.ClientCAFile != "" && tls.ClientAuth != "" && tls.ClientAuth != "none"
+	return !auth.JWT.Enabled() && !auth.APIKeys.Enabled && len(auth.HMAC.Secrets) == 0 && len(auth.OIDC) == 0 &&
+		!mtls && !c.SPIFFE.Enabled()
+}
+
+// MTLSConfig maps verified client certificates to agent IDs
+type MTLSConfig struct {
+	// Identity selects the certificate field used as the agent ID:
+	// "spiffe_id" (default), "uri_san", "dns_san" or "common_name"
+	Identity string `yaml:"identity"`
+
+	// TrimPrefix is removed from the identity, e.g. "spiffe://example.org/agent/"
+	TrimPrefix string `yaml:"trim_prefix"`
+}
+
+// JWTConfig validates bearer tokens presented by agents
+type JWTConfig struct {
+	JWKSURL  string `yaml:"jwks_url"`
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+
+	// AgentClaim names the claim holding the agent ID (default "sub")
+	AgentClaim string `yaml:"agent_claim"`
+
+	// Required rejects requests that don't carry a token
+	Required bool `yaml:"required"`
+
+	ClockSkew   time.Duration `yaml:"clock_skew"`
+	JWKSRefresh time.Duration `yaml:"jwks_refresh"`
+}
+
+// Enabled reports whether JWT authentication is configured
+func (c JWTConfig) Enabled() bool {
+	return c.JWKSURL != ""
+}
+
+// APIKeysConfig enables gateway-issued API keys for agents
+type APIKeysConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Store is the file hashed keys are kept in; keys are lost on restart
+	// when it is empty
+	Store string `yaml:"store"`
+}
+
+// HMACConfig enables signed requests with per-agent shared secrets
+type HMACConfig struct {
+	// Secrets maps agent IDs to secret references such as
+	// "env:FINANCE_AGENT_SECRET" or "vault:secret/data/agents#finance"
+	Secrets map[string]string `yaml:"secrets"`
+
+	// MaxSkew bounds the difference between the signed timestamp and now
+	MaxSkew time.Duration `yaml:"max_skew"`
+
+	// Required rejects unsigned requests from agents that have a secret
+	Required bool `yaml:"required"`
+}
+
+// OIDCProviderConfig trusts ID tokens from an OpenID Connect provider
+type OIDCProviderConfig struct {
+	Name     string `yaml:"name"`
+	Issuer   string `yaml:"issuer"`
+	ClientID string `yaml:"client_id"`
+
+	// JWKSURL overrides the jwks_uri from the discovery document
+	JWKSURL string `yaml:"jwks_url"`
+
+	// AgentClaim (default "sub") becomes the agent ID, prefixed with
+	// AgentPrefix so providers can't collide
+	AgentClaim  string `yaml:"agent_claim"`
+	AgentPrefix string `yaml:"agent_prefix"`
+
+	// GroupsClaim (default "groups") lists the caller's groups; GroupMap
+	// renames provider groups to policy group names
+	GroupsClaim string            `yaml:"groups_claim"`
+	GroupMap    map[string]string `yaml:"group_map"`
+
+	ClockSkew   time.Duration `yaml:"clock_skew"`
+	JWKSRefresh time.Duration `yaml:"jwks_refresh"`
+}
+
+// SPIFFEConfig obtains X.509 SVIDs from the SPIFFE Workload API, used both
+// to authenticate agents over mTLS and to authenticate to upstream tools
+type SPIFFEConfig struct {
+	// SocketPath is the Workload API address, e.g.
+	// "unix:///run/spire/sockets/agent.sock"
+	SocketPath string `yaml:"socket_path"`
+
+	// TrustDomain is the trust domain agents must belong to, e.g. "example.org"
+	TrustDomain string `yaml:"trust_domain"`
+}
+
+// Enabled reports whether the Workload API is configured
+func (c SPIFFEConfig) Enabled() bool {
+	return c.SocketPath != ""
+}
+
+// AdminConfig controls the admin API
+type AdminConfig struct {
+	// Token references a bearer token with the admin role, e.g.
+	// "env:AEGIS_ADMIN_TOKEN". The admin API is disabled when neither a
+	// token nor users are configured.
+	Token string `yaml:"token"`
+
+	// Users are named principals with their own tokens and roles
+	Users []AdminUser `yaml:"users,omitempty"`
+
+	// Address serves the admin API on a separate listener, e.g.
+	// "127.0.0.1:9443", instead of the agent-facing one
+	Address string `yaml:"address,omitempty"`
+
+	// CORS lets browser consoles call the admin listener. It requires
+	// Address; otherwise server.cors applies to the admin API.
+	CORS CORSConfig `yaml:"cors,omitempty"`
+}
+
+// AdminUser is an admin API principal
+type AdminUser struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	Role  string `yaml:"role"`
+}
+
+// Admin roles, each including the ones before it: viewers can read,
+// operators can also reload policies, drain tools and replay dead letters,
+// and admins can also register tools and manage API keys
+const (
+	AdminRoleViewer   = "viewer"
+	AdminRoleOperator = "operator"
+	AdminRoleAdmin    = "admin"
+)
+
+// adminRoleRank orders the admin roles
+var adminRoleRank = map[string]int{AdminRoleViewer: 1, AdminRoleOperator: 2, AdminRoleAdmin: 3}
+
+// RoleAllows reports whether role may act with the required role
+func RoleAllows(role, required string) bool {
+	return adminRoleRank[role] > 0 && adminRoleRank[role] >= adminRoleRank[required]
+}
+
+// Enabled reports whether any admin credential is configured
+func (a AdminConfig) Enabled() bool {
+	return a.ResolveToken() != "" || len(a.Users) > 0
+}
+
+// ResolveToken returns the token of admin.token, or "" if it is unset
+func (a AdminConfig) ResolveToken() string {
+	return resolveEnvRef(a.Token)
+}
+
+// Principals returns the admin users with their tokens resolved. admin.token
+// is included as user "admin" with the admin role. Users whose token
+// variable is empty are skipped.
+func (a AdminConfig) Principals() []AdminUser {
+	var users []AdminUser
+	if token := a.ResolveToken(); token != "" {
+		users = append(users, AdminUser{Name: "admin", Token: token, Role: AdminRoleAdmin})
+	}
+	for _, u := range a.Users {
+		if token := resolveEnvRef(u.Token); token != "" {
+			users = append(users, AdminUser{Name: u.Name, Token: token, Role: u.Role})
+		}
+	}
+	return users
+}
+
+// resolveEnvRef returns the value of an "env:NAME" reference
+func resolveEnvRef(ref string) string {
+	name, ok := strings.CutPrefix(ref, "env:")
+	if !ok {
+		return ""
+	}
+	return os.Getenv(name)
+}
+
+// validateAuth checks the authenticators, admin credentials and ext_authz
+// settings
+func (c *Config) validateAuth() error {
+	switch c.Auth.MTLS.Identity {
+	case "", "spiffe_id", "uri_san", "dns_san", "common_name":
+	default:
+		return fmt.Errorf("auth.mtls.identity must be spiffe_id, uri_san, dns_san or common_name")
+	}
+	if jwt := c.Auth.JWT; jwt.Enabled() {
+		if jwt.Issuer == "" || jwt.Audience == "" {
+			return fmt.Errorf("auth.jwt requires issuer and audience")
+		}
+	} else if jwt.Required {
+		return fmt.Errorf("auth.jwt.required needs jwks_url")
+	}
+	issuers := make(map[string]bool, len(c.Auth.OIDC))
+	for i, p := range c.Auth.OIDC {
+		if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+			return fmt.Errorf("auth.oidc[%d] requires name, issuer and client_id", i)
+		}
+		if issuers[p.Issuer] {
+			return fmt.Errorf("auth.oidc: issuer %s is configured twice", p.Issuer)
+		}
+		issuers[p.Issuer] = true
+	}
+	for agent, ref := range c.Auth.HMAC.Secrets {
+		if err := ValidateSecretRef(ref); err != nil {
+			return fmt.Errorf("auth.hmac.secrets.%s: %w", agent, err)
+		}
+	}
+	if c.SPIFFE.Enabled() && c.SPIFFE.TrustDomain == "" {
+		return fmt.Errorf("spiffe.trust_domain is required")
+	}
+	if c.Admin.Token != "" && !strings.HasPrefix(c.Admin.Token, "env:") {
+		return fmt.Errorf("admin.token must be a reference such as env:NAME")
+	}
+	adminNames := make(map[string]bool)
+	for i, u := range c.Admin.Users {
+		if u.Name == "" || !strings.HasPrefix(u.Token, "env:") {
+			return fmt.Errorf("admin.users[%d] requires a name and an env: token reference", i)
+		}
+		if adminRoleRank[u.Role] == 0 {
+			return fmt.Errorf("admin.users[%d]: role must be viewer, operator or admin", i)
+		}
+		if adminNames[u.Name] {
+			return fmt.Errorf("admin.users: %s is configured twice", u.Name)
+		}
+		adminNames[u.Name] = true
+	}
+	if c.ExtAuthz.Enabled && c.Server.GRPCAddress == "" {
+		return fmt.Errorf("ext_authz requires server.grpc_address")
+	}
+	if c.ExtAuthz.PathPrefix != "" && !strings.HasPrefix(c.ExtAuthz.PathPrefix, "/") {
+		return fmt.Errorf("ext_authz.path_prefix must start with /")
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/logging"
+	"aegis-gateway/internal/redact"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// Config is the gateway configuration loaded from config.yaml
+type Config struct {
+	Server      ServerConfig          `yaml:"server"`
+	Auth        AuthConfig            `yaml:"auth"`
+	SPIFFE      SPIFFEConfig          `yaml:"spiffe"`
+	Admin       AdminConfig           `yaml:"admin"`
+	Policies    PoliciesConfig        `yaml:"policies"`
+	State       StateConfig           `yaml:"state"`
+	Secrets     SecretsConfig         `yaml:"secrets"`
+	Redaction   RedactionConfig       `yaml:"redaction"`
+	Uploads     UploadsConfig         `yaml:"uploads"`
+	Idempotency IdempotencyConfig     `yaml:"idempotency"`
+	Jobs        JobsConfig            `yaml:"jobs"`
+	Egress      EgressConfig          `yaml:"egress"`
+	Plugins     []PluginConfig        `yaml:"plugins"`
+	DeadLetter  DeadLetterConfig      `yaml:"dead_letter"`
+	ExtAuthz    ExtAuthzConfig        `yaml:"ext_authz"`
+	Notify      NotifyConfig          `yaml:"notifications"`
+	Reports     []ReportConfig        `yaml:"reports"`
+	Anomaly     AnomalyConfig         `yaml:"anomaly"`
+	Tripwire    TripwireConfig        `yaml:"tripwire"`
+	Quarantine  QuarantineConfig      `yaml:"quarantine"`
+	Telemetry   telemetry.Config      `yaml:"telemetry"`
+	Logging     logging.Config        `yaml:"logging"`
+	Tools       map[string]ToolConfig `yaml:"tools"`
+
+	// Path is the file the config was loaded from, if any
+	Path string `yaml:"-"`
+}
+
+// RedactionConfig defines detectors for the response redact obligation in
+// addition to the built-in email, credit_card and api_key
+type RedactionConfig struct {
+	Detectors map[string]RedactionDetector `yaml:"detectors"`
+}
+
+// RedactionDetector is a regular expression whose matches are replaced with
+// Mask, by default "[REDACTED:<name>]"
+type RedactionDetector struct {
+	Pattern string `yaml:"pattern"`
+	Mask    string `yaml:"mask,omitempty"`
+}
+
+// Custom returns the detectors in the form the redaction engine takes
+func (r RedactionConfig) Custom() map[string]redact.Custom {
+	custom := make(map[string]redact.Custom, len(r.Detectors))
+	for name, d := range r.Detectors {
+		custom[name] = redact.Custom{Pattern: d.Pattern, Mask: d.Mask}
+	}
+	return custom
+}
+
+// ServerConfig controls the gateway listener
+type ServerConfig struct {
+	Address string    `yaml:"address"`
+	TLS     TLSConfig `yaml:"tls"`
+
+	// GRPCAddress serves the gRPC API when set, e.g. ":9090"
+	GRPCAddress string `yaml:"grpc_address,omitempty"`
+
+	// MetricsAddress serves the health and readiness endpoints and /metrics
+	// on a listener of their own, so probes and scrapers never need the
+	// agent port; /metrics is then no longer served on Address
+	MetricsAddress string `yaml:"metrics_address,omitempty"`
+
+	// CORS lets browser-based agent frontends call the gateway directly
+	CORS CORSConfig `yaml:"cors,omitempty"`
+
+	// MaxRequestBytes caps the request bodies the gateway reads, which
+	// happens before the agent is authenticated (default 10 MiB). Uploads
+	// have their own limits.
+	MaxRequestBytes int64 `yaml:"max_request_bytes,omitempty"`
+}
+
+// DefaultMaxRequestBytes applies when server.max_request_bytes is unset
+const DefaultMaxRequestBytes = 10 << 20
+
+// RequestBodyLimit returns MaxRequestBytes, or its default when unset
+func (s ServerConfig) RequestBodyLimit() int64 {
+	if s.MaxRequestBytes == 0 {
+		return DefaultMaxRequestBytes
+	}
+	return s.MaxRequestBytes
+}
+
+// Defaults for CORS settings that are left unset. The headers cover what
+// agents send and read on the gateway API.
+var (
+	DefaultCORSMethods        = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	DefaultCORSHeaders        = []string{"Authorization", "Content-Type", "Idempotency-Key", "X-Agent-ID", "X-Agent-Session-ID", "X-Aegis-Callback-URL", "Mcp-Session-Id", "Mcp-Protocol-Version", "Last-Event-ID", "traceparent", "tracestate", "baggage"}
+	DefaultCORSExposedHeaders = []string{"X-Aegis-Decision-ID", "X-Aegis-Job-ID", "X-Aegis-Cache", "X-Aegis-Response-Modified", "X-Aegis-Classification", "X-Agent-Session-ID", "Retry-After", "Mcp-Session-Id"}
+)
+
+// CORSConfig controls cross-origin requests to a listener. CORS is off
+// unless AllowedOrigins is set.
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as "https://console.example.com".
+	// "*" allows any origin and "https://*.example.com" any subdomain.
+	AllowedOrigins []string `yaml:"allowed_origins,omitempty"`
+
+	AllowedMethods []string `yaml:"allowed_methods,omitempty"`
+	AllowedHeaders []string `yaml:"allowed_headers,omitempty"`
+	ExposedHeaders []string `yaml:"exposed_headers,omitempty"`
+
+	// AllowCredentials lets browsers send cookies and client certificates.
+	// It can't be combined with the "*" origin.
+	AllowCredentials bool `yaml:"allow_credentials,omitempty"`
+
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration `yaml:"max_age,omitempty"`
+}
+
+// Enabled reports whether any origin is allowed
+func (c CORSConfig) Enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+// WithDefaults returns the settings with unset values defaulted
+func (c CORSConfig) WithDefaults() CORSConfig {
+	if len(c.AllowedMethods) == 0 {
+		c.AllowedMethods = DefaultCORSMethods
+	}
+	if len(c.AllowedHeaders) == 0 {
+		c.AllowedHeaders = DefaultCORSHeaders
+	}
+	if len(c.ExposedHeaders) == 0 {
+		c.ExposedHeaders = DefaultCORSExposedHeaders
+	}
+	return c
+}
+
+// AllowsOrigin reports whether a request from origin may be served
+func (c CORSConfig) AllowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok {
+			host, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(host, "."+strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UnixSocketPath returns the socket path of a "unix:/path/to.sock" listener
+// address
+func UnixSocketPath(address string) (string, bool) {
+	return strings.CutPrefix(address, "unix:")
+}
+
+// TLSConfig enables TLS on the listener when both files are set
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// MinVersion is "1.2" (default) or "1.3"
+	MinVersion string `yaml:"min_version"`
+
+	// CipherSuites restricts TLS 1.2 cipher suites by Go name, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. TLS 1.3 suites are fixed.
+	CipherSuites []string `yaml:"cipher_suites"`
+
+	// ReloadInterval re-reads the certificate files when they change, so
+	// rotated certificates are picked up without a restart. Zero disables it.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+
+	// ClientCAFile enables client certificate verification against this CA
+	// bundle. ClientAuth is "request" (verify if presented) or "require".
+	ClientCAFile string `yaml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth"`
+}
+
+// MinTLSVersion returns the configured minimum TLS version
+func (t TLSConfig) MinTLSVersion() (uint16, error) {
+	switch t.MinVersion {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS min_version %q (use 1.2 or 1.3)", t.MinVersion)
+	}
+}
+
+// CipherSuiteIDs resolves the configured cipher suite names. Only suites Go
+// considers secure are accepted. A nil result means Go's defaults.
+func (t TLSConfig) CipherSuiteIDs() ([]uint16, error) {
+	if len(t.CipherSuites) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported or insecure cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Enabled reports whether TLS is configured
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// PoliciesConfig locates the policy files
+type PoliciesConfig struct {
+	Dir string `yaml:"dir"`
+
+	// StateDir is where the engine keeps what it must remember across
+	// restarts, such as the baselines of rules under rollout. It must be
+	// writable and outside Dir, which is often mounted read-only.
+	StateDir string `yaml:"state_dir,omitempty"`
+
+	// OnPolicyError is what happens when the engine can't reach a decision,
+	// e.g. no policies are loaded: deny (default), allow or degrade. Tools
+	// can override it.
+	OnPolicyError string `yaml:"on_policy_error,omitempty"`
+}
+
+// on_policy_error behaviors. Degrade only lets read-only calls through: GET
+// and HEAD requests and the actions listed in the tool's cache.actions.
+const (
+	PolicyErrorDeny    = "deny"
+	PolicyErrorAllow   = "allow"
+	PolicyErrorDegrade = "degrade"
+)
+
+// validPolicyErrorModes lists the accepted on_policy_error values
+var validPolicyErrorModes = map[string]bool{"": true, PolicyErrorDeny: true, PolicyErrorAllow: true, PolicyErrorDegrade: true}
+
+// Default returns the configuration used when no file is given
+func Default() *Config {
+	return &Config{
+		Server:   ServerConfig{Address: ":8080"},
+		Policies: PoliciesConfig{Dir: "./policies", StateDir: "./data/policies"},
+		Telemetry: telemetry.Config{
+			ServiceName:  "aegis-gateway",
+			LogDir:       "./logs",
+			OTLPEndpoint: "localhost:4318",
+			OTLPInsecure: true,
+		},
+		Tools: map[string]ToolConfig{
+			"payments": {URL: "http://localhost:8081", Timeout: DefaultToolTimeout},
+			"files":    {Protocol: "files", Files: FilesToolConfig{Root: "./data/files"}, Timeout: DefaultToolTimeout},
+		},
+	}
+}
+
+// validateListeners checks that every configured listener has its own
+// address, so admin and health traffic can't end up on the agent port
+func (c *Config) validateListeners() error {
+	listeners := []struct{ key, address string }{
+		{"server.address", c.Server.Address},
+		{"server.grpc_address", c.Server.GRPCAddress},
+		{"server.metrics_address", c.Server.MetricsAddress},
+		{"admin.address", c.Admin.Address},
+	}
+	seen := make(map[string]string)
+	for _, l := range listeners {
+		if l.address == "" {
+			continue
+		}
+		if path, ok := UnixSocketPath(l.address); ok && path == "" {
+			return fmt.Errorf("%s: unix socket path is required", l.key)
+		}
+		if other, ok := seen[l.address]; ok {
+			return fmt.Errorf("%s must differ from %s", l.key, other)
+		}
+		seen[l.address] = l.key
+	}
+	return nil
+}
+
+// validateCORS checks the CORS settings of each listener
+func (c *Config) validateCORS() error {
+	if c.Admin.CORS.Enabled() && c.Admin.Address == "" {
+		return fmt.Errorf("admin.cors requires admin.address")
+	}
+	listeners := []struct {
+		key  string
+		cors CORSConfig
+	}{
+		{"server.cors", c.Server.CORS},
+		{"admin.cors", c.Admin.CORS},
+	}
+	for _, l := range listeners {
+		for _, origin := range l.cors.AllowedOrigins {
+			if origin == "*" {
+				if l.cors.AllowCredentials {
+					return fmt.Errorf("%s: allow_credentials can't be used with origin *", l.key)
+				}
+				continue
+			}
+			u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+			if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+				return fmt.Errorf("%s: invalid origin %q", l.key, origin)
+			}
+		}
+		for _, method := range l.cors.AllowedMethods {
+			if !validMethods[strings.ToUpper(method)] && !strings.EqualFold(method, "OPTIONS") {
+				return fmt.Errorf("%s: invalid method %q", l.key, method)
+			}
+		}
+		if l.cors.MaxAge < 0 {
+			return fmt.Errorf("%s: max_age must not be negative", l.key)
+		}
+	}
+	return nil
+}
+
+// Validate checks the configuration for missing or malformed values
+func (c *Config) Validate() error {
+	if c.Server.Address == "" {
+		return fmt.Errorf("server.address is required")
+	}
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		return fmt.Errorf("server.tls requires both cert_file and key_file")
+	}
+	if _, err := c.Server.TLS.MinTLSVersion(); err != nil {
+		return fmt.Errorf("server.tls: %w", err)
+	}
+	if _, err := c.Server.TLS.CipherSuiteIDs(); err != nil {
+		return fmt.Errorf("server.tls: %w", err)
+	}
+	if c.Server.TLS.ReloadInterval < 0 {
+		return fmt.Errorf("server.tls.reload_interval must not be negative")
+	}
+	if err := c.Logging.Validate(); err != nil {
+		return err
+	}
+	switch c.Server.TLS.ClientAuth {
+	case "", "none":
+	case "request", "require":
+		if c.Server.TLS.ClientCAFile == "" {
+			return fmt.Errorf("server.tls.client_auth requires client_ca_file")
+		}
+	default:
+		return fmt.Errorf("server.tls.client_auth must be none, request or require")
+	}
+	if c.Server.MaxRequestBytes < 0 {
+		return fmt.Errorf("server.max_request_bytes must not be negative")
+	}
+	if c.Policies.Dir == "" {
+		return fmt.Errorf("policies.dir is required")
+	}
+	if c.Policies.StateDir != "" {
+		if rel, err := filepath.Rel(filepath.Clean(c.Policies.Dir), filepath.Clean(c.Policies.StateDir)); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return fmt.Errorf("policies.state_dir must be outside policies.dir")
+		}
+	}
+	if _, err := redact.New(c.Redaction.Custom()); err != nil {
+		return fmt.Errorf("redaction: %w", err)
+	}
+	if !validPolicyErrorModes[c.Policies.OnPolicyError] {
+		return fmt.Errorf("policies.on_policy_error must be deny, allow or degrade")
+	}
+	if err := c.validateListeners(); err != nil {
+		return err
+	}
+	if err := c.validateCORS(); err != nil {
+		return err
+	}
+	if c.Secrets.RefreshInterval < 0 {
+		return fmt.Errorf("secrets.refresh_interval must not be negative")
+	}
+	if err := c.validateAuth(); err != nil {
+		return err
+	}
+	if err := c.validateLimits(); err != nil {
+		return err
+	}
+	if err := c.validateTelemetry(); err != nil {
+		return err
+	}
+	return c.validateTools()
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Upload limits applied when uploads leaves them unset
+const (
+	DefaultMaxUploadFileBytes  = 32 << 20
+	DefaultMaxUploadTotalBytes = 64 << 20
+	DefaultMaxUploadFiles      = 10
+	DefaultMaxUploadFieldBytes = 1 << 20
+)
+
+// UploadsConfig limits multipart/form-data requests. File parts are spooled
+// to TempDir (default the system temp dir) rather than held in memory.
+type UploadsConfig struct {
+	MaxFileBytes  int64  `yaml:"max_file_bytes"`
+	MaxTotalBytes int64  `yaml:"max_total_bytes"`
+	MaxFiles      int    `yaml:"max_files"`
+	MaxFieldBytes int64  `yaml:"max_field_bytes"`
+	TempDir       string `yaml:"temp_dir"`
+
+	// Scan sends every uploaded file to a malware scanner before the call
+	// is evaluated
+	Scan UploadScanConfig `yaml:"scan,omitempty"`
+}
+
+// WithDefaults returns the limits with unset values defaulted
+func (u UploadsConfig) WithDefaults() UploadsConfig {
+	if u.MaxFileBytes == 0 {
+		u.MaxFileBytes = DefaultMaxUploadFileBytes
+	}
+	if u.MaxTotalBytes == 0 {
+		u.MaxTotalBytes = DefaultMaxUploadTotalBytes
+	}
+	if u.MaxFiles == 0 {
+		u.MaxFiles = DefaultMaxUploadFiles
+	}
+	if u.MaxFieldBytes == 0 {
+		u.MaxFieldBytes = DefaultMaxUploadFieldBytes
+	}
+	return u
+}
+
+// Malware scanner types
+const (
+	ScannerClamAV = "clamav"
+	ScannerICAP   = "icap"
+)
+
+// DefaultScanTimeout bounds the scan of one file when no timeout is set
+const DefaultScanTimeout = 30 * time.Second
+
+// UploadScanConfig connects to the malware scanner uploads are checked
+// with. Whether an infected file blocks the call or is forwarded tagged is
+// up to the policy's malware condition.
+type UploadScanConfig struct {
+	// Type is clamav or icap
+	Type string `yaml:"type,omitempty"`
+
+	// Address is clamd's socket, tcp://host:3310 or unix:///path, or the
+	// ICAP service URL, icap://host:1344/service
+	Address string `yaml:"address,omitempty"`
+
+	// Timeout bounds the scan of each file (default 30s)
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// FailOpen forwards uploads unscanned when the scanner is unreachable;
+	// by default they are rejected
+	FailOpen bool `yaml:"fail_open,omitempty"`
+}
+
+// Enabled reports whether uploads are scanned
+func (c UploadScanConfig) Enabled() bool {
+	return c.Type != ""
+}
+
+// validate checks the scanner type and address
+func (c UploadScanConfig) validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil || c.Address == "" {
+		return fmt.Errorf("uploads.scan.address must be a URL")
+	}
+	switch c.Type {
+	case ScannerClamAV:
+		tcp := u.Scheme == "tcp" && u.Port() != ""
+		unix := u.Scheme == "unix" && strings.HasPrefix(u.Path, "/")
+		if !tcp && !unix {
+			return fmt.Errorf("uploads.scan.address must be tcp://host:port or unix:///path for clamav")
+		}
+	case ScannerICAP:
+		if u.Scheme != "icap" || u.Host == "" {
+			return fmt.Errorf("uploads.scan.address must be icap://host[:port]/service for icap")
+		}
+	default:
+		return fmt.Errorf("uploads.scan.type must be clamav or icap")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("uploads.scan.timeout must not be negative")
+	}
+	return nil
+}
+
+// Idempotency defaults
+const (
+	DefaultIdempotencyTTL          = 24 * time.Hour
+	DefaultIdempotencyMaxBodyBytes = 1 << 20
+	DefaultIdempotencyMaxEntries   = 10000
+)
+
+// IdempotencyConfig controls how long responses to requests carrying an
+// Idempotency-Key are remembered. Larger responses are not kept, only that
+// the call was forwarded. MaxEntries bounds the keys remembered, dropping
+// the oldest finished ones.
+type IdempotencyConfig struct {
+	TTL          time.Duration `yaml:"ttl"`
+	MaxBodyBytes int           `yaml:"max_body_bytes"`
+	MaxEntries   int           `yaml:"max_entries"`
+}
+
+// WithDefaults returns the settings with unset values defaulted
+func (c IdempotencyConfig) WithDefaults() IdempotencyConfig {
+	if c.TTL == 0 {
+		c.TTL = DefaultIdempotencyTTL
+	}
+	if c.MaxBodyBytes == 0 {
+		c.MaxBodyBytes = DefaultIdempotencyMaxBodyBytes
+	}
+	if c.MaxEntries == 0 {
+		c.MaxEntries = DefaultIdempotencyMaxEntries
+	}
+	return c
+}
+
+// DefaultJobRetention is how long finished async jobs can be polled
+const DefaultJobRetention = time.Hour
+
+// Job limits used when they are left unset
+const (
+	DefaultJobTimeout     = time.Hour
+	DefaultJobMaxPerAgent = 100
+	DefaultMaxJobs        = 10000
+)
+
+// JobsConfig controls calls made with ?mode=async
+type JobsConfig struct {
+	// Retention keeps finished jobs pollable for this long (default 1h)
+	Retention time.Duration `yaml:"retention"`
+
+	// Timeout fails jobs still pending or running after this long
+	// (default 1h)
+	Timeout time.Duration `yaml:"timeout"`
+
+	// MaxPerAgent bounds an agent's unfinished jobs (default 100) and
+	// MaxJobs the jobs kept, finished or not (default 10000)
+	MaxPerAgent int `yaml:"max_per_agent"`
+	MaxJobs     int `yaml:"max_jobs"`
+
+	// CallbackHosts lists the hosts job results may be posted to. Callbacks
+	// are refused when it is empty, so agents can't make the gateway call
+	// arbitrary URLs.
+	CallbackHosts []string `yaml:"callback_hosts"`
+}
+
+// WithDefaults returns the settings with unset values defaulted
+func (c JobsConfig) WithDefaults() JobsConfig {
+	if c.Retention == 0 {
+		c.Retention = DefaultJobRetention
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultJobTimeout
+	}
+	if c.MaxPerAgent == 0 {
+		c.MaxPerAgent = DefaultJobMaxPerAgent
+	}
+	if c.MaxJobs == 0 {
+		c.MaxJobs = DefaultMaxJobs
+	}
+	return c
+}
+
+// AllowsCallback reports whether results may be posted to rawURL
+func (c JobsConfig) AllowsCallback(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	for _, host := range c.CallbackHosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetterConfig keeps allowed calls that could not be delivered to their
+// tool so an admin can replay them
+type DeadLetterConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Store is the file entries are kept in; they are lost on restart when
+	// it is empty
+	Store string `yaml:"store"`
+
+	// MaxEntries bounds the store, dropping the oldest entries (default 1000)
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// State backends for rate limit and budget usage
+const (
+	StateBackendMemory = "memory"
+	StateBackendRedis  = "redis"
+)
+
+// DefaultRedisKeyPrefix namespaces the gateway's keys in a shared Redis
+const DefaultRedisKeyPrefix = "aegis:"
+
+// StateConfig selects where rate_limit and budget usage is counted: in each
+// gateway process ("memory", the default) or in Redis, shared by all
+// replicas so limits hold cluster-wide
+type StateConfig struct {
+	Backend string      `yaml:"backend,omitempty"`
+	Redis   RedisConfig `yaml:"redis,omitempty"`
+}
+
+// RedisConfig connects to the Redis used as shared state
+type RedisConfig struct {
+	Address string `yaml:"address"`
+
+	// Password references a secret such as "env:AEGIS_REDIS_PASSWORD"
+	Password string `yaml:"password,omitempty"`
+	DB       int    `yaml:"db,omitempty"`
+	TLS      bool   `yaml:"tls,omitempty"`
+
+	// KeyPrefix is prepended to every key (default "aegis:")
+	KeyPrefix string `yaml:"key_prefix,omitempty"`
+
+	// Timeout bounds each operation (default 500ms); a call whose usage
+	// can't be read is handled by on_policy_error
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// ResolvePassword returns the password of redis.password, or "" if unset
+func (r RedisConfig) ResolvePassword() string {
+	return resolveEnvRef(r.Password)
+}
+
+// validateLimits checks upload, idempotency, job and dead letter limits
+// and the state backend rate limits and budgets are kept in
+func (c *Config) validateLimits() error {
+	if u := c.Uploads; u.MaxFileBytes < 0 || u.MaxTotalBytes < 0 || u.MaxFiles < 0 || u.MaxFieldBytes < 0 {
+		return fmt.Errorf("uploads limits must not be negative")
+	}
+	if err := c.Uploads.Scan.validate(); err != nil {
+		return err
+	}
+	if c.Idempotency.TTL < 0 || c.Idempotency.MaxBodyBytes < 0 || c.Idempotency.MaxEntries < 0 {
+		return fmt.Errorf("idempotency settings must not be negative")
+	}
+	if c.DeadLetter.MaxEntries < 0 {
+		return fmt.Errorf("dead_letter.max_entries must not be negative")
+	}
+	if c.Jobs.Retention < 0 || c.Jobs.Timeout < 0 || c.Jobs.MaxPerAgent < 0 || c.Jobs.MaxJobs < 0 {
+		return fmt.Errorf("jobs settings must not be negative")
+	}
+	switch c.State.Backend {
+	case "", StateBackendMemory:
+	case StateBackendRedis:
+		if c.State.Redis.Address == "" {
+			return fmt.Errorf("state.redis.address is required for the redis backend")
+		}
+		if c.State.Redis.DB < 0 || c.State.Redis.Timeout < 0 {
+			return fmt.Errorf("invalid state.redis settings")
+		}
+	default:
+		return fmt.Errorf("state.backend must be memory or redis")
+	}
+	return nil
+}
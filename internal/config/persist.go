@@ -0,0 +1,104 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SaveTool writes the declaration of one tool to the tools section of the
+// config file at path, or removes it when tool is nil, keeping the rest of
+// the document (including comments and the other tools as written) intact.
+// Only the declared config changes: values the running config took from
+// AEGIS_TOOL_* variables or defaults are never written back. A file that
+// declares no tools gets the built-in defaults it was running with. The
+// file is replaced atomically so the config watcher never sees a partial
+// write.
+func SaveTool(path, name string, tool *ToolConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config root must be a mapping")
+	}
+
+	var toolsNode *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "tools" {
+			toolsNode = root.Content[i+1]
+			break
+		}
+	}
+	if toolsNode == nil {
+		toolsNode = &yaml.Node{}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "tools"}, toolsNode)
+	}
+	if toolsNode.Kind == 0 || toolsNode.Tag == "!!null" {
+		if err := toolsNode.Encode(Default().Tools); err != nil {
+			return fmt.Errorf("failed to encode tools: %w", err)
+		}
+	}
+	if toolsNode.Kind != yaml.MappingNode {
+		return fmt.Errorf("tools must be a mapping")
+	}
+
+	var value *yaml.Node
+	if tool != nil {
+		value = &yaml.Node{}
+		if err := value.Encode(tool); err != nil {
+			return fmt.Errorf("failed to encode tool %s: %w", name, err)
+		}
+	}
+	found := false
+	for i := 0; i+1 < len(toolsNode.Content); i += 2 {
+		if toolsNode.Content[i].Value != name {
+			continue
+		}
+		found = true
+		if value != nil {
+			toolsNode.Content[i+1] = value
+		} else {
+			toolsNode.Content = append(toolsNode.Content[:i], toolsNode.Content[i+2:]...)
+		}
+		break
+	}
+	if !found && value != nil {
+		toolsNode.Content = append(toolsNode.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, value)
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	enc.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveTool(t *testing.T) {
+	declared := `# gateway config
+tools:
+  payments:
+    url: http://payments.internal # the real backend
+    timeout: 5s
+`
+	tests := []struct {
+		name      string
+		file      string
+		tool      string
+		config    *ToolConfig
+		wantTools []string
+	}{
+		{"register", declared, "search", &ToolConfig{URL: "http://search.internal", Timeout: time.Second}, []string{"payments", "search"}},
+		{"drain", declared, "payments", nil, []string{}},
+		{"no tools declared", "server:\n  address: :8080\n", "search", &ToolConfig{URL: "http://search.internal", Timeout: time.Second}, []string{"files", "payments", "search"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			// The running config has the override, the file must not get it
+			t.Setenv("AEGIS_TOOL_PAYMENTS_URL", "http://override.local")
+			if _, err := Load(path); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := SaveTool(path, tt.tool, tt.config); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(path)
+			if strings.Contains(string(data), "override.local") {
+				t.Fatalf("environment override was persisted:\n%s", data)
+			}
+			if strings.Contains(tt.file, "the real backend") && tt.config != nil && !strings.Contains(string(data), "# the real backend") {
+				t.Fatalf("comments were lost:\n%s", data)
+			}
+
+			os.Unsetenv("AEGIS_TOOL_PAYMENTS_URL")
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cfg.Tools) != len(tt.wantTools) {
+				t.Fatalf("got tools %v, want %v", cfg.Tools, tt.wantTools)
+			}
+			for _, name := range tt.wantTools {
+				if _, ok := cfg.Tools[name]; !ok {
+					t.Fatalf("tool %s missing: %v", name, cfg.Tools)
+				}
+			}
+			if p, ok := cfg.Tools["payments"]; ok && strings.Contains(tt.file, "payments.internal") && p.URL != "http://payments.internal" {
+				t.Fatalf("payments url is %s", p.URL)
+			}
+		})
+	}
+}
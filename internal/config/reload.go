@@ -0,0 +1,243 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+
+	"aegis-gateway/internal/logging"
+)
+
+// logger is the config component's logger
+var logger = logging.For("config")
+
+// Load reads the configuration file at path on top of the defaults, applies
+// environment overrides and validates the result. An empty path loads the
+// defaults with environment overrides only.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		// Tools declared in the file replace the built-in defaults entirely
+		fileCfg := struct {
+			Tools map[string]ToolConfig `yaml:"tools"`
+		}{}
+		if err := yaml.Unmarshal(data, &fileCfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config YAML: %w", err)
+		}
+		if fileCfg.Tools != nil {
+			cfg.Tools = nil
+		}
+
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config YAML: %w", err)
+		}
+	}
+
+	if err := applyEnv(cfg, os.Environ()); err != nil {
+		return nil, err
+	}
+
+	for name, tool := range cfg.Tools {
+		if tool.Timeout == 0 {
+			tool.Timeout = DefaultToolTimeout
+			cfg.Tools[name] = tool
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	cfg.Path = path
+	return cfg, nil
+}
+
+// applyEnv overrides configuration values from AEGIS_* environment variables:
+//
+//	AEGIS_LISTEN_ADDRESS, AEGIS_METRICS_ADDRESS, AEGIS_ADMIN_ADDRESS,
+//	AEGIS_TLS_CERT_FILE, AEGIS_TLS_KEY_FILE,
+//	AEGIS_POLICIES_DIR, AEGIS_POLICIES_STATE_DIR, AEGIS_LOG_DIR, AEGIS_SERVICE_NAME,
+//	AEGIS_OTLP_ENDPOINT, AEGIS_OTLP_INSECURE, AEGIS_OTLP_METRICS,
+//	AEGIS_LOG_LEVEL, AEGIS_LOG_FORMAT,
+//	AEGIS_TOOL_<NAME>_URL, AEGIS_TOOL_<NAME>_TIMEOUT, AEGIS_TOOL_<NAME>_RETRIES
+func applyEnv(cfg *Config, environ []string) error {
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, "AEGIS_") {
+			continue
+		}
+
+		switch key {
+		case "AEGIS_LISTEN_ADDRESS":
+			cfg.Server.Address = value
+		case "AEGIS_METRICS_ADDRESS":
+			cfg.Server.MetricsAddress = value
+		case "AEGIS_ADMIN_ADDRESS":
+			cfg.Admin.Address = value
+		case "AEGIS_TLS_CERT_FILE":
+			cfg.Server.TLS.CertFile = value
+		case "AEGIS_TLS_KEY_FILE":
+			cfg.Server.TLS.KeyFile = value
+		case "AEGIS_POLICIES_DIR":
+			cfg.Policies.Dir = value
+		case "AEGIS_POLICIES_STATE_DIR":
+			cfg.Policies.StateDir = value
+		case "AEGIS_LOG_DIR":
+			cfg.Telemetry.LogDir = value
+		case "AEGIS_SERVICE_NAME":
+			cfg.Telemetry.ServiceName = value
+		case "AEGIS_SERVICE_VERSION":
+			cfg.Telemetry.ServiceVersion = value
+		case "AEGIS_ENVIRONMENT":
+			cfg.Telemetry.Environment = value
+		case "AEGIS_INSTANCE_ID":
+			cfg.Telemetry.InstanceID = value
+		case "AEGIS_OTLP_ENDPOINT":
+			cfg.Telemetry.OTLPEndpoint = value
+		case "AEGIS_OTLP_INSECURE":
+			cfg.Telemetry.OTLPInsecure = value == "true" || value == "1"
+		case "AEGIS_OTLP_METRICS":
+			cfg.Telemetry.OTLPMetrics = value == "true" || value == "1"
+		case "AEGIS_LOG_LEVEL":
+			cfg.Logging.Level = value
+		case "AEGIS_LOG_FORMAT":
+			cfg.Logging.Format = value
+		default:
+			if err := applyToolEnv(cfg, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyToolEnv handles AEGIS_TOOL_<NAME>_<FIELD> overrides. Tool names are
+// matched case-insensitively; unknown tools are added.
+func applyToolEnv(cfg *Config, key, value string) error {
+	rest, ok := strings.CutPrefix(key, "AEGIS_TOOL_")
+	if !ok {
+		return nil
+	}
+	idx := strings.LastIndex(rest, "_")
+	if idx <= 0 {
+		return nil
+	}
+	name, field := strings.ToLower(rest[:idx]), rest[idx+1:]
+
+	if cfg.Tools == nil {
+		cfg.Tools = make(map[string]ToolConfig)
+	}
+	tool := cfg.Tools[name]
+
+	switch field {
+	case "URL":
+		tool.URL = value
+	case "TIMEOUT":
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		tool.Timeout = d
+	case "RETRIES":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		tool.Retries = n
+	default:
+		return nil
+	}
+
+	cfg.Tools[name] = tool
+	return nil
+}
+
+// ValidEnvName reports whether name is a portable environment variable
+// name: letters, digits and underscores, not starting with a digit
+func ValidEnvName(name string) bool {
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Watcher reloads the configuration file when it changes
+type Watcher struct {
+	path     string
+	watcher  *fsnotify.Watcher
+	onChange func(*Config)
+}
+
+// Watch calls onChange with the new configuration every time the file at
+// path is rewritten and still validates. Invalid changes are logged and the
+// previous configuration stays in effect.
+func Watch(path string, onChange func(*Config)) (*Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create config watcher: %w", err)
+	}
+
+	// Watch the directory so editors that replace the file are handled
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch config directory: %w", err)
+	}
+
+	w := &Watcher{path: filepath.Clean(path), watcher: watcher, onChange: onChange}
+	go w.run()
+	return w, nil
+}
+
+// run handles file system events for hot-reload
+func (w *Watcher) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != w.path {
+				continue
+			}
+
+			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
+				// Small delay to avoid reading during file write
+				time.Sleep(100 * time.Millisecond)
+				cfg, err := Load(w.path)
+				if err != nil {
+					logger.Error("Failed to reload config", "file", w.path, "error", err)
+					continue
+				}
+				logger.Info("Hot-reloaded config", "file", w.path)
+				w.onChange(cfg)
+			}
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Error("Config watcher error", "error", err)
+		}
+	}
+}
+
+// Close stops watching the configuration file
+func (w *Watcher) Close() error {
+	return w.watcher.Close()
+}
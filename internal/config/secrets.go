@@ -0,0 +1,173 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultSecretsRefreshInterval is how long a secret read from a backend is
+// used before it is read again
+const DefaultSecretsRefreshInterval = 5 * time.Minute
+
+// SecretsConfig connects to the backends tool credentials can be read from.
+// Secrets are re-read every RefreshInterval, so rotations apply without a
+// restart.
+type SecretsConfig struct {
+	Vault VaultConfig      `yaml:"vault,omitempty"`
+	AWS   AWSSecretsConfig `yaml:"aws,omitempty"`
+
+	// RefreshInterval defaults to 5m
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+// VaultConfig connects to HashiCorp Vault for vault: references
+type VaultConfig struct {
+	// Address is the Vault URL, e.g. "https://vault:8200"
+	Address string `yaml:"address"`
+
+	// Token references the Vault token, e.g. "env:VAULT_TOKEN"
+	Token string `yaml:"token"`
+
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// ResolveToken returns the token of vault.token, or "" if unset
+func (v VaultConfig) ResolveToken() string {
+	return resolveEnvRef(v.Token)
+}
+
+// AWSSecretsConfig reads aws: references from AWS Secrets Manager with the
+// default credential chain (environment, shared config, IAM role)
+type AWSSecretsConfig struct {
+	// Region overrides the region from the environment
+	Region string `yaml:"region,omitempty"`
+}
+
+// Secret reference schemes
+const (
+	SecretSchemeEnv   = "env"
+	SecretSchemeFile  = "file"
+	SecretSchemeVault = "vault"
+	SecretSchemeAWS   = "aws"
+)
+
+// Credential types: how a tool's secret is sent
+const (
+	CredentialBearer = "bearer"
+	CredentialHeader = "header"
+	CredentialBasic  = "basic"
+)
+
+// CredentialsConfig is the secret a tool is called with. Secret is a
+// reference:
+//
+//	env:NAME                     environment variable
+//	file:/path                   file contents, e.g. a mounted Kubernetes secret
+//	vault:<path>#<key>           Vault KV (v1 or v2) field
+//	aws:<secret-id>[#<key>]      AWS Secrets Manager secret, or a field of a JSON secret
+//
+// In YAML a plain reference is shorthand for a bearer token.
+type CredentialsConfig struct {
+	// Type is bearer (default), header or basic
+	Type   string `yaml:"type,omitempty"`
+	Secret string `yaml:"secret,omitempty"`
+
+	// Header is the header a header credential is sent in, e.g. X-API-Key
+	Header string `yaml:"header,omitempty"`
+
+	// Username is the basic auth user, either literal or a reference; the
+	// secret is the password
+	Username string `yaml:"username,omitempty"`
+}
+
+// UnmarshalYAML accepts a plain reference as well as the full form
+func (c *CredentialsConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*c = CredentialsConfig{Secret: value.Value}
+		return nil
+	}
+	type plain CredentialsConfig
+	return value.Decode((*plain)(c))
+}
+
+// MarshalYAML writes bearer credentials as a plain reference
+func (c CredentialsConfig) MarshalYAML() (interface{}, error) {
+	if c.Type == "" && c.Header == "" && c.Username == "" {
+		return c.Secret, nil
+	}
+	type plain CredentialsConfig
+	return plain(c), nil
+}
+
+// Configured reports whether the tool has credentials
+func (c CredentialsConfig) Configured() bool {
+	return c.Secret != ""
+}
+
+// validate checks the credential type and references
+func (c CredentialsConfig) validate() error {
+	if !c.Configured() {
+		if c.Type != "" || c.Header != "" || c.Username != "" {
+			return fmt.Errorf("secret is required")
+		}
+		return nil
+	}
+	if err := ValidateSecretRef(c.Secret); err != nil {
+		return err
+	}
+	switch c.Type {
+	case "", CredentialBearer:
+	case CredentialHeader:
+		if c.Header == "" {
+			return fmt.Errorf("header is required for header credentials")
+		}
+	case CredentialBasic:
+		if c.Username == "" {
+			return fmt.Errorf("username is required for basic credentials")
+		}
+		if _, _, ok := SplitSecretRef(c.Username); ok {
+			return ValidateSecretRef(c.Username)
+		}
+	default:
+		return fmt.Errorf("type must be bearer, header or basic")
+	}
+	return nil
+}
+
+// UsesScheme reports whether the secret or username is read with scheme
+func (c CredentialsConfig) UsesScheme(scheme string) bool {
+	for _, ref := range []string{c.Secret, c.Username} {
+		if s, _, ok := SplitSecretRef(ref); ok && s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitSecretRef splits a reference into its scheme and the rest. ok is
+// false when ref doesn't start with a known scheme.
+func SplitSecretRef(ref string) (scheme, rest string, ok bool) {
+	scheme, rest, found := strings.Cut(ref, ":")
+	switch scheme {
+	case SecretSchemeEnv, SecretSchemeFile, SecretSchemeVault, SecretSchemeAWS:
+		return scheme, rest, found
+	}
+	return "", "", false
+}
+
+// ValidateSecretRef checks that ref is a well-formed secret reference
+func ValidateSecretRef(ref string) error {
+	scheme, rest, ok := SplitSecretRef(ref)
+	if !ok || rest == "" {
+		return fmt.Errorf("%q must be a reference such as env:NAME, file:/path, vault:path#key or aws:secret-id", ref)
+	}
+	if scheme == SecretSchemeVault {
+		if path, key, _ := strings.Cut(rest, "#"); path == "" || key == "" {
+			return fmt.Errorf("%q must name a field, e.g. vault:secret/data/payments#token", ref)
+		}
+	}
+	return nil
+}
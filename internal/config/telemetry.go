@@ -0,0 +1,452 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"aegis-gateway/pkg/telemetry"
+)
+
+// Notification triggers
+const (
+	TriggerDeny               = "deny"
+	TriggerDenyBurst          = "deny_burst"
+	TriggerBudgetExhausted    = "budget_exhausted"
+	TriggerPolicyReloadFailed = "policy_reload_failed"
+	TriggerAnomaly            = "anomaly"
+	TriggerTripwire           = "tripwire"
+)
+
+// Notification payload formats
+const (
+	NotifyFormatJSON  = "json"
+	NotifyFormatSlack = "slack"
+)
+
+// Notification defaults
+const (
+	DefaultNotifyTimeout  = 5 * time.Second
+	DefaultNotifyCooldown = 5 * time.Minute
+)
+
+// NotifyConfig lists the webhooks operators are notified through
+type NotifyConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks,omitempty"`
+}
+
+// WebhookConfig is a webhook and the events that fire it
+type WebhookConfig struct {
+	Name string `yaml:"name"`
+
+	// URL is where events are POSTed. Slack webhook URLs are secrets, so a
+	// reference such as env:SLACK_WEBHOOK_URL is accepted too.
+	URL string `yaml:"url"`
+
+	// Format is json (default), the event as is, or slack, a message Slack
+	// incoming webhooks and compatible tools accept
+	Format string `yaml:"format,omitempty"`
+
+	// Headers are sent with each request; values may be secret references
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Timeout bounds each request (default 5s)
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// Cooldown is how long a trigger stays quiet for the same agent after
+	// firing (default 5m), so a stream of denials is one notification
+	Cooldown time.Duration `yaml:"cooldown,omitempty"`
+
+	Triggers []TriggerConfig `yaml:"triggers"`
+}
+
+// TriggerConfig is an event that fires a webhook
+type TriggerConfig struct {
+	// Type is deny, deny_burst, budget_exhausted, policy_reload_failed,
+	// anomaly or tripwire
+	Type string `yaml:"type"`
+
+	// Agents, Tools and Codes narrow deny, deny_burst and budget_exhausted
+	// to those agents, tools and deny codes; Agents and Tools narrow
+	// anomaly and tripwire
+	Agents []string `yaml:"agents,omitempty"`
+	Tools  []string `yaml:"tools,omitempty"`
+	Codes  []string `yaml:"codes,omitempty"`
+
+	// Count denials from one agent within Window fire a deny_burst
+	Count  int           `yaml:"count,omitempty"`
+	Window time.Duration `yaml:"window,omitempty"`
+}
+
+// validate checks the webhooks and their triggers
+func (c NotifyConfig) validate() error {
+	names := make(map[string]bool)
+	for i, w := range c.Webhooks {
+		if w.Name == "" {
+			return fmt.Errorf("notifications.webhooks[%d] requires a name", i)
+		}
+		if names[w.Name] {
+			return fmt.Errorf("notifications.webhooks: %s is configured twice", w.Name)
+		}
+		names[w.Name] = true
+		if _, _, ok := SplitSecretRef(w.URL); ok {
+			if err := ValidateSecretRef(w.URL); err != nil {
+				return fmt.Errorf("notifications.webhooks.%s.url: %w", w.Name, err)
+			}
+		} else if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("notifications.webhooks.%s.url must be an http or https URL or a secret reference", w.Name)
+		}
+		switch w.Format {
+		case "", NotifyFormatJSON, NotifyFormatSlack:
+		default:
+			return fmt.Errorf("notifications.webhooks.%s.format must be json or slack", w.Name)
+		}
+		if w.Timeout < 0 || w.Cooldown < 0 {
+			return fmt.Errorf("notifications.webhooks.%s: timeout and cooldown must not be negative", w.Name)
+		}
+		if len(w.Triggers) == 0 {
+			return fmt.Errorf("notifications.webhooks.%s requires triggers", w.Name)
+		}
+		for j, t := range w.Triggers {
+			switch t.Type {
+			case TriggerDeny, TriggerBudgetExhausted, TriggerPolicyReloadFailed, TriggerAnomaly, TriggerTripwire:
+			case TriggerDenyBurst:
+				if t.Count < 2 || t.Window <= 0 {
+					return fmt.Errorf("notifications.webhooks.%s.triggers[%d]: deny_burst requires a count of at least 2 and a window", w.Name, j)
+				}
+			default:
+				return fmt.Errorf("notifications.webhooks.%s.triggers[%d].type must be deny, deny_burst, budget_exhausted, policy_reload_failed, anomaly or tripwire", w.Name, j)
+			}
+		}
+	}
+	return nil
+}
+
+// Report periods
+const (
+	ReportDaily  = "daily"
+	ReportWeekly = "weekly"
+)
+
+// Report formats
+const (
+	ReportFormatJSON = "json"
+	ReportFormatCSV  = "csv"
+	ReportFormatHTML = "html"
+)
+
+// ReportConfig is an audit report for compliance reviews, generated on a
+// schedule from the decision store
+type ReportConfig struct {
+	Name string `yaml:"name"`
+
+	// Period is daily, covering the 24 hours before each run, or weekly,
+	// running on Mondays and covering the 7 days before
+	Period string `yaml:"period"`
+
+	// At is the UTC time of day reports run, as HH:MM (default 00:00)
+	At string `yaml:"at,omitempty"`
+
+	// Formats are json (default), csv and html
+	Formats []string `yaml:"formats,omitempty"`
+
+	// Dir, Email and Webhook are where reports go; at least one is
+	// required
+	Dir     string               `yaml:"dir,omitempty"`
+	Email   *ReportEmailConfig   `yaml:"email,omitempty"`
+	Webhook *ReportWebhookConfig `yaml:"webhook,omitempty"`
+}
+
+// ReportEmailConfig sends reports through an email tool, attached in each
+// format
+type ReportEmailConfig struct {
+	Tool string   `yaml:"tool"`
+	To   []string `yaml:"to"`
+}
+
+// ReportWebhookConfig POSTs reports in JSON
+type ReportWebhookConfig struct {
+	// URL may be a secret reference
+	URL string `yaml:"url"`
+
+	// Headers are sent with each request; values may be secret references
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Timeout bounds each request (default 30s)
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// DefaultReportWebhookTimeout applies to report webhooks without a timeout
+const DefaultReportWebhookTimeout = 30 * time.Second
+
+// validateReports checks the reports and that their email tools exist
+func (c *Config) validateReports() error {
+	if len(c.Reports) > 0 && !c.Telemetry.Store.Enabled() {
+		return fmt.Errorf("reports require telemetry.store")
+	}
+	names := make(map[string]bool)
+	for i, r := range c.Reports {
+		if r.Name == "" {
+			return fmt.Errorf("reports[%d] requires a name", i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("reports: %s is configured twice", r.Name)
+		}
+		names[r.Name] = true
+		if r.Period != ReportDaily && r.Period != ReportWeekly {
+			return fmt.Errorf("reports.%s.period must be daily or weekly", r.Name)
+		}
+		if r.At != "" {
+			if _, err := time.Parse("15:04", r.At); err != nil {
+				return fmt.Errorf("reports.%s.at must be a time of day such as 06:00", r.Name)
+			}
+		}
+		for _, format := range r.Formats {
+			switch format {
+			case ReportFormatJSON, ReportFormatCSV, ReportFormatHTML:
+			default:
+				return fmt.Errorf("reports.%s.formats must be json, csv or html", r.Name)
+			}
+		}
+		if r.Dir == "" && r.Email == nil && r.Webhook == nil {
+			return fmt.Errorf("reports.%s requires a dir, email or webhook", r.Name)
+		}
+		if e := r.Email; e != nil {
+			if tool, ok := c.Tools[e.Tool]; !ok || tool.Protocol != "email" {
+				return fmt.Errorf("reports.%s.email.tool must name an email tool", r.Name)
+			}
+			if len(e.To) == 0 {
+				return fmt.Errorf("reports.%s.email requires recipients", r.Name)
+			}
+		}
+		if w := r.Webhook; w != nil {
+			if _, _, ok := SplitSecretRef(w.URL); ok {
+				if err := ValidateSecretRef(w.URL); err != nil {
+					return fmt.Errorf("reports.%s.webhook.url: %w", r.Name, err)
+				}
+			} else if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("reports.%s.webhook.url must be an http or https URL or a secret reference", r.Name)
+			}
+			if w.Timeout < 0 {
+				return fmt.Errorf("reports.%s.webhook.timeout must not be negative", r.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Anomaly types
+const (
+	AnomalyNewTool     = "new_tool"
+	AnomalyVolumeSpike = "volume_spike"
+	AnomalyOffHours    = "off_hours"
+	AnomalyNearLimit   = "near_limit"
+)
+
+// What the gateway does about an anomaly
+const (
+	AnomalyActionLog             = "log"
+	AnomalyActionRequireApproval = "require_approval"
+)
+
+// Anomaly detection defaults
+const (
+	DefaultAnomalyLearning        = 24 * time.Hour
+	DefaultAnomalyBaseline        = 7 * 24 * time.Hour
+	DefaultAnomalyVolumeFactor    = 10
+	DefaultAnomalyMinVolume       = 20
+	DefaultAnomalyNearLimitRatio  = 0.9
+	DefaultAnomalyNearLimitCount  = 3
+	DefaultAnomalyNearLimitWindow = time.Hour
+	DefaultAnomalyCooldown        = time.Hour
+)
+
+// AnomalyConfig learns each agent's usual behavior and flags calls that
+// deviate from it
+type AnomalyConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Detect lists the anomaly types to flag: new_tool, volume_spike,
+	// off_hours and near_limit (default all)
+	Detect []string `yaml:"detect,omitempty"`
+
+	// Learning is how long an agent is observed before its calls are
+	// flagged (default 24h)
+	Learning time.Duration `yaml:"learning,omitempty"`
+
+	// Baseline is how much of an agent's history its usual behavior is
+	// learned from (default 168h)
+	Baseline time.Duration `yaml:"baseline,omitempty"`
+
+	// VolumeFactor times the agent's average hourly calls, and at least
+	// MinVolume calls, within an hour is a volume_spike (default 10 and 20)
+	VolumeFactor float64 `yaml:"volume_factor,omitempty"`
+	MinVolume    int     `yaml:"min_volume,omitempty"`
+
+	// NearLimitCount allowed amounts of at least NearLimitRatio of the
+	// rule's max_amount within NearLimitWindow are a near_limit (default
+	// 3, 0.9 and 1h)
+	NearLimitRatio  float64       `yaml:"near_limit_ratio,omitempty"`
+	NearLimitCount  int           `yaml:"near_limit_count,omitempty"`
+	NearLimitWindow time.Duration `yaml:"near_limit_window,omitempty"`
+
+	// Cooldown is how long an anomaly type stays quiet for an agent after
+	// it was flagged (default 1h)
+	Cooldown time.Duration `yaml:"cooldown,omitempty"`
+
+	// Action is log (default), which only records anomalies, or
+	// require_approval, which also denies the agent's calls until an
+	// operator approves it
+	Action string `yaml:"action,omitempty"`
+}
+
+// WithDefaults returns the settings with unset values defaulted
+func (a AnomalyConfig) WithDefaults() AnomalyConfig {
+	if len(a.Detect) == 0 {
+		a.Detect = []string{AnomalyNewTool, AnomalyVolumeSpike, AnomalyOffHours, AnomalyNearLimit}
+	}
+	if a.Learning == 0 {
+		a.Learning = DefaultAnomalyLearning
+	}
+	if a.Baseline == 0 {
+		a.Baseline = DefaultAnomalyBaseline
+	}
+	if a.VolumeFactor == 0 {
+		a.VolumeFactor = DefaultAnomalyVolumeFactor
+	}
+	if a.MinVolume == 0 {
+		a.MinVolume = DefaultAnomalyMinVolume
+	}
+	if a.NearLimitRatio == 0 {
+		a.NearLimitRatio = DefaultAnomalyNearLimitRatio
+	}
+	if a.NearLimitCount == 0 {
+		a.NearLimitCount = DefaultAnomalyNearLimitCount
+	}
+	if a.NearLimitWindow == 0 {
+		a.NearLimitWindow = DefaultAnomalyNearLimitWindow
+	}
+	if a.Cooldown == 0 {
+		a.Cooldown = DefaultAnomalyCooldown
+	}
+	if a.Action == "" {
+		a.Action = AnomalyActionLog
+	}
+	return a
+}
+
+// validate checks the anomaly detection settings
+func (a AnomalyConfig) validate() error {
+	for _, t := range a.Detect {
+		switch t {
+		case AnomalyNewTool, AnomalyVolumeSpike, AnomalyOffHours, AnomalyNearLimit:
+		default:
+			return fmt.Errorf("anomaly.detect must list new_tool, volume_spike, off_hours or near_limit")
+		}
+	}
+	if a.Learning < 0 || a.Baseline < 0 || a.NearLimitWindow < 0 || a.Cooldown < 0 {
+		return fmt.Errorf("anomaly durations must not be negative")
+	}
+	if a.Baseline != 0 && a.Baseline < 24*time.Hour {
+		return fmt.Errorf("anomaly.baseline must be at least 24h")
+	}
+	if a.VolumeFactor < 0 || (a.VolumeFactor > 0 && a.VolumeFactor <= 1) {
+		return fmt.Errorf("anomaly.volume_factor must be greater than 1")
+	}
+	if a.MinVolume < 0 || a.NearLimitCount < 0 {
+		return fmt.Errorf("anomaly.min_volume and near_limit_count must not be negative")
+	}
+	if a.NearLimitRatio < 0 || a.NearLimitRatio > 1 {
+		return fmt.Errorf("anomaly.near_limit_ratio must be between 0 and 1")
+	}
+	switch a.Action {
+	case "", AnomalyActionLog, AnomalyActionRequireApproval:
+	default:
+		return fmt.Errorf("anomaly.action must be log or require_approval")
+	}
+	return nil
+}
+
+// What the gateway does about a tripped tripwire
+const (
+	TripwireActionLog        = "log"
+	TripwireActionQuarantine = "quarantine"
+)
+
+// AnomalyTripwire is the anomaly type of calls to decoys
+const AnomalyTripwire = "tripwire"
+
+// TripwireConfig decides what happens when an agent calls a decoy tool or
+// action
+type TripwireConfig struct {
+	// Action is log (default), which records the call and fires tripwire
+	// notifications, or quarantine, which also quarantines the agent
+	Action string `yaml:"action,omitempty"`
+}
+
+// DefaultQuarantineWindow is the window denials are counted in when none is
+// configured
+const DefaultQuarantineWindow = 5 * time.Minute
+
+// QuarantineConfig quarantines agents that are denied too often: all their
+// calls are denied until an operator releases them
+type QuarantineConfig struct {
+	// Denials of an agent within Window quarantine it. 0 (default) leaves
+	// quarantines to tripwires.
+	Denials int           `yaml:"denials,omitempty"`
+	Window  time.Duration `yaml:"window,omitempty"`
+
+	// Codes narrows the denials counted to these deny codes
+	Codes []string `yaml:"codes,omitempty"`
+
+	// Store is the JSON file quarantined agents are kept in across
+	// restarts, in memory only when empty
+	Store string `yaml:"store,omitempty"`
+}
+
+// WithDefaults returns the settings with unset values defaulted
+func (q QuarantineConfig) WithDefaults() QuarantineConfig {
+	if q.Window == 0 {
+		q.Window = DefaultQuarantineWindow
+	}
+	return q
+}
+
+// validateTelemetry checks the audit log, notification, report and
+// anomaly settings
+func (c *Config) validateTelemetry() error {
+	if err := c.Notify.validate(); err != nil {
+		return err
+	}
+	if err := c.validateReports(); err != nil {
+		return err
+	}
+	if err := c.Anomaly.validate(); err != nil {
+		return err
+	}
+	switch c.Tripwire.Action {
+	case "", TripwireActionLog, TripwireActionQuarantine:
+	default:
+		return fmt.Errorf("tripwire.action must be log or quarantine")
+	}
+	if c.Quarantine.Denials < 0 || c.Quarantine.Window < 0 {
+		return fmt.Errorf("quarantine.denials and window must not be negative")
+	}
+	if c.Telemetry.LogDir == "" {
+		return fmt.Errorf("telemetry.log_dir is required")
+	}
+	if err := c.Telemetry.Validate(); err != nil {
+		return err
+	}
+	if store := c.Telemetry.Store; store.Driver == telemetry.StorePostgres {
+		if err := ValidateSecretRef(store.DSN); err != nil {
+			return fmt.Errorf("telemetry.store.dsn: %w", err)
+		}
+	}
+	if ref := c.Telemetry.RecordSigningKey; ref != "" {
+		if err := ValidateSecretRef(ref); err != nil {
+			return fmt.Errorf("telemetry.record_signing_key: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,849 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/injection"
+	"aegis-gateway/internal/schema"
+)
+
+// DefaultEgressPinTTL is how long a tool host's resolved addresses are used
+// before it is resolved again
+const DefaultEgressPinTTL = 5 * time.Minute
+
+// EgressConfig restricts the connections the gateway makes to tools. Only
+// hosts declared in the tool registry, and those listed in Allow, can be
+// reached; redirects to any other host are refused.
+type EgressConfig struct {
+	// Disabled turns the restriction off
+	Disabled bool `yaml:"disabled,omitempty"`
+
+	// Allow lists further hosts, as "host" or "host:port", tool calls may
+	// connect to, such as an outbound proxy
+	Allow []string `yaml:"allow,omitempty"`
+
+	// PinTTL is how long a tool host's addresses are pinned (default 5m).
+	// Connections only go to pinned addresses, so a DNS answer changed
+	// between calls can't redirect them.
+	PinTTL time.Duration `yaml:"pin_ttl,omitempty"`
+}
+
+// Allows reports whether addr, a host:port, is listed in Allow
+func (c EgressConfig) Allows(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	for _, allowed := range c.Allow {
+		if strings.EqualFold(allowed, addr) || strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPluginTimeout bounds a plugin hook call when no timeout is set
+const DefaultPluginTimeout = 100 * time.Millisecond
+
+// PluginConfig loads a WASM plugin that runs before calls are evaluated
+// and before responses are sent (see internal/plugin for the interface
+// plugins implement)
+type PluginConfig struct {
+	Name string `yaml:"name"`
+
+	// Path is the .wasm file
+	Path string `yaml:"path"`
+
+	// Tools limits the plugin to these tools; it runs for every tool when
+	// empty
+	Tools []string `yaml:"tools,omitempty"`
+
+	// Config is passed to the plugin with every call
+	Config map[string]interface{} `yaml:"config,omitempty"`
+
+	// Timeout bounds each hook call (default 100ms)
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// FailOpen lets calls through when the plugin fails or times out;
+	// by default they are rejected
+	FailOpen bool `yaml:"fail_open,omitempty"`
+}
+
+// AppliesTo reports whether the plugin runs for tool
+func (p PluginConfig) AppliesTo(tool string) bool {
+	if len(p.Tools) == 0 {
+		return true
+	}
+	for _, t := range p.Tools {
+		if t == tool {
+			return true
+		}
+	}
+	return false
+}
+
+// ToolConfig describes an upstream tool backend
+type ToolConfig struct {
+	URL     string        `yaml:"url,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// Protocol is "http" (default), "grpc", "mcp", "graphql", "sql", "exec",
+	// "files", "email" or "fetch"
+	Protocol string          `yaml:"protocol,omitempty"`
+	GRPC     GRPCToolConfig  `yaml:"grpc,omitempty"`
+	SQL      SQLToolConfig   `yaml:"sql,omitempty"`
+	Exec     ExecToolConfig  `yaml:"exec,omitempty"`
+	Files    FilesToolConfig `yaml:"files,omitempty"`
+	Email    EmailToolConfig `yaml:"email,omitempty"`
+	Fetch    FetchToolConfig `yaml:"fetch,omitempty"`
+
+	// URLs lists additional replicas balanced together with URL
+	URLs          []string            `yaml:"urls,omitempty"`
+	LoadBalancing LoadBalancingConfig `yaml:"load_balancing,omitempty"`
+
+	// Canary routes a share of agents to a new version of the tool
+	Canary CanaryConfig `yaml:"canary,omitempty"`
+
+	// Retries is the number of extra attempts after a failed forward
+	Retries int         `yaml:"retries,omitempty"`
+	Retry   RetryConfig `yaml:"retry,omitempty"`
+
+	// CircuitBreaker fast-fails calls while the tool is persistently failing
+	CircuitBreaker BreakerConfig `yaml:"circuit_breaker,omitempty"`
+
+	// Concurrency caps in-flight calls so a slow tool can't tie up the gateway
+	Concurrency ConcurrencyConfig `yaml:"concurrency,omitempty"`
+
+	// Transport tunes the connection pool used to reach the tool
+	Transport TransportConfig `yaml:"transport,omitempty"`
+
+	// Credentials is the secret injected when forwarding. The secret itself
+	// never lives in config, only a reference to it.
+	Credentials CredentialsConfig `yaml:"credentials,omitempty"`
+
+	// Methods lists the HTTP methods agents may use; defaults to POST
+	Methods []string `yaml:"methods,omitempty"`
+
+	// HealthCheck is the path probed to check the tool is reachable
+	HealthCheck string `yaml:"health_check,omitempty"`
+
+	// Discovery resolves instances dynamically instead of using URL, e.g.
+	// "consul://payments-svc", "srv://_payments._tcp.example.internal" or
+	// "k8s://payments-svc.finance:8081"
+	Discovery        string        `yaml:"discovery,omitempty"`
+	DiscoveryRefresh time.Duration `yaml:"discovery_refresh,omitempty"`
+
+	// SPIFFEID is the identity the tool must present; calls use the
+	// gateway's own SVID as the client certificate
+	SPIFFEID string `yaml:"spiffe_id,omitempty"`
+
+	// WebSocket lets agents open WebSocket connections to the tool
+	WebSocket WebSocketConfig `yaml:"websocket,omitempty"`
+
+	// Cache serves repeated identical calls to read-only actions from memory
+	Cache CacheConfig `yaml:"cache,omitempty"`
+
+	// OnPolicyError overrides policies.on_policy_error for this tool
+	OnPolicyError string `yaml:"on_policy_error,omitempty"`
+
+	// InjectionFilter scans calls, and optionally responses, for prompt
+	// injection
+	InjectionFilter InjectionFilterConfig `yaml:"injection_filter,omitempty"`
+
+	// Schema validates calls and responses against versioned schemas and
+	// serves them at /v1/tools/<name>/schema
+	Schema SchemaConfig `yaml:"schema,omitempty"`
+
+	// Capture records chosen params in the decision log and on spans;
+	// the rest are only covered by params.hash
+	Capture CaptureConfig `yaml:"capture,omitempty"`
+
+	// Decoy makes the tool a honeytoken no legitimate agent calls. It has
+	// no upstream, and every call is denied and trips the tripwire.
+	Decoy bool `yaml:"decoy,omitempty"`
+
+	// DecoyActions are actions of a real tool that trip the tripwire
+	DecoyActions []string `yaml:"decoy_actions,omitempty"`
+}
+
+// CaptureConfig lists params, by name or dotted path such as payee.iban,
+// that are recorded with each decision
+type CaptureConfig struct {
+	// Cleartext params are recorded as sent
+	Cleartext []string `yaml:"cleartext,omitempty"`
+
+	// Masked params are recorded with all but their last four characters
+	// hidden, or entirely hidden if shorter than eight
+	Masked []string `yaml:"masked,omitempty"`
+}
+
+func (c CaptureConfig) validate() error {
+	seen := make(map[string]bool)
+	for _, path := range append(append([]string{}, c.Cleartext...), c.Masked...) {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			return fmt.Errorf("%q is not a param name or dotted path", path)
+		}
+		if seen[path] {
+			return fmt.Errorf("%s is listed twice", path)
+		}
+		seen[path] = true
+	}
+	return nil
+}
+
+// SchemaConfig registers versions of a tool's request and response
+// schemas. Agents pick a version with X-Aegis-Schema-Version.
+type SchemaConfig struct {
+	// Current is the version calls are validated against by default; the
+	// last version listed if unset
+	Current string `yaml:"current,omitempty"`
+
+	Versions []SchemaVersionConfig `yaml:"versions,omitempty"`
+}
+
+// SchemaVersionConfig is one version of a tool's schemas: an OpenAPI
+// document whose paths are the tool's actions, JSON Schema files per
+// action, or both, with the files taking precedence
+type SchemaVersionConfig struct {
+	Version string                        `yaml:"version"`
+	OpenAPI string                        `yaml:"openapi,omitempty"`
+	Actions map[string]ActionSchemaConfig `yaml:"actions,omitempty"`
+}
+
+// ActionSchemaConfig names the JSON Schema files of an action's params and
+// of its successful responses
+type ActionSchemaConfig struct {
+	Request  string `yaml:"request,omitempty"`
+	Response string `yaml:"response,omitempty"`
+}
+
+// Enabled reports whether the tool has schemas
+func (c SchemaConfig) Enabled() bool {
+	return len(c.Versions) > 0
+}
+
+// Sources returns the versions in the form the schema registry takes
+func (c SchemaConfig) Sources() []schema.Source {
+	sources := make([]schema.Source, 0, len(c.Versions))
+	for _, v := range c.Versions {
+		actions := make(map[string]schema.ActionSource, len(v.Actions))
+		for name, a := range v.Actions {
+			actions[name] = schema.ActionSource{Request: a.Request, Response: a.Response}
+		}
+		sources = append(sources, schema.Source{Version: v.Version, OpenAPI: v.OpenAPI, Actions: actions})
+	}
+	return sources
+}
+
+// Injection filter modes
+const (
+	InjectionFilterOff   = "off"
+	InjectionFilterFlag  = "flag"
+	InjectionFilterBlock = "block"
+)
+
+// InjectionFilterConfig scans a tool's string params for prompt-injection
+// patterns. In flag mode findings are only recorded in the decision log;
+// in block mode they also deny the call.
+type InjectionFilterConfig struct {
+	// Mode is off (default), flag or block
+	Mode string `yaml:"mode,omitempty"`
+
+	// Responses scans the tool's responses as well
+	Responses bool `yaml:"responses,omitempty"`
+
+	// Patterns adds regular expressions by name to the built-in patterns
+	Patterns map[string]string `yaml:"patterns,omitempty"`
+
+	// Disable turns off built-in patterns that misfire for the tool
+	Disable []string `yaml:"disable,omitempty"`
+}
+
+// Enabled reports whether the filter runs
+func (c InjectionFilterConfig) Enabled() bool {
+	return c.Mode == InjectionFilterFlag || c.Mode == InjectionFilterBlock
+}
+
+// CanaryConfig is a new version of a tool that receives Weight percent of
+// agents. Agents are assigned by a hash of their ID, so each one keeps
+// seeing the same version. Every other setting is shared with the tool.
+type CanaryConfig struct {
+	URL    string   `yaml:"url,omitempty"`
+	URLs   []string `yaml:"urls,omitempty"`
+	Weight float64  `yaml:"weight,omitempty"`
+}
+
+// Enabled reports whether a canary version is configured
+func (c CanaryConfig) Enabled() bool {
+	return c.URL != "" || len(c.URLs) > 0
+}
+
+// CacheConfig lists the read-only actions whose successful responses may be
+// reused for the same agent and params
+type CacheConfig struct {
+	Actions []string      `yaml:"actions,omitempty"`
+	TTL     time.Duration `yaml:"ttl,omitempty"`
+
+	// MaxEntries bounds the cache (default 1000); MaxBodyBytes skips larger
+	// responses (default 1 MiB)
+	MaxEntries   int `yaml:"max_entries,omitempty"`
+	MaxBodyBytes int `yaml:"max_body_bytes,omitempty"`
+}
+
+// RetryConfig tunes how failed forwards are retried
+type RetryConfig struct {
+	// Backoff is the base delay, doubled on every attempt up to MaxBackoff
+	Backoff    time.Duration `yaml:"backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+
+	// StatusCodes are upstream responses worth retrying (default 502, 503, 504)
+	StatusCodes []int `yaml:"status_codes,omitempty"`
+
+	// IdempotentActions may be retried even when sent with POST. Requests
+	// carrying an Idempotency-Key header are always treated as idempotent.
+	IdempotentActions []string `yaml:"idempotent_actions,omitempty"`
+}
+
+// LoadBalancingConfig controls how calls are spread across replicas
+type LoadBalancingConfig struct {
+	// Strategy is "round_robin" (default) or "least_connections"
+	Strategy string `yaml:"strategy,omitempty"`
+
+	// EjectAfter consecutive failures take an instance out of rotation for
+	// EjectFor
+	EjectAfter int           `yaml:"eject_after,omitempty"`
+	EjectFor   time.Duration `yaml:"eject_for,omitempty"`
+}
+
+// GRPCToolConfig describes a gRPC tool backend. Actions are the service's
+// method names; the descriptor set lets the gateway decode messages into
+// params for policy evaluation.
+type GRPCToolConfig struct {
+	// Service is the fully-qualified service name, e.g. "payments.v1.Payments"
+	Service string `yaml:"service,omitempty"`
+
+	// DescriptorSet is a file produced by protoc --descriptor_set_out
+	// --include_imports
+	DescriptorSet string `yaml:"descriptor_set,omitempty"`
+}
+
+// WebSocketConfig controls WebSocket proxying for a tool
+type WebSocketConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// EvaluateMessages checks every agent message against policy, parsed as
+	// JSON params, instead of only the initial handshake
+	EvaluateMessages bool `yaml:"evaluate_messages,omitempty"`
+
+	// MaxMessageBytes bounds a single agent message (default 1 MiB)
+	MaxMessageBytes int64 `yaml:"max_message_bytes,omitempty"`
+}
+
+// ConcurrencyConfig limits in-flight calls to a tool
+type ConcurrencyConfig struct {
+	// MaxInFlight is the number of concurrent calls; zero means unlimited
+	MaxInFlight int `yaml:"max_in_flight,omitempty"`
+
+	// MaxQueue calls may wait for a slot for up to QueueTimeout; further
+	// calls are rejected immediately
+	MaxQueue     int           `yaml:"max_queue,omitempty"`
+	QueueTimeout time.Duration `yaml:"queue_timeout,omitempty"`
+}
+
+// TransportConfig tunes the HTTP connections to a tool. Zero values keep
+// the gateway defaults.
+type TransportConfig struct {
+	// MaxIdleConnsPerHost idle connections are kept for reuse per instance
+	// (default 32); MaxConnsPerHost caps all connections, zero meaning
+	// unlimited
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host,omitempty"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout,omitempty"`
+
+	DialTimeout         time.Duration `yaml:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout,omitempty"`
+
+	// KeepAlive is the TCP keep-alive period; negative disables TCP
+	// keep-alives. DisableKeepAlives opens a new connection for every call.
+	KeepAlive         time.Duration `yaml:"keep_alive,omitempty"`
+	DisableKeepAlives bool          `yaml:"disable_keep_alives,omitempty"`
+
+	// DisableHTTP2 keeps HTTPS tools on HTTP/1.1 instead of negotiating h2
+	DisableHTTP2 bool `yaml:"disable_http2,omitempty"`
+
+	// Proxy is the outbound proxy calls go through: an http://, https:// or
+	// socks5:// URL. Empty or "direct" connects directly; proxy environment
+	// variables are not used.
+	Proxy string `yaml:"proxy,omitempty"`
+
+	// CAFile is a PEM bundle of the CAs the tool's certificates are
+	// verified against instead of the system roots
+	CAFile string `yaml:"ca_file,omitempty"`
+
+	// LocalAddress is the source IP connections are made from, for hosts
+	// with several interfaces
+	LocalAddress string `yaml:"local_address,omitempty"`
+}
+
+// ProxyDirect as transport.proxy connects directly. It is the same as
+// leaving proxy empty.
+const ProxyDirect = "direct"
+
+// validate checks the proxy and local address
+func (t TransportConfig) validate() error {
+	if t.Proxy != "" && t.Proxy != ProxyDirect {
+		u, err := url.Parse(t.Proxy)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return fmt.Errorf("proxy must be an http://, https:// or socks5:// URL, or direct")
+		}
+	}
+	if t.LocalAddress != "" && net.ParseIP(t.LocalAddress) == nil {
+		return fmt.Errorf("local_address must be an IP address")
+	}
+	return nil
+}
+
+// BreakerConfig tunes a tool's circuit breaker
+type BreakerConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// FailureRate (0-1) within Window that opens the circuit once at least
+	// MinRequests calls were made
+	FailureRate float64       `yaml:"failure_rate,omitempty"`
+	MinRequests int           `yaml:"min_requests,omitempty"`
+	Window      time.Duration `yaml:"window,omitempty"`
+
+	// OpenFor is how long the circuit stays open before probing
+	OpenFor          time.Duration `yaml:"open_for,omitempty"`
+	HalfOpenRequests int           `yaml:"half_open_requests,omitempty"`
+}
+
+// SQLToolConfig is the database a SQL tool runs statements against
+type SQLToolConfig struct {
+	// DSN is a secret reference to a MySQL data source name, e.g. one
+	// holding "agent:secret@tcp(db:3306)/shop?tls=true"
+	DSN string `yaml:"dsn,omitempty"`
+
+	// MaxRows caps the rows a SELECT returns; defaults to DefaultSQLMaxRows
+	MaxRows int `yaml:"max_rows,omitempty"`
+}
+
+// DefaultSQLMaxRows applies to SQL tools without sql.max_rows
+const DefaultSQLMaxRows = 1000
+
+// ExecToolConfig lists the commands an exec tool runs on the gateway host
+type ExecToolConfig struct {
+	// Binaries maps the actions agents call to absolute executable paths,
+	// e.g. git: /usr/bin/git
+	Binaries map[string]string `yaml:"binaries,omitempty"`
+
+	// Dir is the working directory of calls that don't set one
+	Dir string `yaml:"dir,omitempty"`
+
+	// Env is set for every command. Commands don't inherit the gateway's
+	// environment.
+	Env map[string]string `yaml:"env,omitempty"`
+
+	// MaxOutputBytes caps stdout and stderr each; defaults to
+	// DefaultExecMaxOutputBytes
+	MaxOutputBytes int64 `yaml:"max_output_bytes,omitempty"`
+}
+
+// DefaultExecMaxOutputBytes applies to exec tools without max_output_bytes
+const DefaultExecMaxOutputBytes = 1 << 20
+
+// FilesToolConfig is the directory a files tool serves
+type FilesToolConfig struct {
+	// Root is the directory agents' paths are resolved in. Calls can't
+	// leave it, nor follow symbolic links.
+	Root string `yaml:"root,omitempty"`
+
+	// MaxReadBytes caps the size of files read; defaults to
+	// DefaultFilesMaxReadBytes
+	MaxReadBytes int64 `yaml:"max_read_bytes,omitempty"`
+}
+
+// DefaultFilesMaxReadBytes applies to files tools without max_read_bytes
+const DefaultFilesMaxReadBytes = 10 << 20
+
+// Email providers
+const (
+	EmailProviderSMTP     = "smtp"
+	EmailProviderSendGrid = "sendgrid"
+)
+
+// EmailToolConfig is how an email tool delivers messages. The tool's
+// credentials are the SMTP login (basic) or the SendGrid API key (bearer).
+type EmailToolConfig struct {
+	// Provider is smtp (default) or sendgrid
+	Provider string `yaml:"provider,omitempty"`
+
+	// Address is the SMTP server as host:port. Port 465 uses TLS from the
+	// start, other ports STARTTLS when the server offers it. For sendgrid
+	// it overrides DefaultSendGridURL.
+	Address string `yaml:"address,omitempty"`
+
+	// From is the sender of every message; agents can't choose it
+	From string `yaml:"from,omitempty"`
+
+	// MaxAttachmentBytes caps the decoded size of a message's attachments;
+	// defaults to DefaultEmailMaxAttachmentBytes
+	MaxAttachmentBytes int64 `yaml:"max_attachment_bytes,omitempty"`
+}
+
+// DefaultEmailMaxAttachmentBytes applies to email tools without
+// max_attachment_bytes
+const DefaultEmailMaxAttachmentBytes = 10 << 20
+
+// DefaultSendGridURL is the API sendgrid email tools call
+const DefaultSendGridURL = "https://api.sendgrid.com"
+
+// FetchToolConfig tunes a tool that makes HTTP requests to URLs agents
+// pass, within the domains policy allows
+type FetchToolConfig struct {
+	// MaxResponseBytes caps how much of a response is read; defaults to
+	// DefaultFetchMaxResponseBytes. A rule's fetch_max_response_bytes can
+	// lower it.
+	MaxResponseBytes int64 `yaml:"max_response_bytes,omitempty"`
+
+	// AllowPrivate lets fetches reach loopback, private and link-local
+	// addresses, which are refused by default
+	AllowPrivate bool `yaml:"allow_private,omitempty"`
+
+	// UserAgent is sent with requests that don't set one
+	UserAgent string `yaml:"user_agent,omitempty"`
+}
+
+// DefaultFetchMaxResponseBytes applies to fetch tools without
+// max_response_bytes
+const DefaultFetchMaxResponseBytes = 1 << 20
+
+// DefaultToolTimeout applies to tools without an explicit timeout
+const DefaultToolTimeout = 30 * time.Second
+
+// validMethods lists the HTTP methods a tool may allow
+var validMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true,
+}
+
+// validDiscoverySchemes lists the supported service discovery backends
+var validDiscoverySchemes = map[string]bool{
+	"consul": true, "srv": true, "dns+srv": true, "k8s": true, "kubernetes": true,
+}
+
+// validateEmailTool checks an email tool's provider, sender and
+// credentials
+func validateEmailTool(e EmailToolConfig, creds CredentialsConfig) error {
+	switch e.Provider {
+	case "", EmailProviderSMTP:
+		if _, port, err := net.SplitHostPort(e.Address); err != nil || port == "" {
+			return fmt.Errorf("email.address must be the SMTP server as host:port")
+		}
+		if creds.Configured() && creds.Type != CredentialBasic {
+			return fmt.Errorf("smtp email tools take basic credentials")
+		}
+	case EmailProviderSendGrid:
+		if e.Address != "" {
+			if u, err := url.Parse(e.Address); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("email.address must be an http(s) URL for sendgrid")
+			}
+		}
+		if !creds.Configured() || (creds.Type != "" && creds.Type != CredentialBearer) {
+			return fmt.Errorf("sendgrid email tools require the API key as bearer credentials")
+		}
+	default:
+		return fmt.Errorf("email.provider must be smtp or sendgrid")
+	}
+	if _, err := mail.ParseAddress(e.From); err != nil {
+		return fmt.Errorf("email.from must be an email address")
+	}
+	if e.MaxAttachmentBytes < 0 {
+		return fmt.Errorf("email.max_attachment_bytes must not be negative")
+	}
+	return nil
+}
+
+// ValidateTool checks a single tool registry entry
+func ValidateTool(name string, tool ToolConfig) error {
+	if name == "" {
+		return fmt.Errorf("tool name is required")
+	}
+	for _, action := range tool.DecoyActions {
+		if action == "" || strings.ContainsAny(action, "/?#") {
+			return fmt.Errorf("tool %s: decoy action %q must be a single path segment", name, action)
+		}
+	}
+	if tool.Decoy {
+		// Decoys are never forwarded, so they have nowhere to go
+		if tool.URL != "" || len(tool.URLs) > 0 || tool.Discovery != "" || tool.Canary.Enabled() || tool.HealthCheck != "" || (tool.Protocol != "" && tool.Protocol != "http") {
+			return fmt.Errorf("tool %s: decoy tools take no url, urls, discovery, canary, health_check or protocol", name)
+		}
+		return nil
+	}
+	if tool.URL == "" && len(tool.URLs) == 0 && tool.Discovery == "" && tool.Protocol != "sql" && tool.Protocol != "exec" && tool.Protocol != "files" && tool.Protocol != "email" && tool.Protocol != "fetch" {
+		return fmt.Errorf("tool %s: url, urls or discovery is required", name)
+	}
+	upstreams := tool.URLs
+	if tool.URL != "" {
+		upstreams = append([]string{tool.URL}, upstreams...)
+	}
+	if tool.Canary.URL != "" {
+		upstreams = append(upstreams, tool.Canary.URL)
+	}
+	upstreams = append(upstreams, tool.Canary.URLs...)
+	switch tool.Protocol {
+	case "", "http", "mcp", "graphql":
+		for _, upstream := range upstreams {
+			u, err := url.Parse(upstream)
+			if err == nil && u.Scheme == "unix" {
+				if u.Host != "" || u.Path == "" || u.Path == "/" || u.RawQuery != "" {
+					return fmt.Errorf("tool %s: %q must be unix:// followed by an absolute socket path", name, upstream)
+				}
+				continue
+			}
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("tool %s: %q must be an absolute http(s) or unix:// URL", name, upstream)
+			}
+		}
+	case "grpc":
+		for _, upstream := range upstreams {
+			u, err := url.Parse(upstream)
+			if err != nil || (u.Scheme != "grpc" && u.Scheme != "grpcs") || u.Host == "" {
+				return fmt.Errorf("tool %s: %q must be a grpc:// or grpcs:// address", name, upstream)
+			}
+		}
+		if tool.GRPC.Service == "" || tool.GRPC.DescriptorSet == "" {
+			return fmt.Errorf("tool %s: grpc tools require grpc.service and grpc.descriptor_set", name)
+		}
+		if tool.WebSocket.Enabled {
+			return fmt.Errorf("tool %s: websocket is not supported for grpc tools", name)
+		}
+	case "sql":
+		// The database is reached through sql.dsn, with no URL of its own
+		if len(upstreams) > 0 || tool.Discovery != "" {
+			return fmt.Errorf("tool %s: sql tools take sql.dsn instead of url, urls, discovery or canary", name)
+		}
+		if err := ValidateSecretRef(tool.SQL.DSN); err != nil {
+			return fmt.Errorf("tool %s: sql.dsn: %w", name, err)
+		}
+		if tool.SQL.MaxRows < 0 {
+			return fmt.Errorf("tool %s: sql.max_rows must not be negative", name)
+		}
+		if tool.WebSocket.Enabled || tool.HealthCheck != "" {
+			return fmt.Errorf("tool %s: websocket and health_check are not supported for sql tools", name)
+		}
+	case "exec":
+		if len(upstreams) > 0 || tool.Discovery != "" {
+			return fmt.Errorf("tool %s: exec tools take exec.binaries instead of url, urls, discovery or canary", name)
+		}
+		if len(tool.Exec.Binaries) == 0 {
+			return fmt.Errorf("tool %s: exec tools require exec.binaries", name)
+		}
+		for action, path := range tool.Exec.Binaries {
+			if action == "" || strings.ContainsAny(action, "/?#") {
+				return fmt.Errorf("tool %s: exec binary name %q must be a single path segment", name, action)
+			}
+			if !filepath.IsAbs(path) {
+				return fmt.Errorf("tool %s: exec binary %s must be an absolute path", name, action)
+			}
+		}
+		if tool.Exec.Dir != "" && !filepath.IsAbs(tool.Exec.Dir) {
+			return fmt.Errorf("tool %s: exec.dir must be an absolute path", name)
+		}
+		for key := range tool.Exec.Env {
+			if !ValidEnvName(key) {
+				return fmt.Errorf("tool %s: invalid exec.env variable %q", name, key)
+			}
+		}
+		if tool.Exec.MaxOutputBytes < 0 {
+			return fmt.Errorf("tool %s: exec.max_output_bytes must not be negative", name)
+		}
+		if tool.WebSocket.Enabled || tool.HealthCheck != "" {
+			return fmt.Errorf("tool %s: websocket and health_check are not supported for exec tools", name)
+		}
+	case "files":
+		if len(upstreams) > 0 || tool.Discovery != "" {
+			return fmt.Errorf("tool %s: files tools take files.root instead of url, urls, discovery or canary", name)
+		}
+		if tool.Files.Root == "" {
+			return fmt.Errorf("tool %s: files tools require files.root", name)
+		}
+		if tool.Files.MaxReadBytes < 0 {
+			return fmt.Errorf("tool %s: files.max_read_bytes must not be negative", name)
+		}
+		if tool.WebSocket.Enabled || tool.HealthCheck != "" {
+			return fmt.Errorf("tool %s: websocket and health_check are not supported for files tools", name)
+		}
+	case "email":
+		if len(upstreams) > 0 || tool.Discovery != "" {
+			return fmt.Errorf("tool %s: email tools take email.address instead of url, urls, discovery or canary", name)
+		}
+		if err := validateEmailTool(tool.Email, tool.Credentials); err != nil {
+			return fmt.Errorf("tool %s: %w", name, err)
+		}
+		if tool.WebSocket.Enabled || tool.HealthCheck != "" {
+			return fmt.Errorf("tool %s: websocket and health_check are not supported for email tools", name)
+		}
+	case "fetch":
+		// Agents pass the URL; policy decides which hosts it may name
+		if len(upstreams) > 0 || tool.Discovery != "" {
+			return fmt.Errorf("tool %s: fetch tools take the URL from each call instead of url, urls, discovery or canary", name)
+		}
+		if tool.Fetch.MaxResponseBytes < 0 {
+			return fmt.Errorf("tool %s: fetch.max_response_bytes must not be negative", name)
+		}
+		if tool.Transport.Proxy != "" && tool.Transport.Proxy != ProxyDirect {
+			return fmt.Errorf("tool %s: fetch tools connect directly; transport.proxy is not supported", name)
+		}
+		if tool.Credentials.Configured() {
+			return fmt.Errorf("tool %s: fetch tools can't have credentials, which would be sent to any allowed host", name)
+		}
+		if tool.WebSocket.Enabled || tool.HealthCheck != "" {
+			return fmt.Errorf("tool %s: websocket and health_check are not supported for fetch tools", name)
+		}
+	default:
+		return fmt.Errorf("tool %s: protocol must be http, grpc, mcp, graphql, sql, exec, files, email or fetch", name)
+	}
+	if (tool.Protocol == "mcp" || tool.Protocol == "graphql") && tool.WebSocket.Enabled {
+		return fmt.Errorf("tool %s: websocket is not supported for %s tools", name, tool.Protocol)
+	}
+	switch tool.LoadBalancing.Strategy {
+	case "", "round_robin", "least_connections":
+	default:
+		return fmt.Errorf("tool %s: unknown load_balancing strategy %s", name, tool.LoadBalancing.Strategy)
+	}
+	if tool.Canary.Weight < 0 || tool.Canary.Weight > 100 {
+		return fmt.Errorf("tool %s: canary.weight must be between 0 and 100", name)
+	}
+	if tool.Canary.Weight > 0 && !tool.Canary.Enabled() {
+		return fmt.Errorf("tool %s: canary.weight requires canary.url or canary.urls", name)
+	}
+	if tool.LoadBalancing.EjectAfter < 0 || tool.LoadBalancing.EjectFor < 0 {
+		return fmt.Errorf("tool %s: invalid load_balancing ejection settings", name)
+	}
+	if tool.Discovery != "" {
+		u, err := url.Parse(tool.Discovery)
+		if err != nil || !validDiscoverySchemes[u.Scheme] || u.Host == "" {
+			return fmt.Errorf("tool %s: discovery must be a consul://, srv:// or k8s:// reference", name)
+		}
+	}
+	if !validPolicyErrorModes[tool.OnPolicyError] {
+		return fmt.Errorf("tool %s: on_policy_error must be deny, allow or degrade", name)
+	}
+	if tool.Retry.Backoff < 0 || tool.Retry.MaxBackoff < 0 {
+		return fmt.Errorf("tool %s: retry backoff must not be negative", name)
+	}
+	for _, code := range tool.Retry.StatusCodes {
+		if (code < 500 || code > 599) && code != http.StatusTooManyRequests && code != http.StatusRequestTimeout {
+			return fmt.Errorf("tool %s: retry status code %d is not retryable", name, code)
+		}
+	}
+	if cb := tool.CircuitBreaker; cb.FailureRate < 0 || cb.FailureRate > 1 || cb.MinRequests < 0 ||
+		cb.Window < 0 || cb.OpenFor < 0 || cb.HalfOpenRequests < 0 {
+		return fmt.Errorf("tool %s: invalid circuit_breaker settings", name)
+	}
+	if tool.WebSocket.MaxMessageBytes < 0 {
+		return fmt.Errorf("tool %s: websocket.max_message_bytes must not be negative", name)
+	}
+	if cc := tool.Concurrency; cc.MaxInFlight < 0 || cc.MaxQueue < 0 || cc.QueueTimeout < 0 {
+		return fmt.Errorf("tool %s: invalid concurrency settings", name)
+	}
+	if t := tool.Transport; t.MaxIdleConnsPerHost < 0 || t.MaxConnsPerHost < 0 || t.IdleConnTimeout < 0 ||
+		t.DialTimeout < 0 || t.TLSHandshakeTimeout < 0 {
+		return fmt.Errorf("tool %s: invalid transport settings", name)
+	}
+	if err := tool.Transport.validate(); err != nil {
+		return fmt.Errorf("tool %s: transport.%w", name, err)
+	}
+	if tool.Transport.CAFile != "" && tool.SPIFFEID != "" {
+		return fmt.Errorf("tool %s: transport.ca_file can't be combined with spiffe_id", name)
+	}
+	if c := tool.Cache; c.TTL < 0 || c.MaxEntries < 0 || c.MaxBodyBytes < 0 {
+		return fmt.Errorf("tool %s: invalid cache settings", name)
+	}
+	if tool.DiscoveryRefresh < 0 {
+		return fmt.Errorf("tool %s: discovery_refresh must not be negative", name)
+	}
+	if tool.Timeout < 0 {
+		return fmt.Errorf("tool %s: timeout must not be negative", name)
+	}
+	if tool.Retries < 0 {
+		return fmt.Errorf("tool %s: retries must not be negative", name)
+	}
+	switch tool.InjectionFilter.Mode {
+	case "", InjectionFilterOff, InjectionFilterFlag, InjectionFilterBlock:
+	default:
+		return fmt.Errorf("tool %s: injection_filter.mode must be off, flag or block", name)
+	}
+	if _, err := injection.New(tool.InjectionFilter.Patterns, tool.InjectionFilter.Disable); err != nil {
+		return fmt.Errorf("tool %s: injection_filter: %w", name, err)
+	}
+	if err := tool.Capture.validate(); err != nil {
+		return fmt.Errorf("tool %s: capture: %w", name, err)
+	}
+	if tool.Schema.Enabled() {
+		if _, err := schema.Load(tool.Schema.Sources(), tool.Schema.Current); err != nil {
+			return fmt.Errorf("tool %s: %w", name, err)
+		}
+	}
+	if err := tool.Credentials.validate(); err != nil {
+		return fmt.Errorf("tool %s: credentials: %w", name, err)
+	}
+	for _, method := range tool.Methods {
+		if !validMethods[strings.ToUpper(method)] {
+			return fmt.Errorf("tool %s: unsupported method %s", name, method)
+		}
+	}
+	if tool.HealthCheck != "" && !strings.HasPrefix(tool.HealthCheck, "/") {
+		return fmt.Errorf("tool %s: health_check must be a path starting with /", name)
+	}
+	if tool.SPIFFEID != "" && !strings.HasPrefix(tool.SPIFFEID, "spiffe://") {
+		return fmt.Errorf("tool %s: spiffe_id must be a spiffe:// ID", name)
+	}
+
+	return nil
+}
+
+// validateTools checks egress, plugins and every tool in the registry
+func (c *Config) validateTools() error {
+	if c.Egress.PinTTL < 0 {
+		return fmt.Errorf("egress.pin_ttl must not be negative")
+	}
+	for i, allowed := range c.Egress.Allow {
+		if allowed == "" || strings.Contains(allowed, "/") {
+			return fmt.Errorf("egress.allow[%d] must be a host or host:port", i)
+		}
+	}
+	pluginNames := make(map[string]bool)
+	for i, p := range c.Plugins {
+		if p.Name == "" || p.Path == "" {
+			return fmt.Errorf("plugins[%d] requires a name and a path", i)
+		}
+		if pluginNames[p.Name] {
+			return fmt.Errorf("plugins: %s is configured twice", p.Name)
+		}
+		pluginNames[p.Name] = true
+		if p.Timeout < 0 {
+			return fmt.Errorf("plugins.%s: timeout must not be negative", p.Name)
+		}
+	}
+	for name, tool := range c.Tools {
+		if err := ValidateTool(name, tool); err != nil {
+			return err
+		}
+		if tool.SPIFFEID != "" && !c.SPIFFE.Enabled() {
+			return fmt.Errorf("tool %s: spiffe_id requires spiffe.socket_path", name)
+		}
+		if tool.Credentials.UsesScheme(SecretSchemeVault) && c.Secrets.Vault.Address == "" {
+			return fmt.Errorf("tool %s: vault credentials require secrets.vault.address", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package deadletter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxEntries bounds the store when no limit is configured
+const DefaultMaxEntries = 1000
+
+// Entry is an allowed call that could not be delivered to its tool. It holds
+// everything needed to send the call again.
+type Entry struct {
+	ID      string `json:"id"`
+	AgentID string `json:"agent_id"`
+	Tool    string `json:"tool"`
+	Action  string `json:"action"`
+	Method  string `json:"method"`
+
+	// Target is the action plus any resource path and query string
+	Target string          `json:"target"`
+	Header http.Header     `json:"header,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store keeps undelivered calls, persisted to a file when a path is
+// configured. The oldest entries are dropped once it is full.
+//
+// The file is a journal with one JSON record per line: each change is
+// appended to it, and it is compacted to the live entries once it holds
+// more records than twice the store's capacity. Compaction writes the new
+// file outside the lock, so captures don't wait on it.
+type Store struct {
+	path       string
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+
+	// records counts the lines in the journal
+	records int
+
+	// compacting is set while the journal is rewritten; tail holds the
+	// records appended meanwhile, to be carried over to the new file
+	compacting bool
+	tail       [][]byte
+}
+
+// Journal record operations
+const (
+	opAdd    = "add"
+	opFailed = "failed"
+	opRemove = "remove"
+)
+
+// record is one line of the journal
+type record struct {
+	Op        string     `json:"op"`
+	Entry     *Entry     `json:"entry,omitempty"`
+	ID        string     `json:"id,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// NewStore opens the store at path, creating it on first write. A store
+// written as a single JSON array by earlier versions is read and compacted
+// to a journal.
+func NewStore(path string, maxEntries int) (*Store, error) {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	s := &Store{path: path, maxEntries: maxEntries, entries: make(map[string]*Entry)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter store: %w", err)
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	legacy := len(trimmed) > 0 && trimmed[0] == '['
+	if legacy {
+		var entries []*Entry
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse dead-letter store: %w", err)
+		}
+		for _, e := range entries {
+			s.entries[e.ID] = e
+		}
+	} else if err := s.replay(data); err != nil {
+		return nil, err
+	}
+	for len(s.entries) > s.maxEntries {
+		delete(s.entries, s.sortedLocked()[0].ID)
+	}
+	if legacy || s.records > len(s.entries) {
+		s.compacting = true
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// replay applies the journal's records in order. A last line cut short by
+// a crash is ignored.
+func (s *Store) replay(data []byte) error {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if i == len(lines)-1 {
+				break
+			}
+			return fmt.Errorf("failed to parse dead-letter store line %d: %w", i+1, err)
+		}
+		s.records++
+		switch rec.Op {
+		case opAdd:
+			if rec.Entry != nil {
+				s.entries[rec.Entry.ID] = rec.Entry
+			}
+		case opFailed:
+			if e, ok := s.entries[rec.ID]; ok {
+				e.Attempts++
+				e.Error = rec.Error
+				if rec.UpdatedAt != nil {
+					e.UpdatedAt = *rec.UpdatedAt
+				}
+			}
+		case opRemove:
+			delete(s.entries, rec.ID)
+		}
+	}
+	return nil
+}
+
+// Add records an undelivered call and returns its ID
+func (s *Store) Add(e Entry) (string, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("failed to generate entry ID: %w", err)
+	}
+	now := time.Now().UTC()
+	e.ID = "dl_" + hex.EncodeToString(idBytes)
+	e.Attempts = 1
+	e.CreatedAt = now
+	e.UpdatedAt = now
+
+	s.mu.Lock()
+	var records []record
+	for len(s.entries) >= s.maxEntries {
+		oldest := s.sortedLocked()[0].ID
+		delete(s.entries, oldest)
+		records = append(records, record{Op: opRemove, ID: oldest})
+	}
+	s.entries[e.ID] = &e
+	records = append(records, record{Op: opAdd, Entry: &e})
+	err := s.appendLocked(records...)
+	compact := s.startCompactionLocked()
+	s.mu.Unlock()
+
+	if compact {
+		if cerr := s.compact(); err == nil {
+			err = cerr
+		}
+	}
+	return e.ID, err
+}
+
+// List returns the entries oldest first
+func (s *Store) List() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sorted := s.sortedLocked()
+	entries := make([]Entry, len(sorted))
+	for i, e := range sorted {
+		entries[i] = *e
+	}
+	return entries
+}
+
+// Get returns the entry with the given ID
+func (s *Store) Get(id string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+	if !ok {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+// Failed records another unsuccessful delivery attempt
+func (s *Store) Failed(id, reason string) error {
+	s.mu.Lock()
+	e, ok := s.entries[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("unknown dead letter %s", id)
+	}
+	e.Attempts++
+	e.Error = reason
+	e.UpdatedAt = time.Now().UTC()
+	err := s.appendLocked(record{Op: opFailed, ID: id, Error: reason, UpdatedAt: &e.UpdatedAt})
+	compact := s.startCompactionLocked()
+	s.mu.Unlock()
+
+	if compact {
+		if cerr := s.compact(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Remove deletes an entry, e.g. once it has been delivered
+func (s *Store) Remove(id string) error {
+	s.mu.Lock()
+	if _, ok := s.entries[id]; !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("unknown dead letter %s", id)
+	}
+	delete(s.entries, id)
+	err := s.appendLocked(record{Op: opRemove, ID: id})
+	compact := s.startCompactionLocked()
+	s.mu.Unlock()
+
+	if compact {
+		if cerr := s.compact(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// sortedLocked returns the entries by creation time
+func (s *Store) sortedLocked() []*Entry {
+	entries := make([]*Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+	return entries
+}
+
+// appendLocked writes records to the end of the journal. Entries hold
+// request bodies, so the file is only readable by the gateway user.
+func (s *Store) appendLocked(records ...record) error {
+	if s.path == "" {
+		return nil
+	}
+
+	var data []byte
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("failed to encode dead letter: %w", err)
+		}
+		line = append(line, '\n')
+		data = append(data, line...)
+		if s.compacting {
+			s.tail = append(s.tail, line)
+		}
+	}
+	s.records += len(records)
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to write dead-letter store: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write dead-letter store: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write dead-letter store: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write dead-letter store: %w", err)
+	}
+	return nil
+}
+
+// startCompactionLocked reports whether the journal has grown enough to be
+// compacted and no other compaction is running, claiming it if so
+func (s *Store) startCompactionLocked() bool {
+	if s.path == "" || s.compacting || s.records <= 2*s.maxEntries {
+		return false
+	}
+	s.compacting = true
+	return true
+}
+
+// compact atomically replaces the journal with one add record per live
+// entry. The entries are encoded under the lock but written without it;
+// records appended in the meantime are carried over before the new file
+// takes the old one's place.
+func (s *Store) compact() error {
+	s.mu.Lock()
+	var data []byte
+	sorted := s.sortedLocked()
+	for _, e := range sorted {
+		line, err := json.Marshal(record{Op: opAdd, Entry: e})
+		if err != nil {
+			s.compacting = false
+			s.mu.Unlock()
+			return fmt.Errorf("failed to encode dead-letter store: %w", err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	s.tail = nil
+	s.mu.Unlock()
+
+	tmp, err := s.writeTemp(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() { s.compacting, s.tail = false, nil }()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to compact dead-letter store: %w", err)
+	}
+	for _, line := range s.tail {
+		if _, err := f.Write(line); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to compact dead-letter store: %w", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to compact dead-letter store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to compact dead-letter store: %w", err)
+	}
+	s.records = len(sorted) + len(s.tail)
+	return nil
+}
+
+// writeTemp writes data to a new file next to the store and returns its name
+func (s *Store) writeTemp(data []byte) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return "", fmt.Errorf("failed to compact dead-letter store: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".dead-letter-*.jsonl")
+	if err != nil {
+		return "", fmt.Errorf("failed to compact dead-letter store: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to compact dead-letter store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to compact dead-letter store: %w", err)
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,142 @@
+package deadletter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStorePersistsJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letter.json")
+	s, err := NewStore(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		id, err := s.Add(Entry{Tool: "payments", Action: "create", Error: "unreachable"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := s.Failed(ids[1], "still unreachable"); err != nil {
+		t.Fatal(err)
+	}
+	// Four adds, the eviction of the oldest entry and the failed attempt
+	if lines := journalLines(t, path); lines != 6 {
+		t.Fatalf("journal has %d records, want one per change", lines)
+	}
+	// The seventh record takes the journal over twice the capacity
+	if err := s.Remove(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if lines := journalLines(t, path); lines != 2 {
+		t.Fatalf("journal was not compacted: %d records", lines)
+	}
+
+	reopened, err := NewStore(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := reopened.List()
+	if len(entries) != 2 || entries[0].ID != ids[1] || entries[1].ID != ids[3] {
+		t.Fatalf("got %+v", entries)
+	}
+	if entries[0].Attempts != 2 || entries[0].Error != "still unreachable" {
+		t.Fatalf("failed attempt was not kept: %+v", entries[0])
+	}
+}
+
+func journalLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestStoreCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letter.json")
+	s, err := NewStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := s.Add(Entry{Tool: "payments"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lines := journalLines(t, path); lines > 5 {
+		t.Fatalf("journal grew to %d records", lines)
+	}
+	reopened, err := NewStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reopened.List(), s.List(); len(got) != 2 || got[0].ID != want[0].ID || got[1].ID != want[1].ID {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestStoreReadsArrayFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letter.json")
+	legacy := `[{"id":"dl_1","tool":"payments","action":"create","method":"POST","target":"create","error":"x","attempts":1,"created_at":"2026-01-01T00:00:00Z","updated_at":"2026-01-01T00:00:00Z"}]`
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStore(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Get("dl_1"); !ok {
+		t.Fatal("entry was not read")
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(data), `{"op":"add"`) {
+		t.Fatalf("store was not converted: %s", data)
+	}
+}
+
+// Records appended while the journal is compacted must survive it
+func TestStoreCompactsUnderConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letter.json")
+	s, err := NewStore(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				id, err := s.Add(Entry{Tool: "payments"})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				s.Failed(id, "again")
+			}
+		}()
+	}
+	wg.Wait()
+
+	reopened, err := NewStore(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := reopened.List(), s.List()
+	if len(got) != len(want) {
+		t.Fatalf("reopened %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || got[i].Attempts != want[i].Attempts {
+			t.Fatalf("entry %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
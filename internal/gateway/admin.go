@@ -0,0 +1,375 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// requireAdmin authenticates admin requests by bearer token and checks the
+// caller's role against the request. Every attempt, allowed or not, is
+// written to the admin audit trail. The admin API is disabled when no
+// credentials are configured.
+func (g *Gateway) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g.mu.RLock()
+		principals := g.config.Admin.Principals()
+		g.mu.RUnlock()
+
+		if len(principals) == 0 {
+			writeError(w, "Admin API is disabled", http.StatusNotFound)
+			return
+		}
+
+		// A zero limit records the status without keeping the body
+		start := time.Now()
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		var user config.AdminUser
+		defer func() {
+			g.telemetry.LogAdmin(telemetry.AdminAuditLog{
+				RequestID:  r.Header.Get(requestIDHeader),
+				Principal:  user.Name,
+				Role:       user.Role,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Status:     rec.status,
+				RemoteAddr: r.RemoteAddr,
+				LatencyMS:  time.Since(start).Milliseconds(),
+			})
+		}()
+
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			user, ok = matchAdmin(principals, presented)
+		}
+		if !ok {
+			rec.Header().Set("WWW-Authenticate", `Bearer realm="aegis-admin"`)
+			writeError(rec, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !normalizedPath(r.URL.Path) {
+			writeError(rec, "Path must be normalized", http.StatusBadRequest)
+			return
+		}
+		if required := requiredAdminRole(r); !config.RoleAllows(user.Role, required) {
+			writeError(rec, fmt.Sprintf("Role %s cannot %s %s; %s required", user.Role, r.Method, r.URL.Path, required), http.StatusForbidden)
+			return
+		}
+
+		next(rec, r)
+	}
+}
+
+// matchAdmin returns the principal whose token equals presented. Every
+// token is compared so timing doesn't reveal which one matched.
+func matchAdmin(principals []config.AdminUser, presented string) (config.AdminUser, bool) {
+	var match config.AdminUser
+	found := false
+	for _, p := range principals {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(p.Token)) == 1 && !found {
+			match, found = p, true
+		}
+	}
+	return match, found
+}
+
+// normalizedPath reports whether p has no empty, . or .. segments, so the
+// handler acts on the path the role was decided for. A trailing slash is
+// allowed.
+func normalizedPath(p string) bool {
+	trimmed := strings.TrimSuffix(p, "/")
+	return trimmed == "" || path.Clean(trimmed) == trimmed
+}
+
+// requiredAdminRole is the least role that may make an admin request. API
+// keys and tool registration need admin, other reads need viewer and every
+// other change needs operator.
+func requiredAdminRole(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/admin/keys"),
+		isToolRegistration(r):
+		return config.AdminRoleAdmin
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return config.AdminRoleViewer
+	default:
+		return config.AdminRoleOperator
+	}
+}
+
+// registerAdminRoutes adds the admin API to mux
+func (g *Gateway) registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/policies", g.requireAdmin(g.HandleAdminPolicies))
+	mux.HandleFunc("/admin/policies/", g.requireAdmin(g.HandleAdminPolicies))
+	mux.HandleFunc("/admin/decisions", g.requireAdmin(g.HandleAdminDecisions))
+	mux.HandleFunc("/v1/decisions", g.requireAdmin(g.HandleDecisions))
+	mux.HandleFunc("/v1/decisions/stream", g.requireAdmin(g.HandleDecisionStream))
+	mux.HandleFunc("/v1/analytics/agents/", g.requireAdmin(g.HandleAgentAnalytics))
+	mux.HandleFunc("/admin/tools", g.requireAdmin(g.HandleAdminTools))
+	mux.HandleFunc("/admin/tools/", g.requireAdmin(g.HandleAdminTools))
+	mux.HandleFunc("/admin/keys", g.requireAdmin(g.HandleAdminKeys))
+	mux.HandleFunc("/admin/keys/", g.requireAdmin(g.HandleAdminKeys))
+	mux.HandleFunc("/admin/dead_letters", g.requireAdmin(g.HandleAdminDeadLetters))
+	mux.HandleFunc("/admin/dead_letters/", g.requireAdmin(g.HandleAdminDeadLetters))
+	mux.HandleFunc("/admin/anomalies", g.requireAdmin(g.HandleAdminAnomalies))
+	mux.HandleFunc("/admin/anomalies/", g.requireAdmin(g.HandleAdminAnomalies))
+	mux.HandleFunc("/admin/quarantine", g.requireAdmin(g.HandleAdminQuarantine))
+	mux.HandleFunc("/admin/quarantine/", g.requireAdmin(g.HandleAdminQuarantine))
+	mux.HandleFunc("/admin/openapi.json", g.requireAdmin(g.HandleAdminOpenAPI))
+}
+
+// HandleAdminPolicies serves GET /admin/policies (the loaded policy set) and
+// POST /admin/policies/reload (re-read the policy files)
+func (g *Gateway) HandleAdminPolicies(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/policies"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		writeJSON(w, http.StatusOK, g.policyEngine.Export())
+	case r.Method == http.MethodPost && path == "reload":
+		err := g.policyEngine.Reload()
+		status := g.policyEngine.Status()
+		result := map[string]interface{}{"status": "reloaded", "files": status.Files, "rules": status.Rules}
+		if err != nil {
+			logger.Warn("Admin policy reload had errors", "error", err)
+			result["status"] = "partial"
+			result["error"] = err.Error()
+			writeJSON(w, http.StatusUnprocessableEntity, result)
+			return
+		}
+		logger.Info("Admin reloaded policy files", "files", status.Files)
+		writeJSON(w, http.StatusOK, result)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, "Expected GET /admin/policies or POST /admin/policies/reload", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAdminDecisions serves GET /admin/decisions with the latest decisions,
+// newest first. agent, tool and allowed filter them; limit defaults to 100.
+func (g *Gateway) HandleAdminDecisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 100
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			writeError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	agent, tool, session, allowed := query.Get("agent"), query.Get("tool"), query.Get("session"), query.Get("allowed")
+	if allowed != "" && allowed != "true" && allowed != "false" {
+		writeError(w, "allowed must be true or false", http.StatusBadRequest)
+		return
+	}
+
+	decisions := g.telemetry.RecentDecisions(limit, func(d telemetry.DecisionLog) bool {
+		return (agent == "" || d.AgentID == agent) &&
+			(tool == "" || d.ToolName == tool) &&
+			(session == "" || d.SessionID == session) &&
+			(allowed == "" || d.Decision == allowed)
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"decisions": decisions})
+}
+
+// toolRegistration is the body of POST /admin/tools
+type toolRegistration struct {
+	Name          string   `json:"name"`
+	URL           string   `json:"url"`
+	Timeout       string   `json:"timeout,omitempty"`
+	Retries       int      `json:"retries,omitempty"`
+	Credentials   string   `json:"credentials,omitempty"`
+	Methods       []string `json:"methods,omitempty"`
+	HealthCheck   string   `json:"health_check,omitempty"`
+	Discovery     string   `json:"discovery,omitempty"`
+	OnPolicyError string   `json:"on_policy_error,omitempty"`
+}
+
+// adminToolName is the tool a /admin/tools path names, empty for the
+// collection
+func adminToolName(p string) string {
+	return strings.Trim(strings.TrimPrefix(p, "/admin/tools"), "/")
+}
+
+// isToolRegistration reports whether r registers a tool, which lets the
+// caller choose where the tool's credentials are sent
+func isToolRegistration(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/admin/tools") && adminToolName(r.URL.Path) == ""
+}
+
+// HandleAdminTools serves GET /admin/tools (list), POST /admin/tools
+// (register or replace a tool) and DELETE /admin/tools/:name (drain a tool)
+func (g *Gateway) HandleAdminTools(w http.ResponseWriter, r *http.Request) {
+	name := adminToolName(r.URL.Path)
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		g.listTools(w)
+	case isToolRegistration(r):
+		g.registerTool(w, r)
+	case r.Method == http.MethodDelete && name != "":
+		g.drainTool(w, name)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, "Expected GET or POST /admin/tools, or DELETE /admin/tools/:name", http.StatusMethodNotAllowed)
+	}
+}
+
+// listTools reports every registered tool with its current instances and
+// circuit breaker state
+func (g *Gateway) listTools(w http.ResponseWriter) {
+	tools := make([]map[string]interface{}, 0)
+	for _, name := range g.tools.Names() {
+		tool, ok := g.tools.Get(name)
+		if !ok {
+			continue
+		}
+		running, queued := tool.Limiter.InFlight()
+		entry := map[string]interface{}{
+			"name":      tool.Name,
+			"protocol":  tool.Protocol,
+			"instances": tool.Instances(),
+			"methods":   tool.Methods,
+			"timeout":   tool.Timeout.String(),
+			"circuit":   tool.Breaker.State(),
+			"in_flight": running,
+			"queued":    queued,
+			"stats":     tool.Stats.Snapshot(),
+		}
+		if tool.Decoy {
+			entry["decoy"] = true
+		}
+		if len(tool.DecoyActions) > 0 {
+			entry["decoy_actions"] = tool.DecoyActions
+		}
+		if canary := tool.Canary; canary != nil {
+			entry["canary"] = map[string]interface{}{
+				"instances": canary.Instances(),
+				"weight":    tool.CanaryWeight,
+				"circuit":   canary.Breaker.State(),
+				"stats":     canary.Stats.Snapshot(),
+			}
+		}
+		tools = append(tools, entry)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tools": tools})
+}
+
+// registerTool adds or replaces a tool in the registry and config file
+func (g *Gateway) registerTool(w http.ResponseWriter, r *http.Request) {
+	var reg toolRegistration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		writeError(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	tool := config.ToolConfig{
+		URL:           reg.URL,
+		Retries:       reg.Retries,
+		Credentials:   config.CredentialsConfig{Secret: reg.Credentials},
+		Methods:       reg.Methods,
+		HealthCheck:   reg.HealthCheck,
+		Discovery:     reg.Discovery,
+		OnPolicyError: reg.OnPolicyError,
+	}
+	if reg.Timeout != "" {
+		timeout, err := time.ParseDuration(reg.Timeout)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Invalid timeout: %v", err), http.StatusBadRequest)
+			return
+		}
+		tool.Timeout = timeout
+	}
+	if err := config.ValidateTool(reg.Name, tool); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	persisted, err := g.updateTool(reg.Name, &tool)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to persist tool registry: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Admin registered tool", "tool", reg.Name, "url", reg.URL)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"tool":      reg.Name,
+		"status":    "registered",
+		"persisted": persisted,
+	})
+}
+
+// drainTool removes a tool so no new requests are forwarded to it.
+// In-flight requests finish against the definition they started with.
+func (g *Gateway) drainTool(w http.ResponseWriter, name string) {
+	if _, ok := g.tools.Get(name); !ok {
+		writeError(w, fmt.Sprintf("Unknown tool: %s", name), http.StatusNotFound)
+		return
+	}
+
+	persisted, err := g.updateTool(name, nil)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to persist tool registry: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Policies still granting the tool now reference an unregistered backend
+	referencedBy := g.policyEngine.AgentsUsingTool(name)
+	if len(referencedBy) > 0 {
+		logger.Warn("Drained tool is still referenced by agents", "tool", name, "agents", referencedBy)
+	}
+
+	logger.Info("Admin drained tool", "tool", name)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"tool":          name,
+		"status":        "drained",
+		"persisted":     persisted,
+		"referenced_by": referencedBy,
+	})
+}
+
+// updateTool registers tool as name in a copy of the tool registry config,
+// or removes name when tool is nil, swaps it in and writes the change to the
+// config file when the gateway was loaded from one
+func (g *Gateway) updateTool(name string, tool *config.ToolConfig) (persisted bool, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tools := make(map[string]config.ToolConfig, len(g.config.Tools)+1)
+	for name, tool := range g.config.Tools {
+		tools[name] = tool
+	}
+	if tool != nil {
+		tools[name] = *tool
+	} else {
+		delete(tools, name)
+	}
+
+	if g.config.Path != "" {
+		if err := config.SaveTool(g.config.Path, name, tool); err != nil {
+			return false, err
+		}
+		persisted = true
+	}
+
+	updated := *g.config
+	updated.Tools = tools
+	g.config = &updated
+	g.tools.Load(tools)
+	return persisted, nil
+}
@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"aegis-gateway/internal/config"
+)
+
+func TestRequiredAdminRole(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         string
+	}{
+		{http.MethodGet, "/admin/tools", config.AdminRoleViewer},
+		{http.MethodPost, "/admin/tools", config.AdminRoleAdmin},
+		{http.MethodPost, "/admin/tools/", config.AdminRoleAdmin},
+		{http.MethodPost, "/admin/tools//", config.AdminRoleAdmin},
+		{http.MethodDelete, "/admin/tools/search", config.AdminRoleOperator},
+		{http.MethodGet, "/admin/keys", config.AdminRoleAdmin},
+		{http.MethodPost, "/admin/keys/", config.AdminRoleAdmin},
+		{http.MethodPost, "/admin/policies/reload", config.AdminRoleOperator},
+		{http.MethodGet, "/v1/decisions", config.AdminRoleViewer},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := requiredAdminRole(r); got != tt.want {
+			t.Errorf("%s %s: got %s, want %s", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizedPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/admin/tools", true},
+		{"/admin/tools/", true},
+		{"/admin/policies/reload", true},
+		{"/admin/tools//", false},
+		{"/admin//tools", false},
+		{"/admin/keys/../tools", false},
+		{"/admin/./tools", false},
+	}
+	for _, tt := range tests {
+		if got := normalizedPath(tt.path); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+// An operator must not register a tool, whose credentials it could send
+// to a URL of its choosing, through any spelling of the collection path
+func TestOperatorCannotRegisterTool(t *testing.T) {
+	t.Setenv("AEGIS_TEST_OPERATOR_TOKEN", "operator-token")
+	g := newTestGateway(t, "version: \"1\"\n", func(cfg *config.Config) {
+		cfg.Admin.Users = []config.AdminUser{{Name: "ops", Token: "env:AEGIS_TEST_OPERATOR_TOKEN", Role: config.AdminRoleOperator}}
+	})
+	mux := http.NewServeMux()
+	g.registerAdminRoutes(mux)
+
+	body := `{"name":"exfil","url":"http://attacker.example","credentials":"env:AEGIS_ADMIN_TOKEN"}`
+	for _, path := range []string{"/admin/tools", "/admin/tools/"} {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer operator-token")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("POST %s: got %d, want 403", path, w.Code)
+		}
+	}
+	if _, ok := g.tools.Get("exfil"); ok {
+		t.Fatal("operator registered a tool")
+	}
+}
+
+func TestAdminRoles(t *testing.T) {
+	t.Setenv("AEGIS_TEST_VIEWER_TOKEN", "viewer-token")
+	t.Setenv("AEGIS_TEST_OPERATOR_TOKEN", "operator-token")
+	t.Setenv("AEGIS_TEST_ADMIN_TOKEN", "admin-token")
+	g := newTestGateway(t, "version: \"1\"\n", func(cfg *config.Config) {
+		cfg.Admin.Users = []config.AdminUser{
+			{Name: "auditor", Token: "env:AEGIS_TEST_VIEWER_TOKEN", Role: config.AdminRoleViewer},
+			{Name: "ops", Token: "env:AEGIS_TEST_OPERATOR_TOKEN", Role: config.AdminRoleOperator},
+			{Name: "root", Token: "env:AEGIS_TEST_ADMIN_TOKEN", Role: config.AdminRoleAdmin},
+		}
+	})
+	mux := http.NewServeMux()
+	g.registerAdminRoutes(mux)
+
+	tests := []struct {
+		method, path string
+		token        string
+		wantStatus   int // 0 when the request reaches the handler
+	}{
+		{http.MethodGet, "/admin/policies", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/policies", "wrong-token", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/policies", "viewer-token", 0},
+		{http.MethodPost, "/admin/policies/reload", "viewer-token", http.StatusForbidden},
+		{http.MethodPost, "/admin/policies/reload", "operator-token", 0},
+		{http.MethodPost, "/admin/quarantine/finance-agent/release", "viewer-token", http.StatusForbidden},
+		{http.MethodGet, "/admin/keys", "operator-token", http.StatusForbidden},
+		{http.MethodGet, "/admin/keys", "admin-token", 0},
+		{http.MethodPost, "/admin/tools", "operator-token", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		denied := w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden
+		if (tt.wantStatus != 0 && w.Code != tt.wantStatus) || (tt.wantStatus == 0 && denied) {
+			t.Errorf("%s %s as %q: got %d, want %d", tt.method, tt.path, tt.token, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestAdminDisabledWithoutCredentials(t *testing.T) {
+	g := newTestGateway(t, "version: \"1\"\n", nil)
+	mux := http.NewServeMux()
+	g.registerAdminRoutes(mux)
+	r := httptest.NewRequest(http.MethodGet, "/admin/policies", nil)
+	r.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got %d", w.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// defaultAnalyticsWindow is the window of GET /v1/analytics/agents/:id
+// without since
+const defaultAnalyticsWindow = 24 * time.Hour
+
+// agentAnalytics is the response of GET /v1/analytics/agents/:id
+type agentAnalytics struct {
+	telemetry.AgentAnalytics
+
+	// Budgets are the agent's current budget windows, from the limit
+	// state rather than the window asked for
+	Budgets []policy.BudgetUsage `json:"budgets"`
+}
+
+// HandleAgentAnalytics serves GET /v1/analytics/agents/:id, a summary of
+// the agent's calls from the decision store between since (default 24h
+// ago) and until (default now), along with its budget use
+func (g *Gateway) HandleAgentAnalytics(w http.ResponseWriter, r *http.Request) {
+	agentID := strings.TrimPrefix(r.URL.Path, "/v1/analytics/agents/")
+	if agentID == "" || strings.Contains(agentID, "/") {
+		writeError(w, "Invalid path. Expected: /v1/analytics/agents/:id", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	since, err := parseDecisionTime(r.URL.Query().Get("since"))
+	if err != nil {
+		writeError(w, "since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := parseDecisionTime(r.URL.Query().Get("until"))
+	if err != nil {
+		writeError(w, "until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if since.IsZero() {
+		since = now.Add(-defaultAnalyticsWindow)
+	}
+	if until.IsZero() {
+		until = now
+	}
+	if !since.Before(until) {
+		writeError(w, "since must be before until", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := g.telemetry.AnalyzeAgent(r.Context(), agentID, since, until)
+	switch {
+	case errors.Is(err, telemetry.ErrNoDecisionStore):
+		writeError(w, "Decision store is not configured; set telemetry.store", http.StatusNotImplemented)
+		return
+	case err != nil:
+		logger.Error("Agent analytics query failed", "agent_id", agentID, "error", err)
+		writeError(w, "Agent analytics query failed", http.StatusInternalServerError)
+		return
+	}
+	budgets, err := g.policyEngine.BudgetUsage(agentID)
+	if err != nil {
+		logger.Error("Budget usage is unavailable", "agent_id", agentID, "error", err)
+		writeError(w, "Budget usage is unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, agentAnalytics{AgentAnalytics: summary, Budgets: budgets})
+}
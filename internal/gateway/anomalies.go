@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/anomaly"
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/quarantine"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// checkHold denies the calls of an agent held after an anomaly before they
+// are evaluated, so they don't count against its limits
+func (g *Gateway) checkHold(evaluate func(*policy.Request) policy.Decision) func(*policy.Request) policy.Decision {
+	return func(req *policy.Request) policy.Decision {
+		hold, held := g.holds.Held(req.AgentID)
+		if !held {
+			return evaluate(req)
+		}
+		return policy.Decision{
+			Code:   anomaly.CodeApprovalRequired,
+			Reason: fmt.Sprintf("Calls of %s require approval after a %s anomaly", req.AgentID, hold.AnomalyType),
+		}
+	}
+}
+
+// observeCall has the anomaly detector learn from an evaluated call and
+// logs the anomalies it shows. Calls denied by a hold or quarantine aren't
+// learned from.
+func (g *Gateway) observeCall(ctx context.Context, identity *Identity, req *policy.Request, decision policy.Decision) {
+	if g.anomalies == nil || decision.Code == anomaly.CodeApprovalRequired || decision.Code == quarantine.CodeAgentQuarantined {
+		return
+	}
+	amount, _ := req.Params["amount"].(float64)
+	found := g.anomalies.Observe(anomaly.Call{
+		Time:      time.Now(),
+		AgentID:   req.AgentID,
+		Tool:      req.Tool,
+		Allowed:   decision.Allowed,
+		Amount:    amount,
+		MaxAmount: decision.MaxAmount,
+	})
+	for _, a := range found {
+		action := config.AnomalyActionLog
+		if a.Held {
+			action = config.AnomalyActionRequireApproval
+		}
+		g.telemetry.LogAnomaly(ctx, telemetry.Anomaly{
+			Type:          a.Type,
+			Reason:        a.Reason,
+			Action:        action,
+			DecisionID:    decision.ID,
+			AgentID:       req.AgentID,
+			AgentVerified: identity.Verified(),
+			SessionID:     req.SessionID,
+			Tool:          req.Tool,
+			ToolAction:    req.Action,
+			Allowed:       decision.Allowed,
+			Code:          decision.Code,
+		})
+	}
+}
+
+// HandleAdminAnomalies serves GET /admin/anomalies (the held agents and the
+// latest anomalies and tripwires) and POST /admin/anomalies/:agent/approve
+// (release a held agent)
+func (g *Gateway) HandleAdminAnomalies(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/anomalies"), "/")
+	agentID, approve := strings.CutSuffix(path, "/approve")
+
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		anomalies := g.telemetry.RecentDecisions(100, func(d telemetry.DecisionLog) bool {
+			return d.Phase == telemetry.PhaseAnomaly
+		})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"holds": g.holds.List(), "anomalies": anomalies})
+	case r.Method == http.MethodPost && approve && agentID != "" && !strings.Contains(agentID, "/"):
+		if !g.holds.Approve(agentID) {
+			writeError(w, fmt.Sprintf("Agent %s is not held", agentID), http.StatusNotFound)
+			return
+		}
+		logger.Info("Admin approved held agent", "agent_id", agentID)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"agent_id": agentID, "approved": true})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, "Expected GET /admin/anomalies or POST /admin/anomalies/:agent/approve", http.StatusMethodNotAllowed)
+	}
+}
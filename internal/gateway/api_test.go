@@ -0,0 +1,38 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"aegis-gateway/internal/policy"
+)
+
+func TestHandlePolicies(t *testing.T) {
+	g := newTestGateway(t, rateLimitedPolicy, nil)
+	tests := []struct {
+		method     string
+		wantStatus int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodPost, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		g.HandlePolicies(w, httptest.NewRequest(tt.method, "/v1/policies", nil))
+		if w.Code != tt.wantStatus {
+			t.Fatalf("%s: got %d", tt.method, w.Code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var snapshot policy.Snapshot
+		if err := json.Unmarshal(w.Body.Bytes(), &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshot.Rules) != 1 || snapshot.Rules[0].AgentID != "finance-agent" || snapshot.Rules[0].Conditions["rate_limit"] == nil {
+			t.Fatalf("got %+v", snapshot)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"aegis-gateway/internal/policy"
+)
+
+// maxBatchCalls bounds the number of calls in one batch evaluation
+const maxBatchCalls = 100
+
+// batchCall is one prospective call of a plan. ID is echoed back so agents
+// can match decisions to their steps.
+type batchCall struct {
+	ID       string          `json:"id,omitempty"`
+	Tool     string          `json:"tool"`
+	Action   string          `json:"action"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	Method   string          `json:"method,omitempty"`
+	Resource string          `json:"resource,omitempty"`
+}
+
+// batchDecision is the decision for one call of a batch
+type batchDecision struct {
+	ID         string `json:"id,omitempty"`
+	Tool       string `json:"tool"`
+	Action     string `json:"action"`
+	Allowed    bool   `json:"allowed"`
+	Code       string `json:"code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+	DecisionID string `json:"decision_id,omitempty"`
+}
+
+// HandleEvaluateBatch serves POST /v1/evaluate/batch. It evaluates every
+// call of a plan for the authenticated agent and returns one decision per
+// call, in order, without forwarding anything. Calls are checked against
+// the agent's current rate limit and budget usage without consuming it.
+func (g *Gateway) HandleEvaluateBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, ok := g.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	identity, authErr := g.resolveIdentity(r, body)
+	if authErr != nil {
+		writeAuthError(w, r, authErr)
+		return
+	}
+
+	var req struct {
+		Calls []batchCall `json:"calls"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Calls) == 0 || len(req.Calls) > maxBatchCalls {
+		writeError(w, fmt.Sprintf("calls must list between 1 and %d calls", maxBatchCalls), http.StatusBadRequest)
+		return
+	}
+
+	decisions := make([]batchDecision, len(req.Calls))
+	allowed := true
+	parent := traceContext(r.Header)
+	for i, call := range req.Calls {
+		decisions[i] = g.precheckCall(parent, identity, call)
+		allowed = allowed && decisions[i].Allowed
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"allowed":   allowed,
+		"decisions": decisions,
+	})
+}
+
+// precheckCall evaluates one call of a batch
+func (g *Gateway) precheckCall(parent context.Context, identity *Identity, call batchCall) batchDecision {
+	start := time.Now()
+	result := batchDecision{ID: call.ID, Tool: call.Tool, Action: call.Action}
+	if call.Tool == "" || call.Action == "" {
+		result.Code = policy.CodeInvalidParameter
+		result.Reason = "tool and action are required"
+		return result
+	}
+
+	params := make(map[string]interface{})
+	if len(call.Params) > 0 && string(call.Params) != "null" {
+		if err := json.Unmarshal(call.Params, &params); err != nil {
+			result.Code = policy.CodeInvalidParameter
+			result.Reason = "params must be a JSON object"
+			return result
+		}
+	}
+
+	decision := g.precheck(parent, start, identity, &policy.Request{
+		Tool:     call.Tool,
+		Action:   call.Action,
+		Method:   call.Method,
+		Resource: call.Resource,
+		Params:   params,
+		BodySize: len(call.Params),
+	})
+	result.Allowed = decision.Allowed
+	result.Code = decision.Code
+	result.Reason = decision.Reason
+	result.RetryAfter = decision.RetryAfterSeconds()
+	result.DecisionID = decision.ID
+	return result
+}
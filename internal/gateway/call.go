@@ -0,0 +1,247 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+)
+
+// toolCall is an allowed call on its way to a tool
+type toolCall struct {
+	method string
+	action string
+
+	// target is the action plus any escaped resource path and query string
+	target string
+	body   requestBody
+
+	idempotent bool
+	agentID    string
+	rules      *policy.ResponseRules
+
+	// header holds the agent's headers to forward, see agentHeaders
+	header http.Header
+
+	// schemaVersion picks the response schema; "" is the current version
+	schemaVersion string
+
+	// files and filesRoot are the operation of a call to a files tool and
+	// the directory the allowing rule confines it to
+	files     *fileCommand
+	filesRoot string
+
+	// fetch and fetchMaxBytes are the request of a call to a fetch tool
+	// and the response size the allowing rule reads
+	fetch         *fetchCall
+	fetchMaxBytes int64
+}
+
+// callTool forwards an allowed call and buffers the tool's response, for
+// front-ends that wrap the answer in their own envelope (gRPC Invoke, MCP).
+// Concurrency and circuit breaker rejections are returned as errors, as is a
+// *responseViolation when rules block the response. GraphQL, SQL, exec,
+// files, email and fetch tools are only called through
+// /tools/:tool/:action, where their queries, commands, paths, messages and
+// URLs are parsed for policy.
+func (g *Gateway) callTool(ctx, spanCtx context.Context, upstream *registry.Tool, action string, body []byte, idempotent bool, identity *Identity, rules *policy.ResponseRules) (int, []byte, error) {
+	switch upstream.Protocol {
+	case registry.ProtocolGraphQL:
+		return 0, nil, fmt.Errorf("tool %s is a GraphQL API; POST queries to /tools/%s/query", upstream.Name, upstream.Name)
+	case registry.ProtocolSQL:
+		return 0, nil, fmt.Errorf("tool %s is a SQL database; POST statements to /tools/%s/select", upstream.Name, upstream.Name)
+	case registry.ProtocolExec:
+		return 0, nil, fmt.Errorf("tool %s runs commands; POST them to /tools/%s/:binary", upstream.Name, upstream.Name)
+	case registry.ProtocolFiles:
+		return 0, nil, fmt.Errorf("tool %s serves files; call /tools/%s/read, write, list or delete", upstream.Name, upstream.Name)
+	case registry.ProtocolEmail:
+		return 0, nil, fmt.Errorf("tool %s sends email; POST messages to /tools/%s/send", upstream.Name, upstream.Name)
+	case registry.ProtocolFetch:
+		return 0, nil, fmt.Errorf("tool %s fetches URLs; POST them to /tools/%s/fetch", upstream.Name, upstream.Name)
+	}
+	buf, err := g.sendCall(ctx, spanCtx, upstream, toolCall{
+		method:        http.MethodPost,
+		action:        action,
+		target:        action,
+		body:          jsonBody(body),
+		idempotent:    idempotent,
+		agentID:       identity.AgentID,
+		rules:         rules,
+		schemaVersion: identity.SchemaVersion,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return buf.status, buf.body.Bytes(), nil
+}
+
+// sendCall is callTool for any method and target, returning the buffered
+// response with its headers. The body has already been inspected.
+func (g *Gateway) sendCall(ctx, spanCtx context.Context, upstream *registry.Tool, call toolCall) (*responseBuffer, error) {
+	if upstream.Protocol == registry.ProtocolMCP {
+		return nil, fmt.Errorf("tool %s is an MCP server; connect to /mcp/%s", upstream.Name, upstream.Name)
+	}
+
+	upstream = upstream.Route(call.agentID)
+	ctx = withTrace(ctx, spanCtx)
+
+	release, err := upstream.Limiter.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tool %s is at capacity: %w", upstream.Name, err)
+	}
+	defer release()
+
+	done, _, ok := upstream.Allow()
+	if !ok {
+		return nil, fmt.Errorf("tool %s is failing; requests are suspended", upstream.Name)
+	}
+
+	// status stays 0 unless the tool answers
+	var status int
+	forwardStart := time.Now()
+	defer func() {
+		g.telemetry.LogForwardedCall(spanCtx, upstream.Name, call.action, upstream.Target, status, time.Since(forwardStart)).End()
+	}()
+
+	buf := newResponseBuffer()
+	if upstream.Protocol == registry.ProtocolGRPC {
+		body, ok := call.body.(jsonBody)
+		if !ok {
+			done(true)
+			return nil, fmt.Errorf("tool %s does not accept multipart uploads", upstream.Name)
+		}
+		out, err := g.invokeGRPCTool(ctx, upstream, call.action, body)
+		done(err == nil)
+		if err != nil {
+			return nil, err
+		}
+		buf.header.Set("Content-Type", "application/json")
+		buf.body.Write(out)
+	} else if upstream.Protocol == registry.ProtocolSQL {
+		// Async calls were evaluated on the same statement when queued
+		body, _ := call.body.(jsonBody)
+		stmt, err := parseSQLStatement(body)
+		var out []byte
+		if err == nil {
+			out, err = g.execSQL(ctx, upstream, stmt)
+		}
+		done(err == nil || sqlQueryFailed(err))
+		if err != nil {
+			return nil, err
+		}
+		buf.header.Set("Content-Type", "application/json")
+		buf.body.Write(out)
+	} else if upstream.Protocol == registry.ProtocolExec {
+		body, _ := call.body.(jsonBody)
+		cmd, err := parseExecCommand(upstream, call.action, body)
+		var out []byte
+		if err == nil {
+			out, err = g.runExec(ctx, upstream, cmd)
+		}
+		done(err == nil)
+		if err != nil {
+			return nil, err
+		}
+		buf.header.Set("Content-Type", "application/json")
+		buf.body.Write(out)
+	} else if upstream.Protocol == registry.ProtocolFiles {
+		if call.files == nil {
+			done(true)
+			return nil, fmt.Errorf("tool %s serves files; call /tools/%s/read, write, list or delete", upstream.Name, upstream.Name)
+		}
+		out, err := g.runFiles(ctx, upstream, call.files, call.filesRoot)
+		done(err == nil || isFileError(err))
+		if err != nil {
+			return nil, err
+		}
+		buf.header.Set("Content-Type", "application/json")
+		buf.body.Write(out)
+	} else if upstream.Protocol == registry.ProtocolEmail {
+		body, _ := call.body.(jsonBody)
+		msg, err := parseEmailMessage(upstream, body)
+		var out []byte
+		if err == nil {
+			out, err = g.sendEmail(ctx, upstream, msg, call.agentID)
+		}
+		done(err == nil || emailRejected(err))
+		if err != nil {
+			return nil, err
+		}
+		buf.header.Set("Content-Type", "application/json")
+		buf.body.Write(out)
+	} else if upstream.Protocol == registry.ProtocolFetch {
+		if call.fetch == nil {
+			done(true)
+			return nil, fmt.Errorf("tool %s fetches URLs; POST them to /tools/%s/fetch", upstream.Name, upstream.Name)
+		}
+		out, err := g.runFetch(ctx, upstream, call.fetch, call.fetchMaxBytes)
+		done(true)
+		if err != nil {
+			return nil, err
+		}
+		buf.header.Set("Content-Type", "application/json")
+		buf.body.Write(out)
+	} else {
+		code, err := g.forwardRequest(ctx, upstream, outboundRequest(call.method, call.header), call.target, call.body, call.idempotent, buf)
+		done(err == nil && code < http.StatusInternalServerError)
+		if err != nil {
+			return nil, fmt.Errorf("failed to forward request: %w", err)
+		}
+	}
+	status = buf.status
+
+	if violation := g.checkResponse(spanCtx, upstream, call.agentID, call.action, call.schemaVersion, buf); violation != nil {
+		return nil, violation
+	}
+	if call.rules != nil {
+		out, violation := g.inspectResponse(spanCtx, call.agentID, upstream.Name, call.action, call.rules, buf.header, buf.body.Bytes())
+		if violation != nil {
+			return nil, violation
+		}
+		buf.body.Reset()
+		buf.body.Write(out)
+	}
+	return buf, nil
+}
+
+// callErrorBody describes a callTool error for the agent
+func callErrorBody(err error) *problem {
+	var violation *responseViolation
+	if errors.As(err, &violation) {
+		return violation.body()
+	}
+	return newProblem(problemUpstreamError, http.StatusBadGateway, err.Error())
+}
+
+// responseBuffer captures a forwarded HTTP response
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: http.Header{}, status: http.StatusOK}
+}
+
+func (b *responseBuffer) Header() http.Header         { return b.header }
+func (b *responseBuffer) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *responseBuffer) WriteHeader(status int)      { b.status = status }
+
+// flush sends the buffered response to w, replacing w's headers with its
+// own. Buffers that wrap w start from a clone of w's headers.
+func (b *responseBuffer) flush(w http.ResponseWriter) {
+	for key := range w.Header() {
+		w.Header().Del(key)
+	}
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"net/http"
+
+	"aegis-gateway/internal/policy"
+)
+
+// capabilities is the body of GET /v1/capabilities
+type capabilities struct {
+	AgentID      string              `json:"agent_id"`
+	Groups       []string            `json:"groups,omitempty"`
+	Capabilities []policy.Capability `json:"capabilities"`
+}
+
+// HandleCapabilities serves GET /v1/capabilities with the actions the
+// authenticated agent holds a rule for on registered tools, and the
+// conditions of each rule, so planners can rule out calls that would be
+// denied. ?tool= narrows the list to one tool.
+func (g *Gateway) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	identity, authErr := g.resolveIdentity(r, nil)
+	if authErr != nil {
+		writeAuthError(w, r, authErr)
+		return
+	}
+
+	tool := r.URL.Query().Get("tool")
+	result := capabilities{AgentID: identity.AgentID, Groups: identity.Groups, Capabilities: []policy.Capability{}}
+	for _, c := range g.policyEngine.Capabilities(identity.AgentID, identity.Groups) {
+		if tool != "" && c.Tool != tool {
+			continue
+		}
+		if _, ok := g.tools.Get(c.Tool); !ok {
+			continue
+		}
+		result.Capabilities = append(result.Capabilities, c)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"encoding/json"
+	"strings"
+
+	"aegis-gateway/internal/config"
+)
+
+// callPayload is the call a decision record carries encrypted when
+// telemetry.payload_key is set
+type callPayload struct {
+	Method   string                 `json:"method,omitempty"`
+	Resource string                 `json:"resource,omitempty"`
+	Params   map[string]interface{} `json:"params"`
+}
+
+// captureParams returns the params a tool's capture rules record, by path,
+// or nil if it records none. Paths the call doesn't have are left out.
+func captureParams(params map[string]interface{}, rules config.CaptureConfig) map[string]interface{} {
+	var captured map[string]interface{}
+	record := func(path string, value interface{}) {
+		if captured == nil {
+			captured = make(map[string]interface{})
+		}
+		captured[path] = value
+	}
+	for _, path := range rules.Cleartext {
+		if value, ok := paramAt(params, path); ok {
+			record(path, value)
+		}
+	}
+	for _, path := range rules.Masked {
+		if value, ok := paramAt(params, path); ok {
+			record(path, maskParam(value))
+		}
+	}
+	return captured
+}
+
+// paramAt looks up a param by dotted path
+func paramAt(params map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = params
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// maskParam hides all but the last four characters of a string or number,
+// or all of it if it is shorter than eight. Objects and lists are hidden
+// entirely.
+func maskParam(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case map[string]interface{}, []interface{}:
+		return "****"
+	default:
+		data, _ := json.Marshal(v)
+		s = string(data)
+	}
+	runes := []rune(s)
+	if len(runes) < 8 {
+		return "****"
+	}
+	return "****" + string(runes[len(runes)-4:])
+}
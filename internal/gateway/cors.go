@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"aegis-gateway/internal/config"
+)
+
+// withCORS answers CORS preflights and adds CORS headers to responses for
+// allowed origins. settings picks the listener's CORS config, which is read
+// on every request so reloads apply without a restart.
+func (g *Gateway) withCORS(next http.Handler, settings func(*config.Config) config.CORSConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.RLock()
+		cors := settings(g.config)
+		g.mu.RUnlock()
+
+		origin := r.Header.Get("Origin")
+		if !cors.Enabled() || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cors = cors.WithDefaults()
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !cors.AllowsOrigin(origin) {
+			if preflight {
+				writeError(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			// Without CORS headers the browser withholds the response
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		if cors.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			h.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+		if cors.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
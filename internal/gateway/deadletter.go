@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"aegis-gateway/internal/deadletter"
+	"aegis-gateway/internal/registry"
+)
+
+// deadLetter keeps an allowed call that could not be delivered and returns
+// its entry ID, or "" when capture is disabled or doesn't apply. Uploads
+// are not captured since their files are removed after the request.
+func (g *Gateway) deadLetter(upstream *registry.Tool, call toolCall, cause error) string {
+	body, ok := call.body.(jsonBody)
+	if g.deadLetters == nil || !ok || upstream.Protocol != registry.ProtocolHTTP {
+		return ""
+	}
+
+	id, err := g.deadLetters.Add(deadletter.Entry{
+		AgentID: call.agentID,
+		Tool:    upstream.Name,
+		Action:  call.action,
+		Method:  call.method,
+		Target:  call.target,
+		Header:  call.header,
+		Body:    json.RawMessage(body),
+		Error:   cause.Error(),
+	})
+	if err != nil {
+		logger.Error("Failed to record dead letter", "tool", upstream.Name, "action", call.action, "error", err)
+	}
+	return id
+}
+
+// deadLetterWriter captures a call whose tool still answers with a server
+// error once retries are spent, so the entry ID can be set on the response
+// before it is relayed
+type deadLetterWriter struct {
+	http.ResponseWriter
+	capture func(status int) string
+}
+
+func (dw *deadLetterWriter) WriteHeader(status int) {
+	if status >= http.StatusInternalServerError {
+		if id := dw.capture(status); id != "" {
+			dw.Header().Set("X-Aegis-Dead-Letter-ID", id)
+		}
+	}
+	dw.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController flush the underlying writer
+func (dw *deadLetterWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
+
+// HandleAdminDeadLetters serves GET /admin/dead_letters (list),
+// GET /admin/dead_letters/:id (inspect), POST /admin/dead_letters/:id/replay
+// and DELETE /admin/dead_letters/:id (discard)
+func (g *Gateway) HandleAdminDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if g.deadLetters == nil {
+		writeError(w, "Dead-letter capture is disabled", http.StatusNotFound)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/dead_letters"), "/")
+	id, replay := strings.CutSuffix(path, "/replay")
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		entries := g.deadLetters.List()
+		for i := range entries {
+			entries[i].Header, entries[i].Body = nil, nil
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"entries": entries})
+	case r.Method == http.MethodGet && !replay:
+		entry, ok := g.deadLetters.Get(id)
+		if !ok {
+			writeError(w, fmt.Sprintf("Unknown dead letter: %s", id), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, entry)
+	case r.Method == http.MethodPost && replay && id != "":
+		g.replayDeadLetter(w, r, id)
+	case r.Method == http.MethodDelete && id != "" && !replay:
+		if err := g.deadLetters.Remove(id); err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logger.Info("Admin discarded dead letter", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, "Expected GET /admin/dead_letters[/:id], POST /admin/dead_letters/:id/replay or DELETE /admin/dead_letters/:id", http.StatusMethodNotAllowed)
+	}
+}
+
+// replayDeadLetter sends an entry to its tool again. Delivered entries are
+// removed; failed ones stay with the new error. The call was allowed when
+// it was captured and is not evaluated again.
+func (g *Gateway) replayDeadLetter(w http.ResponseWriter, r *http.Request, id string) {
+	entry, ok := g.deadLetters.Get(id)
+	if !ok {
+		writeError(w, fmt.Sprintf("Unknown dead letter: %s", id), http.StatusNotFound)
+		return
+	}
+
+	upstream, exists := g.tools.Get(entry.Tool)
+	if !exists {
+		writeError(w, fmt.Sprintf("Unknown tool: %s", entry.Tool), http.StatusConflict)
+		return
+	}
+
+	logger.Info("Admin replayed dead letter", "id", id, "tool", entry.Tool, "action", entry.Action)
+	buf, err := g.sendCall(r.Context(), r.Context(), upstream, toolCall{
+		method:  entry.Method,
+		action:  entry.Action,
+		target:  entry.Target,
+		body:    jsonBody(entry.Body),
+		agentID: entry.AgentID,
+		header:  entry.Header,
+	})
+	if err != nil || buf.status >= http.StatusInternalServerError {
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = fmt.Sprintf("tool %s returned %d", entry.Tool, buf.status)
+		}
+		if ferr := g.deadLetters.Failed(id, reason); ferr != nil {
+			logger.Error("Failed to update dead letter", "id", id, "error", ferr)
+		}
+		writeUpstreamError(w, http.StatusBadGateway, reason)
+		return
+	}
+
+	if err := g.deadLetters.Remove(id); err != nil {
+		logger.Error("Failed to remove dead letter", "id", id, "error", err)
+	}
+	result := map[string]interface{}{"id": id, "delivered": true, "status": buf.status}
+	if raw := buf.body.Bytes(); json.Valid(raw) {
+		result["body"] = json.RawMessage(raw)
+	} else {
+		result["body"] = string(raw)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
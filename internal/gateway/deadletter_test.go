@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// A tool that still answers with a server error after retries has not done
+// the work either, so the call is kept for replay
+func TestDeadLetterCapturesServerErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	g := newTestGateway(t, reportsPolicy, func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{
+			"reports": {URL: server.URL, Timeout: config.DefaultToolTimeout},
+		}
+		cfg.DeadLetter.Enabled = true
+	})
+	call := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/tools/reports/generate"+query, strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Agent-ID", "report-agent")
+		w := httptest.NewRecorder()
+		g.HandleRequest(w, r)
+		return w
+	}
+
+	w := call("")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	id := w.Header().Get("X-Aegis-Dead-Letter-ID")
+	if entry, ok := g.deadLetters.Get(id); !ok || !strings.Contains(entry.Error, "503") {
+		t.Fatalf("call was not captured: %q %+v", id, entry)
+	}
+
+	if w := call("?mode=async"); w.Code != http.StatusAccepted {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(g.deadLetters.List()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("failed job was not captured")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"aegis-gateway/pkg/telemetry"
+)
+
+// streamKeepalive is how often an idle decision stream sends a keepalive,
+// so proxies don't close it
+const streamKeepalive = 15 * time.Second
+
+// decisionEvent is a message on a decision stream WebSocket
+type decisionEvent struct {
+	// Type is decision, or dropped when decisions were skipped because
+	// the client fell behind
+	Type     string                 `json:"type"`
+	Decision *telemetry.DecisionLog `json:"decision,omitempty"`
+	Dropped  int64                  `json:"dropped,omitempty"`
+}
+
+// HandleDecisionStream serves GET /v1/decisions/stream, pushing decisions
+// as they are made. agent, tool, session, code and result filter them as
+// for GET /v1/decisions. A WebSocket upgrade request gets a WebSocket;
+// anything else gets server-sent events.
+func (g *Gateway) HandleDecisionStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := decisionQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub := g.telemetry.SubscribeDecisions(q.Matches)
+	defer sub.Close()
+	if websocket.IsWebSocketUpgrade(r) {
+		g.streamDecisionsWebSocket(w, r, sub)
+		return
+	}
+	g.streamDecisionsSSE(w, r, sub)
+}
+
+// streamDecisionsSSE writes each decision as a decision event, with the
+// decision ID as the event ID, until the client goes away
+func (g *Gateway) streamDecisionsSSE(w http.ResponseWriter, r *http.Request, sub *telemetry.DecisionSubscription) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		logger.Warn("Decision stream can't be flushed", "error", err)
+		return
+	}
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+	var reported int64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case entry, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if dropped := sub.Dropped(); dropped > reported {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped-reported)
+				reported = dropped
+			}
+			data, _ := json.Marshal(entry)
+			fmt.Fprintf(w, "event: decision\nid: %s\ndata: %s\n\n", entry.DecisionID, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// streamDecisionsWebSocket sends each decision as a JSON text message
+// until the client closes the connection
+func (g *Gateway) streamDecisionsWebSocket(w http.ResponseWriter, r *http.Request, sub *telemetry.DecisionSubscription) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+	defer conn.Close()
+
+	// The client only sends control messages; reading handles them and
+	// notices when it goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(1024)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+	var reported int64
+	for {
+		var err error
+		select {
+		case <-closed:
+			return
+		case <-keepalive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+		case entry, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if dropped := sub.Dropped(); dropped > reported {
+				if err = conn.WriteJSON(decisionEvent{Type: "dropped", Dropped: dropped - reported}); err != nil {
+					return
+				}
+				reported = dropped
+			}
+			err = conn.WriteJSON(decisionEvent{Type: "decision", Decision: &entry})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"aegis-gateway/pkg/telemetry"
+)
+
+// defaultDecisionPage is the page size of GET /v1/decisions without limit
+const defaultDecisionPage = 100
+
+// HandleDecisions serves GET /v1/decisions from the decision store, newest
+// first. agent, tool, session, code and result (allow or deny) filter the
+// decisions, since and until bound their time, and cursor continues from
+// the next cursor of the previous page.
+func (g *Gateway) HandleDecisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q, err := decisionQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Limit = defaultDecisionPage
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > telemetry.MaxDecisionPage {
+			writeError(w, fmt.Sprintf("limit must be between 1 and %d", telemetry.MaxDecisionPage), http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	page, err := g.telemetry.QueryDecisions(r.Context(), q)
+	switch {
+	case errors.Is(err, telemetry.ErrNoDecisionStore):
+		writeError(w, "Decision store is not configured; set telemetry.store", http.StatusNotImplemented)
+	case errors.Is(err, telemetry.ErrInvalidCursor):
+		writeError(w, "Invalid cursor", http.StatusBadRequest)
+	case err != nil:
+		logger.Error("Decision query failed", "error", err)
+		writeError(w, "Decision query failed", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// decisionQuery parses the filters of GET /v1/decisions and its stream
+func decisionQuery(query url.Values) (telemetry.DecisionQuery, error) {
+	q := telemetry.DecisionQuery{
+		Agent:   query.Get("agent"),
+		Tool:    query.Get("tool"),
+		Session: query.Get("session"),
+		Code:    query.Get("code"),
+		Result:  query.Get("result"),
+		Cursor:  query.Get("cursor"),
+	}
+	if q.Result != "" && q.Result != "allow" && q.Result != "deny" {
+		return q, fmt.Errorf("result must be allow or deny")
+	}
+	var err error
+	if q.Since, err = parseDecisionTime(query.Get("since")); err != nil {
+		return q, fmt.Errorf("since: %w", err)
+	}
+	if q.Until, err = parseDecisionTime(query.Get("until")); err != nil {
+		return q, fmt.Errorf("until: %w", err)
+	}
+	return q, nil
+}
+
+// parseDecisionTime parses an RFC 3339 time, or a duration such as 24h
+// meaning that long ago. Empty is the zero time.
+func parseDecisionTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("must be an RFC 3339 time or a duration such as 24h")
+}
@@ -0,0 +1,151 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/registry"
+)
+
+// dialFunc is the signature of net.Dialer.DialContext
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// egressPins holds the addresses tool hosts resolved to, so connections
+// keep going to them until they are re-resolved
+type egressPins struct {
+	mu    sync.Mutex
+	hosts map[string]*egressPin
+}
+
+type egressPin struct {
+	mu       sync.Mutex
+	addrs    []string
+	resolved time.Time
+}
+
+// egressSettings returns the egress config in effect
+func (g *Gateway) egressSettings() config.EgressConfig {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.config.Egress
+}
+
+// checkEgress returns an error unless addr, a host:port, is declared in
+// the tool registry or allowed by egress.allow
+func (g *Gateway) checkEgress(addr string) error {
+	egress := g.egressSettings()
+	if egress.Disabled || g.tools.Declares(addr) || egress.Allows(addr) {
+		return nil
+	}
+	return fmt.Errorf("egress to %s is not allowed: it is not a declared tool host", addr)
+}
+
+// egressDial wraps dial so it only connects to allowed hosts, at their
+// pinned addresses
+func (g *Gateway) egressDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if g.egressSettings().Disabled {
+			return dial(ctx, network, addr)
+		}
+		targets, err := g.pinnedTargets(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		var errs []error
+		for _, target := range targets {
+			conn, err := dial(ctx, network, target)
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+// pinnedTargets checks that addr, a host:port, is allowed and returns its
+// pinned addresses with the port
+func (g *Gateway) pinnedTargets(ctx context.Context, addr string) ([]string, error) {
+	if err := g.checkEgress(addr); err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := g.pinned(ctx, host, orDefault(g.egressSettings().PinTTL, config.DefaultEgressPinTTL))
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, len(ips))
+	for i, ip := range ips {
+		targets[i] = net.JoinHostPort(ip, port)
+	}
+	return targets, nil
+}
+
+// pinned returns the addresses host is pinned to, resolving it when it
+// has none or they are older than ttl. A failed lookup keeps the previous
+// addresses.
+func (g *Gateway) pinned(ctx context.Context, host string, ttl time.Duration) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	key := strings.ToLower(host)
+
+	p := &g.pins
+	p.mu.Lock()
+	pin, ok := p.hosts[key]
+	if !ok {
+		if p.hosts == nil {
+			p.hosts = make(map[string]*egressPin)
+		}
+		pin = &egressPin{}
+		p.hosts[key] = pin
+	}
+	p.mu.Unlock()
+
+	pin.mu.Lock()
+	defer pin.mu.Unlock()
+	if len(pin.addrs) > 0 && time.Since(pin.resolved) < ttl {
+		return pin.addrs, nil
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		if len(pin.addrs) == 0 {
+			return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+		}
+		logger.Warn("Failed to re-resolve host, keeping its pinned addresses", "host", host, "error", err)
+		pin.resolved = time.Now()
+		return pin.addrs, nil
+	}
+	pin.addrs, pin.resolved = addrs, time.Now()
+	return addrs, nil
+}
+
+// checkRedirect refuses redirects to hosts egress doesn't allow. The
+// dialer would refuse them too, but not when they are reached through an
+// outbound proxy.
+func (g *Gateway) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Scheme == registry.SchemeUnix {
+		if _, _, ok := g.tools.UnixSocket(req.URL); !ok {
+			return fmt.Errorf("refusing redirect: %s is not under a declared unix socket", req.URL)
+		}
+		return nil
+	}
+	if err := g.checkEgress(registry.HostPort(req.URL.String())); err != nil {
+		return fmt.Errorf("refusing redirect: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"aegis-gateway/internal/config"
+)
+
+// connectProxy is an HTTP proxy that records the targets it is asked to
+// reach and tunnels CONNECT requests to them
+type connectProxy struct {
+	*httptest.Server
+	mu      sync.Mutex
+	targets []string
+}
+
+func newConnectProxy(t *testing.T) *connectProxy {
+	p := &connectProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.targets = append(p.targets, r.Host)
+		p.mu.Unlock()
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *connectProxy) seen() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+// Proxy environment variables would route calls around egress pinning, so
+// a tool without transport.proxy connects directly
+func TestEgressIgnoresProxyEnvironment(t *testing.T) {
+	p := newConnectProxy(t)
+	t.Setenv("HTTP_PROXY", p.URL)
+	t.Setenv("HTTPS_PROXY", p.URL)
+	t.Setenv("NO_PROXY", "")
+
+	g := newTestGateway(t, "version: 1\nagents: []\n", func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{"reports": {URL: "http://reports.aegis-test.invalid"}}
+	})
+	tool, _ := g.tools.Get("reports")
+	client, err := g.upstreamClient(tool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Get("http://reports.aegis-test.invalid/summary"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("reached an unresolvable host, status %s", resp.Status)
+	}
+	if seen := p.seen(); len(seen) != 0 {
+		t.Fatalf("proxy from the environment was used for %v", seen)
+	}
+}
+
+// Through a configured proxy, the tunnel goes to the tool host's pinned
+// address and undeclared hosts are refused before the proxy is asked
+func TestProxyTunnelsToPinnedAddress(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	_, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+	p := newConnectProxy(t)
+
+	g := newTestGateway(t, "version: 1\nagents: []\n", func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{"reports": {
+			URL:       "http://localhost:" + port,
+			Transport: config.TransportConfig{Proxy: p.URL},
+		}}
+	})
+	tool, _ := g.tools.Get("reports")
+	client, err := g.upstreamClient(tool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get("http://localhost:" + port + "/summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatalf("got %s %q", resp.Status, body)
+	}
+	seen := p.seen()
+	if len(seen) == 0 {
+		t.Fatal("call didn't go through the proxy")
+	}
+	for _, target := range seen {
+		host, _, _ := net.SplitHostPort(target)
+		if net.ParseIP(host) == nil {
+			t.Errorf("proxy was asked for %s, not a pinned address", target)
+		}
+	}
+
+	if resp, err := client.Get("http://127.0.0.2:" + port + "/"); err == nil {
+		resp.Body.Close()
+		t.Error("reached an undeclared host through the proxy")
+	}
+	if n := len(p.seen()); n != len(seen) {
+		t.Errorf("proxy was asked for an undeclared host: %v", p.seen()[len(seen):])
+	}
+}
@@ -0,0 +1,564 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// emailRequest is the body of a call to an email tool. Attachment content
+// is base64-encoded.
+type emailRequest struct {
+	To          []string                 `json:"to"`
+	Cc          []string                 `json:"cc,omitempty"`
+	Bcc         []string                 `json:"bcc,omitempty"`
+	ReplyTo     string                   `json:"reply_to,omitempty"`
+	Subject     string                   `json:"subject"`
+	Text        string                   `json:"text,omitempty"`
+	HTML        string                   `json:"html,omitempty"`
+	Attachments []emailAttachmentRequest `json:"attachments,omitempty"`
+}
+
+// emailAttachmentRequest is a file attached to an emailRequest
+type emailAttachmentRequest struct {
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+}
+
+// emailReceipt is the response of an email tool to a message it sent
+type emailReceipt struct {
+	MessageID  string `json:"message_id"`
+	ProviderID string `json:"provider_id,omitempty"`
+	Recipients int    `json:"recipients"`
+}
+
+// emailMessage is a parsed call to an email tool
+type emailMessage struct {
+	policy.EmailMessage
+	to, cc, bcc []*mail.Address
+	replyTo     *mail.Address
+	subject     string
+	text, html  string
+	attachments []emailAttachment
+}
+
+// emailAttachment is a decoded attachment. contentType is derived from the
+// filename, so it can't disagree with what policy checked.
+type emailAttachment struct {
+	policy.EmailAttachment
+	contentType string
+	data        []byte
+}
+
+// emailRejectedError is the mail server or API refusing a message, e.g. for
+// an unknown recipient, rather than being unreachable
+type emailRejectedError struct {
+	message string
+}
+
+func (e *emailRejectedError) Error() string {
+	return e.message
+}
+
+// emailRejected reports whether err is a message being refused
+func emailRejected(err error) bool {
+	var rejected *emailRejectedError
+	return errors.As(err, &rejected)
+}
+
+// parseEmail parses calls to email tools, POSTed to /tools/:tool/send, so
+// policy sees the recipients and attachments
+func (g *Gateway) parseEmail(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		tool, ok := g.tools.Get(c.Tool)
+		if !ok || tool.Protocol != registry.ProtocolEmail {
+			next(w, c)
+			return
+		}
+
+		r := c.Request
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, fmt.Sprintf("Tool %s sends email; POST messages to /tools/%s/send", c.Tool, c.Tool), http.StatusMethodNotAllowed)
+			return
+		}
+		if c.multipart || c.Resource != "" || c.Action != "send" {
+			writeError(w, fmt.Sprintf("Tool %s sends email; POST messages to /tools/%s/send", c.Tool, c.Tool), http.StatusBadRequest)
+			return
+		}
+
+		msg, err := parseEmailMessage(tool, c.bodyBytes)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.email = msg
+		next(w, c)
+	}
+}
+
+// parseEmailMessage checks the addresses, subject and attachments of a
+// call to an email tool and decodes the attachments
+func parseEmailMessage(tool *registry.Tool, body []byte) (*emailMessage, error) {
+	var req emailRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, errors.New("Email calls must be a JSON object with to, subject and text or html")
+	}
+	if len(req.To) == 0 {
+		return nil, errors.New("At least one recipient in to is required")
+	}
+	if strings.TrimSpace(req.Subject) == "" || strings.ContainsAny(req.Subject, "\r\n") {
+		return nil, errors.New("Subject is required and must be a single line")
+	}
+	if req.Text == "" && req.HTML == "" {
+		return nil, errors.New("Text or html is required")
+	}
+
+	msg := &emailMessage{subject: req.Subject, text: req.Text, html: req.HTML}
+	seen := make(map[string]bool)
+	parseList := func(field string, list []string) ([]*mail.Address, error) {
+		addresses := make([]*mail.Address, 0, len(list))
+		for _, s := range list {
+			addr, err := mail.ParseAddress(s)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s address %q", field, s)
+			}
+			addr.Address = strings.ToLower(addr.Address)
+			if !seen[addr.Address] {
+				seen[addr.Address] = true
+				msg.Recipients = append(msg.Recipients, addr.Address)
+			}
+			addresses = append(addresses, addr)
+		}
+		return addresses, nil
+	}
+	var err error
+	if msg.to, err = parseList("to", req.To); err != nil {
+		return nil, err
+	}
+	if msg.cc, err = parseList("cc", req.Cc); err != nil {
+		return nil, err
+	}
+	if msg.bcc, err = parseList("bcc", req.Bcc); err != nil {
+		return nil, err
+	}
+	if req.ReplyTo != "" {
+		if msg.replyTo, err = mail.ParseAddress(req.ReplyTo); err != nil {
+			return nil, fmt.Errorf("Invalid reply_to address %q", req.ReplyTo)
+		}
+	}
+
+	limit := tool.Email.MaxAttachmentBytes
+	if limit == 0 {
+		limit = config.DefaultEmailMaxAttachmentBytes
+	}
+	var total int64
+	for _, a := range req.Attachments {
+		if a.Filename == "" || strings.ContainsAny(a.Filename, "/\\\r\n\x00") {
+			return nil, fmt.Errorf("Attachment filename %q must be a plain file name", a.Filename)
+		}
+		data, err := base64.StdEncoding.DecodeString(a.Content)
+		if err != nil {
+			return nil, fmt.Errorf("Content of attachment %s is not valid base64", a.Filename)
+		}
+		if total += int64(len(data)); total > limit {
+			return nil, fmt.Errorf("Attachments exceed max_attachment_bytes=%d", limit)
+		}
+		contentType, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(a.Filename)))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		msg.attachments = append(msg.attachments, emailAttachment{
+			EmailAttachment: policy.EmailAttachment{Filename: a.Filename, Size: len(data)},
+			contentType:     contentType,
+			data:            data,
+		})
+		msg.Attachments = append(msg.Attachments, policy.EmailAttachment{Filename: a.Filename, Size: len(data)})
+	}
+	return msg, nil
+}
+
+// sendEmail delivers a message through an email tool's provider, records
+// it in the email audit log whether or not it was delivered, and returns
+// the receipt as JSON. The sender is always the tool's email.from.
+func (g *Gateway) sendEmail(ctx context.Context, tool *registry.Tool, msg *emailMessage, agentID string) ([]byte, error) {
+	if tool.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tool.Timeout)
+		defer cancel()
+	}
+	from, err := mail.ParseAddress(tool.Email.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email.from of tool %s: %w", tool.Name, err)
+	}
+	messageID, err := newMessageID(from.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	var providerID string
+	if tool.Email.Provider == config.EmailProviderSendGrid {
+		providerID, err = g.sendGridEmail(ctx, tool, from, messageID, msg)
+	} else {
+		err = g.smtpEmail(ctx, tool, from, messageID, msg)
+	}
+	g.auditEmail(ctx, tool, agentID, from, messageID, providerID, msg, time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(emailReceipt{MessageID: messageID, ProviderID: providerID, Recipients: len(msg.Recipients)})
+}
+
+// auditEmail logs the metadata of a message: addresses, subject, sizes and
+// attachment hashes, but not the body
+func (g *Gateway) auditEmail(ctx context.Context, tool *registry.Tool, agentID string, from *mail.Address, messageID, providerID string, msg *emailMessage, latency time.Duration, sendErr error) {
+	addresses := func(list []*mail.Address) []string {
+		out := make([]string, 0, len(list))
+		for _, a := range list {
+			out = append(out, a.Address)
+		}
+		return out
+	}
+	provider := tool.Email.Provider
+	if provider == "" {
+		provider = config.EmailProviderSMTP
+	}
+	entry := telemetry.EmailAuditLog{
+		AgentID:    agentID,
+		ToolName:   tool.Name,
+		Provider:   provider,
+		MessageID:  messageID,
+		ProviderID: providerID,
+		From:       from.Address,
+		To:         addresses(msg.to),
+		Cc:         addresses(msg.cc),
+		Bcc:        addresses(msg.bcc),
+		Subject:    msg.subject,
+		BodyBytes:  len(msg.text) + len(msg.html),
+		Status:     "sent",
+		LatencyMS:  latency.Milliseconds(),
+	}
+	if msg.replyTo != nil {
+		entry.ReplyTo = msg.replyTo.Address
+	}
+	for _, a := range msg.attachments {
+		sum := sha256.Sum256(a.data)
+		entry.Attachments = append(entry.Attachments, telemetry.EmailAttachmentLog{
+			Filename:    a.Filename,
+			ContentType: a.contentType,
+			Size:        a.Size,
+			SHA256:      hex.EncodeToString(sum[:]),
+		})
+	}
+	if sendErr != nil {
+		entry.Status, entry.Error = "failed", sendErr.Error()
+	}
+	g.telemetry.LogEmail(ctx, entry)
+}
+
+// newMessageID returns a unique Message-ID in the sender's domain
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create message ID: %w", err)
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), from[strings.LastIndex(from, "@")+1:]), nil
+}
+
+// smtpEmail sends a message through the tool's SMTP server. The
+// connection goes through the tool's transport and the egress policy.
+// net/smtp takes no context, so the deadline bounds the whole session.
+func (g *Gateway) smtpEmail(ctx context.Context, tool *registry.Tool, from *mail.Address, messageID string, msg *emailMessage) error {
+	raw, err := buildEmail(from, messageID, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := g.toolTLSConfig(tool)
+	if err != nil {
+		return err
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	host, port, _ := net.SplitHostPort(tool.Email.Address)
+	tlsConfig.ServerName = host
+
+	dial := g.proxyDial(tool.Transport, g.egressDial(newDialer(tool.Transport).DialContext))
+	conn, err := dial(ctx, "tcp", tool.Email.Address)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if port == "465" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if port != "465" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if tool.Credentials.Configured() {
+		g.mu.RLock()
+		store := g.secrets
+		g.mu.RUnlock()
+		username, password, err := store.Login(tool.Credentials)
+		if err != nil {
+			return fmt.Errorf("credentials for tool %s: %w", tool.Name, err)
+		}
+		if err := c.Auth(smtp.PlainAuth("", username, password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return smtpError(err)
+	}
+	for _, rcpt := range msg.Recipients {
+		if err := c.Rcpt(rcpt); err != nil {
+			return smtpError(err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(err)
+	}
+	return c.Quit()
+}
+
+// smtpError marks permanent (5xx) replies of an SMTP server as rejections
+func smtpError(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return &emailRejectedError{message: fmt.Sprintf("Mail server rejected the message: %d %s", reply.Code, reply.Msg)}
+	}
+	return err
+}
+
+// buildEmail renders a message as MIME: the text and HTML bodies as
+// alternatives, followed by the attachments. Bcc recipients get no header.
+func buildEmail(from *mail.Address, messageID string, msg *emailMessage, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	joinAddresses := func(list []*mail.Address) string {
+		s := make([]string, len(list))
+		for i, a := range list {
+			s[i] = a.String()
+		}
+		return strings.Join(s, ", ")
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	header("From", from.String())
+	header("To", joinAddresses(msg.to))
+	if len(msg.cc) > 0 {
+		header("Cc", joinAddresses(msg.cc))
+	}
+	if msg.replyTo != nil {
+		header("Reply-To", msg.replyTo.String())
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", messageID)
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	buf.WriteString("\r\n")
+
+	altBoundary := multipart.NewWriter(io.Discard).Boundary()
+	part, err := mixed.CreatePart(textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + altBoundary}})
+	if err != nil {
+		return nil, err
+	}
+	alt := multipart.NewWriter(part)
+	alt.SetBoundary(altBoundary)
+	for _, body := range []struct{ contentType, text string }{{"text/plain", msg.text}, {"text/html", msg.html}} {
+		if body.text == "" {
+			continue
+		}
+		part, err := alt.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err := qp.Write([]byte(body.text)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := alt.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, a := range msg.attachments {
+		part, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(a.contentType, map[string]string{"name": a.Filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.data)
+		for len(encoded) > 76 {
+			io.WriteString(part, encoded[:76]+"\r\n")
+			encoded = encoded[76:]
+		}
+		io.WriteString(part, encoded+"\r\n")
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sendGridAddress is an address in the SendGrid v3 mail API
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+// sendGridEmail sends a message through the SendGrid v3 mail API with the
+// tool's API key and returns SendGrid's message ID
+func (g *Gateway) sendGridEmail(ctx context.Context, tool *registry.Tool, from *mail.Address, messageID string, msg *emailMessage) (string, error) {
+	addresses := func(list []*mail.Address) []sendGridAddress {
+		out := make([]sendGridAddress, 0, len(list))
+		for _, a := range list {
+			out = append(out, sendGridAddress{Email: a.Address, Name: a.Name})
+		}
+		return out
+	}
+	personalization := map[string]interface{}{"to": addresses(msg.to)}
+	if len(msg.cc) > 0 {
+		personalization["cc"] = addresses(msg.cc)
+	}
+	if len(msg.bcc) > 0 {
+		personalization["bcc"] = addresses(msg.bcc)
+	}
+	payload := map[string]interface{}{
+		"personalizations": []interface{}{personalization},
+		"from":             sendGridAddress{Email: from.Address, Name: from.Name},
+		"subject":          msg.subject,
+		"custom_args":      map[string]string{"message_id": messageID},
+	}
+	if msg.replyTo != nil {
+		payload["reply_to"] = sendGridAddress{Email: msg.replyTo.Address, Name: msg.replyTo.Name}
+	}
+	var content []map[string]string
+	if msg.text != "" {
+		content = append(content, map[string]string{"type": "text/plain", "value": msg.text})
+	}
+	if msg.html != "" {
+		content = append(content, map[string]string{"type": "text/html", "value": msg.html})
+	}
+	payload["content"] = content
+	if len(msg.attachments) > 0 {
+		attachments := make([]map[string]string, 0, len(msg.attachments))
+		for _, a := range msg.attachments {
+			attachments = append(attachments, map[string]string{
+				"content":     base64.StdEncoding.EncodeToString(a.data),
+				"filename":    a.Filename,
+				"type":        a.contentType,
+				"disposition": "attachment",
+			})
+		}
+		payload["attachments"] = attachments
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	base := tool.Email.Address
+	if base == "" {
+		base = config.DefaultSendGridURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(base, "/")+"/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	header, value, err := g.toolCredentials(tool)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(header, value)
+	client, err := g.upstreamClient(tool)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		var problem struct {
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		json.Unmarshal(respBody, &problem)
+		var messages []string
+		for _, e := range problem.Errors {
+			messages = append(messages, e.Message)
+		}
+		reason := fmt.Sprintf("SendGrid returned %d: %s", resp.StatusCode, strings.Join(messages, "; "))
+		if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusRequestEntityTooLarge {
+			return "", &emailRejectedError{message: reason}
+		}
+		return "", errors.New(reason)
+	}
+	return resp.Header.Get("X-Message-Id"), nil
+}
@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+)
+
+// execWaitDelay is how long a command's output is still read after it was
+// killed, e.g. from a child process it left behind
+const execWaitDelay = time.Second
+
+// execRequest is the body of a call to an exec tool
+type execRequest struct {
+	Args  []string          `json:"args,omitempty"`
+	Dir   string            `json:"dir,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+	Stdin string            `json:"stdin,omitempty"`
+}
+
+// execResult is the response of an exec tool. A command that ran is
+// answered with 200 whatever its exit code.
+type execResult struct {
+	ExitCode        int    `json:"exit_code"`
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutTruncated bool   `json:"stdout_truncated,omitempty"`
+	StderrTruncated bool   `json:"stderr_truncated,omitempty"`
+	TimedOut        bool   `json:"timed_out,omitempty"`
+	DurationMS      int64  `json:"duration_ms"`
+}
+
+// execCommand is a parsed call to an exec tool
+type execCommand struct {
+	policy.ExecCommand
+	path  string
+	env   map[string]string
+	stdin string
+}
+
+// parseExec parses calls to exec tools, POSTed to /tools/:tool/:binary, so
+// policy sees the command's arguments, working directory and environment
+func (g *Gateway) parseExec(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		tool, ok := g.tools.Get(c.Tool)
+		if !ok || tool.Protocol != registry.ProtocolExec {
+			next(w, c)
+			return
+		}
+
+		r := c.Request
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, fmt.Sprintf("Tool %s runs commands; POST them to /tools/%s/:binary", c.Tool, c.Tool), http.StatusMethodNotAllowed)
+			return
+		}
+		if c.multipart || c.Resource != "" {
+			writeError(w, fmt.Sprintf("Tool %s runs commands; POST them to /tools/%s/:binary", c.Tool, c.Tool), http.StatusBadRequest)
+			return
+		}
+
+		cmd, err := parseExecCommand(tool, c.Action, c.bodyBytes)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.exec = cmd
+		next(w, c)
+	}
+}
+
+// parseExecCommand resolves the binary of a call to an exec tool and
+// checks its arguments, directory and environment are well-formed
+func parseExecCommand(tool *registry.Tool, binary string, body []byte) (*execCommand, error) {
+	path, ok := tool.Exec.Binaries[binary]
+	if !ok {
+		return nil, fmt.Errorf("Tool %s has no binary %s", tool.Name, binary)
+	}
+
+	var req execRequest
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, errors.New("Exec calls must be a JSON object with args, dir, env and stdin")
+		}
+	}
+	for _, arg := range req.Args {
+		if strings.ContainsRune(arg, 0) {
+			return nil, errors.New("Arguments must not contain NUL bytes")
+		}
+	}
+
+	dir := req.Dir
+	if dir == "" {
+		dir = tool.Exec.Dir
+	}
+	if dir != "" {
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("Working directory %s must be an absolute path", dir)
+		}
+		// Policy checks, and the command runs in, the directory a
+		// symbolic link points to rather than the link
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return nil, fmt.Errorf("Working directory %s does not exist", dir)
+		}
+		dir = resolved
+	}
+
+	cmd := &execCommand{
+		ExecCommand: policy.ExecCommand{Binary: binary, Args: req.Args, Dir: dir},
+		path:        path,
+		env:         req.Env,
+		stdin:       req.Stdin,
+	}
+	for name, value := range req.Env {
+		if !config.ValidEnvName(name) || strings.ContainsRune(value, 0) {
+			return nil, fmt.Errorf("Invalid environment variable %q", name)
+		}
+		if _, set := tool.Exec.Env[name]; set {
+			return nil, fmt.Errorf("Environment variable %s is set by tool %s and can't be overridden", name, tool.Name)
+		}
+		cmd.Env = append(cmd.Env, name)
+	}
+	sort.Strings(cmd.Env)
+	return cmd, nil
+}
+
+// runExec runs a command of an exec tool and returns its result as JSON.
+// The command is killed after the tool's timeout and its output is capped
+// at exec.max_output_bytes per stream.
+func (g *Gateway) runExec(ctx context.Context, tool *registry.Tool, cmd *execCommand) ([]byte, error) {
+	if tool.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tool.Timeout)
+		defer cancel()
+	}
+
+	limit := tool.Exec.MaxOutputBytes
+	if limit == 0 {
+		limit = config.DefaultExecMaxOutputBytes
+	}
+	stdout, stderr := &cappedBuffer{limit: limit}, &cappedBuffer{limit: limit}
+
+	c := exec.CommandContext(ctx, cmd.path, cmd.Args...)
+	c.Dir = cmd.Dir
+	// The tool's variables come last, so they win over the call's
+	c.Env = []string{}
+	for name, value := range cmd.env {
+		c.Env = append(c.Env, name+"="+value)
+	}
+	for name, value := range tool.Exec.Env {
+		c.Env = append(c.Env, name+"="+value)
+	}
+	c.Stdin = strings.NewReader(cmd.stdin)
+	c.Stdout, c.Stderr = stdout, stderr
+	c.WaitDelay = execWaitDelay
+
+	start := time.Now()
+	err := c.Run()
+	result := execResult{
+		Stdout:          stdout.buf.String(),
+		Stderr:          stderr.buf.String(),
+		StdoutTruncated: stdout.truncated,
+		StderrTruncated: stderr.truncated,
+		TimedOut:        errors.Is(ctx.Err(), context.DeadlineExceeded),
+		DurationMS:      time.Since(start).Milliseconds(),
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case result.TimedOut:
+		result.ExitCode = -1
+	default:
+		return nil, fmt.Errorf("failed to run %s: %w", cmd.Binary, err)
+	}
+	return json.Marshal(result)
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a chatty command runs to completion without using up memory
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - int64(b.buf.Len()); int64(len(p)) > room {
+		b.buf.Write(p[:room])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
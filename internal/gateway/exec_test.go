@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/registry"
+)
+
+func TestParseExecCommandEnv(t *testing.T) {
+	tool := &registry.Tool{Name: "shell"}
+	tool.Exec = config.ExecToolConfig{
+		Binaries: map[string]string{"git": "/usr/bin/git"},
+		Env:      map[string]string{"PATH": "/usr/bin", "HOME": "/srv/ci"},
+	}
+
+	tests := []struct {
+		body    string
+		wantErr bool
+	}{
+		{`{"args":["status"]}`, false},
+		{`{"env":{"GIT_AUTHOR_NAME":"ci"}}`, false},
+		{`{"env":{"PATH":"/tmp/evil"}}`, true},
+		{`{"env":{"HOME":"/tmp"}}`, true},
+		{`{"env":{"1BAD":"x"}}`, true},
+	}
+	for _, tt := range tests {
+		_, err := parseExecCommand(tool, "git", []byte(tt.body))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.body, err, tt.wantErr)
+		}
+	}
+}
+
+// exec_dirs is checked against where a symbolic link points, so a link in
+// an allowed directory can't lead out of it
+func TestParseExecCommandResolvesDir(t *testing.T) {
+	allowed, outside := t.TempDir(), t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(allowed, "link")); err != nil {
+		t.Fatal(err)
+	}
+	tool := &registry.Tool{Name: "shell"}
+	tool.Exec = config.ExecToolConfig{Binaries: map[string]string{"git": "/usr/bin/git"}}
+
+	tests := []struct {
+		dir     string
+		wantDir string // "" when the call is rejected
+	}{
+		{allowed, allowed},
+		{filepath.Join(allowed, "link"), outside},
+		{filepath.Join(allowed, "link", "..", "link"), outside},
+		{filepath.Join(allowed, "missing"), ""},
+		{"srv", ""},
+	}
+	for _, tt := range tests {
+		cmd, err := parseExecCommand(tool, "git", []byte(`{"dir":"`+tt.dir+`"}`))
+		if tt.wantDir == "" {
+			if err == nil {
+				t.Errorf("%s: accepted as %s", tt.dir, cmd.Dir)
+			}
+			continue
+		}
+		if err != nil || cmd.Dir != tt.wantDir {
+			t.Errorf("%s: got %v, %v; want %s", tt.dir, cmd, err, tt.wantDir)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+
+	"aegis-gateway/internal/policy"
+)
+
+// extAuthzPartialBodyHeader is set by Envoy when it sends only the first
+// max_request_bytes of the body
+const extAuthzPartialBodyHeader = "x-envoy-auth-partial-body"
+
+// extAuthzServer implements the Envoy ext_authz Authorization service. Envoy
+// forwards the call itself, so Aegis only decides and logs; response
+// obligations such as redaction can't be applied in this mode.
+type extAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+	g *Gateway
+}
+
+// Check implements envoy.service.auth.v3.Authorization
+func (s *extAuthzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	g := s.g
+	start := time.Now()
+	attrs := req.GetAttributes()
+	httpReq := attrs.GetRequest().GetHttp()
+
+	g.mu.RLock()
+	settings := g.config.ExtAuthz.WithDefaults()
+	g.mu.RUnlock()
+
+	tool, action, resource, ok := extAuthzTarget(httpReq.GetPath(), settings.PathPrefix, attrs.GetContextExtensions())
+	if !ok {
+		return extAuthzDenied(codes.InvalidArgument, nil, newProblem("", http.StatusBadRequest,
+			fmt.Sprintf("Cannot map %s to a tool and action", httpReq.GetPath()))), nil
+	}
+
+	// Envoy sends the body only when with_request_body is configured;
+	// signed requests need it to authenticate
+	body := httpReq.GetRawBody()
+	if len(body) == 0 && httpReq.GetBody() != "" {
+		body = []byte(httpReq.GetBody())
+	}
+
+	r := &http.Request{
+		Method:     httpReq.GetMethod(),
+		URL:        &url.URL{Path: httpReq.GetPath(), RawQuery: httpReq.GetQuery()},
+		RequestURI: httpReq.GetPath(),
+		Host:       httpReq.GetHost(),
+		Header:     http.Header{},
+	}
+	if path, query, found := strings.Cut(httpReq.GetPath(), "?"); found {
+		r.URL.Path, r.URL.RawQuery = path, query
+	}
+	for key, value := range httpReq.GetHeaders() {
+		if !strings.HasPrefix(key, ":") {
+			r.Header.Set(key, value)
+		}
+	}
+
+	identity, authErr := g.resolveIdentity(r, body)
+	if authErr != nil {
+		code := codes.PermissionDenied
+		switch authErr.status {
+		case http.StatusUnauthorized:
+			code = codes.Unauthenticated
+		case http.StatusBadRequest:
+			code = codes.InvalidArgument
+		}
+		return extAuthzDenied(code, nil, newProblem("", authErr.status, authErr.message)), nil
+	}
+
+	// Like on /tools/, any body is JSON params whatever its Content-Type.
+	// A body Envoy truncated or didn't send can't be parsed; conditions
+	// that need params then deny the call.
+	bodySize := len(body)
+	if size := int(httpReq.GetSize()); size > bodySize {
+		bodySize = size
+	}
+	incomplete := bodySize > len(body) || r.Header.Get(extAuthzPartialBodyHeader) == "true"
+	params := make(map[string]interface{})
+	if len(body) > 0 && !incomplete {
+		if err := json.Unmarshal(body, &params); err != nil {
+			return extAuthzDenied(codes.InvalidArgument, nil, newProblem("", http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %v", err))), nil
+		}
+	}
+	// Envoy forwards the query string as sent, so it must parse in full
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err == nil {
+		err = mergeQuery(params, query)
+	}
+	if err != nil {
+		return extAuthzDenied(codes.InvalidArgument, nil, newProblem("", http.StatusBadRequest, fmt.Sprintf("Invalid query: %v", err))), nil
+	}
+
+	_, span, decision := g.evaluateRequest(traceContext(r.Header), start, identity, &policy.Request{
+		Tool:           tool,
+		Action:         action,
+		Method:         r.Method,
+		Resource:       resource,
+		Params:         params,
+		BodySize:       bodySize,
+		BodyIncomplete: incomplete,
+	})
+	span.End()
+
+	decisionHeader := extAuthzHeader(decisionIDHeader, decision.ID)
+	if !decision.Allowed {
+		code := codes.PermissionDenied
+		headers := []*corev3.HeaderValueOption{decisionHeader}
+		if decision.RetryAfter > 0 {
+			code = codes.ResourceExhausted
+			headers = append(headers, extAuthzHeader("Retry-After", strconv.Itoa(decision.RetryAfterSeconds())))
+		}
+		return extAuthzDenied(code, headers, violationBody(decision)), nil
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{
+			Headers: []*corev3.HeaderValueOption{
+				decisionHeader,
+				extAuthzHeader("X-Aegis-Agent-ID", identity.AgentID),
+			},
+			ResponseHeadersToAdd: []*corev3.HeaderValueOption{decisionHeader},
+		}},
+	}, nil
+}
+
+// extAuthzTarget finds the tool, action and resource of a request Envoy is
+// checking. The "tool" and "action" context extensions of the route win over
+// the path, which is otherwise read as prefix + :tool/:action[/resource].
+func extAuthzTarget(path, prefix string, extensions map[string]string) (tool, action, resource string, ok bool) {
+	path, _, _ = strings.Cut(path, "?")
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		rest = ""
+	}
+	parts := strings.SplitN(strings.Trim(rest, "/"), "/", 3)
+
+	tool, action = extensions["tool"], extensions["action"]
+	switch {
+	case tool != "" && action != "":
+		resource = strings.Trim(rest, "/")
+	case tool != "":
+		action = parts[0]
+		if len(parts) > 1 {
+			resource = strings.Join(parts[1:], "/")
+		}
+	case len(parts) >= 2:
+		tool, action = parts[0], parts[1]
+		if len(parts) == 3 {
+			resource = parts[2]
+		}
+	}
+	if tool == "" || action == "" {
+		return "", "", "", false
+	}
+	for _, segment := range strings.Split(resource, "/") {
+		if segment == "." || segment == ".." {
+			return "", "", "", false
+		}
+	}
+	return tool, action, resource, true
+}
+
+// extAuthzDenied builds a denial Envoy returns to the client as-is, with p
+// as its problem+json body
+func extAuthzDenied(code codes.Code, headers []*corev3.HeaderValueOption, p *problem) *authv3.CheckResponse {
+	body, _ := json.Marshal(p)
+	headers = append(headers, extAuthzHeader("Content-Type", "application/problem+json"))
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code), Message: p.Detail},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status:  &typev3.HttpStatus{Code: typev3.StatusCode(p.Status)},
+			Headers: headers,
+			Body:    string(body),
+		}},
+	}
+}
+
+// extAuthzHeader is a header Envoy sets, replacing any existing value
+func extAuthzHeader(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: key, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
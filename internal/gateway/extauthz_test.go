@@ -0,0 +1,87 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/codes"
+)
+
+func TestExtAuthzParams(t *testing.T) {
+	policyYAML := `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create]
+        conditions:
+          max_amount: 5000
+      - tool: reports
+        actions: [read]
+        conditions:
+          methods: [POST]
+`
+	g := newTestGateway(t, policyYAML, nil)
+	s := &extAuthzServer{g: g}
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		size        int64
+		partial     bool
+		wantCode    codes.Code
+		wantDeny    string
+	}{
+		{"json body", "/payments/create", "application/json", `{"amount":100}`, 0, false, codes.OK, ""},
+		{"over the limit", "/payments/create", "application/json", `{"amount":9000}`, 0, false, codes.PermissionDenied, "MAX_AMOUNT_EXCEEDED"},
+		{"json body without json content type", "/payments/create", "text/plain", `{"amount":9000}`, 0, false, codes.PermissionDenied, "MAX_AMOUNT_EXCEEDED"},
+		{"body not sent", "/payments/create", "application/json", "", 15, false, codes.PermissionDenied, "BODY_INCOMPLETE"},
+		{"body truncated", "/payments/create", "application/json", `{"amount":1`, 15, false, codes.PermissionDenied, "BODY_INCOMPLETE"},
+		{"partial body", "/payments/create", "application/json", `{"amount":1}`, 0, true, codes.PermissionDenied, "BODY_INCOMPLETE"},
+		{"body not needed", "/reports/read", "application/json", "", 15, false, codes.OK, ""},
+		{"invalid json", "/payments/create", "text/plain", "amount=9000", 0, false, codes.InvalidArgument, ""},
+		{"semicolon in query", "/payments/create?currency=USD;amount=9000", "application/json", "", 0, false, codes.InvalidArgument, ""},
+		{"invalid query escape", "/payments/create?amount=%zz", "application/json", "", 0, false, codes.InvalidArgument, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{"x-agent-id": "finance-agent", "content-type": tt.contentType}
+			if tt.partial {
+				headers[extAuthzPartialBodyHeader] = "true"
+			}
+			size := tt.size
+			if size == 0 {
+				size = int64(len(tt.body))
+			}
+			resp, err := s.Check(context.Background(), &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+					Method:  "POST",
+					Path:    tt.path,
+					Headers: headers,
+					Body:    tt.body,
+					Size:    size,
+				}},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := codes.Code(resp.GetStatus().GetCode()); got != tt.wantCode {
+				t.Fatalf("got %s (%s), want %s", got, resp.GetStatus().GetMessage(), tt.wantCode)
+			}
+			if tt.wantDeny == "" {
+				return
+			}
+			var p struct {
+				Code string `json:"code"`
+			}
+			json.Unmarshal([]byte(resp.GetDeniedResponse().GetBody()), &p)
+			if p.Code != tt.wantDeny {
+				t.Fatalf("got deny code %q, want %s", p.Code, tt.wantDeny)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode/utf8"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+)
+
+// fetchRequest documents the JSON body of a call to a fetch tool
+type fetchRequest struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// fetchCall is a parsed call to a fetch tool
+type fetchCall struct {
+	policy.FetchRequest
+	url     *url.URL
+	headers map[string]string
+	body    string
+}
+
+// fetchResult is the JSON a fetch tool answers with. Body is base64 when
+// the response isn't UTF-8 text.
+type fetchResult struct {
+	URL       string            `json:"url"`
+	Status    int               `json:"status"`
+	Headers   map[string]string `json:"headers"`
+	Body      string            `json:"body"`
+	Encoding  string            `json:"encoding,omitempty"`
+	Truncated bool              `json:"truncated,omitempty"`
+}
+
+// fetchReservedHeaders are set by the gateway, not the agent
+var fetchReservedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Upgrade":           true,
+	"Te":                true,
+	"Trailer":           true,
+	"Keep-Alive":        true,
+}
+
+// errPrivateAddress is returned when a fetch resolves to an address the
+// gateway won't connect to
+var errPrivateAddress = errors.New("address is not public")
+
+// parseFetch checks the URL, method and headers of a call to a fetch tool
+func (g *Gateway) parseFetch(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		tool, ok := g.tools.Get(c.Tool)
+		if !ok || tool.Protocol != registry.ProtocolFetch {
+			next(w, c)
+			return
+		}
+
+		r := c.Request
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, fmt.Sprintf("Tool %s fetches URLs; POST them to /tools/%s/fetch", c.Tool, c.Tool), http.StatusMethodNotAllowed)
+			return
+		}
+		if c.multipart || c.Resource != "" || c.Action != "fetch" {
+			writeError(w, fmt.Sprintf("Tool %s fetches URLs; POST them to /tools/%s/fetch", c.Tool, c.Tool), http.StatusBadRequest)
+			return
+		}
+
+		call, err := parseFetchCall(c.Params)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.fetch = call
+		next(w, c)
+	}
+}
+
+// parseFetchCall checks the params of a call to a fetch tool
+func parseFetchCall(params map[string]interface{}) (*fetchCall, error) {
+	raw, _ := params["url"].(string)
+	if raw == "" {
+		return nil, errors.New("A url is required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid url: %v", err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Url must be http or https, not %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("Url must have a host")
+	}
+	if u.User != nil {
+		return nil, errors.New("Url must not have credentials")
+	}
+	u.Fragment = ""
+
+	method := http.MethodGet
+	if m, ok := params["method"]; ok {
+		s, _ := m.(string)
+		method = strings.ToUpper(s)
+		if method == "" || strings.IndexFunc(method, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+			return nil, fmt.Errorf("Invalid method %q", s)
+		}
+		if method == http.MethodConnect {
+			return nil, errors.New("Method CONNECT is not supported")
+		}
+	}
+
+	call := &fetchCall{
+		FetchRequest: policy.FetchRequest{
+			URL:    u.String(),
+			Scheme: u.Scheme,
+			Host:   strings.ToLower(strings.TrimSuffix(u.Hostname(), ".")),
+			Method: method,
+		},
+		url:     u,
+		headers: make(map[string]string),
+	}
+	if h, ok := params["headers"]; ok {
+		headers, ok := h.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("Headers must be an object of strings")
+		}
+		for name, v := range headers {
+			value, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("Header %s must be a string", name)
+			}
+			canonical := http.CanonicalHeaderKey(name)
+			if fetchReservedHeaders[canonical] || strings.HasPrefix(canonical, "Proxy-") {
+				return nil, fmt.Errorf("Header %s is set by the gateway", name)
+			}
+			if strings.ContainsAny(name+value, "\r\n") {
+				return nil, fmt.Errorf("Header %s must not contain line breaks", name)
+			}
+			call.headers[canonical] = value
+		}
+	}
+	if b, ok := params["body"]; ok {
+		body, ok := b.(string)
+		if !ok {
+			return nil, errors.New("Body must be a string")
+		}
+		call.body = body
+	}
+	return call, nil
+}
+
+// runFetch makes the request of a call to a fetch tool and reads at most
+// limit bytes of the response, or the tool's max_response_bytes if lower.
+// Redirects are returned rather than followed, so policy sees every host.
+func (g *Gateway) runFetch(ctx context.Context, tool *registry.Tool, call *fetchCall, limit int64) ([]byte, error) {
+	if tool.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tool.Timeout)
+		defer cancel()
+	}
+	max := tool.Fetch.MaxResponseBytes
+	if max == 0 {
+		max = config.DefaultFetchMaxResponseBytes
+	}
+	if limit > 0 && limit < max {
+		max = limit
+	}
+
+	client := g.fetchClient(tool)
+	var body io.Reader
+	if call.body != "" {
+		body = strings.NewReader(call.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, call.Method, call.url.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range call.headers {
+		req.Header.Set(name, value)
+	}
+	if req.Header.Get("User-Agent") == "" && tool.Fetch.UserAgent != "" {
+		req.Header.Set("User-Agent", tool.Fetch.UserAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	result := fetchResult{URL: call.URL, Status: resp.StatusCode, Headers: make(map[string]string, len(resp.Header))}
+	for name, values := range resp.Header {
+		result.Headers[name] = strings.Join(values, ", ")
+	}
+	if int64(len(data)) > max {
+		data = data[:max]
+		result.Truncated = true
+	}
+	if utf8.Valid(data) {
+		result.Body = string(data)
+	} else {
+		result.Body = base64.StdEncoding.EncodeToString(data)
+		result.Encoding = "base64"
+	}
+	return json.Marshal(result)
+}
+
+// fetchClients holds the clients of fetch tools, which reach any public
+// host policy allows rather than only registered ones
+type fetchClients struct {
+	mu      sync.Mutex
+	clients map[fetchClientKey]*http.Client
+}
+
+type fetchClientKey struct {
+	allowPrivate bool
+	transport    config.TransportConfig
+}
+
+// fetchClient returns the client used by fetch tool. It doesn't follow
+// redirects, use proxies or send credentials, and unless
+// fetch.allow_private is set it refuses to connect to addresses that
+// aren't public, checked after DNS resolution.
+func (g *Gateway) fetchClient(tool *registry.Tool) *http.Client {
+	key := fetchClientKey{allowPrivate: tool.Fetch.AllowPrivate, transport: tool.Transport}
+	f := &g.fetch
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.clients[key]; ok {
+		return c
+	}
+
+	t := newUpstreamTransport(tool.Transport, nil)
+	dialer := newDialer(tool.Transport)
+	if !key.allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			return checkPublicAddress(address)
+		}
+	}
+	t.DialContext = dialer.DialContext
+	c := &http.Client{
+		Transport: t,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if f.clients == nil {
+		f.clients = make(map[fetchClientKey]*http.Client)
+	}
+	f.clients[key] = c
+	return c
+}
+
+// nonPublicPrefixes are ranges net.IP doesn't classify but fetches mustn't
+// reach: this network, shared address space, IETF protocol assignments,
+// benchmarking and reserved
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// checkPublicAddress returns errPrivateAddress unless the dialed host:port
+// is a public unicast address
+func checkPublicAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsMulticast() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return fmt.Errorf("%s: %w", host, errPrivateAddress)
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(addr) {
+			return fmt.Errorf("%s: %w", host, errPrivateAddress)
+		}
+	}
+	return nil
+}
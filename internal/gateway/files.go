@@ -0,0 +1,403 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+)
+
+// maxFileEntries caps the entries a list call returns
+const maxFileEntries = 10000
+
+// filesActions are the actions of a files tool
+var filesActions = map[string]bool{"read": true, "write": true, "list": true, "delete": true}
+
+// errSymlink rejects paths through a symbolic link where the kernel can't
+// be asked to
+var errSymlink = errors.New("path goes through a symbolic link")
+
+// filesRequest is the params of a call to a files tool. Content is only
+// read by writes, and taken as base64 when Encoding is "base64".
+type filesRequest struct {
+	Path     string `json:"path"`
+	Content  string `json:"content,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// fileCommand is a parsed call to a files tool
+type fileCommand struct {
+	policy.FileOperation
+	action  string
+	content []byte
+}
+
+// fileEntry is an entry of a listed directory
+type fileEntry struct {
+	Name     string     `json:"name"`
+	Type     string     `json:"type"`
+	Size     int64      `json:"size,omitempty"`
+	Modified *time.Time `json:"modified,omitempty"`
+}
+
+// fileResult is the response of a files tool. Content is base64-encoded,
+// and Encoding says so, when the file isn't valid UTF-8.
+type fileResult struct {
+	Path      string      `json:"path"`
+	Size      int64       `json:"size,omitempty"`
+	Content   *string     `json:"content,omitempty"`
+	Encoding  string      `json:"encoding,omitempty"`
+	Entries   []fileEntry `json:"entries,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+	Deleted   bool        `json:"deleted,omitempty"`
+}
+
+// fileError rejects a call to a files tool with the given status
+type fileError struct {
+	status  int
+	message string
+}
+
+func (e *fileError) Error() string {
+	return e.message
+}
+
+// isFileError reports whether err is a files tool rejecting a call, e.g.
+// for a missing file, rather than failing
+func isFileError(err error) bool {
+	var fileErr *fileError
+	return errors.As(err, &fileErr)
+}
+
+// parseFiles parses calls to files tools, /tools/:tool/read, write, list or
+// delete with the path in the params or below the action, so policy sees
+// the path that is opened. The cleaned path replaces params.path, so
+// folder_prefix checks it too.
+func (g *Gateway) parseFiles(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		tool, ok := g.tools.Get(c.Tool)
+		if !ok || tool.Protocol != registry.ProtocolFiles {
+			next(w, c)
+			return
+		}
+		if c.multipart {
+			writeError(w, fmt.Sprintf("Tool %s takes file content as a JSON string", c.Tool), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		// Parsed again after a plugin changed the params, the path is
+		// already in params.path
+		resource := c.Resource
+		if c.files != nil {
+			resource = ""
+		}
+		cmd, err := parseFileCommand(c.Action, resource, c.Params)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.Params["path"] = cmd.Path
+		c.files = cmd
+		next(w, c)
+	}
+}
+
+// parseFileCommand checks the action and path of a call to a files tool and
+// decodes the content of a write
+func parseFileCommand(action, resource string, params map[string]interface{}) (*fileCommand, error) {
+	if !filesActions[action] {
+		return nil, fmt.Errorf("Files tools support read, write, list and delete, not %s", action)
+	}
+
+	p, _ := params["path"].(string)
+	if resource != "" {
+		if p != "" {
+			return nil, errors.New("Set the path in the params or below the action, not both")
+		}
+		p = resource
+	}
+	if p == "" && action != "list" {
+		return nil, errors.New("A path is required")
+	}
+	p = "/" + strings.TrimPrefix(p, "/")
+	if (p != "/" && path.Clean(p) != strings.TrimSuffix(p, "/")) || strings.ContainsRune(p, 0) || strings.Contains(p, `\`) {
+		return nil, fmt.Errorf("Path %s must not have empty, . or .. segments", p)
+	}
+	p = path.Clean(p)
+
+	cmd := &fileCommand{FileOperation: policy.FileOperation{Path: p}, action: action}
+	if action == "write" {
+		content, ok := params["content"].(string)
+		if !ok {
+			return nil, errors.New("Writes require content as a string")
+		}
+		cmd.content = []byte(content)
+		if params["encoding"] == "base64" {
+			data, err := base64.StdEncoding.DecodeString(content)
+			if err != nil {
+				return nil, errors.New("Content is not valid base64")
+			}
+			cmd.content = data
+		}
+		cmd.Size = len(cmd.content)
+	}
+	if (action == "write" || action == "delete") && p == "/" {
+		return nil, fmt.Errorf("The root directory can't be the target of %s", action)
+	}
+	return cmd, nil
+}
+
+// runFiles performs a call to a files tool. Paths are resolved in the
+// tool's root, narrowed to the rule's files_root, and can't leave it or
+// follow symbolic links; on Linux the kernel enforces this with openat2.
+func (g *Gateway) runFiles(ctx context.Context, tool *registry.Tool, cmd *fileCommand, filesRoot string) ([]byte, error) {
+	root, err := os.Open(tool.Files.Root)
+	if err != nil {
+		return nil, fmt.Errorf("root of tool %s is unavailable: %w", tool.Name, err)
+	}
+	defer root.Close()
+	if rel := strings.TrimPrefix(filesRoot, "/"); rel != "" {
+		sub, err := openDirAt(root, rel)
+		if err != nil {
+			return nil, fmt.Errorf("files_root %s of tool %s is unavailable: %w", filesRoot, tool.Name, err)
+		}
+		defer sub.Close()
+		root = sub
+	}
+
+	rel := strings.TrimPrefix(cmd.Path, "/")
+	dirRel, base := path.Split(rel)
+	result := fileResult{Path: cmd.Path}
+	switch cmd.action {
+	case "read":
+		limit := tool.Files.MaxReadBytes
+		if limit == 0 {
+			limit = config.DefaultFilesMaxReadBytes
+		}
+		data, err := readFileAt(root, rel, limit)
+		if err != nil {
+			return nil, fileOpError(err, cmd.Path)
+		}
+		content := string(data)
+		if !utf8.Valid(data) {
+			content, result.Encoding = base64.StdEncoding.EncodeToString(data), "base64"
+		}
+		result.Size, result.Content = int64(len(data)), &content
+
+	case "write":
+		dir, err := mkdirAllAt(root, dirRel)
+		if err != nil {
+			return nil, fileOpError(err, path.Clean("/"+dirRel))
+		}
+		defer dir.Close()
+		f, err := openAt(dir, base, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return nil, fileOpError(err, cmd.Path)
+		}
+		_, err = f.Write(cmd.content)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", cmd.Path, err)
+		}
+		result.Size = int64(len(cmd.content))
+
+	case "list":
+		if rel == "" {
+			rel = "."
+		}
+		dir, err := openDirAt(root, rel)
+		if err != nil {
+			return nil, fileOpError(err, cmd.Path)
+		}
+		defer dir.Close()
+		result.Entries, result.Truncated, err = listDirAt(dir)
+		if err != nil {
+			return nil, fileOpError(err, cmd.Path)
+		}
+
+	case "delete":
+		if dirRel == "" {
+			dirRel = "."
+		}
+		dir, err := openDirAt(root, dirRel)
+		if err != nil {
+			return nil, fileOpError(err, cmd.Path)
+		}
+		defer dir.Close()
+		if err := removeAt(dir, base); err != nil {
+			return nil, fileOpError(err, cmd.Path)
+		}
+		result.Deleted = true
+	}
+	return json.Marshal(result)
+}
+
+// readFileAt reads the regular file rel below dir, up to limit bytes
+func readFileAt(dir *os.File, rel string, limit int64) ([]byte, error) {
+	f, err := openAt(dir, rel, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, syscall.EISDIR
+	}
+	if !info.Mode().IsRegular() {
+		return nil, &fileError{status: http.StatusBadRequest, message: "not a regular file"}
+	}
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, &fileError{status: http.StatusRequestEntityTooLarge, message: fmt.Sprintf("larger than max_read_bytes=%d", limit)}
+	}
+	return data, nil
+}
+
+// listDirAt lists dir, sorted by name
+func listDirAt(dir *os.File) ([]fileEntry, bool, error) {
+	dirEntries, err := dir.ReadDir(maxFileEntries + 1)
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	truncated := len(dirEntries) > maxFileEntries
+	if truncated {
+		dirEntries = dirEntries[:maxFileEntries]
+	}
+
+	entries := make([]fileEntry, 0, len(dirEntries))
+	for _, e := range dirEntries {
+		entry := fileEntry{Name: e.Name(), Type: "other"}
+		switch {
+		case e.IsDir():
+			entry.Type = "dir"
+		case e.Type().IsRegular():
+			entry.Type = "file"
+			if size, modified, err := statAt(dir, e.Name()); err == nil {
+				modified = modified.UTC()
+				entry.Size, entry.Modified = size, &modified
+			}
+		case e.Type()&fs.ModeSymlink != 0:
+			entry.Type = "symlink"
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, truncated, nil
+}
+
+// openDirAt opens the directory rel below dir
+func openDirAt(dir *os.File, rel string) (*os.File, error) {
+	f, err := openAt(dir, rel, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || !info.IsDir() {
+		f.Close()
+		if err == nil {
+			err = syscall.ENOTDIR
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// mkdirAllAt opens the directory rel below dir, creating it and its
+// parents as needed
+func mkdirAllAt(dir *os.File, rel string) (*os.File, error) {
+	cur, err := openDirAt(dir, ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(strings.Trim(rel, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		next, err := openDirAt(cur, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			if err = mkdirAt(cur, name); err == nil || errors.Is(err, fs.ErrExist) {
+				next, err = openDirAt(cur, name)
+			}
+		}
+		cur.Close()
+		if err != nil {
+			return nil, err
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// walkOpen opens rel below dir after checking that no element of it is a
+// symbolic link. Unlike openat2 this leaves a window in which a path could
+// be swapped, so it only serves platforms and kernels without it.
+func walkOpen(dir *os.File, rel string, flag int, perm os.FileMode) (*os.File, error) {
+	full := dir.Name()
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		if name == ".." {
+			return nil, &os.PathError{Op: "open", Path: rel, Err: errSymlink}
+		}
+		full = filepath.Join(full, name)
+		info, err := os.Lstat(full)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Nothing below a missing element can be a link
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return nil, &os.PathError{Op: "open", Path: rel, Err: errSymlink}
+		}
+	}
+	return os.OpenFile(filepath.Join(dir.Name(), rel), flag, perm)
+}
+
+// fileOpError describes a failed file operation for the agent by the path
+// it sees, never the one on the gateway host
+func fileOpError(err error, p string) error {
+	var fe *fileError
+	switch {
+	case errors.As(err, &fe):
+		return &fileError{status: fe.status, message: fmt.Sprintf("%s is %s", p, fe.message)}
+	case errors.Is(err, fs.ErrNotExist):
+		return &fileError{status: http.StatusNotFound, message: fmt.Sprintf("%s does not exist", p)}
+	case errors.Is(err, errSymlink), errors.Is(err, syscall.ELOOP), errors.Is(err, syscall.EXDEV):
+		return &fileError{status: http.StatusForbidden, message: fmt.Sprintf("%s goes through a symbolic link", p)}
+	case errors.Is(err, syscall.EISDIR):
+		return &fileError{status: http.StatusBadRequest, message: fmt.Sprintf("%s is a directory", p)}
+	case errors.Is(err, syscall.ENOTDIR):
+		return &fileError{status: http.StatusBadRequest, message: fmt.Sprintf("%s or one of its parents is not a directory", p)}
+	case errors.Is(err, syscall.ENOTEMPTY), errors.Is(err, fs.ErrExist):
+		return &fileError{status: http.StatusConflict, message: fmt.Sprintf("%s is a directory that is not empty", p)}
+	case errors.Is(err, fs.ErrPermission):
+		return &fileError{status: http.StatusForbidden, message: fmt.Sprintf("Permission to %s denied", p)}
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package gateway
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openAt opens rel below dir. The kernel resolves it with openat2, which
+// fails for paths that leave dir or go through a symbolic link, so nothing
+// swapped in between a check and the open can escape the root. Kernels
+// before 5.6 fall back to walkOpen.
+func openAt(dir *os.File, rel string, flag int, perm os.FileMode) (*os.File, error) {
+	how := unix.OpenHow{
+		Flags:   uint64(flag | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_SYMLINKS | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	if flag&os.O_CREATE != 0 {
+		how.Mode = uint64(perm.Perm())
+	}
+	for {
+		fd, err := unix.Openat2(int(dir.Fd()), rel, &how)
+		switch {
+		case err == nil:
+			return os.NewFile(uintptr(fd), filepath.Join(dir.Name(), rel)), nil
+		case errors.Is(err, unix.EINTR), errors.Is(err, unix.EAGAIN):
+			continue
+		case errors.Is(err, unix.ENOSYS):
+			return walkOpen(dir, rel, flag, perm)
+		}
+		return nil, &os.PathError{Op: "openat2", Path: rel, Err: err}
+	}
+}
+
+// mkdirAt creates the directory name, a single path element, in dir
+func mkdirAt(dir *os.File, name string) error {
+	if err := unix.Mkdirat(int(dir.Fd()), name, 0o755); err != nil {
+		return &os.PathError{Op: "mkdirat", Path: name, Err: err}
+	}
+	return nil
+}
+
+// removeAt removes the file or empty directory name, a single path
+// element, from dir. A symbolic link is removed, not followed.
+func removeAt(dir *os.File, name string) error {
+	err := unix.Unlinkat(int(dir.Fd()), name, 0)
+	if errors.Is(err, unix.EISDIR) {
+		err = unix.Unlinkat(int(dir.Fd()), name, unix.AT_REMOVEDIR)
+	}
+	if err != nil {
+		return &os.PathError{Op: "unlinkat", Path: name, Err: err}
+	}
+	return nil
+}
+
+// statAt returns the size and modification time of name, a single path
+// element of dir, without following symbolic links
+func statAt(dir *os.File, name string) (int64, time.Time, error) {
+	var st unix.Stat_t
+	if err := unix.Fstatat(int(dir.Fd()), name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return 0, time.Time{}, &os.PathError{Op: "fstatat", Path: name, Err: err}
+	}
+	return st.Size, time.Unix(st.Mtim.Unix()), nil
+}
@@ -0,0 +1,36 @@
+//go:build !linux
+
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// openAt opens rel below dir with walkOpen; only Linux can have the kernel
+// confine the lookup
+func openAt(dir *os.File, rel string, flag int, perm os.FileMode) (*os.File, error) {
+	return walkOpen(dir, rel, flag, perm)
+}
+
+// mkdirAt creates the directory name, a single path element, in dir
+func mkdirAt(dir *os.File, name string) error {
+	return os.Mkdir(filepath.Join(dir.Name(), name), 0o755)
+}
+
+// removeAt removes the file or empty directory name, a single path
+// element, from dir. A symbolic link is removed, not followed.
+func removeAt(dir *os.File, name string) error {
+	return os.Remove(filepath.Join(dir.Name(), name))
+}
+
+// statAt returns the size and modification time of name, a single path
+// element of dir, without following symbolic links
+func statAt(dir *os.File, name string) (int64, time.Time, error) {
+	info, err := os.Lstat(filepath.Join(dir.Name(), name))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return info.Size(), info.ModTime(), nil
+}
@@ -0,0 +1,87 @@
+package gateway
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/registry"
+)
+
+func TestParseFileCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   string
+		resource string
+		params   map[string]interface{}
+		wantPath string // "" when the call is rejected
+	}{
+		{"param path", "read", "", map[string]interface{}{"path": "reports/q3.txt"}, "/reports/q3.txt"},
+		{"resource path", "read", "reports/q3.txt", map[string]interface{}{}, "/reports/q3.txt"},
+		{"list root", "list", "", map[string]interface{}{}, "/"},
+		{"dot dot", "read", "", map[string]interface{}{"path": "/reports/../../etc/passwd"}, ""},
+		{"dot", "read", "", map[string]interface{}{"path": "/reports/./q3.txt"}, ""},
+		{"empty segment", "read", "", map[string]interface{}{"path": "/reports//q3.txt"}, ""},
+		{"backslash", "read", "", map[string]interface{}{"path": `/reports\..\q3.txt`}, ""},
+		{"NUL", "read", "", map[string]interface{}{"path": "/q3.txt\x00.pdf"}, ""},
+		{"both paths", "read", "a.txt", map[string]interface{}{"path": "b.txt"}, ""},
+		{"delete root", "delete", "", map[string]interface{}{"path": "/"}, ""},
+		{"unknown action", "chmod", "", map[string]interface{}{"path": "/a.txt"}, ""},
+	}
+	for _, tt := range tests {
+		cmd, err := parseFileCommand(tt.action, tt.resource, tt.params)
+		if tt.wantPath == "" {
+			if err == nil {
+				t.Errorf("%s: accepted %s", tt.name, cmd.Path)
+			}
+			continue
+		}
+		if err != nil || cmd.Path != tt.wantPath {
+			t.Errorf("%s: got %v, %v; want %s", tt.name, cmd, err, tt.wantPath)
+		}
+	}
+}
+
+// Calls must stay inside the tool's root and the rule's files_root, even
+// through symbolic links
+func TestRunFilesConfined(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "public"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(root, "public", "a.txt"), []byte("a"), 0o600)
+	os.WriteFile(filepath.Join(root, "private.txt"), []byte("private"), 0o600)
+	if err := os.Symlink(outside, filepath.Join(root, "public", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	g := newTestGateway(t, `version: "1"`, nil)
+	tool := &registry.Tool{Name: "docs", Protocol: registry.ProtocolFiles, Files: config.FilesToolConfig{Root: root}}
+	tests := []struct {
+		path      string
+		filesRoot string
+		wantErr   bool
+	}{
+		{"/public/a.txt", "", false},
+		{"/a.txt", "/public", false},
+		{"/private.txt", "", false},
+		{"/public/link/secret.txt", "", true},
+		{"/link/secret.txt", "/public", true},
+		{"/private.txt", "/public", true},
+	}
+	for _, tt := range tests {
+		cmd, err := parseFileCommand("read", "", map[string]interface{}{"path": tt.path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := g.runFiles(context.Background(), tool, cmd, tt.filesRoot); (err != nil) != tt.wantErr {
+			t.Errorf("%s under %q: got %v, want error %v", tt.path, tt.filesRoot, err, tt.wantErr)
+		}
+	}
+}
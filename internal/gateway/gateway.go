@@ -3,170 +3,822 @@ package gateway
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
+	"net"
 	"net/http"
-	"strings"
+	"net/url"
+	"reflect"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"aegis-gateway/internal/anomaly"
+	"aegis-gateway/internal/auth"
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/deadletter"
+	"aegis-gateway/internal/logging"
+	"aegis-gateway/internal/notify"
+	"aegis-gateway/internal/plugin"
 	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/quarantine"
+	"aegis-gateway/internal/redact"
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/internal/report"
+	"aegis-gateway/internal/scan"
+	"aegis-gateway/internal/secrets"
+	"aegis-gateway/internal/state"
 	"aegis-gateway/pkg/telemetry"
 )
 
+// logger is the gateway component's logger
+var logger = logging.For("gateway")
+
+// decisionIDHeader carries the ID of the policy evaluation behind a response
+const decisionIDHeader = "X-Aegis-Decision-ID"
+
 // Gateway handles requests and enforces policies
 type Gateway struct {
 	policyEngine *policy.PolicyEngine
 	telemetry    *telemetry.Telemetry
 	client       *http.Client
-	toolURLs     map[string]string
+
+	tools *registry.ToolRegistry
+
+	mu     sync.RWMutex
+	config *config.Config
+	jwt    *auth.JWTVerifier
+	oidc   *auth.OIDCVerifier
+	hmac   *auth.HMACVerifier
+
+	// redactor serves the response redact obligation
+	redactor *redact.Redactor
+
+	// secrets resolves the credentials tools are called with
+	secrets *secrets.Store
+
+	// apiKeys is nil when API keys are disabled
+	apiKeys *auth.APIKeyStore
+
+	// spiffe is nil unless the SPIFFE Workload API is configured
+	spiffe *spiffeWorkload
+
+	// clients holds the connection pools used to reach HTTP tools
+	clients upstreamClients
+
+	// pins holds the resolved addresses of tool hosts
+	pins egressPins
+
+	// grpc holds connections and descriptors for gRPC tools
+	grpc grpcUpstreams
+
+	// sql holds connection pools for SQL tools
+	sql sqlDatabases
+
+	// fetch holds the clients of fetch tools
+	fetch fetchClients
+
+	// idempotency remembers responses to requests with an Idempotency-Key
+	idempotency idempotencyStore
+
+	// jobs tracks calls made with ?mode=async
+	jobs jobStore
+
+	// deadLetters is nil unless dead-letter capture is enabled
+	deadLetters *deadletter.Store
+
+	// redis is nil unless rate limit and budget usage is kept in Redis
+	redis *state.RedisStore
+
+	// plugins is nil unless WASM plugins are configured
+	plugins *plugin.Host
+
+	// scanner is nil unless uploads are scanned for malware
+	scanner scan.Scanner
+
+	// notifier is nil unless notification webhooks are configured; denials
+	// feeds it the denied decisions and anomalies
+	notifier *notify.Notifier
+	denials  *telemetry.DecisionSubscription
+
+	// reports is nil unless audit reports are scheduled; stopReportChanges
+	// ends its policy change subscription
+	reports           *report.Scheduler
+	stopReportChanges func()
+
+	// anomalies is nil unless anomaly detection is enabled; holds are the
+	// agents it or a tripwire put on hold
+	anomalies *anomaly.Detector
+	holds     *anomaly.Holds
+
+	// quarantine keeps the agents denied too often or caught by a tripwire
+	quarantine *quarantine.Quarantine
+
+	// middleware are the stages added with Use; pipeline is the composed
+	// handler, rebuilt after each Use
+	middleware map[Stage][]Middleware
+	pipeline   CallHandler
 }
 
-// NewGateway creates a new gateway instance
-func NewGateway(policyEngine *policy.PolicyEngine, telemetry *telemetry.Telemetry) *Gateway {
-	return &Gateway{
+// NewGateway creates a new gateway instance. A nil config uses the defaults.
+func NewGateway(cfg *config.Config, policyEngine *policy.PolicyEngine, telemetry *telemetry.Telemetry) *Gateway {
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	if err := logging.Configure(cfg.Logging); err != nil {
+		logger.Error("Invalid logging settings", "error", err)
+	}
+	g := &Gateway{
 		policyEngine: policyEngine,
 		telemetry:    telemetry,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		toolURLs: map[string]string{
-			"payments": "http://localhost:8081",
-			"files":    "http://localhost:8082",
-		},
+		client:       &http.Client{},
+		tools:        registry.NewToolRegistry(cfg.Tools),
+		config:       cfg,
+		jwt:          newJWTVerifier(cfg.Auth.JWT),
+		oidc:         newOIDCVerifier(cfg.Auth.OIDC),
+		redactor:     newRedactor(cfg.Redaction),
+		secrets:      secrets.NewStore(cfg.Secrets),
+		plugins:      plugin.Load(cfg.Plugins),
+		scanner:      newScanner(cfg.Uploads.Scan),
+		holds:        anomaly.NewHolds(),
 	}
-}
+	g.hmac = g.newHMACVerifier(cfg.Auth.HMAC)
+	g.notifier = notify.New(cfg.Notify, g.resolveSecret)
+	g.anomalies = anomaly.New(cfg.Anomaly, g.holds)
+	g.quarantine = openQuarantine(cfg.Quarantine)
+	// Health checks only reach tool hosts, so keep them from being redirected elsewhere
+	health := http.DefaultTransport.(*http.Transport).Clone()
+	health.RegisterProtocol(registry.SchemeUnix, g.tools.NewUnixTransport(health.Clone()))
+	g.client.Transport = health
+	g.client.CheckRedirect = g.checkRedirect
 
-// HandleRequest processes incoming requests
-func (g *Gateway) HandleRequest(w http.ResponseWriter, r *http.Request) {
-	startTime := time.Now()
+	if cfg.Auth.APIKeys.Enabled {
+		store, err := auth.NewAPIKeyStore(cfg.Auth.APIKeys.Store)
+		if err != nil {
+			// Keys are rejected rather than silently accepted when the store is unreadable
+			logger.Error("API keys disabled", "error", err)
+		} else {
+			g.apiKeys = store
+		}
+	}
 
-	// Parse path: /tools/:tool/:action
-	pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
-	if len(pathParts) < 3 || pathParts[0] != "tools" {
-		http.Error(w, "Invalid path. Expected: /tools/:tool/:action", http.StatusBadRequest)
-		return
+	if cfg.DeadLetter.Enabled {
+		store, err := deadletter.NewStore(cfg.DeadLetter.Store, cfg.DeadLetter.MaxEntries)
+		if err != nil {
+			logger.Error("Dead-letter capture disabled", "error", err)
+		} else {
+			g.deadLetters = store
+		}
 	}
 
-	tool := pathParts[1]
-	action := pathParts[2]
+	if cfg.SPIFFE.Enabled() {
+		workload, err := newSPIFFEWorkload(cfg.SPIFFE)
+		if err != nil {
+			// Agents can't connect and SPIFFE tools fail closed without an SVID
+			logger.Error("SPIFFE disabled", "error", err)
+		} else {
+			g.spiffe = workload
+		}
+	}
 
-	// Get agent ID from header
-	agentID := r.Header.Get("X-Agent-ID")
-	if agentID == "" {
-		http.Error(w, "Missing X-Agent-ID header", http.StatusBadRequest)
-		return
+	if ref := cfg.Telemetry.RecordSigningKey; ref != "" {
+		if err := g.loadRecordSigner(ref); err != nil {
+			// Decisions are still logged, but unsigned
+			logger.Error("Decision log signing disabled", "error", err)
+		}
 	}
 
-	// Read request body
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
-		return
+	if cfg.Telemetry.Store.Enabled() {
+		if err := g.openDecisionStore(cfg.Telemetry.Store); err != nil {
+			// Decisions still go to the sinks; /v1/decisions answers 501
+			logger.Error("Decision store disabled", "error", err)
+		}
 	}
 
-	// Parse JSON body
-	var params map[string]interface{}
-	if len(bodyBytes) > 0 {
-		if err := json.Unmarshal(bodyBytes, &params); err != nil {
-			http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
-			return
+	if cfg.State.Backend == config.StateBackendRedis {
+		store := state.NewRedisStore(cfg.State.Redis)
+		if err := store.Ping(); err != nil {
+			// Limited calls follow on_policy_error until Redis is reachable
+			logger.Error("Redis state store is unreachable", "error", err)
 		}
-	} else {
-		params = make(map[string]interface{})
+		g.redis = store
+		policyEngine.SetStateStore(store)
 	}
 
-	// Hash params for logging
-	paramsHash := telemetry.HashParams(params)
+	policyEngine.SetToolLookup(func(name string) bool {
+		_, ok := g.tools.Get(name)
+		return ok
+	})
+	policyEngine.SetReloadHandler(func(file string, err error) {
+		g.telemetry.RecordPolicyReload(err)
+		if err != nil {
+			g.mu.RLock()
+			g.notifier.PolicyReloadFailed(file, err)
+			g.mu.RUnlock()
+		}
+	})
 
-	// Evaluate policy
-	allowed, reason := g.policyEngine.Evaluate(agentID, tool, action, params)
+	g.denials = g.telemetry.SubscribeDecisions(notifiable)
+	go g.notifyDenials()
 
-	latencyMS := time.Since(startTime).Milliseconds()
+	if g.reports = report.New(cfg.Reports, g.telemetry.SummarizeDecisions, g.mailReport, g.resolveSecret); g.reports != nil {
+		g.stopReportChanges = policyEngine.Subscribe(g.reports.PolicyChanged)
+	}
+	return g
+}
 
-	// Log decision
-	ctx, span := g.telemetry.LogDecision(
-		context.Background(),
-		agentID,
-		tool,
-		action,
-		allowed,
-		reason,
-		paramsHash,
-		latencyMS,
-	)
-	defer span.End()
+// notifiable reports whether a decision log entry may fire a notification:
+// a denied call or an anomaly
+func notifiable(d telemetry.DecisionLog) bool {
+	return d.Decision != "true" || d.Phase == telemetry.PhaseAnomaly
+}
 
-	if !allowed {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusForbidden)
-		response := map[string]string{
-			"error":  "PolicyViolation",
-			"reason": reason,
-		}
-		json.NewEncoder(w).Encode(response)
-		return
+// notifyDenials hands denied decisions and anomalies to the notifier until
+// the subscription is closed
+func (g *Gateway) notifyDenials() {
+	for entry := range g.denials.C {
+		g.mu.RLock()
+		g.notifier.Decision(entry)
+		g.mu.RUnlock()
+	}
+}
+
+// ApplyConfig swaps in a reloaded configuration. Tool, authentication,
+// redaction, secret, plugin, scanning, notification and logging settings take
+// effect for the next request; the sections listed by restartRequired are
+// logged and require a restart.
+func (g *Gateway) ApplyConfig(cfg *config.Config) {
+	g.mu.Lock()
+	previous := g.config
+	g.config = cfg
+	if !reflect.DeepEqual(previous.Auth.JWT, cfg.Auth.JWT) {
+		g.jwt = newJWTVerifier(cfg.Auth.JWT)
+	}
+	if !reflect.DeepEqual(previous.Auth.OIDC, cfg.Auth.OIDC) {
+		g.oidc = newOIDCVerifier(cfg.Auth.OIDC)
+	}
+	if !reflect.DeepEqual(previous.Auth.HMAC, cfg.Auth.HMAC) {
+		g.hmac = g.newHMACVerifier(cfg.Auth.HMAC)
+	}
+	if !reflect.DeepEqual(previous.Redaction, cfg.Redaction) {
+		g.redactor = newRedactor(cfg.Redaction)
+	}
+	if !reflect.DeepEqual(previous.Secrets, cfg.Secrets) {
+		g.secrets = secrets.NewStore(cfg.Secrets)
+	}
+	if !reflect.DeepEqual(previous.Plugins, cfg.Plugins) {
+		// Hooks already running finish on the old plugins
+		go g.plugins.Close()
+		g.plugins = plugin.Load(cfg.Plugins)
 	}
+	if !reflect.DeepEqual(previous.Uploads.Scan, cfg.Uploads.Scan) {
+		g.scanner = newScanner(cfg.Uploads.Scan)
+	}
+	if !reflect.DeepEqual(previous.Notify, cfg.Notify) {
+		// Queued notifications are still sent by the old webhooks
+		go g.notifier.Close()
+		g.notifier = notify.New(cfg.Notify, g.resolveSecret)
+	}
+	g.mu.Unlock()
 
-	// Forward request to tool
-	toolURL, exists := g.toolURLs[tool]
-	if !exists {
-		http.Error(w, fmt.Sprintf("Unknown tool: %s", tool), http.StatusBadRequest)
-		return
+	if !reflect.DeepEqual(previous.Logging, cfg.Logging) {
+		if err := logging.Configure(cfg.Logging); err != nil {
+			logger.Error("Invalid logging settings", "error", err)
+		}
 	}
+	g.tools.Load(cfg.Tools)
 
-	forwardStart := time.Now()
-	err = g.forwardRequest(ctx, toolURL, action, bodyBytes, w)
-	forwardLatency := time.Since(forwardStart).Milliseconds()
-	
-	forwardSpan := g.telemetry.LogForwardedCall(ctx, tool, action, forwardLatency)
-	defer forwardSpan.End()
+	for _, section := range restartRequired(previous, cfg) {
+		logger.Warn(section + " settings changed; restart the gateway to apply them")
+	}
+}
 
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to forward request: %v", err), http.StatusInternalServerError)
-		return
+// restartRequired lists the settings that differ between two configurations
+// but are only read on startup, so ApplyConfig can't apply them
+func restartRequired(previous, cfg *config.Config) []string {
+	var sections []string
+	// CORS settings and the body limit are read per request and need no
+	// restart
+	prevServer, server := previous.Server, cfg.Server
+	prevServer.CORS, server.CORS = config.CORSConfig{}, config.CORSConfig{}
+	prevServer.MaxRequestBytes, server.MaxRequestBytes = 0, 0
+	if !reflect.DeepEqual(prevServer, server) {
+		sections = append(sections, "Server")
+	}
+	if previous.Admin.Address != cfg.Admin.Address {
+		sections = append(sections, "Admin listener")
+	}
+	if !reflect.DeepEqual(previous.Auth.APIKeys, cfg.Auth.APIKeys) {
+		sections = append(sections, "API key")
+	}
+	if !reflect.DeepEqual(previous.SPIFFE, cfg.SPIFFE) {
+		sections = append(sections, "SPIFFE")
+	}
+	if previous.Policies.Dir != cfg.Policies.Dir || previous.Policies.StateDir != cfg.Policies.StateDir {
+		sections = append(sections, "Policy directory")
+	}
+	if !reflect.DeepEqual(previous.State, cfg.State) {
+		sections = append(sections, "State")
+	}
+	if !reflect.DeepEqual(previous.DeadLetter, cfg.DeadLetter) {
+		sections = append(sections, "Dead letter")
+	}
+	// The other ext_authz settings are read per request
+	if previous.ExtAuthz.Enabled != cfg.ExtAuthz.Enabled {
+		sections = append(sections, "ext_authz listener")
+	}
+	// The exporters, sinks, store and signing key are all set up with the
+	// telemetry pipeline on startup
+	if !reflect.DeepEqual(previous.Telemetry, cfg.Telemetry) {
+		sections = append(sections, "Telemetry")
+	}
+	if !reflect.DeepEqual(previous.Reports, cfg.Reports) {
+		sections = append(sections, "Report")
+	}
+	if !reflect.DeepEqual(previous.Anomaly, cfg.Anomaly) {
+		sections = append(sections, "Anomaly")
 	}
+	if !reflect.DeepEqual(previous.Quarantine, cfg.Quarantine) {
+		sections = append(sections, "Quarantine")
+	}
+	return sections
 }
 
-// forwardRequest forwards the request to the appropriate tool
-func (g *Gateway) forwardRequest(ctx context.Context, baseURL, action string, body []byte, w http.ResponseWriter) error {
-	url := fmt.Sprintf("%s/%s", baseURL, action)
-	
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+// loadRecordSigner resolves the decision log signing key and has telemetry
+// sign entries with it
+func (g *Gateway) loadRecordSigner(ref string) error {
+	value, err := g.secrets.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	key, err := telemetry.ParseSigningKey(value)
 	if err != nil {
 		return err
 	}
+	g.telemetry.SetRecordSigner(key)
+	return nil
+}
+
+// resolveSecret resolves a secret reference with the current secret store
+func (g *Gateway) resolveSecret(ref string) (string, error) {
+	g.mu.RLock()
+	store := g.secrets
+	g.mu.RUnlock()
+	return store.Resolve(ref)
+}
+
+// openDecisionStore resolves the store's DSN if it is a secret reference
+// and opens it
+func (g *Gateway) openDecisionStore(store telemetry.StoreConfig) error {
+	dsn := store.DSN
+	if _, _, ok := config.SplitSecretRef(dsn); ok {
+		var err error
+		if dsn, err = g.secrets.Resolve(dsn); err != nil {
+			return err
+		}
+	}
+	return g.telemetry.OpenDecisionStore(store, dsn)
+}
+
+// newJWTVerifier returns a verifier for cfg, or nil if JWT auth is disabled
+func newJWTVerifier(cfg config.JWTConfig) *auth.JWTVerifier {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return auth.NewJWTVerifier(cfg)
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// newOIDCVerifier returns a verifier for the configured providers, or nil
+// if there are none
+func newOIDCVerifier(providers []config.OIDCProviderConfig) *auth.OIDCVerifier {
+	if len(providers) == 0 {
+		return nil
+	}
+	return auth.NewOIDCVerifier(providers)
+}
 
-	resp, err := g.client.Do(req)
+// newHMACVerifier returns a verifier for cfg that reads secrets from the
+// current secret store, or nil if no agent has a signing secret
+func (g *Gateway) newHMACVerifier(cfg config.HMACConfig) *auth.HMACVerifier {
+	if len(cfg.Secrets) == 0 {
+		return nil
+	}
+	return auth.NewHMACVerifier(cfg, g.resolveSecret)
+}
+
+// newRedactor builds the redaction engine for cfg. Invalid custom detectors
+// are rejected by config validation; should one get through, only the
+// built-in detectors are available and rules using the others fail closed.
+func newRedactor(cfg config.RedactionConfig) *redact.Redactor {
+	r, err := redact.New(cfg.Custom())
 	if err != nil {
+		logger.Error("Custom redaction detectors disabled", "error", err)
+		r, _ = redact.New(nil)
+	}
+	return r
+}
+
+// Close flushes pending state and stops background work
+func (g *Gateway) Close() error {
+	g.tools.Close()
+	g.grpc.close()
+	g.sql.close()
+	g.plugins.Close()
+	g.denials.Close()
+	g.mu.Lock()
+	notifier := g.notifier
+	g.notifier = nil
+	g.mu.Unlock()
+	notifier.Close()
+	if g.stopReportChanges != nil {
+		g.stopReportChanges()
+	}
+	g.reports.Close()
+	if g.spiffe != nil {
+		g.spiffe.Close()
+	}
+	if g.redis != nil {
+		g.redis.Close()
+	}
+	if g.apiKeys != nil {
+		return g.apiKeys.Flush()
+	}
+	return nil
+}
+
+// Tools returns the gateway's tool registry
+func (g *Gateway) Tools() *registry.ToolRegistry {
+	return g.tools
+}
+
+// mergeQuery adds query string parameters to the body params so policy sees
+// every argument the tool will receive. Repeated keys become lists. A key
+// present in both is rejected, since the tool might read either value.
+func mergeQuery(params map[string]interface{}, query url.Values) error {
+	for key, values := range query {
+		if _, exists := params[key]; exists {
+			return fmt.Errorf("parameter %s is set in both the query string and the body", key)
+		}
+		if len(values) == 1 {
+			params[key] = values[0]
+			continue
+		}
+		list := make([]interface{}, len(values))
+		for i, v := range values {
+			list[i] = v
+		}
+		params[key] = list
+	}
+	return nil
+}
+
+// evaluate checks a call against policy and logs the decision. parent
+// carries the caller's trace context (see telemetry.Extract). The returned
+// span is the call's root span, started at start, and must be ended by the
+// caller; the returned context carries it for the tool.forward span.
+func (g *Gateway) evaluate(parent context.Context, start time.Time, identity *Identity, tool, action string, params map[string]interface{}, bodySize int) (context.Context, trace.Span, policy.Decision) {
+	return g.evaluateRequest(parent, start, identity, &policy.Request{
+		Tool:     tool,
+		Action:   action,
+		Method:   http.MethodPost,
+		Params:   params,
+		BodySize: bodySize,
+	})
+}
+
+// evaluateRequest is evaluate for callers that know the HTTP method and
+// resource path of the call. The identity fields of req are filled in.
+func (g *Gateway) evaluateRequest(parent context.Context, start time.Time, identity *Identity, req *policy.Request) (context.Context, trace.Span, policy.Decision) {
+	req.AgentID = identity.AgentID
+	req.Claims = identity.Claims
+	req.Groups = identity.Groups
+	req.SessionID = identity.SessionID
+	req.SessionGenerated = identity.SessionGenerated
+	evaluate := g.checkDecoy(g.checkQuarantine(identity, g.checkHold(g.checkSchema(identity.SchemaVersion, g.policyEngine.EvaluateRequest))))
+	evalStart := time.Now()
+	decision := g.screenParams(req, evaluate)
+	ctx, span, decision := g.logDecision(parent, start, identity, req, decision, time.Since(evalStart), "")
+	if g.isDecoy(req) {
+		g.tripwire(ctx, identity, req, decision)
+	} else {
+		g.observeCall(ctx, identity, req, decision)
+		g.countDenial(identity, decision)
+	}
+	return ctx, span, decision
+}
+
+// precheck evaluates a call an agent plans to make without consuming its
+// rate limits or budgets. The decision is logged with phase "precheck".
+func (g *Gateway) precheck(parent context.Context, start time.Time, identity *Identity, req *policy.Request) policy.Decision {
+	req.AgentID = identity.AgentID
+	req.Claims = identity.Claims
+	req.Groups = identity.Groups
+	req.SessionID = identity.SessionID
+	req.SessionGenerated = identity.SessionGenerated
+	simulate := func(req *policy.Request) policy.Decision { return g.policyEngine.Simulate(req).Decision }
+	evaluate := g.checkDecoy(g.checkQuarantine(identity, g.checkHold(g.checkSchema(identity.SchemaVersion, simulate))))
+	evalStart := time.Now()
+	decision := g.screenParams(req, evaluate)
+	_, span, decision := g.logDecision(parent, start, identity, req, decision, time.Since(evalStart), "precheck")
+	span.End()
+	return decision
+}
+
+// logDecision applies on_policy_error to a decision, assigns its ID and
+// writes it to the audit log. It starts the request's root span at start,
+// as a child of parent, with the decision's span under it. evaluation is
+// the time the engine took to decide.
+func (g *Gateway) logDecision(parent context.Context, start time.Time, identity *Identity, req *policy.Request, decision policy.Decision, evaluation time.Duration, phase string) (context.Context, trace.Span, policy.Decision) {
+	if decision.Failed {
+		decision = g.onPolicyError(req, decision)
+	}
+	decision.ID = newDecisionID()
+	for _, threat := range req.Malware {
+		decision.Findings = append(decision.Findings, "malware "+threat)
+	}
+
+	d := telemetry.Decision{
+		ID:            decision.ID,
+		AgentID:       req.AgentID,
+		AgentVerified: identity.Verified(),
+		SessionID:     req.SessionID,
+		Tool:          req.Tool,
+		Action:        req.Action,
+		Allowed:       decision.Allowed,
+		Code:          decision.Code,
+		Reason:        decision.Reason,
+		ParamsHash:    telemetry.HashParams(req.Params),
+		LatencyMS:     time.Since(start).Milliseconds(),
+		Rollout:       decision.Rollout,
+		Evaluation:    evaluation,
+		Fallback:      decision.Fallback,
+		Phase:         phase,
+		Findings:      decision.Findings,
+	}
+	if tool, ok := g.tools.Get(req.Tool); ok {
+		d.ToolRegistered = true
+		d.Params = captureParams(req.Params, tool.Capture)
+	}
+	d.Payload = callPayload{Method: req.Method, Resource: req.Resource, Params: req.Params}
+	ctx, span := g.telemetry.StartRequest(parent, start, d)
+	g.telemetry.LogDecision(ctx, d)
+	return ctx, span, decision
+}
+
+// traceContext returns the trace context and baggage an agent sent in
+// traceparent, tracestate and baggage headers, along with the request ID
+func traceContext(header http.Header) context.Context {
+	ctx := telemetry.Extract(propagation.HeaderCarrier(header))
+	return telemetry.WithRequestID(ctx, header.Get(requestIDHeader))
+}
+
+// withTrace returns ctx carrying the span, baggage and request ID of
+// parent, so they are propagated to the tool without giving up ctx's
+// cancellation
+func withTrace(ctx, parent context.Context) context.Context {
+	ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(parent))
+	ctx = telemetry.WithRequestID(ctx, telemetry.RequestID(parent))
+	return baggage.ContextWithBaggage(ctx, baggage.FromContext(parent))
+}
+
+// newDecisionID returns a random ID for a policy evaluation
+func newDecisionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// onPolicyError applies the tool's on_policy_error behavior to a decision
+// the engine could not make. The engine's code and reason are kept so the
+// decision log shows why the fallback was used.
+func (g *Gateway) onPolicyError(req *policy.Request, decision policy.Decision) policy.Decision {
+	g.mu.RLock()
+	mode := g.config.Policies.OnPolicyError
+	g.mu.RUnlock()
+
+	upstream, exists := g.tools.Get(req.Tool)
+	if exists && upstream.OnPolicyError != "" {
+		mode = upstream.OnPolicyError
+	}
+	if mode == "" {
+		mode = config.PolicyErrorDeny
+	}
+
+	decision.Fallback = mode
+	switch mode {
+	case config.PolicyErrorAllow:
+		decision.Allowed = true
+	case config.PolicyErrorDegrade:
+		readOnly := req.Method == http.MethodGet || req.Method == http.MethodHead
+		decision.Allowed = readOnly || (exists && upstream.Cache.Cacheable(req.Action))
+	}
+	return decision
+}
+
+// writeDenial writes a policy violation response. Denials that may succeed
+// later (rate limit, budget, schedule) are sent as 429 with Retry-After so
+// well-behaved agents can back off.
+func (g *Gateway) writeDenial(w http.ResponseWriter, decision policy.Decision) {
+	writeProblem(w, violationBody(decision))
+}
+
+// violationBody is the problem describing a policy denial
+func violationBody(decision policy.Decision) *problem {
+	status := http.StatusForbidden
+	if decision.RetryAfter > 0 {
+		status = http.StatusTooManyRequests
+	}
+	p := newProblem(problemPolicyViolation, status, decision.Reason)
+	p.Code = decision.Code
+	p.DecisionID = decision.ID
+	p.RetryAfter = decision.RetryAfterSeconds()
+	return p
+}
+
+// writeCircuitOpen tells the agent the tool is unavailable without waiting
+// on it
+func (g *Gateway) writeCircuitOpen(w http.ResponseWriter, tool string, retryAfter time.Duration) {
+	p := newProblem(problemUpstreamUnavailable, http.StatusServiceUnavailable, fmt.Sprintf("Tool %s is failing; requests are suspended", tool))
+	p.Code = "CIRCUIT_OPEN"
+	p.RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+	writeProblem(w, p)
+}
+
+// writeIdempotencyConflict rejects a request whose Idempotency-Key can't be
+// honored
+func writeIdempotencyConflict(w http.ResponseWriter, status int, code, reason string) {
+	p := newProblem(problemIdempotencyConflict, status, reason)
+	p.Code = code
+	writeProblem(w, p)
+}
+
+// writeToolBusy tells the agent the tool is at its concurrency limit
+func (g *Gateway) writeToolBusy(w http.ResponseWriter, tool string, err error) {
+	p := newProblem(problemUpstreamUnavailable, http.StatusServiceUnavailable, fmt.Sprintf("Tool %s is at capacity: %v", tool, err))
+	p.Code = "CONCURRENCY_LIMIT"
+	p.RetryAfter = 1
+	writeProblem(w, p)
+}
+
+// writeUpstreamError reports a call the tool did not answer
+func writeUpstreamError(w http.ResponseWriter, status int, detail string) {
+	writeProblem(w, newProblem(problemUpstreamError, status, detail))
+}
+
+// requestBody is a payload forwarded to a tool. It is opened once per
+// attempt, so retries resend it in full.
+type requestBody interface {
+	// open returns a reader over the payload and its content type, or a nil
+	// reader when there is nothing to send
+	open() (io.Reader, string)
+}
+
+// jsonBody is a buffered JSON payload
+type jsonBody []byte
+
+func (b jsonBody) open() (io.Reader, string) {
+	if len(b) == 0 {
+		return nil, ""
+	}
+	return bytes.NewReader(b), "application/json"
+}
+
+// isStreaming reports whether a response should be relayed incrementally:
+// server-sent events and bodies of unknown length (chunked, NDJSON, ...)
+func isStreaming(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream" || resp.ContentLength < 0
+}
+
+// copyResponse relays an upstream body. Streams are flushed after every
+// chunk so the agent sees events as soon as the tool emits them.
+func copyResponse(w http.ResponseWriter, body io.Reader, stream bool) error {
+	if !stream {
+		_, err := io.Copy(w, body)
 		return err
 	}
-	defer resp.Body.Close()
 
-	// Copy response headers
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if ferr := rc.Flush(); ferr != nil && !errors.Is(ferr, http.ErrNotSupported) {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 	}
+}
 
-	// Copy response status
-	w.WriteHeader(resp.StatusCode)
+// isDialError reports whether err happened while connecting, before any
+// part of the request reached the upstream
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// listenerTLSConfig returns the TLS settings shared by the HTTP and gRPC
+// listeners, or nil for plaintext. SPIFFE SVIDs take precedence over
+// certificate files.
+func (g *Gateway) listenerTLSConfig(cfg *config.Config) (*tls.Config, *certReloader, string, error) {
+	if cfg.SPIFFE.Enabled() {
+		if g.spiffe == nil {
+			return nil, nil, "", fmt.Errorf("SPIFFE is configured but no SVID is available")
+		}
+		minVersion, _ := cfg.Server.TLS.MinTLSVersion()
+		return g.spiffe.serverTLSConfig(minVersion), nil, "SPIFFE mTLS", nil
+	}
 
-	// Copy response body
-	_, err = io.Copy(w, resp.Body)
-	return err
+	if cfg.Server.TLS.Enabled() {
+		tlsConfig, reloader, err := buildTLSConfig(cfg.Server.TLS)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return tlsConfig, reloader, "TLS", nil
+	}
+	return nil, nil, "", nil
 }
 
-// StartServer starts the gateway HTTP server
-func (g *Gateway) StartServer(port string) error {
+// StartServer starts the gateway HTTP server on the configured address,
+// along with the gRPC, metrics and admin listeners that are configured
+func (g *Gateway) StartServer() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/tools/", g.HandleRequest)
+	mux.HandleFunc("/v1/policies", g.HandlePolicies)
+	mux.HandleFunc("/v1/tool_calls", g.HandleToolCalls)
+	mux.HandleFunc("/v1/simulate", g.HandleSimulate)
+	mux.HandleFunc("/v1/evaluate/batch", g.HandleEvaluateBatch)
+	mux.HandleFunc("/v1/capabilities", g.HandleCapabilities)
+	mux.HandleFunc("/v1/tools/", g.HandleToolSchema)
+	mux.HandleFunc("/v1/langchain/tools", g.HandleLangChainTools)
+	mux.HandleFunc("/openapi.json", g.HandleOpenAPI)
+	mux.HandleFunc("/mcp", g.HandleMCP)
+	mux.HandleFunc("/mcp/", g.HandleMCPProxy)
+	mux.HandleFunc("/jobs/", g.HandleJob)
 
-	addr := ":" + port
-	fmt.Printf("Aegis Gateway listening on %s\n", addr)
-	return http.ListenAndServe(addr, mux)
-}
+	g.mu.RLock()
+	cfg := g.config
+	g.mu.RUnlock()
+	server := cfg.Server
+
+	// Metrics stay off the agent listener when they have one of their own
+	g.registerMetricsRoutes(mux, server.MetricsAddress == "")
 
+	tlsConfig, reloader, mode, err := g.listenerTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if reloader != nil && server.TLS.ReloadInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go reloader.watch(ctx, server.TLS.ReloadInterval)
+	}
+
+	if server.GRPCAddress != "" {
+		go func() {
+			if err := g.serveGRPC(server.GRPCAddress, tlsConfig); err != nil {
+				logger.Error("gRPC server stopped", "error", err)
+			}
+		}()
+	}
+
+	// Health endpoints stay on the agent listener for load balancers; the
+	// metrics listener serves them too so probes can use a private port
+	if server.MetricsAddress != "" {
+		metricsMux := http.NewServeMux()
+		g.registerMetricsRoutes(metricsMux, true)
+		serveBackground("Aegis metrics", server.MetricsAddress, metricsMux, tlsConfig, mode)
+	}
+
+	// The admin API gets its own listener when admin.address is set, so it
+	// isn't reachable on the interface agents use
+	if cfg.Admin.Address != "" {
+		adminMux := http.NewServeMux()
+		g.registerAdminRoutes(adminMux)
+		admin := withRequestID(g.withCORS(adminMux, func(c *config.Config) config.CORSConfig { return c.Admin.CORS }))
+		serveBackground("Aegis admin API", cfg.Admin.Address, admin, tlsConfig, mode)
+	} else {
+		g.registerAdminRoutes(mux)
+	}
+
+	handler := g.telemetry.TrackInFlight(withRequestID(g.withCORS(mux, func(c *config.Config) config.CORSConfig { return c.Server.CORS })))
+	return serveHTTP("Aegis Gateway", server.Address, handler, tlsConfig, mode)
+}
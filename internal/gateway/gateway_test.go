@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// newTestGateway returns a gateway enforcing the given policy file, with
+// its logs in a temporary directory and no OTLP export. configure, if set,
+// changes the default config first.
+func newTestGateway(t *testing.T, policyYAML string, configure func(*config.Config)) *Gateway {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "policy.yaml"), []byte(policyYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pe, err := policy.LoadPolicyEngine(dir)
+	if err != nil {
+		t.Fatalf("LoadPolicyEngine: %v", err)
+	}
+
+	cfg := config.Default()
+	cfg.Logging.Level = "error"
+	cfg.Telemetry.LogDir = t.TempDir()
+	cfg.Telemetry.OTLPEndpoint = "127.0.0.1:1"
+	if configure != nil {
+		configure(cfg)
+	}
+	tel, err := telemetry.NewTelemetryWithConfig(cfg.Telemetry)
+	if err != nil {
+		t.Fatalf("NewTelemetryWithConfig: %v", err)
+	}
+
+	g := NewGateway(cfg, pe, tel)
+	t.Cleanup(func() {
+		g.Close()
+		tel.Close()
+		pe.Close()
+	})
+	return g
+}
+
+// Settings only read on startup must be reported when a reload changes them,
+// and settings ApplyConfig applies must not be
+func TestRestartRequired(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*config.Config)
+		want   []string
+	}{
+		{"tools", func(cfg *config.Config) { cfg.Tools = nil }, nil},
+		{"CORS", func(cfg *config.Config) { cfg.Server.CORS.AllowedOrigins = []string{"https://app.example.com"} }, nil},
+		{"logging", func(cfg *config.Config) { cfg.Logging.Level = "debug" }, nil},
+		{"listener", func(cfg *config.Config) { cfg.Server.Address = ":9090" }, []string{"Server"}},
+		{"admin listener", func(cfg *config.Config) { cfg.Admin.Address = "127.0.0.1:9443" }, []string{"Admin listener"}},
+		{"telemetry sink", func(cfg *config.Config) {
+			cfg.Telemetry.Sinks = []telemetry.SinkConfig{{Type: "syslog"}}
+		}, []string{"Telemetry"}},
+		{"dead letters and state", func(cfg *config.Config) {
+			cfg.DeadLetter.Enabled = true
+			cfg.State.Backend = config.StateBackendRedis
+		}, []string{"State", "Dead letter"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.change(cfg)
+			got := restartRequired(config.Default(), cfg)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
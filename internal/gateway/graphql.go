@@ -0,0 +1,185 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+)
+
+// maxGraphQLFields caps the field paths collected from one query, which
+// fragments reused at many places can otherwise multiply
+const maxGraphQLFields = 10000
+
+// graphQLRequest is the body of a call to a GraphQL tool
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    json.RawMessage        `json:"extensions,omitempty"`
+}
+
+// parseGraphQL parses calls to GraphQL tools, POSTed to
+// /tools/:tool/query or /tools/:tool/mutation, so policy sees the
+// operation's name, selected fields and depth, with its variables as
+// Params. Once the call is allowed the tool gets the request as the gateway
+// decoded it, so duplicate or case-variant keys can't make it run a query
+// other than the one evaluated.
+func (g *Gateway) parseGraphQL(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		tool, ok := g.tools.Get(c.Tool)
+		if !ok || tool.Protocol != registry.ProtocolGraphQL {
+			next(w, c)
+			return
+		}
+
+		r := c.Request
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, fmt.Sprintf("Tool %s is a GraphQL API; POST queries to /tools/%s/query", c.Tool, c.Tool), http.StatusMethodNotAllowed)
+			return
+		}
+		if c.multipart || c.Resource != "" {
+			writeError(w, fmt.Sprintf("Tool %s is a GraphQL API; POST queries to /tools/%s/query", c.Tool, c.Tool), http.StatusBadRequest)
+			return
+		}
+
+		var req graphQLRequest
+		if err := json.Unmarshal(c.bodyBytes, &req); err != nil || req.Query == "" {
+			writeError(w, "GraphQL calls must be a JSON object with a query", http.StatusBadRequest)
+			return
+		}
+		doc, err := parser.ParseQuery(&ast.Source{Input: req.Query})
+		if err != nil {
+			writeError(w, fmt.Sprintf("Invalid GraphQL query: %v", err), http.StatusBadRequest)
+			return
+		}
+		op, err := graphQLOperation(doc, req.OperationName)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if op.Type != c.Action {
+			writeError(w, fmt.Sprintf("GraphQL %s sent to /tools/%s/%s; use /tools/%s/%s", op.Type, c.Tool, c.Action, c.Tool, op.Type), http.StatusBadRequest)
+			return
+		}
+
+		encoded, err := json.Marshal(req)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Failed to encode GraphQL request: %v", err), http.StatusInternalServerError)
+			return
+		}
+		c.bodyBytes, c.body, c.bodySize = encoded, jsonBody(encoded), len(encoded)
+
+		c.Params = req.Variables
+		if c.Params == nil {
+			c.Params = make(map[string]interface{})
+		}
+		c.graphql = op
+		next(w, c)
+	}
+}
+
+// graphQLOperation picks the operation a query document executes and
+// collects the fields it selects
+func graphQLOperation(doc *ast.QueryDocument, name string) (*policy.GraphQLOperation, error) {
+	var def *ast.OperationDefinition
+	switch {
+	case name != "":
+		def = doc.Operations.ForName(name)
+		if def == nil {
+			return nil, fmt.Errorf("GraphQL operation %s is not defined in the query", name)
+		}
+	case len(doc.Operations) == 1:
+		def = doc.Operations[0]
+	case len(doc.Operations) == 0:
+		return nil, fmt.Errorf("GraphQL query defines no operation")
+	default:
+		return nil, fmt.Errorf("GraphQL query defines several operations; set operationName")
+	}
+
+	op := &policy.GraphQLOperation{Name: def.Name}
+	switch def.Operation {
+	case ast.Query:
+		op.Type = "query"
+	case ast.Mutation:
+		op.Type = "mutation"
+	default:
+		return nil, fmt.Errorf("GraphQL %s operations are not supported", def.Operation)
+	}
+
+	w := fieldWalker{doc: doc, seen: make(map[string]bool), active: make(map[string]bool), expanded: make(map[string]bool)}
+	if err := w.walk(def.SelectionSet, "", 1); err != nil {
+		return nil, err
+	}
+	op.Fields, op.Depth = w.fields, w.depth
+	return op, nil
+}
+
+// fieldWalker collects the dotted field paths of a selection set, expanding
+// fragments
+type fieldWalker struct {
+	doc    *ast.QueryDocument
+	fields []string
+	seen   map[string]bool
+	depth  int
+
+	// active holds the fragments being expanded, to stop cycles, and
+	// expanded the fragments already walked at a path
+	active   map[string]bool
+	expanded map[string]bool
+}
+
+func (w *fieldWalker) walk(set ast.SelectionSet, prefix string, depth int) error {
+	for _, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			path := sel.Name
+			if prefix != "" {
+				path = prefix + "." + sel.Name
+			}
+			if !w.seen[path] {
+				if len(w.fields) == maxGraphQLFields {
+					return fmt.Errorf("GraphQL query selects more than %d fields", maxGraphQLFields)
+				}
+				w.seen[path] = true
+				w.fields = append(w.fields, path)
+			}
+			if depth > w.depth {
+				w.depth = depth
+			}
+			if err := w.walk(sel.SelectionSet, path, depth+1); err != nil {
+				return err
+			}
+		case *ast.InlineFragment:
+			if err := w.walk(sel.SelectionSet, prefix, depth); err != nil {
+				return err
+			}
+		case *ast.FragmentSpread:
+			fragment := w.doc.Fragments.ForName(sel.Name)
+			if fragment == nil {
+				return fmt.Errorf("GraphQL fragment %s is not defined in the query", sel.Name)
+			}
+			if w.active[sel.Name] {
+				return fmt.Errorf("GraphQL fragment %s spreads itself", sel.Name)
+			}
+			key := sel.Name + "\x00" + prefix
+			if w.expanded[key] {
+				continue
+			}
+			w.expanded[key] = true
+			w.active[sel.Name] = true
+			err := w.walk(fragment.SelectionSet, prefix, depth)
+			w.active[sel.Name] = false
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
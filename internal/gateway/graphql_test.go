@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/registry"
+)
+
+// The GraphQL tool must get the query that was evaluated, whichever of
+// duplicate or case-variant keys it would read
+func TestGraphQLForwardsEvaluatedRequest(t *testing.T) {
+	var forwarded []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"data":{}}`)
+	}))
+	defer server.Close()
+
+	policyYAML := `version: "1"
+agents:
+  - id: dev-agent
+    allow:
+      - tool: github
+        actions: [query]
+`
+	g := newTestGateway(t, policyYAML, func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{
+			"github": {Protocol: registry.ProtocolGraphQL, URL: server.URL, Timeout: config.DefaultToolTimeout},
+		}
+	})
+
+	const evaluated = "query { viewer { login } }"
+	tests := []struct {
+		name, body string
+	}{
+		{"case-variant key", `{"query":"mutation { deleteRepo(id: 1) { id } }","Query":"` + evaluated + `"}`},
+		{"duplicate key", `{"query":"mutation { deleteRepo(id: 1) { id } }","query":"` + evaluated + `"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = nil
+			r := httptest.NewRequest(http.MethodPost, "/tools/github/query", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-Agent-ID", "dev-agent")
+			w := httptest.NewRecorder()
+			g.HandleRequest(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", w.Code, w.Body)
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(forwarded, &got); err != nil {
+				t.Fatalf("forwarded %q: %v", forwarded, err)
+			}
+			if len(got) != 1 || got["query"] != evaluated {
+				t.Fatalf("forwarded %s", forwarded)
+			}
+		})
+	}
+}
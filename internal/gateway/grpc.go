@@ -0,0 +1,446 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	aegisv1 "aegis-gateway/api/aegis/v1"
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// grpcServer implements the aegis.v1.Gateway service
+type grpcServer struct {
+	aegisv1.UnimplementedGatewayServer
+	g *Gateway
+}
+
+// serveGRPC serves the gateway service on address. Calls to services of
+// registered gRPC tools are proxied to them after policy evaluation.
+func (g *Gateway) serveGRPC(address string, tlsConfig *tls.Config) error {
+	opts := []grpc.ServerOption{
+		grpc.ForceServerCodec(proxyCodec{}),
+		grpc.UnknownServiceHandler(g.proxyGRPC),
+	}
+	// Unix sockets are plaintext, like the HTTP listeners
+	if _, unix := config.UnixSocketPath(address); tlsConfig != nil && !unix {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	server := grpc.NewServer(opts...)
+	aegisv1.RegisterGatewayServer(server, &grpcServer{g: g})
+
+	g.mu.RLock()
+	extAuthz := g.config.ExtAuthz
+	g.mu.RUnlock()
+	if extAuthz.Enabled {
+		authv3.RegisterAuthorizationServer(server, &extAuthzServer{g: g})
+	}
+
+	lis, err := listen(address)
+	if err != nil {
+		return err
+	}
+	logger.Info("Aegis Gateway gRPC listening", "address", address)
+	return server.Serve(lis)
+}
+
+// grpcIdentity resolves the caller of a gRPC call. Metadata is treated like
+// HTTP headers and the peer certificate like the TLS connection state, so
+// every identity source works the same on both endpoints.
+func (g *Gateway) grpcIdentity(ctx context.Context, fullMethod string, body []byte) (*Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := http.Header{}
+	for key, values := range md {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+
+	r := &http.Request{
+		Method:     http.MethodPost,
+		URL:        &url.URL{Path: fullMethod},
+		RequestURI: fullMethod,
+		Header:     header,
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			r.RemoteAddr = p.Addr.String()
+		}
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+
+	identity, authErr := g.resolveIdentity(r, body)
+	if authErr != nil {
+		code := codes.PermissionDenied
+		switch authErr.status {
+		case http.StatusUnauthorized:
+			code = codes.Unauthenticated
+		case http.StatusBadRequest:
+			code = codes.InvalidArgument
+		}
+		return nil, status.Error(code, authErr.message)
+	}
+	return identity, nil
+}
+
+// decisionIDMetadata is the response header metadata carrying the decision ID
+const decisionIDMetadata = "x-aegis-decision-id"
+
+// requestIDMetadata carries the request ID, like X-Request-ID over HTTP
+const requestIDMetadata = "x-request-id"
+
+// denialStatus converts a policy denial to a gRPC status carrying the deny
+// code as ErrorInfo
+func denialStatus(decision policy.Decision) error {
+	code := codes.PermissionDenied
+	if decision.RetryAfter > 0 {
+		code = codes.ResourceExhausted
+	}
+	st := status.New(code, decision.Reason)
+	info := &errdetails.ErrorInfo{Reason: decision.Code, Domain: "aegis-gateway", Metadata: map[string]string{}}
+	if decision.ID != "" {
+		info.Metadata["decision_id"] = decision.ID
+	}
+	if decision.RetryAfter > 0 {
+		info.Metadata["retry_after"] = fmt.Sprint(decision.RetryAfterSeconds())
+	}
+	if detailed, err := st.WithDetails(info); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// metadataCarrier adapts gRPC metadata for trace context propagation
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// grpcTraceContext returns the trace context and baggage sent in the
+// metadata of an incoming call, with its request ID or a new one
+func grpcTraceContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	carrier := metadataCarrier(md)
+	return telemetry.WithRequestID(telemetry.Extract(carrier), validRequestID(carrier.Get(requestIDMetadata)))
+}
+
+// Evaluate implements aegis.v1.Gateway
+func (s *grpcServer) Evaluate(ctx context.Context, req *aegisv1.EvaluateRequest) (*aegisv1.EvaluateResponse, error) {
+	start := time.Now()
+	body, params := structParams(req.GetParams())
+
+	identity, err := s.g.grpcIdentity(ctx, aegisv1.Gateway_Evaluate_FullMethodName, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Evaluate only checks the call, so it doesn't consume rate limits or
+	// budgets
+	parent := grpcTraceContext(ctx)
+	decision := s.g.precheck(parent, start, identity, &policy.Request{
+		Tool:     req.GetTool(),
+		Action:   req.GetAction(),
+		Method:   http.MethodPost,
+		Params:   params,
+		BodySize: len(body),
+	})
+	grpc.SetHeader(ctx, metadata.Pairs(decisionIDMetadata, decision.ID, requestIDMetadata, telemetry.RequestID(parent)))
+
+	return &aegisv1.EvaluateResponse{
+		Allowed:           decision.Allowed,
+		Code:              decision.Code,
+		Reason:            decision.Reason,
+		Rollout:           decision.Rollout,
+		RetryAfterSeconds: int32(decision.RetryAfterSeconds()),
+	}, nil
+}
+
+// Invoke implements aegis.v1.Gateway
+func (s *grpcServer) Invoke(ctx context.Context, req *aegisv1.InvokeRequest) (*aegisv1.InvokeResponse, error) {
+	g := s.g
+	start := time.Now()
+	tool, action := req.GetTool(), req.GetAction()
+	body, params := structParams(req.GetParams())
+
+	identity, err := g.grpcIdentity(ctx, aegisv1.Gateway_Invoke_FullMethodName, body)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := grpcTraceContext(ctx)
+	spanCtx, span, decision := g.evaluate(parent, start, identity, tool, action, params, len(body))
+	defer span.End()
+	grpc.SetHeader(ctx, metadata.Pairs(decisionIDMetadata, decision.ID, requestIDMetadata, telemetry.RequestID(parent)))
+	if !decision.Allowed {
+		return nil, denialStatus(decision)
+	}
+
+	upstream, exists := g.tools.Get(tool)
+	if !exists {
+		return nil, status.Errorf(codes.InvalidArgument, "Unknown tool: %s", tool)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	idempotent := upstream.Retry.Idempotent(http.MethodPost, action, len(md.Get("idempotency-key")) > 0)
+	code, out, err := g.callTool(ctx, spanCtx, upstream, action, body, idempotent, identity, decision.Response)
+	if err != nil {
+		return nil, upstreamStatus(err)
+	}
+	return invokeResponse(code, out), nil
+}
+
+// structParams returns the JSON body and policy params for a Struct
+func structParams(s *structpb.Struct) ([]byte, map[string]interface{}) {
+	if s == nil {
+		return []byte("{}"), map[string]interface{}{}
+	}
+	body, _ := protojson.Marshal(s)
+	return body, s.AsMap()
+}
+
+// invokeResponse wraps a tool response, decoding JSON objects into result
+func invokeResponse(code int, body []byte) *aegisv1.InvokeResponse {
+	resp := &aegisv1.InvokeResponse{Status: int32(code)}
+	result := &structpb.Struct{}
+	if protojson.Unmarshal(body, result) == nil {
+		resp.Result = result
+	} else {
+		resp.Body = body
+	}
+	return resp
+}
+
+// upstreamStatus passes gRPC errors from a tool through unchanged
+func upstreamStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var violation *responseViolation
+	if errors.As(err, &violation) {
+		st := status.New(codes.PermissionDenied, violation.reason)
+		if detailed, derr := st.WithDetails(&errdetails.ErrorInfo{Reason: violation.code, Domain: "aegis-gateway"}); derr == nil {
+			st = detailed
+		}
+		return st.Err()
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// proxyGRPC relays calls to the native service of a gRPC tool. The method
+// name is the action and every request message is decoded and evaluated
+// against policy before it is forwarded, so streaming calls stay governed
+// for their whole lifetime. Response messages are relayed as the tool sends
+// them, so calls allowed by a rule with response constraints are refused;
+// Invoke applies them.
+func (g *Gateway) proxyGRPC(_ interface{}, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	fullMethod, ok := grpc.MethodFromServerStream(stream)
+	if !ok {
+		return status.Error(codes.Internal, "missing method name")
+	}
+	service, action, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+
+	upstream := g.grpcTool(service)
+	if upstream == nil {
+		return status.Errorf(codes.Unimplemented, "unknown service %s", service)
+	}
+	method, err := g.grpc.methodDescriptor(upstream, action)
+	if err != nil {
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+
+	// The first message is needed to authenticate signed calls
+	var first rawFrame
+	if err := stream.RecvMsg(&first); err != nil {
+		return err
+	}
+	identity, err := g.grpcIdentity(ctx, fullMethod, first.payload)
+	if err != nil {
+		return err
+	}
+	upstream = upstream.Route(identity.AgentID)
+	parent := grpcTraceContext(ctx)
+
+	release, err := upstream.Limiter.Acquire(ctx)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "Tool %s is at capacity: %v", upstream.Name, err)
+	}
+	defer release()
+
+	evaluate := func(frame *rawFrame) error {
+		params, err := decodeGRPCParams(method, frame.payload)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid %s message: %v", method.Input().FullName(), err)
+		}
+		_, span, decision := g.evaluate(parent, time.Now(), identity, upstream.Name, action, params, len(frame.payload))
+		span.End()
+		if !decision.Allowed {
+			return denialStatus(decision)
+		}
+		if decision.Response != nil {
+			return status.Errorf(codes.FailedPrecondition, "Tool %s has response rules for %s, which native gRPC calls can't apply; call it through Invoke", upstream.Name, action)
+		}
+		return nil
+	}
+	if err := evaluate(&first); err != nil {
+		return err
+	}
+
+	done, _, ok := upstream.Allow()
+	if !ok {
+		return status.Errorf(codes.Unavailable, "Tool %s is failing; requests are suspended", upstream.Name)
+	}
+
+	conn, instance, err := g.grpcConn(upstream)
+	if err != nil {
+		done(false)
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	upstreamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	upstreamCtx = withTrace(upstreamCtx, parent)
+	if upstreamCtx, err = g.upstreamContext(upstreamCtx, upstream); err != nil {
+		done(false)
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	releaseInstance := upstream.Acquire(instance)
+	clientStream, err := conn.NewStream(upstreamCtx, &grpc.StreamDesc{ClientStreams: true, ServerStreams: true}, fullMethod)
+	if err != nil {
+		releaseInstance(false)
+		done(false)
+		return upstreamStatus(err)
+	}
+
+	err = relayGRPC(stream, clientStream, cancel, &first, evaluate)
+	releaseInstance(err == nil)
+	done(err == nil)
+	return err
+}
+
+// relayGRPC pipes messages in both directions until the upstream finishes.
+// A denied message or a broken agent stream cancels the upstream call with
+// cancel, so neither direction keeps running, and its error is returned.
+func relayGRPC(server grpc.ServerStream, client grpc.ClientStream, cancel context.CancelFunc, first *rawFrame, evaluate func(*rawFrame) error) error {
+	sendErr := make(chan error, 1)
+	go func() {
+		frame := first
+		for {
+			if err := client.SendMsg(frame); err != nil {
+				sendErr <- nil
+				return
+			}
+			next := &rawFrame{}
+			err := server.RecvMsg(next)
+			if errors.Is(err, io.EOF) {
+				client.CloseSend()
+				sendErr <- nil
+				return
+			}
+			if err == nil {
+				err = evaluate(next)
+			}
+			if err != nil {
+				sendErr <- err
+				cancel()
+				return
+			}
+			frame = next
+		}
+	}()
+
+	// agentFailed returns the agent side's error once it canceled the
+	// upstream call, which then fails with Canceled
+	agentFailed := func() error {
+		select {
+		case err := <-sendErr:
+			return err
+		default:
+			return nil
+		}
+	}
+
+	header, err := client.Header()
+	if err != nil {
+		if agentErr := agentFailed(); agentErr != nil {
+			return agentErr
+		}
+		return upstreamStatus(err)
+	}
+	server.SendHeader(header)
+
+	for {
+		frame := &rawFrame{}
+		if err := client.RecvMsg(frame); err != nil {
+			if agentErr := agentFailed(); agentErr != nil {
+				return agentErr
+			}
+			server.SetTrailer(client.Trailer())
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return upstreamStatus(err)
+		}
+		if err := server.SendMsg(frame); err != nil {
+			return err
+		}
+
+		select {
+		case err := <-sendErr:
+			if err != nil {
+				return err
+			}
+			sendErr = nil
+		default:
+		}
+	}
+}
+
+// grpcTool finds the gRPC tool that serves service
+func (g *Gateway) grpcTool(service string) *registry.Tool {
+	for _, name := range g.tools.Names() {
+		if t, ok := g.tools.Get(name); ok && t.Protocol == registry.ProtocolGRPC && t.GRPC.Service == service {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeAgentStream is the agent side of a streaming call, sending frames
+// and then holding the stream open
+type fakeAgentStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	frames chan *rawFrame
+}
+
+func (s *fakeAgentStream) Context() context.Context     { return s.ctx }
+func (s *fakeAgentStream) SendHeader(metadata.MD) error { return nil }
+func (s *fakeAgentStream) SetTrailer(metadata.MD)       {}
+func (s *fakeAgentStream) SendMsg(interface{}) error    { return nil }
+func (s *fakeAgentStream) RecvMsg(m interface{}) error {
+	select {
+	case f, ok := <-s.frames:
+		if !ok {
+			return io.EOF
+		}
+		*m.(*rawFrame) = *f
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// fakeToolStream is a tool that never answers until its call is canceled
+type fakeToolStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	sent chan []byte
+}
+
+func (s *fakeToolStream) Header() (metadata.MD, error) { return metadata.MD{}, nil }
+func (s *fakeToolStream) Trailer() metadata.MD         { return nil }
+func (s *fakeToolStream) CloseSend() error             { return nil }
+func (s *fakeToolStream) SendMsg(m interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.sent <- m.(*rawFrame).payload
+	return nil
+}
+func (s *fakeToolStream) RecvMsg(interface{}) error {
+	<-s.ctx.Done()
+	return status.FromContextError(s.ctx.Err()).Err()
+}
+
+// A message denied mid-stream must end the call in both directions at once,
+// not when the tool next sends something
+func TestRelayGRPCDenialCancelsUpstream(t *testing.T) {
+	agentCtx, agentDone := context.WithCancel(context.Background())
+	defer agentDone()
+	upstreamCtx, cancel := context.WithCancel(agentCtx)
+	defer cancel()
+
+	agent := &fakeAgentStream{ctx: agentCtx, frames: make(chan *rawFrame, 2)}
+	agent.frames <- &rawFrame{payload: []byte("denied")}
+	tool := &fakeToolStream{ctx: upstreamCtx, sent: make(chan []byte, 2)}
+	denial := status.Error(codes.PermissionDenied, "denied")
+	evaluate := func(f *rawFrame) error {
+		if string(f.payload) == "denied" {
+			return denial
+		}
+		return nil
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- relayGRPC(agent, tool, cancel, &rawFrame{payload: []byte("allowed")}, evaluate) }()
+	select {
+	case err := <-result:
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("got %v, want the denial", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay kept running after a denial")
+	}
+	if upstreamCtx.Err() == nil {
+		t.Fatal("upstream call was not canceled")
+	}
+	close(tool.sent)
+	for payload := range tool.sent {
+		if string(payload) == "denied" {
+			t.Fatal("denied message was forwarded")
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// rawFrame is an undecoded gRPC message relayed by the passthrough proxy
+type rawFrame struct {
+	payload []byte
+}
+
+// proxyCodec marshals rawFrames as-is and everything else as protobuf, so
+// the typed gateway service and the passthrough proxy share one server
+type proxyCodec struct{}
+
+// Marshal implements encoding.Codec
+func (proxyCodec) Marshal(v interface{}) ([]byte, error) {
+	if f, ok := v.(*rawFrame); ok {
+		return f.payload, nil
+	}
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal %T", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal implements encoding.Codec
+func (proxyCodec) Unmarshal(data []byte, v interface{}) error {
+	if f, ok := v.(*rawFrame); ok {
+		f.payload = append([]byte(nil), data...)
+		return nil
+	}
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal into %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// Name implements encoding.Codec
+func (proxyCodec) Name() string {
+	return "proto"
+}
+
+// grpcUpstreams caches connections and descriptors for gRPC tools
+type grpcUpstreams struct {
+	mu          sync.Mutex
+	conns       map[string]*grpc.ClientConn
+	descriptors map[string]*protoregistry.Files
+}
+
+// methodDescriptor resolves an action of a gRPC tool to its method
+func (u *grpcUpstreams) methodDescriptor(tool *registry.Tool, action string) (protoreflect.MethodDescriptor, error) {
+	u.mu.Lock()
+	files, ok := u.descriptors[tool.GRPC.DescriptorSet]
+	u.mu.Unlock()
+
+	if !ok {
+		data, err := os.ReadFile(tool.GRPC.DescriptorSet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read descriptor set for tool %s: %w", tool.Name, err)
+		}
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &set); err != nil {
+			return nil, fmt.Errorf("failed to parse descriptor set for tool %s: %w", tool.Name, err)
+		}
+		if files, err = protodesc.NewFiles(&set); err != nil {
+			return nil, fmt.Errorf("invalid descriptor set for tool %s: %w", tool.Name, err)
+		}
+
+		u.mu.Lock()
+		if u.descriptors == nil {
+			u.descriptors = make(map[string]*protoregistry.Files)
+		}
+		u.descriptors[tool.GRPC.DescriptorSet] = files
+		u.mu.Unlock()
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(tool.GRPC.Service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not found in descriptor set", tool.GRPC.Service)
+	}
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", tool.GRPC.Service)
+	}
+	method := service.Methods().ByName(protoreflect.Name(action))
+	if method == nil {
+		return nil, fmt.Errorf("service %s has no method %s", tool.GRPC.Service, action)
+	}
+	return method, nil
+}
+
+// grpcConn returns a client connection to the tool's first instance
+func (g *Gateway) grpcConn(tool *registry.Tool) (*grpc.ClientConn, string, error) {
+	instances := tool.Instances()
+	if len(instances) == 0 {
+		return nil, "", fmt.Errorf("no instances available for tool %s", tool.Name)
+	}
+	instance := instances[0]
+
+	u := &g.grpc
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if conn, ok := u.conns[instance]; ok {
+		return conn, instance, nil
+	}
+
+	target, err := url.Parse(instance)
+	if err != nil {
+		return nil, "", err
+	}
+	creds := insecure.NewCredentials()
+	if target.Scheme == "grpcs" || target.Scheme == "https" {
+		tlsConfig, err := g.toolTLSConfig(tool)
+		if err != nil {
+			return nil, "", err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	// gRPC skips its own proxy support with a custom dialer, so the tool's
+	// proxy is applied here
+	dial := g.proxyDial(tool.Transport, g.egressDial(newDialer(tool.Transport).DialContext))
+	conn, err := grpc.Dial(target.Host,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) { return dial(ctx, "tcp", addr) }),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(proxyCodec{})))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to tool %s: %w", tool.Name, err)
+	}
+	if u.conns == nil {
+		u.conns = make(map[string]*grpc.ClientConn)
+	}
+	u.conns[instance] = conn
+	return conn, instance, nil
+}
+
+// upstreamContext carries the tool credentials, never the agent's own
+func (g *Gateway) upstreamContext(ctx context.Context, tool *registry.Tool) (context.Context, error) {
+	credHeader, credValue, err := g.toolCredentials(tool)
+	if err != nil {
+		return nil, err
+	}
+	md := metadata.MD{}
+	if credHeader != "" {
+		md.Set(credHeader, credValue)
+	}
+	telemetry.Inject(ctx, metadataCarrier(md))
+	if id := telemetry.RequestID(ctx); id != "" {
+		md.Set(requestIDMetadata, id)
+	}
+	return metadata.NewOutgoingContext(ctx, md), nil
+}
+
+// invokeGRPCTool calls a unary method of a gRPC tool with JSON params and
+// returns the response as JSON
+func (g *Gateway) invokeGRPCTool(ctx context.Context, tool *registry.Tool, action string, body []byte) ([]byte, error) {
+	method, err := g.grpc.methodDescriptor(tool, action)
+	if err != nil {
+		return nil, err
+	}
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return nil, fmt.Errorf("streaming method %s can only be called over gRPC", action)
+	}
+
+	req := dynamicpb.NewMessage(method.Input())
+	if len(body) > 0 {
+		if err := protojson.Unmarshal(body, req); err != nil {
+			return nil, fmt.Errorf("params do not match %s: %w", method.Input().FullName(), err)
+		}
+	}
+
+	if tool.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tool.Timeout)
+		defer cancel()
+	}
+	ctx, err = g.upstreamContext(ctx, tool)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, instance, err := g.grpcConn(tool)
+	if err != nil {
+		return nil, err
+	}
+	release := tool.Acquire(instance)
+	resp := dynamicpb.NewMessage(method.Output())
+	err = conn.Invoke(ctx, grpcMethodName(tool, action), req, resp)
+	release(err == nil)
+	if err != nil {
+		return nil, err
+	}
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(resp)
+}
+
+// decodeGRPCParams turns a raw request message into policy params
+func decodeGRPCParams(method protoreflect.MethodDescriptor, payload []byte) (map[string]interface{}, error) {
+	msg := dynamicpb.NewMessage(method.Input())
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil, err
+	}
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]interface{})
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// grpcMethodName returns the full method name of a tool action
+func grpcMethodName(tool *registry.Tool, action string) string {
+	return "/" + tool.GRPC.Service + "/" + action
+}
+
+// close releases all upstream connections
+func (u *grpcUpstreams) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, conn := range u.conns {
+		conn.Close()
+	}
+	u.conns = nil
+}
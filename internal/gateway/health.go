@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// upstreamCheckTimeout bounds each tool health probe during /readyz
+const upstreamCheckTimeout = 2 * time.Second
+
+// HandleHealthz serves GET /healthz; it only reports that the process is up
+func (g *Gateway) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readiness is the body of a /readyz response
+type readiness struct {
+	Status    string            `json:"status"`
+	Checks    map[string]string `json:"checks"`
+	Upstreams map[string]string `json:"upstreams,omitempty"`
+	Telemetry map[string]string `json:"telemetry,omitempty"`
+}
+
+// HandleReadyz serves GET /readyz. The gateway is ready once at least one
+// policy file is loaded and the policy watcher is running. With
+// ?upstreams=true, every tool with a health_check must also respond.
+// Failing telemetry exporters make a ready gateway degraded, which doesn't
+// take it out of rotation.
+func (g *Gateway) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	result := readiness{Status: "ready", Checks: map[string]string{}}
+	ready := true
+
+	status := g.policyEngine.Status()
+	if status.Files > 0 {
+		result.Checks["policies"] = "ok"
+	} else {
+		result.Checks["policies"] = "no policy files loaded"
+		ready = false
+	}
+	if status.Watching {
+		result.Checks["policy_watcher"] = "ok"
+	} else {
+		result.Checks["policy_watcher"] = "not running"
+		ready = false
+	}
+
+	if r.URL.Query().Get("upstreams") == "true" {
+		result.Upstreams = g.checkUpstreams(r.Context())
+		for _, state := range result.Upstreams {
+			if state != "ok" {
+				ready = false
+			}
+		}
+	}
+
+	result.Telemetry = g.telemetry.Health()
+	for _, state := range result.Telemetry {
+		if state != "ok" {
+			result.Status = "degraded"
+		}
+	}
+
+	code := http.StatusOK
+	if !ready {
+		result.Status = "not_ready"
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, result)
+}
+
+// checkUpstreams probes every tool that has a health check path
+func (g *Gateway) checkUpstreams(ctx context.Context) map[string]string {
+	results := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, name := range g.tools.Names() {
+		tool, ok := g.tools.Get(name)
+		if !ok {
+			continue
+		}
+		url := tool.HealthURL()
+		if url == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(name, url string) {
+			defer wg.Done()
+			state := g.probe(ctx, url)
+			mu.Lock()
+			results[name] = state
+			mu.Unlock()
+		}(name, url)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// probe issues a health check request and summarizes the outcome
+func (g *Gateway) probe(ctx context.Context, url string) string {
+	ctx, cancel := context.WithTimeout(ctx, upstreamCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err.Error()
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "unreachable"
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return http.StatusText(resp.StatusCode)
+	}
+	return "ok"
+}
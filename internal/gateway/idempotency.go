@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyState is the outcome of looking up an Idempotency-Key
+type idempotencyState int
+
+const (
+	// idempotencyNew means the caller owns the key and must finish it
+	idempotencyNew idempotencyState = iota
+	// idempotencyReplay means a stored response can be returned
+	idempotencyReplay
+	// idempotencyInFlight means the first request is still being forwarded
+	idempotencyInFlight
+	// idempotencyMismatch means the key was used for a different request
+	idempotencyMismatch
+	// idempotencyFull means every remembered key is still in flight
+	idempotencyFull
+)
+
+// idempotencyEntry is a response remembered for an Idempotency-Key
+type idempotencyEntry struct {
+	fingerprint string
+	pending     bool
+	created     time.Time
+	expires     time.Time
+
+	status int
+	header http.Header
+	body   []byte
+
+	// truncated is set when the response was too large to keep; only its
+	// status is known
+	truncated bool
+}
+
+// idempotencyStore remembers recent responses per agent and Idempotency-Key,
+// so an agent retrying after a timeout gets the original answer instead of
+// a second forwarded call
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	nextGC  time.Time
+}
+
+// begin looks up key. For a new key, an in-flight entry is recorded that the
+// caller must complete with finish. With maxEntries keys remembered, the
+// oldest finished one is dropped to make room.
+func (s *idempotencyStore) begin(key, fingerprint string, now time.Time, maxEntries int) (*idempotencyEntry, idempotencyState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.entries == nil {
+		s.entries = make(map[string]*idempotencyEntry)
+	}
+	if now.After(s.nextGC) {
+		for k, e := range s.entries {
+			if !e.pending && now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.nextGC = now.Add(time.Minute)
+	}
+
+	if e, ok := s.entries[key]; ok && (e.pending || now.Before(e.expires)) {
+		switch {
+		case e.fingerprint != fingerprint:
+			return nil, idempotencyMismatch
+		case e.pending:
+			return nil, idempotencyInFlight
+		default:
+			return e, idempotencyReplay
+		}
+	}
+
+	if _, exists := s.entries[key]; !exists && len(s.entries) >= maxEntries {
+		var oldestKey string
+		var oldest time.Time
+		for k, e := range s.entries {
+			if !e.pending && now.After(e.expires) {
+				delete(s.entries, k)
+				continue
+			}
+			if !e.pending && (oldestKey == "" || e.created.Before(oldest)) {
+				oldestKey, oldest = k, e.created
+			}
+		}
+		if len(s.entries) >= maxEntries {
+			if oldestKey == "" {
+				return nil, idempotencyFull
+			}
+			delete(s.entries, oldestKey)
+		}
+	}
+
+	s.entries[key] = &idempotencyEntry{fingerprint: fingerprint, pending: true, created: now}
+	return nil, idempotencyNew
+}
+
+// finish stores the recorded response under key. Calls that never reached
+// the tool are forgotten so the next attempt is forwarded. Anything else is
+// replayed, including 5xx responses and timeouts, after which the tool may
+// have acted on the call anyway.
+func (s *idempotencyStore) finish(key string, rec *recordingWriter, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	if !rec.forwarded {
+		delete(s.entries, key)
+		return
+	}
+
+	e.pending = false
+	e.expires = time.Now().Add(ttl)
+	e.status = rec.status
+	if rec.overflow {
+		e.truncated = true
+		return
+	}
+	e.header = rec.Header().Clone()
+	e.body = rec.body.Bytes()
+}
+
+// replay writes a stored response. A response that was too large to keep
+// is answered with a conflict naming its status instead.
+func (e *idempotencyEntry) replay(w http.ResponseWriter) {
+	if e.truncated {
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeIdempotencyConflict(w, http.StatusConflict, "IDEMPOTENCY_RESPONSE_UNAVAILABLE",
+			fmt.Sprintf("A request with this Idempotency-Key was already forwarded and answered %d; the response was too large to keep", e.status))
+		return
+	}
+	for key, values := range e.header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// recordingWriter passes a response through while keeping a copy of up to
+// limit body bytes
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	limit  int
+
+	// forwarded is set once the call reached the tool; denials and gateway
+	// errors are not remembered
+	forwarded bool
+	overflow  bool
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if !rw.overflow {
+		if rw.body.Len()+len(p) > rw.limit {
+			rw.overflow = true
+			rw.body = bytes.Buffer{}
+		} else {
+			rw.body.Write(p)
+		}
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Hijack lets WebSocket handlers behind requireAdmin take over the
+// connection
+func (rw *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+// Unwrap lets http.ResponseController flush the underlying writer
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
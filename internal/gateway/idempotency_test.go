@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+func TestIdempotencyFinish(t *testing.T) {
+	tests := []struct {
+		name       string
+		forwarded  bool
+		status     int
+		body       string
+		wantState  idempotencyState
+		wantStatus int
+	}{
+		{"denied", false, http.StatusForbidden, "denied", idempotencyNew, 0},
+		{"answered", true, http.StatusOK, "ok", idempotencyReplay, http.StatusOK},
+		{"tool failed", true, http.StatusInternalServerError, "failed", idempotencyReplay, http.StatusInternalServerError},
+		{"too large", true, http.StatusOK, "much too large", idempotencyReplay, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s idempotencyStore
+			now := time.Now()
+			if _, state := s.begin("key", "call", now, 10); state != idempotencyNew {
+				t.Fatalf("got state %d", state)
+			}
+			rec := &recordingWriter{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK, limit: 10, forwarded: tt.forwarded}
+			rec.WriteHeader(tt.status)
+			rec.Write([]byte(tt.body))
+			s.finish("key", rec, time.Hour)
+
+			entry, state := s.begin("key", "call", now, 10)
+			if state != tt.wantState {
+				t.Fatalf("got state %d, want %d", state, tt.wantState)
+			}
+			if entry == nil {
+				return
+			}
+			w := httptest.NewRecorder()
+			entry.replay(w)
+			if w.Code != tt.wantStatus || w.Header().Get("Idempotent-Replayed") != "true" {
+				t.Fatalf("replayed %d %v", w.Code, w.Header())
+			}
+		})
+	}
+}
+
+func TestIdempotencyMaxEntries(t *testing.T) {
+	var s idempotencyStore
+	now := time.Now()
+	finished := &recordingWriter{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK, limit: 10, forwarded: true}
+
+	s.begin("first", "call", now, 2)
+	s.finish("first", finished, time.Hour)
+	s.begin("second", "call", now.Add(time.Second), 2)
+	if _, state := s.begin("third", "call", now.Add(2*time.Second), 2); state != idempotencyNew {
+		t.Fatalf("got state %d", state)
+	}
+	if _, ok := s.entries["first"]; ok || len(s.entries) != 2 {
+		t.Fatalf("oldest finished key was kept: %d entries", len(s.entries))
+	}
+	if _, state := s.begin("fourth", "call", now.Add(3*time.Second), 2); state != idempotencyFull {
+		t.Fatalf("evicted an in-flight key: state %d", state)
+	}
+}
+
+// A tool that failed may still have acted on the call, so a retry must not
+// reach it again
+func TestIdempotentRetryAfterToolError(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	g := newTestGateway(t, rateLimitedPolicy, func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{
+			"payments": {URL: server.URL, Timeout: config.DefaultToolTimeout},
+		}
+	})
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/tools/payments/create", strings.NewReader(`{"amount":10}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Agent-ID", "finance-agent")
+		r.Header.Set("Idempotency-Key", "payment-1")
+		w := httptest.NewRecorder()
+		g.HandleRequest(w, r)
+		if w.Code != http.StatusBadGateway {
+			t.Fatalf("attempt %d: got %d: %s", i+1, w.Code, w.Body)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("tool was called %d times", n)
+	}
+}
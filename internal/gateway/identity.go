@@ -0,0 +1,274 @@
+package gateway
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"aegis-gateway/internal/auth"
+	"aegis-gateway/internal/config"
+)
+
+// Identity sources recorded on an Identity
+const (
+	IdentitySourceHeader = "header"
+	IdentitySourceMTLS   = "mtls"
+	IdentitySourceJWT    = "jwt"
+	IdentitySourceAPIKey = "api_key"
+	IdentitySourceHMAC   = "hmac"
+	IdentitySourceOIDC   = "oidc"
+	IdentitySourceSPIFFE = "spiffe"
+)
+
+// Identity is the agent identity a request is evaluated as
+type Identity struct {
+	AgentID string
+	Source  string
+
+	// Claims are the verified token claims for JWT and OIDC identities
+	Claims map[string]interface{}
+
+	// Groups are the policy groups mapped from an OIDC token
+	Groups []string
+
+	// SessionID correlates the calls of one agent run, taken from
+	// X-Agent-Session-ID or generated for the request
+	SessionID string
+
+	// SessionGenerated is set when SessionID was generated
+	SessionGenerated bool
+
+	// SchemaVersion is the tool schema version the agent builds its calls
+	// against, from X-Aegis-Schema-Version; "" means the current version
+	SchemaVersion string
+
+	// Peer is the IP address the call came from, "" when unknown
+	Peer string
+}
+
+// Verified reports whether the agent ID was proven by a credential rather
+// than taken from the X-Agent-ID header
+func (id *Identity) Verified() bool {
+	return id.Source != IdentitySourceHeader
+}
+
+// sessionIDHeader carries the agent's session or conversation ID
+const sessionIDHeader = "X-Agent-Session-ID"
+
+// maxSessionIDLength bounds agent-supplied session IDs
+const maxSessionIDLength = 128
+
+// sessionID returns the session ID sent by the agent, or a new one if none
+// was sent
+func sessionID(r *http.Request) (string, *authError) {
+	id := r.Header.Get(sessionIDHeader)
+	if id == "" {
+		return newDecisionID(), nil
+	}
+	if len(id) > maxSessionIDLength {
+		return "", &authError{http.StatusBadRequest, "X-Agent-Session-ID is too long"}
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return "", &authError{http.StatusBadRequest, "X-Agent-Session-ID must be printable ASCII without spaces"}
+		}
+	}
+	return id, nil
+}
+
+// authError is an identity failure with the status it should be reported as
+type authError struct {
+	status  int
+	message string
+}
+
+// Error implements the error interface
+func (e *authError) Error() string {
+	return e.message
+}
+
+// writeAuthError reports an identity failure to the caller
+func writeAuthError(w http.ResponseWriter, r *http.Request, err *authError) {
+	if err.status == http.StatusUnauthorized && r.Header.Get(auth.SignatureHeader) == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	writeError(w, err.message, err.status)
+}
+
+// resolveIdentity establishes who is calling and in which session
+func (g *Gateway) resolveIdentity(r *http.Request, body []byte) (*Identity, *authError) {
+	identity, err := g.authenticate(r, body)
+	if err != nil {
+		return nil, err
+	}
+	if identity.SessionID, err = sessionID(r); err != nil {
+		return nil, err
+	}
+	identity.SessionGenerated = r.Header.Get(sessionIDHeader) == ""
+	identity.SchemaVersion = r.Header.Get(schemaVersionHeader)
+	identity.Peer = peerAddress(r.RemoteAddr)
+	return identity, nil
+}
+
+// peerAddress returns the IP address of a request's remote address
+func peerAddress(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// authenticate establishes the agent identity. A verified client
+// certificate, request signature, API key or bearer token takes precedence
+// over the X-Agent-ID header; when the header is also sent it must agree, so
+// it can't be used to impersonate another agent. The header alone is only
+// an identity when no authenticator is configured or
+// auth.allow_header_identity is set.
+func (g *Gateway) authenticate(r *http.Request, body []byte) (*Identity, *authError) {
+	g.mu.RLock()
+	authCfg := g.config.Auth
+	headerAllowed := g.config.HeaderIdentityAllowed()
+	verifier := g.jwt
+	providers := g.oidc
+	signatures := g.hmac
+	g.mu.RUnlock()
+
+	headerID := r.Header.Get("X-Agent-ID")
+
+	var identity *Identity
+	switch {
+	case r.TLS != nil && len(r.TLS.VerifiedChains) > 0:
+		certID := certificateIdentity(r.TLS.VerifiedChains[0][0], authCfg.MTLS)
+		if certID == "" {
+			return nil, &authError{http.StatusForbidden, "Client certificate does not carry an agent identity"}
+		}
+		identity = &Identity{AgentID: certID, Source: IdentitySourceMTLS}
+
+	case r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && g.spiffe != nil:
+		// SVIDs are verified against the SPIFFE bundle during the handshake,
+		// which leaves VerifiedChains empty. The SPIFFE ID is the agent ID.
+		certID := certificateIdentity(r.TLS.PeerCertificates[0], config.MTLSConfig{})
+		if certID == "" {
+			return nil, &authError{http.StatusForbidden, "Client certificate is not an SVID"}
+		}
+		identity = &Identity{AgentID: certID, Source: IdentitySourceSPIFFE}
+
+	case signatures != nil && (r.Header.Get(auth.SignatureHeader) != "" || authCfg.HMAC.Required && authCfg.HMAC.Secrets[headerID] != ""):
+		signature := r.Header.Get(auth.SignatureHeader)
+		if signature == "" {
+			return nil, &authError{http.StatusUnauthorized, "Request signature is required"}
+		}
+		if headerID == "" {
+			return nil, &authError{http.StatusBadRequest, "Signed requests must include X-Agent-ID"}
+		}
+		err := signatures.Verify(headerID, r.Header.Get(auth.TimestampHeader), r.Header.Get(auth.NonceHeader),
+			signature, r.Method, r.URL.RequestURI(), body)
+		if err != nil {
+			return nil, &authError{http.StatusUnauthorized, "Invalid request signature: " + err.Error()}
+		}
+		identity = &Identity{AgentID: headerID, Source: IdentitySourceHMAC}
+
+	default:
+		var authErr *authError
+		if identity, authErr = g.bearerIdentity(r, authCfg, verifier, providers); authErr != nil {
+			return nil, authErr
+		}
+	}
+
+	if identity != nil {
+		if headerID != "" && headerID != identity.AgentID {
+			return nil, &authError{http.StatusForbidden, "X-Agent-ID does not match the authenticated identity"}
+		}
+		return identity, nil
+	}
+
+	if !headerAllowed {
+		return nil, &authError{http.StatusUnauthorized, "Authentication is required"}
+	}
+	if headerID == "" {
+		return nil, &authError{http.StatusBadRequest, "Missing X-Agent-ID header"}
+	}
+	return &Identity{AgentID: headerID, Source: IdentitySourceHeader}, nil
+}
+
+// bearerIdentity authenticates the Authorization header as a gateway API
+// key, an OIDC ID token or a JWT. It returns nil when no token was presented
+// and none is required.
+func (g *Gateway) bearerIdentity(r *http.Request, authCfg config.AuthConfig, verifier *auth.JWTVerifier, providers *auth.OIDCVerifier) (*Identity, *authError) {
+	token, err := auth.BearerToken(r.Header.Get("Authorization"))
+	if errors.Is(err, auth.ErrNoToken) {
+		if authCfg.JWT.Required {
+			return nil, &authError{http.StatusUnauthorized, "Missing bearer token"}
+		}
+		return nil, nil
+	}
+
+	if strings.HasPrefix(token, auth.APIKeyPrefix) && g.apiKeys != nil {
+		agentID, err := g.apiKeys.Verify(token)
+		if err != nil {
+			return nil, &authError{http.StatusUnauthorized, "Invalid API key"}
+		}
+		return &Identity{AgentID: agentID, Source: IdentitySourceAPIKey}, nil
+	}
+
+	if providers != nil && providers.Handles(token) {
+		id, err := providers.Verify(token)
+		if err != nil {
+			return nil, &authError{http.StatusUnauthorized, "Invalid ID token: " + err.Error()}
+		}
+		return &Identity{AgentID: id.AgentID, Source: IdentitySourceOIDC, Claims: id.Claims, Groups: id.Groups}, nil
+	}
+
+	if verifier == nil {
+		// Other bearer tokens are not an identity source without JWT auth
+		return nil, nil
+	}
+	claims, err := verifier.Verify(token)
+	if err != nil {
+		return nil, &authError{http.StatusUnauthorized, "Invalid bearer token: " + err.Error()}
+	}
+	claim := authCfg.JWT.AgentClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	subject := claims.String(claim)
+	if subject == "" {
+		return nil, &authError{http.StatusForbidden, "Bearer token does not carry an agent identity"}
+	}
+	return &Identity{AgentID: subject, Source: IdentitySourceJWT, Claims: claims}, nil
+}
+
+// certificateIdentity extracts the agent ID from a verified leaf certificate
+func certificateIdentity(cert *x509.Certificate, cfg config.MTLSConfig) string {
+	var id string
+	switch cfg.Identity {
+	case "uri_san":
+		if len(cert.URIs) > 0 {
+			id = cert.URIs[0].String()
+		}
+	case "dns_san":
+		if len(cert.DNSNames) > 0 {
+			id = cert.DNSNames[0]
+		}
+	case "common_name":
+		id = cert.Subject.CommonName
+	default:
+		for _, uri := range cert.URIs {
+			if uri.Scheme == "spiffe" {
+				id = uri.String()
+				break
+			}
+		}
+	}
+
+	if cfg.TrimPrefix != "" {
+		trimmed, ok := strings.CutPrefix(id, cfg.TrimPrefix)
+		if !ok {
+			return ""
+		}
+		id = trimmed
+	}
+	return id
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+)
+
+func TestHeaderIdentity(t *testing.T) {
+	tests := []struct {
+		name       string
+		configure  func(*config.Config)
+		wantStatus int // 0 when the header is accepted
+	}{
+		{"no authenticator", nil, 0},
+		{"api keys", func(c *config.Config) { c.Auth.APIKeys.Enabled = true }, http.StatusUnauthorized},
+		{"request signatures", func(c *config.Config) {
+			c.Auth.HMAC.Secrets = map[string]string{"other-agent": "env:AEGIS_TEST_SECRET"}
+		}, http.StatusUnauthorized},
+		{"api keys with header identity allowed", func(c *config.Config) {
+			c.Auth.APIKeys.Enabled = true
+			c.Auth.AllowHeaderIdentity = true
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t, "version: \"1\"\n", tt.configure)
+			r := httptest.NewRequest(http.MethodPost, "/tools/payments/create", nil)
+			r.Header.Set("X-Agent-ID", "finance-agent")
+
+			identity, err := g.authenticate(r, nil)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("header rejected: %v", err)
+				}
+				if identity.AgentID != "finance-agent" || identity.Source != IdentitySourceHeader {
+					t.Fatalf("got %+v", identity)
+				}
+				return
+			}
+			if err == nil || err.status != tt.wantStatus {
+				t.Fatalf("got %+v, %v; want status %d", identity, err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// Leaving out X-Agent-Session-ID must not reset session_limit on every call
+func TestSessionLimitWithoutSessionHeader(t *testing.T) {
+	g := newTestGateway(t, `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create]
+        conditions:
+          session_limit: {requests: 1}
+`, nil)
+	evaluate := func(session string) policy.Decision {
+		r := httptest.NewRequest(http.MethodPost, "/tools/payments/create", nil)
+		r.Header.Set("X-Agent-ID", "finance-agent")
+		if session != "" {
+			r.Header.Set(sessionIDHeader, session)
+		}
+		identity, err := g.resolveIdentity(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, span, decision := g.evaluate(context.Background(), time.Now(), identity, "payments", "create", nil, 0)
+		span.End()
+		return decision
+	}
+
+	if d := evaluate(""); !d.Allowed {
+		t.Fatalf("first call denied: %s", d.Code)
+	}
+	if d := evaluate(""); d.Code != policy.CodeSessionLimit {
+		t.Fatalf("second call without a session got %q", d.Code)
+	}
+	if d := evaluate("run-1"); !d.Allowed {
+		t.Fatalf("call in its own session denied: %s", d.Code)
+	}
+}
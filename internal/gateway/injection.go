@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/injection"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// injectionCode is the decision code of calls and responses blocked by the
+// injection filter
+const injectionCode = "PROMPT_INJECTION"
+
+// screenParams runs the tool's injection filter over the params of req
+// and records the findings with the decision. In block mode a finding
+// denies the call without evaluating it, so it doesn't use up rate limits
+// or budgets; otherwise evaluate decides.
+func (g *Gateway) screenParams(req *policy.Request, evaluate func(*policy.Request) policy.Decision) policy.Decision {
+	tool, ok := g.tools.Get(req.Tool)
+	if !ok || tool.Injection == nil {
+		return evaluate(req)
+	}
+	found := tool.Injection.Scan(req.Params)
+	if len(found) > 0 && tool.InjectionFilter.Mode == config.InjectionFilterBlock {
+		return policy.Decision{
+			Code:     injectionCode,
+			Reason:   fmt.Sprintf("Parameter %s looks like a prompt injection (%s)", found[0].Path, found[0].Pattern),
+			Findings: findingStrings(found),
+		}
+	}
+	decision := evaluate(req)
+	decision.Findings = findingStrings(found)
+	return decision
+}
+
+// filterResponses runs the tool's injection filter over text responses
+// when it is set to scan them. Findings are logged as response checks; in
+// block mode the response is replaced with a violation.
+func (g *Gateway) filterResponses(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		tool := c.Upstream
+		if tool == nil || tool.Injection == nil || !tool.InjectionFilter.Responses || c.upgrade || c.async {
+			next(w, c)
+			return
+		}
+
+		buf := newResponseBuffer()
+		buf.header = w.Header().Clone()
+		next(buf, c)
+
+		if isText(buf.header.Get("Content-Type")) {
+			if found := tool.Injection.ScanBody(buf.body.Bytes()); len(found) > 0 {
+				block := tool.InjectionFilter.Mode == config.InjectionFilterBlock
+				outcome := "flag"
+				if block {
+					outcome = "block"
+				}
+				reason := "Tool response looks like a prompt injection (" + found[0].String() + ")"
+				g.telemetry.LogResponseCheck(c.Context, telemetry.ResponseCheck{
+					AgentID:  c.Identity.AgentID,
+					Tool:     c.Tool,
+					Action:   c.Action,
+					Code:     injectionCode,
+					Reason:   reason,
+					Outcome:  outcome,
+					Findings: findingStrings(found),
+				})
+				if block {
+					writeProblem(w, (&responseViolation{code: injectionCode, reason: reason}).body())
+					return
+				}
+			}
+		}
+		buf.flush(w)
+	}
+}
+
+// isText reports whether a response of contentType can be scanned: JSON,
+// text, or unlabeled
+func isText(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(media, "text/") || media == "application/json" || strings.HasSuffix(media, "+json")
+}
+
+func findingStrings(found []injection.Finding) []string {
+	var out []string
+	for _, f := range found {
+		out = append(out, f.String())
+	}
+	return out
+}
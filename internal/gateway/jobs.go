@@ -0,0 +1,302 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/registry"
+)
+
+// callbackHeader names the URL an async job's result is posted to
+const callbackHeader = "X-Aegis-Callback-URL"
+
+// Job states
+const (
+	jobPending   = "pending"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// job is a call forwarded in the background for ?mode=async
+type job struct {
+	ID          string     `json:"id"`
+	Tool        string     `json:"tool"`
+	Action      string     `json:"action"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Result      *jobResult `json:"result,omitempty"`
+	Error       string     `json:"error,omitempty"`
+
+	agentID  string
+	callback string
+}
+
+// jobResult is the tool's response to a finished job. Body is embedded as
+// JSON when the tool answered with JSON, otherwise as a string.
+type jobResult struct {
+	Status      int         `json:"status"`
+	ContentType string      `json:"content_type,omitempty"`
+	Body        interface{} `json:"body"`
+}
+
+// jobStore keeps async jobs until they have been finished for the
+// retention period
+type jobStore struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	nextGC time.Time
+
+	// unfinished counts the pending and running jobs per agent
+	unfinished map[string]int
+}
+
+// add records a new job, failing stuck ones and dropping expired ones. It
+// reports false, leaving the job out, when its agent has
+// settings.MaxPerAgent unfinished jobs or the store holds settings.MaxJobs.
+func (s *jobStore) add(j *job, settings config.JobsConfig) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jobs == nil {
+		s.jobs = make(map[string]*job)
+		s.unfinished = make(map[string]int)
+	}
+	now := time.Now()
+	if now.After(s.nextGC) || len(s.jobs) >= settings.MaxJobs || s.unfinished[j.agentID] >= settings.MaxPerAgent {
+		s.sweepLocked(now, settings)
+		s.nextGC = now.Add(time.Minute)
+	}
+	if len(s.jobs) >= settings.MaxJobs || s.unfinished[j.agentID] >= settings.MaxPerAgent {
+		return false
+	}
+	s.jobs[j.ID] = j
+	s.unfinished[j.agentID]++
+	return true
+}
+
+// sweepLocked fails jobs that didn't finish within the timeout and drops
+// jobs finished longer than the retention period ago
+func (s *jobStore) sweepLocked(now time.Time, settings config.JobsConfig) {
+	for id, j := range s.jobs {
+		if j.CompletedAt == nil && now.Sub(j.CreatedAt) > settings.Timeout {
+			s.completeLocked(j, now)
+			j.Status = jobFailed
+			j.Error = fmt.Sprintf("job did not finish within %s", settings.Timeout)
+		}
+		if j.CompletedAt != nil && now.Sub(*j.CompletedAt) > settings.Retention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// completeLocked marks a job finished at now
+func (s *jobStore) completeLocked(j *job, now time.Time) {
+	completed := now.UTC()
+	j.CompletedAt = &completed
+	if s.unfinished[j.agentID]--; s.unfinished[j.agentID] <= 0 {
+		delete(s.unfinished, j.agentID)
+	}
+}
+
+// get returns a copy of the agent's job with the given ID
+func (s *jobStore) get(id, agentID string) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok || j.agentID != agentID {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// update applies fn to an unfinished job under the store lock. It reports
+// false when the job was already failed for taking too long.
+func (s *jobStore) update(id string, fn func(*job)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok || j.CompletedAt != nil {
+		return false
+	}
+	fn(j)
+	return true
+}
+
+// finish completes an unfinished job with fn under the store lock and
+// returns a copy. It reports false when the job was already failed for
+// taking too long.
+func (s *jobStore) finish(id string, fn func(*job)) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok || j.CompletedAt != nil {
+		return job{}, false
+	}
+	s.completeLocked(j, time.Now())
+	fn(j)
+	return *j, true
+}
+
+// newJobID returns a random job ID
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// startJob answers an allowed call with 202 and a job ID, then forwards it
+// in the background. It reports whether the job was started.
+func (g *Gateway) startJob(ctx context.Context, w http.ResponseWriter, r *http.Request, upstream *registry.Tool, call toolCall) bool {
+	g.mu.RLock()
+	settings := g.config.Jobs.WithDefaults()
+	g.mu.RUnlock()
+
+	callback := r.Header.Get(callbackHeader)
+	if callback != "" && !settings.AllowsCallback(callback) {
+		writeError(w, fmt.Sprintf("Callback URL %s is not in jobs.callback_hosts", callback), http.StatusBadRequest)
+		return false
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to create job: %v", err), http.StatusInternalServerError)
+		return false
+	}
+
+	j := &job{
+		ID:        id,
+		Tool:      upstream.Name,
+		Action:    call.action,
+		Status:    jobPending,
+		CreatedAt: time.Now().UTC(),
+		agentID:   call.agentID,
+		callback:  callback,
+	}
+	if !g.jobs.add(j, settings) {
+		p := newProblem("", http.StatusTooManyRequests, "Too many async jobs are unfinished; wait for some to finish")
+		p.Code = "TOO_MANY_JOBS"
+		p.RetryAfter = 1
+		writeProblem(w, p)
+		return false
+	}
+
+	// ctx carries the decision's trace but not the request's cancellation;
+	// the job is given up once it outlasts the timeout
+	go g.runJob(ctx, upstream, call, id, settings.Timeout)
+
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"job_id":     id,
+		"status":     jobPending,
+		"status_url": "/jobs/" + id,
+	})
+	return true
+}
+
+// runJob forwards a job's call, records the outcome and posts it to the
+// job's callback URL if one was given
+func (g *Gateway) runJob(ctx context.Context, upstream *registry.Tool, call toolCall, id string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if !g.jobs.update(id, func(j *job) { j.Status = jobRunning }) {
+		return
+	}
+
+	buf, err := g.sendCall(ctx, ctx, upstream, call)
+	var violation *responseViolation
+	if err != nil && !errors.As(err, &violation) {
+		g.deadLetter(upstream, call, err)
+	} else if err == nil && buf.status >= http.StatusInternalServerError {
+		g.deadLetter(upstream, call, fmt.Errorf("tool %s returned %d", upstream.Name, buf.status))
+	}
+	finished, ok := g.jobs.finish(id, func(j *job) {
+		if err != nil {
+			j.Status = jobFailed
+			j.Error = err.Error()
+			return
+		}
+
+		j.Status = jobSucceeded
+		if buf.status >= http.StatusBadRequest {
+			j.Status = jobFailed
+		}
+		result := &jobResult{Status: buf.status, ContentType: buf.header.Get("Content-Type")}
+		if raw := buf.body.Bytes(); json.Valid(raw) {
+			result.Body = json.RawMessage(raw)
+		} else {
+			result.Body = string(raw)
+		}
+		j.Result = result
+	})
+
+	if ok && finished.callback != "" {
+		g.postJobResult(finished)
+	}
+}
+
+// postJobResult delivers a finished job to its callback URL
+func (g *Gateway) postJobResult(j job) {
+	payload, _ := json.Marshal(j)
+	req, err := http.NewRequest(http.MethodPost, j.callback, bytes.NewReader(payload))
+	if err != nil {
+		logger.Warn("Invalid callback URL", "job", j.ID, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Aegis-Job-ID", j.ID)
+
+	// Redirects could lead away from the allowed callback hosts
+	client := &http.Client{
+		Timeout:       10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Warn("Failed to post job result", "job", j.ID, "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		logger.Warn("Job callback failed", "job", j.ID, "status", resp.StatusCode)
+	}
+}
+
+// HandleJob serves GET /jobs/:id. Agents can only see their own jobs.
+func (g *Gateway) HandleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	identity, authErr := g.resolveIdentity(r, nil)
+	if authErr != nil {
+		writeAuthError(w, r, authErr)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	j, ok := g.jobs.get(id, identity.AgentID)
+	if !ok {
+		writeError(w, fmt.Sprintf("Unknown job: %s", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+const reportsPolicy = `version: "1"
+agents:
+  - id: report-agent
+    allow:
+      - tool: reports
+        actions: [generate]
+`
+
+// Calls sent after the agent's request is gone must carry the headers the
+// tool would have got, but not the agent's credentials
+func TestBackgroundCallsForwardAgentHeaders(t *testing.T) {
+	var failFirst atomic.Bool
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failFirst.CompareAndSwap(true, false) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		received <- r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	g := newTestGateway(t, reportsPolicy, func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{
+			"reports": {URL: server.URL, Timeout: config.DefaultToolTimeout},
+		}
+		cfg.DeadLetter.Enabled = true
+	})
+	call := func(query, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/tools/reports/generate"+query, strings.NewReader(`{}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Agent-ID", "report-agent")
+		r.Header.Set("Idempotency-Key", key)
+		r.Header.Set("Authorization", "Bearer agent-secret")
+		r.Header.Set("Connection", "X-Hop")
+		r.Header.Set("X-Hop", "1")
+		w := httptest.NewRecorder()
+		g.HandleRequest(w, r)
+		return w
+	}
+	check := func(t *testing.T, header http.Header, key string) {
+		t.Helper()
+		if got := header.Get("Idempotency-Key"); got != key {
+			t.Errorf("Idempotency-Key: got %q", got)
+		}
+		for _, name := range []string{"Authorization", "X-Hop"} {
+			if header.Get(name) != "" {
+				t.Errorf("forwarded %s", name)
+			}
+		}
+	}
+
+	t.Run("async job", func(t *testing.T) {
+		if w := call("?mode=async", "report-1"); w.Code != http.StatusAccepted {
+			t.Fatalf("got %d: %s", w.Code, w.Body)
+		}
+		select {
+		case header := <-received:
+			check(t, header, "report-1")
+		case <-time.After(5 * time.Second):
+			t.Fatal("job was not forwarded")
+		}
+	})
+
+	t.Run("dead-letter replay", func(t *testing.T) {
+		failFirst.Store(true)
+		w := call("", "report-2")
+		id := w.Header().Get("X-Aegis-Dead-Letter-ID")
+		if id == "" {
+			t.Fatalf("call was not captured: %d %s", w.Code, w.Body)
+		}
+		entry, _ := g.deadLetters.Get(id)
+		if entry.Header.Get("Authorization") != "" {
+			t.Fatal("dead letter stored the agent's credentials")
+		}
+
+		r := httptest.NewRequest(http.MethodPost, "/admin/dead_letters/"+id+"/replay", nil)
+		w = httptest.NewRecorder()
+		g.HandleAdminDeadLetters(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("replay got %d: %s", w.Code, w.Body)
+		}
+		check(t, <-received, "report-2")
+	})
+}
+
+func TestJobStoreLimits(t *testing.T) {
+	settings := config.JobsConfig{MaxPerAgent: 2, MaxJobs: 3}.WithDefaults()
+	var s jobStore
+	now := time.Now()
+	newJob := func(id, agent string, created time.Time) *job {
+		return &job{ID: id, Status: jobPending, CreatedAt: created, agentID: agent}
+	}
+
+	if !s.add(newJob("1", "a", now.Add(-2*settings.Timeout)), settings) || !s.add(newJob("2", "a", now), settings) {
+		t.Fatal("jobs under the limits were refused")
+	}
+	// The first job is stuck, so it is failed to make room
+	if !s.add(newJob("3", "a", now), settings) {
+		t.Fatal("stuck job still counted")
+	}
+	if stuck, _ := s.get("1", "a"); stuck.Status != jobFailed || stuck.CompletedAt == nil {
+		t.Fatalf("stuck job is %s", stuck.Status)
+	}
+	if s.update("1", func(j *job) { j.Status = jobRunning }) {
+		t.Fatal("failed job was started")
+	}
+	if s.add(newJob("4", "a", now), settings) {
+		t.Fatal("agent exceeded max_per_agent")
+	}
+	if s.add(newJob("5", "b", now), settings) {
+		t.Fatal("store exceeded max_jobs")
+	}
+
+	if _, ok := s.finish("2", func(j *job) { j.Status = jobSucceeded }); !ok {
+		t.Fatal("job could not be finished")
+	}
+	if n := s.unfinished["a"]; n != 1 {
+		t.Fatalf("agent has %d unfinished jobs, want 1", n)
+	}
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// keyRequest is the body of POST /admin/keys
+type keyRequest struct {
+	AgentID   string `json:"agent_id"`
+	Name      string `json:"name,omitempty"`
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+// HandleAdminKeys serves GET /admin/keys (list), POST /admin/keys (mint a
+// key for an agent) and DELETE /admin/keys/:id (revoke)
+func (g *Gateway) HandleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	if g.apiKeys == nil {
+		writeError(w, "API keys are disabled", http.StatusNotFound)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/keys"), "/")
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": g.apiKeys.List()})
+	case r.Method == http.MethodPost && id == "":
+		g.mintKey(w, r)
+	case r.Method == http.MethodDelete && id != "":
+		key, err := g.apiKeys.Revoke(id)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		logger.Info("Admin revoked API key", "key_id", key.ID, "agent", key.AgentID)
+		writeJSON(w, http.StatusOK, key)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, "Expected GET or POST /admin/keys, or DELETE /admin/keys/:id", http.StatusMethodNotAllowed)
+	}
+}
+
+// mintKey issues a key and returns it once
+func (g *Gateway) mintKey(w http.ResponseWriter, r *http.Request) {
+	var req keyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.AgentID == "" {
+		writeError(w, "agent_id is required", http.StatusBadRequest)
+		return
+	}
+
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil || ttl <= 0 {
+			writeError(w, "Invalid expires_in", http.StatusBadRequest)
+			return
+		}
+	}
+
+	key, plaintext, err := g.apiKeys.Mint(req.AgentID, req.Name, ttl)
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to mint API key: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Admin minted API key", "key_id", key.ID, "agent", key.AgentID)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":         key.ID,
+		"agent_id":   key.AgentID,
+		"name":       key.Name,
+		"key":        plaintext,
+		"created_at": key.CreatedAt,
+		"expires_at": key.ExpiresAt,
+	})
+}
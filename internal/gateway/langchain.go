@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// langchainTool describes an action as a LangChain structured tool. Calls
+// are sent to the gateway at Path, so policy is enforced on every one.
+type langchainTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Tool        string                 `json:"tool"`
+	Action      string                 `json:"action"`
+	Method      string                 `json:"method"`
+	Path        string                 `json:"path"`
+	ArgsSchema  map[string]interface{} `json:"args_schema"`
+}
+
+// HandleLangChainTools serves GET /v1/langchain/tools, the actions the
+// agent may call as LangChain tool specs. With ?format=python it returns a
+// Python module that builds StructuredTools from them instead.
+func (g *Gateway) HandleLangChainTools(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	identity, authErr := g.resolveIdentity(r, nil)
+	if authErr != nil {
+		writeAuthError(w, r, authErr)
+		return
+	}
+
+	tools := []langchainTool{}
+	for _, a := range g.agentActions(identity) {
+		method := http.MethodPost
+		if !a.tool.AllowsMethod(method) {
+			method = a.tool.Methods[0]
+		}
+		tools = append(tools, langchainTool{
+			Name:        a.name(),
+			Description: fmt.Sprintf("Perform %s on the %s tool. Calls go through Aegis Gateway and may be denied by policy.", a.action, a.tool.Name),
+			Tool:        a.tool.Name,
+			Action:      a.action,
+			Method:      method,
+			Path:        "/tools/" + a.tool.Name + "/" + a.action,
+			ArgsSchema:  argsSchema(a.inputSchema),
+		})
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"agent_id": identity.AgentID, "tools": tools})
+	case "python":
+		manifest, _ := json.Marshal(tools)
+		w.Header().Set("Content-Type", "text/x-python; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="aegis_langchain.py"`)
+		langchainShim.Execute(w, map[string]string{
+			"AgentID":   strconv.Quote(identity.AgentID),
+			"Generated": time.Now().UTC().Format(time.RFC3339),
+			"Tools":     strconv.Quote(string(manifest)),
+		})
+	default:
+		writeError(w, fmt.Sprintf("Unknown format %s; expected json or python", format), http.StatusBadRequest)
+	}
+}
+
+// argsSchema returns schema with the "properties" LangChain reads a tool's
+// arguments from, allowing any arguments when the action has no schema
+func argsSchema(schema map[string]interface{}) map[string]interface{} {
+	if _, ok := schema["properties"]; ok {
+		return schema
+	}
+	out := make(map[string]interface{}, len(schema)+1)
+	for key, value := range schema {
+		out[key] = value
+	}
+	out["properties"] = map[string]interface{}{}
+	return out
+}
+
+// langchainShim is the Python module served for ?format=python. The
+// agent ID and tool list are embedded as Go-quoted strings, which are valid
+// Python literals.
+var langchainShim = template.Must(template.New("aegis_langchain.py").Delims("[[", "]]").Parse(strings.TrimLeft(`
+"""LangChain tools for Aegis Gateway.
+
+Generated by GET /v1/langchain/tools?format=python at [[.Generated]] with
+the tools AGENT_ID may call. Regenerate it when the agent's policy or the
+tools' schemas change; every call is still checked by the gateway.
+
+    from aegis_langchain import aegis_tools
+
+    tools = aegis_tools("https://aegis.internal:8080", token=os.environ["AEGIS_API_KEY"])
+    agent = create_react_agent(model, tools)
+
+Denied and failed calls raise ToolException, which is returned to the model
+as the tool's output so it can adjust its plan.
+"""
+
+import functools
+import json
+import os
+import urllib.error
+import urllib.parse
+import urllib.request
+import uuid
+
+from langchain_core.tools import StructuredTool, ToolException
+
+AGENT_ID = [[.AgentID]]
+
+TOOLS = json.loads([[.Tools]])
+
+
+def _call(base_url, headers, timeout, spec, **params):
+    url = base_url.rstrip("/") + spec["path"]
+    data = None
+    if spec["method"] in ("GET", "HEAD", "DELETE"):
+        if params:
+            url += "?" + urllib.parse.urlencode(params)
+    else:
+        data = json.dumps(params).encode()
+    request = urllib.request.Request(url, data=data, method=spec["method"])
+    for name, value in headers.items():
+        request.add_header(name, value)
+    if data is not None:
+        request.add_header("Content-Type", "application/json")
+    request.add_header("Idempotency-Key", uuid.uuid4().hex)
+
+    try:
+        with urllib.request.urlopen(request, timeout=timeout) as response:
+            return response.read().decode("utf-8", "replace")
+    except urllib.error.HTTPError as err:
+        body = err.read().decode("utf-8", "replace")
+        try:
+            problem = json.loads(body)
+        except ValueError:
+            problem = {}
+        if not isinstance(problem, dict):
+            problem = {}
+        reason = problem.get("detail") or body or err.reason
+        code = problem.get("code")
+        raise ToolException(f"{problem.get('title', err.reason)}: {reason}" + (f" ({code})" if code else ""))
+
+
+def aegis_tools(base_url=None, agent_id=AGENT_ID, token=None, session_id=None, timeout=30):
+    """Returns a StructuredTool per action, calling the gateway at base_url
+    (default $AEGIS_URL) as agent_id, with token (default $AEGIS_TOKEN) as
+    the bearer credential if set."""
+    base_url = base_url or os.environ["AEGIS_URL"]
+    token = token or os.environ.get("AEGIS_TOKEN")
+    headers = {"X-Agent-ID": agent_id, "Accept": "application/json"}
+    if token:
+        headers["Authorization"] = "Bearer " + token
+    if session_id:
+        headers["X-Agent-Session-ID"] = session_id
+
+    return [
+        StructuredTool.from_function(
+            func=functools.partial(_call, base_url, headers, timeout, spec),
+            name=spec["name"],
+            description=spec["description"],
+            args_schema=spec["args_schema"],
+            handle_tool_error=True,
+        )
+        for spec in TOOLS
+    ]
+`, "\n")))
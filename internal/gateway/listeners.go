@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+
+	"aegis-gateway/internal/config"
+)
+
+// listen opens a TCP listener, or a Unix socket for "unix:/path" addresses.
+// A socket left behind by a previous run is replaced.
+func listen(address string) (net.Listener, error) {
+	path, ok := config.UnixSocketPath(address)
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Only the gateway user and its group may connect
+	if err := os.Chmod(path, 0o660); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return lis, nil
+}
+
+// serveHTTP serves handler on address. TCP listeners use tlsConfig when it
+// is set; Unix sockets are plaintext and protected by file permissions.
+func serveHTTP(name, address string, handler http.Handler, tlsConfig *tls.Config, mode string) error {
+	lis, err := listen(address)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: handler, ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn)}
+	if _, unix := config.UnixSocketPath(address); tlsConfig != nil && !unix {
+		server.TLSConfig = tlsConfig
+		logger.Info(name+" listening", "address", address, "mode", mode)
+		return server.ServeTLS(lis, "", "")
+	}
+
+	logger.Info(name+" listening", "address", address)
+	return server.Serve(lis)
+}
+
+// serveBackground runs an extra listener, logging when it stops
+func serveBackground(name, address string, handler http.Handler, tlsConfig *tls.Config, mode string) {
+	go func() {
+		err := serveHTTP(name, address, handler, tlsConfig, mode)
+		logger.Error(name+" stopped", "error", err)
+	}()
+}
+
+// registerMetricsRoutes adds the endpoints meant for probes and, with
+// metrics set, /metrics for monitoring
+func (g *Gateway) registerMetricsRoutes(mux *http.ServeMux, metrics bool) {
+	mux.HandleFunc("/healthz", g.HandleHealthz)
+	mux.HandleFunc("/readyz", g.HandleReadyz)
+	if metrics {
+		mux.Handle("/metrics", g.telemetry.MetricsHandler())
+	}
+}
@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRoutes(t *testing.T) {
+	g := newTestGateway(t, "version: \"1\"\n", nil)
+	tests := []struct {
+		name        string
+		metrics     bool
+		wantMetrics int
+	}{
+		{"agent listener with a metrics listener", false, http.StatusNotFound},
+		{"metrics listener", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			g.registerMetricsRoutes(mux, tt.metrics)
+			for path, want := range map[string]int{"/healthz": http.StatusOK, "/metrics": tt.wantMetrics} {
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				if w.Code != want {
+					t.Errorf("GET %s: got %d, want %d", path, w.Code, want)
+				}
+			}
+		})
+	}
+}
+
+// Agents that only name themselves and tools that don't exist must not
+// add metric series
+func TestMetricLabelsBounded(t *testing.T) {
+	g := newTestGateway(t, "version: \"1\"\n", nil)
+	calls := []struct {
+		identity *Identity
+		tool     string
+	}{
+		{&Identity{AgentID: "made-up-agent", Source: IdentitySourceHeader}, "payments"},
+		{&Identity{AgentID: "verified-agent", Source: IdentitySourceAPIKey}, "made-up-tool"},
+		{&Identity{AgentID: "verified-agent", Source: IdentitySourceAPIKey}, "payments"},
+	}
+	for _, c := range calls {
+		_, span, _ := g.evaluate(context.Background(), time.Now(), c.identity, c.tool, "query", nil, 0)
+		span.End()
+	}
+
+	w := httptest.NewRecorder()
+	g.telemetry.MetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	for _, unwanted := range []string{"made-up-agent", "made-up-tool"} {
+		if strings.Contains(string(body), unwanted) {
+			t.Errorf("metrics label %s", unwanted)
+		}
+	}
+	for _, want := range []string{
+		`aegis_decisions_total{agent="other",result="deny",tool="payments"} 1`,
+		`aegis_decisions_total{agent="verified-agent",result="deny",tool="other"} 1`,
+		`aegis_decisions_total{agent="verified-agent",result="deny",tool="payments"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
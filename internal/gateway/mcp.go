@@ -0,0 +1,298 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/registry"
+)
+
+// MCP protocol versions the server front-end speaks, newest first
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// toolActionSeparator joins a tool and action into the single name MCP and
+// function-calling clients use, e.g. "payments__create"
+const toolActionSeparator = "__"
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+// rpcRequest is a JSON-RPC 2.0 request or notification. Notifications have
+// no ID.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is a JSON-RPC 2.0 error object
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// mcpTool is an entry in a tools/list result
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+// mcpContent is a content block of a tools/call result
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// mcpCallResult is the result of tools/call. Denials and tool failures are
+// reported with IsError so the model sees them, not as JSON-RPC errors.
+type mcpCallResult struct {
+	Content           []mcpContent           `json:"content"`
+	StructuredContent map[string]interface{} `json:"structuredContent,omitempty"`
+	IsError           bool                   `json:"isError,omitempty"`
+
+	// Meta carries the gateway's decision ID as "aegis/decision_id"
+	Meta map[string]interface{} `json:"_meta,omitempty"`
+}
+
+// HandleMCP serves POST /mcp, exposing the gateway as an MCP server over the
+// streamable HTTP transport. Each tool/action the agent's policy allows is
+// listed as an MCP tool, and tools/call goes through the same evaluation and
+// forwarding as /tools/:tool/:action. Responses are always plain JSON.
+func (g *Gateway) HandleMCP(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, ok := g.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	identity, authErr := g.resolveIdentity(r, body)
+	if authErr != nil {
+		writeAuthError(w, r, authErr)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeRPCError(w, nil, rpcParseError, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		writeRPCError(w, req.ID, rpcInvalidRequest, "Expected a JSON-RPC 2.0 request")
+		return
+	}
+
+	// Notifications (initialized, cancelled) need no answer
+	if len(req.ID) == 0 || bytes.Equal(req.ID, []byte("null")) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	var result interface{}
+	var rpcErr *rpcError
+	switch req.Method {
+	case "initialize":
+		result = mcpInitialize(req.Params)
+	case "ping":
+		result = map[string]interface{}{}
+	case "tools/list":
+		result = map[string]interface{}{"tools": g.mcpTools(identity)}
+	case "tools/call":
+		result, rpcErr = g.mcpCall(r, startTime, identity, req.Params)
+	default:
+		rpcErr = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("Method not found: %s", req.Method)}
+	}
+
+	if rpcErr != nil {
+		writeRPCError(w, req.ID, rpcErr.Code, rpcErr.Message)
+		return
+	}
+	writeJSON(w, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result})
+}
+
+// writeRPCError writes a JSON-RPC error response
+func writeRPCError(w http.ResponseWriter, id json.RawMessage, code int, message string) {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	writeJSON(w, http.StatusOK, rpcResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &rpcError{Code: code, Message: message},
+	})
+}
+
+// mcpInitialize answers initialize with the client's protocol version if it
+// is supported, otherwise the newest one
+func mcpInitialize(params json.RawMessage) map[string]interface{} {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(params, &p)
+
+	version := mcpProtocolVersions[0]
+	for _, v := range mcpProtocolVersions {
+		if v == p.ProtocolVersion {
+			version = v
+		}
+	}
+
+	return map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+		"serverInfo":      map[string]interface{}{"name": "aegis-gateway", "version": "1.0"},
+	}
+}
+
+// mcpTools lists the actions the agent may call as MCP tools. Third-party
+// MCP servers are reached through their own /mcp/<tool> endpoint.
+func (g *Gateway) mcpTools(identity *Identity) []mcpTool {
+	tools := []mcpTool{}
+	for _, a := range g.agentActions(identity) {
+		tools = append(tools, mcpTool{
+			Name:        a.name(),
+			Description: fmt.Sprintf("Perform %s on the %s tool through Aegis Gateway", a.action, a.tool.Name),
+			InputSchema: a.inputSchema,
+		})
+	}
+	return tools
+}
+
+// agentAction is an action an agent may call, for the tool listings of
+// MCP and agent frameworks
+type agentAction struct {
+	tool   *registry.Tool
+	action string
+
+	// inputSchema is the action's request schema, or any object
+	inputSchema map[string]interface{}
+}
+
+// name joins the tool and action into the single name MCP and
+// function-calling clients use
+func (a agentAction) name() string {
+	return a.tool.Name + toolActionSeparator + a.action
+}
+
+// agentActions lists the registered tool actions the agent's policy
+// allows, sorted, with the request schema of actions that have one in the
+// agent's schema version. MCP server, GraphQL, SQL, exec, files, email and
+// fetch tools are left out.
+func (g *Gateway) agentActions(identity *Identity) []agentAction {
+	allowed := g.policyEngine.AllowedActions(identity.AgentID, identity.Groups)
+
+	names := make([]string, 0, len(allowed))
+	for name := range allowed {
+		if t, ok := g.tools.Get(name); ok && t.Protocol != registry.ProtocolMCP && t.Protocol != registry.ProtocolGraphQL && t.Protocol != registry.ProtocolSQL && t.Protocol != registry.ProtocolExec &&
+			t.Protocol != registry.ProtocolFiles && t.Protocol != registry.ProtocolEmail && t.Protocol != registry.ProtocolFetch {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var actions []agentAction
+	for _, name := range names {
+		t, _ := g.tools.Get(name)
+		version, _ := schemaVersion(t, identity.SchemaVersion)
+		for _, action := range allowed[name] {
+			inputSchema := map[string]interface{}{"type": "object"}
+			if version != nil {
+				if a := version.Action(action); a != nil && a.Request != nil {
+					if doc, ok := a.Request.Document().(map[string]interface{}); ok {
+						inputSchema = doc
+					}
+				}
+			}
+			actions = append(actions, agentAction{tool: t, action: action, inputSchema: inputSchema})
+		}
+	}
+	return actions
+}
+
+// mcpCall evaluates and forwards a tools/call request
+func (g *Gateway) mcpCall(r *http.Request, start time.Time, identity *Identity, raw json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Invalid params: %v", err)}
+	}
+	tool, action, ok := strings.Cut(p.Name, toolActionSeparator)
+	if !ok || tool == "" || action == "" {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Unknown tool: %s", p.Name)}
+	}
+	if p.Arguments == nil {
+		p.Arguments = make(map[string]interface{})
+	}
+	body, _ := json.Marshal(p.Arguments)
+
+	spanCtx, span, decision := g.evaluate(traceContext(r.Header), start, identity, tool, action, p.Arguments, len(body))
+	defer span.End()
+	meta := map[string]interface{}{"aegis/decision_id": decision.ID}
+	if !decision.Allowed {
+		result := mcpErrorResult(violationBody(decision))
+		result.Meta = meta
+		return result, nil
+	}
+
+	upstream, exists := g.tools.Get(tool)
+	if !exists {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("Unknown tool: %s", p.Name)}
+	}
+
+	idempotent := upstream.Retry.Idempotent(http.MethodPost, action, r.Header.Get("Idempotency-Key") != "")
+	status, out, err := g.callTool(r.Context(), spanCtx, upstream, action, body, idempotent, identity, decision.Response)
+	if err != nil {
+		result := mcpErrorResult(callErrorBody(err))
+		result.Meta = meta
+		return result, nil
+	}
+
+	result := mcpCallResult{
+		Content: []mcpContent{{Type: "text", Text: string(out)}},
+		IsError: status >= http.StatusBadRequest,
+		Meta:    meta,
+	}
+	var structured map[string]interface{}
+	if json.Unmarshal(out, &structured) == nil {
+		result.StructuredContent = structured
+	}
+	return result, nil
+}
+
+// mcpErrorResult reports a failed call to the model as a problem document
+// in JSON text
+func mcpErrorResult(p *problem) mcpCallResult {
+	text, _ := json.Marshal(p)
+	var structured map[string]interface{}
+	json.Unmarshal(text, &structured)
+	return mcpCallResult{Content: []mcpContent{{Type: "text", Text: string(text)}}, StructuredContent: structured, IsError: true}
+}
@@ -0,0 +1,438 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// Headers relayed between the agent and a third-party MCP server
+var (
+	mcpRequestHeaders  = []string{"Accept", "Content-Type", "Mcp-Session-Id", "Mcp-Protocol-Version", "Last-Event-ID", requestIDHeader}
+	mcpResponseHeaders = []string{"Content-Type", "Mcp-Session-Id"}
+)
+
+// HandleMCPProxy serves /mcp/<tool>, sitting between an agent and the
+// third-party MCP server registered as <tool> with protocol: mcp. Traffic is
+// relayed as-is except that tools/list only advertises the tools the agent's
+// policy allows, and every tools/call is evaluated (the MCP tool name is the
+// action) before it reaches the server. A POST body is forwarded as the
+// gateway decoded it, so the server can't read a different method or tool
+// from duplicate or case-variant keys. Results of tools/call under response
+// rules are inspected before the agent gets them.
+func (g *Gateway) HandleMCPProxy(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	name := strings.TrimPrefix(r.URL.Path, "/mcp/")
+	upstream, ok := g.tools.Get(name)
+	if !ok || upstream.Protocol != registry.ProtocolMCP {
+		writeError(w, fmt.Sprintf("Unknown MCP server: %s", name), http.StatusNotFound)
+		return
+	}
+
+	body, ok := g.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	identity, authErr := g.resolveIdentity(r, body)
+	if authErr != nil {
+		writeAuthError(w, r, authErr)
+		return
+	}
+	upstream = upstream.Route(identity.AgentID)
+
+	// A resumed stream replays earlier tools/call results from the server,
+	// which response rules can't inspect there
+	if r.Header.Get("Last-Event-ID") != "" && g.hasResponseRules(identity, upstream.Name) {
+		writeError(w, fmt.Sprintf("Streams of MCP server %s can't be resumed under response rules", upstream.Name), http.StatusBadRequest)
+		return
+	}
+
+	// GET opens the server's event stream and DELETE ends the session
+	if r.Method != http.MethodPost {
+		g.relayMCP(w, r, upstream, body)
+		return
+	}
+
+	// A batch could smuggle tool calls past evaluation
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		writeRPCError(w, nil, rpcInvalidRequest, "Batch requests are not supported")
+		return
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeRPCError(w, nil, rpcParseError, fmt.Sprintf("Invalid JSON: %v", err))
+		return
+	}
+	var err error
+	if body, err = json.Marshal(req); err != nil {
+		writeRPCError(w, req.ID, rpcInternalError, fmt.Sprintf("Failed to encode request: %v", err))
+		return
+	}
+
+	switch req.Method {
+	case "tools/list":
+		g.proxyMCPToolList(w, r, upstream, identity, req, body)
+	case "tools/call":
+		g.proxyMCPToolCall(w, r, upstream, identity, req, body, startTime)
+	default:
+		g.relayMCP(w, r, upstream, body)
+	}
+}
+
+// hasResponseRules reports whether a rule letting identity call tool
+// constrains the tool's responses
+func (g *Gateway) hasResponseRules(identity *Identity, tool string) bool {
+	for _, c := range g.policyEngine.Capabilities(identity.AgentID, identity.Groups) {
+		if c.Tool == tool && c.Response != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyMCPToolList forwards tools/list and drops the tools the agent may not
+// call
+func (g *Gateway) proxyMCPToolList(w http.ResponseWriter, r *http.Request, upstream *registry.Tool, identity *Identity, req rpcRequest, body []byte) {
+	resp, err := g.sendMCP(r, upstream, body)
+	if err != nil {
+		writeRPCError(w, req.ID, rpcInternalError, fmt.Sprintf("Failed to reach MCP server %s: %v", upstream.Name, err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		relayMCPResponse(w, resp)
+		return
+	}
+
+	msg, err := readMCPResponse(resp, req.ID)
+	if err != nil {
+		writeRPCError(w, req.ID, rpcInternalError, fmt.Sprintf("Invalid response from MCP server %s: %v", upstream.Name, err))
+		return
+	}
+
+	if result, ok := msg["result"].(map[string]interface{}); ok {
+		allowed := make(map[string]bool)
+		for _, action := range g.policyEngine.AllowedActions(identity.AgentID, identity.Groups)[upstream.Name] {
+			allowed[action] = true
+		}
+
+		tools, _ := result["tools"].([]interface{})
+		visible := make([]interface{}, 0, len(tools))
+		for _, t := range tools {
+			if entry, ok := t.(map[string]interface{}); ok {
+				if name, _ := entry["name"].(string); allowed[name] {
+					visible = append(visible, entry)
+				}
+			}
+		}
+		result["tools"] = visible
+	}
+
+	if session := resp.Header.Get("Mcp-Session-Id"); session != "" {
+		w.Header().Set("Mcp-Session-Id", session)
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
+
+// proxyMCPToolCall evaluates tools/call and forwards it if allowed
+func (g *Gateway) proxyMCPToolCall(w http.ResponseWriter, r *http.Request, upstream *registry.Tool, identity *Identity, req rpcRequest, body []byte, start time.Time) {
+	var p struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+		Meta      json.RawMessage        `json:"_meta,omitempty"`
+	}
+	if err := json.Unmarshal(req.Params, &p); err != nil || p.Name == "" {
+		writeRPCError(w, req.ID, rpcInvalidParams, "tools/call requires a tool name")
+		return
+	}
+	if p.Arguments == nil {
+		p.Arguments = make(map[string]interface{})
+	}
+
+	// The server gets the name and arguments that are evaluated
+	params, err := json.Marshal(p)
+	if err == nil {
+		req.Params = params
+		body, err = json.Marshal(req)
+	}
+	if err != nil {
+		writeRPCError(w, req.ID, rpcInternalError, fmt.Sprintf("Failed to encode request: %v", err))
+		return
+	}
+
+	ctx, span, decision := g.evaluate(traceContext(r.Header), start, identity, upstream.Name, p.Name, p.Arguments, len(body))
+	defer span.End()
+	w.Header().Set(decisionIDHeader, decision.ID)
+	if !decision.Allowed {
+		writeJSON(w, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: mcpErrorResult(violationBody(decision))})
+		return
+	}
+
+	release, err := upstream.Limiter.Acquire(r.Context())
+	if err != nil {
+		g.writeToolBusy(w, upstream.Name, err)
+		return
+	}
+	defer release()
+
+	done, retryAfter, ok := upstream.Allow()
+	if !ok {
+		g.writeCircuitOpen(w, upstream.Name, retryAfter)
+		return
+	}
+
+	forwardStart := time.Now()
+	var status int
+	if decision.Response != nil {
+		status, err = g.inspectMCPToolCall(ctx, w, r, upstream, identity, req, p.Name, decision, body)
+	} else {
+		status, err = g.relayMCP(w, r, upstream, body)
+	}
+	done(err == nil && status < http.StatusInternalServerError)
+	if err != nil {
+		status = 0
+	}
+	g.telemetry.LogForwardedCall(ctx, upstream.Name, p.Name, upstream.Target, status, time.Since(forwardStart)).End()
+}
+
+// inspectMCPToolCall forwards tools/call and applies the rule's response
+// constraints to the result, as the HTTP front-end does to a tool's body:
+// each text content item and the structured content are inspected on their
+// own. The reply is sent as one JSON message, without the notifications an
+// event stream carried before it.
+func (g *Gateway) inspectMCPToolCall(ctx context.Context, w http.ResponseWriter, r *http.Request, upstream *registry.Tool, identity *Identity, req rpcRequest, action string, decision policy.Decision, body []byte) (int, error) {
+	resp, err := g.sendMCP(r, upstream, body)
+	if err != nil {
+		writeUpstreamError(w, http.StatusBadGateway, fmt.Sprintf("Failed to reach MCP server %s: %v", upstream.Name, err))
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, relayMCPResponse(w, resp)
+	}
+	msg, err := readMCPResponse(resp, req.ID)
+	if err != nil {
+		writeRPCError(w, req.ID, rpcInternalError, fmt.Sprintf("Invalid response from MCP server %s: %v", upstream.Name, err))
+		return resp.StatusCode, err
+	}
+
+	inspect := func(data []byte) ([]byte, *responseViolation) {
+		return g.inspectResponse(ctx, identity.AgentID, upstream.Name, action, decision.Response, resp.Header, data)
+	}
+	modified := false
+	if result, ok := msg["result"].(map[string]interface{}); ok {
+		violation := inspectMCPResult(result, inspect, &modified)
+		if violation != nil {
+			out := mcpErrorResult(violation.body())
+			out.Meta = map[string]interface{}{"aegis/decision_id": decision.ID}
+			msg["result"] = out
+		}
+	}
+
+	if session := resp.Header.Get("Mcp-Session-Id"); session != "" {
+		w.Header().Set("Mcp-Session-Id", session)
+	}
+	if modified {
+		w.Header().Set("X-Aegis-Response-Modified", "true")
+	}
+	writeJSON(w, http.StatusOK, msg)
+	return resp.StatusCode, nil
+}
+
+// inspectMCPResult runs inspect over the text content and structured
+// content of a tools/call result, replacing them with what may be
+// delivered. Structured content that is no longer a JSON object, e.g. once
+// truncated, is dropped; the text content still carries it.
+func inspectMCPResult(result map[string]interface{}, inspect func([]byte) ([]byte, *responseViolation), modified *bool) *responseViolation {
+	content, _ := result["content"].([]interface{})
+	for _, item := range content {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		text, ok := entry["text"].(string)
+		if !ok {
+			continue
+		}
+		out, violation := inspect([]byte(text))
+		if violation != nil {
+			return violation
+		}
+		if string(out) != text {
+			entry["text"] = string(out)
+			*modified = true
+		}
+	}
+
+	structured, ok := result["structuredContent"]
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(structured)
+	if err != nil {
+		return &responseViolation{code: policy.CodeInvalidCondition, reason: fmt.Sprintf("Structured content can't be inspected: %v", err)}
+	}
+	out, violation := inspect(data)
+	if violation != nil {
+		return violation
+	}
+	if !bytes.Equal(out, data) {
+		*modified = true
+		var replaced map[string]interface{}
+		if json.Unmarshal(out, &replaced) != nil {
+			delete(result, "structuredContent")
+			return nil
+		}
+		result["structuredContent"] = replaced
+	}
+	return nil
+}
+
+// relayMCP forwards a request to the MCP server and streams the response
+// back, returning the upstream status
+func (g *Gateway) relayMCP(w http.ResponseWriter, r *http.Request, upstream *registry.Tool, body []byte) (int, error) {
+	resp, err := g.sendMCP(r, upstream, body)
+	if err != nil {
+		writeUpstreamError(w, http.StatusBadGateway, fmt.Sprintf("Failed to reach MCP server %s: %v", upstream.Name, err))
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode, relayMCPResponse(w, resp)
+}
+
+// relayMCPResponse copies an MCP server response to the agent
+func relayMCPResponse(w http.ResponseWriter, resp *http.Response) error {
+	for _, h := range mcpResponseHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	return copyResponse(w, resp.Body, isStreaming(resp))
+}
+
+// sendMCP sends the agent's request to the MCP server with the tool's own
+// credentials. The tool timeout bounds the wait for response headers only,
+// since event streams stay open.
+func (g *Gateway) sendMCP(r *http.Request, upstream *registry.Tool, body []byte) (*http.Response, error) {
+	instances := upstream.Instances()
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no instances available for tool %s", upstream.Name)
+	}
+	credHeader, credValue, err := g.toolCredentials(upstream)
+	if err != nil {
+		return nil, err
+	}
+	client, err := g.upstreamClient(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	req, err := http.NewRequestWithContext(ctx, r.Method, instances[0], bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for _, h := range mcpRequestHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	if credHeader != "" {
+		req.Header.Set(credHeader, credValue)
+	}
+	telemetry.Inject(traceContext(r.Header), propagation.HeaderCarrier(req.Header))
+
+	deadline := time.AfterFunc(upstream.Timeout, cancel)
+	release := upstream.Acquire(instances[0])
+	resp, err := client.Do(req)
+	deadline.Stop()
+	release(err == nil && resp.StatusCode < http.StatusInternalServerError)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request context once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// readMCPResponse returns the JSON-RPC response with the given ID from a
+// plain JSON or event-stream reply
+func readMCPResponse(resp *http.Response, id json.RawMessage) (map[string]interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		var msg map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+
+		var head struct {
+			ID json.RawMessage `json:"id"`
+		}
+		var msg map[string]interface{}
+		event := []byte(data.String())
+		data.Reset()
+		if json.Unmarshal(event, &head) == nil && sameRPCID(head.ID, id) && json.Unmarshal(event, &msg) == nil {
+			return msg, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("stream ended without a response")
+}
+
+// sameRPCID compares JSON-RPC IDs independent of formatting
+func sameRPCID(a, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return false
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
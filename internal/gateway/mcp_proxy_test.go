@@ -0,0 +1,203 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+)
+
+// The MCP server must get the method and tool name that were evaluated,
+// whichever of duplicate or case-variant keys it would read
+func TestMCPProxyForwardsEvaluatedRequest(t *testing.T) {
+	var forwarded []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{"tools":[]}}`)
+	}))
+	defer server.Close()
+
+	policyYAML := `version: "1"
+agents:
+  - id: dev-agent
+    allow:
+      - tool: repo
+        actions: [read_file]
+`
+	g := newTestGateway(t, policyYAML, func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{
+			"repo": {Protocol: registry.ProtocolMCP, URL: server.URL, Timeout: config.DefaultToolTimeout},
+		}
+	})
+
+	tests := []struct {
+		name, body string
+		wantMethod string
+		wantParams string
+	}{
+		{
+			name:       "case-variant tool name",
+			body:       `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"delete_repo","Name":"read_file","arguments":{}}}`,
+			wantMethod: "tools/call",
+			wantParams: `{"name":"read_file","arguments":{}}`,
+		},
+		{
+			name:       "duplicate tool name",
+			body:       `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"delete_repo","name":"read_file"}}`,
+			wantMethod: "tools/call",
+			wantParams: `{"name":"read_file","arguments":{}}`,
+		},
+		{
+			name:       "case-variant method",
+			body:       `{"jsonrpc":"2.0","id":1,"method":"tools/call","Method":"tools/list","params":{"name":"delete_repo"}}`,
+			wantMethod: "tools/list",
+			wantParams: `{"name":"delete_repo"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = nil
+			r := httptest.NewRequest(http.MethodPost, "/mcp/repo", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-Agent-ID", "dev-agent")
+			w := httptest.NewRecorder()
+			g.HandleMCPProxy(w, r)
+
+			if strings.Contains(string(forwarded), `"Name"`) || strings.Contains(string(forwarded), `"Method"`) {
+				t.Fatalf("forwarded case-variant keys: %s", forwarded)
+			}
+			var got rpcRequest
+			if err := json.Unmarshal(forwarded, &got); err != nil {
+				t.Fatalf("forwarded %q: %v", forwarded, err)
+			}
+			if got.Method != tt.wantMethod || string(got.Params) != tt.wantParams {
+				t.Fatalf("forwarded %s", forwarded)
+			}
+		})
+	}
+}
+
+// Results relayed from an MCP server must get the response rules the
+// gateway's own front-ends apply
+func TestMCPProxyResponseRules(t *testing.T) {
+	const result = `{"content":[{"type":"text","text":"{\"path\":\"a\",\"secret\":\"x\"}"}],"structuredContent":{"path":"a","secret":"x"}}`
+	tests := []struct {
+		name           string
+		contentType    string
+		classification string
+		reply          string
+		wantCode       string
+	}{
+		{"json", "application/json", "", `{"jsonrpc":"2.0","id":1,"result":` + result + `}`, ""},
+		{"event stream", "text/event-stream", "", "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\ndata: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":" + result + "}\n\n", ""},
+		{"classified", "application/json", "restricted", `{"jsonrpc":"2.0","id":1,"result":` + result + `}`, policy.CodeClassificationNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.classification != "" {
+					w.Header().Set(classificationHeader, tt.classification)
+				}
+				io.WriteString(w, tt.reply)
+			}))
+			defer server.Close()
+
+			policyYAML := `version: "1"
+agents:
+  - id: dev-agent
+    allow:
+      - tool: repo
+        actions: [read_file]
+        response:
+          forbid_fields: [secret]
+          on_forbidden_field: strip
+          forbid_classifications: [restricted]
+`
+			g := newTestGateway(t, policyYAML, func(cfg *config.Config) {
+				cfg.Tools = map[string]config.ToolConfig{
+					"repo": {Protocol: registry.ProtocolMCP, URL: server.URL, Timeout: config.DefaultToolTimeout},
+				}
+			})
+			body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"read_file","arguments":{}}}`
+			r := httptest.NewRequest(http.MethodPost, "/mcp/repo", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-Agent-ID", "dev-agent")
+			w := httptest.NewRecorder()
+			g.HandleMCPProxy(w, r)
+
+			var msg struct {
+				Result mcpCallResult `json:"result"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+				t.Fatalf("got %d %q: %v", w.Code, w.Body, err)
+			}
+			if tt.wantCode != "" {
+				if !msg.Result.IsError || msg.Result.StructuredContent["code"] != tt.wantCode {
+					t.Fatalf("got %s", w.Body)
+				}
+				return
+			}
+			if strings.Contains(w.Body.String(), "secret") || msg.Result.StructuredContent["path"] != "a" {
+				t.Fatalf("forbidden field was delivered: %s", w.Body)
+			}
+			if w.Header().Get("X-Aegis-Response-Modified") != "true" {
+				t.Fatal("response was not flagged as modified")
+			}
+		})
+	}
+}
+
+// Results relayed from an MCP server are redacted like any tool response,
+// and a resumed stream can't replay them unredacted
+func TestMCPProxyRedaction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{"content":[{"type":"text","text":"{\"owner\":\"jane@example.com\"}"}],"structuredContent":{"owner":"jane@example.com"}}}`)
+	}))
+	defer server.Close()
+
+	policyYAML := `version: "1"
+agents:
+  - id: dev-agent
+    allow:
+      - tool: repo
+        actions: [read_file]
+        response:
+          redact: [email]
+`
+	g := newTestGateway(t, policyYAML, func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{
+			"repo": {Protocol: registry.ProtocolMCP, URL: server.URL, Timeout: config.DefaultToolTimeout},
+		}
+	})
+	call := func(method, lastEventID string) *httptest.ResponseRecorder {
+		body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"read_file","arguments":{}}}`
+		r := httptest.NewRequest(method, "/mcp/repo", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Agent-ID", "dev-agent")
+		if lastEventID != "" {
+			r.Header.Set("Last-Event-ID", lastEventID)
+		}
+		w := httptest.NewRecorder()
+		g.HandleMCPProxy(w, r)
+		return w
+	}
+
+	w := call(http.MethodPost, "")
+	if strings.Contains(w.Body.String(), "jane@example.com") || strings.Count(w.Body.String(), "[REDACTED:email]") != 2 {
+		t.Fatalf("got %s", w.Body)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if w := call(method, "1"); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s resumed the stream: %d %s", method, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"aegis-gateway/internal/config"
+)
+
+// uploadError rejects a multipart request with the given status
+type uploadError struct {
+	status  int
+	message string
+}
+
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+// uploadPart is a form field or a file spooled to disk
+type uploadPart struct {
+	name  string
+	value string
+	file  *uploadFile
+}
+
+// uploadFile is the metadata of a file part and the temporary file holding it
+type uploadFile struct {
+	filename    string
+	contentType string
+	size        int64
+	path        string
+
+	// threat is the malware the scanner found in the file, if any
+	threat string
+}
+
+// upload is a parsed multipart/form-data request. Fields are kept in memory
+// and files are spooled to temporary files, so policy sees every part
+// before anything is forwarded and no file is held in memory.
+type upload struct {
+	parts []uploadPart
+	size  int64
+}
+
+// isMultipart reports whether the request carries a multipart/form-data body
+func isMultipart(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// readUpload parses a multipart/form-data body within the upload limits.
+// The caller must clean up the returned upload.
+func readUpload(w http.ResponseWriter, r *http.Request, limits config.UploadsConfig) (*upload, *uploadError) {
+	limits = limits.WithDefaults()
+
+	// Bound the whole body, leaving room for part headers and boundaries
+	r.Body = http.MaxBytesReader(w, r.Body, limits.MaxTotalBytes+limits.MaxFieldBytes+1<<20)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("Invalid multipart body: %v", err)}
+	}
+
+	u := &upload{}
+	fail := func(status int, format string, args ...interface{}) (*upload, *uploadError) {
+		u.cleanup()
+		return nil, &uploadError{status, fmt.Sprintf(format, args...)}
+	}
+
+	var fieldBytes int64
+	files := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return fail(http.StatusRequestEntityTooLarge, "Upload exceeds %d bytes", limits.MaxTotalBytes)
+			}
+			return fail(http.StatusBadRequest, "Invalid multipart body: %v", err)
+		}
+
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, limits.MaxFieldBytes-fieldBytes+1))
+			part.Close()
+			if err != nil {
+				return fail(http.StatusBadRequest, "Failed to read form field %s: %v", name, err)
+			}
+			if fieldBytes += int64(len(value)); fieldBytes > limits.MaxFieldBytes {
+				return fail(http.StatusRequestEntityTooLarge, "Form fields exceed %d bytes", limits.MaxFieldBytes)
+			}
+			u.parts = append(u.parts, uploadPart{name: name, value: string(value)})
+			continue
+		}
+
+		if files++; files > limits.MaxFiles {
+			part.Close()
+			return fail(http.StatusRequestEntityTooLarge, "Upload has more than %d files", limits.MaxFiles)
+		}
+
+		tmp, err := os.CreateTemp(limits.TempDir, "aegis-upload-*")
+		if err != nil {
+			part.Close()
+			return fail(http.StatusInternalServerError, "Failed to store upload: %v", err)
+		}
+		file := &uploadFile{
+			filename:    part.FileName(),
+			contentType: part.Header.Get("Content-Type"),
+			path:        tmp.Name(),
+		}
+		if file.contentType == "" {
+			file.contentType = "application/octet-stream"
+		}
+		// Registered before writing so cleanup removes partial files too
+		u.parts = append(u.parts, uploadPart{name: name, file: file})
+
+		limit := min(limits.MaxFileBytes, limits.MaxTotalBytes-u.size)
+		n, err := io.Copy(tmp, io.LimitReader(part, limit+1))
+		part.Close()
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return fail(http.StatusRequestEntityTooLarge, "Upload exceeds %d bytes", limits.MaxTotalBytes)
+			}
+			return fail(http.StatusBadRequest, "Failed to read file %s: %v", file.filename, err)
+		}
+		if n > limits.MaxFileBytes {
+			return fail(http.StatusRequestEntityTooLarge, "File %s exceeds %d bytes", file.filename, limits.MaxFileBytes)
+		}
+		if u.size+n > limits.MaxTotalBytes {
+			return fail(http.StatusRequestEntityTooLarge, "Upload exceeds %d bytes", limits.MaxTotalBytes)
+		}
+		file.size = n
+		u.size += n
+	}
+
+	u.size += fieldBytes
+	return u, nil
+}
+
+// params exposes the upload to policy conditions. Fields are strings and
+// files are {filename, size, content_type} objects, so conditions can check
+// e.g. "document.content_type". Repeated names become lists.
+func (u *upload) params() map[string]interface{} {
+	params := make(map[string]interface{})
+	for _, p := range u.parts {
+		var value interface{} = p.value
+		if p.file != nil {
+			value = map[string]interface{}{
+				"filename":     p.file.filename,
+				"size":         p.file.size,
+				"content_type": p.file.contentType,
+			}
+		}
+
+		switch existing := params[p.name].(type) {
+		case nil:
+			params[p.name] = value
+		case []interface{}:
+			params[p.name] = append(existing, value)
+		default:
+			params[p.name] = []interface{}{existing, value}
+		}
+	}
+	return params
+}
+
+// open re-encodes the upload for the tool, streaming files from disk
+func (u *upload) open() (io.Reader, string) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(u.write(mw))
+	}()
+	return pr, mw.FormDataContentType()
+}
+
+// write encodes every part in its original order
+func (u *upload) write(mw *multipart.Writer) error {
+	for _, p := range u.parts {
+		if p.file == nil {
+			if err := mw.WriteField(p.name, p.value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(p.name), quoteEscaper.Replace(p.file.filename)))
+		header.Set("Content-Type", p.file.contentType)
+		if p.file.threat != "" {
+			header.Set(malwareHeader, p.file.threat)
+		}
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p.file.path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// cleanup removes the spooled files
+func (u *upload) cleanup() {
+	for _, p := range u.parts {
+		if p.file != nil {
+			os.Remove(p.file.path)
+		}
+	}
+}
+
+// quoteEscaper escapes names in a Content-Disposition header
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
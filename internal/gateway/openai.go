@@ -0,0 +1,176 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/policy"
+)
+
+// openAIToolCall is a tool call proposed by a chat-completions model
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// toolCallsRequest accepts the tool calls either directly (an assistant
+// message) or inside a full chat completion response
+type toolCallsRequest struct {
+	ToolCalls []openAIToolCall `json:"tool_calls"`
+	Choices   []struct {
+		Message struct {
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"message"`
+	} `json:"choices"`
+
+	// Execute forwards the approved calls instead of only evaluating them
+	Execute bool `json:"execute"`
+}
+
+// toolCallResult is the outcome of one proposed tool call
+type toolCallResult struct {
+	ToolCallID string `json:"tool_call_id"`
+	Name       string `json:"name"`
+	Allowed    bool   `json:"allowed"`
+	Code       string `json:"code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+	DecisionID string `json:"decision_id,omitempty"`
+
+	// Status and Output are set for executed calls
+	Status int    `json:"status,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+// toolMessage is a chat message with role "tool" answering a tool call
+type toolMessage struct {
+	Role       string `json:"role"`
+	ToolCallID string `json:"tool_call_id"`
+	Content    string `json:"content"`
+}
+
+// HandleToolCalls serves POST /v1/tool_calls. It takes the tool_calls of an
+// OpenAI-style chat completion, evaluates each call (function names are
+// "<tool>__<action>", arguments are the params) and returns the decisions.
+// With "execute": true, approved calls are also forwarded, and the response
+// carries role "tool" messages ready to append to the conversation.
+func (g *Gateway) HandleToolCalls(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, ok := g.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	identity, authErr := g.resolveIdentity(r, body)
+	if authErr != nil {
+		writeAuthError(w, r, authErr)
+		return
+	}
+
+	var req toolCallsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	calls := req.ToolCalls
+	for _, choice := range req.Choices {
+		calls = append(calls, choice.Message.ToolCalls...)
+	}
+
+	results := make([]toolCallResult, 0, len(calls))
+	messages := make([]toolMessage, 0, len(calls))
+	for _, call := range calls {
+		result, content := g.handleToolCall(r, identity, call, req.Execute)
+		results = append(results, result)
+		messages = append(messages, toolMessage{Role: "tool", ToolCallID: call.ID, Content: content})
+	}
+
+	response := map[string]interface{}{"results": results}
+	if req.Execute {
+		response["messages"] = messages
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleToolCall evaluates and optionally executes one tool call. The
+// returned content is what the model should see as the call's output.
+func (g *Gateway) handleToolCall(r *http.Request, identity *Identity, call openAIToolCall, execute bool) (toolCallResult, string) {
+	start := time.Now()
+	result := toolCallResult{ToolCallID: call.ID, Name: call.Function.Name}
+
+	deny := func(decision policy.Decision) (toolCallResult, string) {
+		result.Code = decision.Code
+		result.Reason = decision.Reason
+		result.RetryAfter = decision.RetryAfterSeconds()
+		content, _ := json.Marshal(violationBody(decision))
+		return result, string(content)
+	}
+
+	tool, action, ok := strings.Cut(call.Function.Name, toolActionSeparator)
+	if !ok || tool == "" || action == "" {
+		return deny(policy.Decision{
+			Code:   policy.CodeActionNotAllowed,
+			Reason: fmt.Sprintf("Function %s must be named tool%saction", call.Function.Name, toolActionSeparator),
+		})
+	}
+
+	params := make(map[string]interface{})
+	args := []byte(call.Function.Arguments)
+	if strings.TrimSpace(call.Function.Arguments) == "" {
+		args = []byte("{}")
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return deny(policy.Decision{
+			Code:   policy.CodeInvalidParameter,
+			Reason: fmt.Sprintf("Arguments of %s are not a JSON object", call.Function.Name),
+		})
+	}
+
+	// Calls that are only checked don't consume rate limits or budgets
+	req := &policy.Request{Tool: tool, Action: action, Method: http.MethodPost, Params: params, BodySize: len(args)}
+	if !execute {
+		decision := g.precheck(traceContext(r.Header), start, identity, req)
+		result.DecisionID = decision.ID
+		if !decision.Allowed {
+			return deny(decision)
+		}
+		result.Allowed = true
+		return result, ""
+	}
+	spanCtx, span, decision := g.evaluateRequest(traceContext(r.Header), start, identity, req)
+	defer span.End()
+	result.DecisionID = decision.ID
+	if !decision.Allowed {
+		return deny(decision)
+	}
+	result.Allowed = true
+
+	upstream, exists := g.tools.Get(tool)
+	if !exists {
+		result.Reason = fmt.Sprintf("Unknown tool: %s", tool)
+		return result, result.Reason
+	}
+
+	idempotent := upstream.Retry.Idempotent(http.MethodPost, action, r.Header.Get("Idempotency-Key") != "")
+	status, out, err := g.callTool(r.Context(), spanCtx, upstream, action, args, idempotent, identity, decision.Response)
+	if err != nil {
+		result.Reason = err.Error()
+		content, _ := json.Marshal(callErrorBody(err))
+		return result, string(content)
+	}
+	result.Status = status
+	result.Output = string(out)
+	return result, result.Output
+}
@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+
+	aegisv1 "aegis-gateway/api/aegis/v1"
+)
+
+const rateLimitedPolicy = `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create]
+        conditions:
+          rate_limit: {requests: 1, per: 1m}
+`
+
+// Calls that are only evaluated must not use up the agent's rate limit
+func TestEvaluateOnlyDoesNotConsume(t *testing.T) {
+	tests := []struct {
+		name     string
+		evaluate func(t *testing.T, g *Gateway) bool
+	}{
+		{"tool calls without execute", func(t *testing.T, g *Gateway) bool {
+			body := `{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"payments__create","arguments":"{}"}}]}`
+			r := httptest.NewRequest(http.MethodPost, "/v1/tool_calls", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-Agent-ID", "finance-agent")
+			w := httptest.NewRecorder()
+			g.HandleToolCalls(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d: %s", w.Code, w.Body)
+			}
+			return strings.Contains(w.Body.String(), `"allowed":true`)
+		}},
+		{"gRPC Evaluate", func(t *testing.T, g *Gateway) bool {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-agent-id", "finance-agent"))
+			resp, err := (&grpcServer{g: g}).Evaluate(ctx, &aegisv1.EvaluateRequest{Tool: "payments", Action: "create"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return resp.Allowed
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t, rateLimitedPolicy, nil)
+			for i := 0; i < 3; i++ {
+				if !tt.evaluate(t, g) {
+					t.Fatalf("evaluation %d was denied", i+1)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,708 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// openAPIVersion is the OpenAPI version of the served documents. 3.1 takes
+// JSON Schema 2020-12 as is, so tool schemas are embedded unchanged.
+const openAPIVersion = "3.1.0"
+
+// openAPIComponentChars are the characters OpenAPI allows in component names
+var openAPIComponentChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// openAPIDoc is an OpenAPI document under construction. Paths and
+// components are plain maps so tool schemas can be embedded as written.
+type openAPIDoc struct {
+	paths   map[string]map[string]interface{}
+	schemas map[string]interface{}
+}
+
+func newOpenAPIDoc() *openAPIDoc {
+	return &openAPIDoc{
+		paths:   make(map[string]map[string]interface{}),
+		schemas: make(map[string]interface{}),
+	}
+}
+
+// operation adds the operation for method on path
+func (d *openAPIDoc) operation(method, path string, op map[string]interface{}) {
+	if d.paths[path] == nil {
+		d.paths[path] = make(map[string]interface{})
+	}
+	d.paths[path][strings.ToLower(method)] = op
+}
+
+// component registers the schema of a Go type under name and returns a
+// reference to it
+func (d *openAPIDoc) component(name string, v interface{}) map[string]interface{} {
+	if _, ok := d.schemas[name]; !ok {
+		d.schemas[name] = typeSchema(reflect.TypeOf(v), nil)
+	}
+	return schemaRef(name)
+}
+
+// toolComponent registers a tool's JSON Schema under name. The schema's
+// references to itself ("#") are pointed at the component.
+func (d *openAPIDoc) toolComponent(name string, doc interface{}) map[string]interface{} {
+	name = openAPIComponentChars.ReplaceAllString(name, "_")
+	d.schemas[name] = rebaseSchema(doc, "#/components/schemas/"+name)
+	return schemaRef(name)
+}
+
+func (d *openAPIDoc) build(title, description string, security map[string]interface{}, requirements []map[string][]string) map[string]interface{} {
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       title,
+			"description": description,
+			"version":     "1",
+		},
+		"paths": d.paths,
+		"components": map[string]interface{}{
+			"schemas":         d.schemas,
+			"securitySchemes": security,
+		},
+		"security": requirements,
+	}
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// jsonContent describes a JSON request or response body
+func jsonContent(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}},
+	}
+}
+
+// problemResponse describes an application/problem+json error response
+func problemResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     map[string]interface{}{"application/problem+json": map[string]interface{}{"schema": schemaRef("Problem")}},
+	}
+}
+
+// parameter describes a string query, path or header parameter
+func parameter(in, name, description string, required bool) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"in":          in,
+		"description": description,
+		"required":    required,
+		"schema":      map[string]interface{}{"type": "string"},
+	}
+}
+
+// HandleOpenAPI serves GET /openapi.json, an OpenAPI document of the agent
+// API. Each action the agent may call gets its own path, /tools/:tool/:action,
+// typed by the tool's schemas in the version named by ?version= or
+// X-Aegis-Schema-Version, so SDKs can be generated from it.
+func (g *Gateway) HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	identity, authErr := g.resolveIdentity(r, nil)
+	if authErr != nil {
+		writeAuthError(w, r, authErr)
+		return
+	}
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		version = identity.SchemaVersion
+	}
+
+	d := newOpenAPIDoc()
+	d.component("Problem", problem{})
+	g.addToolPaths(d, identity, version)
+	addAgentPaths(d)
+
+	security := map[string]interface{}{
+		"bearerAuth": map[string]interface{}{
+			"type":        "http",
+			"scheme":      "bearer",
+			"description": "Gateway API key, JWT or OIDC ID token",
+		},
+		"agentID": map[string]interface{}{
+			"type":        "apiKey",
+			"in":          "header",
+			"name":        "X-Agent-ID",
+			"description": "Agent ID, when the gateway accepts unauthenticated agents",
+		},
+		"mutualTLS": map[string]interface{}{
+			"type":        "mutualTLS",
+			"description": "Client certificate or SPIFFE SVID",
+		},
+	}
+	requirements := []map[string][]string{{"bearerAuth": {}}, {"agentID": {}}, {"mutualTLS": {}}}
+	writeJSON(w, http.StatusOK, d.build("Aegis Gateway", "Policy-enforcing gateway for agent tool calls", security, requirements))
+}
+
+// addToolPaths adds a path per action the agent may call on HTTP, gRPC,
+// GraphQL, SQL, exec, files, email and fetch tools. MCP tools are reached through /mcp/:tool and aren't listed.
+func (g *Gateway) addToolPaths(d *openAPIDoc, identity *Identity, version string) {
+	allowed := g.policyEngine.AllowedActions(identity.AgentID, identity.Groups)
+	names := make([]string, 0, len(allowed))
+	for name := range allowed {
+		if t, ok := g.tools.Get(name); ok && t.Protocol != registry.ProtocolMCP {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		tool, _ := g.tools.Get(name)
+		v, _ := schemaVersion(tool, version)
+		for _, action := range allowed[name] {
+			request := interface{}(map[string]interface{}{"type": "object"})
+			response := interface{}(map[string]interface{}{})
+			if v != nil {
+				if a := v.Action(action); a != nil {
+					if a.Request != nil {
+						request = d.toolComponent(name+"."+action+".request", a.Request.Document())
+					}
+					if a.Response != nil {
+						response = d.toolComponent(name+"."+action+".response", a.Response.Document())
+					}
+				}
+			}
+
+			// GraphQL, SQL and exec tools take queries and commands,
+			// POSTed to the action named after the operation, statement
+			// type or binary
+			methods := tool.Methods
+			switch tool.Protocol {
+			case registry.ProtocolGraphQL:
+				methods = []string{http.MethodPost}
+				request = d.component("GraphQLRequest", graphQLRequest{})
+				requireFields(d, "GraphQLRequest", "query")
+			case registry.ProtocolSQL:
+				methods = []string{http.MethodPost}
+				request = d.component("SQLRequest", sqlRequest{})
+				requireFields(d, "SQLRequest", "query")
+				if action == "select" {
+					response = d.component("SQLRows", sqlRows{})
+				} else {
+					response = d.component("SQLExecResult", sqlExecResult{})
+				}
+			case registry.ProtocolExec:
+				methods = []string{http.MethodPost}
+				request = d.component("ExecRequest", execRequest{})
+				response = d.component("ExecResult", execResult{})
+			case registry.ProtocolFiles:
+				request = d.component("FilesRequest", filesRequest{})
+				response = d.component("FilesResult", fileResult{})
+			case registry.ProtocolEmail:
+				methods = []string{http.MethodPost}
+				request = d.component("EmailRequest", emailRequest{})
+				requireFields(d, "EmailRequest", "to", "subject")
+				response = d.component("EmailReceipt", emailReceipt{})
+			case registry.ProtocolFetch:
+				methods = []string{http.MethodPost}
+				request = d.component("FetchRequest", fetchRequest{})
+				requireFields(d, "FetchRequest", "url")
+				response = d.component("FetchResult", fetchResult{})
+			}
+
+			for _, method := range methods {
+				operationID := name + toolActionSeparator + action
+				if len(methods) > 1 {
+					operationID += "_" + strings.ToLower(method)
+				}
+				op := map[string]interface{}{
+					"operationId": operationID,
+					"summary":     fmt.Sprintf("Perform %s on the %s tool", action, name),
+					"tags":        []string{name},
+					"parameters": []interface{}{
+						map[string]interface{}{
+							"name":        "mode",
+							"in":          "query",
+							"description": "async runs the call in the background and returns a job",
+							"schema":      map[string]interface{}{"type": "string", "enum": []string{"async"}},
+						},
+						parameter("header", "Idempotency-Key", "Replays the stored response when the call is retried", false),
+						parameter("header", sessionIDHeader, "Session or conversation the call belongs to", false),
+						parameter("header", callbackHeader, "URL the result of an async call is posted to", false),
+					},
+					"responses": map[string]interface{}{
+						"200":     jsonContent("The tool's response", response),
+						"202":     jsonContent("Async job accepted", d.component("Job", job{})),
+						"403":     problemResponse("Denied by policy"),
+						"429":     problemResponse("Rate limit or budget exceeded"),
+						"502":     problemResponse("The tool failed or its response was blocked"),
+						"default": problemResponse("Error"),
+					},
+				}
+				if method != http.MethodGet && method != http.MethodDelete && method != http.MethodHead {
+					op["requestBody"] = map[string]interface{}{
+						"description": "The action's params",
+						"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": request}},
+					}
+				}
+				d.operation(method, "/tools/"+name+"/"+action, op)
+			}
+		}
+	}
+}
+
+// addAgentPaths adds the gateway's own agent endpoints
+func addAgentPaths(d *openAPIDoc) {
+	simulate := d.component("SimulateRequest", simulateRequest{})
+	requireFields(d, "SimulateRequest", "agent_id", "tool", "action")
+	d.component("BatchCall", batchCall{})
+	requireFields(d, "BatchCall", "tool", "action")
+	noAuth := []map[string][]string{}
+
+	d.operation(http.MethodGet, "/v1/policies", map[string]interface{}{
+		"operationId": "getPolicies",
+		"summary":     "List the active policy rules",
+		"responses":   map[string]interface{}{"200": jsonContent("Policy rules", d.component("PolicySnapshot", policy.Snapshot{}))},
+	})
+	d.operation(http.MethodPost, "/v1/simulate", map[string]interface{}{
+		"operationId": "simulate",
+		"summary":     "Explain the decision for a prospective call",
+		"requestBody": jsonContent("The call", simulate),
+		"responses": map[string]interface{}{
+			"200":     jsonContent("The decision", d.component("SimulateResponse", simulateResponse{})),
+			"default": problemResponse("Error"),
+		},
+	})
+	d.operation(http.MethodPost, "/v1/evaluate/batch", map[string]interface{}{
+		"operationId": "evaluateBatch",
+		"summary":     "Evaluate the calls of a plan without forwarding them",
+		"requestBody": jsonContent("The calls", objectSchema(map[string]interface{}{
+			"calls": map[string]interface{}{"type": "array", "items": schemaRef("BatchCall"), "minItems": 1, "maxItems": maxBatchCalls},
+		}, "calls")),
+		"responses": map[string]interface{}{
+			"200": jsonContent("One decision per call", objectSchema(map[string]interface{}{
+				"allowed":   map[string]interface{}{"type": "boolean"},
+				"decisions": map[string]interface{}{"type": "array", "items": d.component("BatchDecision", batchDecision{})},
+			})),
+			"default": problemResponse("Error"),
+		},
+	})
+	d.operation(http.MethodGet, "/v1/capabilities", map[string]interface{}{
+		"operationId": "getCapabilities",
+		"summary":     "List the actions the agent may call and their conditions",
+		"parameters":  []interface{}{parameter("query", "tool", "Only this tool", false)},
+		"responses":   map[string]interface{}{"200": jsonContent("The agent's capabilities", d.component("Capabilities", capabilities{}))},
+	})
+	d.operation(http.MethodPost, "/v1/tool_calls", map[string]interface{}{
+		"operationId": "toolCalls",
+		"summary":     "Evaluate, and optionally execute, the tool calls of a chat completion",
+		"requestBody": jsonContent("Tool calls", d.component("ToolCallsRequest", toolCallsRequest{})),
+		"responses": map[string]interface{}{
+			"200": jsonContent("Decisions, and tool messages for executed calls", objectSchema(map[string]interface{}{
+				"results":  map[string]interface{}{"type": "array", "items": d.component("ToolCallResult", toolCallResult{})},
+				"messages": map[string]interface{}{"type": "array", "items": d.component("ToolMessage", toolMessage{})},
+			})),
+			"default": problemResponse("Error"),
+		},
+	})
+	d.operation(http.MethodGet, "/v1/tools/{tool}/schema", map[string]interface{}{
+		"operationId": "getToolSchema",
+		"summary":     "Get the schemas of the actions the agent may call on a tool",
+		"parameters": []interface{}{
+			parameter("path", "tool", "Tool name", true),
+			parameter("query", "version", "Schema version (default the current one)", false),
+		},
+		"responses": map[string]interface{}{
+			"200": jsonContent("The tool's schemas", d.component("ToolSchema", toolSchema{})),
+			"404": problemResponse("Unknown tool or version"),
+		},
+	})
+	d.operation(http.MethodGet, "/jobs/{id}", map[string]interface{}{
+		"operationId": "getJob",
+		"summary":     "Get an async job",
+		"parameters":  []interface{}{parameter("path", "id", "Job ID", true)},
+		"responses": map[string]interface{}{
+			"200": jsonContent("The job", d.component("Job", job{})),
+			"404": problemResponse("Unknown job"),
+		},
+	})
+	d.operation(http.MethodPost, "/mcp", map[string]interface{}{
+		"operationId": "mcp",
+		"summary":     "MCP server (JSON-RPC 2.0) exposing the agent's tools",
+		"requestBody": jsonContent("JSON-RPC request", d.component("RPCRequest", rpcRequest{})),
+		"responses":   map[string]interface{}{"200": jsonContent("JSON-RPC response", d.component("RPCResponse", rpcResponse{}))},
+	})
+	d.operation(http.MethodGet, "/openapi.json", map[string]interface{}{
+		"operationId": "getOpenAPI",
+		"summary":     "This document",
+		"parameters":  []interface{}{parameter("query", "version", "Schema version tools are described in", false)},
+		"responses":   map[string]interface{}{"200": jsonContent("OpenAPI document", map[string]interface{}{"type": "object"})},
+	})
+	d.operation(http.MethodGet, "/healthz", map[string]interface{}{
+		"operationId": "healthz",
+		"summary":     "Liveness probe",
+		"security":    noAuth,
+		"responses":   map[string]interface{}{"200": jsonContent("The gateway is up", map[string]interface{}{"type": "object"})},
+	})
+	d.operation(http.MethodGet, "/readyz", map[string]interface{}{
+		"operationId": "readyz",
+		"summary":     "Readiness probe",
+		"security":    noAuth,
+		"parameters":  []interface{}{parameter("query", "upstreams", "true also probes the tools' health checks", false)},
+		"responses": map[string]interface{}{
+			"200": jsonContent("Ready", d.component("Readiness", readiness{})),
+			"503": jsonContent("Not ready", schemaRef("Readiness")),
+		},
+	})
+}
+
+// HandleAdminOpenAPI serves GET /admin/openapi.json, an OpenAPI document of
+// the admin API
+func (g *Gateway) HandleAdminOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	d := newOpenAPIDoc()
+	d.component("Problem", problem{})
+	addAdminPaths(d)
+
+	security := map[string]interface{}{
+		"adminToken": map[string]interface{}{
+			"type":        "http",
+			"scheme":      "bearer",
+			"description": "Admin token; its role limits what it may do",
+		},
+	}
+	writeJSON(w, http.StatusOK, d.build("Aegis Gateway Admin API", "Manage the policies, tools, API keys and dead letters of the gateway", security, []map[string][]string{{"adminToken": {}}}))
+}
+
+// addAdminPaths adds the admin API endpoints
+func addAdminPaths(d *openAPIDoc) {
+	object := map[string]interface{}{"type": "object"}
+	d.component("ToolRegistration", toolRegistration{})
+	requireFields(d, "ToolRegistration", "name", "url")
+	d.component("KeyRequest", keyRequest{})
+	requireFields(d, "KeyRequest", "agent_id")
+
+	d.operation(http.MethodGet, "/admin/policies", map[string]interface{}{
+		"operationId": "adminGetPolicies",
+		"summary":     "List the loaded policy rules",
+		"responses":   map[string]interface{}{"200": jsonContent("Policy rules", d.component("PolicySnapshot", policy.Snapshot{}))},
+	})
+	d.operation(http.MethodPost, "/admin/policies/reload", map[string]interface{}{
+		"operationId": "adminReloadPolicies",
+		"summary":     "Re-read the policy files",
+		"responses": map[string]interface{}{
+			"200": jsonContent("Reloaded", object),
+			"422": jsonContent("Some files had errors", object),
+		},
+	})
+	d.operation(http.MethodGet, "/admin/decisions", map[string]interface{}{
+		"operationId": "adminListDecisions",
+		"summary":     "List the latest decisions, newest first",
+		"parameters": []interface{}{
+			parameter("query", "agent", "Only decisions for this agent", false),
+			parameter("query", "tool", "Only decisions for this tool", false),
+			parameter("query", "session", "Only decisions in this session", false),
+			parameter("query", "allowed", "true or false", false),
+			map[string]interface{}{
+				"name":        "limit",
+				"in":          "query",
+				"description": "Maximum number of decisions (default 100)",
+				"schema":      map[string]interface{}{"type": "integer", "minimum": 1},
+			},
+		},
+		"responses": map[string]interface{}{"200": jsonContent("Decisions", object)},
+	})
+	d.operation(http.MethodGet, "/v1/decisions", map[string]interface{}{
+		"operationId": "queryDecisions",
+		"summary":     "Query stored decisions, newest first",
+		"parameters": []interface{}{
+			parameter("query", "agent", "Only decisions for this agent", false),
+			parameter("query", "tool", "Only decisions for this tool", false),
+			parameter("query", "session", "Only decisions in this session", false),
+			parameter("query", "code", "Only decisions with this deny code", false),
+			parameter("query", "result", "allow or deny", false),
+			parameter("query", "since", "RFC 3339 time, or a duration such as 24h meaning that long ago", false),
+			parameter("query", "until", "RFC 3339 time, or a duration such as 1h meaning that long ago", false),
+			parameter("query", "cursor", "The next cursor of the previous page", false),
+			map[string]interface{}{
+				"name":        "limit",
+				"in":          "query",
+				"description": "Maximum number of decisions (default 100)",
+				"schema":      map[string]interface{}{"type": "integer", "minimum": 1, "maximum": telemetry.MaxDecisionPage},
+			},
+		},
+		"responses": map[string]interface{}{
+			"200": jsonContent("Decisions and the next cursor", d.component("DecisionPage", telemetry.DecisionPage{})),
+			"501": jsonContent("No decision store is configured", object),
+		},
+	})
+	d.operation(http.MethodGet, "/v1/decisions/stream", map[string]interface{}{
+		"operationId": "streamDecisions",
+		"summary":     "Stream decisions as they are made, as server-sent events or over a WebSocket",
+		"parameters": []interface{}{
+			parameter("query", "agent", "Only decisions for this agent", false),
+			parameter("query", "tool", "Only decisions for this tool", false),
+			parameter("query", "session", "Only decisions in this session", false),
+			parameter("query", "code", "Only decisions with this deny code", false),
+			parameter("query", "result", "allow or deny", false),
+		},
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "decision events, and dropped events when the client fell behind",
+				"content":     map[string]interface{}{"text/event-stream": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}},
+			},
+			"101": map[string]interface{}{"description": "Switched to a WebSocket carrying decision and dropped messages"},
+		},
+	})
+	d.operation(http.MethodGet, "/v1/analytics/agents/{id}", map[string]interface{}{
+		"operationId": "getAgentAnalytics",
+		"summary":     "Summarize an agent's stored decisions and budget use",
+		"parameters": []interface{}{
+			parameter("path", "id", "Agent ID", true),
+			parameter("query", "since", "RFC 3339 time, or a duration such as 168h meaning that long ago (default 24h)", false),
+			parameter("query", "until", "RFC 3339 time, or a duration such as 1h meaning that long ago (default now)", false),
+		},
+		"responses": map[string]interface{}{
+			"200": jsonContent("Agent analytics", d.component("AgentAnalytics", agentAnalytics{})),
+			"501": jsonContent("No decision store is configured", object),
+		},
+	})
+	d.operation(http.MethodGet, "/admin/tools", map[string]interface{}{
+		"operationId": "adminListTools",
+		"summary":     "List the registered tools",
+		"responses":   map[string]interface{}{"200": jsonContent("Tools", object)},
+	})
+	d.operation(http.MethodPost, "/admin/tools", map[string]interface{}{
+		"operationId": "adminRegisterTool",
+		"summary":     "Register or replace a tool",
+		"requestBody": jsonContent("The tool", schemaRef("ToolRegistration")),
+		"responses": map[string]interface{}{
+			"201":     jsonContent("Registered", object),
+			"default": problemResponse("Error"),
+		},
+	})
+	d.operation(http.MethodDelete, "/admin/tools/{name}", map[string]interface{}{
+		"operationId": "adminDrainTool",
+		"summary":     "Remove a tool",
+		"parameters":  []interface{}{parameter("path", "name", "Tool name", true)},
+		"responses": map[string]interface{}{
+			"200": jsonContent("Drained", object),
+			"404": problemResponse("Unknown tool"),
+		},
+	})
+	d.operation(http.MethodGet, "/admin/keys", map[string]interface{}{
+		"operationId": "adminListKeys",
+		"summary":     "List API keys",
+		"responses":   map[string]interface{}{"200": jsonContent("Keys", object)},
+	})
+	d.operation(http.MethodPost, "/admin/keys", map[string]interface{}{
+		"operationId": "adminMintKey",
+		"summary":     "Mint an API key for an agent; the key is only returned once",
+		"requestBody": jsonContent("The key", schemaRef("KeyRequest")),
+		"responses": map[string]interface{}{
+			"201":     jsonContent("Minted", object),
+			"default": problemResponse("Error"),
+		},
+	})
+	d.operation(http.MethodDelete, "/admin/keys/{id}", map[string]interface{}{
+		"operationId": "adminRevokeKey",
+		"summary":     "Revoke an API key",
+		"parameters":  []interface{}{parameter("path", "id", "Key ID", true)},
+		"responses": map[string]interface{}{
+			"200": jsonContent("Revoked", object),
+			"404": problemResponse("Unknown key"),
+		},
+	})
+	d.operation(http.MethodGet, "/admin/dead_letters", map[string]interface{}{
+		"operationId": "adminListDeadLetters",
+		"summary":     "List undelivered calls",
+		"responses":   map[string]interface{}{"200": jsonContent("Entries", object)},
+	})
+	d.operation(http.MethodGet, "/admin/dead_letters/{id}", map[string]interface{}{
+		"operationId": "adminGetDeadLetter",
+		"summary":     "Inspect an undelivered call",
+		"parameters":  []interface{}{parameter("path", "id", "Entry ID", true)},
+		"responses": map[string]interface{}{
+			"200": jsonContent("The entry", object),
+			"404": problemResponse("Unknown entry"),
+		},
+	})
+	d.operation(http.MethodDelete, "/admin/dead_letters/{id}", map[string]interface{}{
+		"operationId": "adminDiscardDeadLetter",
+		"summary":     "Discard an undelivered call",
+		"parameters":  []interface{}{parameter("path", "id", "Entry ID", true)},
+		"responses": map[string]interface{}{
+			"204": map[string]interface{}{"description": "Discarded"},
+			"404": problemResponse("Unknown entry"),
+		},
+	})
+	d.operation(http.MethodPost, "/admin/dead_letters/{id}/replay", map[string]interface{}{
+		"operationId": "adminReplayDeadLetter",
+		"summary":     "Send an undelivered call to its tool again",
+		"parameters":  []interface{}{parameter("path", "id", "Entry ID", true)},
+		"responses": map[string]interface{}{
+			"200": jsonContent("Delivered", object),
+			"502": problemResponse("Delivery failed again"),
+		},
+	})
+	d.operation(http.MethodGet, "/admin/anomalies", map[string]interface{}{
+		"operationId": "adminListAnomalies",
+		"summary":     "List the agents held after an anomaly and the latest anomalies and tripwires",
+		"responses":   map[string]interface{}{"200": jsonContent("Holds and anomalies", object)},
+	})
+	d.operation(http.MethodPost, "/admin/anomalies/{agent}/approve", map[string]interface{}{
+		"operationId": "adminApproveAgent",
+		"summary":     "Release an agent held after an anomaly",
+		"parameters":  []interface{}{parameter("path", "agent", "Agent ID", true)},
+		"responses": map[string]interface{}{
+			"200": jsonContent("Approved", object),
+			"404": problemResponse("The agent is not held"),
+		},
+	})
+	d.operation(http.MethodGet, "/admin/quarantine", map[string]interface{}{
+		"operationId": "adminListQuarantine",
+		"summary":     "List the quarantined agents",
+		"responses":   map[string]interface{}{"200": jsonContent("Quarantined agents", object)},
+	})
+	d.operation(http.MethodPost, "/admin/quarantine/{agent}/release", map[string]interface{}{
+		"operationId": "adminReleaseAgent",
+		"summary":     "Release a quarantined agent",
+		"parameters":  []interface{}{parameter("path", "agent", "Agent ID", true)},
+		"responses": map[string]interface{}{
+			"200": jsonContent("Released", object),
+			"404": problemResponse("The agent is not quarantined"),
+		},
+	})
+	d.operation(http.MethodGet, "/admin/openapi.json", map[string]interface{}{
+		"operationId": "adminGetOpenAPI",
+		"summary":     "This document",
+		"responses":   map[string]interface{}{"200": jsonContent("OpenAPI document", object)},
+	})
+}
+
+// objectSchema describes an object with the given properties
+func objectSchema(properties map[string]interface{}, required ...string) map[string]interface{} {
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// requireFields marks fields of a registered component as required
+func requireFields(d *openAPIDoc, name string, fields ...string) {
+	if s, ok := d.schemas[name].(map[string]interface{}); ok {
+		s["required"] = fields
+	}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// typeSchema describes the JSON encoding of t. Struct types already being
+// described (seen) are left open rather than recursed into.
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), seen)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			// Embedded structs are inlined, as encoding/json does
+			if name == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+				embedded, _ := typeSchema(f.Type, seen)["properties"].(map[string]interface{})
+				for name, schema := range embedded {
+					properties[name] = schema
+				}
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = typeSchema(f.Type, seen)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	// interface{} holds any JSON value
+	return map[string]interface{}{}
+}
+
+// rebaseSchema copies a standalone schema, pointing its references to its
+// own root at ref
+func rebaseSchema(node interface{}, ref string) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(n))
+		for key, value := range n {
+			if s, ok := value.(string); ok && key == "$ref" && s == "#" {
+				out[key] = ref
+				continue
+			}
+			out[key] = rebaseSchema(value, ref)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(n))
+		for i, item := range n {
+			out[i] = rebaseSchema(item, ref)
+		}
+		return out
+	}
+	return node
+}
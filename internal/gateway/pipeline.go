@@ -0,0 +1,673 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
+
+	"aegis-gateway/internal/auth"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// Stage names a step of the tool call pipeline. Calls run through the
+// stages in the order below.
+type Stage string
+
+const (
+	// StageAuth establishes the agent identity
+	StageAuth Stage = "auth"
+	// StageParse reads the body and query string into Params, scans
+	// uploaded files for malware, and parses queries to GraphQL and SQL
+	// tools, commands to exec tools, operations on files tools, messages
+	// to email tools and requests to fetch tools
+	StageParse Stage = "parse"
+	// StageRateLimit answers retries of idempotent requests before they
+	// are counted against policy rate limits and budgets
+	StageRateLimit Stage = "rate-limit"
+	// StageEvaluate runs request plugins, then checks the call against
+	// policy and rejects denied calls
+	StageEvaluate Stage = "evaluate"
+	// StageTransform picks the tool version and builds the upstream request
+	StageTransform Stage = "transform"
+	// StageForward calls the tool and writes its response, after it is
+	// checked against the tool's schema and response plugins have seen it
+	StageForward Stage = "forward"
+)
+
+var stageOrder = []Stage{StageAuth, StageParse, StageRateLimit, StageEvaluate, StageTransform, StageForward}
+
+// Call is a tool call on its way through the pipeline. Fields are filled
+// in by the stage that produces them and are zero before it has run.
+type Call struct {
+	Request *http.Request
+	Start   time.Time
+
+	// Tool, Action and Resource are parsed from /tools/:tool/:action[/resource...]
+	Tool     string
+	Action   string
+	Resource string
+
+	// Identity is set by StageAuth
+	Identity *Identity
+
+	// Params are the body and query parameters, set by StageParse
+	Params map[string]interface{}
+
+	// Context carries the caller's trace context, and the call's span once
+	// StageEvaluate has run
+	Context context.Context
+
+	// Decision is set by StageEvaluate
+	Decision policy.Decision
+
+	// Upstream is the tool version the call goes to, set by StageTransform
+	Upstream *registry.Tool
+
+	bodyBytes []byte
+	body      requestBody
+	bodySize  int
+	multipart bool
+	async     bool
+	upgrade   bool
+
+	// malware lists the threats found in the call's uploaded files
+	malware []string
+
+	// graphql is the operation of a call to a GraphQL tool
+	graphql *policy.GraphQLOperation
+
+	// sql is the statement of a call to a SQL tool
+	sql *sqlStatement
+
+	// exec is the command of a call to an exec tool
+	exec *execCommand
+
+	// files is the operation of a call to a files tool
+	files *fileCommand
+
+	// email is the message of a call to an email tool
+	email *emailMessage
+
+	// fetch is the request of a call to a fetch tool
+	fetch *fetchCall
+
+	// recorder captures the response for an Idempotency-Key, if any
+	recorder *recordingWriter
+
+	target     string
+	idempotent bool
+}
+
+// CallHandler handles a call at some point of the pipeline
+type CallHandler func(w http.ResponseWriter, c *Call)
+
+// Middleware wraps the rest of the pipeline. It can inspect or change the
+// call, wrap w, or write a response instead of calling next.
+type Middleware func(next CallHandler) CallHandler
+
+// Use adds mw to the pipeline right before the built-in stage. Middleware
+// added to the same stage runs in the order it was added.
+func (g *Gateway) Use(stage Stage, mw Middleware) error {
+	known := false
+	for _, s := range stageOrder {
+		known = known || s == stage
+	}
+	if !known {
+		return fmt.Errorf("unknown pipeline stage %q", stage)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.middleware == nil {
+		g.middleware = make(map[Stage][]Middleware)
+	}
+	g.middleware[stage] = append(g.middleware[stage], mw)
+	g.pipeline = nil
+	return nil
+}
+
+// callPipeline returns the composed pipeline, building it after Use
+func (g *Gateway) callPipeline() CallHandler {
+	g.mu.RLock()
+	h := g.pipeline
+	g.mu.RUnlock()
+	if h != nil {
+		return h
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.pipeline != nil {
+		return g.pipeline
+	}
+	builtin := map[Stage]Middleware{
+		StageAuth:      g.authenticateCall,
+		StageParse:     chain(g.parseCall, g.protocolAdapters()),
+		StageRateLimit: g.replayIdempotent,
+		StageEvaluate:  chain(g.runRequestPlugins, g.evaluateCall),
+		StageTransform: g.transformCall,
+		StageForward:   chain(g.filterResponses, g.runResponsePlugins, g.validateResponses),
+	}
+	h = g.forwardCall
+	for i := len(stageOrder) - 1; i >= 0; i-- {
+		stage := stageOrder[i]
+		if mw, ok := builtin[stage]; ok {
+			h = mw(h)
+		}
+		custom := g.middleware[stage]
+		for j := len(custom) - 1; j >= 0; j-- {
+			h = custom[j](h)
+		}
+	}
+	g.pipeline = h
+	return h
+}
+
+// protocolAdapters parses the calls to tools that aren't plain HTTP APIs
+// into the statement, command, operation, message or request they make
+func (g *Gateway) protocolAdapters() Middleware {
+	return chain(g.parseGraphQL, g.parseSQL, g.parseExec, g.parseFiles, g.parseEmail, g.parseFetch)
+}
+
+// chain runs mws in order as one middleware
+func chain(mws ...Middleware) Middleware {
+	return func(next CallHandler) CallHandler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}
+}
+
+// HandleRequest processes incoming requests
+func (g *Gateway) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	c := &Call{Request: r, Start: time.Now(), Context: traceContext(r.Header)}
+
+	// Parse path: /tools/:tool/:action[/resource...]
+	pathParts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 4)
+	if len(pathParts) < 3 || pathParts[0] != "tools" {
+		writeError(w, "Invalid path. Expected: /tools/:tool/:action", http.StatusBadRequest)
+		return
+	}
+
+	c.Tool = pathParts[1]
+	c.Action = pathParts[2]
+	if len(pathParts) == 4 {
+		c.Resource = pathParts[3]
+		for _, segment := range strings.Split(c.Resource, "/") {
+			if segment == "." || segment == ".." {
+				writeError(w, "Invalid path: dot segments are not allowed", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	g.callPipeline()(w, c)
+}
+
+// authenticateCall establishes the agent identity (client certificate,
+// signature, bearer token or X-Agent-ID)
+func (g *Gateway) authenticateCall(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		r := c.Request
+
+		// Other bodies are read before authentication, since signatures
+		// cover them, and are capped at server.max_request_bytes. Uploads
+		// are read after it, so anonymous callers can't make the gateway
+		// spool files.
+		c.multipart = isMultipart(r)
+		if c.multipart {
+			if r.Header.Get(auth.SignatureHeader) != "" {
+				writeError(w, "Request signatures are not supported for multipart uploads", http.StatusBadRequest)
+				return
+			}
+		} else {
+			var ok bool
+			if c.bodyBytes, ok = g.readBody(w, r); !ok {
+				return
+			}
+		}
+
+		identity, authErr := g.resolveIdentity(r, c.bodyBytes)
+		if authErr != nil {
+			writeAuthError(w, r, authErr)
+			return
+		}
+		c.Identity = identity
+		next(w, c)
+	}
+}
+
+// readBody reads a request body of at most server.max_request_bytes. When
+// it can't, it answers the request itself and returns false.
+func (g *Gateway) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	g.mu.RLock()
+	limit := g.config.Server.RequestBodyLimit()
+	g.mu.RUnlock()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, fmt.Sprintf("Request body exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// parseCall parses the JSON body, or the form fields and file metadata of
+// an upload, and merges in the query string
+func (g *Gateway) parseCall(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		r := c.Request
+		c.body, c.bodySize = jsonBody(c.bodyBytes), len(c.bodyBytes)
+		if c.multipart {
+			g.mu.RLock()
+			limits := g.config.Uploads
+			g.mu.RUnlock()
+
+			up, uploadErr := readUpload(w, r, limits)
+			if uploadErr != nil {
+				writeError(w, uploadErr.message, uploadErr.status)
+				return
+			}
+			defer up.cleanup()
+			if scanErr := g.scanUpload(r.Context(), up); scanErr != nil {
+				writeError(w, scanErr.message, scanErr.status)
+				return
+			}
+			c.Params, c.body, c.bodySize, c.malware = up.params(), up, int(up.size), up.threats()
+		} else if len(c.bodyBytes) > 0 {
+			if err := json.Unmarshal(c.bodyBytes, &c.Params); err != nil {
+				writeError(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+				return
+			}
+		} else {
+			c.Params = make(map[string]interface{})
+		}
+
+		// The tool gets the query string as parsed here, so pairs Go drops,
+		// such as ones split by semicolons, can't reach it unevaluated.
+		// mode=async is addressed to the gateway, not the tool.
+		query, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			writeError(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+			return
+		}
+		c.async = query.Get("mode") == "async"
+		if c.async {
+			query.Del("mode")
+		}
+		r.URL.RawQuery = query.Encode()
+		if err := mergeQuery(c.Params, query); err != nil {
+			writeError(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+			return
+		}
+		next(w, c)
+	}
+}
+
+// replayIdempotent answers a retry with a known Idempotency-Key with the
+// original response. This runs before evaluation so a replayed payment
+// isn't counted against rate limits or budgets twice.
+func (g *Gateway) replayIdempotent(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		r := c.Request
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || websocket.IsWebSocketUpgrade(r) {
+			next(w, c)
+			return
+		}
+
+		g.mu.RLock()
+		settings := g.config.Idempotency.WithDefaults()
+		g.mu.RUnlock()
+
+		scope := strings.Join([]string{c.Identity.AgentID, c.Tool, c.Action, key}, "\x00")
+		fingerprint := strings.Join([]string{r.Method, c.Resource, telemetry.HashParams(c.Params)}, "\x00")
+		entry, state := g.idempotency.begin(scope, fingerprint, time.Now(), settings.MaxEntries)
+		switch state {
+		case idempotencyReplay:
+			entry.replay(w)
+			return
+		case idempotencyInFlight:
+			writeIdempotencyConflict(w, http.StatusConflict, "IDEMPOTENCY_KEY_IN_USE", "A request with this Idempotency-Key is still in progress")
+			return
+		case idempotencyMismatch:
+			writeIdempotencyConflict(w, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", "Idempotency-Key was already used for a different request")
+			return
+		case idempotencyFull:
+			w.Header().Set("Retry-After", "1")
+			writeIdempotencyConflict(w, http.StatusServiceUnavailable, "IDEMPOTENCY_STORE_FULL", "Too many requests with an Idempotency-Key are in progress")
+			return
+		}
+
+		c.recorder = &recordingWriter{ResponseWriter: w, status: http.StatusOK, limit: settings.MaxBodyBytes}
+		defer g.idempotency.finish(scope, c.recorder, settings.TTL)
+		next(c.recorder, c)
+	}
+}
+
+// evaluateCall checks the call against policy and logs the decision
+func (g *Gateway) evaluateCall(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		r := c.Request
+		var statement *policy.SQLStatement
+		if c.sql != nil {
+			statement = &c.sql.SQLStatement
+		}
+		var command *policy.ExecCommand
+		if c.exec != nil {
+			command = &c.exec.ExecCommand
+		}
+		var operation *policy.FileOperation
+		if c.files != nil {
+			operation = &c.files.FileOperation
+		}
+		var message *policy.EmailMessage
+		if c.email != nil {
+			message = &c.email.EmailMessage
+		}
+		var fetch *policy.FetchRequest
+		if c.fetch != nil {
+			fetch = &c.fetch.FetchRequest
+		}
+		var span trace.Span
+		c.Context, span, c.Decision = g.evaluateRequest(c.Context, c.Start, c.Identity, &policy.Request{
+			Tool:     c.Tool,
+			Action:   c.Action,
+			Method:   r.Method,
+			Resource: c.Resource,
+			Params:   c.Params,
+			BodySize: c.bodySize,
+			Malware:  c.malware,
+			GraphQL:  c.graphql,
+			SQL:      statement,
+			Exec:     command,
+			Files:    operation,
+			Email:    message,
+			Fetch:    fetch,
+		})
+		// The root span ends with the status the call was answered with
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		w = rec
+		defer func() { telemetry.EndRequest(span, rec.status) }()
+		w.Header().Set(decisionIDHeader, c.Decision.ID)
+		w.Header().Set(sessionIDHeader, c.Identity.SessionID)
+
+		if !c.Decision.Allowed {
+			g.writeDenial(w, c.Decision)
+			return
+		}
+		next(w, c)
+	}
+}
+
+// transformCall routes the call to a version of its tool and builds the
+// upstream request target
+func (g *Gateway) transformCall(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		r := c.Request
+
+		// Tools see the session even when the gateway generated it
+		r.Header.Set(sessionIDHeader, c.Identity.SessionID)
+
+		upstream, exists := g.tools.Get(c.Tool)
+		if !exists {
+			writeError(w, fmt.Sprintf("Unknown tool: %s", c.Tool), http.StatusBadRequest)
+			return
+		}
+
+		if upstream.Protocol == registry.ProtocolMCP {
+			writeError(w, fmt.Sprintf("Tool %s is an MCP server; connect to /mcp/%s", c.Tool, c.Tool), http.StatusBadRequest)
+			return
+		}
+		c.Upstream = upstream.Route(c.Identity.AgentID)
+
+		c.upgrade = websocket.IsWebSocketUpgrade(r)
+		if c.upgrade && !c.Upstream.WebSocket.Enabled {
+			writeError(w, fmt.Sprintf("Tool %s does not accept WebSocket connections", c.Tool), http.StatusBadRequest)
+			return
+		}
+
+		if !c.upgrade && !c.Upstream.AllowsMethod(r.Method) {
+			w.Header().Set("Allow", strings.Join(c.Upstream.Methods, ", "))
+			writeError(w, fmt.Sprintf("Method %s not allowed for tool %s", r.Method, c.Tool), http.StatusMethodNotAllowed)
+			return
+		}
+
+		// GraphQL queries go to the tool's URL as they were sent
+		c.target = c.Action
+		if c.graphql != nil {
+			c.target = ""
+		}
+		if c.Resource != "" {
+			c.target += "/" + (&url.URL{Path: c.Resource}).EscapedPath()
+		}
+		if r.URL.RawQuery != "" && c.graphql == nil {
+			c.target += "?" + r.URL.RawQuery
+		}
+		c.idempotent = c.Upstream.Retry.Idempotent(r.Method, c.Action, r.Header.Get("Idempotency-Key") != "")
+		next(w, c)
+	}
+}
+
+// forwardCall sends the call to its tool and writes the response, unless
+// it is made async or answered from the cache
+func (g *Gateway) forwardCall(w http.ResponseWriter, c *Call) {
+	r, ctx, upstream := c.Request, c.Context, c.Upstream
+	tool, action, decision, identity := c.Tool, c.Action, c.Decision, c.Identity
+
+	// Async calls are answered with a job right away and forwarded in the
+	// background, where they take their concurrency slot
+	if c.async {
+		if c.upgrade || c.multipart {
+			writeError(w, "mode=async is not supported for WebSockets or uploads", http.StatusBadRequest)
+			return
+		}
+		started := g.startJob(ctx, w, r, upstream, toolCall{
+			method:        r.Method,
+			action:        action,
+			target:        c.target,
+			body:          c.body,
+			idempotent:    c.idempotent,
+			agentID:       identity.AgentID,
+			rules:         decision.Response,
+			header:        agentHeaders(r.Header),
+			schemaVersion: identity.SchemaVersion,
+			files:         c.files,
+			filesRoot:     decision.FilesRoot,
+			fetch:         c.fetch,
+			fetchMaxBytes: decision.FetchMaxBytes,
+		})
+		if started && c.recorder != nil {
+			c.recorder.forwarded = true
+		}
+		return
+	}
+
+	// Repeated reads of cacheable actions are answered without calling the tool
+	var cacheKey string
+	if !c.upgrade && !c.multipart && upstream.Protocol == registry.ProtocolHTTP && upstream.Cache.Cacheable(action) {
+		cacheKey = strings.Join([]string{identity.AgentID, action, r.Method, c.Resource, telemetry.HashParams(c.Params)}, "\x00")
+		if cached, ok := upstream.Cache.Get(cacheKey); ok {
+			g.telemetry.LogCacheHit(ctx, tool, action, time.Since(cached.StoredAt).Milliseconds()).End()
+			for key, values := range cached.Header {
+				w.Header()[key] = values
+			}
+			w.Header().Set(decisionIDHeader, decision.ID)
+			w.Header().Set("X-Aegis-Cache", "hit")
+			w.WriteHeader(cached.Status)
+			w.Write(cached.Body)
+			return
+		}
+	}
+
+	// Wait for a concurrency slot so a slow tool can't absorb all capacity
+	release, err := upstream.Limiter.Acquire(r.Context())
+	if err != nil {
+		g.writeToolBusy(w, tool, err)
+		return
+	}
+	defer release()
+
+	// Fast-fail while the tool's circuit is open
+	done, retryAfter, ok := upstream.Allow()
+	if !ok {
+		g.writeCircuitOpen(w, tool, retryAfter)
+		return
+	}
+
+	if c.recorder != nil {
+		c.recorder.forwarded = true
+	}
+
+	forwardStart := time.Now()
+	if c.upgrade {
+		err := g.proxyWebSocket(w, r, upstream, wsSession{identity: identity, tool: tool, action: action, trace: traceContext(r.Header), rules: decision.Response})
+		done(err == nil)
+		status := http.StatusSwitchingProtocols
+		if err != nil {
+			status = 0
+		}
+		forwardSpan := g.telemetry.LogForwardedCall(ctx, tool, action, upstream.Target, status, time.Since(forwardStart))
+		forwardSpan.End()
+		return
+	}
+
+	// gRPC methods, SQL statements, commands, file operations, email and
+	// fetches are answered with JSON the gateway builds itself
+	if upstream.Protocol == registry.ProtocolGRPC || upstream.Protocol == registry.ProtocolSQL || upstream.Protocol == registry.ProtocolExec ||
+		upstream.Protocol == registry.ProtocolFiles || upstream.Protocol == registry.ProtocolEmail || upstream.Protocol == registry.ProtocolFetch {
+		if c.multipart {
+			done(true)
+			writeError(w, fmt.Sprintf("Tool %s does not accept multipart uploads", tool), http.StatusUnsupportedMediaType)
+			return
+		}
+		var out []byte
+		var err error
+		switch upstream.Protocol {
+		case registry.ProtocolSQL:
+			out, err = g.execSQL(ctx, upstream, c.sql)
+			done(err == nil || sqlQueryFailed(err))
+		case registry.ProtocolExec:
+			out, err = g.runExec(ctx, upstream, c.exec)
+			done(err == nil)
+		case registry.ProtocolFiles:
+			out, err = g.runFiles(ctx, upstream, c.files, decision.FilesRoot)
+			done(err == nil || isFileError(err))
+		case registry.ProtocolEmail:
+			out, err = g.sendEmail(ctx, upstream, c.email, identity.AgentID)
+			done(err == nil || emailRejected(err))
+		case registry.ProtocolFetch:
+			// Failing sites say nothing about the tool's health
+			out, err = g.runFetch(ctx, upstream, c.fetch, decision.FetchMaxBytes)
+			done(true)
+		default:
+			out, err = g.invokeGRPCTool(ctx, upstream, action, c.bodyBytes)
+			done(err == nil)
+		}
+		status := http.StatusOK
+		if err != nil {
+			status = 0
+		}
+		g.telemetry.LogForwardedCall(ctx, tool, action, upstream.Target, status, time.Since(forwardStart)).End()
+		if err != nil && sqlQueryFailed(err) {
+			writeError(w, fmt.Sprintf("Query failed: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil && emailRejected(err) {
+			writeError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if errors.Is(err, errPrivateAddress) {
+			writeError(w, fmt.Sprintf("Fetch refused: %v", err), http.StatusForbidden)
+			return
+		}
+		var fileErr *fileError
+		if errors.As(err, &fileErr) {
+			writeError(w, fileErr.message, fileErr.status)
+			return
+		}
+		if err != nil {
+			writeUpstreamError(w, http.StatusBadGateway, fmt.Sprintf("Failed to forward request: %v", err))
+			return
+		}
+		if decision.Response != nil {
+			buf := newResponseBuffer()
+			buf.header.Set("Content-Type", "application/json")
+			buf.body.Write(out)
+			g.writeInspected(ctx, w, identity.AgentID, tool, action, decision.Response, buf)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+		return
+	}
+
+	if cacheKey != "" {
+		cacheRecorder := &recordingWriter{ResponseWriter: w, status: http.StatusOK, limit: upstream.Cache.MaxBodyBytes(), forwarded: true}
+		w = cacheRecorder
+		defer func() {
+			if cacheRecorder.status == http.StatusOK && !cacheRecorder.overflow {
+				upstream.Cache.Put(cacheKey, &registry.CachedResponse{
+					Status:   cacheRecorder.status,
+					Header:   cacheRecorder.Header().Clone(),
+					Body:     cacheRecorder.body.Bytes(),
+					StoredAt: time.Now(),
+				})
+			}
+		}()
+	}
+
+	// Responses under response rules are buffered and inspected first
+	var out http.ResponseWriter = w
+	var buf *responseBuffer
+	if decision.Response != nil {
+		buf = newResponseBuffer()
+		out = buf
+	}
+	call := toolCall{method: r.Method, action: action, target: c.target, body: c.body, agentID: identity.AgentID, header: agentHeaders(r.Header)}
+	out = &deadLetterWriter{ResponseWriter: out, capture: func(status int) string {
+		return g.deadLetter(upstream, call, fmt.Errorf("tool %s returned %d", tool, status))
+	}}
+
+	status, err := g.forwardRequest(ctx, upstream, r, c.target, c.body, c.idempotent, out)
+	forwardLatency := time.Since(forwardStart)
+	done(err == nil && status < http.StatusInternalServerError)
+
+	if err != nil {
+		status = 0
+	}
+	forwardSpan := g.telemetry.LogForwardedCall(ctx, tool, action, upstream.Target, status, forwardLatency)
+	defer forwardSpan.End()
+
+	if err != nil {
+		// Calls abandoned by the agent aren't worth replaying
+		if r.Context().Err() == nil {
+			if id := g.deadLetter(upstream, call, err); id != "" {
+				w.Header().Set("X-Aegis-Dead-Letter-ID", id)
+			}
+		}
+		writeUpstreamError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to forward request: %v", err))
+		return
+	}
+	if buf != nil {
+		g.writeInspected(ctx, w, identity.AgentID, tool, action, decision.Response, buf)
+	}
+}
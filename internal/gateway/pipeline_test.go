@@ -0,0 +1,223 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	aegisv1 "aegis-gateway/api/aegis/v1"
+	"aegis-gateway/internal/config"
+)
+
+// The tool must get exactly the query parameters that were evaluated
+func TestQueryForwardedAsEvaluated(t *testing.T) {
+	var forwarded string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.URL.RawQuery
+		io.WriteString(w, `{}`)
+	}))
+	defer server.Close()
+
+	policyYAML := `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [list]
+        conditions:
+          forbid_values: {field: status, values: [archived]}
+`
+	g := newTestGateway(t, policyYAML, func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{
+			"payments": {URL: server.URL, Methods: []string{http.MethodGet}, Timeout: config.DefaultToolTimeout},
+		}
+	})
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantQuery  string
+	}{
+		{"status=open&limit=10", http.StatusOK, "limit=10&status=open"},
+		{"status=open;status=archived", http.StatusBadRequest, ""},
+		{"status=%zz", http.StatusBadRequest, ""},
+		{"status=archived", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		forwarded = ""
+		r := httptest.NewRequest(http.MethodGet, "/tools/payments/list?"+tt.query, nil)
+		r.Header.Set("X-Agent-ID", "finance-agent")
+		w := httptest.NewRecorder()
+		g.HandleRequest(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: got %d, want %d: %s", tt.query, w.Code, tt.wantStatus, strings.TrimSpace(w.Body.String()))
+			continue
+		}
+		if forwarded != tt.wantQuery {
+			t.Errorf("%s: forwarded %q, want %q", tt.query, forwarded, tt.wantQuery)
+		}
+	}
+}
+
+// A call must be decided the same way whichever front-end it arrives on, and
+// only allowed calls may reach the tool
+func TestFrontEndsEnforcePolicy(t *testing.T) {
+	policyYAML := `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create]
+        conditions:
+          max_amount: 100
+`
+	post := func(g *Gateway, handler http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Agent-ID", "finance-agent")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	frontEnds := []struct {
+		name string
+		call func(g *Gateway, action string, amount int)
+	}{
+		{"tools", func(g *Gateway, action string, amount int) {
+			post(g, g.HandleRequest, "/tools/payments/"+action, fmt.Sprintf(`{"amount":%d}`, amount))
+		}},
+		{"tool calls", func(g *Gateway, action string, amount int) {
+			arguments, _ := json.Marshal(fmt.Sprintf(`{"amount":%d}`, amount))
+			post(g, g.HandleToolCalls, "/v1/tool_calls", `{"execute":true,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"payments__`+action+`","arguments":`+string(arguments)+`}}]}`)
+		}},
+		{"MCP", func(g *Gateway, action string, amount int) {
+			post(g, g.HandleMCP, "/mcp", fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"payments__%s","arguments":{"amount":%d}}}`, action, amount))
+		}},
+		{"gRPC", func(g *Gateway, action string, amount int) {
+			params, _ := structpb.NewStruct(map[string]interface{}{"amount": float64(amount)})
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-agent-id", "finance-agent"))
+			(&grpcServer{g: g}).Invoke(ctx, &aegisv1.InvokeRequest{Tool: "payments", Action: action, Params: params})
+		}},
+	}
+	calls := []struct {
+		action    string
+		amount    int
+		forwarded bool
+	}{
+		{"create", 10, true},
+		{"create", 1000, false},
+		{"refund", 10, false},
+	}
+	for _, fe := range frontEnds {
+		t.Run(fe.name, func(t *testing.T) {
+			var received atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received.Add(1)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+			g := newTestGateway(t, policyYAML, func(cfg *config.Config) {
+				cfg.Tools = map[string]config.ToolConfig{
+					"payments": {URL: server.URL, Timeout: config.DefaultToolTimeout},
+				}
+			})
+
+			for _, c := range calls {
+				before := received.Load()
+				fe.call(g, c.action, c.amount)
+				if forwarded := received.Load() > before; forwarded != c.forwarded {
+					t.Errorf("%s of %d: forwarded %v, want %v", c.action, c.amount, forwarded, c.forwarded)
+				}
+			}
+		})
+	}
+}
+
+// A rule without resource_prefix must not let a path below the action
+// reach the tool, or folder_prefix could be bypassed by leaving out the
+// path param
+func TestResourcePathNeedsResourcePrefix(t *testing.T) {
+	var forwarded []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.URL.Path)
+		io.WriteString(w, `{}`)
+	}))
+	defer server.Close()
+
+	policyYAML, err := os.ReadFile("../../policies/hr-agent.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := newTestGateway(t, string(policyYAML), func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{
+			"files": {URL: server.URL, Timeout: config.DefaultToolTimeout},
+		}
+	})
+
+	tests := []struct {
+		path, body string
+		wantStatus int
+	}{
+		{"/tools/files/read", `{"path":"/hr-docs/handbook.pdf"}`, http.StatusOK},
+		{"/tools/files/read", `{"path":"/finance/salaries.csv"}`, http.StatusForbidden},
+		{"/tools/files/read/finance/salaries.csv", `{}`, http.StatusForbidden},
+		{"/tools/files/read/hr-docs/handbook.pdf", `{"path":"/hr-docs/handbook.pdf"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		r.Header.Set("X-Agent-ID", "hr-agent")
+		w := httptest.NewRecorder()
+		g.HandleRequest(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s %s: got %d, want %d: %s", tt.path, tt.body, w.Code, tt.wantStatus, strings.TrimSpace(w.Body.String()))
+		}
+	}
+	if len(forwarded) != 1 || forwarded[0] != "/read" {
+		t.Fatalf("forwarded %v, want only /read", forwarded)
+	}
+}
+
+// Bodies are read before the agent is authenticated, so every front-end
+// must stop reading at server.max_request_bytes
+func TestRequestBodyLimit(t *testing.T) {
+	g := newTestGateway(t, rateLimitedPolicy, func(cfg *config.Config) {
+		cfg.Server.MaxRequestBytes = 1024
+		cfg.Tools = map[string]config.ToolConfig{
+			"payments": {URL: "http://127.0.0.1:1", Timeout: config.DefaultToolTimeout},
+			"search":   {Protocol: "mcp", URL: "http://127.0.0.1:1", Timeout: config.DefaultToolTimeout},
+		}
+	})
+	large := `{"memo":"` + strings.Repeat("x", 2048) + `"}`
+
+	tests := []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/tools/payments/create", g.HandleRequest},
+		{"/v1/evaluate/batch", g.HandleEvaluateBatch},
+		{"/v1/simulate", g.HandleSimulate},
+		{"/mcp", g.HandleMCP},
+		{"/mcp/search", g.HandleMCPProxy},
+		{"/v1/tool_calls", g.HandleToolCalls},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(large))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		tt.handler(w, r)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: got %d, want 413: %s", tt.path, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+	}
+}
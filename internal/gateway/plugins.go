@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"aegis-gateway/internal/plugin"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// pluginsFor returns the plugins that apply to tool
+func (g *Gateway) pluginsFor(tool string) []*plugin.Plugin {
+	g.mu.RLock()
+	host := g.plugins
+	g.mu.RUnlock()
+	return host.For(tool)
+}
+
+// runRequestPlugins lets plugins change a call's params or veto it before
+// it is evaluated
+func (g *Gateway) runRequestPlugins(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		for _, p := range g.pluginsFor(c.Tool) {
+			if !p.HandlesRequests() {
+				continue
+			}
+			result, err := p.OnRequest(c.Context, plugin.RequestInput{
+				AgentID:   c.Identity.AgentID,
+				SessionID: c.Identity.SessionID,
+				RequestID: telemetry.RequestID(c.Context),
+				Tool:      c.Tool,
+				Action:    c.Action,
+				Method:    c.Request.Method,
+				Resource:  c.Resource,
+				Params:    c.Params,
+			})
+			changed := err == nil && result.Params != nil
+			if changed {
+				err = c.setParams(result.Params)
+			}
+			if err != nil {
+				if g.pluginFailed(w, p, err) {
+					return
+				}
+				continue
+			}
+			if changed && !g.reparse(w, c) {
+				return
+			}
+			if result.Veto != nil {
+				writePluginVeto(w, p, result.Veto, http.StatusForbidden)
+				return
+			}
+		}
+		next(w, c)
+	}
+}
+
+// setParams replaces the params of a JSON call and rebuilds what is
+// forwarded from them. Params that came from the query string are sent in
+// it again, the rest in the body. The params of a GraphQL call are its
+// variables, so they replace the variables of the request.
+func (c *Call) setParams(params map[string]interface{}) error {
+	if c.multipart {
+		return fmt.Errorf("params of multipart uploads can't be changed")
+	}
+	if c.graphql != nil {
+		var req graphQLRequest
+		if err := json.Unmarshal(c.bodyBytes, &req); err != nil {
+			return err
+		}
+		req.Variables = params
+		encoded, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		c.Params, c.bodyBytes, c.body, c.bodySize = params, encoded, jsonBody(encoded), len(encoded)
+		return nil
+	}
+
+	// parseCall already rejected query strings that don't parse
+	r := c.Request
+	query, _ := url.ParseQuery(r.URL.RawQuery)
+	body := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		if _, fromQuery := query[key]; !fromQuery {
+			body[key] = value
+			continue
+		}
+		values, err := queryValues(value)
+		if err != nil {
+			return fmt.Errorf("parameter %s: %w", key, err)
+		}
+		query[key] = values
+	}
+	for key := range query {
+		if _, kept := params[key]; !kept {
+			query.Del(key)
+		}
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	r.URL.RawQuery = query.Encode()
+	c.Params, c.bodyBytes, c.body, c.bodySize = params, encoded, jsonBody(encoded), len(encoded)
+	return nil
+}
+
+// queryValues encodes a param for the query string the way mergeQuery
+// decodes it, with lists as repeated keys
+func queryValues(value interface{}) ([]string, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		list = []interface{}{value}
+	}
+	values := make([]string, len(list))
+	for i, item := range list {
+		switch v := item.(type) {
+		case string:
+			values[i] = v
+		case float64:
+			values[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			values[i] = strconv.FormatBool(v)
+		case nil:
+		default:
+			return nil, fmt.Errorf("a %T can't be sent in the query string", item)
+		}
+	}
+	return values, nil
+}
+
+// reparse runs the protocol adapters of StageParse again after a plugin
+// changed the params, so the statement, command, operation, message or
+// request evaluated and sent is the one built from them. It reports false
+// when an adapter rejected the call and wrote the error.
+func (g *Gateway) reparse(w http.ResponseWriter, c *Call) bool {
+	parsed := false
+	g.protocolAdapters()(func(http.ResponseWriter, *Call) { parsed = true })(w, c)
+	return parsed
+}
+
+// runResponsePlugins buffers the response so plugins can change or veto it
+// before it is sent. WebSockets and async calls are not buffered.
+func (g *Gateway) runResponsePlugins(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		var plugins []*plugin.Plugin
+		for _, p := range g.pluginsFor(c.Tool) {
+			if p.HandlesResponses() {
+				plugins = append(plugins, p)
+			}
+		}
+		if len(plugins) == 0 || c.upgrade || c.async {
+			next(w, c)
+			return
+		}
+
+		buf := newResponseBuffer()
+		buf.header = w.Header().Clone()
+		next(buf, c)
+
+		modified := false
+		for _, p := range plugins {
+			headers := make(map[string]string, len(buf.header))
+			for key := range buf.header {
+				headers[key] = buf.header.Get(key)
+			}
+			result, err := p.OnResponse(c.Context, plugin.ResponseInput{
+				AgentID:   c.Identity.AgentID,
+				SessionID: c.Identity.SessionID,
+				RequestID: telemetry.RequestID(c.Context),
+				Tool:      c.Tool,
+				Action:    c.Action,
+				Status:    buf.status,
+				Headers:   headers,
+				Body:      buf.body.String(),
+			})
+			if err != nil {
+				if g.pluginFailed(w, p, err) {
+					return
+				}
+				continue
+			}
+			if result.Veto != nil {
+				writePluginVeto(w, p, result.Veto, http.StatusBadGateway)
+				return
+			}
+			if result.Status != 0 {
+				buf.status, modified = result.Status, true
+			}
+			if result.Body != nil {
+				buf.body.Reset()
+				buf.body.WriteString(*result.Body)
+				modified = true
+			}
+		}
+
+		if modified {
+			buf.header.Set("Content-Length", strconv.Itoa(buf.body.Len()))
+			buf.header.Set("X-Aegis-Response-Modified", "true")
+		}
+		buf.flush(w)
+	}
+}
+
+// pluginFailed logs a failed hook and, unless the plugin is fail_open,
+// rejects the call. It reports whether the call was rejected.
+func (g *Gateway) pluginFailed(w http.ResponseWriter, p *plugin.Plugin, err error) bool {
+	if p.FailOpen() {
+		logger.Warn("Plugin failed, continuing without it", "plugin", p.Name(), "error", err)
+		return false
+	}
+	logger.Error("Plugin failed", "plugin", p.Name(), "error", err)
+	writeProblem(w, newProblem(problemPluginError, http.StatusInternalServerError, fmt.Sprintf("Plugin %s failed", p.Name())))
+	return true
+}
+
+// writePluginVeto rejects a call a plugin vetoed
+func writePluginVeto(w http.ResponseWriter, p *plugin.Plugin, veto *plugin.Veto, status int) {
+	reason := veto.Reason
+	if reason == "" {
+		reason = fmt.Sprintf("Rejected by plugin %s", p.Name())
+	}
+	problem := newProblem(problemPluginVeto, status, reason)
+	problem.Code = veto.Code
+	writeProblem(w, problem)
+}
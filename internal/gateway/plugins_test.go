@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/registry"
+)
+
+// Params changed by a plugin must be what the tool is sent, not only what
+// policy evaluates
+func TestSetParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		tool      string
+		action    string
+		target    string
+		body      string
+		params    map[string]interface{}
+		wantQuery string
+		wantBody  string
+	}{
+		{"query and body", "api", "create", "/tools/api/create?id=1&tag=a&tag=b&drop=x", `{"amount":10}`,
+			map[string]interface{}{"id": "2", "tag": []interface{}{"c", 3.5, true}, "amount": 20.0},
+			"id=2&tag=c&tag=3.5&tag=true", `{"amount":20}`},
+		{"graphql variables", "github", "query", "/tools/github/query", `{"query":"query($id: ID) { node(id: $id) { id } }","variables":{"id":"1"}}`,
+			map[string]interface{}{"id": "2"},
+			"", `{"query":"query($id: ID) { node(id: $id) { id } }","variables":{"id":"2"}}`},
+		{"files path", "docs", "read", "/tools/docs/read/public/a.txt", ``,
+			map[string]interface{}{"path": "/secret/b.txt"},
+			"", `{"path":"/secret/b.txt"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t, `version: "1"`, func(cfg *config.Config) {
+				cfg.Tools = map[string]config.ToolConfig{
+					"api":    {URL: "http://127.0.0.1:1", Timeout: config.DefaultToolTimeout},
+					"github": {Protocol: registry.ProtocolGraphQL, URL: "http://127.0.0.1:1", Timeout: config.DefaultToolTimeout},
+					"docs":   {Protocol: registry.ProtocolFiles, Files: config.FilesToolConfig{Root: t.TempDir()}, Timeout: config.DefaultToolTimeout},
+				}
+			})
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			c := &Call{Request: r, Tool: tt.tool, Action: tt.action, bodyBytes: []byte(tt.body)}
+			if parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/tools/"), "/", 3); len(parts) == 3 {
+				c.Resource = parts[2]
+			}
+			w := httptest.NewRecorder()
+			parsed := false
+			chain(g.parseCall, g.protocolAdapters())(func(http.ResponseWriter, *Call) { parsed = true })(w, c)
+			if !parsed {
+				t.Fatalf("call was rejected: %d %s", w.Code, w.Body)
+			}
+
+			if err := c.setParams(tt.params); err != nil {
+				t.Fatal(err)
+			}
+			if !g.reparse(w, c) {
+				t.Fatalf("changed call was rejected: %d %s", w.Code, w.Body)
+			}
+			if got := r.URL.RawQuery; got != tt.wantQuery {
+				t.Errorf("query: got %q, want %q", got, tt.wantQuery)
+			}
+			if got := string(c.bodyBytes); got != tt.wantBody {
+				t.Errorf("body: got %s, want %s", got, tt.wantBody)
+			}
+			switch {
+			case c.graphql != nil:
+				var req graphQLRequest
+				json.Unmarshal(c.bodyBytes, &req)
+				if req.Variables["id"] != "2" {
+					t.Errorf("graphql variables: got %v", req.Variables)
+				}
+			case c.files != nil:
+				if c.files.Path != "/secret/b.txt" {
+					t.Errorf("files operation path: got %s", c.files.Path)
+				}
+			}
+		})
+	}
+}
+
+func TestSetParamsRejectsObjectsInQuery(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/tools/api/list?filter=a", nil)
+	c := &Call{Request: r}
+	err := c.setParams(map[string]interface{}{"filter": map[string]interface{}{"a": 1.0}})
+	if err == nil {
+		t.Fatal("object was sent in the query string")
+	}
+}
@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// problemTypePrefix starts the type URI of every problem the gateway reports
+const problemTypePrefix = "urn:aegis-gateway:problem:"
+
+// Problem types with a meaning beyond their status code. Other errors are
+// typed by status, e.g. urn:aegis-gateway:problem:bad-request.
+const (
+	problemPolicyViolation     = "policy-violation"
+	problemResponseViolation   = "response-violation"
+	problemUpstreamUnavailable = "upstream-unavailable"
+	problemUpstreamError       = "upstream-error"
+	problemIdempotencyConflict = "idempotency-conflict"
+	problemPluginVeto          = "plugin-veto"
+	problemPluginError         = "plugin-error"
+)
+
+var problemTitles = map[string]string{
+	problemPolicyViolation:     "Policy violation",
+	problemResponseViolation:   "Response violation",
+	problemUpstreamUnavailable: "Tool unavailable",
+	problemUpstreamError:       "Tool call failed",
+	problemIdempotencyConflict: "Idempotency conflict",
+	problemPluginVeto:          "Rejected by plugin",
+	problemPluginError:         "Plugin failed",
+}
+
+// problem is an RFC 7807 problem details document. code, decision_id,
+// request_id and retry_after are extension members.
+type problem struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	Code       string `json:"code,omitempty"`
+	DecisionID string `json:"decision_id,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// newProblem describes an error of the given kind, or of its status alone
+// when kind is ""
+func newProblem(kind string, status int, detail string) *problem {
+	title := problemTitles[kind]
+	if kind == "" {
+		title = http.StatusText(status)
+		kind = strings.ReplaceAll(strings.ToLower(title), " ", "-")
+	}
+	return &problem{Type: problemTypePrefix + kind, Title: title, Status: status, Detail: detail}
+}
+
+// writeProblem sends p as application/problem+json. The request ID, and
+// the decision ID once the call has been evaluated, are filled in from the
+// response headers.
+func writeProblem(w http.ResponseWriter, p *problem) {
+	if p.DecisionID == "" {
+		p.DecisionID = w.Header().Get(decisionIDHeader)
+	}
+	p.RequestID = w.Header().Get(requestIDHeader)
+	if p.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(p.RetryAfter))
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeError is http.Error for the gateway: it sends message as the detail
+// of a problem typed by status
+func writeError(w http.ResponseWriter, message string, status int) {
+	writeProblem(w, newProblem("", status, message))
+}
@@ -0,0 +1,291 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// forwardRequest proxies an allowed call to its tool and returns the
+// upstream status code that was passed through. in is the agent's request,
+// or a bare request from outboundRequest for calls made without one; its
+// end-to-end headers are forwarded with X-Forwarded-* added, while
+// hop-by-hop headers and the agent's credentials are dropped. target is the
+// action, optionally followed by an escaped resource path.
+//
+// Transport failures fail over to the tool's next instance, every known
+// instance is tried at least once when connecting fails, and retryable
+// upstream statuses are retried with backoff when the call is idempotent.
+// Streaming responses are relayed as they arrive; for them the tool timeout
+// only bounds the wait for the response headers.
+func (g *Gateway) forwardRequest(ctx context.Context, tool *registry.Tool, in *http.Request, target string, body requestBody, idempotent bool, w http.ResponseWriter) (int, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var deadline *time.Timer
+	if tool.Timeout > 0 {
+		deadline = time.AfterFunc(tool.Timeout, func() {
+			cancel(fmt.Errorf("tool %s did not respond within %s: %w", tool.Name, tool.Timeout, context.DeadlineExceeded))
+		})
+		defer deadline.Stop()
+	}
+
+	credHeader, credValue, err := g.toolCredentials(tool)
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := g.upstreamClient(tool)
+	if err != nil {
+		return 0, err
+	}
+
+	instances := tool.Instances()
+	if len(instances) == 0 {
+		return 0, fmt.Errorf("no instances available for tool %s", tool.Name)
+	}
+
+	transport := &upstreamTransport{
+		ctx:        ctx,
+		client:     client,
+		tool:       tool,
+		instances:  instances,
+		target:     target,
+		body:       body,
+		credHeader: credHeader,
+		credValue:  credValue,
+		idempotent: idempotent,
+		deadline:   deadline,
+	}
+	var status int
+	var proxyErr error
+	proxy := &httputil.ReverseProxy{
+		Rewrite:   rewriteToolRequest,
+		Transport: transport,
+		ModifyResponse: func(resp *http.Response) error {
+			status = resp.StatusCode
+			return nil
+		},
+		// Nothing has been written yet; callers report the error in their
+		// own format
+		ErrorHandler: func(_ http.ResponseWriter, _ *http.Request, err error) {
+			proxyErr = err
+		},
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+
+	out := in.Clone(proxyContext{ctx})
+	out.Body, out.ContentLength = nil, 0
+	proxy.ServeHTTP(w, out)
+
+	if proxyErr != nil {
+		return 0, proxyErr
+	}
+	return status, transport.readErr()
+}
+
+// outboundRequest is the request forwardRequest proxies for calls that have
+// no agent connection, such as async jobs and dead-letter replays. header
+// holds the agent's headers kept by agentHeaders, if any.
+func outboundRequest(method string, header http.Header) *http.Request {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Request{Method: method, URL: &url.URL{}, Header: header.Clone()}
+}
+
+// hopHeaders are the hop-by-hop headers ReverseProxy drops
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// agentHeaders returns the headers of an agent's request that forwardRequest
+// would pass to the tool, such as Idempotency-Key, so calls sent once the
+// request is gone carry them too. The agent's credentials, X-Aegis-* and
+// hop-by-hop headers are left out, as they are when proxying, so they are
+// never stored with a job or dead letter.
+func agentHeaders(in http.Header) http.Header {
+	out := in.Clone()
+	for _, value := range in.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			out.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		out.Del(name)
+	}
+	for key := range out {
+		if strings.HasPrefix(key, "X-Aegis-") {
+			out.Del(key)
+		}
+	}
+	out.Del("Authorization")
+	out.Del("Accept-Encoding")
+	out.Del("Content-Length")
+	return out
+}
+
+// rewriteToolRequest prepares the agent's headers for the tool. The tool
+// gets its own credentials from the transport, bodies are requested
+// uncompressed so response rules and the cache see plain content, and the
+// trace context names the gateway's span as the parent. Async jobs have no
+// agent headers, so the request ID is set from the context.
+func rewriteToolRequest(pr *httputil.ProxyRequest) {
+	pr.SetXForwarded()
+	for key := range pr.Out.Header {
+		if strings.HasPrefix(key, "X-Aegis-") {
+			pr.Out.Header.Del(key)
+		}
+	}
+	pr.Out.Header.Del("Authorization")
+	pr.Out.Header.Del("Accept-Encoding")
+	telemetry.Inject(pr.Out.Context(), propagation.HeaderCarrier(pr.Out.Header))
+	if id := telemetry.RequestID(pr.Out.Context()); id != "" {
+		pr.Out.Header.Set(requestIDHeader, id)
+	}
+}
+
+// proxyContext hides the inbound server from ReverseProxy, which otherwise
+// panics with http.ErrAbortHandler when relaying a body fails. forwardRequest
+// reports those failures instead, and async jobs have no handler to abort.
+type proxyContext struct {
+	context.Context
+}
+
+func (c proxyContext) Value(key interface{}) interface{} {
+	if key == http.ServerContextKey {
+		return nil
+	}
+	return c.Context.Value(key)
+}
+
+// upstreamTransport sends a proxied call to the tool's instances, retrying
+// and failing over as configured. The body is reopened for every attempt.
+type upstreamTransport struct {
+	ctx        context.Context
+	client     *http.Client
+	tool       *registry.Tool
+	instances  []string
+	target     string
+	body       requestBody
+	credHeader string
+	credValue  string
+	idempotent bool
+	deadline   *time.Timer
+
+	mu      sync.Mutex
+	bodyErr error
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *upstreamTransport) RoundTrip(out *http.Request) (*http.Response, error) {
+	tool := t.tool
+	attempts := max(tool.Retry.Attempts+1, len(t.instances))
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(tool.Retry.Delay(attempt)):
+			case <-t.ctx.Done():
+				return nil, context.Cause(t.ctx)
+			}
+		}
+
+		instance := t.instances[attempt%len(t.instances)]
+		reqBody, contentType := t.body.open()
+		req, err := http.NewRequestWithContext(out.Context(), out.Method, registry.ActionURL(instance, t.target), reqBody)
+		if err != nil {
+			if c, ok := reqBody.(io.Closer); ok {
+				c.Close()
+			}
+			return nil, err
+		}
+
+		req.Header = out.Header.Clone()
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if t.credHeader != "" {
+			req.Header.Set(t.credHeader, t.credValue)
+		}
+
+		last := attempt == attempts-1
+		release := tool.Acquire(instance)
+		resp, err := t.client.Do(req)
+		if err != nil {
+			release(false)
+			if t.ctx.Err() != nil {
+				return nil, context.Cause(t.ctx)
+			}
+			// Calls that never reached the tool are always safe to retry
+			if last || !(t.idempotent || isDialError(err)) {
+				return nil, err
+			}
+			continue
+		}
+
+		if !last && t.idempotent && tool.Retry.RetryableStatus(resp.StatusCode) {
+			release(false)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			continue
+		}
+
+		if isStreaming(resp) && t.deadline != nil {
+			// A stream may legitimately outlive the tool timeout once it has started
+			t.deadline.Stop()
+		}
+
+		// Keep the instance counted as busy until the body is relayed
+		resp.Body = &upstreamBody{ReadCloser: resp.Body, transport: t, release: release, healthy: resp.StatusCode < http.StatusInternalServerError}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("no attempts made for tool %s", tool.Name)
+}
+
+// readErr returns the error that interrupted relaying the response body
+func (t *upstreamTransport) readErr() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.bodyErr
+}
+
+// upstreamBody releases its instance once the response has been relayed
+// and records read failures, which ReverseProxy doesn't return
+type upstreamBody struct {
+	io.ReadCloser
+	transport *upstreamTransport
+	release   func(bool)
+	healthy   bool
+	once      sync.Once
+}
+
+func (b *upstreamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		b.transport.mu.Lock()
+		if b.transport.bodyErr == nil {
+			b.transport.bodyErr = err
+		}
+		b.transport.mu.Unlock()
+	}
+	return n, err
+}
+
+func (b *upstreamBody) Close() error {
+	b.once.Do(func() { b.release(b.healthy) })
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,113 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/anomaly"
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/quarantine"
+)
+
+// openQuarantine opens the quarantine store. An unreadable store is left
+// alone, so it isn't overwritten, and quarantines are kept in memory only.
+func openQuarantine(cfg config.QuarantineConfig) *quarantine.Quarantine {
+	q, err := quarantine.New(cfg)
+	if err == nil {
+		return q
+	}
+	logger.Error("Quarantine store unreadable; quarantines are kept in memory only", "store", cfg.Store, "error", err)
+	cfg.Store = ""
+	q, _ = quarantine.New(cfg)
+	return q
+}
+
+// quarantinePeerPrefix marks quarantine entries for a caller's address
+const quarantinePeerPrefix = "peer:"
+
+// quarantineSubject is what an identity's denials and tripwires count
+// against: the agent when its ID was verified, and otherwise the address
+// the call came from, so a caller that only names an agent in X-Agent-ID
+// can't get that agent quarantined. It is "" when neither is known.
+func quarantineSubject(identity *Identity) string {
+	if identity.Verified() {
+		return identity.AgentID
+	}
+	if identity.Peer == "" {
+		return ""
+	}
+	return quarantinePeerPrefix + identity.Peer
+}
+
+// checkQuarantine denies the calls of a quarantined agent, or from a
+// quarantined address, before they are evaluated, so they don't count
+// against the agent's limits
+func (g *Gateway) checkQuarantine(identity *Identity, evaluate func(*policy.Request) policy.Decision) func(*policy.Request) policy.Decision {
+	return func(req *policy.Request) policy.Decision {
+		if _, ok := g.quarantine.Get(req.AgentID); ok {
+			return policy.Decision{
+				Code:   quarantine.CodeAgentQuarantined,
+				Reason: fmt.Sprintf("Agent %s is quarantined until an operator releases it", req.AgentID),
+			}
+		}
+		if subject := quarantineSubject(identity); subject != req.AgentID && subject != "" {
+			if _, ok := g.quarantine.Get(subject); ok {
+				return policy.Decision{
+					Code:   quarantine.CodeAgentQuarantined,
+					Reason: fmt.Sprintf("Calls from %s are quarantined until an operator releases them", identity.Peer),
+				}
+			}
+		}
+		return evaluate(req)
+	}
+}
+
+// countDenial counts a denied call towards quarantining its subject, see
+// quarantineSubject. Denials by the gateway's own holds, and policy errors,
+// which aren't the agent's doing, don't count.
+func (g *Gateway) countDenial(identity *Identity, decision policy.Decision) {
+	switch {
+	case decision.Allowed:
+		return
+	case decision.Code == quarantine.CodeAgentQuarantined, decision.Code == anomaly.CodeApprovalRequired,
+		decision.Code == policy.CodePolicyError, decision.Code == policy.CodeNoPolicies:
+		return
+	}
+	subject := quarantineSubject(identity)
+	if subject == "" {
+		return
+	}
+	if _, err := g.quarantine.Denied(subject, decision.Code, time.Now()); err != nil {
+		logger.Error("Failed to persist quarantine", "agent_id", subject, "error", err)
+	}
+}
+
+// HandleAdminQuarantine serves GET /admin/quarantine (the quarantined
+// agents) and POST /admin/quarantine/:agent/release (release an agent)
+func (g *Gateway) HandleAdminQuarantine(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/quarantine"), "/")
+	agentID, release := strings.CutSuffix(path, "/release")
+
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"quarantined": g.quarantine.List()})
+	case r.Method == http.MethodPost && release && agentID != "" && !strings.Contains(agentID, "/"):
+		released, err := g.quarantine.Release(agentID)
+		if !released {
+			writeError(w, fmt.Sprintf("Agent %s is not quarantined", agentID), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			// Released here, but quarantined again after a restart
+			logger.Error("Failed to persist quarantine", "agent_id", agentID, "error", err)
+		}
+		logger.Info("Admin released quarantined agent", "agent_id", agentID)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"agent_id": agentID, "released": true})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, "Expected GET /admin/quarantine or POST /admin/quarantine/:agent/release", http.StatusMethodNotAllowed)
+	}
+}
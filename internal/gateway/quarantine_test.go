@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/quarantine"
+)
+
+// A caller that only names an agent must not get that agent quarantined
+func TestQuarantineSubject(t *testing.T) {
+	policyYAML := `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create]
+`
+	tests := []struct {
+		name           string
+		attacker       *Identity
+		wantQuarantine string
+	}{
+		{"header identity", &Identity{AgentID: "finance-agent", Source: IdentitySourceHeader, Peer: "10.0.0.1"}, "peer:10.0.0.1"},
+		{"verified identity", &Identity{AgentID: "finance-agent", Source: IdentitySourceAPIKey, Peer: "10.0.0.1"}, "finance-agent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t, policyYAML, func(cfg *config.Config) {
+				cfg.Quarantine = config.QuarantineConfig{Denials: 2, Window: time.Minute}
+			})
+			call := func(identity *Identity, action string) string {
+				_, span, decision := g.evaluate(context.Background(), time.Now(), identity, "payments", action, nil, 0)
+				span.End()
+				return decision.Code
+			}
+			for i := 0; i < 2; i++ {
+				call(tt.attacker, "refund")
+			}
+			if _, ok := g.quarantine.Get(tt.wantQuarantine); !ok {
+				t.Fatalf("%s was not quarantined: %+v", tt.wantQuarantine, g.quarantine.List())
+			}
+			if code := call(tt.attacker, "create"); code != quarantine.CodeAgentQuarantined {
+				t.Fatalf("quarantined caller got %q", code)
+			}
+
+			other := &Identity{AgentID: "finance-agent", Source: IdentitySourceHeader, Peer: "10.0.0.2"}
+			code := call(other, "create")
+			if tt.wantQuarantine == "finance-agent" && code != quarantine.CodeAgentQuarantined {
+				t.Fatalf("quarantined agent got %q from another address", code)
+			}
+			if tt.wantQuarantine != "finance-agent" && code != "" {
+				t.Fatalf("agent got %q after another caller was quarantined", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/internal/report"
+)
+
+// reportSender is the agent ID reports are sent as in the email audit log
+const reportSender = "aegis-reports"
+
+// mailReport sends a report through an email tool. The gateway sends it
+// itself, so it isn't evaluated against policy, but it is recorded in the
+// email audit log like any other message.
+func (g *Gateway) mailReport(ctx context.Context, toolName string, to []string, subject, text string, files []report.File) error {
+	tool, ok := g.tools.Get(toolName)
+	if !ok || tool.Protocol != registry.ProtocolEmail {
+		return fmt.Errorf("%s is not an email tool", toolName)
+	}
+	req := emailRequest{To: to, Subject: subject, Text: text}
+	for _, f := range files {
+		req.Attachments = append(req.Attachments, emailAttachmentRequest{
+			Filename: f.Name,
+			Content:  base64.StdEncoding.EncodeToString(f.Data),
+		})
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	msg, err := parseEmailMessage(tool, body)
+	if err != nil {
+		return err
+	}
+	_, err = g.sendEmail(ctx, tool, msg, reportSender)
+	return err
+}
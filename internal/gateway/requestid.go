@@ -0,0 +1,38 @@
+package gateway
+
+import (
+	"net/http"
+)
+
+// requestIDHeader identifies one call across agent, gateway and tool logs
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds caller-supplied request IDs
+const maxRequestIDLength = 128
+
+// withRequestID makes sure every request has an X-Request-ID. A valid ID
+// sent by the caller is kept; otherwise one is generated. The ID is echoed
+// in the response and stays on the request, so it is forwarded to the tool.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := validRequestID(r.Header.Get(requestIDHeader))
+		r.Header.Set(requestIDHeader, id)
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID returns id if it is a usable request ID, or a new one.
+// Unlike session IDs, a bad request ID is replaced rather than rejected,
+// since it only serves diagnostics.
+func validRequestID(id string) string {
+	if id == "" || len(id) > maxRequestIDLength {
+		return newDecisionID()
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return newDecisionID()
+		}
+	}
+	return id
+}
@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// Tools tag responses with data classifications in this header, e.g.
+// "X-Aegis-Classification: internal, pii"
+const classificationHeader = "X-Aegis-Classification"
+
+// responseViolation is returned when response rules block a tool's answer
+type responseViolation struct {
+	code   string
+	reason string
+}
+
+func (v *responseViolation) Error() string {
+	return v.reason
+}
+
+// body is the problem describing the violation
+func (v *responseViolation) body() *problem {
+	p := newProblem(problemResponseViolation, http.StatusBadGateway, v.reason)
+	p.Code = v.code
+	return p
+}
+
+// inspectResponse applies a rule's response constraints to a buffered tool
+// response and logs every finding. It returns the body to deliver, or the
+// violation that blocked it.
+func (g *Gateway) inspectResponse(ctx context.Context, agentID, tool, action string, rules *policy.ResponseRules, header http.Header, body []byte) ([]byte, *responseViolation) {
+	var classifications []string
+	for _, tag := range strings.Split(header.Get(classificationHeader), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			classifications = append(classifications, tag)
+		}
+	}
+
+	g.mu.RLock()
+	redactor := g.redactor
+	g.mu.RUnlock()
+
+	result := rules.Inspect(body, classifications, redactor)
+	for _, f := range result.Findings {
+		g.telemetry.LogResponseCheck(ctx, telemetry.ResponseCheck{
+			AgentID:    agentID,
+			Tool:       tool,
+			Action:     action,
+			Code:       f.Code,
+			Reason:     f.Reason,
+			Outcome:    f.Action,
+			Redactions: f.Redactions,
+		})
+	}
+
+	if result.Blocked {
+		last := result.Findings[len(result.Findings)-1]
+		return nil, &responseViolation{code: last.Code, reason: last.Reason}
+	}
+	return result.Body, nil
+}
+
+// writeInspected delivers a buffered response once it passes the rule's
+// response constraints. Modified responses are flagged with
+// X-Aegis-Response-Modified.
+func (g *Gateway) writeInspected(ctx context.Context, w http.ResponseWriter, agentID, tool, action string, rules *policy.ResponseRules, buf *responseBuffer) {
+	original := buf.body.Bytes()
+	body, violation := g.inspectResponse(ctx, agentID, tool, action, rules, buf.header, original)
+	if violation != nil {
+		writeProblem(w, violation.body())
+		return
+	}
+
+	for key, values := range buf.header {
+		if key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	if len(body) != len(original) {
+		w.Header().Set("X-Aegis-Response-Modified", "true")
+	}
+	w.WriteHeader(buf.status)
+	w.Write(body)
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/scan"
+)
+
+// malwareHeader marks a file part forwarded under malware: tag with the
+// threat the scanner found in it
+const malwareHeader = "X-Aegis-Malware"
+
+// newScanner returns the scanner for cfg, or nil if scanning is disabled.
+// Config validation rejects bad scanner settings; should one get through,
+// uploads fail closed unless fail_open is set.
+func newScanner(cfg config.UploadScanConfig) scan.Scanner {
+	s, err := scan.New(cfg)
+	if err != nil {
+		logger.Error("Malware scanner disabled", "error", err)
+		return failingScanner{err}
+	}
+	return s
+}
+
+// failingScanner fails every scan, for a scanner that couldn't be set up
+type failingScanner struct {
+	err error
+}
+
+func (s failingScanner) Scan(context.Context, string, io.Reader) (scan.Verdict, error) {
+	return scan.Verdict{}, s.err
+}
+
+// SetScanner replaces the malware scanner built from uploads.scan, e.g. with
+// one for a different engine. It is replaced again if uploads.scan changes
+// on reload.
+func (g *Gateway) SetScanner(s scan.Scanner) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.scanner = s
+}
+
+// scanUpload scans each file of an upload and records the threats found.
+// If the scanner fails the upload is rejected, or let through unscanned
+// with fail_open.
+func (g *Gateway) scanUpload(ctx context.Context, up *upload) *uploadError {
+	g.mu.RLock()
+	scanner, failOpen := g.scanner, g.config.Uploads.Scan.FailOpen
+	g.mu.RUnlock()
+	if scanner == nil {
+		return nil
+	}
+
+	for _, p := range up.parts {
+		if p.file == nil {
+			continue
+		}
+		verdict, err := scanFile(ctx, scanner, p.file)
+		if err != nil {
+			if failOpen {
+				logger.Warn("Malware scan failed, forwarding unscanned", "filename", p.file.filename, "error", err)
+				continue
+			}
+			logger.Error("Malware scan failed", "error", err)
+			return &uploadError{http.StatusServiceUnavailable, fmt.Sprintf("Failed to scan file %s for malware", p.file.filename)}
+		}
+		if verdict.Infected {
+			p.file.threat = verdict.Threat
+			if p.file.threat == "" {
+				p.file.threat = "unknown"
+			}
+		}
+	}
+	return nil
+}
+
+// scanFile scans a spooled file
+func scanFile(ctx context.Context, scanner scan.Scanner, file *uploadFile) (scan.Verdict, error) {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return scan.Verdict{}, err
+	}
+	defer f.Close()
+	return scanner.Scan(ctx, file.filename, f)
+}
+
+// threats lists the malware found in the upload for policy, e.g.
+// "Eicar-Test-Signature in document (invoice.pdf)"
+func (u *upload) threats() []string {
+	var threats []string
+	for _, p := range u.parts {
+		if p.file != nil && p.file.threat != "" {
+			threats = append(threats, fmt.Sprintf("%s in %s (%s)", p.file.threat, p.name, p.file.filename))
+		}
+	}
+	return threats
+}
@@ -0,0 +1,215 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/internal/schema"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// schemaVersionHeader names the schema version an agent builds its calls
+// against
+const schemaVersionHeader = "X-Aegis-Schema-Version"
+
+// schemaCode is the decision code of calls and responses that don't match
+// the tool's schema
+const schemaCode = "SCHEMA_VIOLATION"
+
+// schemaVersion returns the named version of a tool's schemas, or nil if
+// the tool has none. ok is false if the tool doesn't have the version.
+func schemaVersion(tool *registry.Tool, version string) (v *schema.Version, ok bool) {
+	if tool.Schemas == nil {
+		return nil, true
+	}
+	return tool.Schemas.Version(version)
+}
+
+// unknownSchemaVersion describes a version the tool doesn't have
+func unknownSchemaVersion(tool *registry.Tool, version string) string {
+	return fmt.Sprintf("Tool %s has no schema version %s (versions: %s)", tool.Name, version, strings.Join(tool.Schemas.Versions(), ", "))
+}
+
+// checkSchema validates the params of req against the request schema of
+// its action before evaluate runs. A mismatch denies the call without
+// evaluating it, so it doesn't use up rate limits or budgets.
+func (g *Gateway) checkSchema(version string, evaluate func(*policy.Request) policy.Decision) func(*policy.Request) policy.Decision {
+	return func(req *policy.Request) policy.Decision {
+		tool, ok := g.tools.Get(req.Tool)
+		if !ok {
+			return evaluate(req)
+		}
+		v, ok := schemaVersion(tool, version)
+		if !ok {
+			return policy.Decision{Code: schemaCode, Reason: unknownSchemaVersion(tool, version)}
+		}
+		if v == nil {
+			return evaluate(req)
+		}
+		if a := v.Action(req.Action); a != nil && a.Request != nil {
+			if err := a.Request.Validate(req.Params); err != nil {
+				return policy.Decision{
+					Code:   schemaCode,
+					Reason: fmt.Sprintf("Params don't match schema version %s of %s %s: %v", v.Name, req.Tool, req.Action, err),
+				}
+			}
+		}
+		return evaluate(req)
+	}
+}
+
+// checkResponse validates a successful response against the response
+// schema of the action, logging a mismatch as a response check. Responses
+// of actions without a response schema aren't checked.
+func (g *Gateway) checkResponse(ctx context.Context, tool *registry.Tool, agentID, action, version string, buf *responseBuffer) *responseViolation {
+	if buf.status < 200 || buf.status > 299 {
+		return nil
+	}
+	v, _ := schemaVersion(tool, version)
+	if v == nil {
+		return nil
+	}
+	a := v.Action(action)
+	if a == nil || a.Response == nil {
+		return nil
+	}
+
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(buf.body.Bytes()))
+	decoder.UseNumber()
+	err := decoder.Decode(&doc)
+	if err == nil {
+		err = a.Response.Validate(doc)
+	} else {
+		err = fmt.Errorf("not JSON")
+	}
+	if err == nil {
+		return nil
+	}
+
+	reason := fmt.Sprintf("Response doesn't match schema version %s of %s %s: %v", v.Name, tool.Name, action, err)
+	g.telemetry.LogResponseCheck(ctx, telemetry.ResponseCheck{
+		AgentID: agentID,
+		Tool:    tool.Name,
+		Action:  action,
+		Code:    schemaCode,
+		Reason:  reason,
+		Outcome: "block",
+	})
+	return &responseViolation{code: schemaCode, reason: reason}
+}
+
+// validateResponses buffers responses of actions with a response schema
+// and replaces those that don't match it with a violation. WebSockets and
+// async calls are not buffered here; async jobs are checked when they run.
+func (g *Gateway) validateResponses(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		v, _ := schemaVersion(c.Upstream, c.Identity.SchemaVersion)
+		if v == nil || c.upgrade || c.async {
+			next(w, c)
+			return
+		}
+		if a := v.Action(c.Action); a == nil || a.Response == nil {
+			next(w, c)
+			return
+		}
+
+		buf := newResponseBuffer()
+		buf.header = w.Header().Clone()
+		next(buf, c)
+
+		if violation := g.checkResponse(c.Context, c.Upstream, c.Identity.AgentID, c.Action, c.Identity.SchemaVersion, buf); violation != nil {
+			writeProblem(w, violation.body())
+			return
+		}
+		buf.flush(w)
+	}
+}
+
+// toolSchema is the body of GET /v1/tools/:tool/schema
+type toolSchema struct {
+	Tool     string                  `json:"tool"`
+	Version  string                  `json:"version"`
+	Current  string                  `json:"current"`
+	Versions []string                `json:"versions"`
+	Actions  map[string]actionSchema `json:"actions"`
+}
+
+// actionSchema holds the JSON Schemas of an action
+type actionSchema struct {
+	Request  interface{} `json:"request,omitempty"`
+	Response interface{} `json:"response,omitempty"`
+}
+
+// HandleToolSchema serves GET /v1/tools/:tool/schema with the request and
+// response schemas of the actions the agent may call, in the version named
+// by ?version= or X-Aegis-Schema-Version (default the current one)
+func (g *Gateway) HandleToolSchema(w http.ResponseWriter, r *http.Request) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/tools/"), "/")
+	if name == "" || rest != "schema" {
+		writeError(w, "Invalid path. Expected: /v1/tools/:tool/schema", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	identity, authErr := g.resolveIdentity(r, nil)
+	if authErr != nil {
+		writeAuthError(w, r, authErr)
+		return
+	}
+
+	// Tools the agent can't call are reported as unknown
+	allowed := g.policyEngine.AllowedActions(identity.AgentID, identity.Groups)[name]
+	tool, ok := g.tools.Get(name)
+	if !ok || len(allowed) == 0 {
+		writeError(w, fmt.Sprintf("Unknown tool: %s", name), http.StatusNotFound)
+		return
+	}
+	if tool.Schemas == nil {
+		writeError(w, fmt.Sprintf("Tool %s has no schema", name), http.StatusNotFound)
+		return
+	}
+
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		version = identity.SchemaVersion
+	}
+	v, ok := schemaVersion(tool, version)
+	if !ok {
+		writeError(w, unknownSchemaVersion(tool, version), http.StatusNotFound)
+		return
+	}
+
+	doc := toolSchema{
+		Tool:     name,
+		Version:  v.Name,
+		Current:  tool.Schemas.Current(),
+		Versions: tool.Schemas.Versions(),
+		Actions:  make(map[string]actionSchema),
+	}
+	for _, action := range allowed {
+		a := v.Action(action)
+		if a == nil {
+			continue
+		}
+		var s actionSchema
+		if a.Request != nil {
+			s.Request = a.Request.Document()
+		}
+		if a.Response != nil {
+			s.Response = a.Response.Document()
+		}
+		doc.Actions[action] = s
+	}
+	writeJSON(w, http.StatusOK, doc)
+}
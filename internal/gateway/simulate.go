@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"aegis-gateway/internal/policy"
+)
+
+// simulateRequest is the body of POST /v1/simulate. Method, resource, claims
+// and groups are optional and stand in for what the real call would carry.
+type simulateRequest struct {
+	AgentID  string                 `json:"agent_id"`
+	Tool     string                 `json:"tool"`
+	Action   string                 `json:"action"`
+	Params   map[string]interface{} `json:"params"`
+	Method   string                 `json:"method,omitempty"`
+	Resource string                 `json:"resource,omitempty"`
+	Claims   map[string]interface{} `json:"claims,omitempty"`
+	Groups   []string               `json:"groups,omitempty"`
+}
+
+// simulateResponse is the decision for a simulated call and how it was reached
+type simulateResponse struct {
+	Allowed    bool   `json:"allowed"`
+	Code       string `json:"code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Rollout    string `json:"rollout,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+	Fallback   string `json:"fallback,omitempty"`
+
+	Rule             *policy.ExportedRule      `json:"rule,omitempty"`
+	FailedConditions []policy.ConditionFailure `json:"failed_conditions"`
+}
+
+// HandleSimulate serves POST /v1/simulate. It evaluates a call as the given
+// agent and explains the decision without forwarding anything. Simulations
+// are not written to the audit log and don't consume rate limits or budgets.
+func (g *Gateway) HandleSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, ok := g.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	var req simulateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.AgentID == "" || req.Tool == "" || req.Action == "" {
+		writeError(w, "agent_id, tool and action are required", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, g.simulate(req))
+}
+
+// simulate explains the decision for one prospective call, applying
+// on_policy_error like a real evaluation
+func (g *Gateway) simulate(req simulateRequest) simulateResponse {
+	if req.Params == nil {
+		req.Params = make(map[string]interface{})
+	}
+	encoded, _ := json.Marshal(req.Params)
+
+	preq := &policy.Request{
+		AgentID:  req.AgentID,
+		Tool:     req.Tool,
+		Action:   req.Action,
+		Params:   req.Params,
+		Method:   req.Method,
+		Resource: req.Resource,
+		BodySize: len(encoded),
+		Claims:   req.Claims,
+		Groups:   req.Groups,
+	}
+	ex := g.policyEngine.Simulate(preq)
+	decision := ex.Decision
+	if decision.Failed {
+		decision = g.onPolicyError(preq, decision)
+	}
+
+	failed := ex.FailedConditions
+	if failed == nil {
+		failed = []policy.ConditionFailure{}
+	}
+	return simulateResponse{
+		Allowed:          decision.Allowed,
+		Code:             decision.Code,
+		Reason:           decision.Reason,
+		Rollout:          decision.Rollout,
+		RetryAfter:       decision.RetryAfterSeconds(),
+		Fallback:         decision.Fallback,
+		Rule:             ex.Rule,
+		FailedConditions: failed,
+	}
+}
@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+
+	"aegis-gateway/internal/config"
+)
+
+// spiffeStartTimeout bounds the wait for the first SVID from the Workload API
+const spiffeStartTimeout = 10 * time.Second
+
+// spiffeWorkload holds the gateway's own SVID, kept current by the Workload
+// API
+type spiffeWorkload struct {
+	source      *workloadapi.X509Source
+	trustDomain spiffeid.TrustDomain
+}
+
+// newSPIFFEWorkload connects to the Workload API and waits for an SVID
+func newSPIFFEWorkload(cfg config.SPIFFEConfig) (*spiffeWorkload, error) {
+	td, err := spiffeid.TrustDomainFromString(cfg.TrustDomain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spiffe.trust_domain: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), spiffeStartTimeout)
+	defer cancel()
+	source, err := workloadapi.NewX509Source(ctx, workloadapi.WithClientOptions(workloadapi.WithAddr(cfg.SocketPath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain SVID from %s: %w", cfg.SocketPath, err)
+	}
+
+	return &spiffeWorkload{source: source, trustDomain: td}, nil
+}
+
+// serverTLSConfig serves the gateway's SVID and requires agents to present
+// an SVID from the trust domain
+func (s *spiffeWorkload) serverTLSConfig(minVersion uint16) *tls.Config {
+	cfg := tlsconfig.MTLSServerConfig(s.source, s.source, tlsconfig.AuthorizeMemberOf(s.trustDomain))
+	cfg.MinVersion = max(cfg.MinVersion, minVersion)
+	return cfg
+}
+
+// clientTLSConfig authenticates with the gateway's SVID and only accepts
+// an upstream presenting id
+func (s *spiffeWorkload) clientTLSConfig(id string) (*tls.Config, error) {
+	upstreamID, err := spiffeid.FromString(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spiffe_id %s: %w", id, err)
+	}
+	return tlsconfig.MTLSClientConfig(s.source, s.source, tlsconfig.AuthorizeID(upstreamID)), nil
+}
+
+// Close stops watching for SVID rotations
+func (s *spiffeWorkload) Close() error {
+	return s.source.Close()
+}
@@ -0,0 +1,362 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/xwb1989/sqlparser"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+)
+
+// sqlRequest is the body of a call to a SQL tool. Params fill the query's
+// ? placeholders in order.
+type sqlRequest struct {
+	Query  string        `json:"query"`
+	Params []interface{} `json:"params,omitempty"`
+}
+
+// sqlRows is the response of a SQL tool to a SELECT
+type sqlRows struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+
+	// Truncated is set when rows beyond the tool's sql.max_rows were dropped
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// sqlExecResult is the response of a SQL tool to an INSERT, UPDATE or
+// DELETE
+type sqlExecResult struct {
+	RowsAffected int64 `json:"rows_affected"`
+	LastInsertID int64 `json:"last_insert_id,omitempty"`
+}
+
+// sqlStatement is a parsed call to a SQL tool. query is the statement as
+// the gateway printed it back from the parse tree, so what runs on the
+// database is exactly what policy saw, comments and all stripped.
+type sqlStatement struct {
+	policy.SQLStatement
+	query string
+	args  []interface{}
+}
+
+// parseSQL parses calls to SQL tools, POSTed to /tools/:tool/select,
+// insert, update or delete, so policy sees the statement's type, tables and
+// LIMIT
+func (g *Gateway) parseSQL(next CallHandler) CallHandler {
+	return func(w http.ResponseWriter, c *Call) {
+		tool, ok := g.tools.Get(c.Tool)
+		if !ok || tool.Protocol != registry.ProtocolSQL {
+			next(w, c)
+			return
+		}
+
+		r := c.Request
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, fmt.Sprintf("Tool %s is a SQL database; POST statements to /tools/%s/select", c.Tool, c.Tool), http.StatusMethodNotAllowed)
+			return
+		}
+		if c.multipart || c.Resource != "" {
+			writeError(w, fmt.Sprintf("Tool %s is a SQL database; POST statements to /tools/%s/select", c.Tool, c.Tool), http.StatusBadRequest)
+			return
+		}
+
+		stmt, err := parseSQLStatement(c.bodyBytes)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if stmt.Type != c.Action {
+			writeError(w, fmt.Sprintf("SQL %s sent to /tools/%s/%s; use /tools/%s/%s", strings.ToUpper(stmt.Type), c.Tool, c.Action, c.Tool, stmt.Type), http.StatusBadRequest)
+			return
+		}
+		c.sql = stmt
+		next(w, c)
+	}
+}
+
+// parseSQLStatement parses the body of a call to a SQL tool. Only single
+// SELECT, INSERT, UPDATE and DELETE statements are accepted.
+func parseSQLStatement(body []byte) (*sqlStatement, error) {
+	var req sqlRequest
+	if err := json.Unmarshal(body, &req); err != nil || strings.TrimSpace(req.Query) == "" {
+		return nil, errors.New("SQL calls must be a JSON object with a query")
+	}
+	tree, err := sqlparser.Parse(req.Query)
+	if err != nil {
+		return nil, fmt.Errorf("SQL query is invalid: %v", err)
+	}
+	for {
+		p, ok := tree.(*sqlparser.ParenSelect)
+		if !ok {
+			break
+		}
+		tree = p.Select
+	}
+
+	stmt := &sqlStatement{SQLStatement: policy.SQLStatement{Limit: -1}}
+	seen := make(map[string]bool)
+	addTable := func(name sqlparser.TableName) {
+		table := strings.ToLower(sqlparser.String(name))
+		if (name.Qualifier.IsEmpty() && table == "dual") || seen[table] {
+			return
+		}
+		seen[table] = true
+		stmt.Tables = append(stmt.Tables, table)
+	}
+
+	var limit *sqlparser.Limit
+	switch tree := tree.(type) {
+	case *sqlparser.Select:
+		stmt.Type, limit = "select", tree.Limit
+	case *sqlparser.Union:
+		stmt.Type, limit = "select", tree.Limit
+	case *sqlparser.Insert:
+		stmt.Type = "insert"
+		addTable(tree.Table)
+	case *sqlparser.Update:
+		stmt.Type, limit = "update", tree.Limit
+	case *sqlparser.Delete:
+		stmt.Type, limit = "delete", tree.Limit
+	default:
+		return nil, errors.New("SQL tools only run SELECT, INSERT, UPDATE and DELETE statements")
+	}
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if t, ok := node.(*sqlparser.AliasedTableExpr); ok {
+			if name, ok := t.Expr.(sqlparser.TableName); ok {
+				addTable(name)
+			}
+		}
+		return true, nil
+	}, tree)
+
+	// Placeholders are parsed as :v1, :v2, ... and printed back as ? with
+	// their params, in the order they appear in the printed statement
+	var argErr error
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		if v, ok := node.(*sqlparser.SQLVal); ok && v.Type == sqlparser.ValArg {
+			arg, err := sqlParam(req.Params, string(v.Val))
+			if err != nil && argErr == nil {
+				argErr = err
+			}
+			stmt.args = append(stmt.args, arg)
+			buf.WriteString("?")
+			return
+		}
+		node.Format(buf)
+	})
+	buf.Myprintf("%v", tree)
+	if argErr != nil {
+		return nil, argErr
+	}
+	if len(stmt.args) != len(req.Params) {
+		return nil, fmt.Errorf("SQL query has %d placeholders but %d params were given", len(stmt.args), len(req.Params))
+	}
+	stmt.query = buf.String()
+
+	if limit != nil {
+		if n, ok := sqlLimit(limit.Rowcount, req.Params); ok {
+			stmt.Limit = n
+		}
+	}
+	return stmt, nil
+}
+
+// sqlParam returns the param a placeholder named :vN stands for, as a
+// value the driver can bind
+func sqlParam(params []interface{}, name string) (interface{}, error) {
+	i, err := strconv.Atoi(strings.TrimPrefix(name, ":v"))
+	if err != nil || i < 1 {
+		return nil, fmt.Errorf("SQL queries take ? placeholders, not %s", name)
+	}
+	if i > len(params) {
+		return nil, fmt.Errorf("SQL query has more placeholders than the %d params given", len(params))
+	}
+	switch v := params[i-1].(type) {
+	case nil, string, bool:
+		return v, nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), nil
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("SQL param %d must be a string, number, boolean or null", i)
+	}
+}
+
+// sqlLimit returns the row count of a LIMIT clause that is a number or a
+// placeholder bound to one
+func sqlLimit(expr sqlparser.Expr, params []interface{}) (int, bool) {
+	v, ok := expr.(*sqlparser.SQLVal)
+	if !ok {
+		return 0, false
+	}
+	switch v.Type {
+	case sqlparser.IntVal:
+		n, err := strconv.Atoi(string(v.Val))
+		return n, err == nil
+	case sqlparser.ValArg:
+		arg, err := sqlParam(params, string(v.Val))
+		if n, ok := arg.(int64); err == nil && ok && n >= 0 && n <= math.MaxInt32 {
+			return int(n), true
+		}
+	}
+	return 0, false
+}
+
+// sqlDatabases caches connection pools for SQL tools
+type sqlDatabases struct {
+	mu    sync.Mutex
+	pools map[string]*sqlPool
+}
+
+// sqlPool is a tool's connection pool and the DSN it was opened with
+type sqlPool struct {
+	dsn string
+	db  *sql.DB
+}
+
+// sqlDB returns the connection pool of a SQL tool, reopening it when its
+// DSN secret has changed. TCP connections go through the tool's dialer and
+// the egress policy like any other upstream.
+func (g *Gateway) sqlDB(tool *registry.Tool) (*sql.DB, error) {
+	g.mu.RLock()
+	store := g.secrets
+	g.mu.RUnlock()
+	dsn, err := store.Resolve(tool.SQL.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("sql.dsn for tool %s: %w", tool.Name, err)
+	}
+
+	u := &g.sql
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if pool, ok := u.pools[tool.Name]; ok {
+		if pool.dsn == dsn {
+			return pool.db, nil
+		}
+		pool.db.Close()
+		delete(u.pools, tool.Name)
+	}
+
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sql.dsn for tool %s: %w", tool.Name, err)
+	}
+	cfg.MultiStatements = false
+	if cfg.Net == "tcp" {
+		dial := g.proxyDial(tool.Transport, g.egressDial(newDialer(tool.Transport).DialContext))
+		cfg.Net = fmt.Sprintf("aegis-%p-%s", g, tool.Name)
+		mysql.RegisterDialContext(cfg.Net, func(ctx context.Context, addr string) (net.Conn, error) {
+			return dial(ctx, "tcp", addr)
+		})
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sql.dsn for tool %s: %w", tool.Name, err)
+	}
+
+	db := sql.OpenDB(connector)
+	if u.pools == nil {
+		u.pools = make(map[string]*sqlPool)
+	}
+	u.pools[tool.Name] = &sqlPool{dsn: dsn, db: db}
+	return db, nil
+}
+
+// execSQL runs a statement on a SQL tool and returns its result as JSON.
+// SELECTs return at most the tool's sql.max_rows rows.
+func (g *Gateway) execSQL(ctx context.Context, tool *registry.Tool, stmt *sqlStatement) ([]byte, error) {
+	db, err := g.sqlDB(tool)
+	if err != nil {
+		return nil, err
+	}
+	if tool.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tool.Timeout)
+		defer cancel()
+	}
+
+	if stmt.Type != "select" {
+		res, err := db.ExecContext(ctx, stmt.query, stmt.args...)
+		if err != nil {
+			return nil, err
+		}
+		var out sqlExecResult
+		out.RowsAffected, _ = res.RowsAffected()
+		out.LastInsertID, _ = res.LastInsertId()
+		return json.Marshal(out)
+	}
+
+	rows, err := db.QueryContext(ctx, stmt.query, stmt.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	maxRows := tool.SQL.MaxRows
+	if maxRows == 0 {
+		maxRows = config.DefaultSQLMaxRows
+	}
+	out := sqlRows{Columns: columns, Rows: [][]interface{}{}}
+	for rows.Next() {
+		if len(out.Rows) == maxRows {
+			out.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		out.Rows = append(out.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// sqlQueryFailed reports whether err is the database rejecting a statement,
+// e.g. for a syntax error or a missing table, rather than being unreachable
+func sqlQueryFailed(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr)
+}
+
+// close releases all connection pools
+func (u *sqlDatabases) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, pool := range u.pools {
+		pool.db.Close()
+	}
+	u.pools = nil
+}
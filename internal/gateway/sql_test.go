@@ -0,0 +1,50 @@
+package gateway
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSQLStatement(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantType   string
+		wantTables []string
+		wantLimit  int
+		wantQuery  string
+		wantErr    bool
+	}{
+		{"select", `{"query":"SELECT id FROM orders WHERE total > ? LIMIT 10","params":[5]}`,
+			"select", []string{"orders"}, 10, "select id from orders where total > ? limit 10", false},
+		{"join and subquery", `{"query":"SELECT o.id FROM orders o JOIN Shop.Customers c ON o.cid = c.id WHERE o.id IN (SELECT order_id FROM refunds)"}`,
+			"select", []string{"orders", "shop.customers", "refunds"}, -1, "", false},
+		{"placeholder limit", `{"query":"DELETE FROM sessions WHERE expired = 1 LIMIT ?","params":[50]}`,
+			"delete", []string{"sessions"}, 50, "", false},
+		{"comment stripped", `{"query":"SELECT id FROM orders /* LIMIT 1 */"}`,
+			"select", []string{"orders"}, -1, "select id from orders", false},
+		{"insert", `{"query":"INSERT INTO audit (msg) VALUES (?)","params":["x"]}`,
+			"insert", []string{"audit"}, -1, "", false},
+		{"stacked statements", `{"query":"SELECT 1; DROP TABLE orders"}`, "", nil, 0, "", true},
+		{"DDL", `{"query":"DROP TABLE orders"}`, "", nil, 0, "", true},
+		{"too few params", `{"query":"SELECT id FROM orders WHERE id = ?"}`, "", nil, 0, "", true},
+		{"too many params", `{"query":"SELECT id FROM orders","params":[1]}`, "", nil, 0, "", true},
+		{"no query", `{"params":[1]}`, "", nil, 0, "", true},
+	}
+	for _, tt := range tests {
+		stmt, err := parseSQLStatement([]byte(tt.body))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %+v, %v; want error %v", tt.name, stmt, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if stmt.Type != tt.wantType || !reflect.DeepEqual(stmt.Tables, tt.wantTables) || stmt.Limit != tt.wantLimit {
+			t.Errorf("%s: got %s %v limit %d", tt.name, stmt.Type, stmt.Tables, stmt.Limit)
+		}
+		if tt.wantQuery != "" && stmt.query != tt.wantQuery {
+			t.Errorf("%s: got query %q", tt.name, stmt.query)
+		}
+	}
+}
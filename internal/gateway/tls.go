@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// certReloader serves the listener certificate and re-reads it from disk
+// when the files change, so rotated certificates apply without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader loads the initial certificate
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the key pair from disk
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	modTime := latestModTime(c.certFile, c.keyFile)
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// watch polls the certificate files and reloads them when they change. A
+// broken new certificate is logged and the previous one keeps serving.
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.RLock()
+		current := c.modTime
+		c.mu.RUnlock()
+		if !latestModTime(c.certFile, c.keyFile).After(current) {
+			continue
+		}
+
+		if err := c.reload(); err != nil {
+			logger.Error("Failed to reload TLS certificate", "error", err)
+			continue
+		}
+		logger.Info("Reloaded TLS certificate", "file", c.certFile)
+	}
+}
+
+// latestModTime returns the newest modification time of the given files
+func latestModTime(files ...string) time.Time {
+	var latest time.Time
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// buildTLSConfig creates the listener TLS configuration. The reloader is
+// returned so the caller can start watching for rotated certificates.
+func buildTLSConfig(cfg config.TLSConfig) (*tls.Config, *certReloader, error) {
+	minVersion, err := cfg.MinTLSVersion()
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphers, err := cfg.CipherSuiteIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   ciphers,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if cfg.ClientCAFile != "" && cfg.ClientAuth != "" && cfg.ClientAuth != "none" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("client CA file contains no certificates")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.ClientAuth == "require" {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, reloader, nil
+}
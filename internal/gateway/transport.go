@@ -0,0 +1,263 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/registry"
+)
+
+// Connection pool defaults for tools that don't override them. net/http
+// keeps only two idle connections per host, which forces busy tools to
+// reconnect for most calls.
+const (
+	DefaultMaxIdleConnsPerHost = 32
+	DefaultIdleConnTimeout     = 90 * time.Second
+	DefaultDialTimeout         = 30 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+	DefaultKeepAlive           = 30 * time.Second
+)
+
+// upstreamClients caches one client per distinct transport setup, so tools
+// with the same settings share a pool and reloads that leave a tool's
+// settings alone keep its idle connections
+type upstreamClients struct {
+	mu      sync.Mutex
+	clients map[upstreamClientKey]*http.Client
+}
+
+type upstreamClientKey struct {
+	spiffeID  string
+	transport config.TransportConfig
+}
+
+// upstreamClient returns the client used to call tool
+func (g *Gateway) upstreamClient(tool *registry.Tool) (*http.Client, error) {
+	if tool.SPIFFEID != "" && g.spiffe == nil {
+		return nil, fmt.Errorf("tool %s requires a SPIFFE identity but the Workload API is unavailable", tool.Name)
+	}
+
+	key := upstreamClientKey{spiffeID: tool.SPIFFEID, transport: tool.Transport}
+	u := &g.clients
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if c, ok := u.clients[key]; ok {
+		return c, nil
+	}
+
+	tlsConfig, err := g.toolTLSConfig(tool)
+	if err != nil {
+		return nil, err
+	}
+	t := newUpstreamTransport(tool.Transport, tlsConfig)
+	t.RegisterProtocol(registry.SchemeUnix, g.tools.NewUnixTransport(t.Clone()))
+	t.DialContext = g.proxyDial(tool.Transport, g.egressDial(t.DialContext))
+	c := &http.Client{Transport: t, CheckRedirect: g.checkRedirect}
+	if u.clients == nil {
+		u.clients = make(map[upstreamClientKey]*http.Client)
+	}
+	u.clients[key] = c
+	return c, nil
+}
+
+// toolTLSConfig returns the TLS config for connections to tool: its SPIFFE
+// identity, its CA bundle, or nil for the system roots. The CA bundle is
+// read when the tool's connections are set up.
+func (g *Gateway) toolTLSConfig(tool *registry.Tool) (*tls.Config, error) {
+	if tool.SPIFFEID != "" {
+		if g.spiffe == nil {
+			return nil, fmt.Errorf("tool %s requires a SPIFFE identity but the Workload API is unavailable", tool.Name)
+		}
+		return g.spiffe.clientTLSConfig(tool.SPIFFEID)
+	}
+	if tool.Transport.CAFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(tool.Transport.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("tool %s: failed to read ca_file: %w", tool.Name, err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tool %s: ca_file %s contains no certificates", tool.Name, tool.Transport.CAFile)
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: roots}, nil
+}
+
+// toolCredentials returns the header and value the tool's credentials are
+// sent as, or "" for both if it has none
+func (g *Gateway) toolCredentials(tool *registry.Tool) (header, value string, err error) {
+	g.mu.RLock()
+	store := g.secrets
+	g.mu.RUnlock()
+
+	header, value, err = store.Credentials(tool.Credentials)
+	if err != nil {
+		return "", "", fmt.Errorf("credentials for tool %s: %w", tool.Name, err)
+	}
+	return header, value, nil
+}
+
+// newUpstreamTransport builds a transport from a tool's settings, falling
+// back to the gateway defaults. tlsConfig may be nil.
+func newUpstreamTransport(cfg config.TransportConfig, tlsConfig *tls.Config) *http.Transport {
+	t := &http.Transport{
+		DialContext:           newDialer(cfg).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     !cfg.DisableHTTP2,
+		MaxIdleConnsPerHost:   DefaultMaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       orDefault(cfg.IdleConnTimeout, DefaultIdleConnTimeout),
+		TLSHandshakeTimeout:   orDefault(cfg.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout),
+		ExpectContinueTimeout: time.Second,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.DisableHTTP2 {
+		// A non-nil empty map turns off h2 negotiation over TLS
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+// newDialer returns the dialer for a tool's connections
+func newDialer(cfg config.TransportConfig) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   orDefault(cfg.DialTimeout, DefaultDialTimeout),
+		KeepAlive: orDefault(cfg.KeepAlive, DefaultKeepAlive),
+	}
+	if cfg.LocalAddress != "" {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(cfg.LocalAddress)}
+	}
+	return dialer
+}
+
+// proxyDial wraps dial so connections for a tool go through its
+// configured proxy, over an HTTP CONNECT tunnel or SOCKS5. Proxy
+// environment variables are ignored, since they would route calls around
+// egress pinning. dial is used to reach the proxy itself; the target must
+// pass the egress check and the tunnel is opened to its pinned addresses,
+// so the proxy never resolves the name.
+func (g *Gateway) proxyDial(cfg config.TransportConfig, dial dialFunc) dialFunc {
+	if cfg.Proxy == "" || cfg.Proxy == config.ProxyDirect {
+		return dial
+	}
+	proxyURL, parseErr := url.Parse(cfg.Proxy)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		targets := []string{addr}
+		if !g.egressSettings().Disabled {
+			var err error
+			if targets, err = g.pinnedTargets(ctx, addr); err != nil {
+				return nil, err
+			}
+		}
+
+		var errs []error
+		for _, target := range targets {
+			conn, err := tunnel(ctx, dial, proxyURL, network, target)
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+// tunnel opens a connection to addr through proxyURL
+func tunnel(ctx context.Context, dial dialFunc, proxyURL *url.URL, network, addr string) (net.Conn, error) {
+	if proxyURL.Scheme == "socks5" {
+		socks, err := proxy.FromURL(proxyURL, contextDialer(dial))
+		if err != nil {
+			return nil, err
+		}
+		return socks.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	}
+	return connectTunnel(ctx, dial, proxyURL, addr)
+}
+
+// connectTunnel opens a connection to addr through an HTTP(S) proxy
+func connectTunnel(ctx context.Context, dial dialFunc, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", registry.HostPort(proxyURL.String()))
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{MinVersion: tls.VersionTLS12, ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The proxy sends nothing more until we do, so the reader holds no
+	// bytes of the tunnelled stream
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+	return conn, nil
+}
+
+// contextDialer adapts a dialFunc to golang.org/x/net/proxy
+type contextDialer dialFunc
+
+func (d contextDialer) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d contextDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// orDefault returns d, or def when d is unset
+func orDefault(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// checkDecoy denies calls to decoy tools and actions before they are
+// evaluated, whatever the policy says. The denial is the one a call no rule
+// covers gets, so the agent can't tell it tripped a tripwire.
+func (g *Gateway) checkDecoy(evaluate func(*policy.Request) policy.Decision) func(*policy.Request) policy.Decision {
+	return func(req *policy.Request) policy.Decision {
+		if !g.isDecoy(req) {
+			return evaluate(req)
+		}
+		return policy.Decision{
+			Code:   policy.CodeActionNotAllowed,
+			Reason: fmt.Sprintf("Agent %s is not allowed to perform action %s on tool %s", req.AgentID, req.Action, req.Tool),
+		}
+	}
+}
+
+// isDecoy reports whether a call is to a decoy tool or action
+func (g *Gateway) isDecoy(req *policy.Request) bool {
+	tool, ok := g.tools.Get(req.Tool)
+	return ok && tool.IsDecoy(req.Action)
+}
+
+// tripwire records a call to a decoy as a tripwire anomaly and, with
+// tripwire.action quarantine, quarantines the agent
+func (g *Gateway) tripwire(ctx context.Context, identity *Identity, req *policy.Request, decision policy.Decision) {
+	g.mu.RLock()
+	quarantining := g.config.Tripwire.Action == config.TripwireActionQuarantine
+	g.mu.RUnlock()
+
+	reason := fmt.Sprintf("%s called decoy %s/%s", req.AgentID, req.Tool, req.Action)
+	action := config.TripwireActionLog
+	if subject := quarantineSubject(identity); quarantining && subject != "" {
+		added, err := g.quarantine.Add(subject, reason, time.Now())
+		if err != nil {
+			logger.Error("Failed to persist quarantine", "agent_id", subject, "error", err)
+		}
+		if added {
+			action = config.TripwireActionQuarantine
+		}
+	}
+	logger.Error("Tripwire tripped", "agent_id", req.AgentID, "tool", req.Tool, "action", req.Action, "decision_id", decision.ID, "quarantined", action == config.TripwireActionQuarantine)
+	g.telemetry.LogAnomaly(ctx, telemetry.Anomaly{
+		Type:          config.AnomalyTripwire,
+		Reason:        reason,
+		Action:        action,
+		DecisionID:    decision.ID,
+		AgentID:       req.AgentID,
+		AgentVerified: identity.Verified(),
+		SessionID:     req.SessionID,
+		Tool:          req.Tool,
+		ToolAction:    req.Action,
+		Allowed:       decision.Allowed,
+		Code:          decision.Code,
+	})
+}
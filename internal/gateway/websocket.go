@@ -0,0 +1,262 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/propagation"
+
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/internal/registry"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// DefaultMaxMessageBytes bounds agent WebSocket messages when no
+// max_message_bytes is configured
+const DefaultMaxMessageBytes = 1 << 20
+
+// upgrader accepts agent WebSocket connections. Agents authenticate with
+// headers or certificates rather than cookies, so there is no cross-site
+// risk in accepting any origin.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// wsSession carries what is needed to evaluate messages on one connection
+type wsSession struct {
+	identity *Identity
+	tool     string
+	action   string
+	trace    context.Context
+
+	// rules are the response constraints of the rule that allowed the
+	// handshake, applied to every message from the tool
+	rules *policy.ResponseRules
+}
+
+// proxyWebSocket connects the agent to the tool over WebSocket once the
+// handshake has been allowed by policy. With evaluate_messages set, every
+// agent message is evaluated as if it were a request body; denied messages
+// are answered with the violation and not forwarded. Under response rules
+// every message from the tool is inspected as a response before the agent
+// gets it.
+func (g *Gateway) proxyWebSocket(w http.ResponseWriter, r *http.Request, upstream *registry.Tool, session wsSession) error {
+	upstreamConn, upstreamHeader, err := g.dialWebSocket(withTrace(r.Context(), session.trace), upstream, session.action, r.Header.Values("Sec-WebSocket-Protocol"))
+	if err != nil {
+		writeUpstreamError(w, http.StatusBadGateway, fmt.Sprintf("Failed to connect to tool: %v", err))
+		return err
+	}
+	defer upstreamConn.Close()
+
+	responseHeader := http.Header{}
+	if protocol := upstreamConn.Subprotocol(); protocol != "" {
+		responseHeader.Set("Sec-WebSocket-Protocol", protocol)
+	}
+	agentConn, err := upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		// Upgrade has already written an error response
+		return err
+	}
+	defer agentConn.Close()
+
+	limit := upstream.WebSocket.MaxMessageBytes
+	if limit <= 0 {
+		limit = DefaultMaxMessageBytes
+	}
+	agentConn.SetReadLimit(limit)
+
+	// agentConn is written to by both pumps when denials are reported
+	var agentWrite sync.Mutex
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- g.pumpAgentMessages(agentConn, upstreamConn, &agentWrite, upstream.WebSocket.EvaluateMessages, session)
+	}()
+	go func() {
+		if session.rules == nil {
+			errc <- pumpMessages(upstreamConn, agentConn, &agentWrite)
+			return
+		}
+		errc <- g.pumpToolMessages(upstreamConn, agentConn, &agentWrite, upstreamHeader, session)
+	}()
+
+	err = <-errc
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return nil
+	}
+	return err
+}
+
+// dialWebSocket opens the upstream connection on the first available
+// instance and returns it with the headers of the tool's handshake response
+func (g *Gateway) dialWebSocket(ctx context.Context, tool *registry.Tool, action string, protocols []string) (*websocket.Conn, http.Header, error) {
+	instances := tool.Instances()
+	if len(instances) == 0 {
+		return nil, nil, fmt.Errorf("no instances available for tool %s", tool.Name)
+	}
+
+	client, err := g.upstreamClient(tool)
+	if err != nil {
+		return nil, nil, err
+	}
+	dialer := websocket.Dialer{
+		HandshakeTimeout: tool.Timeout,
+		Subprotocols:     protocols,
+	}
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		dialer.NetDialContext = transport.DialContext
+		if transport.TLSClientConfig != nil {
+			// The transport may have added h2, which a WebSocket handshake can't use
+			dialer.TLSClientConfig = transport.TLSClientConfig.Clone()
+			dialer.TLSClientConfig.NextProtos = nil
+		}
+	}
+
+	header := http.Header{}
+	credHeader, credValue, err := g.toolCredentials(tool)
+	if err != nil {
+		return nil, nil, err
+	}
+	if credHeader != "" {
+		header.Set(credHeader, credValue)
+	}
+	telemetry.Inject(ctx, propagation.HeaderCarrier(header))
+	if id := telemetry.RequestID(ctx); id != "" {
+		header.Set(requestIDHeader, id)
+	}
+
+	target := registry.ActionURL(instances[0], action)
+	if u, err := url.Parse(target); err == nil && u.Scheme == registry.SchemeUnix {
+		socket, path, ok := g.tools.UnixSocket(u)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s is not under a registered unix socket", target)
+		}
+		target = "ws://localhost" + path
+		dialer.NetDialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	} else {
+		target = "ws" + strings.TrimPrefix(target, "http")
+	}
+
+	release := tool.Acquire(instances[0])
+	conn, resp, err := dialer.DialContext(ctx, target, header)
+	release(err == nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, resp.Header, nil
+}
+
+// pumpAgentMessages forwards agent messages upstream, evaluating each one
+// against policy first when required
+func (g *Gateway) pumpAgentMessages(agent, upstream *websocket.Conn, agentWrite *sync.Mutex, evaluate bool, session wsSession) error {
+	for {
+		messageType, data, err := agent.ReadMessage()
+		if err != nil {
+			forwardClose(upstream, err)
+			return err
+		}
+
+		if evaluate {
+			if decision := g.evaluateMessage(messageType, data, session); !decision.Allowed {
+				agentWrite.Lock()
+				err := agent.WriteJSON(violationBody(decision))
+				agentWrite.Unlock()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if err := upstream.WriteMessage(messageType, data); err != nil {
+			return err
+		}
+	}
+}
+
+// evaluateMessage checks a single agent message against policy
+func (g *Gateway) evaluateMessage(messageType int, data []byte, session wsSession) policy.Decision {
+	start := time.Now()
+
+	var params map[string]interface{}
+	if messageType != websocket.TextMessage || json.Unmarshal(data, &params) != nil || params == nil {
+		return policy.Decision{Code: policy.CodeInvalidParameter, Reason: "WebSocket messages must be JSON objects"}
+	}
+
+	_, span, decision := g.evaluate(session.trace, start, session.identity, session.tool, session.action, params, len(data))
+	span.End()
+	return decision
+}
+
+// pumpToolMessages forwards tool messages to the agent once each passes the
+// session's response rules, with the tool's handshake headers giving the
+// classification. Blocked messages are answered with the violation instead.
+func (g *Gateway) pumpToolMessages(upstream, agent *websocket.Conn, agentWrite *sync.Mutex, header http.Header, session wsSession) error {
+	for {
+		messageType, data, err := upstream.ReadMessage()
+		if err != nil {
+			agentWrite.Lock()
+			forwardClose(agent, err)
+			agentWrite.Unlock()
+			return err
+		}
+
+		out, violation := g.inspectResponse(session.trace, session.identity.AgentID, session.tool, session.action, session.rules, header, data)
+		agentWrite.Lock()
+		if violation != nil {
+			err = agent.WriteJSON(violation.body())
+		} else {
+			err = agent.WriteMessage(messageType, out)
+		}
+		agentWrite.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// pumpMessages copies messages from src to dst until either side closes
+func pumpMessages(src, dst *websocket.Conn, dstWrite *sync.Mutex) error {
+	for {
+		messageType, data, err := src.ReadMessage()
+		if err != nil {
+			dstWrite.Lock()
+			forwardClose(dst, err)
+			dstWrite.Unlock()
+			return err
+		}
+		dstWrite.Lock()
+		err = dst.WriteMessage(messageType, data)
+		dstWrite.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// forwardClose relays a close frame (or a going-away close for other
+// errors) to the other side of the proxy
+func forwardClose(dst *websocket.Conn, err error) {
+	code, text := websocket.CloseGoingAway, ""
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		code, text = closeErr.Code, closeErr.Text
+		if code == websocket.CloseNoStatusReceived {
+			code = websocket.CloseNormalClosure
+		}
+	}
+	dst.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+}
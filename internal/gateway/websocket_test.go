@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"aegis-gateway/internal/config"
+)
+
+// Messages from a WebSocket tool are responses too, so the rule's response
+// constraints apply to each one
+func TestWebSocketResponseRules(t *testing.T) {
+	tool := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"msg":"hi","secret":"x"}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("a", 100)))
+		conn.ReadMessage()
+	}))
+	defer tool.Close()
+
+	policyYAML := `version: "1"
+agents:
+  - id: chat-agent
+    allow:
+      - tool: chat
+        actions: [stream]
+        response:
+          max_bytes: 64
+          forbid_fields: [secret]
+          on_forbidden_field: strip
+`
+	g := newTestGateway(t, policyYAML, func(cfg *config.Config) {
+		cfg.Tools = map[string]config.ToolConfig{
+			"chat": {URL: tool.URL, Timeout: config.DefaultToolTimeout, WebSocket: config.WebSocketConfig{Enabled: true}},
+		}
+	})
+	gateway := httptest.NewServer(http.HandlerFunc(g.HandleRequest))
+	defer gateway.Close()
+
+	header := http.Header{"X-Agent-Id": {"chat-agent"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http")+"/tools/chat/stream", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, first, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != `{"msg":"hi"}` {
+		t.Fatalf("got %s", first)
+	}
+	_, second, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(second), "RESPONSE_TOO_LARGE") {
+		t.Fatalf("oversized message was delivered: %s", second)
+	}
+}
@@ -0,0 +1,142 @@
+// Package injection scans text an agent sends to a tool, or a tool returns
+// to an agent, for prompt-injection and instruction-smuggling patterns:
+// attempts to override the model's instructions, chat template markup,
+// invisible Unicode and embedded tool calls.
+package injection
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Built-in patterns
+const (
+	IgnoreInstructions = "ignore_instructions"
+	RoleOverride       = "role_override"
+	SystemPromptLeak   = "system_prompt_leak"
+	ChatMarkup         = "chat_markup"
+	HiddenUnicode      = "hidden_unicode"
+	EmbeddedToolCall   = "embedded_tool_call"
+	Exfiltration       = "exfiltration"
+)
+
+// builtins are the patterns available without configuration
+var builtins = map[string]*regexp.Regexp{
+	IgnoreInstructions: regexp.MustCompile(`(?is)\b(?:ignore|disregard|forget|override|bypass)\b.{0,40}?\b(?:previous|prior|above|earlier|all|any|system|developer)\b.{0,40}?\b(?:instructions?|prompts?|rules|directives|guidelines|context)\b`),
+	RoleOverride:       regexp.MustCompile(`(?i)\b(?:you are now|from now on,? you(?: are| will)?|pretend (?:to be|you are)|act as (?:an? )?(?:unrestricted|jailbroken|different)|enter (?:developer|dan|god) mode)\b`),
+	SystemPromptLeak:   regexp.MustCompile(`(?is)\b(?:reveal|print|show|repeat|output|leak)\b.{0,40}?\b(?:system prompt|hidden instructions|initial instructions|developer message)\b`),
+	ChatMarkup:         regexp.MustCompile(`(?im)<\|(?:im_start|im_end|system|assistant|user|endoftext)\|>|\[/?INST\]|<</?SYS>>|^\s*(?:###\s*)?(?:system|assistant)\s*:`),
+	HiddenUnicode:      regexp.MustCompile(`[\x{200B}-\x{200F}\x{202A}-\x{202E}\x{2060}-\x{2064}\x{2066}-\x{2069}\x{FEFF}\x{E0000}-\x{E007F}]`),
+	EmbeddedToolCall:   regexp.MustCompile(`(?i)\{\s*"(?:tool_calls?|function_call|tool_use)"\s*:`),
+	Exfiltration:       regexp.MustCompile(`(?is)\b(?:send|post|upload|forward|exfiltrate|email)\b.{0,60}?\b(?:secrets?|credentials?|passwords?|api keys?|tokens?|conversation|chat history|system prompt)\b.{0,40}?\b(?:to|at)\b\s*(?:https?://|[A-Za-z0-9._%+-]+@)`),
+}
+
+// Builtin reports whether name is a built-in pattern
+func Builtin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// Finding is a string that matched a pattern
+type Finding struct {
+	Pattern string
+
+	// Path locates the string, e.g. "note" or "items[2].text"; it is
+	// empty for a plain-text body
+	Path string
+}
+
+func (f Finding) String() string {
+	if f.Path == "" {
+		return f.Pattern
+	}
+	return f.Pattern + " at " + f.Path
+}
+
+// Filter matches text against a set of patterns
+type Filter struct {
+	names    []string
+	patterns map[string]*regexp.Regexp
+}
+
+// New returns a filter with the built-in patterns, less those in disable,
+// plus custom regular expressions by name
+func New(custom map[string]string, disable []string) (*Filter, error) {
+	f := &Filter{patterns: make(map[string]*regexp.Regexp)}
+	for name, pattern := range builtins {
+		f.patterns[name] = pattern
+	}
+	for _, name := range disable {
+		if !Builtin(name) {
+			return nil, fmt.Errorf("unknown built-in pattern %s", name)
+		}
+		delete(f.patterns, name)
+	}
+	for name, expr := range custom {
+		if Builtin(name) {
+			return nil, fmt.Errorf("pattern %s conflicts with a built-in pattern", name)
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %s: %w", name, err)
+		}
+		f.patterns[name] = pattern
+	}
+	for name := range f.patterns {
+		f.names = append(f.names, name)
+	}
+	sort.Strings(f.names)
+	return f, nil
+}
+
+// Scan returns the findings in every string of v, which may be a string
+// or decoded JSON
+func (f *Filter) Scan(v interface{}) []Finding {
+	var findings []Finding
+	f.walk("", v, &findings)
+	return findings
+}
+
+// ScanBody scans a response body: the strings of a JSON document, or the
+// body as text
+func (f *Filter) ScanBody(body []byte) []Finding {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err == nil {
+		return f.Scan(doc)
+	}
+	return f.Scan(string(body))
+}
+
+func (f *Filter) walk(path string, v interface{}, findings *[]Finding) {
+	switch v := v.(type) {
+	case string:
+		for _, name := range f.names {
+			if f.patterns[name].MatchString(v) {
+				*findings = append(*findings, Finding{Pattern: name, Path: path})
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			f.walk(child, v[key], findings)
+		}
+	case []interface{}:
+		for i, item := range v {
+			f.walk(path+"["+strconv.Itoa(i)+"]", item, findings)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package logging provides the gateway's slog loggers. Each component
+// (policy, gateway, telemetry, ...) logs through its own logger, which
+// follows the level and format set with Configure even when it was created
+// before the config was loaded.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config sets how the gateway logs
+type Config struct {
+	// Level is debug, info (default), warn or error
+	Level string `yaml:"level"`
+
+	// Format is text (default) or json
+	Format string `yaml:"format"`
+
+	// Components overrides the level of single components, e.g.
+	// {policy: debug}
+	Components map[string]string `yaml:"components,omitempty"`
+}
+
+// Validate checks the level and format names
+func (c Config) Validate() error {
+	if _, err := parseLevel(c.Level); err != nil {
+		return fmt.Errorf("logging.level: %w", err)
+	}
+	switch c.Format {
+	case "", FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("logging.format must be text or json")
+	}
+	names := make([]string, 0, len(c.Components))
+	for name := range c.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := parseLevel(c.Components[name]); err != nil {
+			return fmt.Errorf("logging.components.%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// parseLevel parses a level name; "" is info
+func parseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown level %q; use debug, info, warn or error", name)
+}
+
+// settings are the handler and levels every component logger uses
+type settings struct {
+	handler    slog.Handler
+	level      slog.Level
+	components map[string]slog.Level
+}
+
+func (s *settings) levelOf(component string) slog.Level {
+	if level, ok := s.components[component]; ok {
+		return level
+	}
+	return s.level
+}
+
+var current atomic.Pointer[settings]
+
+// output is where logs are written
+var output io.Writer = os.Stdout
+
+func init() {
+	current.Store(&settings{handler: slog.NewTextHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug}), level: slog.LevelInfo})
+}
+
+// Configure applies cfg to every component logger. An invalid config is
+// rejected and the previous one kept.
+func Configure(cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s := &settings{components: make(map[string]slog.Level, len(cfg.Components))}
+	s.level, _ = parseLevel(cfg.Level)
+	for name, level := range cfg.Components {
+		s.components[name], _ = parseLevel(level)
+	}
+
+	// Components filter by level themselves, so the handler passes everything
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if cfg.Format == FormatJSON {
+		s.handler = slog.NewJSONHandler(output, opts)
+	} else {
+		s.handler = slog.NewTextHandler(output, opts)
+	}
+	current.Store(s)
+	return nil
+}
+
+// For returns the logger of a component. Its records carry a component
+// attribute.
+func For(component string) *slog.Logger {
+	return slog.New(&handler{component: component})
+}
+
+// handler sends a component's records to the configured handler. Attributes
+// and groups added with With are replayed onto it, since it can change.
+type handler struct {
+	component string
+	ops       []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= current.Load().levelOf(h.component)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	next := current.Load().handler.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	for _, op := range h.ops {
+		next = op(next)
+	}
+	return next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{component: h.component, ops: append(ops, op)}
+}
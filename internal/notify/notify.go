@@ -0,0 +1,353 @@
+// Package notify sends operators webhook notifications about denials,
+// bursts of denials, exhausted budgets, failed policy reloads and unusual
+// agent behavior.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/logging"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// logger is the notify component's logger
+var logger = logging.For("notify")
+
+// queueSize bounds the notifications waiting for one webhook; further ones
+// are dropped and logged
+const queueSize = 100
+
+// Event is a notification, the body of json webhooks
+type Event struct {
+	Trigger    string    `json:"trigger"`
+	Time       time.Time `json:"time"`
+	Summary    string    `json:"summary"`
+	AgentID    string    `json:"agent_id,omitempty"`
+	Tool       string    `json:"tool,omitempty"`
+	Action     string    `json:"action,omitempty"`
+	Code       string    `json:"code,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	DecisionID string    `json:"decision_id,omitempty"`
+
+	// Count and Window are the denials of a deny_burst
+	Count  int    `json:"count,omitempty"`
+	Window string `json:"window,omitempty"`
+
+	// File and Error describe a failed policy reload
+	File  string `json:"file,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	// Anomaly is the type of an anomaly and AnomalyAction what the gateway
+	// did about it
+	Anomaly       string `json:"anomaly,omitempty"`
+	AnomalyAction string `json:"anomaly_action,omitempty"`
+
+	// Severity is high for tripwires, which no legitimate agent trips
+	Severity string `json:"severity,omitempty"`
+}
+
+// Notifier fires the configured webhooks. A nil Notifier has none.
+type Notifier struct {
+	hooks []*webhook
+}
+
+// webhook is one configured webhook with its delivery queue and the state
+// of its triggers
+type webhook struct {
+	cfg     config.WebhookConfig
+	resolve func(string) (string, error)
+	client  *http.Client
+	queue   chan Event
+	done    chan struct{}
+
+	mu sync.Mutex
+	// fired is when a trigger last fired, by trigger and agent
+	fired map[string]time.Time
+	// denials are the recent denial times of deny_burst triggers, by
+	// trigger and agent
+	denials map[string][]time.Time
+}
+
+// New starts the configured webhooks, or returns nil if there are none.
+// resolve reads secret references in URLs and headers.
+func New(cfg config.NotifyConfig, resolve func(string) (string, error)) *Notifier {
+	if len(cfg.Webhooks) == 0 {
+		return nil
+	}
+	n := &Notifier{}
+	for _, c := range cfg.Webhooks {
+		if c.Timeout == 0 {
+			c.Timeout = config.DefaultNotifyTimeout
+		}
+		if c.Cooldown == 0 {
+			c.Cooldown = config.DefaultNotifyCooldown
+		}
+		h := &webhook{
+			cfg:     c,
+			resolve: resolve,
+			client:  &http.Client{Timeout: c.Timeout},
+			queue:   make(chan Event, queueSize),
+			done:    make(chan struct{}),
+			fired:   make(map[string]time.Time),
+			denials: make(map[string][]time.Time),
+		}
+		go h.run()
+		n.hooks = append(n.hooks, h)
+	}
+	return n
+}
+
+// Close delivers the queued notifications and stops the webhooks
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	for _, h := range n.hooks {
+		close(h.queue)
+		<-h.done
+	}
+}
+
+// Decision fires the deny, deny_burst and budget_exhausted triggers a
+// denied decision matches, or the anomaly or tripwire triggers an anomaly
+// matches
+func (n *Notifier) Decision(d telemetry.DecisionLog) {
+	if n == nil {
+		return
+	}
+	if d.Phase == telemetry.PhaseAnomaly {
+		n.anomaly(d)
+		return
+	}
+	if d.Decision == "true" {
+		return
+	}
+	now := time.Now()
+	for _, h := range n.hooks {
+		for i, t := range h.cfg.Triggers {
+			if !matches(t, d) {
+				continue
+			}
+			event := Event{
+				Trigger:    t.Type,
+				Time:       now,
+				AgentID:    d.AgentID,
+				Tool:       d.ToolName,
+				Action:     d.ToolAction,
+				Code:       d.Code,
+				Reason:     d.Reason,
+				DecisionID: d.DecisionID,
+			}
+			key := fmt.Sprintf("%d/%s", i, d.AgentID)
+			switch t.Type {
+			case config.TriggerDeny:
+				event.Summary = fmt.Sprintf("%s was denied %s/%s: %s", d.AgentID, d.ToolName, d.ToolAction, reason(d))
+			case config.TriggerBudgetExhausted:
+				if d.Code != policy.CodeBudgetExceeded {
+					continue
+				}
+				key += "/" + d.ToolName
+				event.Summary = fmt.Sprintf("%s exhausted its budget for %s/%s: %s", d.AgentID, d.ToolName, d.ToolAction, d.Reason)
+			case config.TriggerDenyBurst:
+				count := h.countDenial(key, now, t.Window)
+				if count < t.Count {
+					continue
+				}
+				event.Count = count
+				event.Window = t.Window.String()
+				event.Summary = fmt.Sprintf("%s was denied %d times in %s; the last was %s/%s: %s", d.AgentID, count, t.Window, d.ToolName, d.ToolAction, reason(d))
+			default:
+				continue
+			}
+			h.fire(key, event)
+		}
+	}
+}
+
+// anomaly fires the anomaly triggers an anomaly entry matches, or the
+// tripwire triggers for calls to decoys. Each type stays quiet for an agent
+// for the cooldown, and a tripwire for an agent and tool.
+func (n *Notifier) anomaly(d telemetry.DecisionLog) {
+	now := time.Now()
+	trigger, severity := config.TriggerAnomaly, ""
+	summary := fmt.Sprintf("Anomaly %s for %s on %s/%s: %s", d.AnomalyType, d.AgentID, d.ToolName, d.ToolAction, d.Reason)
+	suffix := d.AnomalyType
+	if d.AnomalyType == config.AnomalyTripwire {
+		trigger, severity = config.TriggerTripwire, "high"
+		summary = fmt.Sprintf("Tripwire: %s called decoy %s/%s", d.AgentID, d.ToolName, d.ToolAction)
+		suffix = d.ToolName
+	}
+	switch d.AnomalyAction {
+	case config.AnomalyActionRequireApproval:
+		summary += "; its calls now require approval"
+	case config.TripwireActionQuarantine:
+		summary += "; it is quarantined until released"
+	}
+	for _, h := range n.hooks {
+		for i, t := range h.cfg.Triggers {
+			if t.Type != trigger || !listed(t.Agents, d.AgentID) || !listed(t.Tools, d.ToolName) {
+				continue
+			}
+			h.fire(fmt.Sprintf("%d/%s/%s", i, d.AgentID, suffix), Event{
+				Trigger:       t.Type,
+				Time:          now,
+				Summary:       summary,
+				AgentID:       d.AgentID,
+				Tool:          d.ToolName,
+				Action:        d.ToolAction,
+				Reason:        d.Reason,
+				DecisionID:    d.DecisionID,
+				Anomaly:       d.AnomalyType,
+				AnomalyAction: d.AnomalyAction,
+				Severity:      severity,
+			})
+		}
+	}
+}
+
+// PolicyReloadFailed fires the policy_reload_failed triggers
+func (n *Notifier) PolicyReloadFailed(file string, err error) {
+	if n == nil {
+		return
+	}
+	now := time.Now()
+	for _, h := range n.hooks {
+		for i, t := range h.cfg.Triggers {
+			if t.Type != config.TriggerPolicyReloadFailed {
+				continue
+			}
+			h.fire(fmt.Sprintf("%d/%s", i, file), Event{
+				Trigger: t.Type,
+				Time:    now,
+				Summary: fmt.Sprintf("Policy reload failed for %s: %v", file, err),
+				File:    file,
+				Error:   err.Error(),
+			})
+		}
+	}
+}
+
+// matches reports whether a decision passes a trigger's filters
+func matches(t config.TriggerConfig, d telemetry.DecisionLog) bool {
+	return listed(t.Agents, d.AgentID) && listed(t.Tools, d.ToolName) && listed(t.Codes, d.Code)
+}
+
+// listed reports whether value is in list; an empty list allows everything
+func listed(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func reason(d telemetry.DecisionLog) string {
+	if d.Reason == "" {
+		return d.Code
+	}
+	return d.Code + " (" + d.Reason + ")"
+}
+
+// countDenial records a denial for a deny_burst trigger and returns how
+// many fell within window
+func (h *webhook) countDenial(key string, now time.Time, window time.Duration) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	times := h.denials[key]
+	kept := times[:0]
+	for _, t := range times {
+		if now.Sub(t) < window {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, now)
+	h.denials[key] = kept
+	return len(kept)
+}
+
+// fire queues an event unless key fired within the cooldown. A burst
+// that fired starts counting again.
+func (h *webhook) fire(key string, event Event) {
+	h.mu.Lock()
+	if last, ok := h.fired[key]; ok && event.Time.Sub(last) < h.cfg.Cooldown {
+		h.mu.Unlock()
+		return
+	}
+	h.fired[key] = event.Time
+	delete(h.denials, key)
+	h.mu.Unlock()
+
+	select {
+	case h.queue <- event:
+	default:
+		logger.Warn("Notification dropped; webhook queue is full", "webhook", h.cfg.Name, "trigger", event.Trigger)
+	}
+}
+
+func (h *webhook) run() {
+	defer close(h.done)
+	for event := range h.queue {
+		if err := h.send(event); err != nil {
+			logger.Warn("Failed to send notification", "webhook", h.cfg.Name, "trigger", event.Trigger, "error", err)
+		}
+	}
+}
+
+// send POSTs an event in the webhook's format
+func (h *webhook) send(event Event) error {
+	var payload interface{} = event
+	if h.cfg.Format == config.NotifyFormatSlack {
+		payload = map[string]string{"text": "[aegis] " + event.Summary}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	target, err := h.value(h.cfg.URL)
+	if err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, ref := range h.cfg.Headers {
+		value, err := h.value(ref)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// value resolves a secret reference, or returns a plain value as is
+func (h *webhook) value(v string) (string, error) {
+	if _, _, ok := config.SplitSecretRef(v); !ok {
+		return v, nil
+	}
+	return h.resolve(v)
+}
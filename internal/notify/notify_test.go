@@ -0,0 +1,176 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// receiver records the requests POSTed to a webhook
+type receiver struct {
+	mu       sync.Mutex
+	bodies   []string
+	requests []*http.Request
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.bodies = append(rc.bodies, string(body))
+	rc.requests = append(rc.requests, r)
+}
+
+// events decodes the received bodies
+func (rc *receiver) events(t *testing.T) []Event {
+	t.Helper()
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	events := make([]Event, len(rc.bodies))
+	for i, body := range rc.bodies {
+		if err := json.Unmarshal([]byte(body), &events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return events
+}
+
+// listen starts a webhook receiver and returns its URL
+func listen(t *testing.T) (*receiver, string) {
+	t.Helper()
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+	return rc, srv.URL
+}
+
+// denial is a denied call of finance-agent
+func denial(tool, code string) telemetry.DecisionLog {
+	return telemetry.DecisionLog{AgentID: "finance-agent", ToolName: tool, ToolAction: "create", Decision: "false", Code: code, Reason: "denied by rule"}
+}
+
+func TestTriggers(t *testing.T) {
+	rc, url := listen(t)
+	n := New(config.NotifyConfig{Webhooks: []config.WebhookConfig{{
+		Name: "oncall",
+		URL:  url,
+		Triggers: []config.TriggerConfig{
+			{Type: config.TriggerDeny, Tools: []string{"payments"}, Codes: []string{policy.CodeRateLimited}},
+			{Type: config.TriggerBudgetExhausted},
+			{Type: config.TriggerDenyBurst, Count: 3, Window: time.Minute},
+			{Type: config.TriggerPolicyReloadFailed},
+		},
+	}}}, nil)
+
+	n.Decision(denial("payments", policy.CodeRateLimited))
+	n.Decision(denial("reports", policy.CodeRateLimited))
+	n.Decision(telemetry.DecisionLog{AgentID: "finance-agent", ToolName: "payments", Decision: "true"})
+	n.Decision(denial("payroll", policy.CodeBudgetExceeded))
+	// Within the cooldown of the deny trigger, and the first denial of a
+	// new burst
+	n.Decision(denial("payments", policy.CodeRateLimited))
+	n.PolicyReloadFailed("policy.yaml", errors.New("yaml: line 3: bad indentation"))
+	n.Close()
+
+	tests := []struct {
+		trigger string
+		summary string
+	}{
+		{config.TriggerDeny, "finance-agent was denied payments/create: RATE_LIMITED (denied by rule)"},
+		{config.TriggerBudgetExhausted, "finance-agent exhausted its budget for payroll/create: denied by rule"},
+		{config.TriggerDenyBurst, "finance-agent was denied 3 times in 1m0s; the last was payroll/create: BUDGET_EXCEEDED (denied by rule)"},
+		{config.TriggerPolicyReloadFailed, "Policy reload failed for policy.yaml: yaml: line 3: bad indentation"},
+	}
+	events := rc.events(t)
+	if len(events) != len(tests) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(tests), events)
+	}
+	for i, tt := range tests {
+		if got := events[i]; got.Trigger != tt.trigger || got.Summary != tt.summary {
+			t.Errorf("%s: got %+v", tt.trigger, got)
+		}
+	}
+	if events[2].Count != 3 || events[2].Window != "1m0s" || events[3].File != "policy.yaml" {
+		t.Errorf("got %+v", events)
+	}
+}
+
+func TestAnomalies(t *testing.T) {
+	rc, url := listen(t)
+	n := New(config.NotifyConfig{Webhooks: []config.WebhookConfig{{
+		Name:     "security",
+		URL:      url,
+		Triggers: []config.TriggerConfig{{Type: config.TriggerAnomaly}, {Type: config.TriggerTripwire}},
+	}}}, nil)
+
+	anomaly := telemetry.DecisionLog{Phase: telemetry.PhaseAnomaly, AgentID: "finance-agent", ToolName: "payroll", ToolAction: "export", AnomalyType: config.AnomalyNewTool, AnomalyAction: config.AnomalyActionRequireApproval, Reason: "first call to payroll"}
+	n.Decision(anomaly)
+	n.Decision(anomaly)
+	n.Decision(telemetry.DecisionLog{Phase: telemetry.PhaseAnomaly, AgentID: "finance-agent", ToolName: "admin_export", ToolAction: "dump", AnomalyType: config.AnomalyTripwire, AnomalyAction: config.TripwireActionQuarantine})
+	n.Close()
+
+	events := rc.events(t)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2: %+v", len(events), events)
+	}
+	if got := events[0]; got.Trigger != config.TriggerAnomaly || got.Anomaly != config.AnomalyNewTool || got.Summary != "Anomaly new_tool for finance-agent on payroll/export: first call to payroll; its calls now require approval" {
+		t.Errorf("anomaly: got %+v", got)
+	}
+	if got := events[1]; got.Trigger != config.TriggerTripwire || got.Severity != "high" || got.Summary != "Tripwire: finance-agent called decoy admin_export/dump; it is quarantined until released" {
+		t.Errorf("tripwire: got %+v", got)
+	}
+}
+
+// Slack webhooks get a message, and secret references in the URL and
+// headers are resolved when sending
+func TestSlackFormat(t *testing.T) {
+	rc, url := listen(t)
+	resolve := func(ref string) (string, error) {
+		switch ref {
+		case "env:SLACK_WEBHOOK_URL":
+			return url + "/services/T0", nil
+		case "env:WEBHOOK_TOKEN":
+			return "Bearer s3cret", nil
+		}
+		return "", errors.New("unknown secret " + ref)
+	}
+	n := New(config.NotifyConfig{Webhooks: []config.WebhookConfig{{
+		Name:     "slack",
+		URL:      "env:SLACK_WEBHOOK_URL",
+		Format:   config.NotifyFormatSlack,
+		Headers:  map[string]string{"Authorization": "env:WEBHOOK_TOKEN", "X-Source": "aegis"},
+		Triggers: []config.TriggerConfig{{Type: config.TriggerDeny}},
+	}}}, resolve)
+	n.Decision(denial("payments", policy.CodeRateLimited))
+	n.Close()
+
+	if len(rc.bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(rc.bodies))
+	}
+	r := rc.requests[0]
+	if rc.bodies[0] != `{"text":"[aegis] finance-agent was denied payments/create: RATE_LIMITED (denied by rule)"}` {
+		t.Errorf("got body %s", rc.bodies[0])
+	}
+	if r.URL.Path != "/services/T0" || r.Header.Get("Authorization") != "Bearer s3cret" || r.Header.Get("X-Source") != "aegis" {
+		t.Errorf("got %s with headers %v", r.URL.Path, r.Header)
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	n := New(config.NotifyConfig{}, nil)
+	if n != nil {
+		t.Fatal("New without webhooks returned a notifier")
+	}
+	n.Decision(denial("payments", policy.CodeRateLimited))
+	n.PolicyReloadFailed("policy.yaml", errors.New("broken"))
+	n.Close()
+}
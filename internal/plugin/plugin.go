@@ -0,0 +1,292 @@
+// Package plugin runs WASM plugins at two points of a tool call: before it
+// is evaluated against policy, and before the tool's response is sent to
+// the agent. Plugins are compiled once at load and get a fresh instance for
+// every hook call, so they keep no state between calls.
+//
+// A plugin is a WASM module (WASI is available) that exports its memory
+// and:
+//
+//	alloc(size i32) i32              reserves size bytes for the input
+//	on_request(ptr i32, len i32) i64  optional, runs before evaluation
+//	on_response(ptr i32, len i32) i64 optional, runs before the response
+//
+// A hook receives a JSON RequestInput or ResponseInput at ptr and returns
+// the location of a JSON Result as ptr<<32 | len, or 0 to let the call
+// through unchanged.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/logging"
+)
+
+// logger is the plugin component's logger
+var logger = logging.For("plugin")
+
+// Exported function names
+const (
+	allocFunc      = "alloc"
+	onRequestFunc  = "on_request"
+	onResponseFunc = "on_response"
+)
+
+// memoryLimitPages caps a plugin instance's memory at 16 MiB
+const memoryLimitPages = 256
+
+// RequestInput is what on_request receives
+type RequestInput struct {
+	Config    map[string]interface{} `json:"config,omitempty"`
+	AgentID   string                 `json:"agent_id"`
+	SessionID string                 `json:"session_id,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Tool      string                 `json:"tool"`
+	Action    string                 `json:"action"`
+	Method    string                 `json:"method"`
+	Resource  string                 `json:"resource,omitempty"`
+	Params    map[string]interface{} `json:"params"`
+}
+
+// ResponseInput is what on_response receives
+type ResponseInput struct {
+	Config    map[string]interface{} `json:"config,omitempty"`
+	AgentID   string                 `json:"agent_id"`
+	SessionID string                 `json:"session_id,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Tool      string                 `json:"tool"`
+	Action    string                 `json:"action"`
+	Status    int                    `json:"status"`
+	Headers   map[string]string      `json:"headers"`
+	Body      string                 `json:"body"`
+}
+
+// Result is what a hook returns. Unset fields leave the call unchanged.
+type Result struct {
+	// Veto rejects the call
+	Veto *Veto `json:"veto,omitempty"`
+
+	// Params replaces the call's params (on_request only)
+	Params map[string]interface{} `json:"params,omitempty"`
+
+	// Status and Body replace the response (on_response only)
+	Status int     `json:"status,omitempty"`
+	Body   *string `json:"body,omitempty"`
+}
+
+// Veto is a plugin's reason for rejecting a call
+type Veto struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// Host holds the loaded plugins. A nil Host has none.
+type Host struct {
+	// mu is held for reading by hook calls, so Close waits for them
+	mu      sync.RWMutex
+	closed  bool
+	runtime wazero.Runtime
+	plugins []*Plugin
+}
+
+// Plugin is a loaded plugin
+type Plugin struct {
+	cfg      config.PluginConfig
+	host     *Host
+	compiled wazero.CompiledModule
+
+	// err is set when the plugin failed to load; its hooks then fail
+	err error
+
+	onRequest  bool
+	onResponse bool
+}
+
+// Load compiles the configured plugins. A plugin that can't be loaded is
+// logged and kept, failing every call it applies to unless it is
+// fail_open, so a broken plugin can't silently stop vetoing calls.
+func Load(cfgs []config.PluginConfig) *Host {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	h := &Host{
+		runtime: wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+			WithCloseOnContextDone(true).
+			WithMemoryLimitPages(memoryLimitPages)),
+	}
+	wasi_snapshot_preview1.MustInstantiate(ctx, h.runtime)
+
+	for _, cfg := range cfgs {
+		p := &Plugin{cfg: cfg, host: h}
+		if p.err = p.compile(ctx); p.err != nil {
+			logger.Error("Plugin failed to load", "plugin", cfg.Name, "error", p.err)
+			p.onRequest, p.onResponse = true, true
+		}
+		h.plugins = append(h.plugins, p)
+	}
+	return h
+}
+
+// compile loads the module and checks its exports
+func (p *Plugin) compile(ctx context.Context) error {
+	code, err := os.ReadFile(p.cfg.Path)
+	if err != nil {
+		return err
+	}
+	compiled, err := p.host.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return fmt.Errorf("invalid module: %w", err)
+	}
+	exports := compiled.ExportedFunctions()
+	if _, ok := exports[allocFunc]; !ok {
+		return fmt.Errorf("module does not export %s", allocFunc)
+	}
+	if len(compiled.ExportedMemories()) == 0 {
+		return fmt.Errorf("module does not export its memory")
+	}
+	_, p.onRequest = exports[onRequestFunc]
+	_, p.onResponse = exports[onResponseFunc]
+	if !p.onRequest && !p.onResponse {
+		return fmt.Errorf("module exports neither %s nor %s", onRequestFunc, onResponseFunc)
+	}
+	p.compiled = compiled
+	return nil
+}
+
+// For returns the plugins that apply to tool, in configured order
+func (h *Host) For(tool string) []*Plugin {
+	if h == nil {
+		return nil
+	}
+	var plugins []*Plugin
+	for _, p := range h.plugins {
+		if p.cfg.AppliesTo(tool) {
+			plugins = append(plugins, p)
+		}
+	}
+	return plugins
+}
+
+// Close waits for running hooks and releases the plugins
+func (h *Host) Close() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	return h.runtime.Close(context.Background())
+}
+
+// Name returns the plugin's configured name
+func (p *Plugin) Name() string {
+	return p.cfg.Name
+}
+
+// FailOpen reports whether calls go through when the plugin fails
+func (p *Plugin) FailOpen() bool {
+	return p.cfg.FailOpen
+}
+
+// HandlesRequests reports whether the plugin has an on_request hook
+func (p *Plugin) HandlesRequests() bool {
+	return p.onRequest
+}
+
+// HandlesResponses reports whether the plugin has an on_response hook
+func (p *Plugin) HandlesResponses() bool {
+	return p.onResponse
+}
+
+// OnRequest runs the plugin's on_request hook
+func (p *Plugin) OnRequest(ctx context.Context, in RequestInput) (*Result, error) {
+	in.Config = p.cfg.Config
+	return p.call(ctx, onRequestFunc, in)
+}
+
+// OnResponse runs the plugin's on_response hook
+func (p *Plugin) OnResponse(ctx context.Context, in ResponseInput) (*Result, error) {
+	in.Config = p.cfg.Config
+	return p.call(ctx, onResponseFunc, in)
+}
+
+// call runs hook in a new instance of the plugin
+func (p *Plugin) call(ctx context.Context, hook string, input interface{}) (*Result, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	h := p.host
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return nil, errors.New("plugin was unloaded")
+	}
+
+	timeout := p.cfg.Timeout
+	if timeout == 0 {
+		timeout = config.DefaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	mod, err := h.runtime.InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithSysWalltime().
+		WithSysNanotime())
+	if err != nil {
+		return nil, hookError(ctx, hook, err, timeout)
+	}
+	defer mod.Close(context.Background())
+
+	res, err := mod.ExportedFunction(allocFunc).Call(ctx, uint64(len(data)))
+	if err != nil {
+		return nil, hookError(ctx, allocFunc, err, timeout)
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, data) {
+		return nil, fmt.Errorf("%s returned an address outside memory", allocFunc)
+	}
+
+	res, err = mod.ExportedFunction(hook).Call(ctx, uint64(ptr), uint64(len(data)))
+	if err != nil {
+		return nil, hookError(ctx, hook, err, timeout)
+	}
+	result := &Result{}
+	if res[0] == 0 {
+		return result, nil
+	}
+	out, ok := mod.Memory().Read(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return nil, fmt.Errorf("%s returned a result outside memory", hook)
+	}
+	if err := json.Unmarshal(out, result); err != nil {
+		return nil, fmt.Errorf("%s returned an invalid result: %w", hook, err)
+	}
+	return result, nil
+}
+
+// hookError reports a failed call, naming the timeout if it ran out
+func hookError(ctx context.Context, fn string, err error, timeout time.Duration) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s did not finish within %s", fn, timeout)
+	}
+	return fmt.Errorf("%s failed: %w", fn, err)
+}
@@ -0,0 +1,207 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// wasmModule assembles a plugin that exports its memory, an alloc that
+// always returns 1024 and the given hooks. data is placed at address 0.
+// Hooks are the instructions of a func(ptr, len i32) i64, nil to leave the
+// hook out.
+func wasmModule(data string, onRequest, onResponse []byte) []byte {
+	uleb := func(n int) []byte {
+		var out []byte
+		for {
+			b := byte(n & 0x7f)
+			if n >>= 7; n == 0 {
+				return append(out, b)
+			}
+			out = append(out, b|0x80)
+		}
+	}
+	vec := func(items ...[]byte) []byte {
+		out := uleb(len(items))
+		for _, item := range items {
+			out = append(out, item...)
+		}
+		return out
+	}
+	section := func(id byte, content []byte) []byte {
+		return append(append([]byte{id}, uleb(len(content))...), content...)
+	}
+	name := func(s string) []byte { return append(uleb(len(s)), s...) }
+	body := func(code []byte) []byte {
+		code = append(append([]byte{0x00}, code...), 0x0b)
+		return append(uleb(len(code)), code...)
+	}
+
+	funcs := [][]byte{{0x00}}
+	exports := [][]byte{append(name("memory"), 0x02, 0x00), append(name(allocFunc), 0x00, 0x00)}
+	code := [][]byte{body([]byte{0x41, 0x80, 0x08})} // i32.const 1024
+	for _, hook := range []struct {
+		name string
+		code []byte
+	}{{onRequestFunc, onRequest}, {onResponseFunc, onResponse}} {
+		if hook.code == nil {
+			continue
+		}
+		exports = append(exports, append(name(hook.name), 0x00, byte(len(funcs))))
+		funcs = append(funcs, []byte{0x01})
+		code = append(code, body(hook.code))
+	}
+
+	module := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(1, vec(
+		[]byte{0x60, 0x01, 0x7f, 0x01, 0x7f},       // (i32) -> i32
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e}, // (i32, i32) -> i64
+	))...)
+	module = append(module, section(3, vec(funcs...))...)
+	module = append(module, section(5, vec([]byte{0x00, 0x01}))...)
+	module = append(module, section(7, vec(exports...))...)
+	module = append(module, section(10, vec(code...))...)
+	module = append(module, section(11, vec(append([]byte{0x00, 0x41, 0x00, 0x0b}, name(data)...)))...)
+	return module
+}
+
+// Hook bodies
+var (
+	// echo returns its input as the result
+	echo = []byte{0x20, 0x00, 0xad, 0x42, 0x20, 0x86, 0x20, 0x01, 0xad, 0x84}
+	// spin never returns
+	spin = []byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42, 0x00}
+	// pass returns 0, leaving the call unchanged
+	pass = []byte{0x42, 0x00}
+)
+
+// result returns a hook body that returns length bytes at ptr
+func result(ptr, length int64) []byte {
+	v := ptr<<32 | length
+	out := []byte{0x42} // i64.const
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// writeModule writes module to a temp file and returns its path
+func writeModule(t *testing.T, module []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plugin.wasm")
+	if err := os.WriteFile(path, module, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHooks(t *testing.T) {
+	veto := `{"veto":{"code":"PLUGIN_DENIED","reason":"amount too high"}}`
+	rewrite := `{"status":502,"body":"hidden"}`
+	h := Load([]config.PluginConfig{
+		{Name: "echo", Path: writeModule(t, wasmModule("", echo, nil)), Config: map[string]interface{}{"mode": "strict"}},
+		{Name: "veto", Path: writeModule(t, wasmModule(veto+rewrite, result(0, int64(len(veto))), result(int64(len(veto)), int64(len(rewrite))))), Tools: []string{"payments"}},
+		{Name: "pass", Path: writeModule(t, wasmModule("", nil, pass))},
+	})
+	defer h.Close()
+	ctx := context.Background()
+
+	plugins := h.For("payments")
+	if len(plugins) != 3 {
+		t.Fatalf("got %d plugins for payments, want 3", len(plugins))
+	}
+	if others := h.For("reports"); len(others) != 2 {
+		t.Errorf("got %d plugins for reports, want 2", len(others))
+	}
+	echoPlugin, vetoPlugin, passPlugin := plugins[0], plugins[1], plugins[2]
+	if !echoPlugin.HandlesRequests() || echoPlugin.HandlesResponses() || passPlugin.HandlesRequests() || !passPlugin.HandlesResponses() {
+		t.Error("hooks don't match the module exports")
+	}
+
+	res, err := echoPlugin.OnRequest(ctx, RequestInput{AgentID: "finance-agent", Tool: "payments", Action: "create", Params: map[string]interface{}{"amount": 10.0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Params["amount"] != 10.0 || res.Veto != nil {
+		t.Errorf("echo: got %+v", res)
+	}
+
+	res, err = vetoPlugin.OnRequest(ctx, RequestInput{Tool: "payments", Action: "create"})
+	if err != nil || res.Veto == nil || res.Veto.Code != "PLUGIN_DENIED" {
+		t.Errorf("veto: got %+v, %v", res, err)
+	}
+	res, err = vetoPlugin.OnResponse(ctx, ResponseInput{Tool: "payments", Status: 200, Body: "secret"})
+	if err != nil || res.Status != 502 || res.Body == nil || *res.Body != "hidden" {
+		t.Errorf("response rewrite: got %+v, %v", res, err)
+	}
+
+	res, err = passPlugin.OnResponse(ctx, ResponseInput{Tool: "payments", Status: 200})
+	if err != nil || res.Veto != nil || res.Params != nil || res.Status != 0 || res.Body != nil {
+		t.Errorf("pass: got %+v, %v", res, err)
+	}
+}
+
+// Broken and slow plugins fail their calls, so the gateway rejects them
+// unless the plugin is fail_open
+func TestHookFailures(t *testing.T) {
+	h := Load([]config.PluginConfig{
+		{Name: "slow", Path: writeModule(t, wasmModule("", spin, nil)), Timeout: 20 * time.Millisecond},
+		{Name: "missing", Path: filepath.Join(t.TempDir(), "missing.wasm")},
+		{Name: "no-hooks", Path: writeModule(t, wasmModule("", nil, nil)), FailOpen: true},
+		{Name: "not-wasm", Path: writeModule(t, []byte("not a module"))},
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		plugin  string
+		wantErr string
+	}{
+		{"slow", "did not finish within 20ms"},
+		{"missing", "no such file"},
+		{"no-hooks", "exports neither"},
+		{"not-wasm", "invalid module"},
+	}
+	plugins := h.For("payments")
+	for i, tt := range tests {
+		p := plugins[i]
+		if p.Name() != tt.plugin {
+			t.Fatalf("plugin %d: got %s, want %s", i, p.Name(), tt.plugin)
+		}
+		if !p.HandlesRequests() {
+			t.Errorf("%s: doesn't handle requests", tt.plugin)
+		}
+		if _, err := p.OnRequest(ctx, RequestInput{Tool: "payments"}); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want %q", tt.plugin, err, tt.wantErr)
+		}
+	}
+	if !plugins[2].FailOpen() || plugins[0].FailOpen() {
+		t.Error("fail_open not reported")
+	}
+
+	h.Close()
+	if _, err := plugins[0].OnRequest(ctx, RequestInput{Tool: "payments"}); err == nil {
+		t.Error("hook ran after Close")
+	}
+}
+
+func TestNilHost(t *testing.T) {
+	h := Load(nil)
+	if h != nil {
+		t.Fatal("Load without plugins returned a host")
+	}
+	if plugins := h.For("payments"); plugins != nil {
+		t.Errorf("got %v", plugins)
+	}
+	if err := h.Close(); err != nil {
+		t.Error(err)
+	}
+}
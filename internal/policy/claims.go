@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CodeClaimMismatch is returned when the caller's token lacks a required claim
+const CodeClaimMismatch = "CLAIM_MISMATCH"
+
+// checkClaims requires the caller's verified token claims to match. Each
+// entry maps a claim name (dotted for nested claims) to a value or a list of
+// accepted values; list-valued claims such as groups match if any element
+// is accepted.
+func checkClaims(value interface{}, req *Request) *Violation {
+	required, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	names := make([]string, 0, len(required))
+	for name := range required {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		accepted := required[name]
+		claim, exists := lookupParam(req.Claims, name)
+		if !exists {
+			return violationf(CodeClaimMismatch, "Token claim %s is required", name)
+		}
+		if !claimMatches(claim, accepted) {
+			return violationf(CodeClaimMismatch, "Token claim %s does not match policy", name)
+		}
+	}
+	return nil
+}
+
+// claimMatches reports whether a claim value satisfies the accepted value(s)
+func claimMatches(claim, accepted interface{}) bool {
+	if values, ok := claim.([]interface{}); ok {
+		for _, v := range values {
+			if claimMatches(v, accepted) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if options, ok := accepted.([]interface{}); ok {
+		for _, option := range options {
+			if fmt.Sprint(option) == fmt.Sprint(claim) {
+				return true
+			}
+		}
+		return false
+	}
+	return fmt.Sprint(accepted) == fmt.Sprint(claim)
+}
+
+// validateClaimsCondition checks the shape of a claims condition
+func validateClaimsCondition(conditions map[string]interface{}) error {
+	value, ok := conditions["claims"]
+	if !ok {
+		return nil
+	}
+	if _, ok := value.(map[string]interface{}); !ok {
+		return fmt.Errorf("claims must map claim names to accepted values")
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stable deny codes returned alongside the human-readable reason
+const (
+	CodeActionNotAllowed   = "ACTION_NOT_ALLOWED"
+	CodeInvalidParameter   = "INVALID_PARAMETER"
+	CodeInvalidCondition   = "INVALID_CONDITION"
+	CodeConditionFailed    = "CONDITION_FAILED"
+	CodeMaxAmountExceeded  = "MAX_AMOUNT_EXCEEDED"
+	CodeCurrencyNotAllowed = "CURRENCY_NOT_ALLOWED"
+	CodePathPrefixMismatch = "PATH_PREFIX_MISMATCH"
+	CodeMethodNotAllowed   = "METHOD_NOT_ALLOWED"
+
+	// CodePolicyError and CodeNoPolicies mark decisions the engine could
+	// not make; see Decision.Failed
+	CodePolicyError = "POLICY_ERROR"
+	CodeNoPolicies  = "NO_POLICIES_LOADED"
+)
+
+// Violation describes why a condition denied a request
+type Violation struct {
+	Code   string
+	Reason string
+
+	// RetryAfter is set when the request may succeed if retried later
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (v *Violation) Error() string {
+	return v.Reason
+}
+
+// violationf builds a Violation with a formatted reason
+func violationf(code, format string, args ...interface{}) *Violation {
+	return &Violation{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Request is the input to a policy evaluation
+type Request struct {
+	AgentID string
+	Tool    string
+	Action  string
+	Params  map[string]interface{}
+
+	// Method is the HTTP method of the call; front-ends without one use POST
+	Method string
+
+	// Resource is the path below the action, e.g. "reports/q3.pdf" for
+	// /tools/files/read/reports/q3.pdf
+	Resource string
+
+	// BodySize is the length of the raw request body in bytes
+	BodySize int
+
+	// BodyIncomplete is set when Params were read from only part of the
+	// body, or without it, e.g. by ext_authz when Envoy truncates or
+	// doesn't send the body
+	BodyIncomplete bool
+
+	// Claims are the verified token claims of the caller, if any
+	Claims map[string]interface{}
+
+	// Groups are the policy groups the authenticated caller belongs to
+	Groups []string
+
+	// SessionID groups the calls of one agent run or conversation
+	SessionID string
+
+	// SessionGenerated is set when the agent sent no session ID and
+	// SessionID was made up for the call. session_limit then counts the
+	// call with the agent's other calls without a session.
+	SessionGenerated bool
+
+	// Malware lists the threats the upload scanner found, e.g.
+	// "Eicar-Test-Signature in document (invoice.pdf)"
+	Malware []string
+
+	// GraphQL is the operation of a call to a GraphQL tool, whose Params
+	// are the operation's variables
+	GraphQL *GraphQLOperation
+
+	// SQL is the statement of a call to a SQL tool
+	SQL *SQLStatement
+
+	// Exec is the command of a call to an exec tool
+	Exec *ExecCommand
+
+	// Files is the operation of a call to a files tool
+	Files *FileOperation
+
+	// Email is the message of a call to an email tool
+	Email *EmailMessage
+
+	// Fetch is the request of a call to a fetch tool
+	Fetch *FetchRequest
+
+	// state is where stateful conditions count usage, and dryRun is set
+	// when they must only check it
+	state  StateStore
+	dryRun bool
+
+	// onDenied holds the releases of usage reserved by conditions, run if
+	// the request ends up denied
+	onDenied []func()
+}
+
+// onDeny defers fn until the request is denied, so stateful conditions
+// don't consume quota for requests a later condition denies
+func (r *Request) onDeny(fn func()) {
+	r.onDenied = append(r.onDenied, fn)
+}
+
+// ConditionFunc checks a condition's configured value against the request
+// and returns a Violation if the request must be denied
+type ConditionFunc func(value interface{}, req *Request) *Violation
+
+// builtinConditions lists the conditions every engine starts with, in the
+// order they are checked
+var builtinConditions = []struct {
+	name string
+	fn   ConditionFunc
+}{
+	{"required_params", checkRequiredParams},
+	{"max_amount", checkMaxAmount},
+	{"currencies", checkCurrencies},
+	{"folder_prefix", checkFolderPrefix},
+	{"methods", checkMethods},
+	{"resource_prefix", checkResourcePrefix},
+	{"forbid_values", checkForbidValues},
+	{"param_not_matches", checkParamNotMatches},
+	{"max_body_bytes", checkMaxBodyBytes},
+	{"max_array_length", checkMaxArrayLength},
+	{"max_depth", checkMaxDepth},
+	{"schedule", checkSchedule},
+	{"claims", checkClaims},
+	{"malware", checkMalware},
+	{"graphql_operations", checkGraphQLOperations},
+	{"graphql_deny_fields", checkGraphQLDenyFields},
+	{"graphql_max_depth", checkGraphQLMaxDepth},
+	{"sql_tables", checkSQLTables},
+	{"sql_max_rows", checkSQLMaxRows},
+	{"exec_args", checkExecArgs},
+	{"exec_dirs", checkExecDirs},
+	{"exec_env", checkExecEnv},
+	{"files_root", checkFilesRoot},
+	{"files_extensions", checkFilesExtensions},
+	{"files_max_bytes", checkFilesMaxBytes},
+	{"email_domains", checkEmailDomains},
+	{"email_recipients", checkEmailRecipients},
+	{"email_attachment_types", checkEmailAttachmentTypes},
+	{"email_max_attachment_bytes", checkEmailMaxAttachmentBytes},
+	{"fetch_domains", checkFetchDomains},
+	{"fetch_schemes", checkFetchSchemes},
+	{"fetch_methods", checkFetchMethods},
+	{"fetch_max_response_bytes", checkFetchMaxResponseBytes},
+
+	// Stateful conditions count usage in the engine's StateStore
+	{"rate_limit", checkRateLimit},
+	{"budget", checkBudget},
+	{"session_limit", checkSessionLimit},
+	{"email_daily_limit", checkEmailDailyLimit},
+}
+
+// RegisterCondition adds a custom condition that policies can reference by
+// name. Custom conditions run after the built-in ones, in registration order.
+// Violations without a code are reported as CONDITION_FAILED.
+func (pe *PolicyEngine) RegisterCondition(name string, fn ConditionFunc) error {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	if _, exists := pe.conditions[name]; exists {
+		return fmt.Errorf("condition %s is already registered", name)
+	}
+	// Evaluations use the map and order they started with after releasing
+	// the lock, so both are replaced rather than changed
+	conditions := make(map[string]ConditionFunc, len(pe.conditions)+1)
+	for n, f := range pe.conditions {
+		conditions[n] = f
+	}
+	conditions[name] = fn
+	pe.conditions = conditions
+	pe.conditionOrder = append(pe.conditionOrder[:len(pe.conditionOrder):len(pe.conditionOrder)], name)
+	return nil
+}
+
+// registerBuiltinConditions installs the built-in conditions on the engine
+func (pe *PolicyEngine) registerBuiltinConditions() {
+	pe.conditions = make(map[string]ConditionFunc, len(builtinConditions))
+	for _, c := range builtinConditions {
+		pe.conditions[c.name] = c.fn
+		pe.conditionOrder = append(pe.conditionOrder, c.name)
+	}
+}
+
+// validateConditions checks the shape of built-in conditions at load time
+func validateConditions(conditions map[string]interface{}) error {
+	if err := validateNegativeConditions(conditions); err != nil {
+		return err
+	}
+	if err := validateClaimsCondition(conditions); err != nil {
+		return err
+	}
+	if err := validateMethodsCondition(conditions); err != nil {
+		return err
+	}
+	if err := validateMalwareCondition(conditions); err != nil {
+		return err
+	}
+	if err := validateGraphQLConditions(conditions); err != nil {
+		return err
+	}
+	if err := validateSQLConditions(conditions); err != nil {
+		return err
+	}
+	if err := validateExecConditions(conditions); err != nil {
+		return err
+	}
+	if err := validateFilesConditions(conditions); err != nil {
+		return err
+	}
+	if err := validateEmailConditions(conditions); err != nil {
+		return err
+	}
+	if err := validateFetchConditions(conditions); err != nil {
+		return err
+	}
+	return validateLimitConditions(conditions)
+}
+
+// validateMethodsCondition checks that methods lists only strings
+func validateMethodsCondition(conditions map[string]interface{}) error {
+	value, ok := conditions["methods"]
+	if !ok {
+		return nil
+	}
+	methods, ok := value.([]interface{})
+	if !ok || len(methods) == 0 {
+		return fmt.Errorf("methods must be a non-empty list of strings")
+	}
+	for _, m := range methods {
+		if _, ok := m.(string); !ok {
+			return fmt.Errorf("methods must be a non-empty list of strings")
+		}
+	}
+	return nil
+}
+
+// toFloat converts a numeric parameter or condition value to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// checkMaxAmount enforces an upper bound on the amount parameter
+func checkMaxAmount(maxAmount interface{}, req *Request) *Violation {
+	amount, exists := req.Params["amount"]
+	if !exists {
+		return nil
+	}
+
+	amountFloat, ok := toFloat(amount)
+	if !ok {
+		return violationf(CodeInvalidParameter, "amount must be a number")
+	}
+
+	maxFloat, ok := toFloat(maxAmount)
+	if !ok {
+		return violationf(CodeInvalidCondition, "max_amount must be a number")
+	}
+
+	if amountFloat > maxFloat {
+		return violationf(CodeMaxAmountExceeded, "Amount exceeds max_amount=%.0f", maxFloat)
+	}
+	return nil
+}
+
+// maxAmount returns a rule's max_amount, or 0 when it has none
+func maxAmount(conditions map[string]interface{}) float64 {
+	limit, _ := toFloat(conditions["max_amount"])
+	return limit
+}
+
+// checkCurrencies restricts the currency parameter to an allowed list
+func checkCurrencies(value interface{}, req *Request) *Violation {
+	currencies, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	currency, exists := req.Params["currency"]
+	if !exists {
+		return nil
+	}
+
+	currencyStr, ok := currency.(string)
+	if !ok {
+		return violationf(CodeInvalidParameter, "currency must be a string")
+	}
+
+	for _, c := range currencies {
+		if cStr, ok := c.(string); ok && cStr == currencyStr {
+			return nil
+		}
+	}
+	return violationf(CodeCurrencyNotAllowed, "Currency %s not in allowed currencies", currencyStr)
+}
+
+// checkMethods restricts the HTTP method of the call to an allowed list
+func checkMethods(value interface{}, req *Request) *Violation {
+	methods, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "methods must be a list of strings")
+	}
+
+	for _, m := range methods {
+		if mStr, ok := m.(string); ok && strings.EqualFold(mStr, req.Method) {
+			return nil
+		}
+	}
+	return violationf(CodeMethodNotAllowed, "Method %s not in allowed methods", req.Method)
+}
+
+// checkResourcePrefix requires the resource path below the action to be
+// the prefix or below it. The prefix ends at a segment boundary, so
+// reports matches reports/q3.pdf but not reports-secret/q3.pdf.
+func checkResourcePrefix(value interface{}, req *Request) *Violation {
+	prefix, ok := value.(string)
+	if !ok {
+		return violationf(CodeInvalidCondition, "resource_prefix must be a string")
+	}
+
+	dir := strings.TrimSuffix(strings.TrimPrefix(prefix, "/"), "/")
+	if dir != "" && req.Resource != dir && !strings.HasPrefix(req.Resource, dir+"/") {
+		return violationf(CodePathPrefixMismatch, "Resource must start with prefix %s", prefix)
+	}
+	return nil
+}
+
+// checkFolderPrefix requires the path parameter to start with a prefix
+func checkFolderPrefix(value interface{}, req *Request) *Violation {
+	prefix, ok := value.(string)
+	if !ok {
+		return nil
+	}
+
+	path, exists := req.Params["path"]
+	if !exists {
+		return nil
+	}
+
+	pathStr, ok := path.(string)
+	if !ok {
+		return violationf(CodeInvalidParameter, "path must be a string")
+	}
+
+	if len(pathStr) < len(prefix) || pathStr[:len(prefix)] != prefix {
+		return violationf(CodePathPrefixMismatch, "Path must start with prefix %s", prefix)
+	}
+	return nil
+}
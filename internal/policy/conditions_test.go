@@ -0,0 +1,28 @@
+package policy
+
+import "testing"
+
+func TestResourcePrefix(t *testing.T) {
+	tests := []struct {
+		prefix, resource string
+		allowed          bool
+	}{
+		{"reports/", "reports/q3.pdf", true},
+		{"reports", "reports/q3.pdf", true},
+		{"/reports", "reports/2024/q3.pdf", true},
+		{"reports", "reports", true},
+		{"reports/", "reports", true},
+		{"reports", "reports-secret/q3.pdf", false},
+		{"reports/q3", "reports/q3-draft.pdf", false},
+		{"reports/", "", false},
+		{"/", "anything", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix+" "+tt.resource, func(t *testing.T) {
+			violation := checkResourcePrefix(tt.prefix, &Request{Resource: tt.resource})
+			if (violation == nil) != tt.allowed {
+				t.Fatalf("got %v", violation)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CodeMissingParameter is returned when a required parameter is absent
+const CodeMissingParameter = "MISSING_PARAMETER"
+
+// ToolDefaults declares conditions that apply to every allowance for a tool
+type ToolDefaults struct {
+	Name       string                 `yaml:"name" json:"name"`
+	Conditions map[string]interface{} `yaml:"conditions" json:"conditions,omitempty"`
+}
+
+// rebuildToolDefaultsLocked merges the tools sections of all loaded policy
+// files. Files are merged in source order, so when two files set the same
+// condition for a tool the later file wins. Callers must hold pe.mu.
+func (pe *PolicyEngine) rebuildToolDefaultsLocked() {
+	sources := make([]string, 0, len(pe.policies))
+	for source := range pe.policies {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	defaults := make(map[string]map[string]interface{})
+	for _, source := range sources {
+		for _, tool := range pe.policies[source].Tools {
+			merged, ok := defaults[tool.Name]
+			if !ok {
+				merged = make(map[string]interface{})
+				defaults[tool.Name] = merged
+			}
+			for name, value := range tool.Conditions {
+				merged[name] = value
+			}
+		}
+	}
+	pe.toolDefaults = defaults
+}
+
+// effectiveConditions overlays an allowance's own conditions on the tool
+// defaults. Conditions set on the allowance take precedence. Callers must
+// hold pe.mu.
+func (pe *PolicyEngine) effectiveConditions(allow *ToolAllowance) map[string]interface{} {
+	defaults := pe.toolDefaults[allow.Tool]
+	if len(defaults) == 0 {
+		return allow.Conditions
+	}
+
+	merged := make(map[string]interface{}, len(defaults)+len(allow.Conditions))
+	for name, value := range defaults {
+		merged[name] = value
+	}
+	for name, value := range allow.Conditions {
+		merged[name] = value
+	}
+	return merged
+}
+
+// validateToolDefaults checks the tools section of a policy file
+func validateToolDefaults(tools []ToolDefaults) error {
+	for _, tool := range tools {
+		if tool.Name == "" {
+			return fmt.Errorf("tool defaults require a name")
+		}
+		if err := validateConditions(tool.Conditions); err != nil {
+			return fmt.Errorf("tool defaults for %s: %w", tool.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkRequiredParams denies requests that omit any listed parameter:
+//
+//	required_params: [currency]
+func checkRequiredParams(value interface{}, req *Request) *Violation {
+	fields, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "required_params must be a list")
+	}
+
+	for _, f := range fields {
+		field, ok := f.(string)
+		if !ok {
+			return violationf(CodeInvalidCondition, "required_params entries must be strings")
+		}
+		if _, exists := lookupParam(req.Params, field); !exists {
+			return violationf(CodeMissingParameter, "Missing required parameter %s", field)
+		}
+	}
+	return nil
+}
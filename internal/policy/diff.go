@@ -0,0 +1,191 @@
+package policy
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Change types reported in a RuleChange
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// RuleChange describes how a single agent/tool allowance, or the defaults
+// the file's tools section sets for a tool, changed on reload. Changes to
+// defaults have no AgentID or Group and set DefaultsBefore and
+// DefaultsAfter instead of Before and After.
+type RuleChange struct {
+	Type    string         `json:"type"`
+	AgentID string         `json:"agent_id,omitempty"`
+	Group   string         `json:"group,omitempty"`
+	Tool    string         `json:"tool"`
+	Before  *ToolAllowance `json:"before,omitempty"`
+	After   *ToolAllowance `json:"after,omitempty"`
+
+	DefaultsBefore *ToolDefaults `json:"defaults_before,omitempty"`
+	DefaultsAfter  *ToolDefaults `json:"defaults_after,omitempty"`
+}
+
+// ChangeEvent is published whenever a policy file is loaded, reloaded or removed
+type ChangeEvent struct {
+	Source    string       `json:"source"`
+	Timestamp time.Time    `json:"timestamp"`
+	Changes   []RuleChange `json:"changes"`
+}
+
+// ChangeHandler receives policy change events
+type ChangeHandler func(ChangeEvent)
+
+// subscribers holds registered change handlers keyed by subscription ID
+type subscribers struct {
+	mu       sync.Mutex
+	nextID   int
+	handlers map[int]ChangeHandler
+}
+
+// Subscribe registers fn to be called after every policy change that alters
+// at least one rule. The returned function removes the subscription.
+func (pe *PolicyEngine) Subscribe(fn ChangeHandler) (unsubscribe func()) {
+	pe.subs.mu.Lock()
+	defer pe.subs.mu.Unlock()
+
+	if pe.subs.handlers == nil {
+		pe.subs.handlers = make(map[int]ChangeHandler)
+	}
+	id := pe.subs.nextID
+	pe.subs.nextID++
+	pe.subs.handlers[id] = fn
+
+	return func() {
+		pe.subs.mu.Lock()
+		delete(pe.subs.handlers, id)
+		pe.subs.mu.Unlock()
+	}
+}
+
+// publish logs the event and delivers it to every subscriber
+func (pe *PolicyEngine) publish(event ChangeEvent) {
+	if len(event.Changes) == 0 {
+		return
+	}
+
+	changes, _ := json.Marshal(event.Changes)
+	logger.Info("Policy change", "source", event.Source, "changes", json.RawMessage(changes))
+
+	pe.subs.mu.Lock()
+	handlers := make([]ChangeHandler, 0, len(pe.subs.handlers))
+	for _, fn := range pe.subs.handlers {
+		handlers = append(handlers, fn)
+	}
+	pe.subs.mu.Unlock()
+
+	for _, fn := range handlers {
+		fn(event)
+	}
+}
+
+// diffPolicies computes the rule-level changes between two versions of a
+// policy file. Either side may be nil for an added or removed file.
+func diffPolicies(before, after *Policy) []RuleChange {
+	oldRules := indexRules(before)
+	newRules := indexRules(after)
+
+	var changes []RuleChange
+	for key, oldRule := range oldRules {
+		newRule, ok := newRules[key]
+		if !ok {
+			changes = append(changes, RuleChange{Type: ChangeRemoved, AgentID: key.agentID, Group: key.group, Tool: key.tool, Before: oldRule})
+			continue
+		}
+		if !reflect.DeepEqual(oldRule, newRule) {
+			changes = append(changes, RuleChange{Type: ChangeChanged, AgentID: key.agentID, Group: key.group, Tool: key.tool, Before: oldRule, After: newRule})
+		}
+	}
+	for key, newRule := range newRules {
+		if _, ok := oldRules[key]; !ok {
+			changes = append(changes, RuleChange{Type: ChangeAdded, AgentID: key.agentID, Group: key.group, Tool: key.tool, After: newRule})
+		}
+	}
+
+	oldDefaults := indexDefaults(before)
+	newDefaults := indexDefaults(after)
+	for key, oldDefault := range oldDefaults {
+		newDefault, ok := newDefaults[key]
+		if !ok {
+			changes = append(changes, RuleChange{Type: ChangeRemoved, Tool: key.tool, DefaultsBefore: oldDefault})
+			continue
+		}
+		if !reflect.DeepEqual(oldDefault, newDefault) {
+			changes = append(changes, RuleChange{Type: ChangeChanged, Tool: key.tool, DefaultsBefore: oldDefault, DefaultsAfter: newDefault})
+		}
+	}
+	for key, newDefault := range newDefaults {
+		if _, ok := oldDefaults[key]; !ok {
+			changes = append(changes, RuleChange{Type: ChangeAdded, Tool: key.tool, DefaultsAfter: newDefault})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].AgentID != changes[j].AgentID {
+			return changes[i].AgentID < changes[j].AgentID
+		}
+		if changes[i].Group != changes[j].Group {
+			return changes[i].Group < changes[j].Group
+		}
+		if changes[i].Tool != changes[j].Tool {
+			return changes[i].Tool < changes[j].Tool
+		}
+		return changes[i].Type < changes[j].Type
+	})
+	return changes
+}
+
+// ruleKey identifies an allowance within a policy file. Repeated allowances
+// for the same agent and tool are distinguished by their occurrence index.
+type ruleKey struct {
+	agentID string
+	group   string
+	tool    string
+	index   int
+}
+
+// indexRules flattens a policy into its allowances keyed by ruleKey
+func indexRules(p *Policy) map[ruleKey]*ToolAllowance {
+	rules := make(map[ruleKey]*ToolAllowance)
+	if p == nil {
+		return rules
+	}
+
+	seen := make(map[[3]string]int)
+	for _, agent := range p.Agents {
+		for i := range agent.Allow {
+			allow := agent.Allow[i]
+			pair := [3]string{agent.ID, agent.Group, allow.Tool}
+			rules[ruleKey{agentID: agent.ID, group: agent.Group, tool: allow.Tool, index: seen[pair]}] = &allow
+			seen[pair]++
+		}
+	}
+	return rules
+}
+
+// indexDefaults flattens a policy's tools section keyed by ruleKey, with
+// only the tool and occurrence index set
+func indexDefaults(p *Policy) map[ruleKey]*ToolDefaults {
+	defaults := make(map[ruleKey]*ToolDefaults)
+	if p == nil {
+		return defaults
+	}
+
+	seen := make(map[string]int)
+	for i := range p.Tools {
+		tool := p.Tools[i]
+		defaults[ruleKey{tool: tool.Name, index: seen[tool.Name]}] = &tool
+		seen[tool.Name]++
+	}
+	return defaults
+}
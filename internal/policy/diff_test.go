@@ -0,0 +1,90 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiffPolicies(t *testing.T) {
+	allowance := func(conditions map[string]interface{}) *Policy {
+		return &Policy{Agents: []AgentPolicy{{ID: "finance-agent", Allow: []ToolAllowance{{Tool: "payments", Actions: []string{"create"}, Conditions: conditions}}}}}
+	}
+	withDefaults := func(p *Policy, conditions map[string]interface{}) *Policy {
+		p.Tools = []ToolDefaults{{Name: "payments", Conditions: conditions}}
+		return p
+	}
+
+	tests := []struct {
+		name          string
+		before, after *Policy
+		want          []RuleChange
+	}{
+		{"unchanged", allowance(nil), allowance(nil), nil},
+		{"rule added", nil, allowance(nil), []RuleChange{{Type: ChangeAdded, AgentID: "finance-agent", Tool: "payments"}}},
+		{"rule changed", allowance(nil), allowance(map[string]interface{}{"max_amount": 10}), []RuleChange{{Type: ChangeChanged, AgentID: "finance-agent", Tool: "payments"}}},
+		{"defaults added", allowance(nil), withDefaults(allowance(nil), map[string]interface{}{"max_amount": 10}), []RuleChange{{Type: ChangeAdded, Tool: "payments"}}},
+		{"defaults changed", withDefaults(allowance(nil), map[string]interface{}{"max_amount": 10}), withDefaults(allowance(nil), map[string]interface{}{"max_amount": 20}), []RuleChange{{Type: ChangeChanged, Tool: "payments"}}},
+		{"defaults removed", withDefaults(allowance(nil), map[string]interface{}{"max_amount": 10}), allowance(nil), []RuleChange{{Type: ChangeRemoved, Tool: "payments"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffPolicies(tt.before, tt.after)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v", got)
+			}
+			for i, want := range tt.want {
+				c := got[i]
+				if c.Type != want.Type || c.AgentID != want.AgentID || c.Tool != want.Tool {
+					t.Fatalf("change %d: got %+v, want %+v", i, c, want)
+				}
+				isDefaults := c.DefaultsBefore != nil || c.DefaultsAfter != nil
+				if isDefaults != (want.AgentID == "") {
+					t.Fatalf("change %d: got %+v", i, c)
+				}
+			}
+		})
+	}
+}
+
+// Editing only a tools section changes every agent's effective rule for
+// the tool, so subscribers must hear about it
+func TestReloadPublishesDefaultsChanges(t *testing.T) {
+	const rules = `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create]
+`
+	pe := newTestEngine(t, rules)
+	events := make(chan ChangeEvent, 1)
+	// The watcher may see the write too
+	pe.Subscribe(func(e ChangeEvent) {
+		select {
+		case events <- e:
+		default:
+		}
+	})
+
+	updated := rules + `tools:
+  - name: payments
+    conditions:
+      required_params: [currency]
+`
+	if err := os.WriteFile(filepath.Join(pe.baseDir, "policy.yaml"), []byte(updated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := pe.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if len(e.Changes) != 1 || e.Changes[0].Tool != "payments" || e.Changes[0].DefaultsAfter == nil {
+			t.Fatalf("got %+v", e.Changes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change event was published")
+	}
+}
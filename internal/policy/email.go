@@ -0,0 +1,205 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Deny codes for email conditions
+const (
+	CodeEmailRecipientNotAllowed  = "EMAIL_RECIPIENT_NOT_ALLOWED"
+	CodeEmailAttachmentNotAllowed = "EMAIL_ATTACHMENT_NOT_ALLOWED"
+	CodeEmailAttachmentTooLarge   = "EMAIL_ATTACHMENT_TOO_LARGE"
+	CodeEmailDailyLimit           = "EMAIL_DAILY_LIMIT_EXCEEDED"
+)
+
+// emailDailyWindow is the window email_daily_limit counts messages in
+const emailDailyWindow = 24 * time.Hour
+
+// EmailMessage is the message a call to an email tool sends
+type EmailMessage struct {
+	// Recipients are the lowercased addresses of the To, Cc and Bcc
+	// recipients
+	Recipients []string
+
+	Attachments []EmailAttachment
+}
+
+// EmailAttachment is a file attached to an email
+type EmailAttachment struct {
+	Filename string
+
+	// Size is the decoded size in bytes
+	Size int
+}
+
+// checkEmailDomains restricts recipients to the listed domains. An entry
+// starting with *. matches subdomains only:
+//
+//	email_domains: [example.com, "*.example.com"]
+func checkEmailDomains(value interface{}, req *Request) *Violation {
+	if req.Email == nil {
+		return nil
+	}
+	domains, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "email_domains must be a list of domains")
+	}
+	for _, rcpt := range req.Email.Recipients {
+		domain := rcpt[strings.LastIndex(rcpt, "@")+1:]
+		allowed := false
+		for _, d := range domains {
+			if s, ok := d.(string); ok && domainMatches(domain, s) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return violationf(CodeEmailRecipientNotAllowed, "Recipient %s is not in email_domains", rcpt)
+		}
+	}
+	return nil
+}
+
+// checkEmailRecipients restricts recipients to the listed addresses,
+// compared case-insensitively
+func checkEmailRecipients(value interface{}, req *Request) *Violation {
+	if req.Email == nil {
+		return nil
+	}
+	addresses, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "email_recipients must be a list of addresses")
+	}
+	for _, rcpt := range req.Email.Recipients {
+		allowed := false
+		for _, a := range addresses {
+			if s, ok := a.(string); ok && strings.EqualFold(s, rcpt) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return violationf(CodeEmailRecipientNotAllowed, "Recipient %s is not in email_recipients", rcpt)
+		}
+	}
+	return nil
+}
+
+// checkEmailAttachmentTypes restricts attachments to the listed file
+// extensions, compared case-insensitively:
+//
+//	email_attachment_types: [.pdf, .csv]
+func checkEmailAttachmentTypes(value interface{}, req *Request) *Violation {
+	if req.Email == nil {
+		return nil
+	}
+	extensions, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "email_attachment_types must be a list of extensions")
+	}
+	for _, a := range req.Email.Attachments {
+		ext := path.Ext(a.Filename)
+		allowed := false
+		for _, e := range extensions {
+			if s, ok := e.(string); ok && ext != "" && strings.EqualFold(s, ext) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return violationf(CodeEmailAttachmentNotAllowed, "Attachment %s does not have an extension in email_attachment_types", a.Filename)
+		}
+	}
+	return nil
+}
+
+// checkEmailMaxAttachmentBytes caps the total size of a message's
+// attachments
+func checkEmailMaxAttachmentBytes(value interface{}, req *Request) *Violation {
+	if req.Email == nil {
+		return nil
+	}
+	limit, ok := toFloat(value)
+	if !ok {
+		return violationf(CodeInvalidCondition, "email_max_attachment_bytes must be a number")
+	}
+	total := 0
+	for _, a := range req.Email.Attachments {
+		total += a.Size
+	}
+	if float64(total) > limit {
+		return violationf(CodeEmailAttachmentTooLarge, "Attachments of %d bytes exceed email_max_attachment_bytes=%.0f", total, limit)
+	}
+	return nil
+}
+
+// checkEmailDailyLimit caps the messages an agent sends through a tool per
+// 24 hours, counted from its first message of the window:
+//
+//	email_daily_limit: 50
+func checkEmailDailyLimit(value interface{}, req *Request) *Violation {
+	if req.Email == nil {
+		return nil
+	}
+	limit, ok := toFloat(value)
+	if !ok {
+		return violationf(CodeInvalidCondition, "email_daily_limit must be a number")
+	}
+
+	reserved, retryAfter, err := reserve(req, limitKey("email", req), emailDailyWindow, limit, 1)
+	if err != nil {
+		return violationf(CodePolicyError, "Usage for email_daily_limit is unavailable: %v", err)
+	}
+	if !reserved {
+		v := violationf(CodeEmailDailyLimit, "Daily limit of %.0f emails exceeded", limit)
+		v.RetryAfter = retryAfter
+		return v
+	}
+	return nil
+}
+
+// validateEmailConditions checks the shape of the email conditions at load
+// time
+func validateEmailConditions(conditions map[string]interface{}) error {
+	for _, name := range []string{"email_domains", "email_recipients", "email_attachment_types"} {
+		value, ok := conditions[name]
+		if !ok {
+			continue
+		}
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return fmt.Errorf("%s must be a non-empty list of strings", name)
+		}
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok || s == "" {
+				return fmt.Errorf("%s must be a non-empty list of strings", name)
+			}
+			switch name {
+			case "email_domains":
+				if strings.Contains(s, "@") {
+					return fmt.Errorf("email_domains entry %q must be a domain, not an address", s)
+				}
+			case "email_recipients":
+				if !strings.Contains(s, "@") {
+					return fmt.Errorf("email_recipients entry %q must be an address", s)
+				}
+			case "email_attachment_types":
+				if !strings.HasPrefix(s, ".") {
+					return fmt.Errorf("email_attachment_types entry %q must be an extension such as .pdf", s)
+				}
+			}
+		}
+	}
+	for _, name := range []string{"email_max_attachment_bytes", "email_daily_limit"} {
+		if value, ok := conditions[name]; ok {
+			if n, ok := toFloat(value); !ok || n < 0 {
+				return fmt.Errorf("%s must be a non-negative number", name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package policy
+
+import "testing"
+
+func TestEmailConditions(t *testing.T) {
+	pe := newTestEngine(t, `version: "1"
+agents:
+  - id: support-agent
+    allow:
+      - tool: mail
+        actions: [send]
+        conditions:
+          email_domains: [example.com, "*.example.com"]
+          email_attachment_types: [.pdf]
+          email_max_attachment_bytes: 1000
+          email_daily_limit: 2
+`)
+
+	tests := []struct {
+		name     string
+		message  *EmailMessage
+		wantCode string
+	}{
+		{"allowed", &EmailMessage{Recipients: []string{"a@example.com", "b@eu.example.com"}}, ""},
+		{"recipient outside domains", &EmailMessage{Recipients: []string{"a@example.com", "b@example.org"}}, CodeEmailRecipientNotAllowed},
+		{"lookalike domain", &EmailMessage{Recipients: []string{"a@notexample.com"}}, CodeEmailRecipientNotAllowed},
+		{"attachment type", &EmailMessage{Recipients: []string{"a@example.com"}, Attachments: []EmailAttachment{{Filename: "run.exe", Size: 10}}}, CodeEmailAttachmentNotAllowed},
+		{"attachment without extension", &EmailMessage{Recipients: []string{"a@example.com"}, Attachments: []EmailAttachment{{Filename: "pdf", Size: 10}}}, CodeEmailAttachmentNotAllowed},
+		{"attachments too large", &EmailMessage{Recipients: []string{"a@example.com"}, Attachments: []EmailAttachment{{Filename: "a.PDF", Size: 600}, {Filename: "b.pdf", Size: 600}}}, CodeEmailAttachmentTooLarge},
+	}
+	for _, tt := range tests {
+		d := pe.EvaluateRequest(&Request{AgentID: "support-agent", Tool: "mail", Action: "send", Email: tt.message})
+		if d.Code != tt.wantCode || d.Allowed != (tt.wantCode == "") {
+			t.Errorf("%s: got allowed=%v code=%q, want code %q", tt.name, d.Allowed, d.Code, tt.wantCode)
+		}
+	}
+
+	// Only the first allowed message above counted against the daily limit
+	send := func() Decision {
+		return pe.EvaluateRequest(&Request{AgentID: "support-agent", Tool: "mail", Action: "send", Email: &EmailMessage{Recipients: []string{"a@example.com"}}})
+	}
+	if d := send(); !d.Allowed {
+		t.Fatalf("second message denied: %s", d.Code)
+	}
+	if d := send(); d.Code != CodeEmailDailyLimit {
+		t.Fatalf("third message got %q", d.Code)
+	}
+}
@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Deny codes for exec conditions
+const (
+	CodeExecArgNotAllowed = "EXEC_ARG_NOT_ALLOWED"
+	CodeExecDirNotAllowed = "EXEC_DIR_NOT_ALLOWED"
+	CodeExecEnvNotAllowed = "EXEC_ENV_NOT_ALLOWED"
+)
+
+// ExecCommand is the command a call to an exec tool runs
+type ExecCommand struct {
+	// Binary is the name the tool registers the executable under; it is
+	// also the call's action
+	Binary string
+
+	// Args are the arguments, without the binary itself
+	Args []string
+
+	// Dir is the absolute working directory, with symbolic links resolved
+	Dir string
+
+	// Env lists the names of the environment variables the call sets
+	Env []string
+}
+
+// checkExecArgs requires every argument to fully match one of the listed
+// regular expressions:
+//
+//	exec_args: ["--oneline", "-n", "[0-9]+", "[a-z0-9_./-]+"]
+func checkExecArgs(value interface{}, req *Request) *Violation {
+	if req.Exec == nil {
+		return nil
+	}
+	patterns, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "exec_args must be a list of patterns")
+	}
+	for _, arg := range req.Exec.Args {
+		matched := false
+		for _, p := range patterns {
+			s, _ := p.(string)
+			re, err := compilePattern("^(?:" + s + ")$")
+			if err != nil {
+				return violationf(CodeInvalidCondition, "invalid exec_args pattern %q", s)
+			}
+			if re.MatchString(arg) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return violationf(CodeExecArgNotAllowed, "Argument %q to %s matches no exec_args pattern", arg, req.Exec.Binary)
+		}
+	}
+	return nil
+}
+
+// checkExecDirs restricts the working directory to the listed directories
+// and their subdirectories. The gateway resolves symbolic links in the
+// working directory before the check, so a link can't lead out of them.
+func checkExecDirs(value interface{}, req *Request) *Violation {
+	if req.Exec == nil {
+		return nil
+	}
+	dirs, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "exec_dirs must be a list of directories")
+	}
+	if req.Exec.Dir == "" {
+		return violationf(CodeExecDirNotAllowed, "%s has no working directory in exec_dirs", req.Exec.Binary)
+	}
+	for _, d := range dirs {
+		s, _ := d.(string)
+		if s == "" {
+			continue
+		}
+		if s = strings.TrimSuffix(s, "/"); req.Exec.Dir == s || strings.HasPrefix(req.Exec.Dir, s+"/") {
+			return nil
+		}
+	}
+	return violationf(CodeExecDirNotAllowed, "Working directory %s is not in exec_dirs", req.Exec.Dir)
+}
+
+// LoaderEnvName reports whether name is a dynamic loader variable, such as
+// LD_PRELOAD or DYLD_INSERT_LIBRARIES, which can change what a command runs
+// and which no call may set
+func LoaderEnvName(name string) bool {
+	name = strings.ToUpper(name)
+	return strings.HasPrefix(name, "LD_") || strings.HasPrefix(name, "DYLD_")
+}
+
+// checkExecEnv restricts the environment variables a call may set to the
+// listed names. Dynamic loader variables are denied even when listed.
+func checkExecEnv(value interface{}, req *Request) *Violation {
+	if req.Exec == nil {
+		return nil
+	}
+	names, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "exec_env must be a list of variable names")
+	}
+	for _, name := range req.Exec.Env {
+		if LoaderEnvName(name) {
+			return violationf(CodeExecEnvNotAllowed, "Environment variable %s can't be set by calls", name)
+		}
+		found := false
+		for _, n := range names {
+			if s, ok := n.(string); ok && s == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return violationf(CodeExecEnvNotAllowed, "Environment variable %s is not in exec_env", name)
+		}
+	}
+	return nil
+}
+
+// validateExecConditions checks the shape of the exec conditions at load
+// time
+func validateExecConditions(conditions map[string]interface{}) error {
+	for _, name := range []string{"exec_args", "exec_dirs", "exec_env"} {
+		value, ok := conditions[name]
+		if !ok {
+			continue
+		}
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be a list of strings", name)
+		}
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok || s == "" {
+				return fmt.Errorf("%s must be a list of strings", name)
+			}
+			switch name {
+			case "exec_args":
+				if _, err := compilePattern("^(?:" + s + ")$"); err != nil {
+					return fmt.Errorf("invalid exec_args pattern %q: %w", s, err)
+				}
+			case "exec_dirs":
+				if !strings.HasPrefix(s, "/") {
+					return fmt.Errorf("exec_dirs entry %q must be an absolute path", s)
+				}
+			case "exec_env":
+				if LoaderEnvName(s) {
+					return fmt.Errorf("exec_env entry %q is a dynamic loader variable, which calls can't set", s)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package policy
+
+import "testing"
+
+func TestExecEnv(t *testing.T) {
+	pe := newTestEngine(t, `version: "1"
+agents:
+  - id: ci-agent
+    allow:
+      - tool: shell
+        actions: [git]
+        conditions:
+          exec_dirs: [/srv]
+      - tool: build
+        actions: [make]
+        conditions:
+          exec_dirs: [/srv]
+          exec_env: [GIT_AUTHOR_NAME]
+`)
+
+	tests := []struct {
+		tool, binary string
+		env          []string
+		wantCode     string
+	}{
+		{"shell", "git", nil, ""},
+		{"shell", "git", []string{"GIT_AUTHOR_NAME"}, CodeExecEnvNotAllowed},
+		{"shell", "git", []string{"LD_PRELOAD"}, CodeExecEnvNotAllowed},
+		{"build", "make", []string{"GIT_AUTHOR_NAME"}, ""},
+		{"build", "make", []string{"HOME"}, CodeExecEnvNotAllowed},
+		{"build", "make", []string{"DYLD_INSERT_LIBRARIES"}, CodeExecEnvNotAllowed},
+	}
+	for _, tt := range tests {
+		d := pe.EvaluateRequest(&Request{
+			AgentID: "ci-agent",
+			Tool:    tt.tool,
+			Action:  tt.binary,
+			Exec:    &ExecCommand{Binary: tt.binary, Dir: "/srv", Env: tt.env},
+		})
+		if d.Code != tt.wantCode || d.Allowed != (tt.wantCode == "") {
+			t.Errorf("%s/%s with %v: got allowed=%v code=%q, want code %q", tt.tool, tt.binary, tt.env, d.Allowed, d.Code, tt.wantCode)
+		}
+	}
+}
+
+// A rule that leaves out exec_args or exec_dirs allows no arguments or no
+// working directory, rather than any
+func TestExecDefaults(t *testing.T) {
+	pe := newTestEngine(t, `version: "1"
+agents:
+  - id: ci-agent
+    allow:
+      - tool: shell
+        actions: [git]
+        conditions:
+          exec_dirs: [/srv/repo]
+      - tool: build
+        actions: [make]
+        conditions:
+          exec_args: [test, lint]
+`)
+
+	tests := []struct {
+		tool     string
+		exec     *ExecCommand
+		wantCode string
+	}{
+		{"shell", &ExecCommand{Binary: "git", Dir: "/srv/repo"}, ""},
+		{"shell", &ExecCommand{Binary: "git", Args: []string{"status"}, Dir: "/srv/repo"}, CodeExecArgNotAllowed},
+		{"shell", &ExecCommand{Binary: "git", Args: []string{"--upload-pack=sh"}, Dir: "/srv/repo"}, CodeExecArgNotAllowed},
+		{"shell", &ExecCommand{Binary: "git", Dir: "/srv/repo-other"}, CodeExecDirNotAllowed},
+		{"build", &ExecCommand{Binary: "make", Args: []string{"test"}}, CodeExecDirNotAllowed},
+		{"build", &ExecCommand{Binary: "make", Args: []string{"test"}, Dir: "/srv/repo"}, CodeExecDirNotAllowed},
+		{"build", &ExecCommand{Binary: "make", Args: []string{"install"}, Dir: "/srv/repo"}, CodeExecArgNotAllowed},
+	}
+	for _, tt := range tests {
+		d := pe.EvaluateRequest(&Request{AgentID: "ci-agent", Tool: tt.tool, Action: tt.exec.Binary, Exec: tt.exec})
+		if d.Code != tt.wantCode || d.Allowed != (tt.wantCode == "") {
+			t.Errorf("%s %v in %q: got allowed=%v code=%q, want code %q", tt.exec.Binary, tt.exec.Args, tt.exec.Dir, d.Allowed, d.Code, tt.wantCode)
+		}
+	}
+}
+
+func TestExecEnvRejectsLoaderVariables(t *testing.T) {
+	for _, name := range []string{"LD_PRELOAD", "LD_LIBRARY_PATH", "DYLD_INSERT_LIBRARIES", "ld_preload"} {
+		conditions := map[string]interface{}{"exec_env": []interface{}{name}}
+		if err := validateExecConditions(conditions); err == nil {
+			t.Errorf("exec_env %s accepted", name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package policy
+
+import (
+	"sort"
+	"time"
+)
+
+// Snapshot is a point-in-time view of the merged, currently-active policy set
+type Snapshot struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Files       int            `json:"files"`
+	Rules       []ExportedRule `json:"rules"`
+}
+
+// ExportedRule is a single agent/tool allowance together with its provenance
+type ExportedRule struct {
+	AgentID       string                 `json:"agent_id,omitempty"`
+	Group         string                 `json:"group,omitempty"`
+	Tool          string                 `json:"tool"`
+	Actions       []string               `json:"actions"`
+	Conditions    map[string]interface{} `json:"conditions,omitempty"`
+	Rollout       string                 `json:"rollout,omitempty"`
+	Response      *ResponseRules         `json:"response,omitempty"`
+	PolicyVersion string                 `json:"policy_version"`
+	Source        string                 `json:"source"`
+	LoadedAt      time.Time              `json:"loaded_at"`
+}
+
+// Export returns the rules the engine is enforcing right now, ordered by
+// source file and then by their position within that file. Conditions
+// include any defaults merged in from tools sections.
+func (pe *PolicyEngine) Export() Snapshot {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	sources := make([]string, 0, len(pe.policies))
+	for source := range pe.policies {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	snapshot := Snapshot{
+		GeneratedAt: time.Now().UTC(),
+		Files:       len(sources),
+		Rules:       []ExportedRule{},
+	}
+
+	for _, source := range sources {
+		policy := pe.policies[source]
+		for _, agent := range policy.Agents {
+			for i := range agent.Allow {
+				snapshot.Rules = append(snapshot.Rules, pe.exportRule(policy, &agent, &agent.Allow[i]))
+			}
+		}
+	}
+
+	return snapshot
+}
+
+// exportRule describes one allowance of a loaded policy. The caller must
+// hold pe.mu.
+func (pe *PolicyEngine) exportRule(policy *Policy, agent *AgentPolicy, allow *ToolAllowance) ExportedRule {
+	return ExportedRule{
+		AgentID:       agent.ID,
+		Group:         agent.Group,
+		Tool:          allow.Tool,
+		Actions:       append([]string(nil), allow.Actions...),
+		Conditions:    copyConditions(pe.effectiveConditions(allow)),
+		Rollout:       allow.Rollout,
+		Response:      allow.Response,
+		PolicyVersion: policy.Version,
+		Source:        policy.Source,
+		LoadedAt:      policy.LoadedAt,
+	}
+}
+
+// copyConditions makes a shallow copy so callers can't mutate engine state
+func copyConditions(conditions map[string]interface{}) map[string]interface{} {
+	if conditions == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(conditions))
+	for k, v := range conditions {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Deny codes for fetch conditions
+const (
+	CodeFetchDomainNotAllowed = "FETCH_DOMAIN_NOT_ALLOWED"
+	CodeFetchSchemeNotAllowed = "FETCH_SCHEME_NOT_ALLOWED"
+	CodeFetchMethodNotAllowed = "FETCH_METHOD_NOT_ALLOWED"
+)
+
+// FetchRequest is the request a call to a fetch tool makes
+type FetchRequest struct {
+	URL    string
+	Scheme string
+
+	// Host is the lowercased host name or IP address, without the port
+	Host string
+
+	Method string
+}
+
+// domainMatches reports whether domain is pattern, or below it when
+// pattern starts with *.
+func domainMatches(domain, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	return domain == pattern || (strings.HasPrefix(pattern, "*.") && strings.HasSuffix(domain, pattern[1:]))
+}
+
+// checkFetchDomains restricts the hosts a fetch tool calls. An entry
+// starting with *. matches subdomains only:
+//
+//	fetch_domains: [en.wikipedia.org, "*.arxiv.org"]
+func checkFetchDomains(value interface{}, req *Request) *Violation {
+	if req.Fetch == nil {
+		return nil
+	}
+	domains, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "fetch_domains must be a list of domains")
+	}
+	for _, d := range domains {
+		if s, ok := d.(string); ok && domainMatches(req.Fetch.Host, s) {
+			return nil
+		}
+	}
+	return violationf(CodeFetchDomainNotAllowed, "Host %s is not in fetch_domains", req.Fetch.Host)
+}
+
+// checkFetchSchemes restricts the URL schemes of a fetch, e.g. [https]
+func checkFetchSchemes(value interface{}, req *Request) *Violation {
+	if req.Fetch == nil {
+		return nil
+	}
+	schemes, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "fetch_schemes must be a list of schemes")
+	}
+	for _, s := range schemes {
+		if scheme, ok := s.(string); ok && strings.EqualFold(scheme, req.Fetch.Scheme) {
+			return nil
+		}
+	}
+	return violationf(CodeFetchSchemeNotAllowed, "Scheme %s is not in fetch_schemes", req.Fetch.Scheme)
+}
+
+// checkFetchMethods restricts the HTTP methods of a fetch, e.g. [GET, HEAD]
+func checkFetchMethods(value interface{}, req *Request) *Violation {
+	if req.Fetch == nil {
+		return nil
+	}
+	methods, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "fetch_methods must be a list of methods")
+	}
+	for _, m := range methods {
+		if method, ok := m.(string); ok && strings.EqualFold(method, req.Fetch.Method) {
+			return nil
+		}
+	}
+	return violationf(CodeFetchMethodNotAllowed, "Method %s is not in fetch_methods", req.Fetch.Method)
+}
+
+// checkFetchMaxResponseBytes only checks the shape of
+// fetch_max_response_bytes. The gateway stops reading the response at the
+// limit (see Decision.FetchMaxBytes).
+func checkFetchMaxResponseBytes(value interface{}, req *Request) *Violation {
+	if _, ok := toFloat(value); !ok {
+		return violationf(CodeInvalidCondition, "fetch_max_response_bytes must be a number")
+	}
+	return nil
+}
+
+// fetchMaxBytes returns the fetch_max_response_bytes of the allowing
+// rule's conditions, or 0 if it has none
+func fetchMaxBytes(conditions map[string]interface{}) int64 {
+	limit, _ := toFloat(conditions["fetch_max_response_bytes"])
+	return int64(limit)
+}
+
+// validateFetchConditions checks the shape of the fetch conditions at load
+// time
+func validateFetchConditions(conditions map[string]interface{}) error {
+	for _, name := range []string{"fetch_domains", "fetch_schemes", "fetch_methods"} {
+		value, ok := conditions[name]
+		if !ok {
+			continue
+		}
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return fmt.Errorf("%s must be a non-empty list of strings", name)
+		}
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok || s == "" {
+				return fmt.Errorf("%s must be a non-empty list of strings", name)
+			}
+			if name == "fetch_domains" && strings.ContainsAny(s, ":/") {
+				return fmt.Errorf("fetch_domains entry %q must be a host name without scheme or port", s)
+			}
+		}
+	}
+	if value, ok := conditions["fetch_max_response_bytes"]; ok {
+		if n, ok := toFloat(value); !ok || n <= 0 {
+			return fmt.Errorf("fetch_max_response_bytes must be a positive number")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package policy
+
+import "testing"
+
+func TestFetchConditions(t *testing.T) {
+	pe := newTestEngine(t, `version: "1"
+agents:
+  - id: research-agent
+    allow:
+      - tool: web
+        actions: [fetch]
+        conditions:
+          fetch_domains: [en.wikipedia.org, "*.arxiv.org"]
+          fetch_schemes: [https]
+          fetch_methods: [GET]
+`)
+
+	tests := []struct {
+		name     string
+		fetch    *FetchRequest
+		wantCode string
+	}{
+		{"allowed", &FetchRequest{Scheme: "https", Host: "en.wikipedia.org", Method: "GET"}, ""},
+		{"subdomain", &FetchRequest{Scheme: "https", Host: "export.arxiv.org", Method: "GET"}, ""},
+		{"wildcard parent", &FetchRequest{Scheme: "https", Host: "arxiv.org", Method: "GET"}, CodeFetchDomainNotAllowed},
+		{"suffix of another host", &FetchRequest{Scheme: "https", Host: "evilarxiv.org", Method: "GET"}, CodeFetchDomainNotAllowed},
+		{"metadata address", &FetchRequest{Scheme: "https", Host: "169.254.169.254", Method: "GET"}, CodeFetchDomainNotAllowed},
+		{"plain http", &FetchRequest{Scheme: "http", Host: "en.wikipedia.org", Method: "GET"}, CodeFetchSchemeNotAllowed},
+		{"method", &FetchRequest{Scheme: "https", Host: "en.wikipedia.org", Method: "POST"}, CodeFetchMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		d := pe.EvaluateRequest(&Request{AgentID: "research-agent", Tool: "web", Action: "fetch", Fetch: tt.fetch})
+		if d.Code != tt.wantCode || d.Allowed != (tt.wantCode == "") {
+			t.Errorf("%s: got allowed=%v code=%q, want code %q", tt.name, d.Allowed, d.Code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Deny codes for files conditions
+const (
+	CodeFileExtensionNotAllowed = "FILE_EXTENSION_NOT_ALLOWED"
+	CodeFileTooLarge            = "FILE_TOO_LARGE"
+)
+
+// FileOperation is the operation a call to a files tool performs
+type FileOperation struct {
+	// Path is the cleaned, absolute path as the agent sees it, relative to
+	// the rule's files_root
+	Path string
+
+	// Size is the number of bytes a write stores
+	Size int
+}
+
+// checkFilesRoot only checks the shape of files_root. The directory itself
+// is applied by the gateway, which confines the call to it (see
+// Decision.FilesRoot).
+func checkFilesRoot(value interface{}, req *Request) *Violation {
+	if _, ok := value.(string); !ok {
+		return violationf(CodeInvalidCondition, "files_root must be a string")
+	}
+	return nil
+}
+
+// checkFilesExtensions restricts the files a call reads, writes or deletes
+// to the listed extensions, compared case-insensitively:
+//
+//	files_extensions: [.txt, .csv, .pdf]
+func checkFilesExtensions(value interface{}, req *Request) *Violation {
+	if req.Files == nil || req.Action == "list" {
+		return nil
+	}
+	extensions, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "files_extensions must be a list of extensions")
+	}
+	ext := path.Ext(req.Files.Path)
+	for _, e := range extensions {
+		if s, ok := e.(string); ok && ext != "" && strings.EqualFold(s, ext) {
+			return nil
+		}
+	}
+	return violationf(CodeFileExtensionNotAllowed, "File %s does not have an extension in files_extensions", req.Files.Path)
+}
+
+// checkFilesMaxBytes caps the size of the files a call writes
+func checkFilesMaxBytes(value interface{}, req *Request) *Violation {
+	if req.Files == nil {
+		return nil
+	}
+	limit, ok := toFloat(value)
+	if !ok {
+		return violationf(CodeInvalidCondition, "files_max_bytes must be a number")
+	}
+	if float64(req.Files.Size) > limit {
+		return violationf(CodeFileTooLarge, "Writing %d bytes exceeds files_max_bytes=%.0f", req.Files.Size, limit)
+	}
+	return nil
+}
+
+// filesRoot returns the files_root of the allowing rule's conditions
+func filesRoot(conditions map[string]interface{}) string {
+	root, _ := conditions["files_root"].(string)
+	return root
+}
+
+// validateFilesConditions checks the shape of the files conditions at load
+// time
+func validateFilesConditions(conditions map[string]interface{}) error {
+	if value, ok := conditions["files_root"]; ok {
+		root, ok := value.(string)
+		if !ok || !strings.HasPrefix(root, "/") || (root != "/" && path.Clean(root) != strings.TrimSuffix(root, "/")) {
+			return fmt.Errorf("files_root must be an absolute path without . or .. segments")
+		}
+	}
+	if value, ok := conditions["files_extensions"]; ok {
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return fmt.Errorf("files_extensions must be a non-empty list of extensions such as .txt")
+		}
+		for _, item := range list {
+			if s, ok := item.(string); !ok || !strings.HasPrefix(s, ".") {
+				return fmt.Errorf("files_extensions must be a non-empty list of extensions such as .txt")
+			}
+		}
+	}
+	if value, ok := conditions["files_max_bytes"]; ok {
+		if limit, ok := toFloat(value); !ok || limit < 0 {
+			return fmt.Errorf("files_max_bytes must be a non-negative number")
+		}
+	}
+	return nil
+}
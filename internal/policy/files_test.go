@@ -0,0 +1,40 @@
+package policy
+
+import "testing"
+
+func TestFilesConditions(t *testing.T) {
+	pe := newTestEngine(t, `version: "1"
+agents:
+  - id: docs-agent
+    allow:
+      - tool: docs
+        actions: [list, read, write]
+        conditions:
+          files_root: /public
+          files_extensions: [.txt, .csv]
+          files_max_bytes: 100
+`)
+
+	tests := []struct {
+		name     string
+		action   string
+		op       *FileOperation
+		wantCode string
+	}{
+		{"read", "read", &FileOperation{Path: "/notes.TXT"}, ""},
+		{"list ignores extensions", "list", &FileOperation{Path: "/"}, ""},
+		{"extension", "read", &FileOperation{Path: "/key.pem"}, CodeFileExtensionNotAllowed},
+		{"no extension", "read", &FileOperation{Path: "/txt"}, CodeFileExtensionNotAllowed},
+		{"write", "write", &FileOperation{Path: "/data.csv", Size: 100}, ""},
+		{"write too large", "write", &FileOperation{Path: "/data.csv", Size: 101}, CodeFileTooLarge},
+	}
+	for _, tt := range tests {
+		d := pe.EvaluateRequest(&Request{AgentID: "docs-agent", Tool: "docs", Action: tt.action, Files: tt.op})
+		if d.Code != tt.wantCode || d.Allowed != (tt.wantCode == "") {
+			t.Errorf("%s: got allowed=%v code=%q, want code %q", tt.name, d.Allowed, d.Code, tt.wantCode)
+		}
+		if d.Allowed && d.FilesRoot != "/public" {
+			t.Errorf("%s: got files root %q", tt.name, d.FilesRoot)
+		}
+	}
+}
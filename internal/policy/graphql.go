@@ -0,0 +1,118 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Deny codes for GraphQL conditions
+const (
+	CodeGraphQLOperationNotAllowed = "GRAPHQL_OPERATION_NOT_ALLOWED"
+	CodeGraphQLFieldDenied         = "GRAPHQL_FIELD_DENIED"
+	CodeGraphQLTooDeep             = "GRAPHQL_DEPTH_EXCEEDED"
+)
+
+// GraphQLOperation is the operation a call to a GraphQL tool executes
+type GraphQLOperation struct {
+	// Type is "query" or "mutation"; it is also the call's action
+	Type string
+
+	// Name is the operation name, "" for an anonymous operation
+	Name string
+
+	// Fields are the selected fields as dotted paths from the root, e.g.
+	// "user.address.city", with fragments expanded
+	Fields []string
+
+	// Depth is the deepest nesting of selections; a flat query has depth 1
+	Depth int
+}
+
+// checkGraphQLOperations restricts GraphQL calls to named operations:
+//
+//	graphql_operations: [GetInvoice, ListInvoices]
+//
+// Anonymous operations are denied once the condition is set.
+func checkGraphQLOperations(value interface{}, req *Request) *Violation {
+	if req.GraphQL == nil {
+		return nil
+	}
+	names, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "graphql_operations must be a list of operation names")
+	}
+	for _, name := range names {
+		if s, ok := name.(string); ok && s == req.GraphQL.Name && s != "" {
+			return nil
+		}
+	}
+	if req.GraphQL.Name == "" {
+		return violationf(CodeGraphQLOperationNotAllowed, "Anonymous GraphQL operations are not allowed")
+	}
+	return violationf(CodeGraphQLOperationNotAllowed, "GraphQL operation %s is not allowed", req.GraphQL.Name)
+}
+
+// checkGraphQLDenyFields denies GraphQL calls selecting a listed field. A
+// name matches that field anywhere in the query; a dotted path matches
+// selections ending in it:
+//
+//	graphql_deny_fields: [ssn, user.salary]
+func checkGraphQLDenyFields(value interface{}, req *Request) *Violation {
+	if req.GraphQL == nil {
+		return nil
+	}
+	denied, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "graphql_deny_fields must be a list of field names or paths")
+	}
+	for _, field := range req.GraphQL.Fields {
+		for _, d := range denied {
+			s, _ := d.(string)
+			if s != "" && (field == s || strings.HasSuffix(field, "."+s)) {
+				return violationf(CodeGraphQLFieldDenied, "GraphQL field %s may not be selected", field)
+			}
+		}
+	}
+	return nil
+}
+
+// checkGraphQLMaxDepth caps how deeply GraphQL selections may be nested
+func checkGraphQLMaxDepth(value interface{}, req *Request) *Violation {
+	if req.GraphQL == nil {
+		return nil
+	}
+	limit, ok := toFloat(value)
+	if !ok {
+		return violationf(CodeInvalidCondition, "graphql_max_depth must be a number")
+	}
+	if float64(req.GraphQL.Depth) > limit {
+		return violationf(CodeGraphQLTooDeep, "GraphQL selections are nested deeper than graphql_max_depth=%.0f", limit)
+	}
+	return nil
+}
+
+// validateGraphQLConditions checks the shape of the GraphQL conditions at
+// load time
+func validateGraphQLConditions(conditions map[string]interface{}) error {
+	for _, name := range []string{"graphql_operations", "graphql_deny_fields"} {
+		value, ok := conditions[name]
+		if !ok {
+			continue
+		}
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return fmt.Errorf("%s must be a non-empty list of strings", name)
+		}
+		for _, item := range list {
+			if s, ok := item.(string); !ok || s == "" {
+				return fmt.Errorf("%s must be a non-empty list of strings", name)
+			}
+		}
+	}
+	if value, ok := conditions["graphql_max_depth"]; ok {
+		if limit, ok := toFloat(value); !ok || limit < 1 {
+			return fmt.Errorf("graphql_max_depth must be a positive number")
+		}
+	}
+	return nil
+}
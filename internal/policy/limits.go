@@ -0,0 +1,503 @@
+package policy
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deny codes for rate, budget, session and schedule conditions. Denials
+// with these codes carry a RetryAfter hint, except session limits: a
+// session that used up its calls stays denied, so retrying is pointless.
+const (
+	CodeRateLimited      = "RATE_LIMITED"
+	CodeBudgetExceeded   = "BUDGET_EXCEEDED"
+	CodeSessionLimit     = "SESSION_LIMIT_EXCEEDED"
+	CodeOutsideSchedule  = "OUTSIDE_SCHEDULE"
+	defaultScheduleStart = "00:00"
+	defaultScheduleEnd   = "24:00"
+)
+
+// StateStore keeps the usage counted by rate_limit and budget conditions.
+// A window opens with the first use of a key and lasts per. The default
+// in-memory store counts per gateway process; a shared store such as Redis
+// enforces limits across all replicas.
+type StateStore interface {
+	// Usage returns the amount used in the open window of key and the time
+	// until it resets, or zeros if no window is open
+	Usage(key string, per time.Duration) (used float64, resetIn time.Duration, err error)
+
+	// Reserve counts cost against the window of key, opening one if
+	// needed, unless that would take its usage above limit. It reports
+	// whether cost was counted, and the time until the window resets when
+	// it wasn't. Checking and counting must be one atomic step, so
+	// concurrent calls can't together overshoot the limit.
+	Reserve(key string, per time.Duration, limit, cost float64) (reserved bool, resetIn time.Duration, err error)
+
+	// Release takes back cost reserved in the open window of key, e.g.
+	// for a call a later condition denied
+	Release(key string, cost float64) error
+}
+
+// SetStateStore replaces where rate limit and budget usage is kept. Usage
+// counted by the previous store is not carried over.
+func (pe *PolicyEngine) SetStateStore(store StateStore) {
+	pe.mu.Lock()
+	pe.state = store
+	pe.mu.Unlock()
+}
+
+// reserve counts cost against the window for key unless that would exceed
+// limit, and reports whether it did, or the time until the window resets.
+// The reservation is released if the request ends up denied. Simulations
+// only compare the current usage with limit.
+func reserve(req *Request, key string, per time.Duration, limit, cost float64) (reserved bool, retryAfter time.Duration, err error) {
+	store := req.state
+	if req.dryRun {
+		used, resetIn, err := store.Usage(key, per)
+		if err != nil || used+cost <= limit {
+			return err == nil, 0, err
+		}
+		if resetIn <= 0 {
+			resetIn = per
+		}
+		return false, resetIn, nil
+	}
+
+	reserved, resetIn, err := store.Reserve(key, per, limit, cost)
+	if err != nil {
+		return false, 0, err
+	}
+	if !reserved {
+		if resetIn <= 0 {
+			resetIn = per
+		}
+		return false, resetIn, nil
+	}
+	req.onDeny(func() {
+		if err := store.Release(key, cost); err != nil {
+			logger.Error("Failed to release usage", "key", key, "error", err)
+		}
+	})
+	return true, 0, nil
+}
+
+// limitWindow is a fixed accounting window for one agent and tool
+type limitWindow struct {
+	start time.Time
+	used  float64
+}
+
+// memoryState is the default StateStore, keeping usage in process memory
+type memoryState struct {
+	mu      sync.Mutex
+	windows map[string]*limitWindow
+}
+
+// newMemoryState creates an empty in-memory store
+func newMemoryState() *memoryState {
+	return &memoryState{windows: make(map[string]*limitWindow)}
+}
+
+// Usage implements StateStore
+func (s *memoryState) Usage(key string, per time.Duration) (float64, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	w, ok := s.windows[key]
+	if !ok || now.Sub(w.start) >= per {
+		return 0, 0, nil
+	}
+	return w.used, w.start.Add(per).Sub(now), nil
+}
+
+// Reserve implements StateStore
+func (s *memoryState) Reserve(key string, per time.Duration, limit, cost float64) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	w, ok := s.windows[key]
+	if !ok || now.Sub(w.start) >= per {
+		w = &limitWindow{start: now}
+	}
+	if w.used+cost > limit {
+		return false, w.start.Add(per).Sub(now), nil
+	}
+	w.used += cost
+	s.windows[key] = w
+	return true, 0, nil
+}
+
+// Release implements StateStore
+func (s *memoryState) Release(key string, cost float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.windows[key]; ok {
+		w.used = math.Max(0, w.used-cost)
+	}
+	return nil
+}
+
+// parseWindow parses a window length such as "30s", "1m", "24h" or "7d"
+func parseWindow(value interface{}) (time.Duration, error) {
+	s, ok := value.(string)
+	if !ok || s == "" {
+		return 0, fmt.Errorf("window must be a duration string")
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
+
+// BudgetUsage is an agent's use of a budget condition in its open window
+type BudgetUsage struct {
+	Tool   string  `json:"tool"`
+	Amount float64 `json:"amount"`
+	Per    string  `json:"per"`
+	Used   float64 `json:"used"`
+
+	// ResetsIn is the number of seconds until the window closes, 0 when
+	// none is open
+	ResetsIn int `json:"resets_in"`
+}
+
+// BudgetUsage returns the agent's use of the budgets of the rules that
+// name it, one per tool, ordered by policy file. Budgets of group rules
+// aren't included, since the agent's groups aren't known here.
+func (pe *PolicyEngine) BudgetUsage(agentID string) ([]BudgetUsage, error) {
+	type budget struct {
+		usage BudgetUsage
+		per   time.Duration
+	}
+	var budgets []budget
+	seen := make(map[string]bool)
+
+	pe.mu.RLock()
+	sources := make([]string, 0, len(pe.policies))
+	for source := range pe.policies {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		for _, agent := range pe.policies[source].Agents {
+			if agent.ID != agentID {
+				continue
+			}
+			for i := range agent.Allow {
+				allow := &agent.Allow[i]
+				rule, _ := pe.effectiveConditions(allow)["budget"].(map[string]interface{})
+				limit, ok := toFloat(rule["amount"])
+				per, err := parseWindow(rule["per"])
+				if !ok || err != nil || seen[allow.Tool] {
+					continue
+				}
+				seen[allow.Tool] = true
+				window, _ := rule["per"].(string)
+				budgets = append(budgets, budget{usage: BudgetUsage{Tool: allow.Tool, Amount: limit, Per: window}, per: per})
+			}
+		}
+	}
+	store := pe.state
+	pe.mu.RUnlock()
+
+	usage := make([]BudgetUsage, 0, len(budgets))
+	for _, b := range budgets {
+		used, resetIn, err := store.Usage(limitKey("budget", &Request{AgentID: agentID, Tool: b.usage.Tool}), b.per)
+		if err != nil {
+			return nil, err
+		}
+		b.usage.Used = used
+		b.usage.ResetsIn = int(math.Ceil(resetIn.Seconds()))
+		usage = append(usage, b.usage)
+	}
+	return usage, nil
+}
+
+// limitKey scopes usage to the agent and tool of a request
+func limitKey(kind string, req *Request) string {
+	return kind + "|" + req.AgentID + "|" + req.Tool
+}
+
+// checkRateLimit caps the number of allowed calls per window:
+//
+//	rate_limit: {requests: 10, per: 1m}
+func checkRateLimit(value interface{}, req *Request) *Violation {
+	rule, _ := value.(map[string]interface{})
+	limit, ok := toFloat(rule["requests"])
+	per, err := parseWindow(rule["per"])
+	if !ok || err != nil {
+		return violationf(CodeInvalidCondition, "rate_limit requires numeric requests and a per window")
+	}
+
+	reserved, retryAfter, err := reserve(req, limitKey("rate", req), per, limit, 1)
+	if err != nil {
+		return violationf(CodePolicyError, "Usage for rate_limit is unavailable: %v", err)
+	}
+	if !reserved {
+		v := violationf(CodeRateLimited, "Rate limit of %.0f requests per %s exceeded", limit, rule["per"])
+		v.RetryAfter = retryAfter
+		return v
+	}
+	return nil
+}
+
+// checkBudget caps the total amount an agent may spend on a tool per window:
+//
+//	budget: {amount: 10000, per: 24h}
+func checkBudget(value interface{}, req *Request) *Violation {
+	rule, _ := value.(map[string]interface{})
+	limit, ok := toFloat(rule["amount"])
+	per, err := parseWindow(rule["per"])
+	if !ok || err != nil {
+		return violationf(CodeInvalidCondition, "budget requires a numeric amount and a per window")
+	}
+
+	amount, exists := req.Params["amount"]
+	if !exists {
+		return nil
+	}
+	// A negative amount would hand budget back to the agent
+	cost, ok := toFloat(amount)
+	if !ok || cost < 0 || math.IsNaN(cost) || math.IsInf(cost, 0) {
+		return violationf(CodeInvalidParameter, "amount must be a non-negative number")
+	}
+
+	reserved, retryAfter, err := reserve(req, limitKey("budget", req), per, limit, cost)
+	if err != nil {
+		return violationf(CodePolicyError, "Usage for budget is unavailable: %v", err)
+	}
+	if !reserved {
+		v := violationf(CodeBudgetExceeded, "Budget of %.0f per %s exceeded", limit, rule["per"])
+		v.RetryAfter = retryAfter
+		return v
+	}
+	return nil
+}
+
+// defaultSessionWindow is how long session_limit counts a session's calls
+// when the condition has no per window
+const defaultSessionWindow = 24 * time.Hour
+
+// checkSessionLimit caps the calls an agent makes to a tool within one
+// session (X-Agent-Session-ID), e.g. a conversation or agent run. Calls
+// without a session ID of their own are counted together, as one session of
+// the agent, so leaving out the header doesn't escape the limit:
+//
+//	session_limit: {requests: 50, per: 24h}
+func checkSessionLimit(value interface{}, req *Request) *Violation {
+	rule, _ := value.(map[string]interface{})
+	limit, ok := toFloat(rule["requests"])
+	per := defaultSessionWindow
+	if window, set := rule["per"]; set {
+		var err error
+		if per, err = parseWindow(window); err != nil {
+			ok = false
+		}
+	}
+	if !ok {
+		return violationf(CodeInvalidCondition, "session_limit requires numeric requests and an optional per window")
+	}
+	key := "session|" + req.AgentID + "|" + req.Tool + "|"
+	if req.SessionID != "" && !req.SessionGenerated {
+		key += req.SessionID
+	}
+	reserved, _, err := reserve(req, key, per, limit, 1)
+	if err != nil {
+		return violationf(CodePolicyError, "Usage for session_limit is unavailable: %v", err)
+	}
+	if !reserved {
+		return violationf(CodeSessionLimit, "Session limit of %.0f calls to %s exceeded", limit, req.Tool)
+	}
+	return nil
+}
+
+// schedule is a parsed schedule condition
+type schedule struct {
+	days     map[time.Weekday]bool
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock parses "HH:MM" into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parseSchedule parses a schedule condition:
+//
+//	schedule: {days: [mon, tue, wed, thu, fri], start: "09:00", end: "17:00", timezone: "Europe/Berlin"}
+//
+// Windows where end is before start run overnight into the following day.
+func parseSchedule(value interface{}) (*schedule, error) {
+	rule, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schedule must be a mapping")
+	}
+
+	sched := &schedule{days: make(map[time.Weekday]bool), location: time.UTC}
+
+	if days, ok := rule["days"].([]interface{}); ok {
+		for _, d := range days {
+			name, _ := d.(string)
+			day, ok := weekdays[strings.ToLower(name)[:min(3, len(name))]]
+			if !ok {
+				return nil, fmt.Errorf("invalid schedule day %v", d)
+			}
+			sched.days[day] = true
+		}
+	} else {
+		for _, day := range weekdays {
+			sched.days[day] = true
+		}
+	}
+
+	startStr, _ := rule["start"].(string)
+	if startStr == "" {
+		startStr = defaultScheduleStart
+	}
+	endStr, _ := rule["end"].(string)
+	if endStr == "" {
+		endStr = defaultScheduleEnd
+	}
+	var err error
+	if sched.start, err = parseClock(startStr); err != nil {
+		return nil, err
+	}
+	if sched.end, err = parseClock(endStr); err != nil {
+		return nil, err
+	}
+
+	if tz, ok := rule["timezone"].(string); ok && tz != "" {
+		if sched.location, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("invalid schedule timezone %q: %w", tz, err)
+		}
+	}
+
+	return sched, nil
+}
+
+// untilOpen returns zero if now is inside the schedule, otherwise the time
+// until the next window opens
+func (s *schedule) untilOpen(now time.Time) time.Duration {
+	local := now.In(s.location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, s.location)
+	sinceMidnight := local.Sub(midnight)
+
+	if s.start <= s.end {
+		if s.days[local.Weekday()] && sinceMidnight >= s.start && sinceMidnight < s.end {
+			return 0
+		}
+	} else {
+		yesterday := midnight.AddDate(0, 0, -1).Weekday()
+		if (s.days[local.Weekday()] && sinceMidnight >= s.start) || (s.days[yesterday] && sinceMidnight < s.end) {
+			return 0
+		}
+	}
+
+	for offset := 0; offset <= 7; offset++ {
+		day := midnight.AddDate(0, 0, offset)
+		if !s.days[day.Weekday()] {
+			continue
+		}
+		opens := day.Add(s.start)
+		if opens.After(local) {
+			return opens.Sub(local)
+		}
+	}
+	return 0
+}
+
+// checkSchedule only allows requests inside the configured time window
+func checkSchedule(value interface{}, req *Request) *Violation {
+	sched, err := parseSchedule(value)
+	if err != nil {
+		return violationf(CodeInvalidCondition, "invalid schedule: %v", err)
+	}
+
+	if wait := sched.untilOpen(time.Now()); wait > 0 {
+		v := violationf(CodeOutsideSchedule, "Action is not allowed at this time")
+		v.RetryAfter = wait
+		return v
+	}
+	return nil
+}
+
+// validateLimitConditions rejects malformed rate, budget, session and
+// schedule conditions at load time
+func validateLimitConditions(conditions map[string]interface{}) error {
+	if value, ok := conditions["rate_limit"]; ok {
+		rule, _ := value.(map[string]interface{})
+		if n, ok := toFloat(rule["requests"]); !ok || n < 0 {
+			return fmt.Errorf("rate_limit requires a non-negative requests count")
+		}
+		if _, err := parseWindow(rule["per"]); err != nil {
+			return fmt.Errorf("rate_limit: %w", err)
+		}
+	}
+
+	if value, ok := conditions["budget"]; ok {
+		rule, _ := value.(map[string]interface{})
+		if n, ok := toFloat(rule["amount"]); !ok || n < 0 {
+			return fmt.Errorf("budget requires a non-negative amount")
+		}
+		if _, err := parseWindow(rule["per"]); err != nil {
+			return fmt.Errorf("budget: %w", err)
+		}
+	}
+
+	if value, ok := conditions["session_limit"]; ok {
+		rule, _ := value.(map[string]interface{})
+		if n, ok := toFloat(rule["requests"]); !ok || n < 0 {
+			return fmt.Errorf("session_limit requires a non-negative requests count")
+		}
+		if per, ok := rule["per"]; ok {
+			if _, err := parseWindow(per); err != nil {
+				return fmt.Errorf("session_limit: %w", err)
+			}
+		}
+	}
+
+	if value, ok := conditions["schedule"]; ok {
+		if _, err := parseSchedule(value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RetryAfterSeconds rounds the decision's retry hint up to whole seconds
+func (d Decision) RetryAfterSeconds() int {
+	return int(math.Ceil(d.RetryAfter.Seconds()))
+}
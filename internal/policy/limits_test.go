@@ -0,0 +1,218 @@
+package policy
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryStateReserveIsAtomic(t *testing.T) {
+	s := newMemoryState()
+	var reserved atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _, _ := s.Reserve("rate|a|t", time.Minute, 10, 1); ok {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := reserved.Load(); n != 10 {
+		t.Fatalf("reserved %d times, want 10", n)
+	}
+}
+
+func TestRateLimitUnderConcurrency(t *testing.T) {
+	pe := newTestEngine(t, `version: "1"
+agents:
+  - id: search-agent
+    allow:
+      - tool: search
+        actions: [query]
+        conditions:
+          rate_limit: {requests: 10, per: 1m}
+`)
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if pe.EvaluateRequest(&Request{AgentID: "search-agent", Tool: "search", Action: "query"}).Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != 10 {
+		t.Fatalf("allowed %d calls, want 10", n)
+	}
+}
+
+// Usage reserved by one condition is released when a later one denies
+func TestLimitsReleasedOnDenial(t *testing.T) {
+	pe := newTestEngine(t, `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create]
+        conditions:
+          rate_limit: {requests: 2, per: 1m}
+          budget: {amount: 100, per: 1h}
+`)
+	call := func(amount float64) Decision {
+		return pe.EvaluateRequest(&Request{AgentID: "finance-agent", Tool: "payments", Action: "create", Params: map[string]interface{}{"amount": amount}})
+	}
+
+	tests := []struct {
+		amount   float64
+		wantCode string
+	}{
+		{500, CodeBudgetExceeded},
+		{500, CodeBudgetExceeded},
+		{500, CodeBudgetExceeded},
+		{10, ""},
+		{10, ""},
+		{10, CodeRateLimited},
+	}
+	for i, tt := range tests {
+		d := call(tt.amount)
+		if d.Code != tt.wantCode || d.Allowed != (tt.wantCode == "") {
+			t.Fatalf("call %d: got allowed=%v code=%q, want code %q", i+1, d.Allowed, d.Code, tt.wantCode)
+		}
+	}
+	usage, err := pe.BudgetUsage("finance-agent")
+	if err != nil || len(usage) != 1 || usage[0].Used != 20 {
+		t.Fatalf("got budget usage %+v, %v; want 20 used", usage, err)
+	}
+}
+
+func TestSimulateDoesNotConsume(t *testing.T) {
+	pe := newTestEngine(t, `version: "1"
+agents:
+  - id: search-agent
+    allow:
+      - tool: search
+        actions: [query]
+        conditions:
+          rate_limit: {requests: 1, per: 1m}
+`)
+	req := func() *Request { return &Request{AgentID: "search-agent", Tool: "search", Action: "query"} }
+	for i := 0; i < 3; i++ {
+		if ex := pe.Simulate(req()); !ex.Decision.Allowed {
+			t.Fatalf("simulation %d denied: %s", i+1, ex.Decision.Reason)
+		}
+	}
+	if d := pe.EvaluateRequest(req()); !d.Allowed {
+		t.Fatalf("denied after simulations: %s", d.Reason)
+	}
+	if ex := pe.Simulate(req()); ex.Decision.Code != CodeRateLimited {
+		t.Fatalf("got %q, want %s", ex.Decision.Code, CodeRateLimited)
+	}
+}
+
+// Calls without a session of their own must not each start a new session
+func TestSessionLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		sessions    []string
+		generated   bool
+		wantAllowed []bool
+	}{
+		{"same session", []string{"run-1", "run-1", "run-1"}, false, []bool{true, true, false}},
+		{"separate sessions", []string{"run-1", "run-1", "run-2"}, false, []bool{true, true, true}},
+		{"generated sessions", []string{"gen-1", "gen-2", "gen-3"}, true, []bool{true, true, false}},
+		{"no session", []string{"", "", ""}, false, []bool{true, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pe := newTestEngine(t, `version: "1"
+agents:
+  - id: research-agent
+    allow:
+      - tool: search
+        actions: [query]
+        conditions:
+          session_limit: {requests: 2}
+`)
+			for i, session := range tt.sessions {
+				req := &Request{AgentID: "research-agent", Tool: "search", Action: "query", SessionID: session, SessionGenerated: tt.generated}
+				if got := pe.EvaluateRequest(req).Allowed; got != tt.wantAllowed[i] {
+					t.Fatalf("call %d: allowed %v, want %v", i+1, got, tt.wantAllowed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitAndBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions string
+		amounts    []float64
+		wantCodes  []string
+	}{
+		{"rate limit", "rate_limit: {requests: 2, per: 1m}", []float64{1, 1, 1}, []string{"", "", CodeRateLimited}},
+		{"budget", "budget: {amount: 100, per: 24h}", []float64{60, 50, 40}, []string{"", CodeBudgetExceeded, ""}},
+		{"both", "rate_limit: {requests: 2, per: 1m}\n          budget: {amount: 100, per: 24h}", []float64{60, 50, 40, 1}, []string{"", CodeBudgetExceeded, "", CodeRateLimited}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pe := newTestEngine(t, `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create]
+        conditions:
+          `+tt.conditions+`
+`)
+			for i, amount := range tt.amounts {
+				d := pe.Evaluate("finance-agent", "payments", "create", map[string]interface{}{"amount": amount})
+				if d.Code != tt.wantCodes[i] || d.Allowed != (tt.wantCodes[i] == "") {
+					t.Fatalf("call %d: got allowed=%v code=%q, want code %q", i+1, d.Allowed, d.Code, tt.wantCodes[i])
+				}
+				if !d.Allowed && d.RetryAfter <= 0 {
+					t.Fatalf("call %d: no RetryAfter", i+1)
+				}
+			}
+		})
+	}
+}
+
+// An amount that isn't a non-negative number must not give budget back
+func TestBudgetRejectsNegativeAmount(t *testing.T) {
+	pe := newTestEngine(t, `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create]
+        conditions:
+          budget: {amount: 100, per: 24h}
+`)
+	call := func(amount interface{}) Decision {
+		return pe.Evaluate("finance-agent", "payments", "create", map[string]interface{}{"amount": amount})
+	}
+	if d := call(80.0); !d.Allowed {
+		t.Fatalf("first call denied: %s", d.Code)
+	}
+	for _, amount := range []float64{-1000, math.NaN(), math.Inf(-1), math.Inf(1)} {
+		if d := call(amount); d.Code != CodeInvalidParameter {
+			t.Errorf("amount %v: got allowed=%v code=%q, want %s", amount, d.Allowed, d.Code, CodeInvalidParameter)
+		}
+	}
+	usage, err := pe.BudgetUsage("finance-agent")
+	if err != nil || len(usage) != 1 || usage[0].Used != 80 {
+		t.Fatalf("got budget usage %+v, %v; want 80 used", usage, err)
+	}
+	if d := call(30.0); d.Code != CodeBudgetExceeded {
+		t.Fatalf("got %q, want %s", d.Code, CodeBudgetExceeded)
+	}
+}
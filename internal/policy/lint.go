@@ -0,0 +1,144 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Lint finding severities. Errors keep a file from loading; warnings point
+// at rules that load but likely don't do what was meant.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintFinding is a problem found in a policy file
+type LintFinding struct {
+	File     string `json:"file"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// LoadPolicyEngine loads the policies of a directory once, without watching
+// it for changes, for tools that check policies outside the gateway. Unlike
+// NewPolicyEngine it fails when any file doesn't load.
+func LoadPolicyEngine(policiesDir string) (*PolicyEngine, error) {
+	pe := &PolicyEngine{
+		policies: make(map[string]*Policy),
+		baseDir:  policiesDir,
+		state:    newMemoryState(),
+	}
+	pe.registerBuiltinConditions()
+
+	if err := pe.Reload(); err != nil {
+		return nil, err
+	}
+	return pe, nil
+}
+
+// Lint checks the policy files of a directory. Besides what would keep a
+// file from loading, including fields the policy format doesn't have, it
+// warns about:
+//   - conditions that aren't registered, which the engine ignores
+//   - agent entries without allow rules
+//   - rules that allow the same action of a tool to the same agent or
+//     group, since which of them applies is undefined
+//   - tools known doesn't know, if known is set
+//
+// The error is only set when the directory can't be read.
+func Lint(policiesDir string, known func(tool string) bool) ([]LintFinding, error) {
+	entries, err := os.ReadDir(policiesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policies directory: %w", err)
+	}
+
+	pe := &PolicyEngine{}
+	pe.registerBuiltinConditions()
+
+	var findings []LintFinding
+	// allowedBy records the first file allowing each agent, tool and action
+	allowedBy := make(map[[4]string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !isPolicyFile(entry.Name()) {
+			continue
+		}
+		filePath := filepath.Join(policiesDir, entry.Name())
+		report := func(severity, format string, args ...interface{}) {
+			findings = append(findings, LintFinding{File: filePath, Severity: severity, Message: fmt.Sprintf(format, args...)})
+		}
+
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			report(LintError, "failed to read file: %v", err)
+			continue
+		}
+		var policy Policy
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&policy); err != nil && !errors.Is(err, io.EOF) {
+			report(LintError, "failed to parse YAML: %v", err)
+			continue
+		}
+		if err := pe.validatePolicy(&policy); err != nil {
+			report(LintError, "invalid policy: %v", err)
+			continue
+		}
+
+		for _, defaults := range policy.Tools {
+			for _, name := range pe.unknownConditions(defaults.Conditions) {
+				report(LintWarning, "tool defaults for %s use unknown condition %s, which is ignored", defaults.Name, name)
+			}
+		}
+		for _, agent := range policy.Agents {
+			subject := agent.ID
+			if agent.ID == "" {
+				subject = "group:" + agent.Group
+			}
+			if len(agent.Allow) == 0 {
+				report(LintWarning, "%s has no allow rules", subject)
+			}
+			for _, allow := range agent.Allow {
+				for _, name := range pe.unknownConditions(allow.Conditions) {
+					report(LintWarning, "%s: tool %s uses unknown condition %s, which is ignored", subject, allow.Tool, name)
+				}
+				for _, action := range allow.Actions {
+					key := [4]string{agent.ID, agent.Group, allow.Tool, action}
+					if first, ok := allowedBy[key]; ok {
+						report(LintWarning, "%s: action %s of tool %s is also allowed by a rule in %s; which rule applies is undefined",
+							subject, action, allow.Tool, first)
+						continue
+					}
+					allowedBy[key] = filePath
+				}
+			}
+		}
+		if known != nil {
+			for _, tool := range referencedTools(&policy) {
+				if !known(tool) {
+					report(LintWarning, "tool %s is not registered with the gateway", tool)
+				}
+			}
+		}
+	}
+	return findings, nil
+}
+
+// unknownConditions returns the condition names the engine has no check
+// for, sorted
+func (pe *PolicyEngine) unknownConditions(conditions map[string]interface{}) []string {
+	var unknown []string
+	for name := range conditions {
+		if _, ok := pe.conditions[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
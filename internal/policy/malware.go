@@ -0,0 +1,43 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CodeMalwareDetected denies calls uploading a file the scanner flagged
+const CodeMalwareDetected = "MALWARE_DETECTED"
+
+// Values of the malware condition
+const (
+	MalwareBlock = "block"
+	MalwareTag   = "tag"
+)
+
+// checkMalware enforces the malware condition against the threats the
+// gateway's upload scanner found:
+//
+//	malware: block   # deny the call (the default when no rule sets it)
+//	malware: tag     # forward the files, marked as infected
+func checkMalware(value interface{}, req *Request) *Violation {
+	mode, _ := value.(string)
+	if mode != MalwareBlock && mode != MalwareTag {
+		return violationf(CodeInvalidCondition, "malware must be block or tag")
+	}
+	if mode == MalwareBlock && len(req.Malware) > 0 {
+		return violationf(CodeMalwareDetected, "Upload contains malware: %s", strings.Join(req.Malware, ", "))
+	}
+	return nil
+}
+
+// validateMalwareCondition checks the malware mode at load time
+func validateMalwareCondition(conditions map[string]interface{}) error {
+	value, ok := conditions["malware"]
+	if !ok {
+		return nil
+	}
+	if mode, _ := value.(string); mode != MalwareBlock && mode != MalwareTag {
+		return fmt.Errorf("malware must be block or tag")
+	}
+	return nil
+}
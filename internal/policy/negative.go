@@ -0,0 +1,159 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Deny codes for match-then-deny conditions
+const (
+	CodeForbiddenValue   = "FORBIDDEN_VALUE"
+	CodeForbiddenPattern = "FORBIDDEN_PATTERN"
+)
+
+// patternCache holds compiled param_not_matches expressions keyed by source
+var patternCache sync.Map
+
+// compilePattern compiles and caches a regular expression
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patternCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patternCache.Store(pattern, re)
+	return re, nil
+}
+
+// lookupParam resolves a dotted field name (e.g. "recipient.email") in params
+func lookupParam(params map[string]interface{}, field string) (interface{}, bool) {
+	var current interface{} = params
+	for _, part := range strings.Split(field, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// conditionRules normalizes a condition value that may be a single mapping
+// or a list of mappings
+func conditionRules(value interface{}) []map[string]interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}
+	case []interface{}:
+		rules := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				rules = append(rules, m)
+			}
+		}
+		return rules
+	default:
+		return nil
+	}
+}
+
+// checkForbidValues denies when a field equals one of the listed values:
+//
+//	forbid_values: {field: path, values: ["/etc", "/root"]}
+func checkForbidValues(value interface{}, req *Request) *Violation {
+	for _, rule := range conditionRules(value) {
+		field, _ := rule["field"].(string)
+		values, _ := rule["values"].([]interface{})
+		if field == "" {
+			return violationf(CodeInvalidCondition, "forbid_values requires a field")
+		}
+
+		actual, exists := lookupParam(req.Params, field)
+		if !exists {
+			continue
+		}
+
+		for _, forbidden := range values {
+			if fmt.Sprint(actual) == fmt.Sprint(forbidden) {
+				return violationf(CodeForbiddenValue, "Value of %s is forbidden", field)
+			}
+		}
+	}
+	return nil
+}
+
+// checkParamNotMatches denies when a string field matches a pattern:
+//
+//	param_not_matches: {field: path, pattern: "^/(etc|root)(/|$)"}
+func checkParamNotMatches(value interface{}, req *Request) *Violation {
+	for _, rule := range conditionRules(value) {
+		field, _ := rule["field"].(string)
+		pattern, _ := rule["pattern"].(string)
+		if field == "" || pattern == "" {
+			return violationf(CodeInvalidCondition, "param_not_matches requires a field and pattern")
+		}
+
+		actual, exists := lookupParam(req.Params, field)
+		if !exists {
+			continue
+		}
+
+		actualStr, ok := actual.(string)
+		if !ok {
+			return violationf(CodeInvalidParameter, "%s must be a string", field)
+		}
+
+		re, err := compilePattern(pattern)
+		if err != nil {
+			return violationf(CodeInvalidCondition, "invalid param_not_matches pattern for %s", field)
+		}
+		if re.MatchString(actualStr) {
+			return violationf(CodeForbiddenPattern, "Value of %s matches a forbidden pattern", field)
+		}
+	}
+	return nil
+}
+
+// validateNegativeConditions rejects malformed match-then-deny conditions at
+// load time instead of on the request path
+func validateNegativeConditions(conditions map[string]interface{}) error {
+	if value, ok := conditions["param_not_matches"]; ok {
+		rules := conditionRules(value)
+		if len(rules) == 0 {
+			return fmt.Errorf("param_not_matches must be a mapping or list of mappings")
+		}
+		for _, rule := range rules {
+			pattern, _ := rule["pattern"].(string)
+			if _, ok := rule["field"].(string); !ok || pattern == "" {
+				return fmt.Errorf("param_not_matches requires a field and pattern")
+			}
+			if _, err := compilePattern(pattern); err != nil {
+				return fmt.Errorf("invalid param_not_matches pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	if value, ok := conditions["forbid_values"]; ok {
+		rules := conditionRules(value)
+		if len(rules) == 0 {
+			return fmt.Errorf("forbid_values must be a mapping or list of mappings")
+		}
+		for _, rule := range rules {
+			if _, ok := rule["field"].(string); !ok {
+				return fmt.Errorf("forbid_values requires a field")
+			}
+			if _, ok := rule["values"].([]interface{}); !ok {
+				return fmt.Errorf("forbid_values requires a list of values")
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package policy
+
+// Deny codes for payload size and shape conditions
+const (
+	CodeBodyTooLarge = "BODY_TOO_LARGE"
+	CodeArrayTooLong = "ARRAY_TOO_LONG"
+	CodeTooDeep      = "PAYLOAD_TOO_DEEP"
+
+	// CodeBodyIncomplete denies a condition that reads params when the
+	// front-end only saw part of the body
+	CodeBodyIncomplete = "BODY_INCOMPLETE"
+)
+
+// bodylessConditions are the built-in conditions that don't read params,
+// and so can still be checked when Request.BodyIncomplete is set. Every
+// other condition, custom ones included, denies such a request.
+var bodylessConditions = map[string]bool{
+	"methods":         true,
+	"resource_prefix": true,
+	"max_body_bytes":  true,
+	"schedule":        true,
+	"claims":          true,
+	"rate_limit":      true,
+	"session_limit":   true,
+}
+
+// checkMaxBodyBytes caps the size of the raw request body
+func checkMaxBodyBytes(value interface{}, req *Request) *Violation {
+	limit, ok := toFloat(value)
+	if !ok {
+		return violationf(CodeInvalidCondition, "max_body_bytes must be a number")
+	}
+
+	if float64(req.BodySize) > limit {
+		return violationf(CodeBodyTooLarge, "Request body exceeds max_body_bytes=%.0f", limit)
+	}
+	return nil
+}
+
+// checkMaxArrayLength caps the number of elements in an array parameter:
+//
+//	max_array_length: {field: recipients, value: 10}
+func checkMaxArrayLength(value interface{}, req *Request) *Violation {
+	for _, rule := range conditionRules(value) {
+		field, _ := rule["field"].(string)
+		limit, ok := toFloat(rule["value"])
+		if field == "" || !ok {
+			return violationf(CodeInvalidCondition, "max_array_length requires a field and numeric value")
+		}
+
+		actual, exists := lookupParam(req.Params, field)
+		if !exists {
+			continue
+		}
+
+		items, ok := actual.([]interface{})
+		if !ok {
+			return violationf(CodeInvalidParameter, "%s must be an array", field)
+		}
+		if float64(len(items)) > limit {
+			return violationf(CodeArrayTooLong, "%s exceeds max_array_length=%.0f", field, limit)
+		}
+	}
+	return nil
+}
+
+// checkMaxDepth caps how deeply objects and arrays may be nested. The
+// top-level params object has depth 1.
+func checkMaxDepth(value interface{}, req *Request) *Violation {
+	limit, ok := toFloat(value)
+	if !ok {
+		return violationf(CodeInvalidCondition, "max_depth must be a number")
+	}
+
+	if depth := payloadDepth(req.Params); float64(depth) > limit {
+		return violationf(CodeTooDeep, "Payload nesting exceeds max_depth=%.0f", limit)
+	}
+	return nil
+}
+
+// payloadDepth returns the nesting depth of a decoded JSON value
+func payloadDepth(v interface{}) int {
+	deepest := 0
+	switch n := v.(type) {
+	case map[string]interface{}:
+		for _, child := range n {
+			if d := payloadDepth(child); d > deepest {
+				deepest = d
+			}
+		}
+	case []interface{}:
+		for _, child := range n {
+			if d := payloadDepth(child); d > deepest {
+				deepest = d
+			}
+		}
+	default:
+		return 0
+	}
+	return deepest + 1
+}
@@ -1,33 +1,69 @@
 package policy
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"gopkg.in/yaml.v3"
+
+	"aegis-gateway/internal/logging"
 )
 
+// logger is the policy component's logger
+var logger = logging.For("policy")
+
 // Policy represents the complete policy configuration
 type Policy struct {
-	Version string          `yaml:"version"`
-	Agents  []AgentPolicy   `yaml:"agents"`
+	Version string         `yaml:"version"`
+	Agents  []AgentPolicy  `yaml:"agents"`
+	Tools   []ToolDefaults `yaml:"tools"`
+
+	// Source and LoadedAt record where and when the policy was loaded
+	Source   string    `yaml:"-"`
+	LoadedAt time.Time `yaml:"-"`
 }
 
-// AgentPolicy defines what an agent is allowed to do
+// AgentPolicy defines what an agent is allowed to do. A policy names either
+// a single agent ID or a group that authenticated agents may belong to.
 type AgentPolicy struct {
-	ID    string          `yaml:"id"`
+	ID    string          `yaml:"id,omitempty"`
+	Group string          `yaml:"group,omitempty"`
 	Allow []ToolAllowance `yaml:"allow"`
 }
 
+// appliesTo reports whether the policy covers the request's agent
+func (a *AgentPolicy) appliesTo(req *Request) bool {
+	if a.ID != "" {
+		return a.ID == req.AgentID
+	}
+	for _, g := range req.Groups {
+		if g == a.Group {
+			return true
+		}
+	}
+	return false
+}
+
 // ToolAllowance defines allowed tools and actions for an agent
 type ToolAllowance struct {
 	Tool       string                 `yaml:"tool"`
 	Actions    []string               `yaml:"actions"`
 	Conditions map[string]interface{} `yaml:"conditions"`
+
+	// Rollout enforces the rule for only a percentage of requests (e.g. "10%")
+	Rollout string `yaml:"rollout,omitempty"`
+
+	// Response constrains what the tool may return under this rule
+	Response *ResponseRules `yaml:"response,omitempty"`
+
+	// fallback is the rule that was in force before a canary rollout
+	fallback *ToolAllowance
 }
 
 // PolicyEngine manages policy evaluation and hot-reload
@@ -36,14 +72,42 @@ type PolicyEngine struct {
 	policies map[string]*Policy
 	baseDir  string
 	watcher  *fsnotify.Watcher
+	watching atomic.Bool
+	subs     subscribers
+
+	conditions     map[string]ConditionFunc
+	conditionOrder []string
+	toolDefaults   map[string]map[string]interface{}
+	state          StateStore
+	toolLookup     func(tool string) bool
+	reloaded       func(source string, err error)
+
+	// rolloutDir keeps the baselines of rules under rollout across
+	// restarts; it is only set for engines given a state directory
+	rolloutDir string
+	rolloutMu  sync.Mutex
 }
 
-// NewPolicyEngine creates a new policy engine with hot-reload support
+// NewPolicyEngine creates a new policy engine with hot-reload support. The
+// baselines of rules under rollout last until the engine stops; see
+// NewPolicyEngineWithStateDir.
 func NewPolicyEngine(policiesDir string) (*PolicyEngine, error) {
+	return NewPolicyEngineWithStateDir(policiesDir, "")
+}
+
+// NewPolicyEngineWithStateDir creates a policy engine that keeps the
+// baselines of rules under rollout in stateDir (policies.state_dir), so
+// they survive a restart
+func NewPolicyEngineWithStateDir(policiesDir, stateDir string) (*PolicyEngine, error) {
 	pe := &PolicyEngine{
 		policies: make(map[string]*Policy),
 		baseDir:  policiesDir,
+		state:    newMemoryState(),
+	}
+	if stateDir != "" {
+		pe.rolloutDir = filepath.Join(stateDir, rolloutDirName)
 	}
+	pe.registerBuiltinConditions()
 
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -55,6 +119,7 @@ func NewPolicyEngine(policiesDir string) (*PolicyEngine, error) {
 	if err := pe.loadAllPolicies(); err != nil {
 		return nil, err
 	}
+	pe.pruneBaselines()
 
 	// Watch directory for changes
 	if err := watcher.Add(policiesDir); err != nil {
@@ -62,6 +127,7 @@ func NewPolicyEngine(policiesDir string) (*PolicyEngine, error) {
 	}
 
 	// Start hot-reload goroutine
+	pe.watching.Store(true)
 	go pe.watchForChanges()
 
 	return pe, nil
@@ -78,22 +144,90 @@ func (pe *PolicyEngine) loadAllPolicies() error {
 	}
 
 	for _, entry := range entries {
-		if entry.IsDir() || filepath.Ext(entry.Name()) != ".yaml" && filepath.Ext(entry.Name()) != ".yml" {
+		if entry.IsDir() || !isPolicyFile(entry.Name()) {
 			continue
 		}
 
 		filePath := filepath.Join(pe.baseDir, entry.Name())
 		if err := pe.loadPolicyFile(filePath); err != nil {
 			// Log error but continue loading other files
-			fmt.Printf("ERROR: Failed to load policy file %s: %v\n", filePath, err)
+			logger.Error("Failed to load policy file", "file", filePath, "error", err)
 		}
 	}
 
 	return nil
 }
 
+// isPolicyFile reports whether a file name has a policy file extension
+func isPolicyFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// removePolicyFile unloads the policy read from filePath, if any
+func (pe *PolicyEngine) removePolicyFile(filePath string) {
+	pe.mu.Lock()
+	previous, existed := pe.policies[filePath]
+	delete(pe.policies, filePath)
+	pe.rebuildToolDefaultsLocked()
+	pe.mu.Unlock()
+	if existed {
+		logger.Info("Removed policy file", "file", filePath)
+		pe.saveBaselines(filePath)
+		pe.publish(ChangeEvent{
+			Source:    filePath,
+			Timestamp: time.Now().UTC(),
+			Changes:   diffPolicies(previous, nil),
+		})
+	}
+}
+
+// Reload re-reads every policy file and unloads files that were deleted,
+// for changes the watcher missed. Files that fail to load keep their
+// previous version; their errors are returned together.
+func (pe *PolicyEngine) Reload() error {
+	entries, err := os.ReadDir(pe.baseDir)
+	if err != nil {
+		return fmt.Errorf("failed to read policies directory: %w", err)
+	}
+
+	present := make(map[string]bool)
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !isPolicyFile(entry.Name()) {
+			continue
+		}
+		filePath := filepath.Join(pe.baseDir, entry.Name())
+		present[filePath] = true
+		if err := pe.loadPolicyFile(filePath); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filePath, err))
+		}
+	}
+
+	pe.mu.RLock()
+	var removed []string
+	for filePath := range pe.policies {
+		if !present[filePath] {
+			removed = append(removed, filePath)
+		}
+	}
+	pe.mu.RUnlock()
+	for _, filePath := range removed {
+		pe.removePolicyFile(filePath)
+	}
+
+	return errors.Join(errs...)
+}
+
 // loadPolicyFile loads a single policy file
-func (pe *PolicyEngine) loadPolicyFile(filePath string) error {
+func (pe *PolicyEngine) loadPolicyFile(filePath string) (err error) {
+	pe.mu.RLock()
+	reloaded := pe.reloaded
+	pe.mu.RUnlock()
+	if reloaded != nil {
+		defer func() { reloaded(filePath, err) }()
+	}
+
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read file: %w", err)
@@ -109,11 +243,39 @@ func (pe *PolicyEngine) loadPolicyFile(filePath string) error {
 		return fmt.Errorf("invalid policy: %w", err)
 	}
 
+	policy.Source = filePath
+	policy.LoadedAt = time.Now().UTC()
+	pe.warnUnregisteredTools(&policy)
+
+	// On startup, rules still rolling out fall back to what was in force
+	// before the gateway stopped
+	pe.mu.RLock()
+	_, loaded := pe.policies[filePath]
+	pe.mu.RUnlock()
+	var saved map[ruleKey]*ToolAllowance
+	if !loaded {
+		saved = pe.savedBaselines(filePath)
+	}
+
 	pe.mu.Lock()
+	previous := pe.policies[filePath]
+	if previous != nil {
+		carryRolloutFallbacks(indexRules(previous), &policy)
+	} else {
+		carryRolloutFallbacks(saved, &policy)
+	}
 	pe.policies[filePath] = &policy
+	pe.rebuildToolDefaultsLocked()
 	pe.mu.Unlock()
+	pe.saveBaselines(filePath)
 
-	fmt.Printf("Loaded policy file: %s\n", filePath)
+	logger.Info("Loaded policy file", "file", filePath)
+
+	pe.publish(ChangeEvent{
+		Source:    filePath,
+		Timestamp: policy.LoadedAt,
+		Changes:   diffPolicies(previous, &policy),
+	})
 	return nil
 }
 
@@ -123,9 +285,13 @@ func (pe *PolicyEngine) validatePolicy(p *Policy) error {
 		return fmt.Errorf("policy version is required")
 	}
 
+	if err := validateToolDefaults(p.Tools); err != nil {
+		return err
+	}
+
 	for _, agent := range p.Agents {
-		if agent.ID == "" {
-			return fmt.Errorf("agent ID is required")
+		if (agent.ID == "") == (agent.Group == "") {
+			return fmt.Errorf("each agent entry needs exactly one of id or group")
 		}
 		for _, allow := range agent.Allow {
 			if allow.Tool == "" {
@@ -134,6 +300,14 @@ func (pe *PolicyEngine) validatePolicy(p *Policy) error {
 			if len(allow.Actions) == 0 {
 				return fmt.Errorf("at least one action is required for tool %s", allow.Tool)
 			}
+			if allow.Rollout != "" {
+				if _, err := parseRollout(allow.Rollout); err != nil {
+					return fmt.Errorf("tool %s: %w", allow.Tool, err)
+				}
+			}
+			if err := validateConditions(allow.Conditions); err != nil {
+				return fmt.Errorf("tool %s: %w", allow.Tool, err)
+			}
 		}
 	}
 
@@ -142,60 +316,165 @@ func (pe *PolicyEngine) validatePolicy(p *Policy) error {
 
 // watchForChanges handles file system events for hot-reload
 func (pe *PolicyEngine) watchForChanges() {
+	defer pe.watching.Store(false)
+
 	for {
 		select {
 		case event, ok := <-pe.watcher.Events:
 			if !ok {
 				return
 			}
+			// Skip other files, such as the saved rollout baselines
+			if !isPolicyFile(event.Name) {
+				continue
+			}
 
 			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
 				// Small delay to avoid reading during file write
 				time.Sleep(100 * time.Millisecond)
 				if err := pe.loadPolicyFile(event.Name); err != nil {
-					fmt.Printf("ERROR: Failed to reload policy file %s: %v\n", event.Name, err)
+					logger.Error("Failed to reload policy file", "file", event.Name, "error", err)
 				} else {
-					fmt.Printf("Hot-reloaded policy file: %s\n", event.Name)
+					logger.Info("Hot-reloaded policy file", "file", event.Name)
 				}
 			}
 
 			if event.Op&fsnotify.Remove == fsnotify.Remove {
-				pe.mu.Lock()
-				delete(pe.policies, event.Name)
-				pe.mu.Unlock()
-				fmt.Printf("Removed policy file: %s\n", event.Name)
+				pe.removePolicyFile(event.Name)
 			}
 
 		case err, ok := <-pe.watcher.Errors:
 			if !ok {
 				return
 			}
-			fmt.Printf("ERROR: File watcher error: %v\n", err)
+			logger.Error("File watcher error", "error", err)
 		}
 	}
 }
 
+// Decision is the outcome of a policy evaluation
+type Decision struct {
+	Allowed bool
+	Code    string
+	Reason  string
+
+	// Rollout is "canary" or "baseline" when a percentage rollout was involved
+	Rollout string
+
+	// RetryAfter is set for rate limit, budget and schedule denials
+	RetryAfter time.Duration
+
+	// Response holds the allowing rule's response constraints, if any
+	Response *ResponseRules
+
+	// FilesRoot is the allowing rule's files_root: the directory, below the
+	// tool's root, a call to a files tool is confined to
+	FilesRoot string
+
+	// FetchMaxBytes is the allowing rule's fetch_max_response_bytes, or 0:
+	// how much of the response a call to a fetch tool reads
+	FetchMaxBytes int64
+
+	// MaxAmount is the allowing rule's max_amount, or 0, so callers can
+	// tell how close an allowed amount came to it
+	MaxAmount float64
+
+	// Failed is set when the engine could not reach a decision: no policies
+	// are loaded, or a condition is misconfigured or panicked. The caller
+	// decides whether to deny, allow or degrade (see gateway on_policy_error).
+	Failed bool
+
+	// Fallback is the on_policy_error behavior applied to a failed decision
+	Fallback string
+
+	// Findings are the gateway's injection filter matches and malware scan
+	// results, recorded with the decision
+	Findings []string
+
+	// ID uniquely identifies the evaluation; it is assigned by the gateway
+	// and returned to the caller so denials can be traced to their log entry
+	ID string
+}
+
 // Evaluate checks if an agent is allowed to perform an action on a tool
-func (pe *PolicyEngine) Evaluate(agentID, tool, action string, params map[string]interface{}) (allowed bool, reason string) {
+func (pe *PolicyEngine) Evaluate(agentID, tool, action string, params map[string]interface{}) Decision {
+	return pe.EvaluateRequest(&Request{AgentID: agentID, Tool: tool, Action: action, Params: params})
+}
+
+// EvaluateRequest checks a request against the loaded policies
+func (pe *PolicyEngine) EvaluateRequest(req *Request) Decision {
+	return pe.evaluate(req, nil)
+}
+
+// evaluate checks a request against the loaded policies. With ex set, the
+// matched rule and every failing condition are recorded, and rate limits and
+// budgets are checked without consuming them.
+func (pe *PolicyEngine) evaluate(req *Request, ex *Explanation) Decision {
+	if req.Params == nil {
+		req.Params = make(map[string]interface{})
+	}
+	if req.Method == "" {
+		req.Method = "POST"
+	}
+	req.dryRun, req.onDenied = ex != nil, nil
+
+	// Conditions run after the lock is released, since stateful ones may
+	// wait on a shared store such as Redis
 	pe.mu.RLock()
-	defer pe.mu.RUnlock()
+	m, denied := pe.match(req, ex)
+	pe.mu.RUnlock()
+	if m == nil {
+		return denied
+	}
+
+	d := m.check(req, ex)
+	if !d.Allowed {
+		for _, release := range req.onDenied {
+			release()
+		}
+	}
+	return d
+}
+
+// ruleMatch is the rule that allows a request's action, with what its
+// conditions are checked by
+type ruleMatch struct {
+	allow      *ToolAllowance
+	rollout    string
+	conditions map[string]interface{}
+	order      []string
+	checks     map[string]ConditionFunc
+}
+
+// match finds the rule that allows the request's action, or returns the
+// denial when none does. pe.mu must be held.
+func (pe *PolicyEngine) match(req *Request, ex *Explanation) (*ruleMatch, Decision) {
+	if len(pe.policies) == 0 {
+		return nil, Decision{Allowed: false, Code: CodeNoPolicies, Reason: "No policies are loaded", Failed: true}
+	}
 
 	// Search through all policies
 	for _, policy := range pe.policies {
 		for _, agentPolicy := range policy.Agents {
-			if agentPolicy.ID != agentID {
+			if !agentPolicy.appliesTo(req) {
 				continue
 			}
 
-			for _, allow := range agentPolicy.Allow {
-				if allow.Tool != tool {
+			for i := range agentPolicy.Allow {
+				if agentPolicy.Allow[i].Tool != req.Tool {
+					continue
+				}
+
+				// Pick the canary or baseline version of a rule under rollout
+				allow, rollout := selectRolloutRule(&agentPolicy.Allow[i], req)
+				if allow == nil {
 					continue
 				}
 
 				// Check if action is allowed
 				actionAllowed := false
 				for _, a := range allow.Actions {
-					if a == action {
+					if a == req.Action {
 						actionAllowed = true
 						break
 					}
@@ -205,97 +484,131 @@ func (pe *PolicyEngine) Evaluate(agentID, tool, action string, params map[string
 					continue
 				}
 
-				// Check conditions
-				if allow.Conditions != nil {
-					if err := pe.checkConditions(allow.Conditions, params); err != nil {
-						return false, err.Error()
-					}
+				if ex != nil {
+					rule := pe.exportRule(policy, &agentPolicy, allow)
+					ex.Rule = &rule
 				}
-
-				return true, ""
+				req.state = pe.state
+				return &ruleMatch{
+					allow:      allow,
+					rollout:    rollout,
+					conditions: pe.effectiveConditions(allow),
+					order:      pe.conditionOrder,
+					checks:     pe.conditions,
+				}, Decision{}
 			}
 		}
 	}
 
-	return false, fmt.Sprintf("Agent %s is not allowed to perform action %s on tool %s", agentID, action, tool)
+	return nil, Decision{
+		Allowed: false,
+		Code:    CodeActionNotAllowed,
+		Reason:  fmt.Sprintf("Agent %s is not allowed to perform action %s on tool %s", req.AgentID, req.Action, req.Tool),
+	}
 }
 
-// checkConditions validates parameters against policy conditions
-func (pe *PolicyEngine) checkConditions(conditions map[string]interface{}, params map[string]interface{}) error {
-	// Check max_amount condition
-	if maxAmount, ok := conditions["max_amount"]; ok {
-		if amount, exists := params["amount"]; exists {
-			var amountFloat float64
-			switch v := amount.(type) {
-			case float64:
-				amountFloat = v
-			case int:
-				amountFloat = float64(v)
-			case int64:
-				amountFloat = float64(v)
-			default:
-				return fmt.Errorf("amount must be a number")
-			}
+// check decides a request by the conditions of the rule it matched,
+// including tool-wide defaults
+func (m *ruleMatch) check(req *Request, ex *Explanation) Decision {
+	var failures *[]ConditionFailure
+	if ex != nil {
+		failures = &ex.FailedConditions
+	}
 
-			var maxFloat float64
-			switch v := maxAmount.(type) {
-			case float64:
-				maxFloat = v
-			case int:
-				maxFloat = float64(v)
-			case int64:
-				maxFloat = float64(v)
-			default:
-				return fmt.Errorf("max_amount must be a number")
-			}
+	if v := checkConditions(m.order, m.checks, m.conditions, req, failures); v != nil {
+		failed := v.Code == CodeInvalidCondition || v.Code == CodePolicyError
+		return Decision{Allowed: false, Code: v.Code, Reason: v.Reason, Rollout: m.rollout, RetryAfter: v.RetryAfter, Failed: failed}
+	}
 
-			if amountFloat > maxFloat {
-				return fmt.Errorf("Amount exceeds max_amount=%.0f", maxFloat)
+	for _, d := range defaultDenials {
+		if _, set := m.conditions[d.condition]; set {
+			continue
+		}
+		if v := d.check(req); v != nil {
+			if failures != nil {
+				*failures = append(*failures, ConditionFailure{Condition: d.condition, Code: v.Code, Reason: v.Reason})
 			}
+			return Decision{Allowed: false, Code: v.Code, Reason: v.Reason, Rollout: m.rollout}
 		}
 	}
 
-	// Check currencies condition
-	if currencies, ok := conditions["currencies"].([]interface{}); ok {
-		if currency, exists := params["currency"]; exists {
-			currencyStr, ok := currency.(string)
-			if !ok {
-				return fmt.Errorf("currency must be a string")
-			}
-
-			allowed := false
-			for _, c := range currencies {
-				if cStr, ok := c.(string); ok && cStr == currencyStr {
-					allowed = true
-					break
-				}
-			}
+	conditions := m.conditions
+	return Decision{Allowed: true, Rollout: m.rollout, Response: m.allow.Response, FilesRoot: filesRoot(conditions), FetchMaxBytes: fetchMaxBytes(conditions), MaxAmount: maxAmount(conditions)}
+}
 
-			if !allowed {
-				return fmt.Errorf("Currency %s not in allowed currencies", currencyStr)
-			}
+// defaultDenials are checked for every call whose rule leaves the condition
+// out, so what they guard is denied unless a rule allows it
+var defaultDenials = []struct {
+	condition string
+	check     func(req *Request) *Violation
+}{
+	// Infected uploads are blocked unless the rule tags them
+	{"malware", func(req *Request) *Violation { return checkMalware(MalwareBlock, req) }},
+	// A resource path below the action is only passed to the tool when
+	// the rule says which paths it may name
+	{"resource_prefix", func(req *Request) *Violation {
+		if req.Resource == "" {
+			return nil
 		}
-	}
+		return violationf(CodePathPrefixMismatch, "Resource path %s is not allowed without a resource_prefix condition", req.Resource)
+	}},
+	// Commands take no arguments, run in no directory and set no
+	// environment variables the rule doesn't list; a variable like
+	// LD_PRELOAD can change what runs
+	{"exec_args", func(req *Request) *Violation { return checkExecArgs([]interface{}{}, req) }},
+	{"exec_dirs", func(req *Request) *Violation { return checkExecDirs([]interface{}{}, req) }},
+	{"exec_env", func(req *Request) *Violation { return checkExecEnv([]interface{}{}, req) }},
+}
 
-	// Check folder_prefix condition
-	if prefix, ok := conditions["folder_prefix"].(string); ok {
-		if path, exists := params["path"]; exists {
-			pathStr, ok := path.(string)
-			if !ok {
-				return fmt.Errorf("path must be a string")
+// checkConditions validates parameters against policy conditions, in the
+// given order, and returns the first violation. With failures set, the
+// remaining conditions are checked too and every violation is appended to
+// it.
+func checkConditions(order []string, checks map[string]ConditionFunc, conditions map[string]interface{}, req *Request, failures *[]ConditionFailure) *Violation {
+	var first *Violation
+	for _, name := range order {
+		value, ok := conditions[name]
+		if !ok {
+			continue
+		}
+		var v *Violation
+		if req.BodyIncomplete && !bodylessConditions[name] {
+			v = violationf(CodeBodyIncomplete, "Condition %s needs the request body, which was not received in full", name)
+		} else {
+			v = runCondition(name, checks[name], value, req)
+		}
+		if v != nil {
+			if v.Code == "" {
+				v.Code = CodeConditionFailed
 			}
-
-			if len(pathStr) < len(prefix) || pathStr[:len(prefix)] != prefix {
-				return fmt.Errorf("Path must start with prefix %s", prefix)
+			if failures == nil {
+				return v
+			}
+			*failures = append(*failures, ConditionFailure{Condition: name, Code: v.Code, Reason: v.Reason})
+			if first == nil {
+				first = v
 			}
 		}
 	}
 
-	return nil
+	return first
+}
+
+// runCondition calls a condition, turning a panic (e.g. in a custom
+// condition) into a POLICY_ERROR violation
+func runCondition(name string, fn ConditionFunc, value interface{}, req *Request) (v *Violation) {
+	defer func() {
+		if r := recover(); r != nil {
+			v = violationf(CodePolicyError, "Condition %s failed: %v", name, r)
+		}
+	}()
+	return fn(value, req)
 }
 
 // Close stops the policy engine and cleans up resources
 func (pe *PolicyEngine) Close() error {
+	if pe.watcher == nil {
+		return nil
+	}
 	return pe.watcher.Close()
 }
-
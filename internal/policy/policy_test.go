@@ -0,0 +1,112 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestEngine returns an engine with the given policy file loaded
+func newTestEngine(t *testing.T, policyYAML string) *PolicyEngine {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "policy.yaml"), []byte(policyYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pe, err := LoadPolicyEngine(dir)
+	if err != nil {
+		t.Fatalf("LoadPolicyEngine: %v", err)
+	}
+	t.Cleanup(func() { pe.Close() })
+	return pe
+}
+
+const evaluatePolicy = `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create]
+        conditions:
+          max_amount: 5000
+          currencies: [USD, EUR]
+      - tool: files
+        actions: [read]
+        conditions:
+          folder_prefix: /reports/
+          methods: [GET]
+  - group: analysts
+    allow:
+      - tool: search
+        actions: [query]
+        conditions:
+          forbid_values: {field: q, values: [passwords]}
+tools:
+  - name: search
+    conditions:
+      required_params: [q]
+`
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *Request
+		wantCode string // "" when allowed
+	}{
+		{"allowed", &Request{AgentID: "finance-agent", Tool: "payments", Action: "create", Params: map[string]interface{}{"amount": 100.0, "currency": "USD"}}, ""},
+		{"amount over limit", &Request{AgentID: "finance-agent", Tool: "payments", Action: "create", Params: map[string]interface{}{"amount": 9000.0, "currency": "USD"}}, CodeMaxAmountExceeded},
+		{"amount not a number", &Request{AgentID: "finance-agent", Tool: "payments", Action: "create", Params: map[string]interface{}{"amount": "100"}}, CodeInvalidParameter},
+		{"currency not allowed", &Request{AgentID: "finance-agent", Tool: "payments", Action: "create", Params: map[string]interface{}{"amount": 100.0, "currency": "GBP"}}, CodeCurrencyNotAllowed},
+		{"action not allowed", &Request{AgentID: "finance-agent", Tool: "payments", Action: "refund"}, CodeActionNotAllowed},
+		{"tool not allowed", &Request{AgentID: "finance-agent", Tool: "search", Action: "query", Params: map[string]interface{}{"q": "x"}}, CodeActionNotAllowed},
+		{"unknown agent", &Request{AgentID: "other-agent", Tool: "payments", Action: "create"}, CodeActionNotAllowed},
+		{"folder prefix", &Request{AgentID: "finance-agent", Tool: "files", Action: "read", Method: "GET", Params: map[string]interface{}{"path": "/reports/q3.pdf"}}, ""},
+		{"outside folder prefix", &Request{AgentID: "finance-agent", Tool: "files", Action: "read", Method: "GET", Params: map[string]interface{}{"path": "/secrets/key.pem"}}, CodePathPrefixMismatch},
+		{"method not allowed", &Request{AgentID: "finance-agent", Tool: "files", Action: "read", Method: "DELETE", Params: map[string]interface{}{"path": "/reports/q3.pdf"}}, CodeMethodNotAllowed},
+		{"group rule", &Request{AgentID: "analyst-1", Groups: []string{"analysts"}, Tool: "search", Action: "query", Params: map[string]interface{}{"q": "revenue"}}, ""},
+		{"group not claimed", &Request{AgentID: "analyst-1", Tool: "search", Action: "query", Params: map[string]interface{}{"q": "revenue"}}, CodeActionNotAllowed},
+		{"forbidden value", &Request{AgentID: "analyst-1", Groups: []string{"analysts"}, Tool: "search", Action: "query", Params: map[string]interface{}{"q": "passwords"}}, CodeForbiddenValue},
+		{"tool default", &Request{AgentID: "analyst-1", Groups: []string{"analysts"}, Tool: "search", Action: "query"}, CodeMissingParameter},
+	}
+	pe := newTestEngine(t, evaluatePolicy)
+	for _, tt := range tests {
+		d := pe.EvaluateRequest(tt.req)
+		if d.Allowed != (tt.wantCode == "") || d.Code != tt.wantCode {
+			t.Errorf("%s: got allowed=%v code=%q (%s), want code %q", tt.name, d.Allowed, d.Code, d.Reason, tt.wantCode)
+		}
+	}
+}
+
+func TestEvaluateWithoutPolicies(t *testing.T) {
+	pe, err := LoadPolicyEngine(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pe.Close()
+	if d := pe.Evaluate("finance-agent", "payments", "create", nil); d.Allowed || !d.Failed || d.Code != CodeNoPolicies {
+		t.Fatalf("got %+v", d)
+	}
+}
+
+// The snapshot must list what the engine enforces, including tool
+// defaults, without handing out the engine's own maps
+func TestExport(t *testing.T) {
+	pe := newTestEngine(t, evaluatePolicy)
+	snapshot := pe.Export()
+	if snapshot.Files != 1 || len(snapshot.Rules) != 3 {
+		t.Fatalf("got %d files and %d rules", snapshot.Files, len(snapshot.Rules))
+	}
+
+	search := snapshot.Rules[2]
+	if search.Group != "analysts" || search.Tool != "search" || search.PolicyVersion != "1" {
+		t.Fatalf("got %+v", search)
+	}
+	if _, ok := search.Conditions["required_params"]; !ok {
+		t.Fatalf("tool default missing from %v", search.Conditions)
+	}
+
+	snapshot.Rules[0].Conditions["max_amount"] = 1e9
+	if d := pe.Evaluate("finance-agent", "payments", "create", map[string]interface{}{"amount": 9000.0}); d.Allowed {
+		t.Fatal("changing the snapshot changed the policy")
+	}
+}
@@ -0,0 +1,225 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Violation codes for response constraints
+const (
+	CodeResponseTooLarge         = "RESPONSE_TOO_LARGE"
+	CodeForbiddenResponseField   = "FORBIDDEN_RESPONSE_FIELD"
+	CodeClassificationNotAllowed = "CLASSIFICATION_NOT_ALLOWED"
+	CodeResponseRedacted         = "RESPONSE_REDACTED"
+)
+
+// Response rule actions
+const (
+	ResponseBlock    = "block"
+	ResponseTruncate = "truncate"
+	ResponseStrip    = "strip"
+	ResponseRedact   = "redact"
+)
+
+// Redactor masks sensitive values in a response using named detectors,
+// returning the number of values masked per detector
+type Redactor interface {
+	Redact(body []byte, detectors []string) ([]byte, map[string]int, error)
+}
+
+// ResponseRules constrain what a tool may return to the agent under a rule:
+//
+//	response:
+//	  max_bytes: 65536
+//	  on_oversize: truncate        # or block (default)
+//	  forbid_fields: [ssn, items.internal_notes]
+//	  on_forbidden_field: strip    # or block (default)
+//	  forbid_classifications: [secret]
+//	  redact: [email, credit_card, api_key]
+type ResponseRules struct {
+	MaxBytes              int      `yaml:"max_bytes,omitempty" json:"max_bytes,omitempty"`
+	OnOversize            string   `yaml:"on_oversize,omitempty" json:"on_oversize,omitempty"`
+	ForbidFields          []string `yaml:"forbid_fields,omitempty" json:"forbid_fields,omitempty"`
+	OnForbiddenField      string   `yaml:"on_forbidden_field,omitempty" json:"on_forbidden_field,omitempty"`
+	ForbidClassifications []string `yaml:"forbid_classifications,omitempty" json:"forbid_classifications,omitempty"`
+	Redact                []string `yaml:"redact,omitempty" json:"redact,omitempty"`
+}
+
+// ResponseFinding is one response constraint a tool's answer ran into and
+// what was done about it
+type ResponseFinding struct {
+	Code   string
+	Reason string
+	Action string
+
+	// Redactions counts masked values per detector
+	Redactions map[string]int
+}
+
+// ResponseResult is the outcome of inspecting a tool response. Body is the
+// response to deliver unless Blocked.
+type ResponseResult struct {
+	Body     []byte
+	Blocked  bool
+	Findings []ResponseFinding
+}
+
+// Inspect applies the rules to a tool response. classifications are the
+// tags the tool attached to the response. Redaction runs before the size
+// check, so masking can bring a response under max_bytes.
+func (rr *ResponseRules) Inspect(body []byte, classifications []string, redactor Redactor) ResponseResult {
+	result := ResponseResult{Body: body}
+	block := func(code, reason string) ResponseResult {
+		result.Blocked = true
+		result.Findings = append(result.Findings, ResponseFinding{Code: code, Reason: reason, Action: ResponseBlock})
+		return result
+	}
+
+	for _, tag := range classifications {
+		for _, forbidden := range rr.ForbidClassifications {
+			if strings.EqualFold(strings.TrimSpace(tag), forbidden) {
+				return block(CodeClassificationNotAllowed, fmt.Sprintf("Response is classified %s", forbidden))
+			}
+		}
+	}
+
+	if len(rr.ForbidFields) > 0 {
+		var doc interface{}
+		if json.Unmarshal(result.Body, &doc) == nil {
+			stripped := false
+			for _, field := range rr.ForbidFields {
+				if !removeField(doc, strings.Split(field, "."), rr.OnForbiddenField == ResponseStrip) {
+					continue
+				}
+				if rr.OnForbiddenField != ResponseStrip {
+					return block(CodeForbiddenResponseField, fmt.Sprintf("Response contains forbidden field %s", field))
+				}
+				stripped = true
+				result.Findings = append(result.Findings, ResponseFinding{
+					Code:   CodeForbiddenResponseField,
+					Reason: fmt.Sprintf("Removed forbidden field %s", field),
+					Action: ResponseStrip,
+				})
+			}
+			if stripped {
+				if out, err := json.Marshal(doc); err == nil {
+					result.Body = out
+				}
+			}
+		}
+	}
+
+	if len(rr.Redact) > 0 {
+		if redactor == nil {
+			return block(CodeInvalidCondition, "Response redaction is not available")
+		}
+		out, counts, err := redactor.Redact(result.Body, rr.Redact)
+		if err != nil {
+			// Fail closed rather than leak what should have been masked
+			return block(CodeInvalidCondition, fmt.Sprintf("Response redaction failed: %v", err))
+		}
+		result.Body = out
+		if len(counts) > 0 {
+			result.Findings = append(result.Findings, ResponseFinding{
+				Code:       CodeResponseRedacted,
+				Reason:     "Redacted " + redactionSummary(counts),
+				Action:     ResponseRedact,
+				Redactions: counts,
+			})
+		}
+	}
+
+	if rr.MaxBytes > 0 && len(result.Body) > rr.MaxBytes {
+		if rr.OnOversize != ResponseTruncate {
+			return block(CodeResponseTooLarge, fmt.Sprintf("Response exceeds max_bytes=%d", rr.MaxBytes))
+		}
+		result.Body = result.Body[:rr.MaxBytes]
+		result.Findings = append(result.Findings, ResponseFinding{
+			Code:   CodeResponseTooLarge,
+			Reason: fmt.Sprintf("Response truncated to max_bytes=%d", rr.MaxBytes),
+			Action: ResponseTruncate,
+		})
+	}
+
+	return result
+}
+
+// redactionSummary formats counts as "1 credit_card, 2 email"
+func redactionSummary(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[name], name))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// removeField reports whether the dotted path exists in v, deleting it when
+// remove is set. Arrays along the path are searched element by element, so
+// "items.ssn" matches the ssn of every item.
+func removeField(v interface{}, path []string, remove bool) bool {
+	switch node := v.(type) {
+	case []interface{}:
+		found := false
+		for _, item := range node {
+			if removeField(item, path, remove) {
+				found = true
+				if !remove {
+					return true
+				}
+			}
+		}
+		return found
+	case map[string]interface{}:
+		child, ok := node[path[0]]
+		if !ok {
+			return false
+		}
+		if len(path) == 1 {
+			if remove {
+				delete(node, path[0])
+			}
+			return true
+		}
+		return removeField(child, path[1:], remove)
+	}
+	return false
+}
+
+// validateResponseRules checks a rule's response section
+func validateResponseRules(rr *ResponseRules) error {
+	if rr == nil {
+		return nil
+	}
+	if rr.MaxBytes < 0 {
+		return fmt.Errorf("response max_bytes must not be negative")
+	}
+	switch rr.OnOversize {
+	case "", ResponseBlock, ResponseTruncate:
+	default:
+		return fmt.Errorf("response on_oversize must be block or truncate")
+	}
+	switch rr.OnForbiddenField {
+	case "", ResponseBlock, ResponseStrip:
+	default:
+		return fmt.Errorf("response on_forbidden_field must be block or strip")
+	}
+	for _, field := range rr.ForbidFields {
+		if field == "" || strings.Contains(field, "..") {
+			return fmt.Errorf("invalid response forbid_fields entry %q", field)
+		}
+	}
+	for _, name := range rr.Redact {
+		if name == "" {
+			return fmt.Errorf("response redact entries must name a detector")
+		}
+	}
+	return nil
+}
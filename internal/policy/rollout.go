@@ -0,0 +1,229 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rollout tags recorded on decisions that involved a canary rule
+const (
+	RolloutCanary   = "canary"
+	RolloutBaseline = "baseline"
+)
+
+// rolloutBuckets is the resolution of percentage rollouts (0.01%)
+const rolloutBuckets = 10000
+
+// parseRollout converts a rollout value such as "10%" or "2.5" into a
+// percentage between 0 and 100
+func parseRollout(value string) (float64, error) {
+	trimmed := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	pct, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rollout %q", value)
+	}
+	if pct < 0 || pct > 100 {
+		return 0, fmt.Errorf("rollout %q must be between 0%% and 100%%", value)
+	}
+	return pct, nil
+}
+
+// inRollout reports whether the request falls into the canary fraction. The
+// bucket is derived from the agent, tool, action and params so identical
+// requests are always routed the same way.
+func inRollout(rollout string, req *Request) bool {
+	pct, err := parseRollout(rollout)
+	if err != nil {
+		return false
+	}
+
+	paramsJSON, _ := json.Marshal(req.Params)
+	sum := sha256.Sum256([]byte(req.AgentID + "|" + req.Tool + "|" + req.Action + "|" + string(paramsJSON)))
+	bucket := binary.BigEndian.Uint64(sum[:8]) % rolloutBuckets
+
+	return float64(bucket) < pct*rolloutBuckets/100
+}
+
+// selectRolloutRule picks the rule to enforce for an allowance that may be
+// under a canary rollout. It returns nil when the request falls outside the
+// canary and there is no previous rule to fall back to, in which case the
+// allowance is skipped.
+func selectRolloutRule(allow *ToolAllowance, req *Request) (*ToolAllowance, string) {
+	if allow.Rollout == "" {
+		return allow, ""
+	}
+	if inRollout(allow.Rollout, req) {
+		return allow, RolloutCanary
+	}
+	return allow.fallback, RolloutBaseline
+}
+
+// carryRolloutFallbacks attaches the previous version of each rule that is
+// now under a canary rollout, so requests outside the canary keep following
+// the rule that was in force before the reload. oldRules are the rules of
+// the file's previous version, or the baselines saved for it.
+func carryRolloutFallbacks(oldRules map[ruleKey]*ToolAllowance, current *Policy) {
+	seen := make(map[[3]string]int)
+	for a := range current.Agents {
+		agent := &current.Agents[a]
+		for i := range agent.Allow {
+			allow := &agent.Allow[i]
+			pair := [3]string{agent.ID, agent.Group, allow.Tool}
+			key := ruleKey{agentID: agent.ID, group: agent.Group, tool: allow.Tool, index: seen[pair]}
+			seen[pair]++
+
+			if allow.Rollout == "" {
+				continue
+			}
+			old, ok := oldRules[key]
+			if !ok {
+				continue
+			}
+
+			// A rule that was already rolling out keeps its original baseline
+			if old.Rollout != "" {
+				allow.fallback = old.fallback
+				continue
+			}
+			baseline := *old
+			baseline.fallback = nil
+			allow.fallback = &baseline
+		}
+	}
+}
+
+// rolloutDirName is the directory, inside the state directory, where the
+// baselines of rules under rollout are kept so they survive a restart
+const rolloutDirName = "rollouts"
+
+// savedBaseline is the rule in force before a rollout, as saved to disk
+type savedBaseline struct {
+	Agent string        `yaml:"agent,omitempty"`
+	Group string        `yaml:"group,omitempty"`
+	Tool  string        `yaml:"tool"`
+	Index int           `yaml:"index"`
+	Rule  ToolAllowance `yaml:"rule"`
+}
+
+// baselinePath is where the baselines of a policy file are saved
+func (pe *PolicyEngine) baselinePath(filePath string) string {
+	return filepath.Join(pe.rolloutDir, filepath.Base(filePath))
+}
+
+// savedBaselines reads the baselines saved for a policy file. A missing or
+// unreadable file yields none, so rules under rollout are skipped for
+// requests outside the canary, as for a new rule.
+func (pe *PolicyEngine) savedBaselines(filePath string) map[ruleKey]*ToolAllowance {
+	rules := make(map[ruleKey]*ToolAllowance)
+	if pe.rolloutDir == "" {
+		return rules
+	}
+	data, err := os.ReadFile(pe.baselinePath(filePath))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("Failed to read rollout baselines", "file", filePath, "error", err)
+		}
+		return rules
+	}
+	var saved []savedBaseline
+	if err := yaml.Unmarshal(data, &saved); err != nil {
+		logger.Warn("Failed to read rollout baselines", "file", filePath, "error", err)
+		return rules
+	}
+	for i := range saved {
+		b := &saved[i]
+		rules[ruleKey{agentID: b.Agent, group: b.Group, tool: b.Tool, index: b.Index}] = &b.Rule
+	}
+	return rules
+}
+
+// saveBaselines writes the baselines of the rules under rollout in the
+// loaded version of a policy file, or removes them once no rule is rolling
+// out. Failures are logged as errors; the baselines still apply until a
+// restart.
+func (pe *PolicyEngine) saveBaselines(filePath string) {
+	if pe.rolloutDir == "" {
+		return
+	}
+	pe.rolloutMu.Lock()
+	defer pe.rolloutMu.Unlock()
+
+	pe.mu.RLock()
+	var saved []savedBaseline
+	if p := pe.policies[filePath]; p != nil {
+		seen := make(map[[3]string]int)
+		for _, agent := range p.Agents {
+			for _, allow := range agent.Allow {
+				pair := [3]string{agent.ID, agent.Group, allow.Tool}
+				index := seen[pair]
+				seen[pair]++
+				if allow.fallback != nil {
+					saved = append(saved, savedBaseline{Agent: agent.ID, Group: agent.Group, Tool: allow.Tool, Index: index, Rule: *allow.fallback})
+				}
+			}
+		}
+	}
+	pe.mu.RUnlock()
+
+	if err := writeBaselines(pe.baselinePath(filePath), saved); err != nil {
+		logger.Error("Failed to save rollout baselines; they will be lost on restart. Check that policies.state_dir is writable", "file", filePath, "dir", pe.rolloutDir, "error", err)
+	}
+}
+
+// writeBaselines replaces the baselines file at path, or removes it when
+// there are none
+func writeBaselines(path string, saved []savedBaseline) error {
+	if len(saved) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := yaml.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".baselines-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// pruneBaselines removes the baselines of policy files that were deleted
+// while the gateway was down
+func (pe *PolicyEngine) pruneBaselines() {
+	if pe.rolloutDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(pe.rolloutDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(pe.baseDir, entry.Name())); os.IsNotExist(err) {
+			os.Remove(filepath.Join(pe.rolloutDir, entry.Name()))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Requests outside a canary must keep following the previous rule after the
+// gateway restarts, not only until then. The baselines go to the state
+// directory, since the policies directory is often mounted read-only.
+func TestRolloutBaselineSurvivesRestart(t *testing.T) {
+	dir, stateDir := t.TempDir(), t.TempDir()
+	file := filepath.Join(dir, "policy.yaml")
+	write := func(rule string) {
+		t.Helper()
+		policyYAML := `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+` + rule
+		os.Chmod(dir, 0o700)
+		defer os.Chmod(dir, 0o500)
+		if err := os.WriteFile(file, []byte(policyYAML), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0o700) })
+	open := func() *PolicyEngine {
+		t.Helper()
+		pe, err := NewPolicyEngineWithStateDir(dir, stateDir)
+		if err != nil {
+			t.Fatalf("NewPolicyEngineWithStateDir: %v", err)
+		}
+		t.Cleanup(func() { pe.Close() })
+		return pe
+	}
+	checkBaseline := func(pe *PolicyEngine) {
+		t.Helper()
+		if d := pe.Evaluate("finance-agent", "payments", "create", nil); !d.Allowed || d.Rollout != RolloutBaseline {
+			t.Fatalf("baseline rule: got %+v", d)
+		}
+		if d := pe.Evaluate("finance-agent", "payments", "refund", nil); d.Allowed {
+			t.Fatal("canary rule applied outside the canary")
+		}
+	}
+
+	write("        actions: [create]\n")
+	pe := open()
+	write("        actions: [refund]\n        rollout: 0%\n")
+	if err := pe.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	checkBaseline(pe)
+	pe.Close()
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("wrote to the policies directory: %v", entries)
+	}
+
+	pe = open()
+	checkBaseline(pe)
+
+	// Completing the rollout drops the saved baseline
+	write("        actions: [refund]\n")
+	if err := pe.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, rolloutDirName, "policy.yaml")); !os.IsNotExist(err) {
+		t.Fatalf("baseline still saved: %v", err)
+	}
+}
+
+// A state directory that can't be written must not stop the rollout; the
+// failure is logged since the baselines will be lost on restart
+func TestRolloutBaselineWithUnwritableStateDir(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	defer func(previous *slog.Logger) { logger = previous }(logger)
+	logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	file := filepath.Join(dir, "policy.yaml")
+	policyYAML := "version: \"1\"\nagents:\n  - id: finance-agent\n    allow:\n      - tool: payments\n"
+	if err := os.WriteFile(file, []byte(policyYAML+"        actions: [create]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pe, err := NewPolicyEngineWithStateDir(dir, filepath.Join(blocker, "state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pe.Close()
+	if err := os.WriteFile(file, []byte(policyYAML+"        actions: [refund]\n        rollout: 0%\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := pe.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := pe.Evaluate("finance-agent", "payments", "create", nil); !d.Allowed || d.Rollout != RolloutBaseline {
+		t.Fatalf("baseline rule: got %+v", d)
+	}
+	if !strings.Contains(logs.String(), "Failed to save rollout baselines") {
+		t.Fatalf("write error not logged: %s", logs.String())
+	}
+}
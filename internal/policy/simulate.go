@@ -0,0 +1,30 @@
+package policy
+
+// Explanation is a decision together with how it was reached
+type Explanation struct {
+	Decision Decision
+
+	// Rule is the rule that matched the agent, tool and action, if any. A
+	// denial without a rule means no rule covers the action.
+	Rule *ExportedRule
+
+	// FailedConditions lists every condition of the rule the request fails,
+	// not just the first one that decided the denial
+	FailedConditions []ConditionFailure
+}
+
+// ConditionFailure is one condition a simulated request did not satisfy
+type ConditionFailure struct {
+	Condition string `json:"condition"`
+	Code      string `json:"code"`
+	Reason    string `json:"reason"`
+}
+
+// Simulate evaluates req like EvaluateRequest and explains the decision.
+// Rate limits and budgets are checked against current usage but not
+// consumed, so simulations can't exhaust an agent's quota.
+func (pe *PolicyEngine) Simulate(req *Request) Explanation {
+	var ex Explanation
+	ex.Decision = pe.evaluate(req, &ex)
+	return ex
+}
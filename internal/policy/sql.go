@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Deny codes for SQL conditions
+const (
+	CodeSQLTableNotAllowed = "SQL_TABLE_NOT_ALLOWED"
+	CodeSQLRowLimit        = "SQL_ROW_LIMIT_EXCEEDED"
+)
+
+// SQLStatement is the statement a call to a SQL tool runs
+type SQLStatement struct {
+	// Type is "select", "insert", "update" or "delete"; it is also the
+	// call's action
+	Type string
+
+	// Tables are the tables the statement reads or writes, lower-cased and
+	// qualified with the database when the query is, e.g. "shop.orders"
+	Tables []string
+
+	// Limit is the row count of the statement's LIMIT clause, or -1 when it
+	// has none or it isn't a constant
+	Limit int
+}
+
+// checkSQLTables restricts SQL calls to the listed tables. Tables are
+// matched case-insensitively as written in the query, so "orders" doesn't
+// allow "archive.orders":
+//
+//	sql_tables: [orders, customers]
+func checkSQLTables(value interface{}, req *Request) *Violation {
+	if req.SQL == nil {
+		return nil
+	}
+	allowed, ok := value.([]interface{})
+	if !ok {
+		return violationf(CodeInvalidCondition, "sql_tables must be a list of table names")
+	}
+	for _, table := range req.SQL.Tables {
+		found := false
+		for _, a := range allowed {
+			if s, ok := a.(string); ok && strings.EqualFold(s, table) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return violationf(CodeSQLTableNotAllowed, "Table %s is not in sql_tables", table)
+		}
+	}
+	return nil
+}
+
+// checkSQLMaxRows requires SELECT, UPDATE and DELETE statements to carry a
+// LIMIT of at most the given number of rows
+func checkSQLMaxRows(value interface{}, req *Request) *Violation {
+	if req.SQL == nil || req.SQL.Type == "insert" {
+		return nil
+	}
+	limit, ok := toFloat(value)
+	if !ok {
+		return violationf(CodeInvalidCondition, "sql_max_rows must be a number")
+	}
+	if req.SQL.Limit < 0 {
+		return violationf(CodeSQLRowLimit, "%s statements must have a constant LIMIT of at most %.0f", strings.ToUpper(req.SQL.Type), limit)
+	}
+	if float64(req.SQL.Limit) > limit {
+		return violationf(CodeSQLRowLimit, "LIMIT %d is above sql_max_rows=%.0f", req.SQL.Limit, limit)
+	}
+	return nil
+}
+
+// validateSQLConditions checks the shape of the SQL conditions at load time
+func validateSQLConditions(conditions map[string]interface{}) error {
+	if value, ok := conditions["sql_tables"]; ok {
+		list, ok := value.([]interface{})
+		if !ok || len(list) == 0 {
+			return fmt.Errorf("sql_tables must be a non-empty list of table names")
+		}
+		for _, item := range list {
+			if s, ok := item.(string); !ok || s == "" {
+				return fmt.Errorf("sql_tables must be a non-empty list of table names")
+			}
+		}
+	}
+	if value, ok := conditions["sql_max_rows"]; ok {
+		if limit, ok := toFloat(value); !ok || limit < 1 {
+			return fmt.Errorf("sql_max_rows must be a positive number")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package policy
+
+import "testing"
+
+func TestSQLConditions(t *testing.T) {
+	pe := newTestEngine(t, `version: "1"
+agents:
+  - id: analytics-agent
+    allow:
+      - tool: warehouse
+        actions: [select, update]
+        conditions:
+          sql_tables: [orders, shop.customers]
+          sql_max_rows: 100
+`)
+
+	tests := []struct {
+		name     string
+		stmt     *SQLStatement
+		wantCode string
+	}{
+		{"allowed", &SQLStatement{Type: "select", Tables: []string{"orders"}, Limit: 10}, ""},
+		{"case-insensitive table", &SQLStatement{Type: "select", Tables: []string{"SHOP.CUSTOMERS"}, Limit: 10}, ""},
+		{"table not listed", &SQLStatement{Type: "select", Tables: []string{"orders", "users"}, Limit: 10}, CodeSQLTableNotAllowed},
+		{"qualified table", &SQLStatement{Type: "select", Tables: []string{"archive.orders"}, Limit: 10}, CodeSQLTableNotAllowed},
+		{"no limit", &SQLStatement{Type: "update", Tables: []string{"orders"}, Limit: -1}, CodeSQLRowLimit},
+		{"limit too high", &SQLStatement{Type: "select", Tables: []string{"orders"}, Limit: 1000}, CodeSQLRowLimit},
+	}
+	for _, tt := range tests {
+		d := pe.EvaluateRequest(&Request{AgentID: "analytics-agent", Tool: "warehouse", Action: tt.stmt.Type, SQL: tt.stmt})
+		if d.Code != tt.wantCode || d.Allowed != (tt.wantCode == "") {
+			t.Errorf("%s: got allowed=%v code=%q, want code %q", tt.name, d.Allowed, d.Code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package policy
+
+// Status summarizes the engine's state for readiness checks
+type Status struct {
+	Files    int  `json:"files"`
+	Rules    int  `json:"rules"`
+	Watching bool `json:"watching"`
+}
+
+// SetReloadHandler sets fn to be called after each attempt to load a
+// policy file once the engine is running, with the error if it failed.
+// Loads of the initial files aren't reported.
+func (pe *PolicyEngine) SetReloadHandler(fn func(source string, err error)) {
+	pe.mu.Lock()
+	pe.reloaded = fn
+	pe.mu.Unlock()
+}
+
+// Status reports how many policy files and rules are loaded and whether the
+// hot-reload watcher is still running
+func (pe *PolicyEngine) Status() Status {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	status := Status{Files: len(pe.policies), Watching: pe.watching.Load()}
+	for _, policy := range pe.policies {
+		for _, agent := range policy.Agents {
+			status.Rules += len(agent.Allow)
+		}
+	}
+	return status
+}
@@ -0,0 +1,213 @@
+package policy
+
+import (
+	"sort"
+)
+
+// SetToolLookup tells the engine which tools the gateway can forward to.
+// Policies that reference unknown tools still load, but are reported so
+// operators notice typos and drained backends.
+func (pe *PolicyEngine) SetToolLookup(known func(tool string) bool) {
+	pe.mu.Lock()
+	pe.toolLookup = known
+	pe.mu.Unlock()
+
+	for _, tool := range pe.UnregisteredTools() {
+		logger.Warn("Policies reference unregistered tool", "tool", tool)
+	}
+}
+
+// UnregisteredTools returns the tools referenced by loaded policies that the
+// tool lookup doesn't know about
+func (pe *PolicyEngine) UnregisteredTools() []string {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	if pe.toolLookup == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, policy := range pe.policies {
+		for _, tool := range referencedTools(policy) {
+			if !pe.toolLookup(tool) {
+				seen[tool] = true
+			}
+		}
+	}
+
+	tools := make([]string, 0, len(seen))
+	for tool := range seen {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	return tools
+}
+
+// AgentsUsingTool returns the agents with at least one allowance for tool.
+// Group policies are listed as "group:<name>".
+func (pe *PolicyEngine) AgentsUsingTool(tool string) []string {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, policy := range pe.policies {
+		for _, agent := range policy.Agents {
+			for _, allow := range agent.Allow {
+				if allow.Tool != tool {
+					continue
+				}
+				if agent.ID != "" {
+					seen[agent.ID] = true
+				} else {
+					seen["group:"+agent.Group] = true
+				}
+			}
+		}
+	}
+
+	agents := make([]string, 0, len(seen))
+	for agent := range seen {
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+	return agents
+}
+
+// AllowedActions returns the actions each tool allows for an agent with the
+// given groups, sorted. Conditions are not considered, so a listed action may
+// still be denied for particular params.
+func (pe *PolicyEngine) AllowedActions(agentID string, groups []string) map[string][]string {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	req := &Request{AgentID: agentID, Groups: groups}
+	seen := make(map[string]map[string]bool)
+	for _, policy := range pe.policies {
+		for _, agent := range policy.Agents {
+			if !agent.appliesTo(req) {
+				continue
+			}
+			for _, allow := range agent.Allow {
+				if seen[allow.Tool] == nil {
+					seen[allow.Tool] = make(map[string]bool)
+				}
+				for _, action := range allow.Actions {
+					seen[allow.Tool][action] = true
+				}
+			}
+		}
+	}
+
+	allowed := make(map[string][]string, len(seen))
+	for tool, actions := range seen {
+		list := make([]string, 0, len(actions))
+		for action := range actions {
+			list = append(list, action)
+		}
+		sort.Strings(list)
+		allowed[tool] = list
+	}
+	return allowed
+}
+
+// Capability is an action an agent holds a rule for, with the conditions
+// that rule puts on calls
+type Capability struct {
+	Tool       string                 `json:"tool"`
+	Action     string                 `json:"action"`
+	Conditions map[string]interface{} `json:"conditions,omitempty"`
+
+	// Response holds the rule's response constraints, if any
+	Response *ResponseRules `json:"response,omitempty"`
+
+	// Rollout is RolloutCanary for a rule under a canary rollout, which
+	// only applies to some calls, and RolloutBaseline for the rule the
+	// other calls follow
+	Rollout string `json:"rollout,omitempty"`
+}
+
+// Capabilities returns an entry per action and rule that applies to an
+// agent with the given groups, sorted by tool and action. Conditions
+// include tool-wide defaults.
+func (pe *PolicyEngine) Capabilities(agentID string, groups []string) []Capability {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	req := &Request{AgentID: agentID, Groups: groups}
+	var capabilities []Capability
+	add := func(allow *ToolAllowance, rollout string) {
+		for _, action := range allow.Actions {
+			capabilities = append(capabilities, Capability{
+				Tool:       allow.Tool,
+				Action:     action,
+				Conditions: copyConditions(pe.effectiveConditions(allow)),
+				Response:   allow.Response,
+				Rollout:    rollout,
+			})
+		}
+	}
+	for _, policy := range pe.policies {
+		for _, agent := range policy.Agents {
+			if !agent.appliesTo(req) {
+				continue
+			}
+			for i := range agent.Allow {
+				allow := &agent.Allow[i]
+				if allow.Rollout == "" {
+					add(allow, "")
+					continue
+				}
+				add(allow, RolloutCanary)
+				if allow.fallback != nil {
+					add(allow.fallback, RolloutBaseline)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(capabilities, func(i, j int) bool {
+		if capabilities[i].Tool != capabilities[j].Tool {
+			return capabilities[i].Tool < capabilities[j].Tool
+		}
+		return capabilities[i].Action < capabilities[j].Action
+	})
+	return capabilities
+}
+
+// warnUnregisteredTools logs tools in p that the tool lookup doesn't know
+func (pe *PolicyEngine) warnUnregisteredTools(p *Policy) {
+	pe.mu.RLock()
+	known := pe.toolLookup
+	pe.mu.RUnlock()
+
+	if known == nil {
+		return
+	}
+	for _, tool := range referencedTools(p) {
+		if !known(tool) {
+			logger.Warn("Policy file references unregistered tool", "file", p.Source, "tool", tool)
+		}
+	}
+}
+
+// referencedTools lists the distinct tools a policy mentions
+func referencedTools(p *Policy) []string {
+	seen := make(map[string]bool)
+	var tools []string
+	add := func(tool string) {
+		if !seen[tool] {
+			seen[tool] = true
+			tools = append(tools, tool)
+		}
+	}
+	for _, agent := range p.Agents {
+		for _, allow := range agent.Allow {
+			add(allow.Tool)
+		}
+	}
+	for _, defaults := range p.Tools {
+		add(defaults.Name)
+	}
+	return tools
+}
@@ -0,0 +1,225 @@
+// Package quarantine denies every call of an agent that was denied too often
+// or tripped a tripwire, until an operator releases it. Quarantined agents
+// are persisted to a JSON file, so a restart doesn't release them.
+package quarantine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/logging"
+)
+
+// logger is the quarantine component's logger
+var logger = logging.For("quarantine")
+
+// CodeAgentQuarantined denies the calls of a quarantined agent
+const CodeAgentQuarantined = "AGENT_QUARANTINED"
+
+// Entry is a quarantined agent
+type Entry struct {
+	AgentID string    `json:"agent_id"`
+	Since   time.Time `json:"since"`
+	Reason  string    `json:"reason"`
+}
+
+// Quarantine keeps the quarantined agents and counts the denials of the
+// others
+type Quarantine struct {
+	cfg   config.QuarantineConfig
+	codes map[string]bool
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	// denials are the recent denials of each agent, oldest first
+	denials map[string][]time.Time
+	swept   time.Time
+}
+
+// New opens the quarantine store of cfg, creating it on first write
+func New(cfg config.QuarantineConfig) (*Quarantine, error) {
+	cfg = cfg.WithDefaults()
+	q := &Quarantine{
+		cfg:     cfg,
+		codes:   make(map[string]bool),
+		entries: make(map[string]*Entry),
+		denials: make(map[string][]time.Time),
+	}
+	for _, code := range cfg.Codes {
+		q.codes[code] = true
+	}
+	if cfg.Store == "" {
+		return q, nil
+	}
+
+	data, err := os.ReadFile(cfg.Store)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quarantine store: %w", err)
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse quarantine store: %w", err)
+	}
+	for _, e := range entries {
+		q.entries[e.AgentID] = e
+	}
+	return q, nil
+}
+
+// Get returns the quarantine entry of an agent
+func (q *Quarantine) Get(agentID string) (Entry, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.entries[agentID]
+	if !ok {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+// List returns the quarantined agents, longest quarantined first
+func (q *Quarantine) List() []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	sorted := q.sortedLocked()
+	entries := make([]Entry, len(sorted))
+	for i, e := range sorted {
+		entries[i] = *e
+	}
+	return entries
+}
+
+// Add quarantines an agent and reports whether it wasn't quarantined
+// already. The agent stays quarantined when the store can't be written.
+func (q *Quarantine) Add(agentID, reason string, at time.Time) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.entries[agentID]; ok {
+		return false, nil
+	}
+	q.entries[agentID] = &Entry{AgentID: agentID, Since: at.UTC(), Reason: reason}
+	delete(q.denials, agentID)
+	return true, q.saveLocked()
+}
+
+// Release lifts an agent's quarantine and reports whether it was
+// quarantined. Its earlier denials don't count towards another one.
+func (q *Quarantine) Release(agentID string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.entries[agentID]; !ok {
+		return false, nil
+	}
+	delete(q.entries, agentID)
+	delete(q.denials, agentID)
+	return true, q.saveLocked()
+}
+
+// Denied counts a denial of an agent and quarantines it once it was denied
+// quarantine.denials times within quarantine.window. It returns the entry
+// when this denial quarantined the agent.
+func (q *Quarantine) Denied(agentID, code string, at time.Time) (*Entry, error) {
+	if q.cfg.Denials == 0 || (len(q.codes) > 0 && !q.codes[code]) {
+		return nil, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sweepLocked(at)
+
+	if _, ok := q.entries[agentID]; ok {
+		return nil, nil
+	}
+	kept := q.denials[agentID][:0]
+	for _, t := range q.denials[agentID] {
+		if at.Sub(t) < q.cfg.Window {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, at)
+	if len(kept) < q.cfg.Denials {
+		q.denials[agentID] = kept
+		return nil, nil
+	}
+
+	e := &Entry{
+		AgentID: agentID,
+		Since:   at.UTC(),
+		Reason:  fmt.Sprintf("%s was denied %d times within %s", agentID, len(kept), q.cfg.Window),
+	}
+	q.entries[agentID] = e
+	delete(q.denials, agentID)
+	logger.Warn("Agent quarantined", "agent_id", agentID, "reason", e.Reason)
+	entry := *e
+	return &entry, q.saveLocked()
+}
+
+// sweepLocked drops, at most once a window, the denial counts of agents not
+// denied within the window
+func (q *Quarantine) sweepLocked(now time.Time) {
+	if now.Sub(q.swept) < q.cfg.Window {
+		return
+	}
+	q.swept = now
+	for id, times := range q.denials {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= q.cfg.Window {
+			delete(q.denials, id)
+		}
+	}
+}
+
+// sortedLocked returns the entries by quarantine time
+func (q *Quarantine) sortedLocked() []*Entry {
+	entries := make([]*Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Since.Before(entries[j].Since) })
+	return entries
+}
+
+// saveLocked atomically rewrites the store file
+func (q *Quarantine) saveLocked() error {
+	if q.cfg.Store == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(q.sortedLocked(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode quarantine store: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(q.cfg.Store), 0o700); err != nil {
+		return fmt.Errorf("failed to write quarantine store: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.cfg.Store), ".quarantine-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write quarantine store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write quarantine store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write quarantine store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.cfg.Store); err != nil {
+		return fmt.Errorf("failed to write quarantine store: %w", err)
+	}
+	return nil
+}
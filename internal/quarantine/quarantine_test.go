@@ -0,0 +1,174 @@
+package quarantine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+)
+
+// start is when the denials of the tests begin
+var start = time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+
+// after returns a time the given number of minutes after start
+func after(minutes int) time.Time {
+	return start.Add(time.Duration(minutes) * time.Minute)
+}
+
+// denial is a denied call of an agent, minutes after start
+type denial struct {
+	agent   string
+	code    string
+	minutes int
+}
+
+func TestDenied(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.QuarantineConfig
+		denials []denial
+		want    []string
+	}{
+		{"within window", config.QuarantineConfig{Denials: 3, Window: time.Hour}, []denial{
+			{"finance-agent", policy.CodeRateLimited, 0},
+			{"hr-agent", policy.CodeRateLimited, 1},
+			{"finance-agent", policy.CodeRateLimited, 10},
+			{"finance-agent", policy.CodeRateLimited, 20},
+		}, []string{"finance-agent"}},
+		{"outside window", config.QuarantineConfig{Denials: 3, Window: time.Hour}, []denial{
+			{"finance-agent", policy.CodeRateLimited, 0},
+			{"finance-agent", policy.CodeRateLimited, 50},
+			{"finance-agent", policy.CodeRateLimited, 70},
+		}, nil},
+		{"codes", config.QuarantineConfig{Denials: 2, Window: time.Hour, Codes: []string{policy.CodeBudgetExceeded}}, []denial{
+			{"finance-agent", policy.CodeRateLimited, 0},
+			{"finance-agent", policy.CodeBudgetExceeded, 1},
+			{"finance-agent", policy.CodeRateLimited, 2},
+			{"finance-agent", policy.CodeBudgetExceeded, 3},
+		}, []string{"finance-agent"}},
+		{"already quarantined", config.QuarantineConfig{Denials: 1, Window: time.Hour}, []denial{
+			{"finance-agent", policy.CodeRateLimited, 0},
+			{"finance-agent", policy.CodeRateLimited, 1},
+		}, []string{"finance-agent"}},
+		{"disabled", config.QuarantineConfig{}, []denial{
+			{"finance-agent", policy.CodeRateLimited, 0},
+			{"finance-agent", policy.CodeRateLimited, 1},
+		}, nil},
+	}
+	for _, tt := range tests {
+		q, err := New(tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range tt.denials {
+			e, err := q.Denied(d.agent, d.code, after(d.minutes))
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			if e != nil {
+				got = append(got, e.AgentID)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Released agents start counting their denials again
+func TestRelease(t *testing.T) {
+	q, err := New(config.QuarantineConfig{Denials: 2, Window: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Denied("finance-agent", policy.CodeRateLimited, after(0))
+	e, _ := q.Denied("finance-agent", policy.CodeRateLimited, after(1))
+	if e == nil || e.Reason != "finance-agent was denied 2 times within 1h0m0s" {
+		t.Fatalf("got %+v", e)
+	}
+	if got, ok := q.Get("finance-agent"); !ok || !got.Since.Equal(after(1)) {
+		t.Errorf("got %+v, %v", got, ok)
+	}
+
+	if released, err := q.Release("finance-agent"); !released || err != nil {
+		t.Fatalf("got %v, %v", released, err)
+	}
+	if released, _ := q.Release("finance-agent"); released {
+		t.Error("released twice")
+	}
+	if e, _ := q.Denied("finance-agent", policy.CodeRateLimited, after(2)); e != nil {
+		t.Errorf("quarantined again by its first denial after release: %+v", e)
+	}
+}
+
+// Quarantined agents are kept across restarts
+func TestStore(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "state", "quarantine.json")
+	cfg := config.QuarantineConfig{Store: store}
+	q, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, err := q.Add("hr-agent", "tripwire admin_export", after(5)); !added || err != nil {
+		t.Fatalf("got %v, %v", added, err)
+	}
+	q.Add("finance-agent", "tripwire payroll_dump", after(1))
+	if added, _ := q.Add("finance-agent", "again", after(9)); added {
+		t.Error("quarantined twice")
+	}
+
+	reopened, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{AgentID: "finance-agent", Since: after(1), Reason: "tripwire payroll_dump"},
+		{AgentID: "hr-agent", Since: after(5), Reason: "tripwire admin_export"},
+	}
+	if got := reopened.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	reopened.Release("hr-agent")
+	reopened, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.Get("hr-agent"); ok {
+		t.Error("release wasn't stored")
+	}
+	if info, err := os.Stat(store); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("got %v, %v; want a store readable only by the gateway", info, err)
+	}
+}
+
+func TestStoreErrors(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(config.QuarantineConfig{Store: corrupt}); err == nil {
+		t.Error("corrupt store opened")
+	}
+
+	// An agent stays quarantined when the store can't be written
+	q, err := New(config.QuarantineConfig{Store: filepath.Join(dir, "state", "quarantine.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "state"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if added, err := q.Add("finance-agent", "tripwire", after(0)); !added || err == nil {
+		t.Errorf("got %v, %v", added, err)
+	}
+	if _, ok := q.Get("finance-agent"); !ok {
+		t.Error("agent not quarantined after failed write")
+	}
+}
@@ -0,0 +1,188 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Built-in detectors
+const (
+	Email      = "email"
+	CreditCard = "credit_card"
+	APIKey     = "api_key"
+)
+
+// builtins are the detectors available without configuration
+var builtins = map[string]*detector{
+	Email: {
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	CreditCard: {
+		pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		valid:   luhn,
+	},
+	APIKey: {
+		pattern: regexp.MustCompile(`\b(?:` +
+			`sk-[A-Za-z0-9_-]{20,}|` + // OpenAI-style secret keys
+			`sk_(?:live|test)_[A-Za-z0-9]{16,}|` + // Stripe
+			`AKIA[0-9A-Z]{16}|` + // AWS access key IDs
+			`gh[pousr]_[A-Za-z0-9]{36,}|` + // GitHub tokens
+			`xox[abprs]-[A-Za-z0-9-]{10,}|` + // Slack tokens
+			`AIza[0-9A-Za-z_-]{35}|` + // Google API keys
+			`aegis_[0-9a-f]{12}_[A-Za-z0-9_-]+` + // Aegis agent API keys
+			`)`),
+	},
+}
+
+// Builtin reports whether name is a built-in detector
+func Builtin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// detector finds one kind of sensitive value
+type detector struct {
+	pattern *regexp.Regexp
+	// valid filters out pattern matches that aren't real values
+	valid func(match string) bool
+	mask  string
+}
+
+// Custom is an operator-defined regular expression detector. Mask replaces
+// each match; it defaults to "[REDACTED:<name>]".
+type Custom struct {
+	Pattern string
+	Mask    string
+}
+
+// Redactor masks sensitive values in tool responses
+type Redactor struct {
+	detectors map[string]*detector
+}
+
+// New returns a redactor with the built-in detectors and the custom ones
+func New(custom map[string]Custom) (*Redactor, error) {
+	r := &Redactor{detectors: make(map[string]*detector, len(builtins)+len(custom))}
+	for name, d := range builtins {
+		r.detectors[name] = d
+	}
+	for name, c := range custom {
+		if Builtin(name) {
+			return nil, fmt.Errorf("redaction detector %s shadows a built-in detector", name)
+		}
+		pattern, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction detector %s: %w", name, err)
+		}
+		r.detectors[name] = &detector{pattern: pattern, mask: c.Mask}
+	}
+	return r, nil
+}
+
+// Redact masks the matches of the named detectors in body and returns the
+// number of values masked per detector. JSON bodies are redacted value by
+// value so they stay valid JSON; anything else is treated as text.
+func (r *Redactor) Redact(body []byte, names []string) ([]byte, map[string]int, error) {
+	detectors := make([]*detector, 0, len(names))
+	for _, name := range names {
+		d, ok := r.detectors[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown redaction detector %s", name)
+		}
+		detectors = append(detectors, d)
+	}
+
+	counts := make(map[string]int)
+	redact := func(s string) string {
+		for i, d := range detectors {
+			s = d.replace(names[i], s, counts)
+		}
+		return s
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil || dec.More() {
+		return []byte(redact(string(body))), counts, nil
+	}
+
+	doc = redactValue(doc, redact)
+	if len(counts) == 0 {
+		return body, counts, nil
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, counts, nil
+}
+
+// redactValue applies redact to every string and number in a JSON value.
+// Numbers that contain a match, such as card numbers, become strings.
+func redactValue(v interface{}, redact func(string) string) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for key, child := range node {
+			node[key] = redactValue(child, redact)
+		}
+	case []interface{}:
+		for i, child := range node {
+			node[i] = redactValue(child, redact)
+		}
+	case string:
+		return redact(node)
+	case json.Number:
+		if masked := redact(node.String()); masked != node.String() {
+			return masked
+		}
+	}
+	return v
+}
+
+// replace masks the detector's matches in s
+func (d *detector) replace(name, s string, counts map[string]int) string {
+	mask := d.mask
+	if mask == "" {
+		mask = "[REDACTED:" + name + "]"
+	}
+	return d.pattern.ReplaceAllStringFunc(s, func(match string) string {
+		if d.valid != nil && !d.valid(match) {
+			return match
+		}
+		counts[name]++
+		return mask
+	})
+}
+
+// luhn reports whether a card number candidate has 13 to 19 digits and a
+// valid Luhn checksum
+func luhn(candidate string) bool {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, candidate)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		n := int(digits[i] - '0')
+		if double {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+		double = !double
+	}
+	return sum%10 == 0
+}
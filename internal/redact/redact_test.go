@@ -0,0 +1,69 @@
+package redact
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	r, err := New(map[string]Custom{
+		"employee_id": {Pattern: `EMP-\d{6}`},
+		"ssn":         {Pattern: `\b\d{3}-\d{2}-\d{4}\b`, Mask: "***-**-****"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		detectors  []string
+		want       string
+		wantCounts map[string]int
+	}{
+		{"email in JSON", `{"contact":"jane.doe@example.com","id":7}`, []string{Email}, `{"contact":"[REDACTED:email]","id":7}`, map[string]int{Email: 1}},
+		{"card number as JSON number", `{"card":4111111111111111}`, []string{CreditCard}, `{"card":"[REDACTED:credit_card]"}`, map[string]int{CreditCard: 1}},
+		{"card failing Luhn", `{"card":"4111 1111 1111 1112"}`, []string{CreditCard}, `{"card":"4111 1111 1111 1112"}`, map[string]int{}},
+		{"api key in text", "key=sk_live_abcdefghijklmnop0123 ok", []string{APIKey}, "key=[REDACTED:api_key] ok", map[string]int{APIKey: 1}},
+		{"nested values", `{"rows":[{"email":"a@example.com"},{"email":"b@example.org"}]}`, []string{Email}, `{"rows":[{"email":"[REDACTED:email]"},{"email":"[REDACTED:email]"}]}`, map[string]int{Email: 2}},
+		{"custom with default mask", `{"owner":"EMP-004211"}`, []string{"employee_id"}, `{"owner":"[REDACTED:employee_id]"}`, map[string]int{"employee_id": 1}},
+		{"custom with mask", "ssn 123-45-6789", []string{"ssn"}, "ssn ***-**-****", map[string]int{"ssn": 1}},
+		{"detector not requested", `{"contact":"jane.doe@example.com"}`, []string{CreditCard}, `{"contact":"jane.doe@example.com"}`, map[string]int{}},
+		{"nothing to mask keeps body", `{ "id": 7 }`, []string{Email}, `{ "id": 7 }`, map[string]int{}},
+	}
+	for _, tt := range tests {
+		got, counts, err := r.Redact([]byte(tt.body), tt.detectors)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+		if len(counts) != len(tt.wantCounts) {
+			t.Errorf("%s: got counts %v, want %v", tt.name, counts, tt.wantCounts)
+			continue
+		}
+		for name, n := range tt.wantCounts {
+			if counts[name] != n {
+				t.Errorf("%s: got counts %v, want %v", tt.name, counts, tt.wantCounts)
+			}
+		}
+	}
+
+	if _, _, err := r.Redact([]byte("x"), []string{"unknown"}); err == nil {
+		t.Error("unknown detector accepted")
+	}
+}
+
+func TestNewRejectsBadDetectors(t *testing.T) {
+	tests := []struct {
+		name   string
+		custom map[string]Custom
+	}{
+		{"shadows built-in", map[string]Custom{Email: {Pattern: `x`}}},
+		{"invalid pattern", map[string]Custom{"broken": {Pattern: `(`}}},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.custom); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
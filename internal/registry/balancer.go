@@ -0,0 +1,133 @@
+package registry
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// Load balancing strategies
+const (
+	StrategyRoundRobin       = "round_robin"
+	StrategyLeastConnections = "least_connections"
+)
+
+// Passive health ejection defaults
+const (
+	DefaultEjectAfter = 3
+	DefaultEjectFor   = 30 * time.Second
+)
+
+// instanceStats tracks load and health of one upstream instance
+type instanceStats struct {
+	inflight            int
+	consecutiveFailures int
+	ejectedUntil        time.Time
+}
+
+// balancer orders a tool's instances for each call and passively ejects
+// instances that keep failing
+type balancer struct {
+	strategy   string
+	ejectAfter int
+	ejectFor   time.Duration
+
+	next  uint64
+	mu    sync.Mutex
+	stats map[string]*instanceStats
+}
+
+// newBalancer applies defaults to a tool's load balancing settings
+func newBalancer(cfg config.LoadBalancingConfig) *balancer {
+	b := &balancer{
+		strategy:   cfg.Strategy,
+		ejectAfter: cfg.EjectAfter,
+		ejectFor:   cfg.EjectFor,
+		stats:      make(map[string]*instanceStats),
+	}
+	if b.strategy == "" {
+		b.strategy = StrategyRoundRobin
+	}
+	if b.ejectAfter <= 0 {
+		b.ejectAfter = DefaultEjectAfter
+	}
+	if b.ejectFor <= 0 {
+		b.ejectFor = DefaultEjectFor
+	}
+	return b
+}
+
+// order returns candidates in the order they should be tried. Healthy
+// instances come first, ordered by strategy; ejected instances are only
+// kept at the end as a last resort.
+func (b *balancer) order(candidates []string) []string {
+	if len(candidates) <= 1 {
+		return candidates
+	}
+
+	start := int(atomic.AddUint64(&b.next, 1) % uint64(len(candidates)))
+	rotated := make([]string, 0, len(candidates))
+	rotated = append(rotated, candidates[start:]...)
+	rotated = append(rotated, candidates[:start]...)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	healthy := make([]string, 0, len(rotated))
+	var ejected []string
+	for _, url := range rotated {
+		if s := b.stats[url]; s != nil && now.Before(s.ejectedUntil) {
+			ejected = append(ejected, url)
+			continue
+		}
+		healthy = append(healthy, url)
+	}
+
+	if b.strategy == StrategyLeastConnections {
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return b.inflightLocked(healthy[i]) < b.inflightLocked(healthy[j])
+		})
+	}
+	return append(healthy, ejected...)
+}
+
+// inflightLocked returns the in-flight count for url. Callers must hold b.mu.
+func (b *balancer) inflightLocked(url string) int {
+	if s := b.stats[url]; s != nil {
+		return s.inflight
+	}
+	return 0
+}
+
+// acquire marks a call to url as in flight. The returned function must be
+// called with the outcome once the call completes.
+func (b *balancer) acquire(url string) func(success bool) {
+	b.mu.Lock()
+	s := b.stats[url]
+	if s == nil {
+		s = &instanceStats{}
+		b.stats[url] = s
+	}
+	s.inflight++
+	b.mu.Unlock()
+
+	return func(success bool) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		s.inflight--
+		if success {
+			s.consecutiveFailures = 0
+			return
+		}
+		s.consecutiveFailures++
+		if s.consecutiveFailures >= b.ejectAfter {
+			s.ejectedUntil = time.Now().Add(b.ejectFor)
+			s.consecutiveFailures = 0
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// Circuit breaker defaults
+const (
+	DefaultBreakerFailureRate = 0.5
+	DefaultBreakerMinRequests = 10
+	DefaultBreakerWindow      = 30 * time.Second
+	DefaultBreakerOpenFor     = 30 * time.Second
+	DefaultBreakerHalfOpenMax = 1
+	breakerStateClosed        = "closed"
+	breakerStateOpen          = "open"
+	breakerStateHalfOpen      = "half_open"
+)
+
+// CircuitBreaker fast-fails calls to a tool that is persistently failing.
+// It opens when the failure rate within a window crosses the threshold,
+// stays open for a cool-down, then lets a few probe calls through
+// (half-open) to decide whether to close again.
+type CircuitBreaker struct {
+	mu sync.Mutex
+
+	enabled     bool
+	failureRate float64
+	minRequests int
+	window      time.Duration
+	openFor     time.Duration
+	halfOpenMax int
+
+	state       string
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+}
+
+// newCircuitBreaker creates a closed breaker with the given settings
+func newCircuitBreaker(cfg config.BreakerConfig) *CircuitBreaker {
+	b := &CircuitBreaker{state: breakerStateClosed}
+	b.configure(cfg)
+	return b
+}
+
+// configure applies settings, keeping the current state and counters
+func (b *CircuitBreaker) configure(cfg config.BreakerConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.enabled = cfg.Enabled
+	b.failureRate = cfg.FailureRate
+	if b.failureRate <= 0 {
+		b.failureRate = DefaultBreakerFailureRate
+	}
+	b.minRequests = cfg.MinRequests
+	if b.minRequests <= 0 {
+		b.minRequests = DefaultBreakerMinRequests
+	}
+	b.window = cfg.Window
+	if b.window <= 0 {
+		b.window = DefaultBreakerWindow
+	}
+	b.openFor = cfg.OpenFor
+	if b.openFor <= 0 {
+		b.openFor = DefaultBreakerOpenFor
+	}
+	b.halfOpenMax = cfg.HalfOpenRequests
+	if b.halfOpenMax <= 0 {
+		b.halfOpenMax = DefaultBreakerHalfOpenMax
+	}
+	if !b.enabled {
+		b.reset(breakerStateClosed, time.Now())
+	}
+}
+
+// Allow reports whether a call may proceed. When it may, the caller must
+// report the outcome through the returned done function. When it may not,
+// retryAfter is the time until the breaker will allow a probe.
+func (b *CircuitBreaker) Allow() (done func(success bool), retryAfter time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.enabled {
+		return func(bool) {}, 0, true
+	}
+
+	now := time.Now()
+	if b.state == breakerStateOpen {
+		if wait := b.openedAt.Add(b.openFor).Sub(now); wait > 0 {
+			return nil, wait, false
+		}
+		b.reset(breakerStateHalfOpen, now)
+	}
+
+	if b.state == breakerStateHalfOpen {
+		if b.probes >= b.halfOpenMax {
+			return nil, time.Second, false
+		}
+		b.probes++
+	}
+
+	return b.record, 0, true
+}
+
+// record updates the breaker with the outcome of a call
+func (b *CircuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case breakerStateHalfOpen:
+		if success {
+			b.reset(breakerStateClosed, now)
+		} else {
+			b.trip(now)
+		}
+	case breakerStateClosed:
+		if now.Sub(b.windowStart) >= b.window {
+			b.reset(breakerStateClosed, now)
+		}
+		b.requests++
+		if !success {
+			b.failures++
+		}
+		if b.requests >= b.minRequests && float64(b.failures)/float64(b.requests) >= b.failureRate {
+			b.trip(now)
+		}
+	}
+}
+
+// State returns "closed", "open" or "half_open"
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerStateOpen && time.Since(b.openedAt) >= b.openFor {
+		return breakerStateHalfOpen
+	}
+	return b.state
+}
+
+// trip opens the breaker
+func (b *CircuitBreaker) trip(now time.Time) {
+	b.reset(breakerStateOpen, now)
+	b.openedAt = now
+}
+
+// reset moves to state with fresh counters
+func (b *CircuitBreaker) reset(state string, now time.Time) {
+	b.state = state
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+	b.probes = 0
+}
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// Cache defaults used when a tool's cache leaves them unset
+const (
+	DefaultCacheTTL          = 30 * time.Second
+	DefaultCacheMaxEntries   = 1000
+	DefaultCacheMaxBodyBytes = 1 << 20
+)
+
+// CachedResponse is a tool response kept for reuse
+type CachedResponse struct {
+	Status   int
+	Header   http.Header
+	Body     []byte
+	StoredAt time.Time
+}
+
+// ResponseCache keeps successful responses of a tool's read-only actions
+// for a short TTL. A nil cache caches nothing.
+type ResponseCache struct {
+	actions      map[string]bool
+	ttl          time.Duration
+	maxEntries   int
+	maxBodyBytes int
+
+	mu      sync.Mutex
+	entries map[string]*CachedResponse
+}
+
+// newResponseCache returns nil when no action is cacheable
+func newResponseCache(cfg config.CacheConfig) *ResponseCache {
+	if len(cfg.Actions) == 0 {
+		return nil
+	}
+	c := &ResponseCache{
+		actions:      make(map[string]bool, len(cfg.Actions)),
+		ttl:          cfg.TTL,
+		maxEntries:   cfg.MaxEntries,
+		maxBodyBytes: cfg.MaxBodyBytes,
+		entries:      make(map[string]*CachedResponse),
+	}
+	for _, action := range cfg.Actions {
+		c.actions[action] = true
+	}
+	if c.ttl == 0 {
+		c.ttl = DefaultCacheTTL
+	}
+	if c.maxEntries == 0 {
+		c.maxEntries = DefaultCacheMaxEntries
+	}
+	if c.maxBodyBytes == 0 {
+		c.maxBodyBytes = DefaultCacheMaxBodyBytes
+	}
+	return c
+}
+
+// Cacheable reports whether responses to action may be cached
+func (c *ResponseCache) Cacheable(action string) bool {
+	return c != nil && c.actions[action]
+}
+
+// MaxBodyBytes is the size of the largest response worth caching
+func (c *ResponseCache) MaxBodyBytes() int {
+	return c.maxBodyBytes
+}
+
+// Get returns the unexpired response stored under key
+func (c *ResponseCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(resp.StoredAt) > c.ttl {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return resp, true
+}
+
+// Put stores a response, evicting expired entries and then the oldest one
+// when the cache is full
+func (c *ResponseCache) Put(key string, resp *CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.maxEntries {
+		var oldestKey string
+		var oldest time.Time
+		for k, e := range c.entries {
+			if time.Since(e.StoredAt) > c.ttl {
+				delete(c.entries, k)
+				continue
+			}
+			if oldestKey == "" || e.StoredAt.Before(oldest) {
+				oldestKey, oldest = k, e.StoredAt
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			delete(c.entries, oldestKey)
+		}
+	}
+	c.entries[key] = resp
+}
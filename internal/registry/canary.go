@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// Versions of a tool that calls can be routed to
+const (
+	TargetStable = "stable"
+	TargetCanary = "canary"
+)
+
+// canaryBuckets is the resolution of canary weights (0.01%)
+const canaryBuckets = 10000
+
+// Route returns the version of the tool that serves agentID: the canary for
+// the configured share of agents, the tool itself otherwise
+func (t *Tool) Route(agentID string) *Tool {
+	if t.Canary == nil || t.CanaryWeight <= 0 {
+		return t
+	}
+	sum := sha256.Sum256([]byte(t.Name + "|" + agentID))
+	bucket := binary.BigEndian.Uint64(sum[:8]) % canaryBuckets
+	if float64(bucket) < t.CanaryWeight*canaryBuckets/100 {
+		return t.Canary
+	}
+	return t
+}
+
+// Allow checks the circuit breaker like Breaker.Allow and also counts the
+// call, its outcome and its latency in the version's stats
+func (t *Tool) Allow() (done func(success bool), retryAfter time.Duration, ok bool) {
+	breakerDone, retryAfter, ok := t.Breaker.Allow()
+	if !ok {
+		return nil, retryAfter, false
+	}
+	start := time.Now()
+	return func(success bool) {
+		breakerDone(success)
+		t.Stats.record(success, time.Since(start))
+	}, 0, true
+}
+
+// TargetStats counts the calls forwarded to one version of a tool
+type TargetStats struct {
+	requests  atomic.Int64
+	errors    atomic.Int64
+	latencyMS atomic.Int64
+}
+
+// TargetSnapshot is a point-in-time copy of a version's stats
+type TargetSnapshot struct {
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+}
+
+// record counts a finished call
+func (s *TargetStats) record(success bool, latency time.Duration) {
+	s.requests.Add(1)
+	if !success {
+		s.errors.Add(1)
+	}
+	s.latencyMS.Add(latency.Milliseconds())
+}
+
+// Snapshot returns the counts so far
+func (s *TargetStats) Snapshot() TargetSnapshot {
+	snap := TargetSnapshot{Requests: s.requests.Load(), Errors: s.errors.Load()}
+	if snap.Requests > 0 {
+		snap.ErrorRate = float64(snap.Errors) / float64(snap.Requests)
+		snap.AvgLatencyMS = float64(s.latencyMS.Load()) / float64(snap.Requests)
+	}
+	return snap
+}
@@ -0,0 +1,26 @@
+package registry
+
+import (
+	"testing"
+
+	"aegis-gateway/internal/config"
+)
+
+func TestAllowRecordsOutcome(t *testing.T) {
+	r := NewToolRegistry(map[string]config.ToolConfig{
+		"search": {URL: "http://127.0.0.1:1", Timeout: config.DefaultToolTimeout},
+	})
+	defer r.Close()
+	tool, _ := r.Get("search")
+
+	for _, success := range []bool{true, false} {
+		done, _, ok := tool.Allow()
+		if !ok {
+			t.Fatal("closed breaker refused a call")
+		}
+		done(success)
+	}
+	if snap := tool.Stats.Snapshot(); snap.Requests != 2 || snap.Errors != 1 {
+		t.Fatalf("got %+v, want 2 requests and 1 error", snap)
+	}
+}
@@ -0,0 +1,220 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDiscoveryRefresh is how often discovered instances are re-resolved
+const DefaultDiscoveryRefresh = 15 * time.Second
+
+// Resolver looks up the base URLs of a tool's instances
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// NewResolver builds a resolver from a discovery reference:
+//
+//	consul://payments-svc?tag=v2&scheme=https   Consul health API (CONSUL_HTTP_ADDR)
+//	srv://_payments._tcp.example.internal       DNS SRV records
+//	k8s://payments-svc.finance:8081             Kubernetes Service via cluster DNS
+func NewResolver(ref string) (Resolver, error) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid discovery reference %q", ref)
+	}
+
+	scheme := u.Query().Get("scheme")
+	if scheme == "" {
+		scheme = "http"
+	}
+	if scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("invalid discovery scheme %q", scheme)
+	}
+
+	switch u.Scheme {
+	case "consul":
+		addr := os.Getenv("CONSUL_HTTP_ADDR")
+		if addr == "" {
+			addr = "http://127.0.0.1:8500"
+		}
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		return &consulResolver{addr: addr, service: u.Host, tag: u.Query().Get("tag"), scheme: scheme}, nil
+	case "srv", "dns+srv":
+		return &srvResolver{name: u.Host, scheme: scheme}, nil
+	case "k8s", "kubernetes":
+		return newKubernetesResolver(u, scheme)
+	default:
+		return nil, fmt.Errorf("unsupported discovery scheme %q", u.Scheme)
+	}
+}
+
+// consulResolver queries Consul for passing instances of a service
+type consulResolver struct {
+	addr    string
+	service string
+	tag     string
+	scheme  string
+}
+
+// consulEntry is the subset of a Consul health API entry we use
+type consulEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+// Resolve implements Resolver
+func (c *consulResolver) Resolve(ctx context.Context) ([]string, error) {
+	query := url.Values{"passing": {"true"}}
+	if c.tag != "" {
+		query.Set("tag", c.tag)
+	}
+	endpoint := fmt.Sprintf("%s/v1/health/service/%s?%s", strings.TrimSuffix(c.addr, "/"), url.PathEscape(c.service), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("consul lookup failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul lookup failed: status %d", resp.StatusCode)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("consul lookup failed: %w", err)
+	}
+
+	urls := make([]string, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		urls = append(urls, fmt.Sprintf("%s://%s", c.scheme, net.JoinHostPort(host, strconv.Itoa(e.Service.Port))))
+	}
+	sort.Strings(urls)
+	return urls, nil
+}
+
+// srvResolver looks up DNS SRV records, ordered by priority then weight
+type srvResolver struct {
+	name   string
+	scheme string
+}
+
+// Resolve implements Resolver
+func (s *srvResolver) Resolve(ctx context.Context) ([]string, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", s.name)
+	if err != nil {
+		return nil, fmt.Errorf("SRV lookup failed: %w", err)
+	}
+
+	urls := make([]string, 0, len(records))
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		urls = append(urls, fmt.Sprintf("%s://%s", s.scheme, net.JoinHostPort(host, strconv.Itoa(int(r.Port)))))
+	}
+	return urls, nil
+}
+
+// kubernetesResolver resolves a Service through cluster DNS. Headless
+// services return one address per ready pod.
+type kubernetesResolver struct {
+	host   string
+	port   string
+	scheme string
+}
+
+// serviceAccountNamespace is where pods find their own namespace
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// newKubernetesResolver parses k8s://service[.namespace]:port
+func newKubernetesResolver(u *url.URL, scheme string) (*kubernetesResolver, error) {
+	port := u.Port()
+	if port == "" {
+		return nil, fmt.Errorf("kubernetes discovery requires a port, e.g. k8s://payments-svc:8081")
+	}
+
+	name := u.Hostname()
+	if !strings.Contains(name, ".") {
+		namespace := "default"
+		if data, err := os.ReadFile(serviceAccountNamespace); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+		name = name + "." + namespace
+	}
+	if !strings.HasSuffix(name, ".svc.cluster.local") {
+		name += ".svc.cluster.local"
+	}
+
+	return &kubernetesResolver{host: name, port: port, scheme: scheme}, nil
+}
+
+// Resolve implements Resolver
+func (k *kubernetesResolver) Resolve(ctx context.Context) ([]string, error) {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, k.host)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes service lookup failed: %w", err)
+	}
+	sort.Strings(addrs)
+
+	urls := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		urls = append(urls, fmt.Sprintf("%s://%s", k.scheme, net.JoinHostPort(addr, k.port)))
+	}
+	return urls, nil
+}
+
+// refresh re-resolves the tool's instances until ctx is cancelled. Failed
+// lookups keep the last known instances so a flaky registry doesn't take a
+// healthy tool offline.
+func (t *Tool) refresh(ctx context.Context, resolver Resolver, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		urls, err := resolver.Resolve(lookupCtx)
+		cancel()
+
+		switch {
+		case err != nil:
+			logger.Error("Discovery failed", "tool", t.Name, "error", err)
+		case len(urls) == 0:
+			logger.Warn("Discovery returned no instances", "tool", t.Name)
+		default:
+			t.setInstances(urls)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// DefaultQueueTimeout is how long a call waits for a slot when no
+// queue_timeout is configured
+const DefaultQueueTimeout = time.Second
+
+// Errors returned when a call can't get a concurrency slot
+var (
+	ErrQueueFull    = errors.New("too many calls waiting for the tool")
+	ErrQueueTimeout = errors.New("timed out waiting for the tool")
+)
+
+// ConcurrencyLimiter caps in-flight calls to a tool. Calls beyond the cap
+// wait in a bounded FIFO queue until a slot frees up or they time out.
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	maxQueue int
+	timeout  time.Duration
+
+	inFlight int
+	waiters  []chan struct{}
+}
+
+// newConcurrencyLimiter creates a limiter with the given settings
+func newConcurrencyLimiter(cfg config.ConcurrencyConfig) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{}
+	l.configure(cfg)
+	return l
+}
+
+// configure applies settings, keeping in-flight calls counted. Waiters that
+// fit under a raised limit are let through immediately.
+func (l *ConcurrencyLimiter) configure(cfg config.ConcurrencyConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = cfg.MaxInFlight
+	l.maxQueue = cfg.MaxQueue
+	l.timeout = cfg.QueueTimeout
+	if l.timeout <= 0 {
+		l.timeout = DefaultQueueTimeout
+	}
+	for len(l.waiters) > 0 && (l.limit == 0 || l.inFlight < l.limit) {
+		l.handOffLocked()
+	}
+}
+
+// Acquire takes a slot, waiting in the queue if none is free. The returned
+// function must be called once the call has finished.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) (release func(), err error) {
+	l.mu.Lock()
+	if l.limit == 0 || l.inFlight < l.limit {
+		l.inFlight++
+		l.mu.Unlock()
+		return l.release, nil
+	}
+	if len(l.waiters) >= l.maxQueue {
+		l.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	timeout := l.timeout
+	l.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return l.release, nil
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, w := range l.waiters {
+		if w == ready {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return nil, err
+		}
+	}
+	// The slot was handed over while giving up; keep it
+	return l.release, nil
+}
+
+// release frees a slot, handing it straight to the longest waiting call
+func (l *ConcurrencyLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	if len(l.waiters) > 0 && (l.limit == 0 || l.inFlight < l.limit) {
+		l.handOffLocked()
+	}
+}
+
+// handOffLocked gives a slot to the first waiter
+func (l *ConcurrencyLimiter) handOffLocked() {
+	close(l.waiters[0])
+	l.waiters = l.waiters[1:]
+	l.inFlight++
+}
+
+// InFlight returns the number of running and queued calls
+func (l *ConcurrencyLimiter) InFlight() (running, queued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight, len(l.waiters)
+}
@@ -0,0 +1,458 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/injection"
+	"aegis-gateway/internal/logging"
+	"aegis-gateway/internal/schema"
+)
+
+// logger is the registry component's logger
+var logger = logging.For("registry")
+
+// Tool protocols
+const (
+	ProtocolHTTP    = "http"
+	ProtocolGRPC    = "grpc"
+	ProtocolMCP     = "mcp"
+	ProtocolGraphQL = "graphql"
+	ProtocolSQL     = "sql"
+	ProtocolExec    = "exec"
+	ProtocolFiles   = "files"
+	ProtocolEmail   = "email"
+	ProtocolFetch   = "fetch"
+)
+
+// Tool is a registered upstream tool backend
+type Tool struct {
+	Name        string
+	Protocol    string
+	GRPC        config.GRPCToolConfig
+	SQL         config.SQLToolConfig
+	Exec        config.ExecToolConfig
+	Files       config.FilesToolConfig
+	Email       config.EmailToolConfig
+	Fetch       config.FetchToolConfig
+	URL         string
+	URLs        []string
+	Timeout     time.Duration
+	Retry       RetryPolicy
+	Credentials config.CredentialsConfig
+	Methods     []string
+	HealthCheck string
+	Discovery   string
+	SPIFFEID    string
+	WebSocket   config.WebSocketConfig
+	Transport   config.TransportConfig
+	Breaker     *CircuitBreaker
+	Limiter     *ConcurrencyLimiter
+
+	// Cache is nil unless some actions are cacheable
+	Cache *ResponseCache
+
+	// OnPolicyError overrides the global on_policy_error behavior if set
+	OnPolicyError string
+
+	// Injection is nil unless the tool's injection filter is enabled;
+	// InjectionFilter holds its mode
+	Injection       *injection.Filter
+	InjectionFilter config.InjectionFilterConfig
+
+	// Schemas is nil unless the tool has request and response schemas
+	Schemas *schema.Set
+
+	// Capture lists the params recorded with decisions
+	Capture config.CaptureConfig
+
+	// Decoy is set for honeytoken tools; DecoyActions are the decoy
+	// actions of a real tool
+	Decoy        bool
+	DecoyActions []string
+
+	// Target is TargetStable, or TargetCanary for a tool's canary version
+	Target string
+
+	// Canary is the version CanaryWeight percent of agents are routed to,
+	// nil unless configured (see Route)
+	Canary       *Tool
+	CanaryWeight float64
+
+	// Stats counts the calls forwarded to this version
+	Stats *TargetStats
+
+	mu        sync.RWMutex
+	instances []string
+	balancer  *balancer
+}
+
+// Instances returns the base URLs requests may be sent to, in the order they
+// should be tried. Discovered instances take precedence over the static
+// url/urls once the first lookup succeeds.
+func (t *Tool) Instances() []string {
+	t.mu.RLock()
+	instances := t.instances
+	t.mu.RUnlock()
+
+	if len(instances) == 0 {
+		instances = t.URLs
+	}
+	return t.balancer.order(instances)
+}
+
+// Acquire marks a call to the instance at baseURL as in flight for load
+// balancing. The returned function must be called with the call's outcome;
+// instances that keep failing are ejected for a while.
+func (t *Tool) Acquire(baseURL string) func(success bool) {
+	return t.balancer.acquire(baseURL)
+}
+
+// setInstances replaces the discovered instances
+func (t *Tool) setInstances(urls []string) {
+	t.mu.Lock()
+	t.instances = urls
+	t.mu.Unlock()
+}
+
+// AllowsMethod reports whether agents may call the tool with method
+func (t *Tool) AllowsMethod(method string) bool {
+	for _, m := range t.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDecoy reports whether a call to action trips the tripwire
+func (t *Tool) IsDecoy(action string) bool {
+	if t.Decoy {
+		return true
+	}
+	for _, a := range t.DecoyActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// ActionURL returns the upstream URL for an action on the given instance.
+// An empty action addresses the instance URL itself, as for GraphQL tools.
+func ActionURL(baseURL, action string) string {
+	if action == "" {
+		return baseURL
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(baseURL, "/"), action)
+}
+
+// HealthURL returns the health check URL of the first available instance,
+// or "" if no health check is configured
+func (t *Tool) HealthURL() string {
+	instances := t.Instances()
+	if t.HealthCheck == "" || len(instances) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(instances[0], "/") + t.HealthCheck
+}
+
+// ToolRegistry holds the tool backends the gateway can forward to
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]*Tool
+
+	// stopDiscovery cancels the refresh loops of the current tool set
+	stopDiscovery context.CancelFunc
+
+	// breakers outlive reloads so a failing tool stays open across them
+	breakers map[string]*CircuitBreaker
+
+	// limiters outlive reloads so in-flight calls stay counted
+	limiters map[string]*ConcurrencyLimiter
+
+	// stats outlive reloads so a rollout's numbers aren't reset by them
+	stats map[string]*TargetStats
+}
+
+// NewToolRegistry creates a registry from the tools section of the config
+func NewToolRegistry(tools map[string]config.ToolConfig) *ToolRegistry {
+	r := &ToolRegistry{}
+	r.Load(tools)
+	return r
+}
+
+// Load replaces the registered tools. In-flight requests keep the tool
+// definition they started with. Tools using discovery are re-resolved in
+// the background until the next Load or Close.
+func (r *ToolRegistry) Load(tools map[string]config.ToolConfig) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.mu.Lock()
+	if r.breakers == nil {
+		r.breakers = make(map[string]*CircuitBreaker)
+	}
+	// Canary versions get their own breaker and stats, keyed "<name>/canary"
+	breakers := make(map[string]*CircuitBreaker, len(tools))
+	stats := make(map[string]*TargetStats, len(tools))
+	for name, tc := range tools {
+		keys := []string{name}
+		if tc.Canary.Enabled() {
+			keys = append(keys, name+"/"+TargetCanary)
+		}
+		for _, key := range keys {
+			if b, ok := r.breakers[key]; ok {
+				b.configure(tc.CircuitBreaker)
+				breakers[key] = b
+			} else {
+				breakers[key] = newCircuitBreaker(tc.CircuitBreaker)
+			}
+			if s, ok := r.stats[key]; ok {
+				stats[key] = s
+			} else {
+				stats[key] = &TargetStats{}
+			}
+		}
+	}
+	r.breakers = breakers
+	r.stats = stats
+
+	limiters := make(map[string]*ConcurrencyLimiter, len(tools))
+	for name, tc := range tools {
+		if l, ok := r.limiters[name]; ok {
+			l.configure(tc.Concurrency)
+			limiters[name] = l
+		} else {
+			limiters[name] = newConcurrencyLimiter(tc.Concurrency)
+		}
+	}
+	r.limiters = limiters
+	r.mu.Unlock()
+
+	loaded := make(map[string]*Tool, len(tools))
+	for name, tc := range tools {
+		tool := newTool(name, tc)
+		tool.Breaker = breakers[name]
+		tool.Limiter = limiters[name]
+		tool.Stats = stats[name]
+		if tc.Canary.Enabled() {
+			canaryCfg := tc
+			canaryCfg.URL, canaryCfg.URLs, canaryCfg.Discovery = tc.Canary.URL, tc.Canary.URLs, ""
+			canary := newTool(name, canaryCfg)
+			canary.Target = TargetCanary
+			canary.Breaker = breakers[name+"/"+TargetCanary]
+			canary.Limiter = tool.Limiter
+			canary.Stats = stats[name+"/"+TargetCanary]
+			tool.Canary, tool.CanaryWeight = canary, tc.Canary.Weight
+		}
+		loaded[name] = tool
+
+		if tc.Discovery == "" {
+			continue
+		}
+		resolver, err := NewResolver(tc.Discovery)
+		if err != nil {
+			logger.Error("Invalid tool", "tool", name, "error", err)
+			continue
+		}
+		every := tc.DiscoveryRefresh
+		if every <= 0 {
+			every = DefaultDiscoveryRefresh
+		}
+		go tool.refresh(ctx, resolver, every)
+	}
+
+	r.mu.Lock()
+	stop := r.stopDiscovery
+	r.tools = loaded
+	r.stopDiscovery = cancel
+	r.mu.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+}
+
+// Close stops background discovery
+func (r *ToolRegistry) Close() {
+	r.mu.Lock()
+	stop := r.stopDiscovery
+	r.stopDiscovery = nil
+	r.mu.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+}
+
+// newTool applies defaults to a tool's configuration
+func newTool(name string, tc config.ToolConfig) *Tool {
+	methods := make([]string, 0, len(tc.Methods))
+	for _, m := range tc.Methods {
+		methods = append(methods, strings.ToUpper(m))
+	}
+	if len(methods) == 0 {
+		methods = []string{http.MethodPost}
+	}
+
+	timeout := tc.Timeout
+	if timeout == 0 {
+		timeout = config.DefaultToolTimeout
+	}
+
+	var urls []string
+	if tc.URL != "" {
+		urls = append(urls, tc.URL)
+	}
+	urls = append(urls, tc.URLs...)
+
+	protocol := tc.Protocol
+	if protocol == "" {
+		protocol = ProtocolHTTP
+	}
+
+	return &Tool{
+		Name:            name,
+		Protocol:        protocol,
+		GRPC:            tc.GRPC,
+		SQL:             tc.SQL,
+		Exec:            tc.Exec,
+		Files:           tc.Files,
+		Email:           tc.Email,
+		Fetch:           tc.Fetch,
+		URL:             tc.URL,
+		URLs:            urls,
+		balancer:        newBalancer(tc.LoadBalancing),
+		Timeout:         timeout,
+		Retry:           newRetryPolicy(tc.Retries, tc.Retry),
+		Credentials:     tc.Credentials,
+		Methods:         methods,
+		HealthCheck:     tc.HealthCheck,
+		Discovery:       tc.Discovery,
+		SPIFFEID:        tc.SPIFFEID,
+		WebSocket:       tc.WebSocket,
+		Transport:       tc.Transport,
+		Cache:           newResponseCache(tc.Cache),
+		OnPolicyError:   tc.OnPolicyError,
+		Injection:       newInjectionFilter(name, tc.InjectionFilter),
+		InjectionFilter: tc.InjectionFilter,
+		Schemas:         newSchemas(name, tc.Schema),
+		Capture:         tc.Capture,
+		Decoy:           tc.Decoy,
+		DecoyActions:    tc.DecoyActions,
+		Target:          TargetStable,
+	}
+}
+
+// newInjectionFilter builds the tool's injection filter, or nil if it is
+// off. Invalid patterns are rejected by config validation; should one get
+// through, only the built-in patterns are used.
+func newInjectionFilter(name string, cfg config.InjectionFilterConfig) *injection.Filter {
+	if !cfg.Enabled() {
+		return nil
+	}
+	f, err := injection.New(cfg.Patterns, cfg.Disable)
+	if err != nil {
+		logger.Error("Custom injection patterns disabled", "tool", name, "error", err)
+		f, _ = injection.New(nil, nil)
+	}
+	return f
+}
+
+// newSchemas loads the tool's schemas, or returns nil if it has none.
+// Invalid schemas are rejected by config validation; should a file change
+// in between, the tool goes without schemas.
+func newSchemas(name string, cfg config.SchemaConfig) *schema.Set {
+	if !cfg.Enabled() {
+		return nil
+	}
+	set, err := schema.Load(cfg.Sources(), cfg.Current)
+	if err != nil {
+		logger.Error("Schemas disabled", "tool", name, "error", err)
+		return nil
+	}
+	return set
+}
+
+// Get returns the named tool
+func (r *ToolRegistry) Get(name string) (*Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// Names returns the registered tool names in sorted order
+func (r *ToolRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Declares reports whether addr, a host:port, is a static or discovered
+// instance of a registered tool or its canary, or a tool's proxy
+func (r *ToolRegistry) Declares(addr string) bool {
+	if addr == "" {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, tool := range r.tools {
+		if tool.declares(addr) || (tool.Canary != nil && tool.Canary.declares(addr)) {
+			return true
+		}
+	}
+	return false
+}
+
+// declares reports whether addr is one of the tool's instances or its proxy
+func (t *Tool) declares(addr string) bool {
+	if t.Transport.Proxy != "" && strings.EqualFold(HostPort(t.Transport.Proxy), addr) {
+		return true
+	}
+	t.mu.RLock()
+	discovered := t.instances
+	t.mu.RUnlock()
+	for _, list := range [][]string{t.URLs, discovered} {
+		for _, instance := range list {
+			if strings.EqualFold(HostPort(instance), addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HostPort returns the host:port rawURL connects to, filling in the
+// scheme's default port, or "" if rawURL can't be parsed
+func HostPort(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if port := u.Port(); port != "" {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	switch u.Scheme {
+	case "https", "wss", "grpcs":
+		return net.JoinHostPort(u.Hostname(), "443")
+	case "socks5":
+		return net.JoinHostPort(u.Hostname(), "1080")
+	default:
+		return net.JoinHostPort(u.Hostname(), "80")
+	}
+}
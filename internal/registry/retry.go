@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// Retry defaults used when a tool doesn't override them
+const (
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultRetryMaxBackoff = 2 * time.Second
+)
+
+// defaultRetryStatusCodes are the upstream responses retried by default
+var defaultRetryStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// RetryPolicy decides whether and when a failed forward is retried
+type RetryPolicy struct {
+	// Attempts is the number of extra attempts after the first
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	statusCodes       map[int]bool
+	idempotentActions map[string]bool
+}
+
+// newRetryPolicy applies defaults to a tool's retry settings
+func newRetryPolicy(retries int, rc config.RetryConfig) RetryPolicy {
+	p := RetryPolicy{
+		Attempts:          retries,
+		Backoff:           rc.Backoff,
+		MaxBackoff:        rc.MaxBackoff,
+		statusCodes:       make(map[int]bool),
+		idempotentActions: make(map[string]bool),
+	}
+	if p.Backoff == 0 {
+		p.Backoff = DefaultRetryBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = DefaultRetryMaxBackoff
+	}
+
+	codes := rc.StatusCodes
+	if len(codes) == 0 {
+		codes = defaultRetryStatusCodes
+	}
+	for _, code := range codes {
+		p.statusCodes[code] = true
+	}
+	for _, action := range rc.IdempotentActions {
+		p.idempotentActions[action] = true
+	}
+	return p
+}
+
+// RetryableStatus reports whether an upstream status code is worth retrying
+func (p RetryPolicy) RetryableStatus(code int) bool {
+	return p.statusCodes[code]
+}
+
+// Idempotent reports whether a call may safely be sent more than once.
+// Safe HTTP methods, actions listed as idempotent and requests carrying an
+// Idempotency-Key qualify.
+func (p RetryPolicy) Idempotent(method, action string, hasIdempotencyKey bool) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return hasIdempotencyKey || p.idempotentActions[action]
+}
+
+// Delay returns the wait before the given retry (1-based), using
+// exponential backoff with full jitter
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	ceiling := p.Backoff
+	for i := 1; i < retry && ceiling < p.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
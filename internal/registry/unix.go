@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SchemeUnix is the URL scheme of tools reached over a Unix domain socket,
+// e.g. unix:///var/run/payments.sock
+const SchemeUnix = "unix"
+
+// UnixSocket splits a URL under a registered unix:// instance into the
+// socket it is served on and the HTTP path on that socket. ok is false if
+// no registered instance is a prefix of u.
+func (r *ToolRegistry) UnixSocket(u *url.URL) (socket, path string, ok bool) {
+	if u.Scheme != SchemeUnix {
+		return "", "", false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, tool := range r.tools {
+		tools := []*Tool{tool}
+		if tool.Canary != nil {
+			tools = append(tools, tool.Canary)
+		}
+		for _, t := range tools {
+			for _, instance := range t.URLs {
+				base, err := url.Parse(instance)
+				if err != nil || base.Scheme != SchemeUnix {
+					continue
+				}
+				candidate := strings.TrimSuffix(base.Path, "/")
+				rest, found := strings.CutPrefix(u.Path, candidate)
+				if !found || (rest != "" && !strings.HasPrefix(rest, "/")) || len(candidate) <= len(socket) {
+					continue
+				}
+				socket, path, ok = candidate, rest, true
+			}
+		}
+	}
+	if path == "" {
+		path = "/"
+	}
+	return socket, path, ok
+}
+
+// UnixTransport sends requests for unix:// tool URLs over the tool's socket
+// as plain HTTP. Register it on a transport with
+// RegisterProtocol(SchemeUnix, ...).
+type UnixTransport struct {
+	registry  *ToolRegistry
+	transport *http.Transport
+}
+
+// NewUnixTransport returns a transport for the registry's unix:// tools.
+// base supplies the pool settings; it is modified, so pass a clone.
+func (r *ToolRegistry) NewUnixTransport(base *http.Transport) *UnixTransport {
+	base.Proxy = nil
+	base.TLSClientConfig = nil
+	// Each socket gets its own pool, keyed by the socket path encoded as
+	// the request host
+	base.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		socket, err := hex.DecodeString(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unix socket address %s", addr)
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", string(socket))
+	}
+	return &UnixTransport{registry: r, transport: base}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *UnixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	socket, path, ok := t.registry.UnixSocket(req.URL)
+	if !ok {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%s is not under a registered unix socket", req.URL)
+	}
+	out := req.Clone(req.Context())
+	out.URL = &url.URL{Scheme: "http", Host: hex.EncodeToString([]byte(socket)), Path: path, RawQuery: req.URL.RawQuery}
+	out.Host = "localhost"
+	return t.transport.RoundTrip(out)
+}
@@ -0,0 +1,120 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strconv"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// render renders a report in one format. Files are named after the report
+// and the day its period ends.
+func render(report Report, format string) (File, error) {
+	file := File{Name: fmt.Sprintf("%s-%s.%s", report.Name, report.Until.Format("2006-01-02"), format)}
+	var err error
+	switch format {
+	case config.ReportFormatJSON:
+		file.ContentType = "application/json"
+		file.Data, err = json.MarshalIndent(report, "", "  ")
+	case config.ReportFormatCSV:
+		file.ContentType = "text/csv"
+		file.Data, err = renderCSV(report)
+	case config.ReportFormatHTML:
+		file.ContentType = "text/html; charset=utf-8"
+		var buf bytes.Buffer
+		err = htmlReport.Execute(&buf, report)
+		file.Data = buf.Bytes()
+	default:
+		err = fmt.Errorf("unknown format %s", format)
+	}
+	return file, err
+}
+
+// renderCSV writes a report as one table whose section column says what
+// each row is: total, agent, tool, denial or policy_change
+func renderCSV(report Report) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	n := strconv.Itoa
+	w.Write([]string{"section", "agent_id", "tool", "code", "calls", "allowed", "denied", "detail", "time"})
+	w.Write([]string{"total", "", "", "", n(report.Calls), n(report.Allowed), n(report.Denied),
+		report.Since.Format(time.RFC3339) + " to " + report.Until.Format(time.RFC3339), report.GeneratedAt.Format(time.RFC3339)})
+	for _, a := range report.Agents {
+		w.Write([]string{"agent", a.AgentID, "", "", n(a.Calls), n(a.Allowed), n(a.Denied), "", ""})
+	}
+	for _, t := range report.Tools {
+		w.Write([]string{"tool", "", t.Tool, "", n(t.Calls), n(t.Allowed), n(t.Denied), "", ""})
+	}
+	for _, d := range report.Denials {
+		w.Write([]string{"denial", d.AgentID, d.Tool, d.Code, "", "", n(d.Count), d.Reason, d.Last})
+	}
+	for _, event := range report.PolicyChanges {
+		for _, c := range event.Changes {
+			agent := c.AgentID
+			if agent == "" && c.Group != "" {
+				agent = "group:" + c.Group
+			}
+			if agent == "" {
+				agent = "defaults"
+			}
+			w.Write([]string{"policy_change", agent, c.Tool, c.Type, "", "", "", event.Source, event.Timestamp.UTC().Format(time.RFC3339)})
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} audit report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+td.n { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Name}} audit report</h1>
+<p>{{time .Since}} to {{time .Until}} ({{.Period}}), generated {{time .GeneratedAt}}</p>
+<p>{{.Calls}} calls: {{.Allowed}} allowed, {{.Denied}} denied.</p>
+
+<h2>Agents</h2>
+<table>
+<tr><th>Agent</th><th>Calls</th><th>Allowed</th><th>Denied</th></tr>
+{{range .Agents}}<tr><td>{{.AgentID}}</td><td class="n">{{.Calls}}</td><td class="n">{{.Allowed}}</td><td class="n">{{.Denied}}</td></tr>
+{{else}}<tr><td colspan="4">No calls</td></tr>
+{{end}}</table>
+
+<h2>Tools</h2>
+<table>
+<tr><th>Tool</th><th>Calls</th><th>Allowed</th><th>Denied</th></tr>
+{{range .Tools}}<tr><td>{{.Tool}}</td><td class="n">{{.Calls}}</td><td class="n">{{.Allowed}}</td><td class="n">{{.Denied}}</td></tr>
+{{else}}<tr><td colspan="4">No calls</td></tr>
+{{end}}</table>
+
+<h2>Notable denials</h2>
+<table>
+<tr><th>Agent</th><th>Tool</th><th>Code</th><th>Count</th><th>Latest reason</th><th>Latest</th></tr>
+{{range .Denials}}<tr><td>{{.AgentID}}</td><td>{{.Tool}}</td><td>{{.Code}}</td><td class="n">{{.Count}}</td><td>{{.Reason}}</td><td>{{.Last}}</td></tr>
+{{else}}<tr><td colspan="6">No denials</td></tr>
+{{end}}</table>
+
+<h2>Policy changes</h2>
+<table>
+<tr><th>Time</th><th>File</th><th>Change</th><th>Agent or group</th><th>Tool</th></tr>
+{{range $event := .PolicyChanges}}{{range .Changes}}<tr><td>{{time $event.Timestamp}}</td><td>{{$event.Source}}</td><td>{{.Type}}</td><td>{{if .AgentID}}{{.AgentID}}{{else if .Group}}group {{.Group}}{{else}}tool defaults{{end}}</td><td>{{.Tool}}</td></tr>
+{{end}}{{else}}<tr><td colspan="5">No policy changes</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
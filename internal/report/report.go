@@ -0,0 +1,284 @@
+// Package report generates audit reports for compliance reviews on a
+// schedule: calls per agent and per tool, notable denials and policy
+// changes, as JSON, CSV or HTML, written to a directory, emailed or POSTed
+// to a webhook.
+package report
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/logging"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/telemetry"
+)
+
+// logger is the report component's logger
+var logger = logging.For("report")
+
+// changeRetention is how long policy changes are kept for the reports
+// that cover them, a little longer than the weekly period
+const changeRetention = 8 * 24 * time.Hour
+
+// maxPolicyChanges bounds the policy changes kept between reports
+const maxPolicyChanges = 1000
+
+// Report is one generated report, the body of json files and webhooks
+type Report struct {
+	Name        string    `json:"name"`
+	Period      string    `json:"period"`
+	GeneratedAt time.Time `json:"generated_at"`
+
+	telemetry.DecisionSummary
+
+	// PolicyChanges are the rule changes made while the gateway ran
+	PolicyChanges []policy.ChangeEvent `json:"policy_changes"`
+}
+
+// File is a report rendered in one format
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Summarizer summarizes the stored decisions of a window
+type Summarizer func(ctx context.Context, since, until time.Time) (telemetry.DecisionSummary, error)
+
+// Mailer sends a report's files through the named email tool
+type Mailer func(ctx context.Context, tool string, to []string, subject, text string, files []File) error
+
+// Scheduler generates the configured reports. A nil Scheduler has none.
+type Scheduler struct {
+	summarize Summarizer
+	mail      Mailer
+	resolve   func(string) (string, error)
+	client    *http.Client
+	stop      chan struct{}
+	wg        sync.WaitGroup
+
+	mu sync.Mutex
+	// changes are the recent policy changes, oldest first
+	changes []policy.ChangeEvent
+}
+
+// New schedules the configured reports, or returns nil if there are none.
+// resolve reads secret references in webhook URLs and headers.
+func New(cfgs []config.ReportConfig, summarize Summarizer, mail Mailer, resolve func(string) (string, error)) *Scheduler {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	s := &Scheduler{
+		summarize: summarize,
+		mail:      mail,
+		resolve:   resolve,
+		client:    &http.Client{},
+		stop:      make(chan struct{}),
+	}
+	for _, cfg := range cfgs {
+		if len(cfg.Formats) == 0 {
+			cfg.Formats = []string{config.ReportFormatJSON}
+		}
+		s.wg.Add(1)
+		go s.run(cfg)
+	}
+	return s
+}
+
+// PolicyChanged records a policy change for the reports that cover it
+func (s *Scheduler) PolicyChanged(event policy.ChangeEvent) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changes = append(s.changes, event)
+	cutoff := time.Now().Add(-changeRetention)
+	drop := 0
+	for drop < len(s.changes) && (s.changes[drop].Timestamp.Before(cutoff) || len(s.changes)-drop > maxPolicyChanges) {
+		drop++
+	}
+	s.changes = s.changes[drop:]
+}
+
+// Close stops the schedules, waiting for reports being generated
+func (s *Scheduler) Close() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// run generates a report at each of its scheduled times until Close
+func (s *Scheduler) run(cfg config.ReportConfig) {
+	defer s.wg.Done()
+	for {
+		next := nextRun(cfg, time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := s.generate(context.Background(), cfg, next); err != nil {
+			logger.Error("Failed to generate report", "report", cfg.Name, "error", err)
+		}
+	}
+}
+
+// nextRun returns the first scheduled time of a report after now: the
+// next At, on a Monday for weekly reports
+func nextRun(cfg config.ReportConfig, now time.Time) time.Time {
+	at, _ := time.Parse("15:04", cfg.At)
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, time.UTC)
+	for !next.After(now) || (cfg.Period == config.ReportWeekly && next.Weekday() != time.Monday) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// periodStart returns the start of the period a report run at until covers
+func periodStart(cfg config.ReportConfig, until time.Time) time.Time {
+	if cfg.Period == config.ReportWeekly {
+		return until.AddDate(0, 0, -7)
+	}
+	return until.AddDate(0, 0, -1)
+}
+
+// generate builds the report covering the period up to until and delivers
+// it to each of its destinations. Every destination is tried, whatever the
+// others did.
+func (s *Scheduler) generate(ctx context.Context, cfg config.ReportConfig, until time.Time) error {
+	since := periodStart(cfg, until)
+	summary, err := s.summarize(ctx, since, until)
+	if err != nil {
+		return fmt.Errorf("failed to summarize decisions: %w", err)
+	}
+	report := Report{
+		Name:            cfg.Name,
+		Period:          cfg.Period,
+		GeneratedAt:     time.Now().UTC(),
+		DecisionSummary: summary,
+		PolicyChanges:   s.changesBetween(since, until),
+	}
+
+	var files []File
+	for _, format := range cfg.Formats {
+		file, err := render(report, format)
+		if err != nil {
+			return fmt.Errorf("failed to render %s: %w", format, err)
+		}
+		files = append(files, file)
+	}
+
+	var errs []error
+	if cfg.Dir != "" {
+		if err := writeFiles(cfg.Dir, files); err != nil {
+			errs = append(errs, fmt.Errorf("dir: %w", err))
+		}
+	}
+	if e := cfg.Email; e != nil {
+		subject := fmt.Sprintf("[aegis] %s report, %s to %s", cfg.Name, since.Format(time.RFC3339), until.Format(time.RFC3339))
+		text := fmt.Sprintf("%d calls: %d allowed, %d denied, by %d agents. %d policy changes. The report is attached.\n",
+			summary.Calls, summary.Allowed, summary.Denied, len(summary.Agents), len(report.PolicyChanges))
+		if err := s.mail(ctx, e.Tool, e.To, subject, text, files); err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		}
+	}
+	if w := cfg.Webhook; w != nil {
+		if err := s.post(ctx, w, report); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	logger.Info("Report generated", "report", cfg.Name, "since", since, "until", until, "calls", summary.Calls)
+	return nil
+}
+
+// changesBetween returns the policy changes made from since until until
+func (s *Scheduler) changesBetween(since, until time.Time) []policy.ChangeEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changes := make([]policy.ChangeEvent, 0)
+	for _, event := range s.changes {
+		if !event.Timestamp.Before(since) && event.Timestamp.Before(until) {
+			changes = append(changes, event)
+		}
+	}
+	return changes
+}
+
+// writeFiles writes a report's files to dir. Reports name agents and
+// denial reasons, so they are only readable by the gateway's user.
+func writeFiles(dir string, files []File) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.Name), f.Data, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post sends the JSON report to a webhook
+func (s *Scheduler) post(ctx context.Context, w *config.ReportWebhookConfig, report Report) error {
+	file, err := render(report, config.ReportFormatJSON)
+	if err != nil {
+		return err
+	}
+	timeout := w.Timeout
+	if timeout == 0 {
+		timeout = config.DefaultReportWebhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	target, err := s.value(w.URL)
+	if err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(file.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", file.ContentType)
+	for name, ref := range w.Headers {
+		value, err := s.value(ref)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// value resolves a secret reference, or returns a plain value as is
+func (s *Scheduler) value(v string) (string, error) {
+	if _, _, ok := config.SplitSecretRef(v); !ok {
+		return v, nil
+	}
+	return s.resolve(v)
+}
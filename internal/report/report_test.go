@@ -0,0 +1,213 @@
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+	"aegis-gateway/pkg/telemetry"
+)
+
+func TestNextRun(t *testing.T) {
+	// Wednesday
+	now := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		cfg  config.ReportConfig
+		now  time.Time
+		want time.Time
+	}{
+		{"daily later today", config.ReportConfig{Period: config.ReportDaily, At: "18:00"}, now, time.Date(2026, 10, 14, 18, 0, 0, 0, time.UTC)},
+		{"daily tomorrow", config.ReportConfig{Period: config.ReportDaily, At: "09:30"}, now, time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)},
+		{"daily at midnight by default", config.ReportConfig{Period: config.ReportDaily}, now, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{"weekly on Monday", config.ReportConfig{Period: config.ReportWeekly, At: "06:00"}, now, time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC)},
+		{"At is UTC", config.ReportConfig{Period: config.ReportDaily, At: "10:00"}, now.In(time.FixedZone("UTC+2", 2*60*60)), time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := nextRun(tt.cfg, tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// summary is the decisions summarized for every report
+var summary = telemetry.DecisionSummary{
+	Calls: 12, Allowed: 9, Denied: 3,
+	Agents:  []telemetry.AgentUsage{{AgentID: "finance-agent", Calls: 12, Allowed: 9, Denied: 3}},
+	Tools:   []telemetry.ToolUsage{{Tool: "payments", Calls: 12, Allowed: 9, Denied: 3}},
+	Denials: []telemetry.DenialGroup{{AgentID: "finance-agent", Tool: "payments", Code: policy.CodeRateLimited, Count: 3, Reason: "<limit>", Last: "2026-10-14T08:00:00Z"}},
+}
+
+func TestGenerate(t *testing.T) {
+	until := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	var posted []byte
+	var postedHeader http.Header
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted, _ = io.ReadAll(r.Body)
+		postedHeader = r.Header.Clone()
+	}))
+	defer webhook.Close()
+
+	var mailed struct {
+		tool, subject, text string
+		to                  []string
+		files               []File
+	}
+	s := &Scheduler{
+		summarize: func(ctx context.Context, since, until time.Time) (telemetry.DecisionSummary, error) {
+			sum := summary
+			sum.Since, sum.Until = since, until
+			return sum, nil
+		},
+		mail: func(ctx context.Context, tool string, to []string, subject, text string, files []File) error {
+			mailed.tool, mailed.to, mailed.subject, mailed.text, mailed.files = tool, to, subject, text, files
+			return nil
+		},
+		resolve: func(ref string) (string, error) { return "Bearer s3cret", nil },
+		client:  &http.Client{},
+	}
+	// Only the change within the period is reported
+	s.PolicyChanged(policy.ChangeEvent{Source: "old.yaml", Timestamp: until.AddDate(0, 0, -2)})
+	s.PolicyChanged(policy.ChangeEvent{Source: "policy.yaml", Timestamp: until.Add(-time.Hour), Changes: []policy.RuleChange{{Type: "added", Group: "finance", Tool: "payments"}}})
+	s.PolicyChanged(policy.ChangeEvent{Source: "next.yaml", Timestamp: until})
+
+	dir := filepath.Join(t.TempDir(), "reports")
+	cfg := config.ReportConfig{
+		Name:    "compliance",
+		Period:  config.ReportDaily,
+		Formats: []string{config.ReportFormatJSON, config.ReportFormatCSV, config.ReportFormatHTML},
+		Dir:     dir,
+		Email:   &config.ReportEmailConfig{Tool: "mail", To: []string{"audit@example.com"}},
+		Webhook: &config.ReportWebhookConfig{URL: webhook.URL, Headers: map[string]string{"Authorization": "env:REPORT_TOKEN"}},
+	}
+	if err := s.generate(context.Background(), cfg, until); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"compliance-2026-10-14.json", "compliance-2026-10-14.csv", "compliance-2026-10-14.html"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("%s: got mode %v, want 0600", name, info.Mode().Perm())
+		}
+	}
+
+	var report Report
+	if err := json.Unmarshal(posted, &report); err != nil {
+		t.Fatalf("webhook: %v: %s", err, posted)
+	}
+	if report.Name != "compliance" || report.Calls != 12 || !report.Since.Equal(until.AddDate(0, 0, -1)) || len(report.PolicyChanges) != 1 || report.PolicyChanges[0].Source != "policy.yaml" {
+		t.Errorf("webhook: got %+v", report)
+	}
+	if postedHeader.Get("Authorization") != "Bearer s3cret" || postedHeader.Get("Content-Type") != "application/json" {
+		t.Errorf("webhook: got headers %v", postedHeader)
+	}
+
+	if mailed.tool != "mail" || len(mailed.files) != 3 || !strings.HasPrefix(mailed.subject, "[aegis] compliance report, 2026-10-13T00:00:00Z to 2026-10-14T00:00:00Z") {
+		t.Errorf("email: got %+v", mailed)
+	}
+	if want := "12 calls: 9 allowed, 3 denied, by 1 agents. 1 policy changes. The report is attached.\n"; mailed.text != want {
+		t.Errorf("email: got %q, want %q", mailed.text, want)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(string(mailed.files[1].Data))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sections []string
+	for _, row := range rows[1:] {
+		sections = append(sections, row[0]+":"+row[1])
+	}
+	if got := strings.Join(sections, " "); got != "total: agent:finance-agent tool: denial:finance-agent policy_change:group:finance" {
+		t.Errorf("csv: got sections %s", got)
+	}
+	// Values are escaped in HTML reports
+	if html := string(mailed.files[2].Data); !strings.Contains(html, "&lt;limit&gt;") || !strings.Contains(html, "group finance") {
+		t.Errorf("html: got %s", html)
+	}
+}
+
+// Every destination is tried, and the report fails if any of them did
+func TestGenerateErrors(t *testing.T) {
+	until := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer rejecting.Close()
+
+	mailed := false
+	s := &Scheduler{
+		summarize: func(ctx context.Context, since, until time.Time) (telemetry.DecisionSummary, error) {
+			return summary, nil
+		},
+		mail: func(ctx context.Context, tool string, to []string, subject, text string, files []File) error {
+			mailed = true
+			return errors.New("smtp unavailable")
+		},
+		client: &http.Client{},
+	}
+	blocked := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocked, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.ReportConfig{
+		Name:    "weekly",
+		Period:  config.ReportWeekly,
+		Formats: []string{config.ReportFormatJSON},
+		Dir:     filepath.Join(blocked, "reports"),
+		Email:   &config.ReportEmailConfig{Tool: "mail", To: []string{"audit@example.com"}},
+		Webhook: &config.ReportWebhookConfig{URL: rejecting.URL},
+	}
+	err := s.generate(context.Background(), cfg, until)
+	if err == nil || !mailed {
+		t.Fatalf("got %v, mailed %v", err, mailed)
+	}
+	for _, want := range []string{"dir: ", "email: smtp unavailable", "webhook: webhook returned 403"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got %q, want it to contain %q", err, want)
+		}
+	}
+
+	s.summarize = func(ctx context.Context, since, until time.Time) (telemetry.DecisionSummary, error) {
+		return telemetry.DecisionSummary{}, errors.New("store closed")
+	}
+	if err := s.generate(context.Background(), cfg, until); err == nil || !strings.Contains(err.Error(), "store closed") {
+		t.Errorf("got %v", err)
+	}
+	if _, err := render(Report{}, "pdf"); err == nil {
+		t.Error("unknown format rendered")
+	}
+}
+
+func TestPolicyChangeRetention(t *testing.T) {
+	s := &Scheduler{}
+	now := time.Now()
+	s.PolicyChanged(policy.ChangeEvent{Source: "expired.yaml", Timestamp: now.Add(-changeRetention - time.Hour)})
+	for i := 0; i < maxPolicyChanges+5; i++ {
+		s.PolicyChanged(policy.ChangeEvent{Source: "policy.yaml", Timestamp: now})
+	}
+	if len(s.changes) != maxPolicyChanges || s.changes[0].Source != "policy.yaml" {
+		t.Errorf("got %d changes, the first from %s", len(s.changes), s.changes[0].Source)
+	}
+
+	var nilScheduler *Scheduler
+	nilScheduler.PolicyChanged(policy.ChangeEvent{})
+	nilScheduler.Close()
+	if New(nil, nil, nil, nil) != nil {
+		t.Error("New without reports returned a scheduler")
+	}
+}
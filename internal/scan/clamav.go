@@ -0,0 +1,110 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// clamavChunkSize is the size of the chunks files are streamed to clamd in
+const clamavChunkSize = 64 << 10
+
+// ClamAV scans files with clamd's INSTREAM command
+type ClamAV struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamAV returns a scanner for the clamd listening on address,
+// tcp://host:port or unix:///path
+func NewClamAV(address string, timeout time.Duration) (*ClamAV, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clamd address: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp":
+		return &ClamAV{network: "tcp", address: u.Host, timeout: timeout}, nil
+	case "unix":
+		return &ClamAV{network: "unix", address: u.Path, timeout: timeout}, nil
+	}
+	return nil, fmt.Errorf("clamd address must be tcp:// or unix://, got %s", address)
+}
+
+// Scan implements Scanner
+func (c *ClamAV) Scan(ctx context.Context, filename string, content io.Reader) (Verdict, error) {
+	ctx, cancel := deadline(ctx, c.timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("clamd unreachable: %w", err)
+	}
+	defer conn.Close()
+	if t, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(t)
+	}
+
+	sendErr := c.stream(conn, content)
+	// clamd answers and closes the connection when it rejects the stream,
+	// e.g. over StreamMaxLength, so its reply explains a failed send
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		if sendErr != nil {
+			return Verdict{}, fmt.Errorf("failed to send %s to clamd: %w", filename, sendErr)
+		}
+		return Verdict{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamAVReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// stream sends content as an INSTREAM command: length-prefixed chunks
+// ending with an empty one
+func (c *ClamAV) stream(conn net.Conn, content io.Reader) error {
+	w := bufio.NewWriterSize(conn, clamavChunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, clamavChunkSize)
+	for {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 {
+			binary.Write(w, binary.BigEndian, uint32(n))
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	binary.Write(w, binary.BigEndian, uint32(0))
+	return w.Flush()
+}
+
+// parseClamAVReply reads "stream: OK", "stream: <threat> FOUND" or
+// "<message> ERROR"
+func parseClamAVReply(reply string) (Verdict, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return Verdict{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return Verdict{Infected: true, Threat: strings.TrimSuffix(result, " FOUND")}, nil
+	case strings.HasSuffix(result, " ERROR"):
+		return Verdict{}, fmt.Errorf("clamd: %s", strings.TrimSuffix(result, " ERROR"))
+	}
+	return Verdict{}, fmt.Errorf("unexpected clamd reply %q", reply)
+}
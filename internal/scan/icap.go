@@ -0,0 +1,145 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icapDefaultPort is used when the service URL has no port
+const icapDefaultPort = "1344"
+
+// icapResponseHeader is the encapsulated HTTP response files are sent in
+const icapResponseHeader = "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+
+// ICAP scans files with an ICAP (RFC 3507) antivirus service, sending each
+// one as the body of a RESPMOD request
+type ICAP struct {
+	service *url.URL
+	address string
+	timeout time.Duration
+}
+
+// NewICAP returns a scanner for the service at rawURL,
+// icap://host[:port]/service
+func NewICAP(rawURL string, timeout time.Duration) (*ICAP, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "icap" || u.Host == "" {
+		return nil, fmt.Errorf("ICAP service must be an icap:// URL, got %s", rawURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = icapDefaultPort
+	}
+	return &ICAP{service: u, address: net.JoinHostPort(u.Hostname(), port), timeout: timeout}, nil
+}
+
+// Scan implements Scanner. A 204 reply means the file is clean; a 200 reply
+// naming an infection in X-Infection-Found, X-Virus-ID or
+// X-Violations-Found means it is infected.
+func (c *ICAP) Scan(ctx context.Context, filename string, content io.Reader) (Verdict, error) {
+	ctx, cancel := deadline(ctx, c.timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("ICAP service unreachable: %w", err)
+	}
+	defer conn.Close()
+	if t, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(t)
+	}
+
+	if err := c.send(conn, content); err != nil {
+		return Verdict{}, fmt.Errorf("failed to send %s to ICAP service: %w", filename, err)
+	}
+
+	r := textproto.NewReader(bufio.NewReader(conn))
+	status, err := r.ReadLine()
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to read ICAP reply: %w", err)
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to read ICAP reply: %w", err)
+	}
+
+	proto, rest, _ := strings.Cut(status, " ")
+	code, _, _ := strings.Cut(rest, " ")
+	if proto != "ICAP/1.0" {
+		return Verdict{}, fmt.Errorf("unexpected ICAP reply %q", status)
+	}
+	switch code {
+	case "204":
+		return Verdict{}, nil
+	case "200":
+		if threat := icapThreat(header); threat != "" {
+			return Verdict{Infected: true, Threat: threat}, nil
+		}
+		// The service returned the file unmodified
+		return Verdict{}, nil
+	}
+	return Verdict{}, fmt.Errorf("ICAP service returned %s", rest)
+}
+
+// send writes the RESPMOD request, with content as the chunked body
+func (c *ICAP) send(conn net.Conn, content io.Reader) error {
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", c.service)
+	fmt.Fprintf(w, "Host: %s\r\n", c.service.Host)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Connection: close\r\n")
+	fmt.Fprintf(w, "Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(icapResponseHeader))
+	w.WriteString(icapResponseHeader)
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%s\r\n", strconv.FormatInt(int64(n), 16))
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	return w.Flush()
+}
+
+// icapThreat returns the threat an ICAP reply reports, or ""
+func icapThreat(header textproto.MIMEHeader) string {
+	// X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;
+	if found := header.Get("X-Infection-Found"); found != "" {
+		for _, field := range strings.Split(found, ";") {
+			if name, ok := strings.CutPrefix(strings.TrimSpace(field), "Threat="); ok && name != "" {
+				return name
+			}
+		}
+		return found
+	}
+	if id := header.Get("X-Virus-ID"); id != "" {
+		return id
+	}
+	// X-Violations-Found: a count followed by filename, threat, ID and
+	// resolution lines per violation
+	if found := header.Get("X-Violations-Found"); found != "" {
+		if fields := strings.Fields(found); len(fields) >= 3 {
+			return fields[2]
+		}
+		return found
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+// Package scan checks uploaded files for malware before they reach a tool.
+// Scanner is the interface the gateway calls; ClamAV and ICAP are the
+// reference implementations, selected by uploads.scan in the config.
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// Scanner scans the content of one file
+type Scanner interface {
+	// Scan reads content to the end and returns the verdict. An error
+	// means the file could not be scanned, not that it is infected.
+	Scan(ctx context.Context, filename string, content io.Reader) (Verdict, error)
+}
+
+// Verdict is the result of a scan
+type Verdict struct {
+	Infected bool
+
+	// Threat names what was found, e.g. "Eicar-Test-Signature"
+	Threat string
+}
+
+// New returns the scanner configured by cfg, or nil if scanning is disabled
+func New(cfg config.UploadScanConfig) (Scanner, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = config.DefaultScanTimeout
+	}
+	// A failed constructor's nil pointer must not become a non-nil Scanner
+	switch cfg.Type {
+	case config.ScannerClamAV:
+		s, err := NewClamAV(cfg.Address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case config.ScannerICAP:
+		s, err := NewICAP(cfg.Address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown scanner type %s", cfg.Type)
+}
+
+// deadline returns ctx bounded by timeout
+func deadline(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,213 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+)
+
+// eicar stands in for a signature the fake scanners detect
+const eicar = "EICAR-STANDARD-ANTIVIRUS-TEST-FILE"
+
+// serve accepts connections on a local port and hands each to handle
+func serve(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// clamd answers INSTREAM commands, refusing streams over limit bytes
+func clamd(limit int) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		if command, err := r.ReadString(0); err != nil || command != "zINSTREAM\x00" {
+			io.WriteString(conn, "UNKNOWN COMMAND ERROR\x00")
+			return
+		}
+		var content bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if content.Len()+int(size) > limit {
+				io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+				return
+			}
+			if _, err := io.CopyN(&content, r, int64(size)); err != nil {
+				return
+			}
+		}
+		if strings.Contains(content.String(), eicar) {
+			io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+			return
+		}
+		io.WriteString(conn, "stream: OK\x00")
+	}
+}
+
+func TestClamAV(t *testing.T) {
+	address := serve(t, clamd(1<<20))
+	s, err := New(config.UploadScanConfig{Type: config.ScannerClamAV, Address: "tcp://" + address})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    Verdict
+		wantErr bool
+	}{
+		{"clean", "quarterly report", Verdict{}, false},
+		{"infected", "X5O!P%@AP[4\\PZX54(P^)7CC)7}$" + eicar + "!$H+H*", Verdict{Infected: true, Threat: "Eicar-Test-Signature"}, false},
+		{"infected across chunks", strings.Repeat("a", clamavChunkSize-10) + eicar, Verdict{Infected: true, Threat: "Eicar-Test-Signature"}, false},
+		{"empty", "", Verdict{}, false},
+		{"over clamd's limit", strings.Repeat("a", 2<<20), Verdict{}, true},
+	}
+	for _, tt := range tests {
+		got, err := s.Scan(context.Background(), "upload.bin", strings.NewReader(tt.content))
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %+v, %v", tt.name, got, err)
+		}
+	}
+}
+
+// icapServer answers RESPMOD requests, reporting the infection in header
+func icapServer(header string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		r := textproto.NewReader(bufio.NewReader(conn))
+		if line, err := r.ReadLine(); err != nil || !strings.HasPrefix(line, "RESPMOD icap://") {
+			io.WriteString(conn, "ICAP/1.0 400 Bad Request\r\n\r\n")
+			return
+		}
+		if _, err := r.ReadMIMEHeader(); err != nil {
+			return
+		}
+		// The encapsulated response header, then the file as a chunked body
+		if _, err := http.ReadResponse(r.R, nil); err != nil {
+			return
+		}
+		body, err := io.ReadAll(httputil.NewChunkedReader(r.R))
+		if err != nil {
+			return
+		}
+		if strings.Contains(string(body), eicar) {
+			io.WriteString(conn, "ICAP/1.0 200 OK\r\n"+header+"\r\n\r\n")
+			return
+		}
+		io.WriteString(conn, "ICAP/1.0 204 No Content\r\n\r\n")
+	}
+}
+
+func TestICAP(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		content string
+		want    Verdict
+	}{
+		{"clean", "X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;", "quarterly report", Verdict{}},
+		{"X-Infection-Found", "X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;", eicar, Verdict{Infected: true, Threat: "Eicar-Test-Signature"}},
+		{"X-Virus-ID", "X-Virus-ID: EICAR_Test_File", eicar, Verdict{Infected: true, Threat: "EICAR_Test_File"}},
+		{"X-Violations-Found", "X-Violations-Found: 1 upload.bin EICAR-Test 111", eicar, Verdict{Infected: true, Threat: "EICAR-Test"}},
+		{"large file", "X-Virus-ID: EICAR_Test_File", strings.Repeat("a", 200<<10) + eicar, Verdict{Infected: true, Threat: "EICAR_Test_File"}},
+	}
+	for _, tt := range tests {
+		address := serve(t, icapServer(tt.header))
+		s, err := New(config.UploadScanConfig{Type: config.ScannerICAP, Address: "icap://" + address + "/avscan"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.Scan(context.Background(), "upload.bin", strings.NewReader(tt.content))
+		if got != tt.want || err != nil {
+			t.Errorf("%s: got %+v, %v", tt.name, got, err)
+		}
+	}
+}
+
+// A scanner that can't be reached or doesn't answer in time is an error,
+// never a clean verdict
+func TestScanFailures(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+	silent := serve(t, func(conn net.Conn) { io.Copy(io.Discard, conn) })
+
+	tests := []struct {
+		name string
+		cfg  config.UploadScanConfig
+	}{
+		{"clamd unreachable", config.UploadScanConfig{Type: config.ScannerClamAV, Address: "tcp://" + closed}},
+		{"clamd timeout", config.UploadScanConfig{Type: config.ScannerClamAV, Address: "tcp://" + silent, Timeout: 50 * time.Millisecond}},
+		{"ICAP unreachable", config.UploadScanConfig{Type: config.ScannerICAP, Address: "icap://" + closed + "/avscan"}},
+		{"ICAP timeout", config.UploadScanConfig{Type: config.ScannerICAP, Address: "icap://" + silent + "/avscan", Timeout: 50 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		s, err := New(tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.Scan(context.Background(), "upload.bin", strings.NewReader("data")); err == nil {
+			t.Errorf("%s: got %+v and no error", tt.name, got)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.UploadScanConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{"disabled", config.UploadScanConfig{}, true, false},
+		{"clamav over unix socket", config.UploadScanConfig{Type: config.ScannerClamAV, Address: "unix:///run/clamd.sock"}, false, false},
+		{"clamav without scheme", config.UploadScanConfig{Type: config.ScannerClamAV, Address: "clamd:3310"}, true, true},
+		{"icap default port", config.UploadScanConfig{Type: config.ScannerICAP, Address: "icap://av.internal/avscan"}, false, false},
+		{"icap with http URL", config.UploadScanConfig{Type: config.ScannerICAP, Address: "http://av.internal/avscan"}, true, true},
+		{"unknown type", config.UploadScanConfig{Type: "sophos", Address: "tcp://av:1"}, true, true},
+	}
+	for _, tt := range tests {
+		s, err := New(tt.cfg)
+		if (s == nil) != tt.wantNil || (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %v, %v", tt.name, s, err)
+		}
+	}
+	if s, _ := NewICAP("icap://av.internal/avscan", time.Second); s.address != "av.internal:"+icapDefaultPort {
+		t.Errorf("got ICAP address %s", s.address)
+	}
+}
@@ -0,0 +1,405 @@
+// Package schema holds the request and response schemas of a tool's
+// actions, in named versions, and validates calls and responses against
+// them. Schemas are JSON Schema documents, given per action or taken from
+// an OpenAPI document whose paths are the tool's actions (/charge for the
+// charge action).
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Source locates the schemas of one version. Schemas in Actions take
+// precedence over those found in the OpenAPI document.
+type Source struct {
+	Version string
+	OpenAPI string
+	Actions map[string]ActionSource
+}
+
+// ActionSource names the JSON Schema files of an action's params and of
+// its successful responses; either may be empty
+type ActionSource struct {
+	Request  string
+	Response string
+}
+
+// Set is the schema versions of a tool
+type Set struct {
+	current  string
+	order    []string
+	versions map[string]*Version
+}
+
+// Version is one version of a tool's schemas
+type Version struct {
+	Name    string
+	actions map[string]*Action
+}
+
+// Action holds the schemas of an action. Either may be nil.
+type Action struct {
+	Request  *Schema
+	Response *Schema
+}
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	compiled *jsonschema.Schema
+
+	// doc is the schema as written, with local references inlined so it
+	// stands on its own
+	doc interface{}
+}
+
+// Load compiles the versions in sources. current is the version calls are
+// validated against by default; when empty it is the last one.
+func Load(sources []Source, current string) (*Set, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no schema versions")
+	}
+	s := &Set{versions: make(map[string]*Version)}
+	for _, src := range sources {
+		if src.Version == "" {
+			return nil, fmt.Errorf("schema versions require a version")
+		}
+		if _, dup := s.versions[src.Version]; dup {
+			return nil, fmt.Errorf("schema version %s is defined twice", src.Version)
+		}
+		v, err := loadVersion(src)
+		if err != nil {
+			return nil, fmt.Errorf("schema version %s: %w", src.Version, err)
+		}
+		s.versions[src.Version] = v
+		s.order = append(s.order, src.Version)
+	}
+	if current == "" {
+		current = s.order[len(s.order)-1]
+	}
+	if _, ok := s.versions[current]; !ok {
+		return nil, fmt.Errorf("current schema version %s is not defined", current)
+	}
+	s.current = current
+	return s, nil
+}
+
+// Current returns the name of the default version
+func (s *Set) Current() string {
+	return s.current
+}
+
+// Versions returns the version names in the order they were defined
+func (s *Set) Versions() []string {
+	return s.order
+}
+
+// Version returns the named version, or the current one for ""
+func (s *Set) Version(name string) (*Version, bool) {
+	if name == "" {
+		name = s.current
+	}
+	v, ok := s.versions[name]
+	return v, ok
+}
+
+// Action returns the schemas of an action, or nil if it has none
+func (v *Version) Action(name string) *Action {
+	return v.actions[name]
+}
+
+// Actions returns the names of the actions with schemas, sorted
+func (v *Version) Actions() []string {
+	names := make([]string, 0, len(v.actions))
+	for name := range v.actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks v, a decoded JSON value. The error names the first
+// value that doesn't match, e.g. "/amount: must be >= 0".
+func (s *Schema) Validate(v interface{}) error {
+	err := s.compiled.Validate(v)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+	for len(ve.Causes) > 0 {
+		ve = ve.Causes[0]
+	}
+	location := ve.InstanceLocation
+	if location == "" {
+		location = "/"
+	}
+	return fmt.Errorf("%s: %s", location, ve.Message)
+}
+
+// Document returns the schema for agents to read
+func (s *Schema) Document() interface{} {
+	return s.doc
+}
+
+// loadVersion compiles the schemas of one version
+func loadVersion(src Source) (*Version, error) {
+	v := &Version{Name: src.Version, actions: make(map[string]*Action)}
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+
+	if src.OpenAPI != "" {
+		if err := loadOpenAPI(v, compiler, src.OpenAPI); err != nil {
+			return nil, err
+		}
+	}
+	for name, action := range src.Actions {
+		a := &Action{}
+		var err error
+		if a.Request, err = loadFile(compiler, action.Request); err != nil {
+			return nil, fmt.Errorf("%s request: %w", name, err)
+		}
+		if a.Response, err = loadFile(compiler, action.Response); err != nil {
+			return nil, fmt.Errorf("%s response: %w", name, err)
+		}
+		v.actions[name] = a
+	}
+	return v, nil
+}
+
+// loadFile compiles a JSON Schema file, or returns nil for ""
+func loadFile(compiler *jsonschema.Compiler, path string) (*Schema, error) {
+	if path == "" {
+		return nil, nil
+	}
+	url, doc, err := addDocument(compiler, path)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := compiler.Compile(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{compiled: compiled, doc: doc}, nil
+}
+
+// openAPIMethods are the operations an action's schemas are taken from,
+// in order of preference
+var openAPIMethods = []string{"post", "put", "patch", "get", "delete"}
+
+// openAPIResponses are the responses an action's response schema is
+// taken from, in order of preference
+var openAPIResponses = []string{"200", "201", "202", "2XX", "default"}
+
+// loadOpenAPI adds the request body and response schemas of each
+// single-segment path in the OpenAPI document at path
+func loadOpenAPI(v *Version, compiler *jsonschema.Compiler, path string) error {
+	url, doc, err := addDocument(compiler, path)
+	if err != nil {
+		return err
+	}
+	root, _ := doc.(map[string]interface{})
+	paths, _ := root["paths"].(map[string]interface{})
+	if paths == nil {
+		return fmt.Errorf("%s has no paths", path)
+	}
+
+	for p, item := range paths {
+		action := strings.TrimPrefix(p, "/")
+		if action == "" || strings.ContainsAny(action, "/{") {
+			continue
+		}
+		ops, _ := item.(map[string]interface{})
+		for _, method := range openAPIMethods {
+			op, ok := ops[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			base := "/paths/" + escapePointer(p) + "/" + method
+			a := &Action{}
+			if media, ok := jsonContent(lookup(op, "requestBody", "content")); ok {
+				if a.Request, err = compileAt(compiler, url, root, base+"/requestBody/content/"+escapePointer(media)+"/schema"); err != nil {
+					return fmt.Errorf("%s %s request: %w", strings.ToUpper(method), p, err)
+				}
+			}
+			for _, status := range openAPIResponses {
+				media, ok := jsonContent(lookup(op, "responses", status, "content"))
+				if !ok {
+					continue
+				}
+				if a.Response, err = compileAt(compiler, url, root, base+"/responses/"+status+"/content/"+escapePointer(media)+"/schema"); err != nil {
+					return fmt.Errorf("%s %s response: %w", strings.ToUpper(method), p, err)
+				}
+				break
+			}
+			if a.Request != nil || a.Response != nil {
+				v.actions[action] = a
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// compileAt compiles the schema at pointer in the document at url
+func compileAt(compiler *jsonschema.Compiler, url string, root map[string]interface{}, pointer string) (*Schema, error) {
+	compiled, err := compiler.Compile(url + "#" + pointer)
+	if err != nil {
+		return nil, err
+	}
+	node, _ := resolve(root, pointer)
+	top, _ := lookup(node, "$ref").(string)
+	return &Schema{compiled: compiled, doc: inline(root, node, top, nil)}, nil
+}
+
+// addDocument reads a JSON or YAML document and adds it to the compiler,
+// returning its URL and decoded content
+func addDocument(compiler *jsonschema.Compiler, path string) (string, interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return "", nil, fmt.Errorf("%s: %w", path, err)
+	}
+	doc = normalize(doc)
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", path, err)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+	url := "file://" + filepath.ToSlash(abs)
+	if err := compiler.AddResource(url, bytes.NewReader(encoded)); err != nil {
+		return "", nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return url, doc, nil
+}
+
+// normalize turns the maps YAML decodes with non-string keys, such as
+// unquoted response codes, into JSON objects
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalize(value)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalize(value)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+		return v
+	}
+	return v
+}
+
+// jsonContent returns the JSON media type of an OpenAPI content map
+func jsonContent(content interface{}) (string, bool) {
+	m, _ := content.(map[string]interface{})
+	if _, ok := m["application/json"]; ok {
+		return "application/json", true
+	}
+	for media := range m {
+		if strings.HasSuffix(media, "+json") {
+			return media, true
+		}
+	}
+	return "", false
+}
+
+// lookup follows keys through nested objects
+func lookup(v interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// resolve returns the value at a JSON pointer
+func resolve(root interface{}, pointer string) (interface{}, bool) {
+	v := root
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		if token == "" {
+			continue
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[unescapePointer(token)]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// inline replaces local "$ref"s in node with what they point to. A
+// reference to a schema that is already being inlined is kept, so
+// recursive schemas stay finite. When node is itself a reference (top),
+// references back to it become "#".
+func inline(root, node interface{}, top string, stack []string) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := n["$ref"].(string); ok && strings.HasPrefix(ref, "#/") {
+			if ref == top && len(stack) > 0 {
+				return map[string]interface{}{"$ref": "#"}
+			}
+			for _, seen := range stack {
+				if seen == ref {
+					return n
+				}
+			}
+			if target, ok := resolve(root, ref[1:]); ok {
+				return inline(root, target, top, append(stack, ref))
+			}
+			return n
+		}
+		out := make(map[string]interface{}, len(n))
+		for key, value := range n {
+			out[key] = inline(root, value, top, stack)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(n))
+		for i, item := range n {
+			out[i] = inline(root, item, top, stack)
+		}
+		return out
+	}
+	return node
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+func escapePointer(token string) string {
+	return pointerEscaper.Replace(token)
+}
+
+func unescapePointer(token string) string {
+	return pointerUnescaper.Replace(token)
+}
@@ -0,0 +1,194 @@
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to name in dir and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// decode unmarshals a JSON value for Validate
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+const openAPI = `
+openapi: 3.1.0
+paths:
+  /charge:
+    get:
+      responses:
+        200:
+          content:
+            application/json:
+              schema: {type: array}
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Charge'
+      responses:
+        201:
+          content:
+            application/problem+json:
+              schema:
+                type: object
+                required: [id]
+                properties:
+                  id: {type: string}
+  /charges/{id}:
+    get:
+      responses:
+        200:
+          content:
+            application/json:
+              schema: {type: object}
+  /refund:
+    post:
+      requestBody:
+        content:
+          text/plain:
+            schema: {type: string}
+components:
+  schemas:
+    Charge:
+      type: object
+      required: [amount]
+      properties:
+        amount: {type: number, minimum: 0}
+        currency: {$ref: '#/components/schemas/Currency'}
+    Currency:
+      type: string
+      enum: [USD, EUR]
+`
+
+func TestOpenAPI(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "payments.yaml", openAPI)
+	s, err := Load([]Source{{Version: "v1", OpenAPI: path}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := s.Version("")
+	// Templated paths and actions without JSON content are left out
+	if got := v.Actions(); !reflect.DeepEqual(got, []string{"charge"}) {
+		t.Fatalf("got actions %v, want [charge]", got)
+	}
+	charge := v.Action("charge")
+	if charge.Request == nil || charge.Response == nil {
+		t.Fatalf("got %+v, want the POST request and 201 response", charge)
+	}
+
+	tests := []struct {
+		name    string
+		schema  *Schema
+		value   string
+		wantErr string
+	}{
+		{"valid request", charge.Request, `{"amount":12.5,"currency":"USD"}`, ""},
+		{"negative amount", charge.Request, `{"amount":-1}`, "/amount: "},
+		{"referenced enum", charge.Request, `{"amount":1,"currency":"GBP"}`, "/currency: "},
+		{"missing amount", charge.Request, `{}`, "/: "},
+		{"valid response", charge.Response, `{"id":"ch_1"}`, ""},
+		{"response id type", charge.Response, `{"id":1}`, "/id: "},
+	}
+	for _, tt := range tests {
+		err := tt.schema.Validate(decode(t, tt.value))
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: got %v, want %q...", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// The document agents read has the component references inlined
+	doc, _ := json.Marshal(charge.Request.Document())
+	if strings.Contains(string(doc), "$ref") || !strings.Contains(string(doc), `"enum":["USD","EUR"]`) {
+		t.Errorf("got document %s", doc)
+	}
+}
+
+// Per-action files take precedence over the OpenAPI document, and each
+// version keeps its own schemas
+func TestVersions(t *testing.T) {
+	dir := t.TempDir()
+	spec := writeFile(t, dir, "payments.yaml", openAPI)
+	v1 := writeFile(t, dir, "charge-v1.json", `{"type":"object","required":["amount"]}`)
+	v2 := writeFile(t, dir, "charge-v2.json", `{"type":"object","required":["amount","currency"]}`)
+	s, err := Load([]Source{
+		{Version: "v1", Actions: map[string]ActionSource{"charge": {Request: v1}}},
+		{Version: "v2", OpenAPI: spec, Actions: map[string]ActionSource{"charge": {Request: v2}}},
+	}, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Current() != "v1" || !reflect.DeepEqual(s.Versions(), []string{"v1", "v2"}) {
+		t.Errorf("got current %s of %v", s.Current(), s.Versions())
+	}
+
+	call := decode(t, `{"amount":5}`)
+	current, _ := s.Version("")
+	if err := current.Action("charge").Request.Validate(call); err != nil {
+		t.Errorf("v1: %v", err)
+	}
+	next, ok := s.Version("v2")
+	if !ok {
+		t.Fatal("v2 not found")
+	}
+	if err := next.Action("charge").Request.Validate(call); err == nil {
+		t.Error("v2: call without currency accepted")
+	}
+	if next.Action("charge").Response != nil {
+		t.Error("v2: per-action entry kept the OpenAPI response schema")
+	}
+	if _, ok := s.Version("v3"); ok {
+		t.Error("unknown version found")
+	}
+	if current.Action("refund") != nil {
+		t.Error("v1: got schemas for an action without any")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	valid := writeFile(t, dir, "valid.json", `{"type":"object"}`)
+	invalid := writeFile(t, dir, "invalid.json", `{"type":"no-such-type"}`)
+	noPaths := writeFile(t, dir, "empty.yaml", "openapi: 3.1.0\n")
+
+	tests := []struct {
+		name    string
+		sources []Source
+		current string
+	}{
+		{"no versions", nil, ""},
+		{"empty version", []Source{{Actions: map[string]ActionSource{"charge": {Request: valid}}}}, ""},
+		{"duplicate version", []Source{{Version: "v1"}, {Version: "v1"}}, ""},
+		{"unknown current", []Source{{Version: "v1"}}, "v2"},
+		{"missing file", []Source{{Version: "v1", Actions: map[string]ActionSource{"charge": {Response: filepath.Join(dir, "missing.json")}}}}, ""},
+		{"invalid schema", []Source{{Version: "v1", Actions: map[string]ActionSource{"charge": {Request: invalid}}}}, ""},
+		{"OpenAPI without paths", []Source{{Version: "v1", OpenAPI: noPaths}}, ""},
+	}
+	for _, tt := range tests {
+		if _, err := Load(tt.sources, tt.current); err == nil {
+			t.Errorf("%s: got no error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+
+	"aegis-gateway/internal/config"
+)
+
+// awsClient reads secrets from AWS Secrets Manager
+type awsClient struct {
+	client *secretsmanager.Client
+}
+
+// newAWSClient loads the default AWS credential chain
+func newAWSClient(ctx context.Context, cfg config.AWSSecretsConfig) (*awsClient, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	return &awsClient{client: secretsmanager.NewFromConfig(awsCfg)}, nil
+}
+
+// read returns the current version of secret id, or field key of it when
+// the secret is a JSON object
+func (a *awsClient) read(ctx context.Context, id, key string) (string, error) {
+	out, err := a.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+	if err != nil {
+		return "", fmt.Errorf("AWS Secrets Manager read of %s failed: %w", id, err)
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("AWS secret %s is binary; only string secrets are supported", id)
+	}
+	if key == "" {
+		return *out.SecretString, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(*out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("AWS secret %s is not a JSON object: %w", id, err)
+	}
+	value, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("AWS secret %s has no string field %s", id, key)
+	}
+	return value, nil
+}
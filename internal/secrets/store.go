@@ -0,0 +1,181 @@
+// Package secrets resolves the secret references tools are called with
+// (env:, file:, vault:, aws:) and keeps the values fresh so rotated secrets
+// are picked up without a restart.
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/logging"
+)
+
+// logger is the secrets component's logger
+var logger = logging.For("secrets")
+
+// fetchTimeout bounds a single read from a secrets backend
+const fetchTimeout = 10 * time.Second
+
+// Store resolves secret references. Values read from a backend are cached
+// for the refresh interval; if a refresh fails, the last value keeps being
+// used and the failure is logged.
+type Store struct {
+	cfg     config.SecretsConfig
+	refresh time.Duration
+	vault   *vaultClient
+
+	awsOnce sync.Once
+	aws     *awsClient
+	awsErr  error
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// entry is a cached secret. Its own lock makes concurrent callers wait for
+// one fetch instead of each hitting the backend.
+type entry struct {
+	mu      sync.Mutex
+	value   string
+	fetched time.Time
+}
+
+// NewStore returns a store for the configured backends
+func NewStore(cfg config.SecretsConfig) *Store {
+	s := &Store{cfg: cfg, refresh: cfg.RefreshInterval, entries: make(map[string]*entry)}
+	if s.refresh == 0 {
+		s.refresh = config.DefaultSecretsRefreshInterval
+	}
+	if cfg.Vault.Address != "" {
+		s.vault = newVaultClient(cfg.Vault)
+	}
+	return s
+}
+
+// Resolve returns the secret ref points to
+func (s *Store) Resolve(ref string) (string, error) {
+	scheme, rest, ok := config.SplitSecretRef(ref)
+	if !ok {
+		return "", fmt.Errorf("unsupported secret reference %q", ref)
+	}
+
+	// Environment variables and files are cheap to read and may change at
+	// any time, so they are never cached
+	switch scheme {
+	case config.SecretSchemeEnv:
+		value, ok := os.LookupEnv(rest)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", rest)
+		}
+		return value, nil
+	case config.SecretSchemeFile:
+		data, err := os.ReadFile(rest)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	s.mu.Lock()
+	e, ok := s.entries[ref]
+	if !ok {
+		e = &entry{}
+		s.entries[ref] = e
+	}
+	s.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.fetched.IsZero() && time.Since(e.fetched) < s.refresh {
+		return e.value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	value, err := s.fetch(ctx, scheme, rest)
+	if err != nil {
+		if e.fetched.IsZero() {
+			return "", err
+		}
+		// Keep serving the last value and try again after the next interval
+		logger.Warn("Failed to refresh secret, using the previous value", "secret", ref, "error", err)
+		e.fetched = time.Now()
+		return e.value, nil
+	}
+	e.value, e.fetched = value, time.Now()
+	return value, nil
+}
+
+// fetch reads a secret from its backend
+func (s *Store) fetch(ctx context.Context, scheme, rest string) (string, error) {
+	switch scheme {
+	case config.SecretSchemeVault:
+		if s.vault == nil {
+			return "", fmt.Errorf("secrets.vault is not configured")
+		}
+		path, key, _ := strings.Cut(rest, "#")
+		return s.vault.read(ctx, path, key)
+	case config.SecretSchemeAWS:
+		s.awsOnce.Do(func() {
+			s.aws, s.awsErr = newAWSClient(ctx, s.cfg.AWS)
+		})
+		if s.awsErr != nil {
+			return "", s.awsErr
+		}
+		id, key, _ := strings.Cut(rest, "#")
+		return s.aws.read(ctx, id, key)
+	}
+	return "", fmt.Errorf("unsupported secret scheme %s", scheme)
+}
+
+// Credentials returns the header and value a tool's credentials are sent
+// as, or "" for both if the tool has none
+func (s *Store) Credentials(c config.CredentialsConfig) (header, value string, err error) {
+	if !c.Configured() {
+		return "", "", nil
+	}
+	secret, err := s.Resolve(c.Secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch c.Type {
+	case config.CredentialHeader:
+		return c.Header, secret, nil
+	case config.CredentialBasic:
+		username, err := s.username(c)
+		if err != nil {
+			return "", "", err
+		}
+		return "Authorization", "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+secret)), nil
+	default:
+		return "Authorization", "Bearer " + secret, nil
+	}
+}
+
+// Login returns the username and password of basic credentials, for tools
+// that log in rather than send a header, e.g. SMTP servers
+func (s *Store) Login(c config.CredentialsConfig) (username, password string, err error) {
+	if password, err = s.Resolve(c.Secret); err != nil {
+		return "", "", err
+	}
+	if username, err = s.username(c); err != nil {
+		return "", "", err
+	}
+	return username, password, nil
+}
+
+// username returns the username of basic credentials, resolving it if it
+// is a reference
+func (s *Store) username(c config.CredentialsConfig) (string, error) {
+	if _, _, ok := config.SplitSecretRef(c.Username); ok {
+		return s.Resolve(c.Username)
+	}
+	return c.Username, nil
+}
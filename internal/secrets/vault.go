@@ -0,0 +1,65 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"aegis-gateway/internal/config"
+)
+
+// vaultClient reads secrets from Vault's KV engine over its HTTP API
+type vaultClient struct {
+	cfg    config.VaultConfig
+	client *http.Client
+}
+
+func newVaultClient(cfg config.VaultConfig) *vaultClient {
+	return &vaultClient{cfg: cfg, client: &http.Client{}}
+}
+
+// vaultResponse is the subset of a Vault read response we use. KV v2 nests
+// the secret in data.data, next to data.metadata.
+type vaultResponse struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+// read returns field key of the secret at path
+func (v *vaultClient) read(ctx context.Context, path, key string) (string, error) {
+	endpoint := fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(v.cfg.Address, "/"), strings.TrimPrefix(path, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.cfg.ResolveToken())
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault read of %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault read of %s failed: status %d", path, resp.StatusCode)
+	}
+
+	var body vaultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("vault read of %s failed: %w", path, err)
+	}
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, v2 := data["metadata"]; v2 {
+			data = nested
+		}
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %s", path, key)
+	}
+	return value, nil
+}
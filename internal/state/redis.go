@@ -0,0 +1,152 @@
+package state
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"aegis-gateway/internal/config"
+)
+
+// DefaultRedisTimeout bounds each Redis operation when no timeout is configured
+const DefaultRedisTimeout = 500 * time.Millisecond
+
+// reserveScript adds to a window's usage unless that would take it above
+// the limit, and starts the window's expiry when it is new, so every
+// replica sees the same window boundaries. It returns 1, or 0 and the time
+// until the window resets. A negative cost is an error, since it would
+// lower the usage.
+var reserveScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+if cost == nil or cost ~= cost or cost < 0 then
+	return redis.error_reply('cost must be a non-negative number')
+end
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+if used + cost > tonumber(ARGV[3]) then
+	return {0, redis.call('PTTL', KEYS[1])}
+end
+redis.call('INCRBYFLOAT', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return {1, 0}
+`)
+
+// releaseScript takes back usage from a window that is still open
+var releaseScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+if tonumber(redis.call('INCRBYFLOAT', KEYS[1], -tonumber(ARGV[1]))) < 0 then
+	redis.call('SET', KEYS[1], '0', 'KEEPTTL')
+end
+return 1
+`)
+
+// RedisStore keeps rate limit and budget usage in Redis. Each window is a
+// key that expires when the window ends.
+type RedisStore struct {
+	client  *redis.Client
+	prefix  string
+	timeout time.Duration
+}
+
+// NewRedisStore connects to the configured Redis. The connection is made
+// lazily; Ping reports whether Redis is reachable.
+func NewRedisStore(cfg config.RedisConfig) *RedisStore {
+	opts := &redis.Options{
+		Addr:     cfg.Address,
+		Password: cfg.ResolvePassword(),
+		DB:       cfg.DB,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	s := &RedisStore{client: redis.NewClient(opts), prefix: cfg.KeyPrefix, timeout: cfg.Timeout}
+	if s.prefix == "" {
+		s.prefix = config.DefaultRedisKeyPrefix
+	}
+	if s.timeout <= 0 {
+		s.timeout = DefaultRedisTimeout
+	}
+	return s
+}
+
+// Ping checks that Redis is reachable
+func (s *RedisStore) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.client.Ping(ctx).Err()
+}
+
+// Usage implements policy.StateStore
+func (s *RedisStore) Usage(key string, per time.Duration) (float64, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var get *redis.StringCmd
+	var ttl *redis.DurationCmd
+	_, err := s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		get = p.Get(ctx, s.prefix+key)
+		ttl = p.PTTL(ctx, s.prefix+key)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	used, err := get.Float64()
+	if err != nil {
+		return 0, 0, err
+	}
+	resetIn := ttl.Val()
+	if resetIn < 0 || resetIn > per {
+		resetIn = 0
+	}
+	return used, resetIn, nil
+}
+
+// Reserve implements policy.StateStore
+func (s *RedisStore) Reserve(key string, per time.Duration, limit, cost float64) (bool, time.Duration, error) {
+	if cost < 0 || math.IsNaN(cost) {
+		return false, 0, fmt.Errorf("cost %v must be a non-negative number", cost)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	result, err := reserveScript.Run(ctx, s.client, []string{s.prefix + key}, cost, per.Milliseconds(), limit).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected reserve result %v", result)
+	}
+	if result[0] == 1 {
+		return true, 0, nil
+	}
+	resetIn := time.Duration(result[1]) * time.Millisecond
+	if resetIn < 0 || resetIn > per {
+		resetIn = 0
+	}
+	return false, resetIn, nil
+}
+
+// Release implements policy.StateStore
+func (s *RedisStore) Release(key string, cost float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return releaseScript.Run(ctx, s.client, []string{s.prefix + key}, cost).Err()
+}
+
+// Close releases the connection pool
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,183 @@
+package state
+
+import (
+	"context"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/config"
+	"aegis-gateway/internal/policy"
+)
+
+// redisTestStore connects to the Redis at AEGIS_TEST_REDIS_ADDR and
+// returns a key unique to the test, or skips the test
+func redisTestStore(t *testing.T) (*RedisStore, string) {
+	t.Helper()
+	address := os.Getenv("AEGIS_TEST_REDIS_ADDR")
+	if address == "" {
+		t.Skip("AEGIS_TEST_REDIS_ADDR is not set")
+	}
+	s := NewRedisStore(config.RedisConfig{Address: address, KeyPrefix: "aegis-test:"})
+	key := "budget|a|t|" + time.Now().Format(time.RFC3339Nano)
+	t.Cleanup(func() {
+		s.client.Del(context.Background(), s.prefix+key)
+		s.Close()
+	})
+	return s, key
+}
+
+// An unreachable Redis is reported as an error rather than as no usage, so
+// the engine can fail closed
+func TestRedisUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	s := NewRedisStore(config.RedisConfig{Address: address, Timeout: 100 * time.Millisecond})
+	defer s.Close()
+	if err := s.Ping(); err == nil {
+		t.Error("Ping succeeded")
+	}
+	if ok, _, err := s.Reserve("rate|a|t", time.Minute, 10, 1); ok || err == nil {
+		t.Errorf("Reserve: got %v, %v", ok, err)
+	}
+	if _, _, err := s.Usage("rate|a|t", time.Minute); err == nil {
+		t.Error("Usage succeeded")
+	}
+}
+
+// Limited calls are denied while the store is unreachable, instead of
+// going through uncounted
+func TestRedisUnreachableDeniesLimitedCalls(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	dir := t.TempDir()
+	policyYAML := `version: "1"
+agents:
+  - id: finance-agent
+    allow:
+      - tool: payments
+        actions: [create, list]
+        conditions:
+          rate_limit: {requests: 10, per: 1m}
+      - tool: reports
+        actions: [read]
+`
+	if err := os.WriteFile(filepath.Join(dir, "policy.yaml"), []byte(policyYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pe, err := policy.LoadPolicyEngine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pe.Close()
+	s := NewRedisStore(config.RedisConfig{Address: address, Timeout: 100 * time.Millisecond})
+	defer s.Close()
+	pe.SetStateStore(s)
+
+	if d := pe.Evaluate("finance-agent", "payments", "create", nil); d.Allowed || d.Code != policy.CodePolicyError {
+		t.Errorf("rate limited call: got allowed=%v code=%q, want %s", d.Allowed, d.Code, policy.CodePolicyError)
+	}
+	if d := pe.Evaluate("finance-agent", "reports", "read", nil); !d.Allowed {
+		t.Errorf("call without limits denied: %s", d.Code)
+	}
+}
+
+// Reservations count toward the window until the limit, and releases take
+// usage back without going below zero
+func TestRedisReserveAndRelease(t *testing.T) {
+	s, key := redisTestStore(t)
+
+	if used, _, err := s.Usage(key, time.Hour); err != nil || used != 0 {
+		t.Fatalf("new window: got usage %v, %v", used, err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := s.Reserve(key, time.Hour, 100, 40); !ok || err != nil {
+			t.Fatalf("reserve %d: got %v, %v", i, ok, err)
+		}
+	}
+	ok, resetIn, err := s.Reserve(key, time.Hour, 100, 40)
+	if ok || err != nil {
+		t.Fatalf("over the limit: got %v, %v", ok, err)
+	}
+	if resetIn <= 0 || resetIn > time.Hour {
+		t.Errorf("over the limit: got reset in %v", resetIn)
+	}
+	if used, _, err := s.Usage(key, time.Hour); err != nil || used != 80 {
+		t.Fatalf("got usage %v, %v; want 80", used, err)
+	}
+
+	if err := s.Release(key, 40); err != nil {
+		t.Fatal(err)
+	}
+	if used, _, _ := s.Usage(key, time.Hour); used != 40 {
+		t.Errorf("after release: got usage %v, want 40", used)
+	}
+	if err := s.Release(key, 100); err != nil {
+		t.Fatal(err)
+	}
+	if used, _, _ := s.Usage(key, time.Hour); used != 0 {
+		t.Errorf("after releasing too much: got usage %v, want 0", used)
+	}
+}
+
+// A negative cost is refused before it reaches Redis
+func TestRedisReserveRejectsNegativeCost(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var connections atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			conn.Close()
+		}
+	}()
+
+	s := NewRedisStore(config.RedisConfig{Address: ln.Addr().String(), Timeout: 100 * time.Millisecond})
+	defer s.Close()
+	for _, cost := range []float64{-5, math.NaN()} {
+		if ok, _, err := s.Reserve("budget|a|t", time.Hour, 100, cost); ok || err == nil {
+			t.Errorf("cost %v: got %v, %v", cost, ok, err)
+		}
+	}
+	if n := connections.Load(); n != 0 {
+		t.Fatalf("connected to Redis %d times", n)
+	}
+}
+
+// The reserve script itself refuses a negative cost and leaves the usage as
+// it was
+func TestRedisReserveScriptRejectsNegativeCost(t *testing.T) {
+	s, key := redisTestStore(t)
+
+	if ok, _, err := s.Reserve(key, time.Hour, 100, 80); !ok || err != nil {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	if err := reserveScript.Run(context.Background(), s.client, []string{s.prefix + key}, -50, time.Hour.Milliseconds(), 100).Err(); err == nil {
+		t.Fatal("script accepted a negative cost")
+	}
+	used, _, err := s.Usage(key, time.Hour)
+	if err != nil || used != 80 {
+		t.Fatalf("got usage %v, %v; want 80", used, err)
+	}
+}
@@ -0,0 +1,231 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Call is a tool call: an action on a tool with its params
+type Call struct {
+	// ID is echoed back by BatchEvaluate to match decisions to calls
+	ID string
+
+	Tool   string
+	Action string
+
+	// Params is encoded as the JSON body; nil sends an empty object
+	Params interface{}
+
+	// Method defaults to POST. Resource is appended to the path, for tools
+	// addressed as /tools/:tool/:action/:resource.
+	Method   string
+	Resource string
+
+	// IdempotencyKey lets the gateway replay the response when the call is
+	// retried. Invoke generates one when it is empty.
+	IdempotencyKey string
+}
+
+// path returns the gateway path of the call
+func (call Call) path() string {
+	path := "/tools/" + call.Tool + "/" + call.Action
+	if call.Resource != "" {
+		path += "/" + call.Resource
+	}
+	return path
+}
+
+// params returns the call's params as JSON
+func (call Call) params() ([]byte, error) {
+	if call.Params == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(call.Params)
+}
+
+// Response is the tool's answer to an allowed call
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	// DecisionID identifies the gateway's evaluation of the call
+	DecisionID string
+}
+
+// Decode unmarshals the JSON body into v
+func (r *Response) Decode(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Invoke evaluates a call and, if it is allowed, forwards it to the tool.
+// A denial is returned as an *Error whose Denied method reports true; an
+// error response of the tool is returned as an *Error carrying its Body.
+func (c *Client) Invoke(ctx context.Context, call Call) (*Response, error) {
+	if call.Tool == "" || call.Action == "" {
+		return nil, fmt.Errorf("tool and action are required")
+	}
+	body, err := call.params()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode params: %w", err)
+	}
+	method := call.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	key := call.IdempotencyKey
+	if key == "" {
+		key = randomID()
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		body = nil
+	}
+
+	resp, data, err := c.do(ctx, request{
+		method: method,
+		path:   call.path(),
+		body:   body,
+		header: http.Header{"Idempotency-Key": {key}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       data,
+		DecisionID: resp.Header.Get("X-Aegis-Decision-ID"),
+	}, nil
+}
+
+// Simulation is a prospective call to explain. Method, Resource, Claims and
+// Groups are optional and stand in for what the real call would carry.
+type Simulation struct {
+	AgentID  string                 `json:"agent_id"`
+	Tool     string                 `json:"tool"`
+	Action   string                 `json:"action"`
+	Params   map[string]interface{} `json:"params"`
+	Method   string                 `json:"method,omitempty"`
+	Resource string                 `json:"resource,omitempty"`
+	Claims   map[string]interface{} `json:"claims,omitempty"`
+	Groups   []string               `json:"groups,omitempty"`
+}
+
+// SimulationResult is the decision for a simulated call and how it was
+// reached
+type SimulationResult struct {
+	Allowed    bool   `json:"allowed"`
+	Code       string `json:"code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Rollout    string `json:"rollout,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+	Fallback   string `json:"fallback,omitempty"`
+
+	Rule             *Rule              `json:"rule,omitempty"`
+	FailedConditions []ConditionFailure `json:"failed_conditions"`
+}
+
+// Rule is the policy rule a simulated call matched
+type Rule struct {
+	AgentID       string                 `json:"agent_id,omitempty"`
+	Group         string                 `json:"group,omitempty"`
+	Tool          string                 `json:"tool"`
+	Actions       []string               `json:"actions"`
+	Conditions    map[string]interface{} `json:"conditions,omitempty"`
+	Rollout       string                 `json:"rollout,omitempty"`
+	PolicyVersion string                 `json:"policy_version"`
+	Source        string                 `json:"source"`
+}
+
+// ConditionFailure is a condition of the matched rule the call didn't meet
+type ConditionFailure struct {
+	Condition string `json:"condition"`
+	Code      string `json:"code"`
+	Reason    string `json:"reason"`
+}
+
+// Simulate explains the decision for a call without forwarding it. It
+// doesn't consume rate limits or budgets.
+func (c *Client) Simulate(ctx context.Context, sim Simulation) (*SimulationResult, error) {
+	if sim.AgentID == "" {
+		sim.AgentID = c.agentID
+	}
+	var result SimulationResult
+	if err := c.post(ctx, "/v1/simulate", sim, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// BatchResult holds a decision per call of a batch, in order
+type BatchResult struct {
+	// Allowed is true when every call is
+	Allowed   bool            `json:"allowed"`
+	Decisions []BatchDecision `json:"decisions"`
+}
+
+// BatchDecision is the decision for one call of a batch
+type BatchDecision struct {
+	ID         string `json:"id,omitempty"`
+	Tool       string `json:"tool"`
+	Action     string `json:"action"`
+	Allowed    bool   `json:"allowed"`
+	Code       string `json:"code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+	DecisionID string `json:"decision_id,omitempty"`
+}
+
+// BatchEvaluate evaluates the calls of a plan as the client's agent without
+// forwarding them, so a plan can be checked before its first step runs
+func (c *Client) BatchEvaluate(ctx context.Context, calls []Call) (*BatchResult, error) {
+	type batchCall struct {
+		ID       string          `json:"id,omitempty"`
+		Tool     string          `json:"tool"`
+		Action   string          `json:"action"`
+		Params   json.RawMessage `json:"params,omitempty"`
+		Method   string          `json:"method,omitempty"`
+		Resource string          `json:"resource,omitempty"`
+	}
+	batch := make([]batchCall, len(calls))
+	for i, call := range calls {
+		params, err := call.params()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode params of call %d: %w", i, err)
+		}
+		batch[i] = batchCall{
+			ID:       call.ID,
+			Tool:     call.Tool,
+			Action:   call.Action,
+			Params:   params,
+			Method:   call.Method,
+			Resource: call.Resource,
+		}
+	}
+
+	var result BatchResult
+	if err := c.post(ctx, "/v1/evaluate/batch", map[string]interface{}{"calls": batch}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// post sends in as JSON to a gateway endpoint and decodes the response
+// into out. These endpoints have no side effects, so they are retried
+// without an idempotency key.
+func (c *Client) post(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	_, data, err := c.do(ctx, request{method: http.MethodPost, path: path, body: body})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", path, err)
+	}
+	return nil
+}
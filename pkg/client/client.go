@@ -0,0 +1,338 @@
+// Package client is a Go client for Aegis Gateway. It authenticates as an
+// agent, retries calls that failed in transit, gives every tool call an
+// Idempotency-Key so retries can't run it twice, and propagates the trace
+// context of the caller:
+//
+//	c, err := client.New("https://aegis.internal:8080",
+//		client.WithAgentID("finance-agent"),
+//		client.WithToken(os.Getenv("AEGIS_API_KEY")))
+//	resp, err := c.Invoke(ctx, client.Call{
+//		Tool:   "payments",
+//		Action: "create",
+//		Params: map[string]interface{}{"amount": 500, "currency": "USD"},
+//	})
+//
+// Denials and gateway errors are returned as *Error.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"aegis-gateway/internal/auth"
+	"aegis-gateway/pkg/telemetry"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Retry defaults used unless WithRetries overrides them
+const (
+	DefaultRetries         = 2
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultRetryMaxBackoff = 2 * time.Second
+)
+
+// DefaultTimeout bounds each attempt of a request made with the default
+// HTTP client
+const DefaultTimeout = 30 * time.Second
+
+// maxRetryAfter is the longest Retry-After a retry will wait for; longer
+// waits are left to the caller
+const maxRetryAfter = 10 * time.Second
+
+// retryStatusCodes are the responses retried: the gateway or the tool
+// behind it was unavailable
+var retryStatusCodes = map[int]bool{
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// Client calls an Aegis Gateway as one agent. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+
+	agentID       string
+	token         string
+	secret        string
+	sessionID     string
+	schemaVersion string
+
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAgentID sends the agent ID as X-Agent-ID. It is required for signed
+// requests; with a token or client certificate it must match the identity
+// they carry.
+func WithAgentID(id string) Option {
+	return func(c *Client) { c.agentID = id }
+}
+
+// WithToken authenticates with a bearer token: a gateway API key, a JWT or
+// an OIDC ID token
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithSigningSecret signs every request with the agent's shared secret
+// (see auth.hmac in the gateway config)
+func WithSigningSecret(secret string) Option {
+	return func(c *Client) { c.secret = secret }
+}
+
+// WithHTTPClient replaces the HTTP client, e.g. with one presenting a
+// client certificate for mTLS
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetries sets how many times a failed request is retried, and the
+// initial and maximum backoff between attempts. Zero retries disables them.
+func WithRetries(retries int, backoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries, c.backoff, c.maxBackoff = retries, backoff, maxBackoff
+	}
+}
+
+// WithSessionID tags every call with the agent's session or conversation
+func WithSessionID(id string) Option {
+	return func(c *Client) { c.sessionID = id }
+}
+
+// WithSchemaVersion names the tool schema version calls are built against
+func WithSchemaVersion(version string) Option {
+	return func(c *Client) { c.schemaVersion = version }
+}
+
+// New returns a client for the gateway at baseURL
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("gateway URL must be an http:// or https:// URL, got %s", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+		retries:    DefaultRetries,
+		backoff:    DefaultRetryBackoff,
+		maxBackoff: DefaultRetryMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.secret != "" && c.agentID == "" {
+		return nil, fmt.Errorf("signed requests require an agent ID")
+	}
+	if c.retries < 0 {
+		return nil, fmt.Errorf("retries must not be negative")
+	}
+	return c, nil
+}
+
+// Error is a problem (RFC 7807) the gateway answered with: a policy denial,
+// an error of the tool or of the request
+type Error struct {
+	StatusCode int    `json:"status"`
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Detail     string `json:"detail,omitempty"`
+	Code       string `json:"code,omitempty"`
+	DecisionID string `json:"decision_id,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+
+	// RetryAfter is how long to wait before the call may be allowed, for
+	// rate limit and budget denials
+	RetryAfter time.Duration `json:"-"`
+
+	// Body is the response body, e.g. the error a tool answered with
+	Body []byte `json:"-"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("aegis: %d %s", e.StatusCode, e.Title)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	return msg
+}
+
+// Denied reports whether the call was denied by policy
+func (e *Error) Denied() bool {
+	return strings.HasSuffix(e.Type, ":policy-violation")
+}
+
+// request is one API request, sent again as is on retry
+type request struct {
+	method string
+	path   string
+	body   []byte
+	header http.Header
+}
+
+// do sends req, retrying transport failures and unavailable responses.
+// A response that isn't 2xx is returned as *Error.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		resp, body, err := c.send(ctx, req)
+		if attempt >= c.retries || ctx.Err() != nil {
+			return resp, body, err
+		}
+
+		var wait time.Duration
+		switch {
+		case err != nil && resp == nil:
+			wait = c.delay(attempt + 1)
+		case resp != nil && retryStatusCodes[resp.StatusCode]:
+			wait = c.delay(attempt + 1)
+			if apiErr, ok := err.(*Error); ok && apiErr.RetryAfter > 0 {
+				if apiErr.RetryAfter > maxRetryAfter {
+					return resp, body, err
+				}
+				wait = apiErr.RetryAfter
+			}
+		default:
+			return resp, body, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return resp, body, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// send makes one attempt
+func (c *Client) send(ctx context.Context, req request) (*http.Response, []byte, error) {
+	u := *c.baseURL
+	path, query, _ := strings.Cut(req.path, "?")
+	u.Path += path
+	u.RawQuery = query
+
+	var body io.Reader
+	if req.body != nil {
+		body = bytes.NewReader(req.body)
+	}
+	hreq, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range req.header {
+		hreq.Header[name] = values
+	}
+	if req.body != nil {
+		hreq.Header.Set("Content-Type", "application/json")
+	}
+	hreq.Header.Set("Accept", "application/json")
+	c.authenticate(hreq, req.body)
+	if c.sessionID != "" {
+		hreq.Header.Set("X-Agent-Session-ID", c.sessionID)
+	}
+	if c.schemaVersion != "" {
+		hreq.Header.Set("X-Aegis-Schema-Version", c.schemaVersion)
+	}
+	if id := telemetry.RequestID(ctx); id != "" {
+		hreq.Header.Set("X-Request-ID", id)
+	}
+	telemetry.Inject(ctx, propagation.HeaderCarrier(hreq.Header))
+
+	resp, err := c.httpClient.Do(hreq)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, data, parseError(resp, data)
+	}
+	return resp, data, nil
+}
+
+// authenticate adds the agent's credentials to req. Each attempt is signed
+// afresh, since the gateway rejects a reused nonce.
+func (c *Client) authenticate(req *http.Request, body []byte) {
+	if c.agentID != "" {
+		req.Header.Set("X-Agent-ID", c.agentID)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := randomID()
+		req.Header.Set(auth.TimestampHeader, timestamp)
+		req.Header.Set(auth.NonceHeader, nonce)
+		req.Header.Set(auth.SignatureHeader, auth.SignRequest(c.secret, timestamp, nonce, req.Method, req.URL.RequestURI(), body))
+	}
+}
+
+// delay returns the wait before the given retry (1-based), using
+// exponential backoff with full jitter
+func (c *Client) delay(retry int) time.Duration {
+	ceiling := c.backoff
+	for i := 1; i < retry && ceiling < c.maxBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > c.maxBackoff {
+		ceiling = c.maxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(mathrand.Int63n(int64(ceiling)) + 1)
+}
+
+// parseError reads the problem document of a failed response. Responses a
+// tool sent, which aren't problems, are described by their status.
+func parseError(resp *http.Response, body []byte) *Error {
+	e := &Error{}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/problem+json") {
+		json.Unmarshal(body, e)
+	}
+	e.StatusCode, e.Body = resp.StatusCode, body
+	if e.Title == "" {
+		e.Title = http.StatusText(resp.StatusCode)
+	}
+	if e.DecisionID == "" {
+		e.DecisionID = resp.Header.Get("X-Aegis-Decision-ID")
+	}
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+// randomID returns 16 random bytes, hex encoded
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
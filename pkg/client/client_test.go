@@ -0,0 +1,231 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"aegis-gateway/internal/auth"
+)
+
+// newTestClient returns a client of a gateway served by handler, retrying
+// without delay
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL+"/", append([]Option{WithRetries(2, time.Millisecond, time.Millisecond)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestInvoke(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got, body = r, mustRead(t, r)
+		w.Header().Set("X-Aegis-Decision-ID", "dec-1")
+		w.Write([]byte(`{"id":"pay_1"}`))
+	}, WithAgentID("finance-agent"), WithToken("key-1"), WithSessionID("sess-1"), WithSchemaVersion("v2"))
+
+	resp, err := c.Invoke(context.Background(), Call{Tool: "payments", Action: "refund", Resource: "pay_1", Params: map[string]interface{}{"amount": 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct{ ID string }
+	if err := resp.Decode(&out); err != nil || out.ID != "pay_1" || resp.DecisionID != "dec-1" {
+		t.Errorf("got %+v, %s, %v", resp, out.ID, err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"method", got.Method, http.MethodPost},
+		{"path", got.URL.Path, "/tools/payments/refund/pay_1"},
+		{"body", string(body), `{"amount":5}`},
+		{"agent", got.Header.Get("X-Agent-ID"), "finance-agent"},
+		{"token", got.Header.Get("Authorization"), "Bearer key-1"},
+		{"session", got.Header.Get("X-Agent-Session-ID"), "sess-1"},
+		{"schema version", got.Header.Get("X-Aegis-Schema-Version"), "v2"},
+		{"content type", got.Header.Get("Content-Type"), "application/json"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+	if len(got.Header.Get("Idempotency-Key")) != 32 {
+		t.Errorf("got Idempotency-Key %q", got.Header.Get("Idempotency-Key"))
+	}
+
+	if _, err := c.Invoke(context.Background(), Call{Tool: "payments"}); err == nil {
+		t.Error("call without an action accepted")
+	}
+}
+
+// Retries resend the same call, idempotency key included, and sign each
+// attempt with a fresh nonce
+func TestRetries(t *testing.T) {
+	var mu sync.Mutex
+	var keys, nonces []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body := mustRead(t, r)
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		nonces = append(nonces, r.Header.Get(auth.NonceHeader))
+		timestamp, nonce := r.Header.Get(auth.TimestampHeader), r.Header.Get(auth.NonceHeader)
+		if r.Header.Get(auth.SignatureHeader) != auth.SignRequest("s3cret", timestamp, nonce, r.Method, r.URL.RequestURI(), body) {
+			t.Error("signature doesn't match the request")
+		}
+		if len(keys) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}, WithAgentID("finance-agent"), WithSigningSecret("s3cret"))
+
+	if _, err := c.Invoke(context.Background(), Call{Tool: "payments", Action: "create"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Errorf("got idempotency keys %v", keys)
+	}
+	if nonces[0] == nonces[1] || nonces[1] == nonces[2] {
+		t.Errorf("got nonces %v", nonces)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		retryAfter   string
+		wantAttempts int32
+	}{
+		{"unavailable", http.StatusServiceUnavailable, "", 3},
+		{"bad gateway", http.StatusBadGateway, "", 3},
+		{"short Retry-After", http.StatusServiceUnavailable, "0", 3},
+		{"long Retry-After", http.StatusServiceUnavailable, "60", 1},
+		{"denied", http.StatusForbidden, "", 1},
+		{"rate limited", http.StatusTooManyRequests, "1", 1},
+	}
+	for _, tt := range tests {
+		var attempts atomic.Int32
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			if tt.retryAfter != "" {
+				w.Header().Set("Retry-After", tt.retryAfter)
+			}
+			w.WriteHeader(tt.status)
+		})
+		_, err := c.Invoke(context.Background(), Call{Tool: "payments", Action: "create"})
+		var apiErr *Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status || attempts.Load() != tt.wantAttempts {
+			t.Errorf("%s: got %v after %d attempts, want %d", tt.name, err, attempts.Load(), tt.wantAttempts)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tools/payments/create":
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"type":"urn:aegis-gateway:problem:policy-violation","status":429,"title":"Too Many Requests","detail":"rate limit exceeded","code":"RATE_LIMITED","decision_id":"dec-2"}`))
+		default:
+			w.Header().Set("X-Request-ID", "req-3")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("no such invoice"))
+		}
+	})
+
+	_, err := c.Invoke(context.Background(), Call{Tool: "payments", Action: "create"})
+	var denied *Error
+	if !errors.As(err, &denied) || !denied.Denied() || denied.Code != "RATE_LIMITED" || denied.DecisionID != "dec-2" || denied.RetryAfter != 30*time.Second {
+		t.Errorf("denial: got %+v", err)
+	}
+	if want := "aegis: 429 Too Many Requests: rate limit exceeded (RATE_LIMITED)"; err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+
+	_, err = c.Invoke(context.Background(), Call{Tool: "invoices", Action: "get", Method: http.MethodGet})
+	var toolErr *Error
+	if !errors.As(err, &toolErr) || toolErr.Denied() || toolErr.Title != "Not Found" || string(toolErr.Body) != "no such invoice" || toolErr.RequestID != "req-3" {
+		t.Errorf("tool error: got %+v", err)
+	}
+}
+
+func TestSimulateAndBatch(t *testing.T) {
+	var bodies sync.Map
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		bodies.Store(r.URL.Path, string(mustRead(t, r)))
+		switch r.URL.Path {
+		case "/v1/simulate":
+			w.Write([]byte(`{"allowed":false,"code":"CONDITION_FAILED","failed_conditions":[{"condition":"max_amount","code":"CONDITION_FAILED","reason":"amount 900 exceeds 500"}]}`))
+		case "/v1/evaluate/batch":
+			w.Write([]byte(`{"allowed":true,"decisions":[{"id":"step-1","tool":"payments","action":"create","allowed":true}]}`))
+		}
+	}, WithAgentID("finance-agent"))
+	ctx := context.Background()
+
+	sim, err := c.Simulate(ctx, Simulation{Tool: "payments", Action: "create", Params: map[string]interface{}{"amount": 900}})
+	if err != nil || sim.Allowed || len(sim.FailedConditions) != 1 || sim.FailedConditions[0].Condition != "max_amount" {
+		t.Errorf("simulate: got %+v, %v", sim, err)
+	}
+	var simBody Simulation
+	body, _ := bodies.Load("/v1/simulate")
+	if json.Unmarshal([]byte(body.(string)), &simBody); simBody.AgentID != "finance-agent" {
+		t.Errorf("simulate: got body %s, want the client's agent", body)
+	}
+
+	batch, err := c.BatchEvaluate(ctx, []Call{{ID: "step-1", Tool: "payments", Action: "create"}})
+	if err != nil || !batch.Allowed || len(batch.Decisions) != 1 || batch.Decisions[0].ID != "step-1" {
+		t.Errorf("batch: got %+v, %v", batch, err)
+	}
+	if body, _ := bodies.Load("/v1/evaluate/batch"); body != `{"calls":[{"id":"step-1","tool":"payments","action":"create","params":{}}]}` {
+		t.Errorf("batch: got body %s", body)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		opts    []Option
+		wantErr bool
+	}{
+		{"https", "https://aegis.internal:8080", nil, false},
+		{"no scheme", "aegis.internal:8080", nil, true},
+		{"unsupported scheme", "ftp://aegis.internal", nil, true},
+		{"secret without agent", "https://aegis.internal", []Option{WithSigningSecret("s3cret")}, true},
+		{"negative retries", "https://aegis.internal", []Option{WithRetries(-1, 0, 0)}, true},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.url, tt.opts...); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
+
+// mustRead reads the request body
+func mustRead(t *testing.T, r *http.Request) []byte {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	return data
+}
@@ -0,0 +1,40 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// AdminAuditLog is an entry of the admin API audit trail
+type AdminAuditLog struct {
+	Timestamp  string `json:"timestamp"`
+	RequestID  string `json:"request.id,omitempty"`
+	Principal  string `json:"admin.principal,omitempty"`
+	Role       string `json:"admin.role,omitempty"`
+	Method     string `json:"http.method"`
+	Path       string `json:"http.path"`
+	Status     int    `json:"http.status"`
+	RemoteAddr string `json:"client.address"`
+	LatencyMS  int64  `json:"latency.ms"`
+}
+
+// LogAdmin appends an entry to admin-audit.log in the log directory, kept
+// apart from agent decisions
+func (t *Telemetry) LogAdmin(entry AdminAuditLog) {
+	t.adminLogOnce.Do(func() {
+		t.adminLog, t.adminLogErr = os.OpenFile(filepath.Join(t.logDir, "admin-audit.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	})
+	if t.adminLogErr != nil {
+		t.metrics.exporterErrors.WithLabelValues(exporterAuditLog).Inc()
+		logger.Error("Failed to open admin audit log", "error", t.adminLogErr)
+		return
+	}
+
+	if entry.Timestamp == "" {
+		entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	logJSON, _ := json.Marshal(entry)
+	t.appendLog(t.adminLog, logJSON)
+}
@@ -0,0 +1,293 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxTopTools bounds the tools AgentAnalytics lists
+const maxTopTools = 10
+
+// AgentAnalytics summarizes an agent's calls over a window
+type AgentAnalytics struct {
+	AgentID string    `json:"agent_id"`
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until"`
+
+	Calls      int     `json:"calls"`
+	Allowed    int     `json:"allowed"`
+	Denied     int     `json:"denied"`
+	AllowRatio float64 `json:"allow_ratio"`
+
+	// DenialsByCode counts denials by deny code
+	DenialsByCode map[string]int `json:"denials_by_code"`
+
+	// TopTools are the tools the agent called most, most called first
+	TopTools []ToolUsage `json:"top_tools"`
+
+	// LatencyMS are percentiles of the time the gateway took to decide
+	// the agent's calls
+	LatencyMS LatencyPercentiles `json:"latency_ms"`
+}
+
+// ToolUsage counts an agent's calls to one tool
+type ToolUsage struct {
+	Tool    string `json:"tool"`
+	Calls   int    `json:"calls"`
+	Allowed int    `json:"allowed"`
+	Denied  int    `json:"denied"`
+}
+
+// LatencyPercentiles are nearest-rank percentiles, in milliseconds
+type LatencyPercentiles struct {
+	P50 int64 `json:"p50"`
+	P90 int64 `json:"p90"`
+	P95 int64 `json:"p95"`
+	P99 int64 `json:"p99"`
+	Max int64 `json:"max"`
+}
+
+// AnalyzeAgent summarizes the agent's stored decisions from since until
+// until. Only calls that were enforced count: batch pre-checks and
+// response checks are left out.
+func (t *Telemetry) AnalyzeAgent(ctx context.Context, agentID string, since, until time.Time) (AgentAnalytics, error) {
+	s := t.store.Load()
+	if s == nil {
+		return AgentAnalytics{}, ErrNoDecisionStore
+	}
+	return s.analyze(ctx, agentID, since, until)
+}
+
+func (s *decisionStore) analyze(ctx context.Context, agentID string, since, until time.Time) (AgentAnalytics, error) {
+	a := AgentAnalytics{
+		AgentID:       agentID,
+		Since:         since.UTC(),
+		Until:         until.UTC(),
+		DenialsByCode: make(map[string]int),
+		TopTools:      make([]ToolUsage, 0),
+	}
+	tools := make(map[string]*ToolUsage)
+	var latencies []int64
+	err := s.scan(ctx, agentID, since, until, func(entry DecisionLog) {
+		tool := tools[entry.ToolName]
+		if tool == nil {
+			tool = &ToolUsage{Tool: entry.ToolName}
+			tools[entry.ToolName] = tool
+		}
+		a.Calls++
+		tool.Calls++
+		if entry.result() == "allow" {
+			a.Allowed++
+			tool.Allowed++
+		} else {
+			a.Denied++
+			tool.Denied++
+			a.DenialsByCode[entry.Code]++
+		}
+		latencies = append(latencies, entry.LatencyMS)
+	})
+	if err != nil {
+		return a, err
+	}
+
+	if a.Calls > 0 {
+		a.AllowRatio = float64(a.Allowed) / float64(a.Calls)
+	}
+	for _, tool := range tools {
+		a.TopTools = append(a.TopTools, *tool)
+	}
+	sort.Slice(a.TopTools, func(i, j int) bool {
+		if a.TopTools[i].Calls != a.TopTools[j].Calls {
+			return a.TopTools[i].Calls > a.TopTools[j].Calls
+		}
+		return a.TopTools[i].Tool < a.TopTools[j].Tool
+	})
+	if len(a.TopTools) > maxTopTools {
+		a.TopTools = a.TopTools[:maxTopTools]
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		a.LatencyMS = LatencyPercentiles{
+			P50: percentile(latencies, 50),
+			P90: percentile(latencies, 90),
+			P95: percentile(latencies, 95),
+			P99: percentile(latencies, 99),
+			Max: latencies[len(latencies)-1],
+		}
+	}
+	return a, nil
+}
+
+// maxDenialGroups bounds the denial groups DecisionSummary lists
+const maxDenialGroups = 25
+
+// DecisionSummary summarizes the calls of all agents over a window
+type DecisionSummary struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+
+	Calls   int `json:"calls"`
+	Allowed int `json:"allowed"`
+	Denied  int `json:"denied"`
+
+	// Agents and Tools count calls per agent and per tool, most called
+	// first
+	Agents []AgentUsage `json:"agents"`
+	Tools  []ToolUsage  `json:"tools"`
+
+	// Denials group the denials by agent, tool and code, most frequent
+	// first
+	Denials []DenialGroup `json:"denials"`
+}
+
+// AgentUsage counts the calls of one agent
+type AgentUsage struct {
+	AgentID string `json:"agent_id"`
+	Calls   int    `json:"calls"`
+	Allowed int    `json:"allowed"`
+	Denied  int    `json:"denied"`
+}
+
+// DenialGroup counts the denials of one agent, tool and deny code, with
+// the reason and time of the latest
+type DenialGroup struct {
+	AgentID string `json:"agent_id"`
+	Tool    string `json:"tool"`
+	Code    string `json:"code"`
+	Count   int    `json:"count"`
+	Reason  string `json:"reason,omitempty"`
+	Last    string `json:"last"`
+}
+
+// SummarizeDecisions summarizes the stored decisions of all agents from
+// since until until. Like AnalyzeAgent it counts only enforced calls. Up
+// to 25 denial groups are listed.
+func (t *Telemetry) SummarizeDecisions(ctx context.Context, since, until time.Time) (DecisionSummary, error) {
+	s := t.store.Load()
+	if s == nil {
+		return DecisionSummary{}, ErrNoDecisionStore
+	}
+	sum := DecisionSummary{
+		Since:   since.UTC(),
+		Until:   until.UTC(),
+		Agents:  make([]AgentUsage, 0),
+		Tools:   make([]ToolUsage, 0),
+		Denials: make([]DenialGroup, 0),
+	}
+	agents := make(map[string]*AgentUsage)
+	tools := make(map[string]*ToolUsage)
+	denials := make(map[[3]string]*DenialGroup)
+	err := s.scan(ctx, "", since, until, func(entry DecisionLog) {
+		agent := agents[entry.AgentID]
+		if agent == nil {
+			agent = &AgentUsage{AgentID: entry.AgentID}
+			agents[entry.AgentID] = agent
+		}
+		tool := tools[entry.ToolName]
+		if tool == nil {
+			tool = &ToolUsage{Tool: entry.ToolName}
+			tools[entry.ToolName] = tool
+		}
+		sum.Calls++
+		agent.Calls++
+		tool.Calls++
+		if entry.result() == "allow" {
+			sum.Allowed++
+			agent.Allowed++
+			tool.Allowed++
+			return
+		}
+		sum.Denied++
+		agent.Denied++
+		tool.Denied++
+		key := [3]string{entry.AgentID, entry.ToolName, entry.Code}
+		group := denials[key]
+		if group == nil {
+			group = &DenialGroup{AgentID: entry.AgentID, Tool: entry.ToolName, Code: entry.Code}
+			denials[key] = group
+		}
+		group.Count++
+		if entry.Timestamp >= group.Last {
+			group.Last, group.Reason = entry.Timestamp, entry.Reason
+		}
+	})
+	if err != nil {
+		return sum, err
+	}
+
+	for _, agent := range agents {
+		sum.Agents = append(sum.Agents, *agent)
+	}
+	sort.Slice(sum.Agents, func(i, j int) bool {
+		if sum.Agents[i].Calls != sum.Agents[j].Calls {
+			return sum.Agents[i].Calls > sum.Agents[j].Calls
+		}
+		return sum.Agents[i].AgentID < sum.Agents[j].AgentID
+	})
+	for _, tool := range tools {
+		sum.Tools = append(sum.Tools, *tool)
+	}
+	sort.Slice(sum.Tools, func(i, j int) bool {
+		if sum.Tools[i].Calls != sum.Tools[j].Calls {
+			return sum.Tools[i].Calls > sum.Tools[j].Calls
+		}
+		return sum.Tools[i].Tool < sum.Tools[j].Tool
+	})
+	for _, group := range denials {
+		sum.Denials = append(sum.Denials, *group)
+	}
+	sort.Slice(sum.Denials, func(i, j int) bool {
+		if sum.Denials[i].Count != sum.Denials[j].Count {
+			return sum.Denials[i].Count > sum.Denials[j].Count
+		}
+		return sum.Denials[i].Last > sum.Denials[j].Last
+	})
+	if len(sum.Denials) > maxDenialGroups {
+		sum.Denials = sum.Denials[:maxDenialGroups]
+	}
+	return sum, nil
+}
+
+// scan calls fn with every enforced call of the agent, or of all agents if
+// agentID is empty, stored from since until until. Batch pre-checks and
+// response checks are skipped.
+func (s *decisionStore) scan(ctx context.Context, agentID string, since, until time.Time, fn func(DecisionLog)) error {
+	args := []interface{}{since.UnixMicro(), until.UnixMicro()}
+	where := []string{"time_us >= " + s.placeholder(1), "time_us < " + s.placeholder(2)}
+	if agentID != "" {
+		args = append(args, agentID)
+		where = append(where, "agent_id = "+s.placeholder(3))
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT entry FROM aegis_decisions WHERE "+strings.Join(where, " AND "), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		var entry DecisionLog
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return err
+		}
+		if entry.Phase == "" {
+			fn(entry)
+		}
+	}
+	return rows.Err()
+}
+
+// percentile returns the nearest-rank pth percentile of sorted values
+func percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
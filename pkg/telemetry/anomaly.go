@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PhaseAnomaly is the decision.phase of anomaly entries
+const PhaseAnomaly = "anomaly"
+
+// Anomaly is unusual behavior of an agent, flagged on one of its calls
+type Anomaly struct {
+	// Type is new_tool, volume_spike, off_hours or near_limit
+	Type string
+
+	// Reason explains how the call deviates from the agent's baseline
+	Reason string
+
+	// Action is what the gateway did about it: log or require_approval
+	Action string
+
+	// The call the anomaly was flagged on
+	DecisionID string
+	AgentID    string
+	SessionID  string
+	Tool       string
+	ToolAction string
+	Allowed    bool
+	Code       string
+
+	// AgentVerified is set when AgentID was proven by a credential, see
+	// Decision
+	AgentVerified bool
+}
+
+// LogAnomaly records an anomaly on the call's trace and in the audit log,
+// as an entry with decision.phase anomaly that carries the call's decision
+func (t *Telemetry) LogAnomaly(ctx context.Context, a Anomaly) {
+	_, span := t.tracer.Start(ctx, "policy.anomaly", trace.WithAttributes(withRequestID(ctx, []attribute.KeyValue{
+		attribute.String("agent.id", a.AgentID),
+		attribute.String("tool.name", a.Tool),
+		attribute.String("tool.action", a.ToolAction),
+		attribute.String("anomaly.type", a.Type),
+		attribute.String("anomaly.action", a.Action),
+	})...))
+	defer span.End()
+	t.metrics.recordAnomaly(metricLabel(a.AgentID, a.AgentVerified), a.Type)
+
+	decisionStr := "false"
+	if a.Allowed {
+		decisionStr = "true"
+	}
+	t.writeDecision(DecisionLog{
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		DecisionID:    a.DecisionID,
+		RequestID:     RequestID(ctx),
+		AgentID:       a.AgentID,
+		SessionID:     a.SessionID,
+		ToolName:      a.Tool,
+		ToolAction:    a.ToolAction,
+		Decision:      decisionStr,
+		Code:          a.Code,
+		Reason:        a.Reason,
+		Phase:         PhaseAnomaly,
+		AnomalyType:   a.Type,
+		AnomalyAction: a.Action,
+		TraceID:       span.SpanContext().TraceID().String(),
+		SpanID:        span.SpanContext().SpanID().String(),
+	})
+}
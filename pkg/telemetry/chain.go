@@ -0,0 +1,291 @@
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DefaultCheckpointEvery is how many chained entries a file sink writes
+// between signed checkpoints
+const DefaultCheckpointEvery = 1000
+
+// maxAuditLine bounds the lines VerifyAuditLog reads
+const maxAuditLine = 16 << 20
+
+// auditChain links the entries of a file sink. Each entry carries its
+// sequence number and the hash of the entry before it, and ends with its
+// own hash, the SHA-256 of the entry as written up to that field. Editing,
+// removing or reordering entries breaks the chain; checkpoints signed with
+// the sink's key make truncating the file detectable too.
+type auditChain struct {
+	seq  uint64
+	head string
+
+	key   ed25519.PrivateKey
+	every int
+	since int
+}
+
+// chainFields are the chain and checkpoint fields of an audit log line
+type chainFields struct {
+	Seq  uint64 `json:"chain.seq"`
+	Prev string `json:"chain.prev"`
+	Hash string `json:"chain.hash"`
+
+	CheckpointSeq  *uint64 `json:"checkpoint.seq"`
+	CheckpointHash string  `json:"checkpoint.hash"`
+	Signature      string  `json:"checkpoint.signature"`
+}
+
+// checkpointLine is a signed statement of the chain's head
+type checkpointLine struct {
+	Timestamp string `json:"timestamp"`
+	Seq       uint64 `json:"checkpoint.seq"`
+	Hash      string `json:"checkpoint.hash"`
+	Signature string `json:"checkpoint.signature"`
+}
+
+// newAuditChain continues the chain at the end of the file at path, if
+// there is one
+func newAuditChain(path string, key ed25519.PrivateKey, every int) (*auditChain, error) {
+	if every == 0 {
+		every = DefaultCheckpointEvery
+	}
+	c := &auditChain{key: key, every: every}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The last chained entry is within the file's tail
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - maxAuditLine
+	if offset < 0 {
+		offset = 0
+	}
+	tail, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.Split(tail, []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		var fields chainFields
+		if json.Unmarshal(lines[i], &fields) == nil && fields.Hash != "" {
+			c.seq, c.head = fields.Seq, fields.Hash
+			break
+		}
+	}
+	return c, nil
+}
+
+// link returns the line for entry and the hash that becomes the chain's
+// head once the line is written
+func (c *auditChain) link(entry DecisionLog) ([]byte, string, error) {
+	entry.ChainSeq = c.seq + 1
+	entry.ChainPrev = c.head
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	line := append(body[:len(body)-1], `,"chain.hash":"`+hash+`"}`...)
+	return line, hash, nil
+}
+
+// advance makes hash the head after its line was written. It reports
+// whether a checkpoint is due.
+func (c *auditChain) advance(hash string) bool {
+	c.seq++
+	c.head = hash
+	c.since++
+	return c.key != nil && c.since >= c.every
+}
+
+// checkpoint returns a signed checkpoint of the head, or nil if the chain
+// has no key or nothing new to sign
+func (c *auditChain) checkpoint() ([]byte, error) {
+	if c.key == nil || c.since == 0 {
+		return nil, nil
+	}
+	c.since = 0
+	sig := ed25519.Sign(c.key, checkpointMessage(c.seq, c.head))
+	return json.Marshal(checkpointLine{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Seq:       c.seq,
+		Hash:      c.head,
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	})
+}
+
+// checkpointMessage is what a checkpoint signature covers
+func checkpointMessage(seq uint64, hash string) []byte {
+	return []byte("aegis-audit-checkpoint\n" + strconv.FormatUint(seq, 10) + "\n" + hash)
+}
+
+// ParseSigningKey parses a base64 Ed25519 private key or seed
+func ParseSigningKey(value string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("signing key is not base64: %w", err)
+	}
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(raw), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(raw), nil
+	}
+	return nil, fmt.Errorf("signing key must be a %d byte seed or %d byte private key", ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// ParsePublicKey parses a base64 Ed25519 public key
+func ParsePublicKey(value string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("public key is not base64: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// AuditLogReport summarizes a verified audit log
+type AuditLogReport struct {
+	// Entries is the number of chained entries
+	Entries int
+
+	// Unchained is the number of entries before the chain starts, written
+	// before chaining was enabled
+	Unchained int
+
+	// FirstSeq and LastSeq are the sequence numbers of the first and last
+	// chained entries. A FirstSeq above 1 means the file starts mid-chain,
+	// e.g. after rotation.
+	FirstSeq uint64
+	LastSeq  uint64
+
+	// Head is the hash of the last entry
+	Head string
+
+	// Checkpoints is the number of checkpoints, and SignedSeq the sequence
+	// number the last one covers. Entries after it could be truncated
+	// without detection.
+	Checkpoints int
+	SignedSeq   uint64
+
+	// unsigned counts the entries since the last checkpoint
+	unsigned int
+}
+
+// VerifyAuditLog checks the hash chain of an audit log and, if publicKey
+// isn't nil, the signatures of its checkpoints. With a key the log must
+// also have a checkpoint, and no more than checkpointEvery entries
+// (DefaultCheckpointEvery if 0) may follow one another without a
+// checkpoint, since the sink writes one at least that often; otherwise
+// entries could have been cut from the end. It returns an error naming the
+// first line that fails.
+func VerifyAuditLog(r io.Reader, publicKey ed25519.PublicKey, checkpointEvery int) (AuditLogReport, error) {
+	if checkpointEvery <= 0 {
+		checkpointEvery = DefaultCheckpointEvery
+	}
+	var report AuditLogReport
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxAuditLine)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if err := report.verifyLine(line, publicKey); err != nil {
+			return report, fmt.Errorf("line %d: %w", n, err)
+		}
+		if publicKey != nil && report.unsigned > checkpointEvery {
+			return report, fmt.Errorf("line %d: more than %d entries without a checkpoint", n, checkpointEvery)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return report, err
+	}
+	if publicKey != nil && report.Entries > 0 && report.Checkpoints == 0 {
+		return report, fmt.Errorf("no signed checkpoints")
+	}
+	return report, nil
+}
+
+func (r *AuditLogReport) verifyLine(line []byte, publicKey ed25519.PublicKey) error {
+	var fields chainFields
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	if fields.CheckpointSeq != nil {
+		if *fields.CheckpointSeq != r.LastSeq || fields.CheckpointHash != r.Head {
+			return fmt.Errorf("checkpoint of entry %d doesn't match the chain", *fields.CheckpointSeq)
+		}
+		if publicKey != nil {
+			sig, err := base64.StdEncoding.DecodeString(fields.Signature)
+			if err != nil || !ed25519.Verify(publicKey, checkpointMessage(r.LastSeq, r.Head), sig) {
+				return fmt.Errorf("checkpoint signature is invalid")
+			}
+		}
+		r.Checkpoints++
+		r.SignedSeq = r.LastSeq
+		r.unsigned = 0
+		return nil
+	}
+
+	if fields.Hash == "" {
+		if r.Entries > 0 {
+			return fmt.Errorf("entry is not chained")
+		}
+		r.Unchained++
+		return nil
+	}
+
+	// The hash covers the line as it was before the hash was appended
+	suffix := []byte(`,"chain.hash":"` + fields.Hash + `"}`)
+	if !bytes.HasSuffix(line, suffix) {
+		return fmt.Errorf("chain.hash isn't the last field")
+	}
+	body := append(bytes.Clone(line[:len(line)-len(suffix)]), '}')
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != fields.Hash {
+		return fmt.Errorf("entry %d was modified", fields.Seq)
+	}
+
+	switch {
+	case r.Entries == 0:
+		if fields.Seq == 1 && fields.Prev != "" {
+			return fmt.Errorf("first entry has a previous hash")
+		}
+		r.FirstSeq = fields.Seq
+	case fields.Seq != r.LastSeq+1:
+		return fmt.Errorf("entry %d follows entry %d", fields.Seq, r.LastSeq)
+	case fields.Prev != r.Head:
+		return fmt.Errorf("entry %d doesn't follow the entry before it", fields.Seq)
+	}
+	r.Entries++
+	r.LastSeq = fields.Seq
+	r.Head = fields.Hash
+	r.unsigned++
+	return nil
+}
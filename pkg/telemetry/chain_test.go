@@ -0,0 +1,77 @@
+package telemetry
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// chainedLog writes n chained entries, with a checkpoint wherever the
+// chain has one due unless skip lists the sequence number
+func chainedLog(t *testing.T, key ed25519.PrivateKey, n, every int, skip map[uint64]bool) []byte {
+	t.Helper()
+	c, err := newAuditChain(filepath.Join(t.TempDir(), "audit.log"), key, every)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		line, hash, err := c.link(DecisionLog{AgentID: "finance-agent", ToolName: "payments"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(append(line, '\n'))
+		if c.advance(hash) {
+			checkpoint, err := c.checkpoint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !skip[c.seq] {
+				buf.Write(append(checkpoint, '\n'))
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestVerifyAuditLogCheckpoints(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		log     []byte
+		key     ed25519.PublicKey
+		wantErr string
+	}{
+		{"checkpointed", chainedLog(t, private, 5, 2, nil), public, ""},
+		{"no key", chainedLog(t, nil, 5, 2, nil), nil, ""},
+		{"no checkpoints", chainedLog(t, nil, 2, 2, nil), public, "no signed checkpoints"},
+		{"checkpoint missing", chainedLog(t, private, 7, 2, map[uint64]bool{4: true}), public, "more than 2 entries without a checkpoint"},
+		{"tail too long", chainedLog(t, private, 7, 2, map[uint64]bool{6: true}), public, "more than 2 entries without a checkpoint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyAuditLog(bytes.NewReader(tt.log), tt.key, 2)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("got %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("got %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditLogDetectsEdits(t *testing.T) {
+	log := chainedLog(t, nil, 3, 0, nil)
+	edited := bytes.Replace(log, []byte("finance-agent"), []byte("finance-agenT"), 1)
+	if _, err := VerifyAuditLog(bytes.NewReader(edited), nil, 0); err == nil || !strings.Contains(err.Error(), "was modified") {
+		t.Fatalf("got %v, want a modified entry", err)
+	}
+}
@@ -0,0 +1,82 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EmailAuditLog is an entry of the email audit trail: the metadata of a
+// message an agent sent, or tried to, never its body
+type EmailAuditLog struct {
+	Timestamp   string               `json:"timestamp"`
+	RequestID   string               `json:"request.id,omitempty"`
+	AgentID     string               `json:"agent.id"`
+	ToolName    string               `json:"tool.name"`
+	Provider    string               `json:"email.provider"`
+	MessageID   string               `json:"email.message_id"`
+	ProviderID  string               `json:"email.provider_id,omitempty"`
+	From        string               `json:"email.from"`
+	To          []string             `json:"email.to"`
+	Cc          []string             `json:"email.cc,omitempty"`
+	Bcc         []string             `json:"email.bcc,omitempty"`
+	ReplyTo     string               `json:"email.reply_to,omitempty"`
+	Subject     string               `json:"email.subject"`
+	BodyBytes   int                  `json:"email.body_bytes"`
+	Attachments []EmailAttachmentLog `json:"email.attachments,omitempty"`
+
+	// Status is "sent" or "failed"
+	Status    string `json:"email.status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency.ms"`
+	TraceID   string `json:"trace.id"`
+	SpanID    string `json:"span.id"`
+}
+
+// EmailAttachmentLog describes an attached file by its name, type, size and
+// content hash
+type EmailAttachmentLog struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// LogEmail records a sent message on the call's trace and appends it to
+// email-audit.log in the log directory
+func (t *Telemetry) LogEmail(ctx context.Context, entry EmailAuditLog) {
+	_, span := t.tracer.Start(ctx, "tool.email",
+		trace.WithAttributes(withRequestID(ctx, []attribute.KeyValue{
+			attribute.String("agent.id", entry.AgentID),
+			attribute.String("tool.name", entry.ToolName),
+			attribute.String("email.message_id", entry.MessageID),
+			attribute.String("email.status", entry.Status),
+			attribute.Int("email.recipients", len(entry.To)+len(entry.Cc)+len(entry.Bcc)),
+			attribute.Int("email.attachments", len(entry.Attachments)),
+		})...),
+	)
+	defer span.End()
+
+	t.emailLogOnce.Do(func() {
+		t.emailLog, t.emailLogErr = os.OpenFile(filepath.Join(t.logDir, "email-audit.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	})
+	if t.emailLogErr != nil {
+		t.metrics.exporterErrors.WithLabelValues(exporterAuditLog).Inc()
+		logger.Error("Failed to open email audit log", "error", t.emailLogErr)
+		return
+	}
+
+	if entry.Timestamp == "" {
+		entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	entry.RequestID = RequestID(ctx)
+	entry.TraceID = span.SpanContext().TraceID().String()
+	entry.SpanID = span.SpanContext().SpanID().String()
+	logJSON, _ := json.Marshal(entry)
+	t.appendLog(t.emailLog, logJSON)
+}
@@ -0,0 +1,158 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// otlpRetry retries span exports that fail with a network error, 429 or
+// 5xx, waiting 1s and doubling the wait up to 10s, for at most 30s
+var otlpRetry = otlptracehttp.RetryConfig{
+	Enabled:         true,
+	InitialInterval: time.Second,
+	MaxInterval:     10 * time.Second,
+	MaxElapsedTime:  30 * time.Second,
+}
+
+// otlpReconnectBackoff is the wait before creating the OTLP span exporter
+// again after it failed; it doubles after each further failure up to
+// otlpMaxReconnectBackoff
+const (
+	otlpReconnectBackoff    = time.Second
+	otlpMaxReconnectBackoff = time.Minute
+)
+
+// exporterHealth is whether an exporter's latest delivery worked. A nil
+// exporterHealth tracks nothing.
+type exporterHealth struct {
+	mu    sync.Mutex
+	err   error
+	since time.Time
+}
+
+func (h *exporterHealth) succeeded() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.err = nil
+}
+
+func (h *exporterHealth) failed(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err == nil {
+		h.since = time.Now()
+	}
+	h.err = err
+}
+
+// status is ok, or since when the exporter fails and its latest error
+func (h *exporterHealth) status() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err == nil {
+		return "ok"
+	}
+	return fmt.Sprintf("failing since %s: %v", h.since.UTC().Format(time.RFC3339), h.err)
+}
+
+// Health reports the state of each exporter: the OTLP span exporter as
+// otlp_traces, each decision log sink as "sinks[i] type" and the decision
+// store. A state is ok or says since when the exporter fails and why, with
+// the number of entries spooled for the sink, if any.
+func (t *Telemetry) Health() map[string]string {
+	health := make(map[string]string)
+	if t.traces != nil {
+		health["otlp_traces"] = t.traces.health.status()
+	}
+	for i, sink := range t.sinks {
+		state := sink.health.status()
+		if sink.spool != nil {
+			if n := sink.spool.count(); n > 0 {
+				state += fmt.Sprintf("; %d entries spooled", n)
+			}
+		}
+		health[fmt.Sprintf("sinks[%d] %s", i, sink.typ)] = state
+	}
+	if store := t.store.Load(); store != nil {
+		health[sinkDecisionStore] = store.health.status()
+	}
+	return health
+}
+
+// otlpTraceExporter exports spans through the OTLP exporter connect
+// creates. If it can't be created, e.g. because the endpoint is invalid,
+// it is created again with backoff on later exports, and the spans of
+// exports without one are dropped.
+type otlpTraceExporter struct {
+	connect func() (sdktrace.SpanExporter, error)
+	health  exporterHealth
+
+	mu       sync.Mutex
+	exporter sdktrace.SpanExporter
+	backoff  time.Duration
+	retryAt  time.Time
+}
+
+func newOTLPTraceExporter(connect func() (sdktrace.SpanExporter, error)) *otlpTraceExporter {
+	e := &otlpTraceExporter{connect: connect}
+	if _, err := e.current(); err != nil {
+		logger.Warn("Failed to initialize OTLP exporter; retrying", "error", err)
+	}
+	return e
+}
+
+// current returns the exporter, creating it if it's due
+func (e *otlpTraceExporter) current() (sdktrace.SpanExporter, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.exporter != nil {
+		return e.exporter, nil
+	}
+	if time.Now().Before(e.retryAt) {
+		return nil, fmt.Errorf("OTLP exporter unavailable; retrying at %s", e.retryAt.UTC().Format(time.RFC3339))
+	}
+	exporter, err := e.connect()
+	if err != nil {
+		e.backoff = min(max(2*e.backoff, otlpReconnectBackoff), otlpMaxReconnectBackoff)
+		e.retryAt = time.Now().Add(e.backoff)
+		e.health.failed(err)
+		return nil, err
+	}
+	e.exporter, e.backoff = exporter, 0
+	return exporter, nil
+}
+
+func (e *otlpTraceExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	exporter, err := e.current()
+	if err != nil {
+		e.health.failed(err)
+		return fmt.Errorf("%d spans dropped: %w", len(spans), err)
+	}
+	if err := exporter.ExportSpans(ctx, spans); err != nil {
+		e.health.failed(err)
+		return err
+	}
+	e.health.succeeded()
+	return nil
+}
+
+func (e *otlpTraceExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	exporter := e.exporter
+	e.mu.Unlock()
+	if exporter == nil {
+		return nil
+	}
+	return exporter.Shutdown(ctx)
+}
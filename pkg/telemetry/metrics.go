@@ -0,0 +1,227 @@
+package telemetry
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+)
+
+// Exporters counted by aegis_exporter_errors_total
+const (
+	exporterOTLP     = "otlp"
+	exporterAuditLog = "audit_log"
+)
+
+// metrics holds the Prometheus collectors served on /metrics
+type metrics struct {
+	registry *prometheus.Registry
+
+	decisions         *prometheus.CounterVec
+	denials           *prometheus.CounterVec
+	evaluation        *prometheus.HistogramVec
+	upstreamDuration  *prometheus.HistogramVec
+	upstreamResponses *prometheus.CounterVec
+	inFlight          prometheus.Gauge
+	policyReloads     *prometheus.CounterVec
+	exporterErrors    *prometheus.CounterVec
+	decisionsDropped  prometheus.Counter
+	anomalies         *prometheus.CounterVec
+
+	// otlp is nil unless metrics are pushed over OTLP too
+	otlp *otlpMetrics
+}
+
+// newMetrics creates the gateway's collectors in a registry of their own,
+// along with the Go runtime and process collectors
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aegis_decisions_total",
+			Help: "Policy decisions by agent, tool and result (allow or deny).",
+		}, []string{"agent", "tool", "result"}),
+		denials: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aegis_denials_total",
+			Help: "Denied calls by tool and deny code, e.g. the condition that failed.",
+		}, []string{"tool", "code"}),
+		evaluation: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "aegis_policy_evaluation_seconds",
+			Help:    "Time the policy engine took to decide a call.",
+			Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+		}, []string{"tool"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "aegis_upstream_duration_seconds",
+			Help:    "Time tools took to answer forwarded calls.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"tool", "target"}),
+		upstreamResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aegis_upstream_responses_total",
+			Help: `Forwarded calls by tool and HTTP status, or "error" when the tool couldn't be reached.`,
+		}, []string{"tool", "code"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "aegis_requests_in_flight",
+			Help: "Requests the agent listener is serving.",
+		}),
+		policyReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aegis_policy_reloads_total",
+			Help: "Policy file reloads by result (success or error).",
+		}, []string{"result"}),
+		exporterErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aegis_exporter_errors_total",
+			Help: "Failures to export spans (otlp), write audit logs (audit_log) or deliver decision log entries (by sink type).",
+		}, []string{"exporter"}),
+		decisionsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "aegis_decision_log_dropped_total",
+			Help: "Decisions dropped because the decision log queue was full.",
+		}),
+		anomalies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "aegis_anomalies_total",
+			Help: "Unusual agent behavior flagged by anomaly detection, by agent and type.",
+		}, []string{"agent", "type"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.decisions, m.denials, m.evaluation, m.upstreamDuration, m.upstreamResponses,
+		m.inFlight, m.policyReloads, m.exporterErrors, m.decisionsDropped, m.anomalies,
+	)
+	// Both exporters show up at zero before they first fail
+	m.exporterErrors.WithLabelValues(exporterOTLP)
+	m.exporterErrors.WithLabelValues(exporterAuditLog)
+	return m
+}
+
+// countExporterErrors counts OpenTelemetry errors, which are almost all
+// failed span exports, and still prints them as the default handler does
+func (m *metrics) countExporterErrors() {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		m.exporterErrors.WithLabelValues(exporterOTLP).Inc()
+		logger.Error("OpenTelemetry error", "error", err)
+	}))
+}
+
+// watchDecisionQueue reports how many decisions are waiting in q
+func (m *metrics) watchDecisionQueue(q *decisionQueue) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "aegis_decision_log_queue_depth",
+			Help: "Decisions waiting to be written to the decision log sinks.",
+		}, func() float64 { return float64(q.depth()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "aegis_decision_log_queue_capacity",
+			Help: "Decisions the decision log queue holds before it blocks or drops (telemetry.queue).",
+		}, func() float64 { return float64(cap(q.entries)) }),
+	)
+}
+
+// watchSpools reports how many entries are spooled for each sink with a
+// spool
+func (m *metrics) watchSpools(sinks []typedSink) {
+	for i, sink := range sinks {
+		if sink.spool == nil {
+			continue
+		}
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "aegis_decision_log_spooled",
+			Help:        "Decision log entries spooled on disk for a sink (telemetry.spool).",
+			ConstLabels: prometheus.Labels{"sink": sink.typ, "index": strconv.Itoa(i)},
+		}, func() float64 { return float64(sink.spool.count()) }))
+	}
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format
+func (t *Telemetry) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(t.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// TrackInFlight counts the requests next is serving
+func (t *Telemetry) TrackInFlight(next http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerInFlight(t.metrics.inFlight, next)
+}
+
+// RecordPolicyReload counts a policy file reload, failed if err isn't nil
+func (t *Telemetry) RecordPolicyReload(err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	t.metrics.policyReloads.WithLabelValues(result).Inc()
+	if t.metrics.otlp != nil {
+		t.metrics.otlp.recordPolicyReload(result)
+	}
+}
+
+// OtherLabel is the agent or tool label of decisions and anomalies whose
+// agent wasn't verified or whose tool isn't configured
+const OtherLabel = "other"
+
+// metricLabel returns value as a label if it is known to be bounded, and
+// OtherLabel if not
+func metricLabel(value string, known bool) string {
+	if !known {
+		return OtherLabel
+	}
+	return value
+}
+
+// recordDecision counts a decision and, for calls the engine decided,
+// observes how long it took
+func (m *metrics) recordDecision(d Decision) {
+	d.AgentID = metricLabel(d.AgentID, d.AgentVerified)
+	d.Tool = metricLabel(d.Tool, d.ToolRegistered)
+	result := "deny"
+	if d.Allowed {
+		result = "allow"
+	}
+	m.decisions.WithLabelValues(d.AgentID, d.Tool, result).Inc()
+	if !d.Allowed {
+		m.denials.WithLabelValues(d.Tool, d.Code).Inc()
+	}
+	if d.Evaluation > 0 {
+		m.evaluation.WithLabelValues(d.Tool).Observe(d.Evaluation.Seconds())
+	}
+	if m.otlp != nil {
+		m.otlp.recordDecision(d, result)
+	}
+}
+
+// recordForwardedCall observes a call's upstream latency and status
+func (m *metrics) recordForwardedCall(tool, target string, status int, latency time.Duration) {
+	m.upstreamDuration.WithLabelValues(tool, target).Observe(latency.Seconds())
+	code := "error"
+	if status > 0 {
+		code = strconv.Itoa(status)
+	}
+	m.upstreamResponses.WithLabelValues(tool, code).Inc()
+	if m.otlp != nil {
+		m.otlp.recordForwardedCall(tool, target, status, latency)
+	}
+}
+
+// recordAnomaly counts a flagged anomaly
+func (m *metrics) recordAnomaly(agentID, typ string) {
+	m.anomalies.WithLabelValues(agentID, typ).Inc()
+	if m.otlp != nil {
+		m.otlp.recordAnomaly(agentID, typ)
+	}
+}
+
+// sinkError counts and logs entries a decision log sink failed to deliver
+func (m *metrics) sinkError(sink string, err error) {
+	m.exporterErrors.WithLabelValues(sink).Inc()
+	logger.Error("Failed to deliver decision log entries", "sink", sink, "error", err)
+}
+
+// appendLog writes a JSON entry to an audit log, counting failed writes
+func (t *Telemetry) appendLog(f *os.File, entry []byte) {
+	if _, err := f.Write(append(entry, '\n')); err != nil {
+		t.metrics.exporterErrors.WithLabelValues(exporterAuditLog).Inc()
+		logger.Error("Failed to write audit log", "file", f.Name(), "error", err)
+	}
+}
@@ -0,0 +1,139 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// DefaultOTLPMetricsInterval is how often metrics are pushed when
+// telemetry.otlp_metrics_interval isn't set
+const DefaultOTLPMetricsInterval = time.Minute
+
+// otlpMetrics pushes the gateway's metrics to the OTLP endpoint alongside
+// the Prometheus ones
+type otlpMetrics struct {
+	provider *sdkmetric.MeterProvider
+
+	decisions         metric.Int64Counter
+	denials           metric.Int64Counter
+	evaluation        metric.Float64Histogram
+	upstreamDuration  metric.Float64Histogram
+	upstreamResponses metric.Int64Counter
+	upstreamErrors    metric.Int64Counter
+	policyReloads     metric.Int64Counter
+	anomalies         metric.Int64Counter
+}
+
+// newOTLPMetrics creates the meter provider and instruments and installs
+// the provider as the global one
+func newOTLPMetrics(cfg Config, res *resource.Resource) (*otlpMetrics, error) {
+	opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(cfg.OTLPEndpoint)}
+	if cfg.OTLPInsecure {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	}
+	exporter, err := otlpmetrichttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	interval := cfg.OTLPMetricsInterval
+	if interval == 0 {
+		interval = DefaultOTLPMetricsInterval
+	}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))),
+		sdkmetric.WithResource(res),
+	)
+	meter := provider.Meter(cfg.ServiceName)
+
+	m := &otlpMetrics{provider: provider}
+	if m.decisions, err = meter.Int64Counter("aegis.decisions",
+		metric.WithDescription("Policy decisions by agent, tool and result (allow or deny).")); err != nil {
+		return nil, err
+	}
+	if m.denials, err = meter.Int64Counter("aegis.denials",
+		metric.WithDescription("Denied calls by tool and deny code.")); err != nil {
+		return nil, err
+	}
+	if m.evaluation, err = meter.Float64Histogram("aegis.policy.evaluation.duration",
+		metric.WithDescription("Time the policy engine took to decide a call."), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if m.upstreamDuration, err = meter.Float64Histogram("aegis.upstream.duration",
+		metric.WithDescription("Time tools took to answer forwarded calls."), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if m.upstreamResponses, err = meter.Int64Counter("aegis.upstream.responses",
+		metric.WithDescription(`Forwarded calls by tool and HTTP status, or "error" when the tool couldn't be reached.`)); err != nil {
+		return nil, err
+	}
+	if m.upstreamErrors, err = meter.Int64Counter("aegis.upstream.errors",
+		metric.WithDescription("Forwarded calls that failed with a 5xx status or couldn't reach the tool.")); err != nil {
+		return nil, err
+	}
+	if m.policyReloads, err = meter.Int64Counter("aegis.policy.reloads",
+		metric.WithDescription("Policy file reloads by result (success or error).")); err != nil {
+		return nil, err
+	}
+	if m.anomalies, err = meter.Int64Counter("aegis.anomalies",
+		metric.WithDescription("Unusual agent behavior flagged by anomaly detection, by agent and type.")); err != nil {
+		return nil, err
+	}
+	otel.SetMeterProvider(provider)
+	return m, nil
+}
+
+func (m *otlpMetrics) recordDecision(d Decision, result string) {
+	ctx := context.Background()
+	m.decisions.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("agent", d.AgentID),
+		attribute.String("tool", d.Tool),
+		attribute.String("result", result),
+	))
+	if !d.Allowed {
+		m.denials.Add(ctx, 1, metric.WithAttributes(attribute.String("tool", d.Tool), attribute.String("code", d.Code)))
+	}
+	if d.Evaluation > 0 {
+		m.evaluation.Record(ctx, d.Evaluation.Seconds(), metric.WithAttributes(attribute.String("tool", d.Tool)))
+	}
+}
+
+func (m *otlpMetrics) recordForwardedCall(tool, target string, status int, latency time.Duration) {
+	ctx := context.Background()
+	m.upstreamDuration.Record(ctx, latency.Seconds(), metric.WithAttributes(
+		attribute.String("tool", tool),
+		attribute.String("target", target),
+	))
+	code := "error"
+	if status > 0 {
+		code = strconv.Itoa(status)
+	}
+	m.upstreamResponses.Add(ctx, 1, metric.WithAttributes(attribute.String("tool", tool), attribute.String("code", code)))
+	if status == 0 || status >= 500 {
+		m.upstreamErrors.Add(ctx, 1, metric.WithAttributes(attribute.String("tool", tool), attribute.String("target", target)))
+	}
+}
+
+func (m *otlpMetrics) recordPolicyReload(result string) {
+	m.policyReloads.Add(context.Background(), 1, metric.WithAttributes(attribute.String("result", result)))
+}
+
+func (m *otlpMetrics) recordAnomaly(agentID, typ string) {
+	m.anomalies.Add(context.Background(), 1, metric.WithAttributes(attribute.String("agent", agentID), attribute.String("type", typ)))
+}
+
+// shutdown pushes what hasn't been exported yet and stops the reader
+func (m *otlpMetrics) shutdown(ctx context.Context) error {
+	if err := m.provider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to flush OTLP metrics: %w", err)
+	}
+	return nil
+}
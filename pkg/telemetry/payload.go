@@ -0,0 +1,131 @@
+package telemetry
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// payloadSealer encrypts call payloads to an operator's X25519 public key.
+// Each payload is sealed with a new ephemeral key, so only the holder of the
+// private key can open it and payloads can't be linked to each other.
+type payloadSealer struct {
+	key   *ecdh.PublicKey
+	keyID string
+}
+
+func newPayloadSealer(value string) (*payloadSealer, error) {
+	key, err := ParsePayloadKey(value)
+	if err != nil {
+		return nil, err
+	}
+	return &payloadSealer{key: key, keyID: keyID(key.Bytes())}, nil
+}
+
+// seal encrypts the JSON of payload. The result is the ephemeral public
+// key, the nonce and the AES-256-GCM ciphertext, in base64.
+func (s *payloadSealer) seal(payload interface{}) (string, error) {
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := ephemeral.ECDH(s.key)
+	if err != nil {
+		return "", err
+	}
+	aead, err := payloadCipher(shared, ephemeral.PublicKey(), s.key)
+	if err != nil {
+		return "", err
+	}
+	sealed := append([]byte(nil), ephemeral.PublicKey().Bytes()...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed = append(sealed, nonce...)
+	sealed = aead.Seal(sealed, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenPayload decrypts the payload.sealed field of a decision record with
+// the operator's private key and returns the payload's JSON
+func OpenPayload(sealed string, key *ecdh.PrivateKey) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("sealed payload is not base64")
+	}
+	const keySize = 32
+	if len(raw) < keySize {
+		return nil, fmt.Errorf("sealed payload is too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(raw[:keySize])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := payloadCipher(shared, ephemeral, key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	raw = raw[keySize:]
+	if len(raw) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed payload is too short")
+	}
+	plaintext, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("payload can't be decrypted with this key")
+	}
+	return plaintext, nil
+}
+
+// payloadCipher derives the AES-256-GCM key of a payload from the X25519
+// shared secret, bound to the ephemeral and recipient public keys
+func payloadCipher(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write(shared)
+	h.Write(ephemeral.Bytes())
+	h.Write(recipient.Bytes())
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ParsePayloadKey parses a base64 X25519 public key
+func ParsePayloadKey(value string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("payload key is not base64: %w", err)
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("payload key must be a 32 byte X25519 public key")
+	}
+	return key, nil
+}
+
+// ParsePayloadPrivateKey parses a base64 X25519 private key
+func ParsePayloadPrivateKey(value string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("private key is not base64: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("private key must be a 32 byte X25519 key")
+	}
+	return key, nil
+}
@@ -0,0 +1,40 @@
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// propagator reads and writes W3C traceparent, tracestate and baggage
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Extract returns a context carrying the trace context and baggage a caller
+// sent, for use as the parent of the gateway's spans. It is not tied to the
+// caller's request, so work that outlives the request keeps its parent.
+func Extract(carrier propagation.TextMapCarrier) context.Context {
+	return propagator.Extract(context.Background(), carrier)
+}
+
+// Inject writes the trace context and baggage of ctx to carrier, so the
+// tool's spans join the agent's trace
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	propagator.Inject(ctx, carrier)
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the ID of the request being served.
+// Spans and log entries recorded under it are tagged with request.id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,129 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// What the decision log does when its queue is full
+const (
+	QueueBlock = "block"
+	QueueDrop  = "drop"
+)
+
+// DefaultDecisionQueueSize bounds the decisions waiting to be written when
+// telemetry.queue.size isn't set
+const DefaultDecisionQueueSize = 8192
+
+// QueueConfig sizes the queue decisions wait in between the request that
+// made them and the sinks
+type QueueConfig struct {
+	// Size bounds the waiting decisions; defaults to
+	// DefaultDecisionQueueSize
+	Size int `yaml:"size,omitempty"`
+
+	// OnFull is block (default), which holds calls until there is room so
+	// no decision is lost, or drop, which drops and counts the decision
+	// so calls never wait on the sinks
+	OnFull string `yaml:"on_full,omitempty"`
+}
+
+func (c QueueConfig) validate() error {
+	if c.Size < 0 {
+		return fmt.Errorf("telemetry.queue.size must not be negative")
+	}
+	switch c.OnFull {
+	case "", QueueBlock, QueueDrop:
+		return nil
+	}
+	return fmt.Errorf("telemetry.queue.on_full must be block or drop")
+}
+
+// decisionQueue hands decisions to a goroutine that writes whatever has
+// queued up as one batch, keeping file writes off the request path
+type decisionQueue struct {
+	entries chan DecisionLog
+	done    chan struct{}
+	drop    bool
+
+	// mu keeps push from sending on the channel once close closed it
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newDecisionQueue(cfg QueueConfig, write func([]DecisionLog)) *decisionQueue {
+	size := cfg.Size
+	if size == 0 {
+		size = DefaultDecisionQueueSize
+	}
+	q := &decisionQueue{
+		entries: make(chan DecisionLog, size),
+		done:    make(chan struct{}),
+		drop:    cfg.OnFull == QueueDrop,
+	}
+	go q.run(write)
+	return q
+}
+
+// push queues an entry. It reports false if the entry was dropped because
+// the queue is full or closed.
+func (q *decisionQueue) push(entry DecisionLog) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	if !q.drop {
+		q.entries <- entry
+		return true
+	}
+	select {
+	case q.entries <- entry:
+		return true
+	default:
+		return false
+	}
+}
+
+// depth is the number of entries waiting
+func (q *decisionQueue) depth() int {
+	return len(q.entries)
+}
+
+func (q *decisionQueue) run(write func([]DecisionLog)) {
+	defer close(q.done)
+	for entry := range q.entries {
+		batch := []DecisionLog{entry}
+	collect:
+		for len(batch) < maxSinkBatch {
+			select {
+			case next, ok := <-q.entries:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+		write(batch)
+	}
+}
+
+// close writes the queued entries and stops the goroutine. It gives up
+// waiting when ctx ends, leaving the goroutine to write the rest.
+func (q *decisionQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.entries)
+	}
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,48 @@
+package telemetry
+
+import "sync"
+
+// recentDecisionLimit bounds the decisions kept in memory
+const recentDecisionLimit = 1000
+
+// recentDecisions is a ring buffer of the latest decision log entries
+type recentDecisions struct {
+	mu      sync.Mutex
+	entries []DecisionLog
+	next    int
+}
+
+// add records an entry, overwriting the oldest once the buffer is full
+func (r *recentDecisions) add(entry DecisionLog) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.entries) < recentDecisionLimit {
+		r.entries = append(r.entries, entry)
+		return
+	}
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % recentDecisionLimit
+}
+
+// RecentDecisions returns up to limit of the latest decision log entries,
+// newest first. A nil match returns every entry.
+func (t *Telemetry) RecentDecisions(limit int, match func(DecisionLog) bool) []DecisionLog {
+	if limit <= 0 {
+		return nil
+	}
+
+	r := &t.recent
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]DecisionLog, 0, min(limit, len(r.entries)))
+	for i := 0; i < len(r.entries) && len(out) < limit; i++ {
+		// Walk back from the most recently written slot
+		idx := (r.next - 1 - i + 2*len(r.entries)) % len(r.entries)
+		if match == nil || match(r.entries[idx]) {
+			out = append(out, r.entries[idx])
+		}
+	}
+	return out
+}
@@ -0,0 +1,155 @@
+package telemetry
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Samplers
+const (
+	SamplerAlwaysOn    = "always_on"
+	SamplerAlwaysOff   = "always_off"
+	SamplerRatio       = "ratio"
+	SamplerParentBased = "parent_based"
+)
+
+// SamplingConfig chooses which traces the gateway exports. The choice is
+// made on the gateway.request span, once the call is decided; the spans
+// under it follow it.
+type SamplingConfig struct {
+	// Sampler is always_on (default), always_off, ratio, which samples
+	// Ratio of calls whatever the agent sampled, or parent_based, which
+	// follows the agent's sampled flag and samples Ratio of calls that
+	// come without one (all of them if Ratio isn't set)
+	Sampler string  `yaml:"sampler,omitempty"`
+	Ratio   float64 `yaml:"ratio,omitempty"`
+
+	// AlwaysSampleDenials samples every denied call, whatever the sampler
+	AlwaysSampleDenials bool `yaml:"always_sample_denials,omitempty"`
+
+	// Routes override the sampler for some tools or actions; the first
+	// that matches applies
+	Routes []SamplingRoute `yaml:"routes,omitempty"`
+}
+
+// SamplingRoute samples Ratio (0 to 1) of the calls to Tool, or to one
+// of its actions if Action is set
+type SamplingRoute struct {
+	Tool   string  `yaml:"tool"`
+	Action string  `yaml:"action,omitempty"`
+	Ratio  float64 `yaml:"ratio"`
+}
+
+func (c SamplingConfig) validate() error {
+	switch c.Sampler {
+	case "", SamplerAlwaysOn, SamplerAlwaysOff:
+	case SamplerRatio:
+		if c.Ratio <= 0 || c.Ratio > 1 {
+			return fmt.Errorf("telemetry.sampling.ratio must be greater than 0 and at most 1")
+		}
+	case SamplerParentBased:
+		if c.Ratio < 0 || c.Ratio > 1 {
+			return fmt.Errorf("telemetry.sampling.ratio must be between 0 and 1")
+		}
+	default:
+		return fmt.Errorf("telemetry.sampling.sampler must be always_on, always_off, ratio or parent_based")
+	}
+	for i, r := range c.Routes {
+		if r.Tool == "" {
+			return fmt.Errorf("telemetry.sampling.routes[%d] requires a tool", i)
+		}
+		if r.Ratio < 0 || r.Ratio > 1 {
+			return fmt.Errorf("telemetry.sampling.routes[%d].ratio must be between 0 and 1", i)
+		}
+	}
+	return nil
+}
+
+// gatewaySampler applies a SamplingConfig. Spans under one of the
+// gateway's own spans follow it, so a call's tree is kept or dropped as a
+// whole.
+type gatewaySampler struct {
+	root        sdktrace.Sampler
+	parentBased bool
+	denials     bool
+	routes      []routeSampler
+}
+
+type routeSampler struct {
+	tool, action string
+	sampler      sdktrace.Sampler
+}
+
+func newSampler(cfg SamplingConfig) sdktrace.Sampler {
+	s := &gatewaySampler{
+		root:        sdktrace.AlwaysSample(),
+		parentBased: cfg.Sampler == SamplerParentBased,
+		denials:     cfg.AlwaysSampleDenials,
+	}
+	switch cfg.Sampler {
+	case SamplerAlwaysOff:
+		s.root = sdktrace.NeverSample()
+	case SamplerRatio:
+		s.root = sdktrace.TraceIDRatioBased(cfg.Ratio)
+	case SamplerParentBased:
+		if cfg.Ratio > 0 {
+			s.root = sdktrace.TraceIDRatioBased(cfg.Ratio)
+		}
+	}
+	for _, r := range cfg.Routes {
+		s.routes = append(s.routes, routeSampler{tool: r.Tool, action: r.Action, sampler: sdktrace.TraceIDRatioBased(r.Ratio)})
+	}
+	return s
+}
+
+func (s *gatewaySampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	parent := trace.SpanContextFromContext(p.ParentContext)
+	override := s.override(p.Attributes)
+	switch {
+	case parent.IsValid() && !parent.IsRemote():
+		return followParent(parent, p)
+	case override != nil:
+		return override.ShouldSample(p)
+	case parent.IsValid() && s.parentBased:
+		return followParent(parent, p)
+	}
+	return s.root.ShouldSample(p)
+}
+
+// override returns the sampler a denial or a route calls for, or nil
+func (s *gatewaySampler) override(attrs []attribute.KeyValue) sdktrace.Sampler {
+	var tool, action string
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "decision.allow":
+			if s.denials && attr.Value.Type() == attribute.BOOL && !attr.Value.AsBool() {
+				return sdktrace.AlwaysSample()
+			}
+		case "tool.name":
+			tool = attr.Value.AsString()
+		case "tool.action":
+			action = attr.Value.AsString()
+		}
+	}
+	for _, r := range s.routes {
+		if r.tool == tool && (r.action == "" || r.action == action) {
+			return r.sampler
+		}
+	}
+	return nil
+}
+
+// followParent samples a span if its parent was sampled
+func followParent(parent trace.SpanContext, p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if parent.IsSampled() {
+		return sdktrace.AlwaysSample().ShouldSample(p)
+	}
+	return sdktrace.NeverSample().ShouldSample(p)
+}
+
+func (s *gatewaySampler) Description() string {
+	return "AegisGatewaySampler"
+}
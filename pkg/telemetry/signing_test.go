@@ -0,0 +1,42 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+)
+
+// Decisions served by the admin API carry the same signature as the ones
+// written to the sinks
+func TestRecentDecisionsAreSigned(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tel, err := NewTelemetryWithConfig(Config{ServiceName: "aegis-test", LogDir: t.TempDir(), OTLPEndpoint: "127.0.0.1:1", OTLPInsecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tel.SetRecordSigner(private)
+	tel.LogDecision(context.Background(), Decision{AgentID: "finance-agent", Tool: "payments", Action: "create", Allowed: true})
+	if err := tel.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recent := tel.RecentDecisions(10, nil)
+	if len(recent) != 1 {
+		t.Fatalf("got %d recent decisions, want 1", len(recent))
+	}
+	if recent[0].Signature == "" || recent[0].SignatureKey != KeyID(public) {
+		t.Fatalf("recent decision is not signed: %+v", recent[0])
+	}
+	record, err := json.Marshal(recent[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRecord(record, []ed25519.PublicKey{public}); err != nil {
+		t.Errorf("recent decision doesn't verify: %v", err)
+	}
+}
@@ -0,0 +1,753 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Sink types
+const (
+	SinkFile    = "file"
+	SinkStdout  = "stdout"
+	SinkSyslog  = "syslog"
+	SinkWebhook = "webhook"
+	SinkKafka   = "kafka"
+	SinkSplunk  = "splunk"
+	SinkElastic = "elasticsearch"
+)
+
+// Sink receives decision log entries. Sinks that reach other services
+// queue entries and deliver them in the background, so Write doesn't wait
+// on the network.
+type Sink interface {
+	Write(entry DecisionLog) error
+	Close() error
+}
+
+// SinkConfig configures one destination of the decision log
+type SinkConfig struct {
+	// Type is file, stdout, syslog, webhook, kafka, splunk or elasticsearch
+	Type string `yaml:"type"`
+
+	// Path is the file a file sink appends to, by default aegis.log in the
+	// log directory
+	Path string `yaml:"path,omitempty"`
+
+	// Chain links each entry of a file sink to the one before it by hash,
+	// so edits to the file can be detected
+	Chain bool `yaml:"chain,omitempty"`
+
+	// SigningKey is a reference such as env:AEGIS_AUDIT_KEY to a base64
+	// Ed25519 private key. A chained file sink with a key writes a signed
+	// checkpoint every CheckpointEvery entries (DefaultCheckpointEvery by
+	// default) and on shutdown.
+	SigningKey      string `yaml:"signing_key,omitempty"`
+	CheckpointEvery int    `yaml:"checkpoint_every,omitempty"`
+
+	// Network and Address locate the syslog server, e.g. udp and
+	// localhost:514. Both empty use the local syslog daemon.
+	Network string `yaml:"network,omitempty"`
+	Address string `yaml:"address,omitempty"`
+
+	// Tag is the syslog tag, by default the service name
+	Tag string `yaml:"tag,omitempty"`
+
+	// URL is where a webhook sink POSTs batches of entries as a JSON array.
+	// For splunk it's the HTTP Event Collector endpoint, e.g.
+	// https://splunk:8088/services/collector/event, and for elasticsearch
+	// the cluster address, which the bulk API is called on.
+	URL string `yaml:"url,omitempty"`
+
+	// Headers are sent with each webhook, splunk or elasticsearch request.
+	// Values may be references such as env:AUDIT_WEBHOOK_TOKEN.
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Token is the Splunk HEC token or the Elasticsearch API key, usually
+	// a reference such as env:SPLUNK_HEC_TOKEN
+	Token string `yaml:"token,omitempty"`
+
+	// Index is the Splunk index, by default the token's, or the
+	// Elasticsearch index or data stream, by default aegis-decisions
+	Index string `yaml:"index,omitempty"`
+
+	// Timeout bounds each HTTP request or Kafka write; defaults to
+	// DefaultSinkTimeout
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// Retries is the number of extra attempts after a delivery fails with
+	// a network error, 429 or 5xx; defaults to DefaultSinkRetries
+	Retries int `yaml:"retries,omitempty"`
+
+	// QueueSize bounds the entries waiting for delivery; defaults to
+	// DefaultSinkQueueSize
+	QueueSize int `yaml:"queue_size,omitempty"`
+
+	// Brokers and Topic are where a kafka sink produces entries, keyed by
+	// agent ID
+	Brokers []string `yaml:"brokers,omitempty"`
+	Topic   string   `yaml:"topic,omitempty"`
+}
+
+// DefaultSinkTimeout applies to webhook and kafka sinks without a timeout
+const DefaultSinkTimeout = 5 * time.Second
+
+// DefaultSinkRetries applies to background sinks without retries
+const DefaultSinkRetries = 3
+
+// DefaultSinkQueueSize bounds the entries waiting for a background sink.
+// Entries beyond it are dropped and counted as exporter errors.
+const DefaultSinkQueueSize = 4096
+
+// DefaultElasticIndex is the index elasticsearch sinks write to by default
+const DefaultElasticIndex = "aegis-decisions"
+
+// maxSinkBatch bounds the entries one delivery carries
+const maxSinkBatch = 500
+
+// sinkRetryBackoff is the wait before the first retry; it doubles after
+// each further failure
+const sinkRetryBackoff = 500 * time.Millisecond
+
+// defaultSinks keeps the decision log in aegis.log and on stdout
+var defaultSinks = []SinkConfig{{Type: SinkFile}, {Type: SinkStdout}}
+
+// Validate checks the decision log sinks, store, queue and sampling and
+// the OTLP metrics interval
+func (c Config) Validate() error {
+	if err := c.Store.validate(); err != nil {
+		return err
+	}
+	if err := c.Queue.validate(); err != nil {
+		return err
+	}
+	if err := c.Sampling.validate(); err != nil {
+		return err
+	}
+	if err := c.Spool.validate(); err != nil {
+		return err
+	}
+	if c.PayloadKey != "" {
+		if _, err := ParsePayloadKey(c.PayloadKey); err != nil {
+			return fmt.Errorf("telemetry.payload_key: %w", err)
+		}
+	}
+	if c.OTLPMetricsInterval < 0 {
+		return fmt.Errorf("telemetry.otlp_metrics_interval must not be negative")
+	}
+	for i, s := range c.Sinks {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("telemetry.sinks[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (s SinkConfig) validate() error {
+	if s.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if s.Retries < 0 {
+		return fmt.Errorf("retries must not be negative")
+	}
+	if s.QueueSize < 0 {
+		return fmt.Errorf("queue_size must not be negative")
+	}
+	if (s.Chain || s.SigningKey != "" || s.CheckpointEvery != 0) && s.Type != SinkFile {
+		return fmt.Errorf("chain, signing_key and checkpoint_every apply to file sinks")
+	}
+	if (s.SigningKey != "" || s.CheckpointEvery != 0) && !s.Chain {
+		return fmt.Errorf("signing_key and checkpoint_every require chain")
+	}
+	if s.SigningKey != "" && !strings.HasPrefix(s.SigningKey, "env:") {
+		return fmt.Errorf("signing_key must be a reference such as env:NAME")
+	}
+	if s.CheckpointEvery < 0 {
+		return fmt.Errorf("checkpoint_every must not be negative")
+	}
+	switch s.Type {
+	case SinkFile, SinkStdout:
+	case SinkSyslog:
+		switch s.Network {
+		case "":
+			if s.Address != "" {
+				return fmt.Errorf("syslog address requires a network")
+			}
+		case "udp", "tcp", "unix", "unixgram":
+			if s.Address == "" {
+				return fmt.Errorf("syslog network %s requires an address", s.Network)
+			}
+		default:
+			return fmt.Errorf("syslog network must be udp, tcp, unix or unixgram")
+		}
+	case SinkWebhook, SinkSplunk, SinkElastic:
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s requires an http or https url", s.Type)
+		}
+		if s.Type == SinkSplunk && s.Token == "" {
+			return fmt.Errorf("splunk requires a token")
+		}
+	case SinkKafka:
+		if len(s.Brokers) == 0 || s.Topic == "" {
+			return fmt.Errorf("kafka requires brokers and a topic")
+		}
+	default:
+		return fmt.Errorf("type must be file, stdout, syslog, webhook, kafka, splunk or elasticsearch")
+	}
+	return nil
+}
+
+// batchWriter is a sink that writes several entries at once more cheaply
+// than one at a time
+type batchWriter interface {
+	WriteBatch(batch []DecisionLog) error
+}
+
+// writeBatch hands a batch to a sink, in one call if it takes batches
+func writeBatch(sink Sink, batch []DecisionLog) error {
+	if w, ok := sink.(batchWriter); ok {
+		return w.WriteBatch(batch)
+	}
+	var errs []error
+	for _, entry := range batch {
+		if err := sink.Write(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// typedSink is an open sink and its type, which errors are reported under
+type typedSink struct {
+	Sink
+	typ string
+
+	// health is the sink's latest delivery, and spool is nil unless the
+	// sink delivers in the background and telemetry.spool.dir is set
+	health *exporterHealth
+	spool  *spool
+}
+
+// background reports whether the sink delivers entries after Write
+// returned, so a Write without error isn't a delivery
+func (s typedSink) background() bool {
+	return s.typ != SinkFile && s.typ != SinkStdout
+}
+
+// openSinks creates the configured sinks, or the default ones. onError is
+// told about entries a sink failed to deliver in the background.
+func openSinks(cfg Config, onError func(sink string, err error)) ([]typedSink, error) {
+	configs := cfg.Sinks
+	if len(configs) == 0 {
+		configs = defaultSinks
+	}
+	var sinks []typedSink
+	for i, c := range configs {
+		sinkType := c.Type
+		typed := typedSink{typ: c.Type, health: &exporterHealth{}}
+		queue := queueConfig{onError: func(err error) { onError(sinkType, err) }, health: typed.health}
+		sink, err := openSink(c, cfg, queue, i)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, fmt.Errorf("failed to open %s decision log sink: %w", c.Type, err)
+		}
+		typed.Sink = sink
+		if q, ok := sink.(*queuedSink); ok {
+			typed.spool = q.spool
+		}
+		sinks = append(sinks, typed)
+	}
+	return sinks, nil
+}
+
+// openSink creates the sink at index. Sinks that deliver in the background
+// get a queue with the given error and health reporting.
+func openSink(c SinkConfig, cfg Config, queue queueConfig, index int) (Sink, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultSinkTimeout
+	}
+	queue.size, queue.retries = c.QueueSize, c.Retries
+	if queue.size == 0 {
+		queue.size = DefaultSinkQueueSize
+	}
+	if queue.retries == 0 {
+		queue.retries = DefaultSinkRetries
+	}
+	switch c.Type {
+	case SinkSyslog, SinkWebhook, SinkSplunk, SinkElastic:
+		if cfg.Spool.Dir != "" {
+			var err error
+			if queue.spool, err = openSpool(cfg.Spool, index, c.Type); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch c.Type {
+	case SinkFile:
+		path := c.Path
+		if path == "" {
+			path = filepath.Join(cfg.LogDir, "aegis.log")
+		}
+		chain, err := openChain(c, path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &fileSink{f: f, chain: chain}, nil
+	case SinkStdout:
+		return &fileSink{f: os.Stdout, shared: true}, nil
+	case SinkSyslog:
+		tag := c.Tag
+		if tag == "" {
+			tag = cfg.ServiceName
+		}
+		return newSyslogSink(c.Network, c.Address, tag, queue)
+	case SinkWebhook:
+		w := newHTTPSink(c.URL, c.Headers, timeout)
+		return newQueuedSink(w.postJSON, queue), nil
+	case SinkSplunk:
+		return newSplunkSink(c, cfg.ServiceName, timeout, queue), nil
+	case SinkElastic:
+		return newElasticSink(c, timeout, queue), nil
+	case SinkKafka:
+		return &kafkaSink{writer: &kafka.Writer{
+			Addr:         kafka.TCP(c.Brokers...),
+			Topic:        c.Topic,
+			Balancer:     &kafka.Hash{},
+			Async:        true,
+			WriteTimeout: timeout,
+			MaxAttempts:  queue.retries + 1,
+			Completion: func(messages []kafka.Message, err error) {
+				if err != nil {
+					queue.health.failed(err)
+					queue.onError(fmt.Errorf("%d entries: %w", len(messages), err))
+					return
+				}
+				queue.health.succeeded()
+			},
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q", c.Type)
+}
+
+// openChain returns the chain of a file sink at path, or nil if the sink
+// isn't chained
+func openChain(c SinkConfig, path string) (*auditChain, error) {
+	if !c.Chain {
+		return nil, nil
+	}
+	var key ed25519.PrivateKey
+	if c.SigningKey != "" {
+		var err error
+		if key, err = ParseSigningKey(resolveRef(c.SigningKey)); err != nil {
+			return nil, err
+		}
+	}
+	return newAuditChain(path, key, c.CheckpointEvery)
+}
+
+// fileSink appends entries to a file as JSON lines
+type fileSink struct {
+	mu sync.Mutex
+	f  *os.File
+
+	// shared files such as stdout aren't closed
+	shared bool
+
+	// chain is set if entries are hash-chained
+	chain *auditChain
+}
+
+func (s *fileSink) Write(entry DecisionLog) error {
+	return s.WriteBatch([]DecisionLog{entry})
+}
+
+// WriteBatch appends a batch with one write. Chained entries are written
+// one at a time, so the chain only advances past lines that made it to
+// the file.
+func (s *fileSink) WriteBatch(batch []DecisionLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.chain != nil {
+		for _, entry := range batch {
+			if err := s.writeLinked(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var buf []byte
+	for _, entry := range batch {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	_, err := s.f.Write(buf)
+	return err
+}
+
+// writeLinked appends a chained entry, and a checkpoint if one is due
+func (s *fileSink) writeLinked(entry DecisionLog) error {
+	line, hash, err := s.chain.link(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if s.chain.advance(hash) {
+		return s.writeCheckpoint()
+	}
+	return nil
+}
+
+// writeCheckpoint appends a signed checkpoint of the chain's head
+func (s *fileSink) writeCheckpoint() error {
+	line, err := s.chain.checkpoint()
+	if err != nil || line == nil {
+		return err
+	}
+	_, err = s.f.Write(append(line, '\n'))
+	return err
+}
+
+func (s *fileSink) Close() error {
+	if s.shared {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	if s.chain != nil {
+		errs = append(errs, s.writeCheckpoint())
+	}
+	errs = append(errs, s.f.Close())
+	return errors.Join(errs...)
+}
+
+// resolveRef returns the variable an env: reference names, or value as is
+func resolveRef(value string) string {
+	if env, ok := strings.CutPrefix(value, "env:"); ok {
+		return os.Getenv(env)
+	}
+	return value
+}
+
+// retryableError is a failed delivery worth trying again; entries are the
+// ones still to be delivered
+type retryableError struct {
+	err     error
+	entries []DecisionLog
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// retryStatus reports whether a response status is worth retrying
+func retryStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// queueConfig sizes a queuedSink and says where failures go
+type queueConfig struct {
+	size    int
+	retries int
+	onError func(error)
+
+	// health is told how deliveries went, and spool, if set, keeps the
+	// entries that couldn't be delivered
+	health *exporterHealth
+	spool  *spool
+}
+
+// queuedSink hands entries to a goroutine that delivers whatever has
+// queued up as one batch. A batch that fails with a retryableError is
+// retried with backoff, and while it waits new entries fill the queue until
+// it is full and further ones are dropped. With a spool, batches that still
+// fail and entries that don't fit in the queue are spooled instead, and
+// delivered after the next successful delivery or spoolRetryInterval.
+type queuedSink struct {
+	entries chan DecisionLog
+	done    chan struct{}
+	stop    chan struct{}
+	deliver func([]DecisionLog) error
+	queueConfig
+
+	// mu keeps Write from sending on the channel once Close closed it
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newQueuedSink(deliver func([]DecisionLog) error, cfg queueConfig) *queuedSink {
+	q := &queuedSink{
+		entries:     make(chan DecisionLog, cfg.size),
+		done:        make(chan struct{}),
+		stop:        make(chan struct{}),
+		deliver:     deliver,
+		queueConfig: cfg,
+	}
+	go q.run()
+	return q
+}
+
+func (q *queuedSink) Write(entry DecisionLog) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return fmt.Errorf("sink is closed; entry dropped")
+	}
+	select {
+	case q.entries <- entry:
+		return nil
+	default:
+	}
+	if q.spool == nil {
+		return fmt.Errorf("queue is full; entry dropped")
+	}
+	if err := q.spool.add([]DecisionLog{entry}); err != nil {
+		return fmt.Errorf("queue is full and %w; entry dropped", err)
+	}
+	return nil
+}
+
+func (q *queuedSink) run() {
+	defer close(q.done)
+	var retry <-chan time.Time
+	if q.spool != nil {
+		ticker := time.NewTicker(spoolRetryInterval)
+		defer ticker.Stop()
+		retry = ticker.C
+	}
+	for {
+		var entry DecisionLog
+		select {
+		case next, ok := <-q.entries:
+			if !ok {
+				return
+			}
+			entry = next
+		case <-retry:
+			if q.spool.count() > 0 {
+				q.replay()
+			}
+			continue
+		}
+		batch := []DecisionLog{entry}
+	collect:
+		for len(batch) < maxSinkBatch {
+			select {
+			case next, ok := <-q.entries:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, next)
+			default:
+				break collect
+			}
+		}
+		q.send(batch)
+	}
+}
+
+// send delivers a batch, retrying what is worth retrying, and then the
+// spooled entries
+func (q *queuedSink) send(batch []DecisionLog) {
+	for attempt := 0; ; attempt++ {
+		err := q.deliver(batch)
+		if err == nil {
+			q.health.succeeded()
+			if q.spool != nil && q.spool.count() > 0 {
+				q.replay()
+			}
+			return
+		}
+		q.health.failed(err)
+		var retry *retryableError
+		if !errors.As(err, &retry) {
+			q.onError(fmt.Errorf("%d entries: %w", len(batch), err))
+			return
+		}
+		batch = retry.entries
+		if attempt < q.retries && q.backoff(attempt) {
+			continue
+		}
+		q.giveUp(batch, err)
+		return
+	}
+}
+
+// giveUp spools a batch that couldn't be delivered, or drops it without a
+// spool
+func (q *queuedSink) giveUp(batch []DecisionLog, err error) {
+	if q.spool != nil {
+		serr := q.spool.add(batch)
+		if serr == nil {
+			return
+		}
+		err = fmt.Errorf("%w and %w", err, serr)
+	}
+	q.onError(fmt.Errorf("%d entries: %w", len(batch), err))
+}
+
+// replay delivers the spooled entries, once each. After a delivery fails,
+// the rest go back to the spool.
+func (q *queuedSink) replay() {
+	path, err := q.spool.take()
+	if err != nil || path == "" {
+		if err != nil {
+			q.onError(err)
+		}
+		return
+	}
+	var failed error
+	err = readSpool(path, func(batch []DecisionLog) {
+		if failed == nil {
+			select {
+			case <-q.stop:
+				failed = fmt.Errorf("sink is closing")
+			default:
+				if failed = q.deliver(batch); failed == nil {
+					return
+				}
+				q.health.failed(failed)
+				var retry *retryableError
+				if !errors.As(failed, &retry) {
+					q.onError(fmt.Errorf("%d spooled entries: %w", len(batch), failed))
+					return
+				}
+				batch = retry.entries
+			}
+		}
+		q.giveUp(batch, failed)
+	})
+	if err != nil {
+		// The file stays for the next replay
+		q.onError(err)
+		return
+	}
+	if err := q.spool.replayed(); err != nil {
+		q.onError(err)
+	}
+}
+
+// backoff waits before a retry. It returns false if the sink is closing,
+// in which case the batch is given up.
+func (q *queuedSink) backoff(attempt int) bool {
+	timer := time.NewTimer(sinkRetryBackoff << attempt)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-q.stop:
+		return false
+	}
+}
+
+// Close delivers the queued entries, without retries, and stops the
+// goroutine
+func (q *queuedSink) Close() error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.stop)
+		close(q.entries)
+	}
+	q.mu.Unlock()
+	<-q.done
+	return nil
+}
+
+// httpSink POSTs batches of entries to an HTTP endpoint
+type httpSink struct {
+	url    string
+	header http.Header
+	client *http.Client
+}
+
+func newHTTPSink(endpoint string, headers map[string]string, timeout time.Duration) *httpSink {
+	header := make(http.Header, len(headers))
+	for name, value := range headers {
+		header.Set(name, resolveRef(value))
+	}
+	return &httpSink{url: endpoint, header: header, client: &http.Client{Timeout: timeout}}
+}
+
+// postJSON sends a batch as a JSON array, as webhook sinks do
+func (s *httpSink) postJSON(batch []DecisionLog) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	_, err = s.post(batch, body, "application/json")
+	return err
+}
+
+// post sends body, which carries batch, and returns the response body.
+// Network errors, 429 and 5xx are retryable.
+func (s *httpSink) post(batch []DecisionLog, body []byte, contentType string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = s.header.Clone()
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, &retryableError{err: err, entries: batch}
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, &retryableError{err: err, entries: batch}
+	}
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("%s returned %d", s.url, resp.StatusCode)
+		if retryStatus(resp.StatusCode) {
+			return nil, &retryableError{err: err, entries: batch}
+		}
+		return nil, err
+	}
+	return respBody, nil
+}
+
+// kafkaSink produces entries to a topic, keyed by agent ID so each
+// agent's decisions stay in order
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+func (s *kafkaSink) Write(entry DecisionLog) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// Async writes return at once; failures reach the Completion callback
+	return s.writer.WriteMessages(context.Background(), kafka.Message{Key: []byte(entry.AgentID), Value: value})
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
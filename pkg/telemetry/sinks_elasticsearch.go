@@ -0,0 +1,103 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// elasticSink indexes batches of entries with the Elasticsearch bulk API
+type elasticSink struct {
+	*httpSink
+	index   string
+	onError func(error)
+}
+
+// elasticDocument is an entry with the @timestamp data streams require
+type elasticDocument struct {
+	Timestamp string `json:"@timestamp"`
+	DecisionLog
+}
+
+// elasticBulkResponse is the part of a bulk response that says which
+// entries failed
+type elasticBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+func newElasticSink(c SinkConfig, timeout time.Duration, queue queueConfig) Sink {
+	s := &elasticSink{
+		httpSink: newHTTPSink(strings.TrimSuffix(c.URL, "/")+"/_bulk", c.Headers, timeout),
+		index:    c.Index,
+		onError:  queue.onError,
+	}
+	if s.index == "" {
+		s.index = DefaultElasticIndex
+	}
+	if c.Token != "" {
+		s.header.Set("Authorization", "ApiKey "+resolveRef(c.Token))
+	}
+	return newQueuedSink(s.deliver, queue)
+}
+
+// deliver creates a document per entry. Entries the cluster rejected with
+// 429 or 5xx are retried; other rejections are reported and dropped.
+func (s *elasticSink) deliver(batch []DecisionLog) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	action := map[string]map[string]string{"create": {"_index": s.index}}
+	for _, entry := range batch {
+		if err := enc.Encode(action); err != nil {
+			return err
+		}
+		if err := enc.Encode(elasticDocument{Timestamp: entry.Timestamp, DecisionLog: entry}); err != nil {
+			return err
+		}
+	}
+	respBody, err := s.post(batch, body.Bytes(), "application/x-ndjson")
+	if err != nil {
+		return err
+	}
+
+	var resp elasticBulkResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return fmt.Errorf("invalid bulk response: %w", err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+	var retry []DecisionLog
+	rejected, reason := 0, ""
+	for i, item := range resp.Items {
+		if i >= len(batch) {
+			break
+		}
+		for _, result := range item {
+			switch {
+			case result.Status < 300:
+			case retryStatus(result.Status):
+				retry = append(retry, batch[i])
+			default:
+				rejected++
+				reason = result.Error.Type + ": " + result.Error.Reason
+			}
+		}
+	}
+	if rejected > 0 {
+		s.onError(fmt.Errorf("%d entries rejected: %s", rejected, reason))
+	}
+	if len(retry) > 0 {
+		return &retryableError{err: errors.New("cluster returned 429 or 5xx"), entries: retry}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// splunkSourceType is the sourcetype of decision events in Splunk
+const splunkSourceType = "aegis:decision"
+
+// splunkSink sends batches of entries to a Splunk HTTP Event Collector
+type splunkSink struct {
+	*httpSink
+	index  string
+	source string
+	host   string
+}
+
+// splunkEvent is the HEC envelope of one entry
+type splunkEvent struct {
+	Time       float64     `json:"time,omitempty"`
+	Host       string      `json:"host,omitempty"`
+	Source     string      `json:"source,omitempty"`
+	SourceType string      `json:"sourcetype"`
+	Index      string      `json:"index,omitempty"`
+	Event      DecisionLog `json:"event"`
+}
+
+func newSplunkSink(c SinkConfig, service string, timeout time.Duration, queue queueConfig) Sink {
+	s := &splunkSink{httpSink: newHTTPSink(c.URL, c.Headers, timeout), index: c.Index, source: service}
+	s.host, _ = os.Hostname()
+	s.header.Set("Authorization", "Splunk "+resolveRef(c.Token))
+	return newQueuedSink(s.deliver, queue)
+}
+
+// deliver posts the batch as concatenated events, as HEC expects
+func (s *splunkSink) deliver(batch []DecisionLog) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, entry := range batch {
+		event := splunkEvent{Host: s.host, Source: s.source, SourceType: splunkSourceType, Index: s.index, Event: entry}
+		if ts, err := time.Parse(time.RFC3339, entry.Timestamp); err == nil {
+			event.Time = float64(ts.Unix())
+		}
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	_, err := s.post(batch, body.Bytes(), "application/json")
+	return err
+}
@@ -0,0 +1,43 @@
+//go:build !windows && !plan9
+
+package telemetry
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// syslogSink sends each entry as a JSON message at info priority of the
+// auth facility. The connection is made, and remade after a failure, when
+// entries are delivered.
+type syslogSink struct {
+	network, address, tag string
+	writer                *syslog.Writer
+}
+
+func newSyslogSink(network, address, tag string, queue queueConfig) (Sink, error) {
+	s := &syslogSink{network: network, address: address, tag: tag}
+	return newQueuedSink(s.deliver, queue), nil
+}
+
+func (s *syslogSink) deliver(batch []DecisionLog) error {
+	if s.writer == nil {
+		w, err := syslog.Dial(s.network, s.address, syslog.LOG_INFO|syslog.LOG_AUTH, s.tag)
+		if err != nil {
+			return &retryableError{err: err, entries: batch}
+		}
+		s.writer = w
+	}
+	for i, entry := range batch {
+		msg, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := s.writer.Info(string(msg)); err != nil {
+			s.writer.Close()
+			s.writer = nil
+			return &retryableError{err: err, entries: batch[i:]}
+		}
+	}
+	return nil
+}
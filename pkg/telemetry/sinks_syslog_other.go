@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package telemetry
+
+import "errors"
+
+func newSyslogSink(network, address, tag string, queue queueConfig) (Sink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,191 @@
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultSpoolMaxBytes bounds each sink's spool file when
+// telemetry.spool.max_bytes isn't set
+const DefaultSpoolMaxBytes = 64 << 20
+
+// spoolRetryInterval is how often a sink with spooled entries tries to
+// deliver them when no new entry was delivered in between
+const spoolRetryInterval = 30 * time.Second
+
+// maxSpoolLine bounds one spooled entry
+const maxSpoolLine = 16 << 20
+
+// SpoolConfig keeps the decision log entries background sinks couldn't
+// deliver on disk until they can be
+type SpoolConfig struct {
+	// Dir holds a spool file per sink; entries aren't spooled when empty
+	Dir string `yaml:"dir,omitempty"`
+
+	// MaxBytes bounds each spool file (DefaultSpoolMaxBytes by default).
+	// Entries that don't fit are dropped and counted as exporter errors.
+	MaxBytes int64 `yaml:"max_bytes,omitempty"`
+}
+
+func (c SpoolConfig) validate() error {
+	if c.MaxBytes < 0 {
+		return fmt.Errorf("telemetry.spool.max_bytes must not be negative")
+	}
+	return nil
+}
+
+// spool is a file of JSON lines a sink couldn't deliver. While they are
+// redelivered, the lines are moved to a replay file next to it, so entries
+// spooled in the meantime don't mix with them.
+type spool struct {
+	path string
+	max  int64
+
+	mu      sync.Mutex
+	size    int64
+	entries int
+	// replaying are the lines of the replay file, which count towards the
+	// spool until they are delivered
+	replaying int
+}
+
+// openSpool opens the spool file of the sink at index of the given type,
+// counting the entries left from the last run
+func openSpool(cfg SpoolConfig, index int, sinkType string) (*spool, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	s := &spool{path: filepath.Join(cfg.Dir, fmt.Sprintf("sink-%d-%s.jsonl", index, sinkType)), max: cfg.MaxBytes}
+	if s.max == 0 {
+		s.max = DefaultSpoolMaxBytes
+	}
+	var err error
+	if s.entries, s.size, err = countLines(s.path); err != nil {
+		return nil, err
+	}
+	if s.replaying, _, err = countLines(s.replayPath()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// countLines returns the lines and size of a file, 0 if it doesn't exist
+func countLines(path string) (int, int64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read spool: %w", err)
+	}
+	return bytes.Count(data, []byte{'\n'}), int64(len(data)), nil
+}
+
+func (s *spool) replayPath() string {
+	return s.path + ".replay"
+}
+
+// count is the number of entries waiting in the spool
+func (s *spool) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries + s.replaying
+}
+
+// add appends entries to the spool file, or none of them if they don't fit
+func (s *spool) add(batch []DecisionLog) error {
+	var buf []byte
+	for _, entry := range batch {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(len(buf)) > s.max {
+		return fmt.Errorf("spool is full")
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(buf); err != nil {
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	s.size += int64(len(buf))
+	s.entries += len(batch)
+	return nil
+}
+
+// take moves the spooled entries to the replay file and returns its path.
+// A replay file left by a replay that didn't finish is returned as is.
+func (s *spool) take() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replaying > 0 {
+		return s.replayPath(), nil
+	}
+	if s.entries == 0 {
+		return "", nil
+	}
+	if err := os.Rename(s.path, s.replayPath()); err != nil {
+		return "", fmt.Errorf("failed to replay spool: %w", err)
+	}
+	s.replaying, s.entries, s.size = s.entries, 0, 0
+	return s.replayPath(), nil
+}
+
+// replayed removes the replay file once its entries were delivered or
+// spooled again
+func (s *spool) replayed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replaying = 0
+	if err := os.Remove(s.replayPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove replayed spool: %w", err)
+	}
+	return nil
+}
+
+// readSpool calls batch with the entries of a spool file, maxSinkBatch at
+// a time
+func readSpool(path string, batch func([]DecisionLog)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read spool: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), maxSpoolLine)
+	var entries []DecisionLog
+	for scanner.Scan() {
+		var entry DecisionLog
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A line cut short by a crash; the rest are still good
+			continue
+		}
+		entries = append(entries, entry)
+		if len(entries) == maxSinkBatch {
+			batch(entries)
+			entries = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read spool: %w", err)
+	}
+	if len(entries) > 0 {
+		batch(entries)
+	}
+	return nil
+}
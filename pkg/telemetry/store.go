@@ -0,0 +1,342 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Decision store drivers
+const (
+	StoreSQLite   = "sqlite"
+	StorePostgres = "postgres"
+)
+
+// StoreConfig configures the database decisions are kept in for querying
+type StoreConfig struct {
+	// Driver is sqlite or postgres; empty disables the store
+	Driver string `yaml:"driver,omitempty"`
+
+	// DSN is the SQLite file or the Postgres connection string. A secret
+	// reference such as vault:secret/data/aegis#decisions_dsn is resolved
+	// by the gateway; Postgres DSNs must be one.
+	DSN string `yaml:"dsn,omitempty"`
+
+	// Retention is how long decisions are kept; zero keeps them forever
+	Retention time.Duration `yaml:"retention,omitempty"`
+}
+
+// Enabled reports whether a decision store is configured
+func (c StoreConfig) Enabled() bool {
+	return c.Driver != ""
+}
+
+func (c StoreConfig) validate() error {
+	switch c.Driver {
+	case "":
+		return nil
+	case StoreSQLite, StorePostgres:
+	default:
+		return fmt.Errorf("telemetry.store.driver must be sqlite or postgres")
+	}
+	if c.DSN == "" {
+		return fmt.Errorf("telemetry.store.dsn is required")
+	}
+	if c.Retention < 0 {
+		return fmt.Errorf("telemetry.store.retention must not be negative")
+	}
+	return nil
+}
+
+// Errors returned by QueryDecisions
+var (
+	ErrNoDecisionStore = errors.New("no decision store is configured")
+	ErrInvalidCursor   = errors.New("invalid cursor")
+)
+
+// sinkDecisionStore is the exporter the store's write errors are counted
+// under
+const sinkDecisionStore = "decision_store"
+
+// MaxDecisionPage bounds the decisions one query returns
+const MaxDecisionPage = 1000
+
+// pruneInterval is how often decisions past the retention are deleted
+const pruneInterval = time.Hour
+
+// DecisionQuery selects decisions, newest first. Empty fields don't filter.
+type DecisionQuery struct {
+	Agent   string
+	Tool    string
+	Session string
+	Code    string
+
+	// Result is allow or deny
+	Result string
+
+	Since time.Time
+	Until time.Time
+
+	// Cursor continues a previous query from its DecisionPage.Next
+	Cursor string
+
+	Limit int
+}
+
+// Matches reports whether an entry passes the query's agent, tool,
+// session, code and result filters. Times and the cursor aren't checked.
+func (q DecisionQuery) Matches(entry DecisionLog) bool {
+	return (q.Agent == "" || entry.AgentID == q.Agent) &&
+		(q.Tool == "" || entry.ToolName == q.Tool) &&
+		(q.Session == "" || entry.SessionID == q.Session) &&
+		(q.Code == "" || entry.Code == q.Code) &&
+		(q.Result == "" || entry.result() == q.Result)
+}
+
+// result is allow or deny
+func (d DecisionLog) result() string {
+	if d.Decision == "true" {
+		return "allow"
+	}
+	return "deny"
+}
+
+// DecisionPage is a page of decisions and the cursor of the next one,
+// empty on the last page
+type DecisionPage struct {
+	Decisions []DecisionLog `json:"decisions"`
+	Next      string        `json:"next,omitempty"`
+}
+
+// decisionStore writes decisions to a database in batches and queries them
+type decisionStore struct {
+	*queuedSink
+	db        *sql.DB
+	driver    string
+	retention time.Duration
+	pruned    time.Time
+}
+
+// OpenDecisionStore opens the database at dsn, creating the decisions
+// table if needed, and keeps every decision from now on in it
+func (t *Telemetry) OpenDecisionStore(cfg StoreConfig, dsn string) error {
+	driverName := "sqlite"
+	if cfg.Driver == StorePostgres {
+		driverName = "pgx"
+	}
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return err
+	}
+	if cfg.Driver == StoreSQLite {
+		// SQLite allows one writer; a single connection avoids lock errors
+		db.SetMaxOpenConns(1)
+	}
+	s := &decisionStore{db: db, driver: cfg.Driver, retention: cfg.Retention}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to create decisions table: %w", err)
+	}
+	s.queuedSink = newQueuedSink(s.insert, queueConfig{
+		size:    DefaultSinkQueueSize,
+		retries: DefaultSinkRetries,
+		onError: func(err error) { t.metrics.sinkError(sinkDecisionStore, err) },
+		health:  &exporterHealth{},
+	})
+	t.metrics.exporterErrors.WithLabelValues(sinkDecisionStore)
+	if previous := t.store.Swap(s); previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+func (s *decisionStore) migrate() error {
+	id := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if s.driver == StorePostgres {
+		id = "BIGSERIAL PRIMARY KEY"
+	}
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS aegis_decisions (
+			id ` + id + `,
+			time_us BIGINT NOT NULL,
+			agent_id TEXT NOT NULL,
+			tool TEXT NOT NULL,
+			result TEXT NOT NULL,
+			code TEXT NOT NULL,
+			session_id TEXT NOT NULL,
+			entry TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS aegis_decisions_time ON aegis_decisions (time_us)`,
+		`CREATE INDEX IF NOT EXISTS aegis_decisions_agent ON aegis_decisions (agent_id, id)`,
+		`CREATE INDEX IF NOT EXISTS aegis_decisions_tool ON aegis_decisions (tool, id)`,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSinkTimeout)
+	defer cancel()
+	for _, stmt := range statements {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placeholder returns the nth (1-based) bind parameter
+func (s *decisionStore) placeholder(n int) string {
+	if s.driver == StorePostgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+// insert writes a batch in one transaction. Network and lock errors are
+// retried.
+func (s *decisionStore) insert(batch []DecisionLog) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSinkTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &retryableError{err: err, entries: batch}
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO aegis_decisions (time_us, agent_id, tool, result, code, session_id, entry) VALUES ("
+	for i := 1; i <= 7; i++ {
+		if i > 1 {
+			query += ", "
+		}
+		query += s.placeholder(i)
+	}
+	stmt, err := tx.PrepareContext(ctx, query+")")
+	if err != nil {
+		return &retryableError{err: err, entries: batch}
+	}
+	defer stmt.Close()
+	for _, entry := range batch {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		at, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err != nil {
+			at = time.Now()
+		}
+		if _, err := stmt.ExecContext(ctx, at.UnixMicro(), entry.AgentID, entry.ToolName, entry.result(), entry.Code, entry.SessionID, string(data)); err != nil {
+			return &retryableError{err: err, entries: batch}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return &retryableError{err: err, entries: batch}
+	}
+	s.prune(ctx)
+	return nil
+}
+
+// prune deletes decisions past the retention, at most once per
+// pruneInterval
+func (s *decisionStore) prune(ctx context.Context) {
+	if s.retention == 0 || time.Since(s.pruned) < pruneInterval {
+		return
+	}
+	s.pruned = time.Now()
+	cutoff := time.Now().Add(-s.retention).UnixMicro()
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM aegis_decisions WHERE time_us < "+s.placeholder(1), cutoff); err != nil {
+		logger.Warn("Failed to delete expired decisions", "error", err)
+	}
+}
+
+// query returns a page of decisions matching q
+func (s *decisionStore) query(ctx context.Context, q DecisionQuery) (DecisionPage, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, cond+" "+s.placeholder(len(args)))
+	}
+	if q.Agent != "" {
+		add("agent_id =", q.Agent)
+	}
+	if q.Tool != "" {
+		add("tool =", q.Tool)
+	}
+	if q.Session != "" {
+		add("session_id =", q.Session)
+	}
+	if q.Code != "" {
+		add("code =", q.Code)
+	}
+	if q.Result != "" {
+		add("result =", q.Result)
+	}
+	if !q.Since.IsZero() {
+		add("time_us >=", q.Since.UnixMicro())
+	}
+	if !q.Until.IsZero() {
+		add("time_us <", q.Until.UnixMicro())
+	}
+	if q.Cursor != "" {
+		before, err := strconv.ParseInt(q.Cursor, 10, 64)
+		if err != nil || before <= 0 {
+			return DecisionPage{}, ErrInvalidCursor
+		}
+		add("id <", before)
+	}
+
+	query := "SELECT id, entry FROM aegis_decisions"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// One extra row tells whether there is a next page
+	query += " ORDER BY id DESC LIMIT " + strconv.Itoa(q.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return DecisionPage{}, err
+	}
+	defer rows.Close()
+
+	page := DecisionPage{Decisions: make([]DecisionLog, 0)}
+	var lastID int64
+	for rows.Next() {
+		if len(page.Decisions) == q.Limit {
+			page.Next = strconv.FormatInt(lastID, 10)
+			break
+		}
+		var data string
+		if err := rows.Scan(&lastID, &data); err != nil {
+			return DecisionPage{}, err
+		}
+		var entry DecisionLog
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return DecisionPage{}, err
+		}
+		page.Decisions = append(page.Decisions, entry)
+	}
+	return page, rows.Err()
+}
+
+// Close writes the queued decisions and closes the database
+func (s *decisionStore) Close() error {
+	s.queuedSink.Close()
+	return s.db.Close()
+}
+
+// QueryDecisions returns a page of stored decisions, newest first. The
+// limit is capped at MaxDecisionPage.
+func (t *Telemetry) QueryDecisions(ctx context.Context, q DecisionQuery) (DecisionPage, error) {
+	s := t.store.Load()
+	if s == nil {
+		return DecisionPage{}, ErrNoDecisionStore
+	}
+	if q.Limit <= 0 || q.Limit > MaxDecisionPage {
+		q.Limit = MaxDecisionPage
+	}
+	return s.query(ctx, q)
+}
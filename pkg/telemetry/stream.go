@@ -0,0 +1,76 @@
+package telemetry
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// subscriptionBuffer is how many decisions a subscriber may fall behind
+// before further ones are dropped for it
+const subscriptionBuffer = 256
+
+// DecisionSubscription receives decisions as they are logged
+type DecisionSubscription struct {
+	// C delivers the decisions the subscription matches. It is closed by
+	// Close.
+	C <-chan DecisionLog
+
+	ch      chan DecisionLog
+	match   func(DecisionLog) bool
+	dropped atomic.Int64
+	subs    *decisionSubscribers
+}
+
+// Dropped is the number of decisions skipped so far because the
+// subscriber didn't keep up
+func (s *DecisionSubscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close ends the subscription
+func (s *DecisionSubscription) Close() {
+	s.subs.mu.Lock()
+	defer s.subs.mu.Unlock()
+	if _, ok := s.subs.set[s]; ok {
+		delete(s.subs.set, s)
+		close(s.ch)
+	}
+}
+
+// decisionSubscribers are the live subscriptions to the decision log
+type decisionSubscribers struct {
+	mu  sync.RWMutex
+	set map[*DecisionSubscription]struct{}
+}
+
+// SubscribeDecisions delivers every decision logged from now on that match
+// accepts, or every decision if match is nil. A subscriber that falls
+// behind misses decisions rather than slowing down calls.
+func (t *Telemetry) SubscribeDecisions(match func(DecisionLog) bool) *DecisionSubscription {
+	ch := make(chan DecisionLog, subscriptionBuffer)
+	s := &DecisionSubscription{C: ch, ch: ch, match: match, subs: &t.subscribers}
+
+	t.subscribers.mu.Lock()
+	defer t.subscribers.mu.Unlock()
+	if t.subscribers.set == nil {
+		t.subscribers.set = make(map[*DecisionSubscription]struct{})
+	}
+	t.subscribers.set[s] = struct{}{}
+	return s
+}
+
+// publish hands an entry to the matching subscriptions
+func (d *decisionSubscribers) publish(entry DecisionLog) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for s := range d.set {
+		if s.match != nil && !s.match(entry) {
+			continue
+		}
+		select {
+		case s.ch <- entry:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
@@ -5,93 +5,290 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
-	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 	"go.opentelemetry.io/otel/trace"
+
+	"aegis-gateway/internal/logging"
 )
 
+// logger is the telemetry component's logger
+var logger = logging.For("telemetry")
+
 // Telemetry manages OpenTelemetry and logging
 type Telemetry struct {
-	tracer     trace.Tracer
-	logFile    *os.File
-	logDir     string
+	tracer      trace.Tracer
+	logDir      string
 	serviceName string
+
+	// serviceVersion, environment and instanceID tell gateways apart in
+	// decision log entries
+	serviceVersion string
+	environment    string
+	instanceID     string
+
+	// adminLog is the admin API audit trail, opened on first use
+	adminLog     *os.File
+	adminLogErr  error
+	adminLogOnce sync.Once
+
+	// emailLog is the audit trail of sent email, opened on first use
+	emailLog     *os.File
+	emailLogErr  error
+	emailLogOnce sync.Once
+
+	// sinks receive decision log entries from queue
+	sinks []typedSink
+	queue *decisionQueue
+
+	// signer is nil unless entries are signed
+	signer atomic.Pointer[recordSigner]
+
+	// sealer is nil unless payloads are captured
+	sealer *payloadSealer
+
+	// store is nil unless decisions are kept in a database
+	store atomic.Pointer[decisionStore]
+
+	// subscribers receive decisions as they are logged
+	subscribers decisionSubscribers
+
+	// recent keeps the latest decisions for the admin API
+	recent recentDecisions
+
+	// metrics are served on /metrics
+	metrics *metrics
+
+	// tracerProvider batches spans for traces, the OTLP exporter
+	tracerProvider *sdktrace.TracerProvider
+	traces         *otlpTraceExporter
+
+	shutdownOnce sync.Once
+	shutdownErr  error
 }
 
 // DecisionLog represents a structured audit log entry
 type DecisionLog struct {
-	Timestamp    string            `json:"timestamp"`
-	AgentID      string            `json:"agent.id"`
-	ToolName     string            `json:"tool.name"`
-	ToolAction   string            `json:"tool.action"`
-	Decision     string            `json:"decision.allow"` // "true" or "false"
-	Reason       string            `json:"reason,omitempty"`
-	PolicyVersion string           `json:"policy.version,omitempty"`
-	ParamsHash   string            `json:"params.hash"`
-	LatencyMS    int64             `json:"latency.ms"`
-	TraceID      string            `json:"trace.id"`
-	SpanID       string            `json:"span.id"`
-}
-
-// NewTelemetry initializes OpenTelemetry and logging
+	Timestamp     string                 `json:"timestamp"`
+	DecisionID    string                 `json:"decision.id,omitempty"`
+	RequestID     string                 `json:"request.id,omitempty"`
+	AgentID       string                 `json:"agent.id"`
+	SessionID     string                 `json:"session.id,omitempty"`
+	ToolName      string                 `json:"tool.name"`
+	ToolAction    string                 `json:"tool.action"`
+	Decision      string                 `json:"decision.allow"` // "true" or "false"
+	Code          string                 `json:"decision.code,omitempty"`
+	Reason        string                 `json:"reason,omitempty"`
+	PolicyVersion string                 `json:"policy.version,omitempty"`
+	Rollout       string                 `json:"policy.rollout,omitempty"`
+	Fallback      string                 `json:"decision.fallback,omitempty"`
+	Phase         string                 `json:"decision.phase,omitempty"`
+	Outcome       string                 `json:"response.outcome,omitempty"`
+	Redactions    map[string]int         `json:"response.redactions,omitempty"`
+	Findings      []string               `json:"content.findings,omitempty"`
+	AnomalyType   string                 `json:"anomaly.type,omitempty"`
+	AnomalyAction string                 `json:"anomaly.action,omitempty"`
+	Params        map[string]interface{} `json:"params,omitempty"`
+	ParamsHash    string                 `json:"params.hash"`
+	ParamsHashAlg string                 `json:"params.hash_alg,omitempty"`
+	PayloadKey    string                 `json:"payload.key,omitempty"`
+	Payload       string                 `json:"payload.sealed,omitempty"`
+	LatencyMS     int64                  `json:"latency.ms"`
+	TraceID       string                 `json:"trace.id"`
+	SpanID        string                 `json:"span.id"`
+
+	// Set from telemetry.service_version, environment and instance_id
+	ServiceVersion string `json:"service.version,omitempty"`
+	Environment    string `json:"deployment.environment,omitempty"`
+	InstanceID     string `json:"service.instance.id,omitempty"`
+
+	// Set when records are signed
+	SignatureKey string `json:"signature.key,omitempty"`
+	Signature    string `json:"signature,omitempty"`
+
+	// Set by file sinks that chain their entries
+	ChainSeq  uint64 `json:"chain.seq,omitempty"`
+	ChainPrev string `json:"chain.prev,omitempty"`
+}
+
+// Config controls telemetry export and audit log placement
+type Config struct {
+	ServiceName  string `yaml:"service_name"`
+	LogDir       string `yaml:"log_dir"`
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	OTLPInsecure bool   `yaml:"otlp_insecure"`
+
+	// ServiceVersion, Environment and InstanceID are set on the OTel
+	// resource, as service.version, deployment.environment and
+	// service.instance.id, and in every decision log entry, so the
+	// gateways of a fleet can be told apart
+	ServiceVersion string `yaml:"service_version,omitempty"`
+	Environment    string `yaml:"environment,omitempty"`
+	InstanceID     string `yaml:"instance_id,omitempty"`
+
+	// OTLPMetrics pushes metrics to OTLPEndpoint too, every
+	// OTLPMetricsInterval (DefaultOTLPMetricsInterval by default), for
+	// backends that don't scrape /metrics
+	OTLPMetrics         bool          `yaml:"otlp_metrics,omitempty"`
+	OTLPMetricsInterval time.Duration `yaml:"otlp_metrics_interval,omitempty"`
+
+	// Sinks are where decision log entries are written, by default
+	// aegis.log in LogDir and stdout
+	Sinks []SinkConfig `yaml:"sinks,omitempty"`
+
+	// RecordSigningKey is a secret reference (env:, file:, vault:, aws:)
+	// to a base64 Ed25519 key every decision log entry is signed with. The
+	// gateway resolves it and calls SetRecordSigner.
+	RecordSigningKey string `yaml:"record_signing_key,omitempty"`
+
+	// PayloadKey is a base64 X25519 public key. When set, every entry
+	// carries the call's complete payload encrypted to it, which only the
+	// holder of the private key can read.
+	PayloadKey string `yaml:"payload_key,omitempty"`
+
+	// Store keeps decisions in a database for GET /v1/decisions
+	Store StoreConfig `yaml:"store,omitempty"`
+
+	// Queue holds decisions on their way to the sinks and store
+	Queue QueueConfig `yaml:"queue,omitempty"`
+
+	// Sampling chooses which traces are exported
+	Sampling SamplingConfig `yaml:"sampling,omitempty"`
+
+	// Spool keeps entries background sinks couldn't deliver on disk
+	Spool SpoolConfig `yaml:"spool,omitempty"`
+}
+
+// NewTelemetry initializes OpenTelemetry and logging with the default
+// local collector endpoint
 func NewTelemetry(serviceName, logDir string) (*Telemetry, error) {
+	return NewTelemetryWithConfig(Config{
+		ServiceName:  serviceName,
+		LogDir:       logDir,
+		OTLPEndpoint: "localhost:4318",
+		OTLPInsecure: true,
+	})
+}
+
+// NewTelemetryWithConfig initializes OpenTelemetry and logging
+func NewTelemetryWithConfig(cfg Config) (*Telemetry, error) {
+	serviceName, logDir := cfg.ServiceName, cfg.LogDir
+
 	// Ensure log directory exists
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create log directory: %w", err)
 	}
 
-	logPath := filepath.Join(logDir, "aegis.log")
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	var sealer *payloadSealer
+	if cfg.PayloadKey != "" {
+		var err error
+		if sealer, err = newPayloadSealer(cfg.PayloadKey); err != nil {
+			return nil, fmt.Errorf("telemetry.payload_key: %w", err)
+		}
+	}
+
+	metrics := newMetrics()
+	metrics.countExporterErrors()
+
+	sinks, err := openSinks(cfg, metrics.sinkError)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %w", err)
+		return nil, err
 	}
 
 	// Initialize OTLP exporter
-	exporter, err := otlptracehttp.New(context.Background(),
-		otlptracehttp.WithEndpoint("localhost:4318"),
-		otlptracehttp.WithInsecure(),
-	)
-	if err != nil {
-		// Fallback to no-op if exporter fails (for local dev)
-		fmt.Printf("WARNING: Failed to initialize OTLP exporter: %v\n", err)
-		exporter = nil
+	exporterOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.OTLPEndpoint)}
+	if cfg.OTLPInsecure {
+		exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
 	}
+	exporterOpts = append(exporterOpts, otlptracehttp.WithRetry(otlpRetry))
+	traces := newOTLPTraceExporter(func() (sdktrace.SpanExporter, error) {
+		return otlptracehttp.New(context.Background(), exporterOpts...)
+	})
 
-	var tp *sdktrace.TracerProvider
-	if exporter != nil {
-		resource, _ := resource.New(context.Background(),
-			resource.WithAttributes(semconv.ServiceName(serviceName)),
-		)
+	res, _ := resource.New(context.Background(), resource.WithAttributes(resourceAttributes(cfg)...))
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traces),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(newSampler(cfg.Sampling)),
+	)
+	otel.SetTracerProvider(tp)
 
-		tp = sdktrace.NewTracerProvider(
-			sdktrace.WithBatcher(exporter),
-			sdktrace.WithResource(resource),
-		)
-		otel.SetTracerProvider(tp)
+	if cfg.OTLPMetrics {
+		otlp, err := newOTLPMetrics(cfg, res)
+		if err != nil {
+			// Prometheus metrics are still served on /metrics
+			logger.Warn("Failed to initialize OTLP metrics exporter", "error", err)
+		} else {
+			metrics.otlp = otlp
+		}
 	}
 
+	otel.SetTextMapPropagator(propagator)
 	tracer := otel.Tracer(serviceName)
 
-	return &Telemetry{
+	t := &Telemetry{
 		tracer:      tracer,
-		logFile:     logFile,
+		sinks:       sinks,
 		logDir:      logDir,
 		serviceName: serviceName,
-	}, nil
+		metrics:     metrics,
+		sealer:      sealer,
+
+		tracerProvider: tp,
+		traces:         traces,
+
+		serviceVersion: cfg.ServiceVersion,
+		environment:    cfg.Environment,
+		instanceID:     cfg.InstanceID,
+	}
+	t.queue = newDecisionQueue(cfg.Queue, t.writeDecisions)
+	metrics.watchDecisionQueue(t.queue)
+	metrics.watchSpools(sinks)
+	return t, nil
+}
+
+// resourceAttributes describes the gateway on the OTel resource
+func resourceAttributes(cfg Config) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.ServiceName(cfg.ServiceName)}
+	if cfg.ServiceVersion != "" {
+		attrs = append(attrs, semconv.ServiceVersion(cfg.ServiceVersion))
+	}
+	if cfg.Environment != "" {
+		attrs = append(attrs, semconv.DeploymentEnvironment(cfg.Environment))
+	}
+	if cfg.InstanceID != "" {
+		attrs = append(attrs, semconv.ServiceInstanceID(cfg.InstanceID))
+	}
+	return attrs
 }
 
-// HashParams creates a SHA-256 hash of request parameters
+// paramString formats a captured param for a span attribute: strings as
+// they are, anything else as JSON
+func paramString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// HashParams creates a SHA-256 hash of request parameters in canonical
+// JSON (see ParamsHashAlgorithm)
 func HashParams(params interface{}) string {
-	data, err := json.Marshal(params)
+	data, err := CanonicalJSON(params)
 	if err != nil {
 		return "hash_error"
 	}
@@ -99,65 +296,373 @@ func HashParams(params interface{}) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// LogDecision creates a span and logs the decision
-func (t *Telemetry) LogDecision(ctx context.Context, agentID, tool, action string, allowed bool, reason string, paramsHash string, latencyMS int64) (context.Context, trace.Span) {
-	ctx, span := t.tracer.Start(ctx, "policy.evaluate",
-		trace.WithAttributes(
-			attribute.String("agent.id", agentID),
-			attribute.String("tool.name", tool),
-			attribute.String("tool.action", action),
-			attribute.Bool("decision.allow", allowed),
-			attribute.String("params.hash", paramsHash),
-			attribute.Int64("latency.ms", latencyMS),
-		),
+// Decision describes a policy decision to be recorded
+type Decision struct {
+	ID         string
+	AgentID    string
+	SessionID  string
+	Tool       string
+	Action     string
+	Allowed    bool
+	Code       string
+	Reason     string
+	ParamsHash string
+	LatencyMS  int64
+	Rollout    string
+
+	// AgentVerified is set when AgentID was proven by a credential, and
+	// ToolRegistered when Tool is a configured tool. Metrics label other
+	// agents and tools as OtherLabel, so callers can't add series at will.
+	AgentVerified  bool
+	ToolRegistered bool
+
+	// Evaluation is the time the policy engine took, 0 when it didn't run
+	Evaluation time.Duration
+
+	// Fallback is the on_policy_error behavior used when the policy engine
+	// could not decide
+	Fallback string
+
+	// Phase is "precheck" for calls evaluated ahead of time, e.g. in a batch
+	Phase string
+
+	// Findings are content filter matches in the params, e.g.
+	// "ignore_instructions at note", and malware found in uploads
+	Findings []string
+
+	// Params are the params the tool's capture rules record, by path,
+	// cleartext or already masked
+	Params map[string]interface{}
+
+	// Payload is the complete call, encrypted into the entry if a payload
+	// key is set and dropped otherwise
+	Payload interface{}
+}
+
+// StartRequest starts the root span of a request the gateway received at
+// start, the parent of its policy.evaluate and tool.forward spans. It
+// carries the call's route and outcome, which sampling decides on. It must
+// be ended, with EndRequest when the request had an HTTP status.
+func (t *Telemetry) StartRequest(ctx context.Context, start time.Time, d Decision) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("decision.id", d.ID),
+		attribute.String("agent.id", d.AgentID),
+		attribute.String("tool.name", d.Tool),
+		attribute.String("tool.action", d.Action),
+		attribute.Bool("decision.allow", d.Allowed),
+	}
+	return t.tracer.Start(ctx, "gateway.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(withRequestID(ctx, attrs)...),
+	)
+}
+
+// EndRequest records the HTTP status a request was answered with and ends
+// its span. Statuses of 500 and above mark the span as failed.
+func EndRequest(span trace.Span, status int) {
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// LogDecision records the policy.evaluate span of a decision, as a child
+// of ctx's span covering the evaluation, and logs the decision
+func (t *Telemetry) LogDecision(ctx context.Context, d Decision) {
+	attrs := []attribute.KeyValue{
+		attribute.String("decision.id", d.ID),
+		attribute.String("agent.id", d.AgentID),
+		attribute.String("tool.name", d.Tool),
+		attribute.String("tool.action", d.Action),
+		attribute.Bool("decision.allow", d.Allowed),
+		attribute.String("decision.code", d.Code),
+		attribute.String("params.hash", d.ParamsHash),
+		attribute.Int64("latency.ms", d.LatencyMS),
+	}
+	if d.SessionID != "" {
+		attrs = append(attrs, attribute.String("session.id", d.SessionID))
+	}
+	attrs = withRequestID(ctx, attrs)
+	if d.Rollout != "" {
+		attrs = append(attrs, attribute.String("policy.rollout", d.Rollout))
+	}
+	if d.Fallback != "" {
+		attrs = append(attrs, attribute.String("decision.fallback", d.Fallback))
+	}
+	if d.Phase != "" {
+		attrs = append(attrs, attribute.String("decision.phase", d.Phase))
+	}
+	if len(d.Findings) > 0 {
+		attrs = append(attrs, attribute.StringSlice("content.findings", d.Findings))
+	}
+	for path, value := range d.Params {
+		attrs = append(attrs, attribute.String("params."+path, paramString(value)))
+	}
+
+	// The engine has already decided; the span covers the time it took
+	end := time.Now()
+	_, span := t.tracer.Start(ctx, "policy.evaluate",
+		trace.WithTimestamp(end.Add(-d.Evaluation)),
+		trace.WithAttributes(attrs...),
 	)
+	if d.Allowed {
+		span.SetStatus(codes.Ok, "")
+	} else {
+		span.SetStatus(codes.Error, d.Code)
+	}
+	span.End(trace.WithTimestamp(end))
 
 	decisionStr := "false"
-	if allowed {
+	if d.Allowed {
 		decisionStr = "true"
 	}
 
 	logEntry := DecisionLog{
 		Timestamp:  time.Now().UTC().Format(time.RFC3339),
-		AgentID:    agentID,
-		ToolName:   tool,
-		ToolAction: action,
+		DecisionID: d.ID,
+		RequestID:  RequestID(ctx),
+		AgentID:    d.AgentID,
+		SessionID:  d.SessionID,
+		ToolName:   d.Tool,
+		ToolAction: d.Action,
 		Decision:   decisionStr,
-		Reason:     reason,
-		ParamsHash: paramsHash,
-		LatencyMS:  latencyMS,
+		Code:       d.Code,
+		Reason:     d.Reason,
+		Rollout:    d.Rollout,
+		Fallback:   d.Fallback,
+		Phase:      d.Phase,
+		Findings:   d.Findings,
+		Params:     d.Params,
+		ParamsHash: d.ParamsHash,
+		LatencyMS:  d.LatencyMS,
 		TraceID:    span.SpanContext().TraceID().String(),
 		SpanID:     span.SpanContext().SpanID().String(),
 	}
-
-	if !allowed {
-		logEntry.Reason = reason
+	if d.ParamsHash != "" {
+		logEntry.ParamsHashAlg = ParamsHashAlgorithm
+	}
+	if t.sealer != nil && d.Payload != nil {
+		if sealed, err := t.sealer.seal(d.Payload); err != nil {
+			logger.Error("Failed to encrypt payload", "decision_id", d.ID, "error", err)
+		} else {
+			logEntry.PayloadKey, logEntry.Payload = t.sealer.keyID, sealed
+		}
 	}
 
-	// Write to log file
-	logJSON, _ := json.Marshal(logEntry)
-	t.logFile.WriteString(string(logJSON) + "\n")
-
-	// Also write to stdout
-	fmt.Println(string(logJSON))
-
-	return ctx, span
+	t.metrics.recordDecision(d)
+	t.writeDecision(logEntry)
 }
 
-// LogForwardedCall logs a forwarded call to a tool
-func (t *Telemetry) LogForwardedCall(ctx context.Context, tool, action string, latencyMS int64) trace.Span {
+// LogForwardedCall logs a forwarded call to a tool that took latency, as a
+// tool.forward span starting that long ago. target is the version of the
+// tool that served it, "stable" or "canary". status is the tool's HTTP
+// status, or 0 when the call failed without one; both 0 and 5xx mark the
+// span as failed.
+func (t *Telemetry) LogForwardedCall(ctx context.Context, tool, action, target string, status int, latency time.Duration) trace.Span {
+	attrs := []attribute.KeyValue{
+		attribute.String("tool.name", tool),
+		attribute.String("tool.action", action),
+		attribute.String("tool.target", target),
+		attribute.Int64("latency.ms", latency.Milliseconds()),
+	}
+	if status > 0 {
+		attrs = append(attrs, attribute.Int("http.status_code", status))
+	}
 	_, span := t.tracer.Start(ctx, "tool.forward",
-		trace.WithAttributes(
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(time.Now().Add(-latency)),
+		trace.WithAttributes(withRequestID(ctx, attrs)...),
+	)
+	switch {
+	case status == 0:
+		span.SetStatus(codes.Error, "tool unreachable")
+	case status >= 500:
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	t.metrics.recordForwardedCall(tool, target, status, latency)
+	return span
+}
+
+// LogCacheHit records a call answered from the response cache. ageMS is how
+// long ago the cached response was stored.
+func (t *Telemetry) LogCacheHit(ctx context.Context, tool, action string, ageMS int64) trace.Span {
+	_, span := t.tracer.Start(ctx, "tool.cache_hit",
+		trace.WithAttributes(withRequestID(ctx, []attribute.KeyValue{
 			attribute.String("tool.name", tool),
 			attribute.String("tool.action", action),
-			attribute.Int64("latency.ms", latencyMS),
-		),
+			attribute.Int64("cache.age_ms", ageMS),
+		})...),
 	)
 	return span
 }
 
-// Close closes the log file
+// ResponseCheck describes a response constraint applied to a tool's answer
+type ResponseCheck struct {
+	AgentID string
+	Tool    string
+	Action  string
+	Code    string
+	Reason  string
+
+	// Outcome is what happened to the response: block, truncate, strip or
+	// redact
+	Outcome string
+
+	// Redactions counts the values masked per detector
+	Redactions map[string]int
+
+	// Findings are content filter matches in the response
+	Findings []string
+}
+
+// LogResponseCheck records a response constraint finding on the call's trace
+// and in the audit log. Blocked responses are logged as denials.
+func (t *Telemetry) LogResponseCheck(ctx context.Context, c ResponseCheck) {
+	attrs := []attribute.KeyValue{
+		attribute.String("agent.id", c.AgentID),
+		attribute.String("tool.name", c.Tool),
+		attribute.String("tool.action", c.Action),
+		attribute.String("decision.code", c.Code),
+		attribute.String("response.outcome", c.Outcome),
+	}
+	for detector, count := range c.Redactions {
+		attrs = append(attrs, attribute.Int("response.redactions."+detector, count))
+	}
+	if len(c.Findings) > 0 {
+		attrs = append(attrs, attribute.StringSlice("content.findings", c.Findings))
+	}
+	attrs = withRequestID(ctx, attrs)
+	_, span := t.tracer.Start(ctx, "policy.response", trace.WithAttributes(attrs...))
+	defer span.End()
+
+	decisionStr := "true"
+	if c.Outcome == "block" {
+		decisionStr = "false"
+	}
+
+	logEntry := DecisionLog{
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		RequestID:  RequestID(ctx),
+		AgentID:    c.AgentID,
+		ToolName:   c.Tool,
+		ToolAction: c.Action,
+		Decision:   decisionStr,
+		Code:       c.Code,
+		Reason:     c.Reason,
+		Phase:      "response",
+		Outcome:    c.Outcome,
+		Redactions: c.Redactions,
+		Findings:   c.Findings,
+		TraceID:    span.SpanContext().TraceID().String(),
+		SpanID:     span.SpanContext().SpanID().String(),
+	}
+	t.writeDecision(logEntry)
+}
+
+// writeDecision stamps an entry with the gateway instance and queues it for
+// the sinks. With telemetry.queue.on_full set to drop, an entry that doesn't
+// fit is counted and dropped.
+func (t *Telemetry) writeDecision(entry DecisionLog) {
+	entry.ServiceVersion = t.serviceVersion
+	entry.Environment = t.environment
+	entry.InstanceID = t.instanceID
+	if !t.queue.push(entry) {
+		t.metrics.decisionsDropped.Inc()
+	}
+}
+
+// writeDecisions signs a batch of entries, if a signer is set, and sends
+// it to every sink, the store, the subscribers and the recent decisions the
+// admin API serves. Entries are only kept once signed, so the API returns
+// the same records the sinks receive.
+func (t *Telemetry) writeDecisions(batch []DecisionLog) {
+	if signer := t.signer.Load(); signer != nil {
+		for i := range batch {
+			if err := signer.sign(&batch[i]); err != nil {
+				logger.Error("Failed to sign decision log entry", "error", err)
+			}
+		}
+	}
+	for _, sink := range t.sinks {
+		if err := writeBatch(sink.Sink, batch); err != nil {
+			sink.health.failed(err)
+			t.metrics.sinkError(sink.typ, err)
+		} else if !sink.background() {
+			sink.health.succeeded()
+		}
+	}
+	store := t.store.Load()
+	for _, entry := range batch {
+		t.recent.add(entry)
+		if store != nil {
+			if err := store.Write(entry); err != nil {
+				store.health.failed(err)
+				t.metrics.sinkError(sinkDecisionStore, err)
+			}
+		}
+		t.subscribers.publish(entry)
+	}
+}
+
+// withRequestID adds the request.id attribute when ctx carries a request ID
+func withRequestID(ctx context.Context, attrs []attribute.KeyValue) []attribute.KeyValue {
+	if id := RequestID(ctx); id != "" {
+		attrs = append(attrs, attribute.String("request.id", id))
+	}
+	return attrs
+}
+
+// Close shuts telemetry down like Shutdown, giving the exporters
+// DefaultSinkTimeout to flush
 func (t *Telemetry) Close() error {
-	return t.logFile.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultSinkTimeout)
+	defer cancel()
+	return t.Shutdown(ctx)
 }
 
+// Shutdown drains the decision log queue into the sinks and the store,
+// closes them, flushes the batched spans and OTLP metrics and closes the
+// audit logs, in that order. If ctx ends before the queue is drained, the
+// sinks are left open for the entries still being written. Only the first
+// call does anything; later ones return its result.
+func (t *Telemetry) Shutdown(ctx context.Context) error {
+	t.shutdownOnce.Do(func() {
+		t.shutdownErr = t.shutdown(ctx)
+	})
+	return t.shutdownErr
+}
+
+func (t *Telemetry) shutdown(ctx context.Context) error {
+	var errs []error
+	// Queued entries are written before the sinks close
+	if err := t.queue.close(ctx); err != nil {
+		logger.Error("Decision log queue not drained", "queued", t.queue.depth(), "error", err)
+		errs = append(errs, fmt.Errorf("failed to drain decision log queue: %w", err))
+	} else {
+		if store := t.store.Swap(nil); store != nil {
+			errs = append(errs, store.Close())
+		}
+		for _, sink := range t.sinks {
+			if err := sink.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if t.tracerProvider != nil {
+		if err := t.tracerProvider.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush spans: %w", err))
+		}
+	}
+	if t.metrics.otlp != nil {
+		errs = append(errs, t.metrics.otlp.shutdown(ctx))
+	}
+	if t.adminLog != nil {
+		t.adminLog.Close()
+	}
+	if t.emailLog != nil {
+		t.emailLog.Close()
+	}
+	return errors.Join(errs...)
+}
@@ -30,10 +30,9 @@ Write-Host ""
 # Test 3: Allowed HR file read inside /hr-docs/
 Write-Host "Test 3: Allowed HR file read inside /hr-docs/"
 Write-Host "----------------------------------------------"
-Invoke-RestMethod -Uri "http://localhost:8082/write" `
-  -Method Post `
-  -Headers @{"Content-Type"="application/json"} `
-  -Body '{"path":"/hr-docs/employee1.txt","content":"Employee data"}'
+# The files tool serves ./data/files, so create the file there directly
+New-Item -ItemType Directory -Force -Path "data/files/hr-docs" | Out-Null
+Set-Content -Path "data/files/hr-docs/employee1.txt" -Value "Employee data"
 Write-Host "Now reading via gateway..."
 Invoke-RestMethod -Uri "$GATEWAY_URL/tools/files/read" `
   -Method Post `
@@ -44,10 +43,8 @@ Write-Host ""
 # Test 4: Blocked HR file read outside /hr-docs/
 Write-Host "Test 4: Blocked HR file read outside /hr-docs/"
 Write-Host "-----------------------------------------------"
-Invoke-RestMethod -Uri "http://localhost:8082/write" `
-  -Method Post `
-  -Headers @{"Content-Type"="application/json"} `
-  -Body '{"path":"/legal/contract.docx","content":"Legal document"}'
+New-Item -ItemType Directory -Force -Path "data/files/legal" | Out-Null
+Set-Content -Path "data/files/legal/contract.docx" -Value "Legal document"
 Write-Host "Now trying to read via gateway (should be blocked)..."
 try {
   Invoke-RestMethod -Uri "$GATEWAY_URL/tools/files/read" `
@@ -37,12 +37,9 @@ echo ""
 # Test 3: Allowed HR file read inside /hr-docs/
 echo "Test 3: Allowed HR file read inside /hr-docs/"
 echo "----------------------------------------------"
-# First, write a file to the files service directly (or use gateway if write is allowed)
-# For demo, we'll assume the file exists or create it via direct service call
-curl -s -H "Content-Type: application/json" \
-  -X POST "http://localhost:8082/write" \
-  -d '{"path":"/hr-docs/employee1.txt","content":"Employee data"}'
-echo ""
+# The files tool serves ./data/files, so create the file there directly
+mkdir -p data/files/hr-docs
+echo "Employee data" > data/files/hr-docs/employee1.txt
 echo "Now reading via gateway..."
 curl -s -H "X-Agent-ID: hr-agent" \
   -H "Content-Type: application/json" \
@@ -55,10 +52,8 @@ echo ""
 echo "Test 4: Blocked HR file read outside /hr-docs/"
 echo "-----------------------------------------------"
 # First create a file outside the allowed prefix
-curl -s -H "Content-Type: application/json" \
-  -X POST "http://localhost:8082/write" \
-  -d '{"path":"/legal/contract.docx","content":"Legal document"}'
-echo ""
+mkdir -p data/files/legal
+echo "Legal document" > data/files/legal/contract.docx
 echo "Now trying to read via gateway (should be blocked)..."
 curl -s -H "X-Agent-ID: hr-agent" \
   -H "Content-Type: application/json" \